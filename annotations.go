@@ -0,0 +1,135 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/uber/go-torch/renderer"
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
+)
+
+// parseAnnotations parses notes for functions from a file with a line for
+// each function:
+//
+//	main.parse = known issue JIRA-123
+//	github.com/uber/foo.(*Cache).Get = fixed in v1.4
+//
+// Lines starting with ; or # are comments.
+func parseAnnotations(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	notes := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%v:%v: expected <function> = <note>, got %q", file, lineNum, line)
+		}
+		fn, note := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if existing, ok := notes[fn]; ok {
+			// Keep every note for a function, rather than only the last.
+			note = existing + "\n" + note
+		}
+		notes[fn] = note
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// finishFlameGraph colors the frames for --colors cb-safe, and adds the self
+// samples of functions in the result's profile for --show-self, the notes
+// from the annotations file, the functions changed in --git-range and the
+// functions not found by --check-source, and the source snippets of
+// --source-root, to the svg generated by the flame graph script, and
+// converts it to the output format. The file, the git range and the source are read for each flame
+// graph, so they can change in watch mode.
+func finishFlameGraph(svg []byte, opts outputOptions, result *torch.Result) ([]byte, error) {
+	if opts.Colors == colorBlindSafe && (result == nil || !renderer.IsDiffFlameInput(result.FlameInput)) {
+		svg = renderer.ColorFlameGraph(svg, renderer.ColorBlindSafeColors)
+	}
+	if opts.ShowSelf {
+		profile, err := resultProfile(result)
+		if err != nil {
+			return nil, err
+		}
+		svg = renderer.NoteFlameGraph(svg, selfNotes(profile, result.SampleIndex, opts.messages()))
+	}
+	notes := make(map[string]string)
+	if opts.Annotations != "" {
+		var err error
+		if notes, err = parseAnnotations(opts.Annotations); err != nil {
+			return nil, fmt.Errorf("could not read annotations: %v", err)
+		}
+	}
+	if opts.GitRange != "" {
+		changed, err := gitChangedFuncs(".", opts.GitRange)
+		if err != nil {
+			return nil, fmt.Errorf("could not find functions changed in %v: %v", opts.GitRange, err)
+		}
+		profile, err := resultProfile(result)
+		if err != nil {
+			return nil, err
+		}
+		var funcs []string
+		for _, t := range stack.Top(profile, result.SampleIndex) {
+			funcs = append(funcs, t.Func)
+		}
+		for fn, note := range changed.notes(funcs, "changed in "+opts.GitRange) {
+			if existing, ok := notes[fn]; ok {
+				note = existing + "\n" + note
+			}
+			notes[fn] = note
+		}
+	}
+	for fn, note := range opts.staleNotes {
+		if existing, ok := notes[fn]; ok {
+			note = existing + "\n" + note
+		}
+		notes[fn] = note
+	}
+	if len(notes) > 0 {
+		svg = renderer.AnnotateFlameGraph(svg, notes)
+	}
+	if opts.SourceRoot != "" {
+		snippets, err := sourceSnippets(opts.SourceRoot, result)
+		if err != nil {
+			return nil, fmt.Errorf("could not read source snippets from %v: %v", opts.SourceRoot, err)
+		}
+		svg = renderer.NoteFlameGraph(svg, snippets)
+	}
+	return convertFlameGraph(svg, opts.OutFormat)
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uber/go-torch/torch"
+)
+
+func TestParseAnnotations(t *testing.T) {
+	file := writeTempFile(t, `
+# known issues
+main.fib = known issue JIRA-123
+main.fib = recursion is expected
+; fixed
+github.com/uber/foo.(*Cache).Get = fixed in v1.4, a == b
+`)
+	defer os.Remove(file)
+
+	notes, err := parseAnnotations(file)
+	if err != nil {
+		t.Fatalf("parseAnnotations failed: %v", err)
+	}
+	want := map[string]string{
+		"main.fib":                         "known issue JIRA-123\nrecursion is expected",
+		"github.com/uber/foo.(*Cache).Get": "fixed in v1.4, a == b",
+	}
+	if !reflect.DeepEqual(notes, want) {
+		t.Errorf("parseAnnotations got %q, want %q", notes, want)
+	}
+}
+
+func TestParseAnnotationsErrors(t *testing.T) {
+	if _, err := parseAnnotations("/dev/zero/invalid/file"); err == nil {
+		t.Errorf("parseAnnotations of missing file expected to fail")
+	}
+
+	for _, contents := range []string{"main.fib", "= note"} {
+		file := writeTempFile(t, contents)
+		defer os.Remove(file)
+		_, err := parseAnnotations(file)
+		if err == nil || !strings.Contains(err.Error(), ":1: expected <function> = <note>") {
+			t.Errorf("parseAnnotations(%q) got unexpected error: %v", contents, err)
+		}
+	}
+}
+
+func TestFinishFlameGraph(t *testing.T) {
+	file := writeTempFile(t, "main.fib = hot\n")
+	defer os.Remove(file)
+
+	svg := []byte(`<svg width="10" height="10"><title>main.fib (1 samples, 100%)</title><rect x="0" y="0" width="10" height="10" /></svg>`)
+	opts := getDefaultOptions().OutputOpts
+	opts.Annotations = file
+	out, err := finishFlameGraph(svg, opts, nil)
+	if err != nil {
+		t.Fatalf("finishFlameGraph failed: %v", err)
+	}
+	if !strings.Contains(string(out), "\nhot</title>") {
+		t.Errorf("finishFlameGraph did not add note, got %s", out)
+	}
+
+	opts.Annotations = "/dev/zero/invalid/file"
+	if _, err := finishFlameGraph(svg, opts, nil); err == nil || !strings.Contains(err.Error(), "could not read annotations") {
+		t.Errorf("finishFlameGraph with missing annotations got unexpected error: %v", err)
+	}
+}
+
+func TestFinishFlameGraphColorBlindSafe(t *testing.T) {
+	svg := []byte(`<svg width="10" height="10"><title>main.fib (1 samples, 100%)</title><rect x="0" y="0" width="10" height="10" fill="rgb(230,100,40)" /></svg>`)
+	opts := getDefaultOptions().OutputOpts
+	opts.Colors = colorBlindSafe
+
+	tests := []struct {
+		flameInput string
+		recolored  bool
+	}{
+		{flameInput: "main.fib 1\n", recolored: true},
+		{flameInput: "main.fib 1 2\n", recolored: false},
+	}
+	for _, tt := range tests {
+		out, err := finishFlameGraph(svg, opts, &torch.Result{FlameInput: []byte(tt.flameInput)})
+		if err != nil {
+			t.Fatalf("finishFlameGraph failed: %v", err)
+		}
+		if recolored := !strings.Contains(string(out), "rgb(230,100,40)"); recolored != tt.recolored {
+			t.Errorf("finishFlameGraph for %q recolored = %v, want %v: %s", tt.flameInput, recolored, tt.recolored, out)
+		}
+	}
+}
+
+func TestFinishFlameGraphShowSelf(t *testing.T) {
+	svg := []byte(`<svg width="10" height="10"><title>main.fib (4 samples, 100%)</title><rect x="0" y="0" width="10" height="10" /></svg>`)
+	opts := getDefaultOptions().OutputOpts
+	opts.ShowSelf = true
+	result := &torch.Result{FlameInput: []byte("main.fib 1\nmain.fib;main.fib 2\nmain.fib;runtime.mallocgc 1\n")}
+	out, err := finishFlameGraph(svg, opts, result)
+	if err != nil {
+		t.Fatalf("finishFlameGraph failed: %v", err)
+	}
+	if !strings.Contains(string(out), "\nself: 3 (75.00%)</title>") {
+		t.Errorf("finishFlameGraph did not add self samples, got %s", out)
+	}
+
+	result.FlameInput = []byte("bad input")
+	if _, err := finishFlameGraph(svg, opts, result); err == nil {
+		t.Errorf("finishFlameGraph with bad flame graph input expected to fail")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/uber/go-torch/renderer"
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torchlog"
+
+	gflags "github.com/jessevdk/go-flags"
+)
+
+// defaultBaselineDir is the directory, relative to the home directory, that
+// baselines are stored in if --baseline-dir is not specified.
+const defaultBaselineDir = ".go-torch/baselines"
+
+var serviceNameRE = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// baselineOptions are the options for the baseline command.
+type baselineOptions struct {
+	Service   string  `long:"service" description:"Name of the service that the baseline is for"`
+	Dir       string  `long:"baseline-dir" description:"Directory that baselines are stored in (default: ~/.go-torch/baselines)"`
+	Threshold float64 `long:"threshold" default:"1" description:"Minimum change, in percent of samples, for a function to be reported as drift"`
+}
+
+// addBaselineCommand adds the baseline save and compare commands to parser.
+func addBaselineCommand(parser *gflags.Parser, opts *baselineOptions) error {
+	cmd, err := parser.AddCommand("baseline", "Save or compare against a baseline profile for a service",
+		"Save a profile as the baseline for a service, or compare a profile against the saved baseline and report functions that drifted.", opts)
+	if err != nil {
+		return err
+	}
+	if _, err := cmd.AddCommand("save", "Save the profile as the baseline for the service", "", &struct{}{}); err != nil {
+		return err
+	}
+	_, err = cmd.AddCommand("compare", "Report functions that drifted from the baseline for the service", "", &struct{}{})
+	return err
+}
+
+// file returns the path of the baseline for the service.
+func (opts *baselineOptions) file() string {
+	dir := opts.Dir
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), defaultBaselineDir)
+	}
+	return filepath.Join(dir, opts.Service+".folded")
+}
+
+func validateBaselineOptions(opts *baselineOptions) error {
+	if opts.Service == "" {
+		return fmt.Errorf("--service is required for baseline commands")
+	}
+	if !serviceNameRE.MatchString(opts.Service) {
+		return fmt.Errorf("invalid service name %q", opts.Service)
+	}
+	if opts.Threshold < 0 || opts.Threshold > 100 {
+		return fmt.Errorf("threshold must be between 0 and 100")
+	}
+	return nil
+}
+
+// runBaseline captures a profile, and either saves it as the baseline for
+// the service, or compares it against the saved baseline.
+func runBaseline(allOpts *options, command string, remaining []string, w io.Writer) error {
+	opts := allOpts.baseline
+	if err := validateBaselineOptions(opts); err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+	if allOpts.PProfOptions.BaseURL2 != "" {
+		return fmt.Errorf("invalid options: --base-url2 cannot be used with baseline commands")
+	}
+	file := opts.file()
+
+	var baseline []byte
+	if command == "compare" {
+		// Read the baseline before capturing a profile, to fail early.
+		var err error
+		if baseline, err = ioutil.ReadFile(file); err != nil {
+			return fmt.Errorf("could not read baseline for %v: %v", opts.Service, err)
+		}
+	}
+
+	ctx, cancel := newContext(allOpts.Timeout)
+	defer cancel()
+
+	// Baselines are stored as flame graph input, so the flame graph is not rendered.
+	rawOpts := *allOpts
+	rawOpts.OutputOpts.Raw = true
+	result, _, err := generate(ctx, &rawOpts, remaining)
+	if err != nil {
+		return err
+	}
+	flameInput := result.FlameInput
+
+	if command == "save" {
+		torchlog.Printf("Saving baseline for %v to %v", opts.Service, file)
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			return fmt.Errorf("could not create baseline directory: %v", err)
+		}
+		if err := ioutil.WriteFile(file, flameInput, 0666); err != nil {
+			return fmt.Errorf("could not write baseline: %v", err)
+		}
+		return nil
+	}
+
+	baseProfile, err := renderer.ParseFlameInput(baseline)
+	if err != nil {
+		return fmt.Errorf("could not parse baseline %v: %v", file, err)
+	}
+	currentProfile, err := renderer.ParseFlameInput(flameInput)
+	if err != nil {
+		return fmt.Errorf("could not parse profile: %v", err)
+	}
+
+	drift := stack.Drift(baseProfile, currentProfile, 0, opts.Threshold/100)
+	return writeDriftReport(w, opts, drift)
+}
+
+// writeDriftReport writes a line for each function that drifted.
+func writeDriftReport(w io.Writer, opts *baselineOptions, drift []stack.FuncDrift) error {
+	if len(drift) == 0 {
+		_, err := fmt.Fprintf(w, "No functions in %v drifted by %v%% or more of samples from the baseline\n",
+			opts.Service, opts.Threshold)
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "%v functions in %v drifted by %v%% or more of samples from the baseline:\n",
+		len(drift), opts.Service, opts.Threshold); err != nil {
+		return err
+	}
+	for _, d := range drift {
+		if _, err := fmt.Fprintf(w, "  %+6.1f%%  %v (%.1f%% -> %.1f%%)\n",
+			d.Change()*100, d.Func, d.Base*100, d.Current*100); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/uber/go-torch/stack"
+)
+
+func TestBaselineSaveCompare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-baselines")
+	if err != nil {
+		t.Fatalf("Failed to create baseline dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = runWithArgs("baseline", "save", "--service", "test-service", "--baseline-dir", dir,
+		"--binaryinput", testPProfInputFile)
+	if err != nil {
+		t.Fatalf("baseline save failed: %v", err)
+	}
+	baseline, err := ioutil.ReadFile(filepath.Join(dir, "test-service.folded"))
+	if err != nil {
+		t.Fatalf("Failed to read saved baseline: %v", err)
+	}
+	if !strings.Contains(string(baseline), "main.fib") {
+		t.Errorf("Baseline is missing stacks, got:\n%s", baseline)
+	}
+
+	opts, parser, remaining, err := parseArgs([]string{"baseline", "compare", "--service", "test-service",
+		"--baseline-dir", dir, "--binaryinput", testPProfInputFile}, "", "")
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	if parser.Active == nil || parser.Active.Active == nil || parser.Active.Active.Name != "compare" {
+		t.Fatalf("Expected baseline compare command to be active")
+	}
+
+	var buf bytes.Buffer
+	if err := runBaseline(opts, "compare", remaining, &buf); err != nil {
+		t.Fatalf("baseline compare failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "No functions in test-service drifted") {
+		t.Errorf("Expected no drift against the same profile, got:\n%s", buf.String())
+	}
+}
+
+func TestBaselineErrors(t *testing.T) {
+	tests := []struct {
+		args   []string
+		errMsg string
+	}{
+		{
+			args:   []string{"baseline", "save"},
+			errMsg: "--service is required",
+		},
+		{
+			args:   []string{"baseline", "save", "--service", "../escape"},
+			errMsg: "invalid service name",
+		},
+		{
+			args:   []string{"baseline", "compare", "--service", "test", "--threshold", "101"},
+			errMsg: "threshold must be between 0 and 100",
+		},
+		{
+			args:   []string{"baseline", "save", "--service", "test", "--base-url2", "http://production:8080"},
+			errMsg: "--base-url2 cannot be used with baseline commands",
+		},
+		{
+			args:   []string{"baseline", "compare", "--service", "missing", "--baseline-dir", "/dev/zero/invalid"},
+			errMsg: "could not read baseline for missing",
+		},
+	}
+
+	for _, tt := range tests {
+		err := runWithArgs(tt.args...)
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("runWithArgs(%v) got error %v, want %q", tt.args, err, tt.errMsg)
+		}
+	}
+}
+
+func TestWriteDriftReport(t *testing.T) {
+	opts := &baselineOptions{Service: "foo", Threshold: 5}
+	drift := []stack.FuncDrift{
+		{Func: "main.fib", Base: 0.1, Current: 0.25},
+		{Func: "main.old", Base: 0.3, Current: 0},
+	}
+
+	var buf bytes.Buffer
+	if err := writeDriftReport(&buf, opts, drift); err != nil {
+		t.Fatalf("writeDriftReport failed: %v", err)
+	}
+
+	expected := "2 functions in foo drifted by 5% or more of samples from the baseline:\n" +
+		"   +15.0%  main.fib (10.0% -> 25.0%)\n" +
+		"   -30.0%  main.old (30.0% -> 0.0%)\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected report, got:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+}
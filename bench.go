@@ -0,0 +1,124 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/uber/go-torch/torchlog"
+
+	gflags "github.com/jessevdk/go-flags"
+)
+
+// benchOptions are the options for the bench command.
+type benchOptions struct {
+	Bench     string `long:"bench" default:"." description:"Regexp of the benchmarks to run, as go test -bench"`
+	Benchtime string `long:"benchtime" description:"Run each benchmark for this long or this many iterations, as go test -benchtime"`
+	Args      struct {
+		Package string `positional-arg-name:"package" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+// addBenchCommand adds the bench command to parser.
+func addBenchCommand(parser *gflags.Parser, opts *benchOptions) error {
+	_, err := parser.AddCommand("bench", "Profile the benchmarks of a package",
+		"Run the benchmarks of a package using go test while profiling them, and render the profile with the test binary. Arguments after -- are passed to go test.", opts)
+	return err
+}
+
+// runBench runs the benchmarks of the package with a profile, and renders
+// the profile. remaining are extra arguments for go test.
+func runBench(allOpts *options, remaining []string) error {
+	pprofOpts := allOpts.PProfOptions
+	if pprofOpts.BinaryFile != "" || pprofOpts.Merge || pprofOpts.BaseURL2 != "" || allOpts.Watch > 0 {
+		return fmt.Errorf("invalid options: the bench command cannot be used with --binaryinput, --merge, --base-url2 or --watch")
+	}
+	if allOpts.FoldedInput != "" || allOpts.PerfInput != "" || allOpts.HeapInput != "" || allOpts.DotInput != "" || allOpts.TracebackInput != "" {
+		return fmt.Errorf("invalid options: the bench command cannot be used with --folded-input, --perf-input, --heap-input, --dot-input or --traceback-input")
+	}
+	profileFlag, err := benchProfileFlag(pprofOpts.Heap, pprofOpts.Block, pprofOpts.Mutex, pprofOpts.Goroutine)
+	if err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "go-torch-bench")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	benchOpts := allOpts.bench
+	profile := filepath.Join(dir, "bench.prof")
+	binary := filepath.Join(dir, "bench.test")
+	args := []string{"test", "-run", "^$", "-bench", benchOpts.Bench, profileFlag, profile, "-o", binary}
+	if benchOpts.Benchtime != "" {
+		args = append(args, "-benchtime", benchOpts.Benchtime)
+	}
+	args = append(args, remaining...)
+	args = append(args, benchOpts.Args.Package)
+
+	goBinary := pprofOpts.GoBinary
+	if goBinary == "" {
+		goBinary = "go"
+	}
+	ctx, cancel := newContext(allOpts.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, goBinary, args...)
+	// The benchmark results are written to stderr, as stdout may be used
+	// for the output.
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	torchlog.Printf("Run benchmarks: %v %v", goBinary, strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("benchmarks failed: %v", err)
+	}
+	if _, err := os.Stat(profile); err != nil {
+		return fmt.Errorf("benchmarks did not write a profile, check that --bench matches a benchmark: %v", err)
+	}
+
+	runOpts := *allOpts
+	runOpts.PProfOptions.BinaryFile = profile
+	if _, err := os.Stat(binary); err == nil {
+		runOpts.PProfOptions.BinaryName = binary
+	}
+	return runWithOptions(&runOpts, nil)
+}
+
+// benchProfileFlag returns the go test flag that writes the profile of the
+// selected profile type, which is the CPU profile by default.
+func benchProfileFlag(heap, block, mutex, goroutine bool) (string, error) {
+	switch {
+	case goroutine:
+		return "", fmt.Errorf("the bench command cannot profile goroutines")
+	case heap:
+		return "-memprofile", nil
+	case block:
+		return "-blockprofile", nil
+	case mutex:
+		return "-mutexprofile", nil
+	}
+	return "-cpuprofile", nil
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFakeGo writes a go script to dir that records the go test arguments
+// to argsFile and writes the test profile to the profile flag, and runs
+// the real go for other commands, such as go tool pprof.
+func writeFakeGo(t *testing.T, dir, argsFile string) string {
+	realGo, err := exec.LookPath("go")
+	if err != nil {
+		t.Skipf("go is not installed: %v", err)
+	}
+	profile, err := filepath.Abs(testPProfInputFile)
+	if err != nil {
+		t.Fatalf("Abs failed: %v", err)
+	}
+
+	script := `#!/bin/sh
+if [ "$1" != "test" ]; then
+  exec ` + realGo + ` "$@"
+fi
+echo "$@" > ` + argsFile + `
+while [ $# -gt 0 ]; do
+  case "$1" in
+  -cpuprofile|-memprofile) cp ` + profile + ` "$2"; shift ;;
+  esac
+  shift
+done
+echo "BenchmarkFib-8   1000000   1234 ns/op"
+`
+	fakeGo := filepath.Join(dir, "go")
+	if err := ioutil.WriteFile(fakeGo, []byte(script), 0777); err != nil {
+		t.Fatalf("Failed to write fake go: %v", err)
+	}
+	return fakeGo
+}
+
+func TestBench(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-bench-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	argsFile := filepath.Join(dir, "args")
+	fakeGo := writeFakeGo(t, dir, argsFile)
+	rawFile := filepath.Join(dir, "bench.folded")
+
+	err = runWithArgs("bench", "--go-binary", fakeGo, "--bench", "BenchmarkFib", "--benchtime", "2s",
+		"--raw-file", rawFile, "./fib", "--", "-benchmem")
+	if err != nil {
+		t.Fatalf("bench failed: %v", err)
+	}
+
+	args, err := ioutil.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("go test was not run: %v", err)
+	}
+	for _, want := range []string{"test -run ^$ -bench BenchmarkFib -cpuprofile ", " -benchtime 2s -benchmem ./fib"} {
+		if !strings.Contains(string(args), want) {
+			t.Errorf("go test args %q do not contain %q", args, want)
+		}
+	}
+	if raw, err := ioutil.ReadFile(rawFile); err != nil || len(raw) == 0 {
+		t.Errorf("bench did not write the raw output: %v", err)
+	}
+}
+
+func TestBenchNoProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-bench-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	fakeGo := writeFakeGo(t, dir, filepath.Join(dir, "args"))
+
+	// The fake go does not write block profiles, as when no benchmark runs.
+	err = runWithArgs("bench", "--go-binary", fakeGo, "--block", "--raw", "./fib")
+	if err == nil || !strings.Contains(err.Error(), "did not write a profile") {
+		t.Errorf("bench error = %v, want missing profile", err)
+	}
+}
+
+func TestBenchProfileFlag(t *testing.T) {
+	tests := []struct {
+		heap, block, mutex bool
+		want               string
+	}{
+		{want: "-cpuprofile"},
+		{heap: true, want: "-memprofile"},
+		{block: true, want: "-blockprofile"},
+		{mutex: true, want: "-mutexprofile"},
+	}
+	for _, tt := range tests {
+		got, err := benchProfileFlag(tt.heap, tt.block, tt.mutex, false)
+		if err != nil || got != tt.want {
+			t.Errorf("benchProfileFlag(%v, %v, %v) = %v, %v, want %v", tt.heap, tt.block, tt.mutex, got, err, tt.want)
+		}
+	}
+	if _, err := benchProfileFlag(false, false, false, true); err == nil {
+		t.Errorf("benchProfileFlag should fail for goroutine profiles")
+	}
+}
+
+func TestBenchInvalidOptions(t *testing.T) {
+	tests := [][]string{
+		{"bench", "--goroutine", "./fib"},
+		{"bench", "--binaryinput", "cpu.prof", "./fib"},
+		{"bench", "--perf-input", "perf.data", "./fib"},
+	}
+	for _, args := range tests {
+		err := runWithArgs(args...)
+		if err == nil || !strings.Contains(err.Error(), "invalid options") {
+			t.Errorf("runWithArgs(%v) error = %v, want invalid options", args, err)
+		}
+	}
+}
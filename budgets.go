@@ -0,0 +1,185 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// budgetFuncs is the number of functions listed under each package that
+// exceeded its budget.
+const budgetFuncs = 3
+
+// packageBudget is the maximum percent of samples for a package, or for a
+// package and its subpackages if Package ends in "/...".
+type packageBudget struct {
+	Package string
+	Percent float64
+}
+
+// budgetViolation is a package that exceeded its budget, with the functions
+// in the package that are in the most samples.
+type budgetViolation struct {
+	Budget  packageBudget
+	Percent float64
+	Funcs   []stack.FuncTotal
+}
+
+// parseBudgets parses the package budgets from a YAML file that maps each
+// package to its max percent of samples:
+//
+//	github.com/uber/foo/cache: 20
+//	"github.com/uber/foo/rpc/...": 35%
+//
+// The file is parsed like the config file, but only a flat mapping is
+// supported.
+func parseBudgets(file string) ([]packageBudget, error) {
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseConfig(input)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+
+	var budgets []packageBudget
+	seen := make(map[string]bool)
+	for _, v := range values {
+		if len(v.section) > 0 {
+			return nil, fmt.Errorf("%v: line %v: nested values are not supported, got %v under %v", file, v.line, v.name, strings.Join(v.section, "."))
+		}
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(v.value, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("%v: line %v: budget %q for %v must be between 0 and 100", file, v.line, v.value, v.name)
+		}
+		if seen[v.name] {
+			return nil, fmt.Errorf("%v: line %v: duplicate budget for %v", file, v.line, v.name)
+		}
+		seen[v.name] = true
+		budgets = append(budgets, packageBudget{Package: v.name, Percent: percent})
+	}
+	if len(budgets) == 0 {
+		return nil, fmt.Errorf("%v: no budgets found", file)
+	}
+	return budgets, nil
+}
+
+// matches returns whether the function fn is covered by the budget.
+func (b packageBudget) matches(fn string) bool {
+	pkg := stack.FuncPackage(fn)
+	if prefix := strings.TrimSuffix(b.Package, "/..."); prefix != b.Package {
+		return pkg == prefix || strings.HasPrefix(pkg, prefix+"/")
+	}
+	return pkg == b.Package
+}
+
+// checkBudgets returns the packages that are in more than their budget's
+// percent of samples, highest percent first. A sample counts against a budget
+// once, however many of the budget's functions are in its stack.
+func checkBudgets(profile *stack.Profile, sampleIdx int, budgets []packageBudget) []budgetViolation {
+	var total int64
+	for _, s := range profile.Samples {
+		total += s.Counts[sampleIdx]
+	}
+	if total == 0 {
+		return nil
+	}
+
+	top := stack.Top(profile, sampleIdx)
+	var violations []budgetViolation
+	for _, budget := range budgets {
+		var count int64
+		for _, s := range profile.Samples {
+			for _, fn := range s.Funcs {
+				if budget.matches(fn) {
+					count += s.Counts[sampleIdx]
+					break
+				}
+			}
+		}
+		if percent := percent(count, total); percent > budget.Percent {
+			violations = append(violations, budgetViolation{
+				Budget:  budget,
+				Percent: percent,
+				Funcs:   budgetTop(top, budget),
+			})
+		}
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Percent > violations[j].Percent
+	})
+	return violations
+}
+
+// budgetTop returns the functions covered by budget that are in the most
+// samples, up to budgetFuncs.
+func budgetTop(top []stack.FuncTotal, budget packageBudget) []stack.FuncTotal {
+	var funcs []stack.FuncTotal
+	for _, t := range top {
+		if budget.matches(t.Func) {
+			funcs = append(funcs, t)
+		}
+	}
+	sort.SliceStable(funcs, func(i, j int) bool {
+		return funcs[i].Cum > funcs[j].Cum
+	})
+	if len(funcs) > budgetFuncs {
+		funcs = funcs[:budgetFuncs]
+	}
+	return funcs
+}
+
+// writeBudgetReport writes a line for each package that exceeded its budget,
+// followed by the functions in the package that are in the most samples.
+func writeBudgetReport(w io.Writer, profile *stack.Profile, sampleIdx int, violations []budgetViolation) error {
+	sampleName := profile.SampleNames[sampleIdx]
+	if len(violations) == 0 {
+		_, err := fmt.Fprintf(w, "No packages exceeded their budget of %v\n", sampleName)
+		return err
+	}
+
+	var total int64
+	for _, s := range profile.Samples {
+		total += s.Counts[sampleIdx]
+	}
+	if _, err := fmt.Fprintf(w, "%v packages exceeded their budget of %v:\n", len(violations), sampleName); err != nil {
+		return err
+	}
+	for _, v := range violations {
+		if _, err := fmt.Fprintf(w, "  %6.2f%%  %v (budget %v%%)\n", v.Percent, v.Budget.Package, v.Budget.Percent); err != nil {
+			return err
+		}
+		for _, t := range v.Funcs {
+			if _, err := fmt.Fprintf(w, "             %6.2f%%  %v\n", percent(t.Cum, total), t.Func); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uber/go-torch/stack"
+)
+
+func TestParseBudgets(t *testing.T) {
+	file := writeTempFile(t, `---
+# per-team budgets
+github.com/uber/foo/cache: 20 # owned by cache-team
+"github.com/uber/foo/rpc/...": 35%
+'main': 12.5
+`)
+	defer os.Remove(file)
+
+	budgets, err := parseBudgets(file)
+	if err != nil {
+		t.Fatalf("parseBudgets failed: %v", err)
+	}
+	want := []packageBudget{
+		{Package: "github.com/uber/foo/cache", Percent: 20},
+		{Package: "github.com/uber/foo/rpc/...", Percent: 35},
+		{Package: "main", Percent: 12.5},
+	}
+	if !reflect.DeepEqual(budgets, want) {
+		t.Errorf("parseBudgets got %+v, want %+v", budgets, want)
+	}
+}
+
+func TestParseBudgetsErrors(t *testing.T) {
+	if _, err := parseBudgets("/dev/zero/invalid/file"); err == nil {
+		t.Errorf("parseBudgets of missing file expected to fail")
+	}
+
+	tests := []struct {
+		contents string
+		errMsg   string
+	}{
+		{"main", `line 1: expected name: value, got "main"`},
+		{": 10", `line 1: expected name: value, got ": 10"`},
+		{"main: lots", `line 1: budget "lots" for main must be between 0 and 100`},
+		{"main: 101", `line 1: budget "101" for main must be between 0 and 100`},
+		{"main: 10\nmain: 20", "line 2: duplicate budget for main"},
+		{"teams:\n  main: 10", "line 2: nested values are not supported, got main under teams"},
+		{"main: 10\n  cache: 10", "line 2: unexpected indentation"},
+		{"# nothing\n", "no budgets found"},
+	}
+	for _, tt := range tests {
+		file := writeTempFile(t, tt.contents)
+		defer os.Remove(file)
+		_, err := parseBudgets(file)
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("parseBudgets(%q) got error %v, want %q", tt.contents, err, tt.errMsg)
+		}
+	}
+}
+
+func TestCheckBudgets(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main.main", "github.com/uber/foo/cache.Get", "github.com/uber/foo/cache.hash"}, Counts: []int64{6}},
+			{Funcs: []string{"main.main", "github.com/uber/foo/rpc/tchannel.Call"}, Counts: []int64{3}},
+			{Funcs: []string{"main.main", "github.com/uber/foo/rpc.Dial"}, Counts: []int64{1}},
+		},
+	}
+	budgets := []packageBudget{
+		{Package: "main", Percent: 100},
+		{Package: "github.com/uber/foo/rpc/...", Percent: 30},
+		{Package: "github.com/uber/foo/cache", Percent: 50},
+		{Package: "github.com/uber/foo", Percent: 0},
+	}
+
+	violations := checkBudgets(profile, 0, budgets)
+	if len(violations) != 2 {
+		t.Fatalf("checkBudgets got %+v, want 2 violations", violations)
+	}
+	if v := violations[0]; v.Budget.Package != "github.com/uber/foo/cache" || v.Percent != 60 || len(v.Funcs) != 2 {
+		t.Errorf("Unexpected violation %+v", v)
+	}
+	if v := violations[1]; v.Budget.Package != "github.com/uber/foo/rpc/..." || v.Percent != 40 || len(v.Funcs) != 2 {
+		t.Errorf("Unexpected violation %+v", v)
+	}
+
+	if violations := checkBudgets(&stack.Profile{SampleNames: profile.SampleNames}, 0, budgets); len(violations) != 0 {
+		t.Errorf("checkBudgets of empty profile got %v", violations)
+	}
+}
+
+func TestWriteBudgetReport(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main.main", "main.fib"}, Counts: []int64{3}},
+			{Funcs: []string{"main.main"}, Counts: []int64{1}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeBudgetReport(&buf, profile, 0, nil); err != nil {
+		t.Fatalf("writeBudgetReport failed: %v", err)
+	}
+	if want := "No packages exceeded their budget of samples/count\n"; buf.String() != want {
+		t.Errorf("Unexpected report, got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	violations := checkBudgets(profile, 0, []packageBudget{{Package: "main", Percent: 50}})
+	if err := writeBudgetReport(&buf, profile, 0, violations); err != nil {
+		t.Fatalf("writeBudgetReport failed: %v", err)
+	}
+	want := "1 packages exceeded their budget of samples/count:\n" +
+		"  100.00%  main (budget 50%)\n" +
+		"             100.00%  main.main\n" +
+		"              75.00%  main.fib\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected report, got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestRunCheckBudgets(t *testing.T) {
+	file := writeTempFile(t, "main: 100\n")
+	defer os.Remove(file)
+	opts, _, remaining, err := parseArgs([]string{"check", "--budgets", file, "--binaryinput", testPProfInputFile}, "", "")
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := runCheck(opts, remaining, &buf); err != nil {
+		t.Errorf("check within budgets failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "No packages exceeded") {
+		t.Errorf("Unexpected report, got:\n%s", buf.String())
+	}
+
+	overBudget := writeTempFile(t, "main: 1\n")
+	defer os.Remove(overBudget)
+	err = runWithArgs("check", "--budgets", overBudget, "--max-percent", `^main\.fib$=1`, "--binaryinput", testPProfInputFile)
+	want := "1 functions exceeded their max percent of samples, and 1 packages exceeded their budget"
+	if err == nil || err.Error() != want {
+		t.Errorf("check over budget got error %v, want %q", err, want)
+	}
+
+	err = runWithArgs("check", "--budgets", "/dev/zero/invalid/file", "--binaryinput", testPProfInputFile)
+	if err == nil || !strings.Contains(err.Error(), "could not read budgets") {
+		t.Errorf("check with missing budgets got unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/uber/go-torch/torch"
+	"github.com/uber/go-torch/torchlog"
+)
+
+const (
+	// calibrationProbe is how long the probe profile of --target-samples is
+	// captured for.
+	calibrationProbe = 2 * time.Second
+
+	// maxCalibratedSeconds limits the duration chosen by --target-samples,
+	// so a mostly idle target is not profiled indefinitely.
+	maxCalibratedSeconds = 600
+)
+
+// calibrateDuration captures a short probe profile to estimate how many
+// samples the target records per second, and returns options that profile
+// for long enough to record opts.TargetSamples samples.
+func calibrateDuration(ctx context.Context, opts *options, remaining []string) (*options, error) {
+	probeOpts := *opts
+	probeOpts.PProfOptions.TimeSeconds = int(calibrationProbe / time.Second)
+	probeOpts.PProfOptions.TimeAlias = nil
+	probeOpts.ExcludeFirst = 0
+
+	torchlog.Printf("Capturing a %v probe profile to choose a duration for %v samples", calibrationProbe, opts.TargetSamples)
+	result, err := generateResult(ctx, &probeOpts, remaining)
+	if err != nil {
+		return nil, fmt.Errorf("could not capture probe profile: %v", err)
+	}
+
+	seconds := calibratedSeconds(result, opts.TargetSamples)
+	torchlog.Printf("Profiling for %vs to record about %v samples", seconds, opts.TargetSamples)
+
+	calibrated := *opts
+	calibrated.PProfOptions.TimeSeconds = seconds
+	calibrated.PProfOptions.TimeAlias = nil
+	return &calibrated, nil
+}
+
+// calibratedSeconds returns the number of seconds to profile for to record
+// target samples, at the rate of the probe result. If the probe has no
+// samples, the target is idle and the maximum duration is used.
+func calibratedSeconds(probe *torch.Result, target int64) int {
+	duration := probe.Profile.Duration
+	if duration <= 0 {
+		duration = calibrationProbe
+	}
+	samples := sampleTotal(probe, 0)
+	if samples <= 0 {
+		torchlog.Printf("Warning: the probe profile has no samples, profiling for the maximum of %vs", maxCalibratedSeconds)
+		return maxCalibratedSeconds
+	}
+
+	rate := float64(samples) / duration.Seconds()
+	seconds := int(math.Ceil(float64(target) / rate))
+	if seconds < 1 {
+		return 1
+	}
+	if seconds > maxCalibratedSeconds {
+		torchlog.Printf("Warning: %v samples would take %vs at %.0f samples/s, profiling for the maximum of %vs",
+			target, seconds, rate, maxCalibratedSeconds)
+		return maxCalibratedSeconds
+	}
+	return seconds
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
+)
+
+func probeResult(duration time.Duration, counts ...int64) *torch.Result {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count", "cpu/nanoseconds"},
+		Duration:    duration,
+	}
+	for _, count := range counts {
+		profile.Samples = append(profile.Samples, stack.NewSample([]string{"main"}, []int64{count, count * 1e7}))
+	}
+	return &torch.Result{Profile: profile}
+}
+
+func TestCalibratedSeconds(t *testing.T) {
+	tests := []struct {
+		msg    string
+		probe  *torch.Result
+		target int64
+		want   int
+	}{
+		{"100 samples/s", probeResult(2*time.Second, 150, 50), 5000, 50},
+		{"rounded up", probeResult(2*time.Second, 200), 5050, 51},
+		{"probe duration if unknown", probeResult(0, 400), 5000, 25},
+		{"at least 1s", probeResult(2*time.Second, 20000), 5000, 1},
+		{"at most the maximum", probeResult(2*time.Second, 2), 5000, maxCalibratedSeconds},
+		{"idle target", probeResult(2 * time.Second), 5000, maxCalibratedSeconds},
+	}
+
+	for _, tt := range tests {
+		if got := calibratedSeconds(tt.probe, tt.target); got != tt.want {
+			t.Errorf("%v: calibratedSeconds = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestCalibrateDuration(t *testing.T) {
+	profile, err := ioutil.ReadFile(testPProfInputFile)
+	if err != nil {
+		t.Fatalf("Failed to read test profile: %v", err)
+	}
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Query().Get("seconds"))
+		w.Write(profile)
+	}))
+	defer server.Close()
+
+	opts := getDefaultOptions()
+	opts.PProfOptions.BinaryFile = ""
+	opts.PProfOptions.BaseURL = server.URL
+	opts.TargetSamples = 5000
+	opts.ExcludeFirst = time.Second
+
+	calibrated, err := calibrateDuration(context.Background(), opts, nil)
+	if err != nil {
+		t.Fatalf("calibrateDuration failed: %v", err)
+	}
+	if len(requested) != 1 || requested[0] != "2" {
+		t.Errorf("Probe should fetch a single 2s profile without --exclude-first, got seconds %v", requested)
+	}
+	if calibrated.PProfOptions.TimeSeconds < 1 || calibrated.PProfOptions.TimeSeconds > maxCalibratedSeconds {
+		t.Errorf("Calibrated duration %vs is out of range", calibrated.PProfOptions.TimeSeconds)
+	}
+	if calibrated.ExcludeFirst != time.Second || opts.PProfOptions.TimeSeconds != 30 {
+		t.Errorf("calibrateDuration should only change the duration of a copy of the options")
+	}
+}
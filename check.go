@@ -0,0 +1,191 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+
+	gflags "github.com/jessevdk/go-flags"
+)
+
+// checkOptions are the options for the check command.
+type checkOptions struct {
+	MaxPercent []string `long:"max-percent" description:"Fail if a function matching a regexp is in more than a percent of samples, as regexp=percent (e.g. ^main\\.parse$=20); can be repeated"`
+	Budgets    string   `long:"budgets" description:"YAML file of the max percent of samples for each package (e.g. github.com/uber/foo/cache: 20), to fail if a package exceeds its budget"`
+}
+
+// funcLimit is the maximum percent of samples for functions matching Func.
+type funcLimit struct {
+	Func    *regexp.Regexp
+	Percent float64
+}
+
+// limitViolation is a function that exceeded a limit.
+type limitViolation struct {
+	Func    string
+	Percent float64
+	Limit   funcLimit
+}
+
+// addCheckCommand adds the check command to parser.
+func addCheckCommand(parser *gflags.Parser, opts *checkOptions) error {
+	_, err := parser.AddCommand("check", "Fail if functions or packages use more than a percent of samples",
+		"Capture a profile, and exit with an error if any function matching a --max-percent regexp is in more than that percent of samples, or any package is in more than its --budgets percent, e.g. to fail a CI build on performance regressions.", opts)
+	return err
+}
+
+// parseLimits parses the --max-percent limits, which are a regexp and a
+// percent separated by the last "=", as the regexp may contain "=".
+func parseLimits(maxPercent []string) ([]funcLimit, error) {
+	var limits []funcLimit
+	for _, limit := range maxPercent {
+		idx := strings.LastIndex(limit, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("max percent %q must be in the form regexp=percent", limit)
+		}
+		re, err := regexp.Compile(limit[:idx])
+		if err != nil {
+			return nil, fmt.Errorf("invalid max percent regexp: %v", err)
+		}
+		percent, err := strconv.ParseFloat(limit[idx+1:], 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("max percent %q must be between 0 and 100", limit[idx+1:])
+		}
+		limits = append(limits, funcLimit{Func: re, Percent: percent})
+	}
+	return limits, nil
+}
+
+// runCheck captures a profile, and returns an error if any function exceeds
+// its limit. The functions that exceeded their limits are written to w.
+func runCheck(allOpts *options, remaining []string, w io.Writer) error {
+	checkOpts := allOpts.check
+	if len(checkOpts.MaxPercent) == 0 && checkOpts.Budgets == "" {
+		return fmt.Errorf("invalid options: --max-percent or --budgets is required for the check command")
+	}
+	limits, err := parseLimits(checkOpts.MaxPercent)
+	if err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+	var budgets []packageBudget
+	if checkOpts.Budgets != "" {
+		if budgets, err = parseBudgets(checkOpts.Budgets); err != nil {
+			return fmt.Errorf("could not read budgets: %v", err)
+		}
+	}
+	if allOpts.PProfOptions.BaseURL2 != "" {
+		return fmt.Errorf("invalid options: --base-url2 cannot be used with the check command")
+	}
+
+	ctx, cancel := newContext(allOpts.Timeout)
+	defer cancel()
+
+	// Only the samples are checked, so the flame graph is not rendered.
+	rawOpts := *allOpts
+	rawOpts.OutputOpts.Raw = true
+	result, _, err := generate(ctx, &rawOpts, remaining)
+	if err != nil {
+		return err
+	}
+	profile, err := resultProfile(result)
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	if len(limits) > 0 {
+		violations := checkLimits(profile, result.SampleIndex, limits)
+		if err := writeCheckReport(w, profile.SampleNames[result.SampleIndex], violations); err != nil {
+			return err
+		}
+		if len(violations) > 0 {
+			failures = append(failures, fmt.Sprintf("%v functions exceeded their max percent of samples", len(violations)))
+		}
+	}
+	if len(budgets) > 0 {
+		violations := checkBudgets(profile, result.SampleIndex, budgets)
+		if err := writeBudgetReport(w, profile, result.SampleIndex, violations); err != nil {
+			return err
+		}
+		if len(violations) > 0 {
+			failures = append(failures, fmt.Sprintf("%v packages exceeded their budget", len(violations)))
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, ", and "))
+	}
+	return nil
+}
+
+// checkLimits returns the functions that are in more than their limit's
+// percent of samples, highest percent first. A function that matches several
+// limits is reported for the first limit that it exceeds.
+func checkLimits(profile *stack.Profile, sampleIdx int, limits []funcLimit) []limitViolation {
+	var total int64
+	for _, s := range profile.Samples {
+		total += s.Counts[sampleIdx]
+	}
+	if total == 0 {
+		return nil
+	}
+
+	var violations []limitViolation
+	for _, t := range stack.Top(profile, sampleIdx) {
+		percent := percent(t.Cum, total)
+		for _, limit := range limits {
+			if percent > limit.Percent && limit.Func.MatchString(t.Func) {
+				violations = append(violations, limitViolation{Func: t.Func, Percent: percent, Limit: limit})
+				break
+			}
+		}
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Percent > violations[j].Percent
+	})
+	return violations
+}
+
+// writeCheckReport writes a line for each function that exceeded its limit.
+func writeCheckReport(w io.Writer, sampleName string, violations []limitViolation) error {
+	if len(violations) == 0 {
+		_, err := fmt.Fprintf(w, "No functions exceeded their max percent of %v\n", sampleName)
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "%v functions exceeded their max percent of %v:\n", len(violations), sampleName); err != nil {
+		return err
+	}
+	for _, v := range violations {
+		if _, err := fmt.Fprintf(w, "  %6.2f%%  %v (max %v%% for %v)\n",
+			v.Percent, v.Func, v.Limit.Percent, v.Limit.Func); err != nil {
+			return err
+		}
+	}
+	return nil
+}
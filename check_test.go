@@ -0,0 +1,163 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/uber/go-torch/stack"
+)
+
+func TestParseLimits(t *testing.T) {
+	limits, err := parseLimits([]string{`^main\.fib$=20`, `a=b=12.5`})
+	if err != nil {
+		t.Fatalf("parseLimits failed: %v", err)
+	}
+	if len(limits) != 2 {
+		t.Fatalf("parseLimits got %v limits, want 2", len(limits))
+	}
+	if limits[0].Func.String() != `^main\.fib$` || limits[0].Percent != 20 {
+		t.Errorf("Unexpected first limit %v=%v", limits[0].Func, limits[0].Percent)
+	}
+	if limits[1].Func.String() != "a=b" || limits[1].Percent != 12.5 {
+		t.Errorf("Unexpected second limit %v=%v", limits[1].Func, limits[1].Percent)
+	}
+
+	tests := []struct {
+		maxPercent []string
+		errMsg     string
+	}{
+		{[]string{"main.fib"}, "must be in the form regexp=percent"},
+		{[]string{"=10"}, "must be in the form regexp=percent"},
+		{[]string{"(=10"}, "invalid max percent regexp"},
+		{[]string{"main=101"}, "must be between 0 and 100"},
+		{[]string{"main=lots"}, "must be between 0 and 100"},
+	}
+	for _, tt := range tests {
+		_, err := parseLimits(tt.maxPercent)
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("parseLimits(%v) got error %v, want %q", tt.maxPercent, err, tt.errMsg)
+		}
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main", "a"}, Counts: []int64{6}},
+			{Funcs: []string{"main", "b", "a"}, Counts: []int64{3}},
+			{Funcs: []string{"main", "c"}, Counts: []int64{1}},
+		},
+	}
+	limits := []funcLimit{
+		{Func: regexp.MustCompile("^a$"), Percent: 50},
+		{Func: regexp.MustCompile("^[abc]$"), Percent: 20},
+	}
+
+	violations := checkLimits(profile, 0, limits)
+	if len(violations) != 2 {
+		t.Fatalf("checkLimits got %v, want 2 violations", violations)
+	}
+	if v := violations[0]; v.Func != "a" || v.Percent != 90 || v.Limit.Percent != 50 {
+		t.Errorf("Unexpected violation %+v", v)
+	}
+	if v := violations[1]; v.Func != "b" || v.Percent != 30 || v.Limit.Percent != 20 {
+		t.Errorf("Unexpected violation %+v", v)
+	}
+
+	if violations := checkLimits(&stack.Profile{SampleNames: profile.SampleNames}, 0, limits); len(violations) != 0 {
+		t.Errorf("checkLimits of empty profile got %v", violations)
+	}
+}
+
+func TestWriteCheckReport(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeCheckReport(&buf, "samples/count", nil); err != nil {
+		t.Fatalf("writeCheckReport failed: %v", err)
+	}
+	if want := "No functions exceeded their max percent of samples/count\n"; buf.String() != want {
+		t.Errorf("Unexpected report, got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	violations := []limitViolation{
+		{Func: "main.fib", Percent: 45.5, Limit: funcLimit{Func: regexp.MustCompile(`^main\.`), Percent: 30}},
+	}
+	if err := writeCheckReport(&buf, "samples/count", violations); err != nil {
+		t.Fatalf("writeCheckReport failed: %v", err)
+	}
+	want := "1 functions exceeded their max percent of samples/count:\n" +
+		"   45.50%  main.fib (max 30% for ^main\\.)\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected report, got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestRunCheck(t *testing.T) {
+	opts, _, remaining, err := parseArgs([]string{"check", "--max-percent", `^main\.fib$=100`,
+		"--binaryinput", testPProfInputFile}, "", "")
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := runCheck(opts, remaining, &buf); err != nil {
+		t.Errorf("check within limits failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "No functions exceeded") {
+		t.Errorf("Unexpected report, got:\n%s", buf.String())
+	}
+
+	err = runWithArgs("check", "--max-percent", `^main\.fib$=1`, "--binaryinput", testPProfInputFile)
+	if err == nil || !strings.Contains(err.Error(), "1 functions exceeded their max percent of samples") {
+		t.Errorf("check over limit got unexpected error: %v", err)
+	}
+}
+
+func TestRunCheckErrors(t *testing.T) {
+	tests := []struct {
+		args   []string
+		errMsg string
+	}{
+		{
+			args:   []string{"check", "--binaryinput", testPProfInputFile},
+			errMsg: "--max-percent or --budgets is required",
+		},
+		{
+			args:   []string{"check", "--max-percent", "main=10", "--base-url2", "http://production:8080"},
+			errMsg: "--base-url2 cannot be used with the check command",
+		},
+		{
+			args:   []string{"check", "--max-percent", "main=10", "--folded-input", "/dev/zero/invalid/file"},
+			errMsg: "could not read folded input",
+		},
+	}
+
+	for _, tt := range tests {
+		err := runWithArgs(tt.args...)
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("runWithArgs(%v) got error %v, want %q", tt.args, err, tt.errMsg)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber/go-torch/torchlog"
+
+	gflags "github.com/jessevdk/go-flags"
+)
+
+// The collect protocol: profiles are sent as the body of a POST to
+// collectPath, with optional headers naming the source and format. If the
+// callback or async header is set, the profile is rendered in the
+// background, and its job is served under collectJobsPath.
+const (
+	collectPath           = "/collect"
+	collectJobsPath       = "/jobs/"
+	collectSourceHeader   = "X-Torch-Source"
+	collectFormatHeader   = "X-Torch-Format"
+	collectCallbackHeader = "X-Torch-Callback"
+	collectAsyncHeader    = "X-Torch-Async"
+
+	// maxCollectSize is the largest profile that is accepted.
+	maxCollectSize = 64 << 20
+
+	// maxCollectJobs is the number of jobs that are kept to be queried.
+	maxCollectJobs = 1000
+
+	// callbackTimeout bounds the POST of a finished job to its callback.
+	callbackTimeout = 30 * time.Second
+)
+
+// The statuses of a collect job.
+const (
+	jobPending = "pending"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// collectOptions are the options for the collect command.
+type collectOptions struct {
+	Listen string `long:"listen" default:"localhost:9090" description:"Address to receive profiles on"`
+	Dir    string `long:"dir" default:"profiles" description:"Directory to store received profiles and their flame graphs in, under a directory for each source"`
+}
+
+// collector receives profiles over HTTP, and stores and renders them.
+type collector struct {
+	opts   *options
+	now    func() time.Time
+	client *http.Client
+
+	// mu serializes rendering, so a burst of profiles does not run many
+	// pprof and flame graph processes at once.
+	mu sync.Mutex
+
+	jobsMu sync.Mutex
+	// jobs are the background jobs by ID, and jobIDs are their IDs, oldest
+	// first.
+	jobs   map[string]*collectJob
+	jobIDs []string
+}
+
+// collectJob is a profile that is rendered in the background. It is sent as
+// JSON to the callback URL when it finishes, and served under
+// collectJobsPath.
+type collectJob struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	// Status is pending, done or failed.
+	Status string `json:"status"`
+	// File is the output file, and Output is the URL that serves it, once
+	// the job is done.
+	File   string `json:"file,omitempty"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	callback string
+}
+
+func newCollector(opts *options) *collector {
+	return &collector{
+		opts:   opts,
+		now:    time.Now,
+		client: &http.Client{Timeout: callbackTimeout},
+		jobs:   make(map[string]*collectJob),
+	}
+}
+
+// addCollectCommand adds the collect command to parser.
+func addCollectCommand(parser *gflags.Parser, opts *collectOptions) error {
+	_, err := parser.AddCommand("collect", "Receive profiles over HTTP and render them",
+		"Listen for profiles POSTed to "+collectPath+" by any language or profiler, as pprof, perf script output or collapsed stacks, and store each profile and its flame graph under --dir.", opts)
+	return err
+}
+
+// runCollect receives profiles until the server fails.
+func runCollect(allOpts *options) error {
+	opts := allOpts.OutputOpts
+	if opts.Print || opts.Raw || opts.RawFile != "" || opts.OutDir != "" || printsReport(opts) || allOpts.Watch > 0 {
+		return fmt.Errorf("invalid options: the collect command cannot be used with --print, --raw, --raw-file, --out-dir, --top, --cost-by or --watch")
+	}
+	if allOpts.PProfOptions.Merge || allOpts.PProfOptions.BaseURL2 != "" {
+		return fmt.Errorf("invalid options: the collect command cannot be used with --merge or --base-url2")
+	}
+
+	c := newCollector(allOpts)
+	mux := http.NewServeMux()
+	mux.Handle(collectPath, c)
+	mux.HandleFunc(collectJobsPath, c.serveJob)
+
+	addr := allOpts.collect.Listen
+	torchlog.Printf("Receiving profiles on http://%v%v, storing them in %v", addr, collectPath, allOpts.collect.Dir)
+	return http.ListenAndServe(addr, mux)
+}
+
+// ServeHTTP stores the profile in the request body, and renders it using the
+// collector's options. The path of the output file is returned, unless the
+// profile is rendered in the background, in which case its job is returned.
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "profiles must be sent using POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callback := r.Header.Get(collectCallbackHeader)
+	async := callback != "" || r.Header.Get(collectAsyncHeader) == "true"
+	if callback != "" {
+		if u, err := url.Parse(callback); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, fmt.Sprintf("%v must be an http or https URL", collectCallbackHeader), http.StatusBadRequest)
+			return
+		}
+	}
+
+	p, status, err := c.store(r)
+	if err == nil && !async {
+		status, err = c.render(p)
+	}
+	if err != nil {
+		torchlog.Printf("Failed to collect profile: %v", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if !async {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintln(w, p.opts.OutputOpts.File)
+		return
+	}
+
+	job, err := c.addJob(p.source, callback)
+	if err != nil {
+		torchlog.Printf("Failed to collect profile: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	outputURL := "http://" + r.Host + collectJobsPath + job.ID + "/output"
+	go c.runJob(job, p, outputURL)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", collectJobsPath+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// collectedProfile is a stored profile, and the options to render it.
+type collectedProfile struct {
+	source string
+	format string
+	opts   options
+}
+
+// store stores the profile in r, and returns the options to render it. If
+// it fails, the HTTP status to return is the status of the error.
+func (c *collector) store(r *http.Request) (collectedProfile, int, error) {
+	source := sourceDirName(r.Header.Get(collectSourceHeader))
+	dir := filepath.Join(c.opts.collect.Dir, source)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return collectedProfile{}, http.StatusInternalServerError, fmt.Errorf("could not create directory: %v", err)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxCollectSize+1))
+	if err != nil {
+		return collectedProfile{}, http.StatusBadRequest, fmt.Errorf("could not read profile: %v", err)
+	}
+	if len(body) == 0 {
+		return collectedProfile{}, http.StatusBadRequest, fmt.Errorf("profile is empty")
+	}
+	if len(body) > maxCollectSize {
+		return collectedProfile{}, http.StatusRequestEntityTooLarge, fmt.Errorf("profile is larger than %v bytes", maxCollectSize)
+	}
+
+	base, err := writeUniqueFile(filepath.Join(dir, c.now().Format(watchTimestampFormat)), ".profile", body)
+	if err != nil {
+		return collectedProfile{}, http.StatusInternalServerError, fmt.Errorf("could not store profile: %v", err)
+	}
+	rawFile := base + ".profile"
+
+	format := r.Header.Get(collectFormatHeader)
+	switch format {
+	case "pprof", "perf", "folded", "heap", "dot", "traceback":
+	case "":
+		if format, err = detectFormat(rawFile); err != nil {
+			return collectedProfile{}, http.StatusBadRequest, fmt.Errorf("could not detect profile format: %v", err)
+		}
+	default:
+		return collectedProfile{}, http.StatusBadRequest, fmt.Errorf("unknown profile format %q, must be pprof, perf, folded, heap, dot or traceback", format)
+	}
+
+	runOpts := *c.opts
+	runOpts.PProfOptions.BinaryFile = ""
+	runOpts.PerfInput = ""
+	runOpts.FoldedInput = ""
+	runOpts.HeapInput = ""
+	runOpts.DotInput = ""
+	runOpts.TracebackInput = ""
+	setInputFile(&runOpts, format, rawFile)
+	runOpts.OutputOpts.File = base + "." + outputExt(runOpts.OutputOpts.OutFormat)
+	return collectedProfile{source: source, format: format, opts: runOpts}, 0, nil
+}
+
+// render renders a stored profile to its output file. If it fails, the
+// HTTP status to return is the status of the error.
+func (c *collector) render(p collectedProfile) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	torchlog.Printf("Rendering %v profile from %v", p.format, p.source)
+	if err := runWithOptions(&p.opts, nil); err != nil {
+		return http.StatusUnprocessableEntity, fmt.Errorf("could not render profile: %v", err)
+	}
+	return 0, nil
+}
+
+// addJob adds a pending job for a profile from source. Once there are
+// maxCollectJobs jobs, the oldest is forgotten.
+func (c *collector) addJob(source, callback string) (*collectJob, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("could not create job ID: %v", err)
+	}
+	job := &collectJob{ID: hex.EncodeToString(id), Source: source, Status: jobPending, callback: callback}
+
+	c.jobsMu.Lock()
+	defer c.jobsMu.Unlock()
+	c.jobs[job.ID] = job
+	c.jobIDs = append(c.jobIDs, job.ID)
+	if len(c.jobIDs) > maxCollectJobs {
+		delete(c.jobs, c.jobIDs[0])
+		c.jobIDs = c.jobIDs[1:]
+	}
+	return job, nil
+}
+
+// runJob renders the profile of a job, and posts the finished job to its
+// callback URL, if it has one.
+func (c *collector) runJob(job *collectJob, p collectedProfile, outputURL string) {
+	_, err := c.render(p)
+
+	c.jobsMu.Lock()
+	if err != nil {
+		torchlog.Printf("Failed to render job %v: %v", job.ID, err)
+		job.Status, job.Error = jobFailed, err.Error()
+	} else {
+		job.Status, job.File, job.Output = jobDone, p.opts.OutputOpts.File, outputURL
+	}
+	finished := *job
+	c.jobsMu.Unlock()
+
+	if job.callback == "" {
+		return
+	}
+	if err := c.postCallback(finished); err != nil {
+		torchlog.Printf("Failed to post job %v to %v: %v", job.ID, job.callback, err)
+	}
+}
+
+// postCallback posts a finished job to its callback URL as JSON.
+func (c *collector) postCallback(job collectJob) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(job.callback, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned %v", resp.Status)
+	}
+	return nil
+}
+
+// serveJob serves a job as JSON at /jobs/<id>, and the output file of a
+// finished job at /jobs/<id>/output.
+func (c *collector) serveJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, collectJobsPath), "/")
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] != "output") {
+		http.NotFound(w, r)
+		return
+	}
+	c.jobsMu.Lock()
+	job, ok := c.jobs[parts[0]]
+	var current collectJob
+	if ok {
+		current = *job
+	}
+	c.jobsMu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(parts) == 1 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(current)
+		return
+	}
+	if current.Status != jobDone {
+		http.Error(w, fmt.Sprintf("job %v is %v", current.ID, current.Status), http.StatusConflict)
+		return
+	}
+	http.ServeFile(w, r, current.File)
+}
+
+// maxUniqueFiles is how many suffixes writeUniqueFile tries.
+const maxUniqueFiles = 1000
+
+// writeUniqueFile writes data to a new file named base+ext, or base-N+ext
+// if that exists, such as when profiles are sent in the same millisecond,
+// and returns the base name used. Files are created exclusively, so
+// concurrent requests never write to the same file.
+func writeUniqueFile(base, ext string, data []byte) (string, error) {
+	name := base
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(name+ext, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < maxUniqueFiles {
+			name = fmt.Sprintf("%v-%v", base, i)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return name, err
+	}
+}
+
+// sourceDirName returns the directory name used for a source, replacing
+// characters that are not safe in file names.
+func sourceDirName(source string) string {
+	name := unsafeFileChars.ReplaceAllString(source, "_")
+	if name == "" || name == "." || name == ".." {
+		return "unknown"
+	}
+	return name
+}
@@ -0,0 +1,354 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestCollector(t *testing.T, args ...string) (*collector, string) {
+	dir, err := ioutil.TempDir("", "go-torch-collect")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	opts, _, _, err := parseArgs(append([]string{"collect", "--dir", dir}, args...), "", "")
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	now := time.Date(2017, 10, 10, 10, 10, 10, 0, time.UTC)
+	c := newCollector(opts)
+	c.now = func() time.Time { return now }
+	return c, dir
+}
+
+func TestCollect(t *testing.T) {
+	c, dir := newTestCollector(t)
+	defer os.RemoveAll(dir)
+
+	req := httptest.NewRequest("POST", collectPath, strings.NewReader("main;work 10\nmain;idle 5\n"))
+	req.Header.Set(collectSourceHeader, "python/api")
+	w := httptest.NewRecorder()
+	withScriptsInPath(t, func() {
+		c.ServeHTTP(w, req)
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("collect got status %v: %s", w.Code, w.Body.String())
+	}
+
+	want := filepath.Join(dir, "python_api", "20171010-101010.000.svg")
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("collect returned %q, want %q", got, want)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("flame graph was not written: %v", err)
+	}
+	profile, err := ioutil.ReadFile(filepath.Join(dir, "python_api", "20171010-101010.000.profile"))
+	if err != nil || !strings.Contains(string(profile), "main;work 10") {
+		t.Errorf("profile was not stored, got %q, err %v", profile, err)
+	}
+}
+
+func TestCollectPProf(t *testing.T) {
+	c, dir := newTestCollector(t, "--out-format", "json")
+	defer os.RemoveAll(dir)
+
+	f, err := os.Open(testPProfInputFile)
+	if err != nil {
+		t.Fatalf("Failed to open test profile: %v", err)
+	}
+	defer f.Close()
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("POST", collectPath, f))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("collect got status %v: %s", w.Code, w.Body.String())
+	}
+	output, err := ioutil.ReadFile(filepath.Join(dir, "unknown", "20171010-101010.000.json"))
+	if err != nil || !strings.Contains(string(output), "main.fib") {
+		t.Errorf("json output missing main.fib, got %q, err %v", output, err)
+	}
+}
+
+func TestCollectSameTime(t *testing.T) {
+	c, dir := newTestCollector(t, "--out-format", "json")
+	defer os.RemoveAll(dir)
+
+	// The collector's clock is fixed, so every profile is sent at the same
+	// time.
+	const profiles = 5
+	var wg sync.WaitGroup
+	for i := 0; i < profiles; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf("main;work%v %v\n", i, i+1)
+			p, _, err := c.store(httptest.NewRequest("POST", collectPath, strings.NewReader(body)))
+			if err == nil {
+				_, err = c.render(p)
+			}
+			if err != nil {
+				t.Errorf("collect %v failed: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	files, err := filepath.Glob(filepath.Join(dir, "unknown", "20171010-101010.000*.profile"))
+	if err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+	if len(files) != profiles {
+		t.Errorf("got %v stored profiles, want %v: %v", len(files), profiles, files)
+	}
+	for _, file := range files {
+		if ts, ok := runTime(filepath.Base(file)); !ok || ts.Year() != 2017 {
+			t.Errorf("stored profile %v is not named by the time it was received", file)
+		}
+	}
+}
+
+func newCollectServer(c *collector) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle(collectPath, c)
+	mux.HandleFunc(collectJobsPath, c.serveJob)
+	return httptest.NewServer(mux)
+}
+
+func TestCollectCallback(t *testing.T) {
+	c, dir := newTestCollector(t, "--out-format", "json")
+	defer os.RemoveAll(dir)
+	server := newCollectServer(c)
+	defer server.Close()
+
+	jobs := make(chan collectJob, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var job collectJob
+		if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+			t.Errorf("callback got invalid job: %v", err)
+		}
+		jobs <- job
+	}))
+	defer callback.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+collectPath, strings.NewReader("main;work 10\n"))
+	req.Header.Set(collectSourceHeader, "python")
+	req.Header.Set(collectCallbackHeader, callback.URL)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("collect failed: %v", err)
+	}
+	var accepted collectJob
+	err = json.NewDecoder(resp.Body).Decode(&accepted)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("collect got status %v, err %v", resp.Status, err)
+	}
+	if accepted.ID == "" || accepted.Source != "python" || accepted.Status != jobPending {
+		t.Errorf("collect returned job %+v, want a pending job from python", accepted)
+	}
+
+	var job collectJob
+	select {
+	case job = <-jobs:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("callback was not called")
+	}
+	want := filepath.Join(dir, "python", "20171010-101010.000.json")
+	if job.ID != accepted.ID || job.Status != jobDone || job.File != want {
+		t.Errorf("callback got job %+v, want job %v done with file %v", job, accepted.ID, want)
+	}
+
+	resp, err = http.Get(job.Output)
+	if err != nil {
+		t.Fatalf("Get output failed: %v", err)
+	}
+	output, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || !strings.Contains(string(output), "work") {
+		t.Errorf("output got status %v: %q, err %v", resp.Status, output, err)
+	}
+
+	resp, err = http.Get(server.URL + collectJobsPath + job.ID)
+	if err != nil {
+		t.Fatalf("Get job failed: %v", err)
+	}
+	var got collectJob
+	err = json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if err != nil || got != job {
+		t.Errorf("job got %+v, err %v, want %+v", got, err, job)
+	}
+}
+
+func TestCollectAsyncFailed(t *testing.T) {
+	c, dir := newTestCollector(t)
+	defer os.RemoveAll(dir)
+	server := newCollectServer(c)
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+collectPath, strings.NewReader("not a profile\n"))
+	req.Header.Set(collectFormatHeader, "pprof")
+	req.Header.Set(collectAsyncHeader, "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("collect failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("collect got status %v, want %v", resp.Status, http.StatusAccepted)
+	}
+	jobURL := server.URL + resp.Header.Get("Location")
+
+	var job collectJob
+	for start := time.Now(); job.Status != jobFailed; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("job did not fail, got %+v", job)
+		}
+		resp, err := http.Get(jobURL)
+		if err != nil {
+			t.Fatalf("Get job failed: %v", err)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&job)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("job is invalid: %v", err)
+		}
+	}
+	if !strings.Contains(job.Error, "could not render profile") {
+		t.Errorf("job got error %q, want could not render profile", job.Error)
+	}
+
+	resp, err = http.Get(jobURL + "/output")
+	if err != nil {
+		t.Fatalf("Get output failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("output of failed job got status %v, want %v", resp.Status, http.StatusConflict)
+	}
+}
+
+func TestCollectJobs(t *testing.T) {
+	c, dir := newTestCollector(t)
+	defer os.RemoveAll(dir)
+
+	first, err := c.addJob("api", "")
+	if err != nil {
+		t.Fatalf("addJob failed: %v", err)
+	}
+	for i := 0; i < maxCollectJobs; i++ {
+		if _, err := c.addJob("api", ""); err != nil {
+			t.Fatalf("addJob failed: %v", err)
+		}
+	}
+	if len(c.jobs) != maxCollectJobs || c.jobs[first.ID] != nil {
+		t.Errorf("got %v jobs, want the oldest job to be forgotten and %v jobs", len(c.jobs), maxCollectJobs)
+	}
+
+	for _, path := range []string{first.ID, "unknown", c.jobIDs[0] + "/svg"} {
+		w := httptest.NewRecorder()
+		c.serveJob(w, httptest.NewRequest("GET", collectJobsPath+path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%v got status %v, want %v", path, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestCollectInvalidCallback(t *testing.T) {
+	c, dir := newTestCollector(t)
+	defer os.RemoveAll(dir)
+
+	for _, callback := range []string{"ftp://example.com/done", "example.com/done", "://"} {
+		req := httptest.NewRequest("POST", collectPath, strings.NewReader("main 1\n"))
+		req.Header.Set(collectCallbackHeader, callback)
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "must be an http or https URL") {
+			t.Errorf("callback %q got status %v: %s", callback, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	c, dir := newTestCollector(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		method     string
+		body       string
+		format     string
+		wantStatus int
+		wantErr    string
+	}{
+		{"GET", "", "", http.StatusMethodNotAllowed, "profiles must be sent using POST"},
+		{"POST", "", "", http.StatusBadRequest, "profile is empty"},
+		{"POST", "main 1\n", "jfr", http.StatusBadRequest, `unknown profile format "jfr"`},
+		{"POST", "not a profile\n", "pprof", http.StatusUnprocessableEntity, "could not render profile"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, collectPath, strings.NewReader(tt.body))
+		if tt.format != "" {
+			req.Header.Set(collectFormatHeader, tt.format)
+		}
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, req)
+		if w.Code != tt.wantStatus || !strings.Contains(w.Body.String(), tt.wantErr) {
+			t.Errorf("%v %q got status %v: %s, want %v: %v", tt.method, tt.body, w.Code, w.Body.String(), tt.wantStatus, tt.wantErr)
+		}
+	}
+}
+
+func TestRunCollectInvalidOptions(t *testing.T) {
+	for _, args := range [][]string{
+		{"collect", "--print"},
+		{"collect", "--top", "10"},
+		{"collect", "--merge"},
+	} {
+		err := runWithArgs(args...)
+		if err == nil || !strings.Contains(err.Error(), "the collect command cannot be used with") {
+			t.Errorf("runWithArgs(%v) got unexpected error: %v", args, err)
+		}
+	}
+}
+
+func TestSourceDirName(t *testing.T) {
+	tests := map[string]string{
+		"":             "unknown",
+		"..":           "unknown",
+		"api":          "api",
+		"python/api 2": "python_api_2",
+	}
+	for source, want := range tests {
+		if got := sourceDirName(source); got != want {
+			t.Errorf("sourceDirName(%q) = %q, want %q", source, got, want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"unicode/utf8"
+
+	gflags "github.com/jessevdk/go-flags"
+)
+
+// sniffSize is how much of a file convert reads to detect its format.
+const sniffSize = 4096
+
+var (
+	gzipMagic     = []byte{0x1f, 0x8b}
+	perfDataMagic = []byte("PERFILE2")
+	// heapTextHeader starts a text heap profile from /debug/pprof/heap?debug=1.
+	heapTextHeader = []byte("heap profile:")
+
+	// dotHeader starts a call graph exported by pprof -dot.
+	dotHeader = []byte("digraph")
+
+	// tracebackRE matches the start of a goroutine in a traceback, which may
+	// be anywhere in a log file.
+	tracebackRE = regexp.MustCompile(`(?m)^goroutine \d+ .*\[.*\]:$`)
+
+	// foldedLineRE matches a line of collapsed stacks, "func1;func2 <count>".
+	foldedLineRE = regexp.MustCompile(`^\S.* \d+$`)
+)
+
+// commandOptions are the arguments of the profile commands. Options that are
+// shared by all commands, such as the output options, are in options.
+type commandOptions struct {
+	Diff    diffArgs
+	Convert convertArgs
+	Dumps   dumpsArgs
+}
+
+type diffArgs struct {
+	Args struct {
+		Base    string `positional-arg-name:"base" required:"yes"`
+		Current string `positional-arg-name:"current" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+type convertArgs struct {
+	Args struct {
+		File string `positional-arg-name:"file" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+type dumpsArgs struct {
+	Blocked int `long:"blocked" default:"10" description:"Print the N most common stacks of blocked goroutines to stdout, or none if 0"`
+
+	Args struct {
+		Dir string `positional-arg-name:"dir" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+// addProfileCommands adds the commands that generate a flame graph. Running
+// go-torch without a command is the same as the cpu command.
+func addProfileCommands(parser *gflags.Parser, opts *commandOptions) error {
+	commands := []struct {
+		name, short, long string
+		data              interface{}
+	}{
+		{"cpu", "Profile CPU usage (the default)", "Profile CPU usage using /debug/pprof/profile for --seconds.", &struct{}{}},
+		{"heap", "Profile heap memory", "Profile heap memory using /debug/pprof/heap, the same as --heap.", &struct{}{}},
+		{"diff", "Generate a differential flame graph of two profile sources",
+			"Profile two base URLs (or read two saved profiles) at the same time, and color the flame graph of current by the difference from base.", &opts.Diff},
+		{"convert", "Render a saved profile without fetching one",
+			"Render a saved pprof profile, text heap profile, pprof -dot call graph, log with goroutine tracebacks, perf.data file, perf script output or collapsed stacks, detecting the format from the contents of the file.", &opts.Convert},
+		{"dumps", "Render a directory of goroutine dumps",
+			"Render the goroutine tracebacks in every file in a directory, such as dumps collected using SIGQUIT or from crash reporters, as one flame graph, and print the most common stacks of blocked goroutines.", &opts.Dumps},
+	}
+	for _, c := range commands {
+		if _, err := parser.AddCommand(c.name, c.short, c.long, c.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyCommand sets the options for the active profile command, so that the
+// command runs the same as the equivalent flags.
+func applyCommand(opts *options, command string, remaining []string) error {
+	pprofOpts := &opts.PProfOptions
+	switch command {
+	case "cpu":
+		if pprofOpts.Heap || pprofOpts.Block || pprofOpts.Mutex || pprofOpts.Goroutine {
+			return fmt.Errorf("the cpu command cannot be used with --heap, --block, --mutex or --goroutine")
+		}
+	case "heap":
+		pprofOpts.Heap = true
+	case "diff":
+		if pprofOpts.BaseURL2 != "" || len(remaining) > 0 {
+			return fmt.Errorf("the diff command only takes the base and current profile sources")
+		}
+		args := opts.commands.Diff.Args
+		*pprofOpts = pprofOpts.ForSource(args.Current)
+		pprofOpts.BaseURL2 = args.Base
+	case "convert":
+		if len(remaining) > 0 {
+			return fmt.Errorf("the convert command only takes the file to render")
+		}
+		file := opts.commands.Convert.Args.File
+		format, err := detectFormat(file)
+		if err != nil {
+			return fmt.Errorf("could not read %v: %v", file, err)
+		}
+		setInputFile(opts, format, file)
+	case "dumps":
+		if len(remaining) > 0 {
+			return fmt.Errorf("the dumps command only takes the directory of dumps")
+		}
+		dumps := opts.commands.Dumps
+		if dumps.Blocked < 0 {
+			return fmt.Errorf("--blocked must not be negative")
+		}
+		if dumps.Blocked > 0 && (opts.OutputOpts.Print || opts.OutputOpts.Raw) {
+			return fmt.Errorf("--blocked cannot be used with --print or --raw, which also write to stdout")
+		}
+		opts.TracebackInput = dumps.Args.Dir
+		opts.blocked = dumps.Blocked
+	}
+	return nil
+}
+
+// setInputFile sets the option that renders a saved profile file in the
+// given format, as returned by detectFormat.
+func setInputFile(opts *options, format, file string) {
+	switch format {
+	case "pprof":
+		opts.PProfOptions.BinaryFile = file
+	case "perf":
+		opts.PerfInput = file
+	case "folded":
+		opts.FoldedInput = file
+	case "heap":
+		opts.HeapInput = file
+	case "dot":
+		opts.DotInput = file
+	case "traceback":
+		opts.TracebackInput = file
+	}
+}
+
+// detectFormat returns the format of a saved profile: pprof for a pprof
+// protobuf, perf for a perf.data file or the output of perf script, heap
+// for a text heap profile, dot for a pprof -dot call graph, traceback for a
+// log with goroutine tracebacks, or folded for collapsed stacks.
+func detectFormat(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return "pprof", nil
+	case bytes.HasPrefix(head, perfDataMagic):
+		return "perf", nil
+	case isBinary(head):
+		// Uncompressed protobufs are binary.
+		return "pprof", nil
+	case tracebackRE.Match(head):
+		return "traceback", nil
+	}
+
+	for _, line := range bytes.Split(head, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if bytes.HasPrefix(line, heapTextHeader) {
+			return "heap", nil
+		}
+		if bytes.HasPrefix(line, dotHeader) {
+			return "dot", nil
+		}
+		if foldedLineRE.Match(line) {
+			return "folded", nil
+		}
+		return "perf", nil
+	}
+	return "", fmt.Errorf("file is empty")
+}
+
+// isBinary returns whether head has control characters, or is not UTF-8.
+// Only complete lines are checked, so a multi-byte character that was cut
+// off by sniffSize is not treated as binary.
+func isBinary(head []byte) bool {
+	if len(head) == sniffSize {
+		head = head[:bytes.LastIndexByte(head, '\n')+1]
+	}
+	for _, b := range head {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' {
+			return true
+		}
+	}
+	return !utf8.Valid(head)
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, contents string) string {
+	file := getTempFilename(t, "")
+	if err := ioutil.WriteFile(file, []byte(contents), 0666); err != nil {
+		t.Fatalf("Failed to write %v: %v", file, err)
+	}
+	return file
+}
+
+func TestDetectFormat(t *testing.T) {
+	folded := writeTempFile(t, "main.main;main.fib 3\nmain.main 1\n")
+	defer os.Remove(folded)
+	perfData := writeTempFile(t, "PERFILE2\x00\x01\x02")
+	defer os.Remove(perfData)
+	proto := writeTempFile(t, "\x0a\x0f\x08\x01\x12\x07samples")
+	defer os.Remove(proto)
+	empty := writeTempFile(t, "\n\n")
+	defer os.Remove(empty)
+
+	tests := []struct {
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{file: testPProfInputFile, want: "pprof"},
+		{file: proto, want: "pprof"},
+		{file: "./perf/testdata/perf.script.txt", want: "perf"},
+		{file: perfData, want: "perf"},
+		{file: folded, want: "folded"},
+		{file: testHeapTextFile, want: "heap"},
+		{file: testDotFile, want: "dot"},
+		{file: testTracebackFile, want: "traceback"},
+		{file: empty, wantErr: true},
+		{file: "/dev/zero/invalid/file", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := detectFormat(tt.file)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("detectFormat(%v) wantErr %v got error: %v", tt.file, tt.wantErr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("detectFormat(%v) got %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestApplyCommand(t *testing.T) {
+	opts, parser, remaining, err := parseArgs([]string{"heap", "-u", "http://localhost:1234"}, "", "")
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	if err := applyCommand(opts, parser.Active.Name, remaining); err != nil {
+		t.Fatalf("applyCommand failed: %v", err)
+	}
+	if !opts.PProfOptions.Heap {
+		t.Errorf("heap command should set --heap")
+	}
+
+	opts, parser, remaining, err = parseArgs([]string{"diff", "http://production:8080", "canary.pb.gz"}, "", "")
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	if err := applyCommand(opts, parser.Active.Name, remaining); err != nil {
+		t.Fatalf("applyCommand failed: %v", err)
+	}
+	if opts.PProfOptions.BaseURL2 != "http://production:8080" || opts.PProfOptions.BinaryFile != "canary.pb.gz" {
+		t.Errorf("diff command got base %q and binary %q", opts.PProfOptions.BaseURL2, opts.PProfOptions.BinaryFile)
+	}
+}
+
+func TestInvalidCommands(t *testing.T) {
+	tests := []struct {
+		args         []string
+		errorMessage string
+	}{
+		{
+			args:         []string{"cpu", "--heap"},
+			errorMessage: "the cpu command cannot be used with --heap, --block, --mutex or --goroutine",
+		},
+		{
+			args:         []string{"diff", "base.pb.gz"},
+			errorMessage: "could not parse options",
+		},
+		{
+			args:         []string{"diff", "base.pb.gz", "current.pb.gz", "--base-url2", "http://production:8080"},
+			errorMessage: "the diff command only takes the base and current profile sources",
+		},
+		{
+			args:         []string{"convert", testPProfInputFile, "extra"},
+			errorMessage: "the convert command only takes the file to render",
+		},
+		{
+			args:         []string{"convert", "/dev/zero/invalid/file"},
+			errorMessage: "could not read /dev/zero/invalid/file",
+		},
+	}
+
+	for _, tt := range tests {
+		err := runWithArgs(tt.args...)
+		if err == nil || !strings.Contains(err.Error(), tt.errorMessage) {
+			t.Errorf("runWithArgs(%v) expected error %q, got %v", tt.args, tt.errorMessage, err)
+		}
+	}
+}
+
+func TestRunCommands(t *testing.T) {
+	rawFile := getTempFilename(t, ".folded")
+	defer os.Remove(rawFile)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			args: []string{"convert", testPProfInputFile},
+			want: "main.fib",
+		},
+		{
+			args: []string{"convert", "./perf/testdata/perf.script.txt"},
+			want: "main.main;main.fib;runtime.mallocgc 2",
+		},
+		{
+			args: []string{"cpu", "--binaryinput", testPProfInputFile},
+			want: "main.fib",
+		},
+		{
+			// Differential flame graph input has the base and current counts.
+			args: []string{"diff", testPProfInputFile, testPProfInputFile},
+			want: "runtime.mach_semaphore_signal 12 12\n",
+		},
+	}
+
+	for _, tt := range tests {
+		args := append(tt.args, "--raw-file", rawFile)
+		if err := runWithArgs(args...); err != nil {
+			t.Errorf("runWithArgs(%v) failed: %v", args, err)
+			continue
+		}
+		out, err := ioutil.ReadFile(rawFile)
+		if err != nil {
+			t.Fatalf("Failed to read raw output file: %v", err)
+		}
+		if !strings.Contains(string(out), tt.want) {
+			t.Errorf("runWithArgs(%v) output is missing %q, got:\n%s", args, tt.want, out)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+
+	gflags "github.com/jessevdk/go-flags"
+)
+
+// defaultConfigFile is the config file, relative to the home directory, that
+// is applied if --config is not set.
+const defaultConfigFile = ".gotorch.yaml"
+
+// filterChainsKey is the key of the config file that defines named filter
+// chains, as a mapping of chain names to lists of filters.
+const filterChainsKey = "filter-chains"
+
+// configValue is a value set in a config file: the key it is set for, the
+// line it is on, and the keys of the mappings that it is nested in,
+// outermost first.
+type configValue struct {
+	name    string
+	value   string
+	line    int
+	section []string
+}
+
+// configBlock is a key of a config file whose value is the lines after it
+// that are indented more than it, or list items indented the same.
+type configBlock struct {
+	key    string
+	indent int
+	// childIndent is the indent of the lines in the block, or -1 before the
+	// first line, and list is whether they are list items.
+	childIndent int
+	list        bool
+}
+
+// configFile returns the config file to apply: the --config file if it is
+// set, or else ~/.gotorch.yaml if it exists.
+func configFile(opts *options) string {
+	if opts.Config != "" {
+		return opts.Config
+	}
+	file := filepath.Join(os.Getenv("HOME"), defaultConfigFile)
+	if _, err := os.Stat(file); err != nil {
+		return ""
+	}
+	return file
+}
+
+// applyConfig sets the options in the config file using parser, before the
+// command line is parsed, so options on the command line take precedence.
+// The filter chains defined in the config file are returned by name.
+func applyConfig(parser *gflags.Parser, file string) (map[string][]stack.Filter, error) {
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseConfig(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var ini bytes.Buffer
+	chains := make(map[string][]stack.Filter)
+	for _, v := range values {
+		if len(v.section) > 0 && v.section[0] == filterChainsKey {
+			if len(v.section) != 2 {
+				return nil, fmt.Errorf("line %v: each filter chain must be a list of filters, such as - hide: <regexp>", v.line)
+			}
+			filter, err := newChainFilter(v.name, v.value)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", v.line, err)
+			}
+			chains[v.section[1]] = append(chains[v.section[1]], filter)
+			continue
+		}
+		if len(v.section) > 0 {
+			return nil, fmt.Errorf("line %v: nested options are not supported, options must be set by their long name", v.line)
+		}
+
+		opt := parser.FindOptionByLongName(v.name)
+		if opt == nil || v.name == "config" {
+			return nil, fmt.Errorf("line %v: unknown option %q", v.line, v.name)
+		}
+		if !isBoolOption(opt) {
+			fmt.Fprintf(&ini, "%v = %v\n", v.name, strconv.Quote(v.value))
+			continue
+		}
+		enabled, err := strconv.ParseBool(v.value)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v must be true or false, got %q", v.line, v.name, v.value)
+		}
+		if enabled {
+			fmt.Fprintf(&ini, "%v =\n", v.name)
+		}
+	}
+
+	if err := gflags.NewIniParser(parser).Parse(&ini); err != nil {
+		return nil, err
+	}
+	return chains, nil
+}
+
+// isBoolOption returns whether opt is a flag without a value, such as --hash.
+func isBoolOption(opt *gflags.Option) bool {
+	_, ok := opt.Value().(bool)
+	return ok
+}
+
+// parseConfig parses the subset of YAML used by go-torch's config files,
+// such as ~/.gotorch.yaml and --budgets files, e.g.
+//
+//	# Defaults for the payments team.
+//	width: 1600
+//	colors: cb-safe
+//	hash: true
+//	ignore: ^runtime\.
+//	label:
+//	  - service=payments
+//	  - region=us-east
+//	filter-chains:
+//	  service-noise:
+//	    - hide: ^github.com/uber/service/middleware\.
+//	    - squash: ^runtime\.
+//
+// Mappings can be nested, and a key can be set to a list of values, or to a
+// list of single key mappings such as "- hide: <regexp>". The values are
+// returned in order, with each list item returned as a value of its key.
+// Callers decide which keys may be nested.
+func parseConfig(input []byte) ([]configValue, error) {
+	var values []configValue
+	// blocks are the keys whose blocks contain the line, outermost first,
+	// after the top level block.
+	blocks := []configBlock{{indent: -1}}
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := stripConfigComment(scanner.Text())
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		isItem := trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+
+		for len(blocks) > 1 {
+			top := blocks[len(blocks)-1]
+			if indent > top.indent || indent == top.indent && isItem && (top.childIndent < 0 || top.childIndent == indent) {
+				break
+			}
+			blocks = blocks[:len(blocks)-1]
+		}
+		block := &blocks[len(blocks)-1]
+		if block.childIndent < 0 {
+			block.childIndent, block.list = indent, isItem
+		}
+		switch {
+		case indent != block.childIndent:
+			return nil, fmt.Errorf("line %v: unexpected indentation", lineNum)
+		case isItem && !block.list:
+			return nil, fmt.Errorf("line %v: list item outside of a list", lineNum)
+		case !isItem && block.list:
+			return nil, fmt.Errorf("line %v: expected a list item, got %q", lineNum, trimmed)
+		}
+
+		section := blockKeys(blocks[1:])
+		content := trimmed
+		if isItem {
+			content = strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))
+		}
+		name, rawValue, isMapping, err := splitConfigKey(content)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineNum, err)
+		}
+		switch {
+		case isItem && !isMapping:
+			// A list item is a value of the list's key.
+			name, rawValue, section = block.key, content, blockKeys(blocks[1:len(blocks)-1])
+		case !isMapping || name == "":
+			return nil, fmt.Errorf("line %v: expected name: value, got %q", lineNum, trimmed)
+		case rawValue == "" && isItem:
+			return nil, fmt.Errorf("line %v: list items cannot contain nested values", lineNum)
+		case rawValue == "":
+			// The value follows as a block.
+			blocks = append(blocks, configBlock{key: name, indent: indent, childIndent: -1})
+			continue
+		}
+		value, err := parseConfigScalar(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineNum, err)
+		}
+		values = append(values, configValue{name, value, lineNum, section})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// blockKeys returns the keys of blocks, or nil if there are none.
+func blockKeys(blocks []configBlock) []string {
+	var keys []string
+	for _, b := range blocks {
+		keys = append(keys, b.key)
+	}
+	return keys
+}
+
+// splitConfigKey splits a "key: value" mapping into its key and its raw
+// value, or returns ok false if s is a scalar. The key may be quoted.
+func splitConfigKey(s string) (key, value string, ok bool, err error) {
+	start := 0
+	if s != "" && (s[0] == '"' || s[0] == '\'') {
+		if start = closingQuote(s); start < 0 {
+			return "", "", false, nil
+		}
+	}
+	for i := start; i < len(s); i++ {
+		if s[i] == ':' && (i+1 == len(s) || s[i+1] == ' ' || s[i+1] == '\t') {
+			key, err := parseConfigScalar(strings.TrimSpace(s[:i]))
+			return key, strings.TrimSpace(s[i+1:]), true, err
+		}
+	}
+	return "", "", false, nil
+}
+
+// closingQuote returns the index after the quote that closes the quoted
+// scalar at the start of s, or -1 if it is not closed.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[0] == '"' && s[i] == '\\':
+			i++
+		case s[i] == s[0] && s[0] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == s[0]:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// stripConfigComment removes a # comment that starts the line or follows
+// whitespace, as in YAML, so regexps can contain #.
+func stripConfigComment(line string) string {
+	inQuote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case inQuote != 0:
+			if c == inQuote {
+				inQuote = 0
+			}
+		case c == '"' || c == '\'':
+			inQuote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseConfigScalar returns the value of a plain, single quoted or double
+// quoted YAML scalar.
+func parseConfigScalar(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		value, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted value %v: %v", s, err)
+		}
+		return value, nil
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+	return s, nil
+}
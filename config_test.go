@@ -0,0 +1,220 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	input := strings.Join([]string{
+		"# Defaults for the payments team.",
+		"---",
+		"width: 1600 # wider than the default",
+		`ignore: ^runtime\.|#gc`,
+		`title: "Payments: CPU"`,
+		"subtitle: 'it''s live'",
+		"hash: true",
+		"label:",
+		"  - service=payments",
+		"  - 'region=us-east'",
+		"filter-chains:",
+		"  noise:",
+		"  - hide: ^runtime\\.goexit$",
+		"  - 'squash': '^runtime\\.'",
+		`"github.com/uber/foo/...": 35%`,
+	}, "\n")
+
+	values, err := parseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("parseConfig failed: %v", err)
+	}
+	expected := []configValue{
+		{"width", "1600", 3, nil},
+		{"ignore", `^runtime\.|#gc`, 4, nil},
+		{"title", "Payments: CPU", 5, nil},
+		{"subtitle", "it's live", 6, nil},
+		{"hash", "true", 7, nil},
+		{"label", "service=payments", 9, nil},
+		{"label", "region=us-east", 10, nil},
+		{"hide", `^runtime\.goexit$`, 13, []string{"filter-chains", "noise"}},
+		{"squash", `^runtime\.`, 14, []string{"filter-chains", "noise"}},
+		{"github.com/uber/foo/...", "35%", 15, nil},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("parseConfig got %v, want %v", values, expected)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{"- stray", "line 1: list item outside of a list"},
+		{"width: 1600\n  height: 800", "line 2: unexpected indentation"},
+		{"label:\n  - a\n  b: c", `line 3: expected a list item, got "b: c"`},
+		{"label:\n  - a\n   - b", "line 3: unexpected indentation"},
+		{"chains:\n  - hide:\n    x: y", "line 2: list items cannot contain nested values"},
+		{"width 1600", `line 1: expected name: value, got "width 1600"`},
+		{`title: "unterminated\"`, "line 1: invalid quoted value"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseConfig([]byte(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parseConfig(%q) got error %v, want %v", tt.input, err, tt.wantErr)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	config := getTempFilename(t, ".yaml")
+	defer os.Remove(config)
+	contents := strings.Join([]string{
+		"width: 1600",
+		"colors: cb-safe",
+		"hash: true",
+		"reverse: false",
+		`ignore: ^runtime\.`,
+		"label:",
+		"  - service=payments",
+		"  - region=us-east",
+		"seconds: 10",
+	}, "\n")
+	if err := ioutil.WriteFile(config, []byte(contents), 0666); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	opts, _, _, err := parseArgs([]string{"--width", "800", "--label", "handler=/pay"}, config, "")
+	if err != nil {
+		t.Fatalf("parseArgs with config failed: %v", err)
+	}
+	if opts.OutputOpts.Width != 800 {
+		t.Errorf("Command line width should override config, got %v", opts.OutputOpts.Width)
+	}
+	if opts.OutputOpts.Colors != "cb-safe" || !opts.OutputOpts.Hash || opts.OutputOpts.Reverse {
+		t.Errorf("Config output options were not applied, got %+v", opts.OutputOpts)
+	}
+	if opts.Ignore != `^runtime\.` || opts.PProfOptions.TimeSeconds != 10 {
+		t.Errorf("Config options were not applied, got ignore %q, seconds %v", opts.Ignore, opts.PProfOptions.TimeSeconds)
+	}
+	if want := []string{"handler=/pay"}; !reflect.DeepEqual(opts.Labels, want) {
+		t.Errorf("Command line labels should replace config labels, got %v, want %v", opts.Labels, want)
+	}
+
+	opts, _, _, err = parseArgs(nil, config, "")
+	if err != nil {
+		t.Fatalf("parseArgs with config failed: %v", err)
+	}
+	if want := []string{"service=payments", "region=us-east"}; !reflect.DeepEqual(opts.Labels, want) {
+		t.Errorf("Config labels got %v, want %v", opts.Labels, want)
+	}
+}
+
+func TestApplyConfigFilterChains(t *testing.T) {
+	config := getTempFilename(t, ".yaml")
+	defer os.Remove(config)
+	contents := strings.Join([]string{
+		"filters: noise",
+		"filter-chains:",
+		"  noise:",
+		"    - hide: ^runtime\\.goexit$",
+		"    - squash: ^runtime\\.",
+		"  short:",
+		"    - trim: main.",
+	}, "\n")
+	if err := ioutil.WriteFile(config, []byte(contents), 0666); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	opts, _, _, err := parseArgs([]string{"--filters", "noise,short"}, config, "")
+	if err != nil {
+		t.Fatalf("parseArgs with config failed: %v", err)
+	}
+	if len(opts.filterChains) != 2 || len(opts.filterChains["noise"]) != 2 || len(opts.filterChains["short"]) != 1 {
+		t.Errorf("Config filter chains got %v, want noise and short chains", opts.filterChains)
+	}
+
+	// The filter config file is not required if the config file defines
+	// chains.
+	home, err := ioutil.TempDir("", "go-torch-home")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(home)
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	filter, err := buildFilter(opts)
+	os.Setenv("HOME", oldHome)
+	if err != nil {
+		t.Fatalf("buildFilter failed: %v", err)
+	}
+	got := filter([]string{"runtime.goexit", "main.main", "main.fib", "runtime.mallocgc", "runtime.gcStart"})
+	if want := []string{"main", "fib", "runtime.mallocgc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filter got %v, want %v", got, want)
+	}
+
+	// Chains in the config file are combined with those in the filter
+	// config file.
+	filterConfig := writeFilterConfig(t, "[other]\ntrim = runtime.\n")
+	defer os.Remove(filterConfig)
+	if err := runWithArgs("--config", config, "--filter-config", filterConfig, "--filters", "noise,other", "--raw", "--binaryinput", testPProfInputFile); err != nil {
+		t.Errorf("Run with filter chains in the config file failed: %v", err)
+	}
+}
+
+func TestApplyConfigErrors(t *testing.T) {
+	tests := []struct {
+		contents string
+		wantErr  string
+	}{
+		{"widht: 1600", `line 1: unknown option "widht"`},
+		{"config: other.yaml", `line 1: unknown option "config"`},
+		{"hash: yes please", `line 1: hash must be true or false, got "yes please"`},
+		{"width: wide", `parsing "wide"`},
+		{"output:\n  width: 1600", "line 2: nested options are not supported"},
+		{"filter-chains:\n  noise: hide", "line 2: each filter chain must be a list of filters"},
+		{"filter-chains:\n  noise:\n    - focus: main", `line 3: unknown filter operation "focus"`},
+		{"filter-chains:\n  noise:\n    - squash: (", "line 3: invalid squash filter"},
+	}
+
+	config := getTempFilename(t, ".yaml")
+	defer os.Remove(config)
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(config, []byte(tt.contents), 0666); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		err := runWithArgs("--config", config)
+		if err == nil || !strings.Contains(err.Error(), "could not apply config file") || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Config %q got error %v, want %v", tt.contents, err, tt.wantErr)
+		}
+	}
+
+	err := runWithArgs("--config", "/dev/zero/invalid/file")
+	if err == nil || !strings.Contains(err.Error(), "could not apply config file") {
+		t.Errorf("Expected missing config file to fail, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
+)
+
+// Groups for functions that are not in a module.
+const (
+	stdModule     = "(std)"
+	unknownModule = "(unknown)"
+)
+
+var majorVersionRE = regexp.MustCompile(`^v[0-9]+$`)
+
+// packageModule guesses the module of a package from its path, as profiles
+// do not record modules. Modules are assumed to be a host and two path
+// elements (e.g. github.com/uber/go-torch) with an optional major version,
+// except for gopkg.in, which only has one. Packages without a host in their
+// path are in the standard library, except for main.
+func packageModule(pkg string) string {
+	if idx := strings.LastIndex(pkg, "/vendor/"); idx >= 0 {
+		pkg = pkg[idx+len("/vendor/"):]
+	}
+	parts := strings.Split(pkg, "/")
+	switch {
+	case pkg == "":
+		return unknownModule
+	case pkg == "main":
+		return pkg
+	case !strings.Contains(parts[0], "."):
+		return stdModule
+	}
+
+	n := 3
+	if parts[0] == "gopkg.in" {
+		n = 2
+	}
+	if len(parts) > n && majorVersionRE.MatchString(parts[n]) {
+		n++
+	}
+	if len(parts) < n {
+		n = len(parts)
+	}
+	return strings.Join(parts[:n], "/")
+}
+
+// costGroup returns the function that groups functions for --cost-by.
+func costGroup(by string) func(fn string) string {
+	if by == "module" {
+		return func(fn string) string {
+			return packageModule(stack.FuncPackage(fn))
+		}
+	}
+	return func(fn string) string {
+		if pkg := stack.FuncPackage(fn); pkg != "" {
+			return pkg
+		}
+		return unknownModule
+	}
+}
+
+// printCost writes the samples of each package or module in the result's
+// profile, sorted by cumulative samples if byCum is set.
+func printCost(w io.Writer, result *torch.Result, by string, byCum bool, msgs *messages) error {
+	profile, err := resultProfile(result)
+	if err != nil {
+		return err
+	}
+	totals := stack.TopGroups(profile, result.SampleIndex, costGroup(by))
+	if byCum {
+		stack.SortGroupsByCum(totals)
+	}
+	heading := msgs.packages
+	if by == "module" {
+		heading = msgs.modules
+	}
+	return writeGroupReport(w, heading, profile, result.SampleIndex, totals, msgs)
+}
+
+// writeGroupReport writes the self and cumulative counts of each group.
+func writeGroupReport(w io.Writer, heading string, profile *stack.Profile, sampleIdx int, totals []stack.GroupTotal, msgs *messages) error {
+	var total int64
+	for _, s := range profile.Samples {
+		total += s.Counts[sampleIdx]
+	}
+
+	if _, err := fmt.Fprintf(w, msgs.groupHeading+"\n", heading, profile.SampleNames[sampleIdx], total); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%12s %7s %12s %7s\n", msgs.self, msgs.self+"%", msgs.cum, msgs.cum+"%"); err != nil {
+		return err
+	}
+	for _, t := range totals {
+		if _, err := fmt.Fprintf(w, "%12d %6.2f%% %12d %6.2f%%  %v\n",
+			t.Self, percent(t.Self, total), t.Cum, percent(t.Cum, total), t.Group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
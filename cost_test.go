@@ -0,0 +1,91 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/uber/go-torch/torch"
+)
+
+func TestPackageModule(t *testing.T) {
+	tests := []struct {
+		pkg  string
+		want string
+	}{
+		{"main", "main"},
+		{"net/http", stdModule},
+		{"", unknownModule},
+		{"github.com/uber/go-torch/renderer", "github.com/uber/go-torch"},
+		{"github.com/uber/go-torch", "github.com/uber/go-torch"},
+		{"github.com/uber/zap/v2/zapcore", "github.com/uber/zap/v2"},
+		{"golang.org/x/net/http2", "golang.org/x/net"},
+		{"gopkg.in/yaml", "gopkg.in/yaml"},
+		{"github.com/uber/svc/vendor/github.com/pkg/errors", "github.com/pkg/errors"},
+		{"example.com/short", "example.com/short"},
+	}
+	for _, tt := range tests {
+		if got := packageModule(tt.pkg); got != tt.want {
+			t.Errorf("packageModule(%v) got %q, want %q", tt.pkg, got, tt.want)
+		}
+	}
+}
+
+func TestPrintCost(t *testing.T) {
+	result := &torch.Result{FlameInput: []byte(
+		"runtime.main;github.com/uber/svc/handler.Serve;github.com/uber/svc/cache.Get 6\n" +
+			"runtime.main;github.com/uber/svc/handler.Serve;golang.org/x/net/http2.Write 3\n" +
+			"runtime.main;malloc 1\n")}
+
+	var buf bytes.Buffer
+	if err := printCost(&buf, result, "module", false, english); err != nil {
+		t.Fatalf("printCost failed: %v", err)
+	}
+	expected := "Modules by samples/count, 10 total\n" +
+		"        self   self%          cum    cum%\n" +
+		"           6  60.00%            9  90.00%  github.com/uber/svc\n" +
+		"           3  30.00%            3  30.00%  golang.org/x/net\n" +
+		"           1  10.00%            1  10.00%  (unknown)\n" +
+		"           0   0.00%           10 100.00%  (std)\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected report, got:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+
+	buf.Reset()
+	if err := printCost(&buf, result, "package", false, english); err != nil {
+		t.Fatalf("printCost failed: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "Packages by samples/count") || !strings.Contains(buf.String(), "  github.com/uber/svc/cache\n") {
+		t.Errorf("Unexpected report, got:\n%s", buf.String())
+	}
+}
+
+func TestRunCostBy(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.OutputOpts.CostBy = "package"
+	opts.OutputOpts.File = ""
+
+	if err := runWithOptions(opts, nil); err != nil {
+		t.Fatalf("Run with --cost-by failed: %v", err)
+	}
+}
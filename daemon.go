@@ -0,0 +1,519 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber/go-torch/pprof"
+	"github.com/uber/go-torch/torchlog"
+
+	gflags "github.com/jessevdk/go-flags"
+)
+
+// daemonFilesPath is the path that the daemon serves stored files under.
+const daemonFilesPath = "/files/"
+
+// historyTimeFormats are the formats accepted for the at parameter of
+// /history. Times without a zone are in the local time zone.
+var historyTimeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// daemonOptions are the options for the daemon command.
+type daemonOptions struct {
+	Targets   string        `long:"targets" description:"File of targets to profile, as name = base URL lines"`
+	Dir       string        `long:"dir" default:"profiles" description:"Directory to store profiles and their flame graphs in, under a directory for each target"`
+	Interval  time.Duration `long:"interval" default:"10m" description:"How often to profile the targets"`
+	Retention time.Duration `long:"retention" default:"168h" description:"How long to keep profiles and flame graphs for; 0 keeps them forever"`
+	Listen    string        `long:"listen" default:"localhost:9091" description:"Address to serve an index of the stored profiles on; empty to disable"`
+
+	// Store and S3Endpoint store files in S3 rather than --dir, or index
+	// the files in --dir in SQLite.
+	Store      string `long:"store" description:"S3 bucket to store profiles and flame graphs in rather than --dir, as s3://bucket/prefix, or a SQLite database to index the files in --dir in, as sqlite://path"`
+	S3Endpoint string `long:"s3-endpoint" description:"Endpoint of an S3-compatible service for --store; defaults to the AWS endpoint of $AWS_REGION"`
+
+	// Trend and Webhook post trend reports.
+	Trend   string `long:"trend" choice:"daily" choice:"weekly" description:"After each day or week, merge its profiles of each target, compare them against the previous period, and post the functions that rose and fell the most to --webhook"`
+	Webhook string `long:"webhook" description:"URL to post --trend reports to, as JSON with the report in a text field, such as a Slack incoming webhook"`
+}
+
+// daemonTarget is a program that the daemon profiles.
+type daemonTarget struct {
+	Name string
+	URL  string
+}
+
+// daemon profiles targets on a schedule, and stores the profiles and flame
+// graphs in store.
+type daemon struct {
+	opts    *options
+	targets []daemonTarget
+	store   artifactStore
+	now     func() time.Time
+
+	// trendStart is the start of the period that the last trend report
+	// was checked in.
+	trendStart time.Time
+}
+
+// addDaemonCommand adds the daemon command to parser.
+func addDaemonCommand(parser *gflags.Parser, opts *daemonOptions) error {
+	_, err := parser.AddCommand("daemon", "Profile targets continuously",
+		"Profile each target in --targets every --interval, store the profiles and flame graphs under --dir for --retention, and serve an index to browse them on --listen.", opts)
+	return err
+}
+
+// runDaemon profiles the targets until a value is received on stop.
+func runDaemon(allOpts *options, stop <-chan os.Signal) error {
+	opts := allOpts.OutputOpts
+	if opts.Print || opts.Raw || opts.RawFile != "" || opts.OutDir != "" || printsReport(opts) || allOpts.Watch > 0 {
+		return fmt.Errorf("invalid options: the daemon command cannot be used with --print, --raw, --raw-file, --out-dir, --top, --cost-by or --watch")
+	}
+	pprofOpts := allOpts.PProfOptions
+	if pprofOpts.BinaryFile != "" || pprofOpts.Merge || pprofOpts.BaseURL2 != "" {
+		return fmt.Errorf("invalid options: the daemon command cannot be used with --binaryinput, --merge or --base-url2")
+	}
+	if allOpts.FoldedInput != "" || allOpts.PerfInput != "" || allOpts.HeapInput != "" || allOpts.DotInput != "" || allOpts.TracebackInput != "" {
+		return fmt.Errorf("invalid options: the daemon command cannot be used with --folded-input, --perf-input, --heap-input, --dot-input or --traceback-input")
+	}
+	daemonOpts := allOpts.daemon
+	if daemonOpts.Targets == "" {
+		return fmt.Errorf("invalid options: --targets is required for the daemon command")
+	}
+	if daemonOpts.Interval <= 0 || daemonOpts.Retention < 0 {
+		return fmt.Errorf("invalid options: --interval must be positive, and --retention cannot be negative")
+	}
+	if err := validateTrend(daemonOpts); err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+
+	targets, err := parseDaemonTargets(daemonOpts.Targets)
+	if err != nil {
+		return fmt.Errorf("could not read targets: %v", err)
+	}
+	store, err := newArtifactStore(*daemonOpts)
+	if err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+	d := &daemon{opts: allOpts, targets: targets, store: store, now: time.Now}
+	// The first trend report is sent once the current period ends.
+	d.trendStart = trendPeriodStart(daemonOpts.Trend, d.now())
+
+	if daemonOpts.Listen != "" {
+		ln, err := net.Listen("tcp", daemonOpts.Listen)
+		if err != nil {
+			return fmt.Errorf("could not listen for the index: %v", err)
+		}
+		defer ln.Close()
+		torchlog.Printf("Serving the index of profiles on http://%v/", ln.Addr())
+		go http.Serve(ln, d.handler())
+	}
+
+	torchlog.Printf("Profiling %v targets every %v, storing them in %v", len(targets), daemonOpts.Interval, store)
+	ticker := time.NewTicker(daemonOpts.Interval)
+	defer ticker.Stop()
+	for {
+		d.profileAll()
+		if daemonOpts.Trend != "" {
+			d.reportTrends()
+		}
+
+		select {
+		case <-stop:
+			torchlog.Print("Stopped the daemon")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseDaemonTargets parses a file of targets, which has name = base URL
+// lines. Blank lines and lines starting with # or ; are ignored.
+func parseDaemonTargets(file string) ([]daemonTarget, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var targets []daemonTarget
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%v:%v: expected <name> = <base URL>, got %q", file, lineNum, line)
+		}
+		t := daemonTarget{Name: strings.TrimSpace(parts[0]), URL: strings.TrimSpace(parts[1])}
+		if t.Name == "" || sourceDirName(t.Name) != t.Name {
+			return nil, fmt.Errorf("%v:%v: target name %q can only contain letters, digits, '_', '.' and '-'", file, lineNum, t.Name)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("%v:%v: target %v is listed more than once", file, lineNum, t.Name)
+		}
+		if u, err := url.Parse(t.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "unix") {
+			return nil, fmt.Errorf("%v:%v: %q is not a base URL", file, lineNum, t.URL)
+		}
+		seen[t.Name] = true
+		targets = append(targets, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%v has no targets", file)
+	}
+	return targets, nil
+}
+
+// profileAll profiles every target at once, and removes expired files.
+// Errors are logged rather than returned, so a target that is down does
+// not stop the daemon.
+func (d *daemon) profileAll() {
+	var wg sync.WaitGroup
+	for _, t := range d.targets {
+		wg.Add(1)
+		go func(t daemonTarget) {
+			defer wg.Done()
+			if name, err := d.profile(t); err != nil {
+				torchlog.Printf("Failed to profile %v: %v", t.Name, err)
+			} else {
+				torchlog.Printf("Stored flame graph of %v as %v/%v", t.Name, t.Name, name)
+			}
+			if err := d.prune(t); err != nil {
+				torchlog.Printf("Failed to remove expired files of %v: %v", t.Name, err)
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
+// profile stores the profile of the target and its flame graph, and
+// returns the name of the flame graph. Both are written to a temporary
+// directory and then copied to the store.
+func (d *daemon) profile(t daemonTarget) (string, error) {
+	dir, err := ioutil.TempDir("", "go-torch-daemon")
+	if err != nil {
+		return "", fmt.Errorf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	base := filepath.Join(dir, d.now().Format(watchTimestampFormat))
+
+	runOpts := *d.opts
+	runOpts.PProfOptions.BaseURL = t.URL
+	ctx, cancel := newContext(d.opts.Timeout)
+	defer cancel()
+	rawFile := base + ".profile"
+	if err := pprof.Download(ctx, runOpts.PProfOptions, rawFile); err != nil {
+		return "", err
+	}
+
+	runOpts.PProfOptions.BinaryFile = rawFile
+	runOpts.OutputOpts.File = base + "." + outputExt(runOpts.OutputOpts.OutFormat)
+	if err := runWithOptions(&runOpts, nil); err != nil {
+		return "", fmt.Errorf("could not render profile: %v", err)
+	}
+
+	for _, file := range []string{rawFile, runOpts.OutputOpts.File} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		if err := d.store.Put(t.Name, filepath.Base(file), data); err != nil {
+			return "", fmt.Errorf("could not store %v: %v", filepath.Base(file), err)
+		}
+	}
+	return filepath.Base(runOpts.OutputOpts.File), nil
+}
+
+// prune removes the files of the target that are older than the retention.
+func (d *daemon) prune(t daemonTarget) error {
+	retention := d.opts.daemon.Retention
+	if retention == 0 {
+		return nil
+	}
+	runs, err := d.runs(t)
+	if err != nil {
+		return err
+	}
+	expiry := d.now().Add(-retention)
+	for _, r := range runs {
+		if !r.Time.Before(expiry) {
+			continue
+		}
+		for _, f := range r.Files {
+			if err := d.store.Remove(t.Name, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// daemonRun is the files stored for a target at a time.
+type daemonRun struct {
+	Time  time.Time
+	Files []string
+}
+
+// runs returns the stored runs of the target, newest first. Files that are
+// not named by a timestamp are ignored.
+func (d *daemon) runs(t daemonTarget) ([]daemonRun, error) {
+	names, err := d.store.List(t.Name)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var runs []daemonRun
+	byStamp := make(map[string]int)
+	for _, name := range names {
+		ts, ok := runTime(name)
+		if !ok {
+			continue
+		}
+		stamp := name[:len(watchTimestampFormat)]
+		i, ok := byStamp[stamp]
+		if !ok {
+			i = len(runs)
+			byStamp[stamp] = i
+			runs = append(runs, daemonRun{Time: ts})
+		}
+		runs[i].Files = append(runs[i].Files, name)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Time.After(runs[j].Time) })
+	return runs, nil
+}
+
+// runTime returns the time of a file named by a timestamp.
+func runTime(name string) (time.Time, bool) {
+	if len(name) < len(watchTimestampFormat) {
+		return time.Time{}, false
+	}
+	ts, err := time.ParseInLocation(watchTimestampFormat, name[:len(watchTimestampFormat)], time.Local)
+	return ts, err == nil
+}
+
+var daemonIndex = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><title>go-torch profiles</title></head>
+<body>
+<h1>go-torch profiles</h1>
+{{range .}}<h2>{{.Name}}</h2>
+{{if .Runs}}<ul>
+{{range .Runs}}<li>{{.Time.Format "2006-01-02 15:04:05"}}{{range .Files}} <a href="{{.Path}}">{{.Name}}</a>{{end}}</li>
+{{end}}</ul>
+{{else}}<p>No profiles yet.</p>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// handler returns the handler that serves the index of stored profiles,
+// and the stored files.
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(daemonFilesPath, d.serveFile)
+	mux.HandleFunc("/history", d.serveHistory)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := d.writeIndex(w); err != nil {
+			torchlog.Printf("Failed to serve the index: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	return mux
+}
+
+// serveFile serves a stored file, at /files/<target>/<name>. Only the files
+// of known targets that are named by a timestamp are served.
+func (d *daemon) serveFile(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, daemonFilesPath), "/")
+	if len(parts) != 2 || !d.hasTarget(parts[0]) || sourceDirName(parts[1]) != parts[1] {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := runTime(parts[1]); !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := d.store.Get(parts[0], parts[1])
+	if err == errArtifactNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		torchlog.Printf("Failed to serve %v: %v", r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(parts[1]))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+// serveHistory serves the stored file of a target that is nearest to a time,
+// at /history?target=<name>&at=<time>. The type parameter selects the file
+// by extension, such as profile, and defaults to the flame graph.
+func (d *daemon) serveHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	target := query.Get("target")
+	if !d.hasTarget(target) {
+		http.Error(w, fmt.Sprintf("unknown target %q", target), http.StatusBadRequest)
+		return
+	}
+	at, ok := parseHistoryTime(query.Get("at"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("at must be a time such as 2006-01-02T15:04, got %q", query.Get("at")), http.StatusBadRequest)
+		return
+	}
+	ext := query.Get("type")
+	if ext == "" {
+		ext = outputExt(d.opts.OutputOpts.OutFormat)
+	}
+
+	runs, err := d.runs(daemonTarget{Name: target})
+	if err != nil {
+		torchlog.Printf("Failed to serve the history of %v: %v", target, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if retention := d.opts.daemon.Retention; retention > 0 && at.Before(d.now().Add(-retention)) {
+		http.Error(w, fmt.Sprintf("profiles of %v are only kept for %v", target, retention), http.StatusNotFound)
+		return
+	}
+	file, ok := nearestRunFile(runs, at, "."+ext)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no %v files are stored for %v", ext, target), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Location", daemonFilesPath+target+"/"+file)
+	r.URL.Path = daemonFilesPath + target + "/" + file
+	d.serveFile(w, r)
+}
+
+// parseHistoryTime parses a time in one of historyTimeFormats.
+func parseHistoryTime(s string) (time.Time, bool) {
+	for _, format := range historyTimeFormats {
+		if t, err := time.ParseInLocation(format, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nearestRunFile returns the file with the extension ext of the run that is
+// nearest to at.
+func nearestRunFile(runs []daemonRun, at time.Time, ext string) (string, bool) {
+	var nearest string
+	var nearestDist time.Duration
+	for _, r := range runs {
+		dist := r.Time.Sub(at)
+		if dist < 0 {
+			dist = -dist
+		}
+		if nearest != "" && dist >= nearestDist {
+			continue
+		}
+		for _, f := range r.Files {
+			if filepath.Ext(f) == ext {
+				nearest, nearestDist = f, dist
+			}
+		}
+	}
+	return nearest, nearest != ""
+}
+
+// hasTarget returns whether name is one of the daemon's targets.
+func (d *daemon) hasTarget(name string) bool {
+	for _, t := range d.targets {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeIndex writes the index page, listing the stored runs of each target.
+func (d *daemon) writeIndex(w http.ResponseWriter) error {
+	type link struct{ Name, Path string }
+	type run struct {
+		Time  time.Time
+		Files []link
+	}
+	type target struct {
+		Name string
+		Runs []run
+	}
+
+	var targets []target
+	for _, t := range d.targets {
+		runs, err := d.runs(t)
+		if err != nil {
+			return err
+		}
+		indexTarget := target{Name: t.Name}
+		for _, r := range runs {
+			indexRun := run{Time: r.Time}
+			for _, f := range r.Files {
+				indexRun.Files = append(indexRun.Files, link{
+					Name: strings.TrimPrefix(filepath.Ext(f), "."),
+					Path: daemonFilesPath + t.Name + "/" + f,
+				})
+			}
+			indexTarget.Runs = append(indexTarget.Runs, indexRun)
+		}
+		targets = append(targets, indexTarget)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return daemonIndex.Execute(w, targets)
+}
@@ -0,0 +1,465 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	// The pure Go SQLite driver, so builds do not need cgo.
+	_ "modernc.org/sqlite"
+)
+
+// errArtifactNotFound is returned by artifactStore.Get for a missing file.
+var errArtifactNotFound = errors.New("artifact not found")
+
+// artifactStore stores the files that the daemon writes for each target,
+// such as profiles and flame graphs.
+type artifactStore interface {
+	// Put stores a file of the target, replacing any file with the same name.
+	Put(target, name string, data []byte) error
+	// Get returns a stored file, or errArtifactNotFound.
+	Get(target, name string) ([]byte, error)
+	// List returns the names of the stored files of the target.
+	List(target string) ([]string, error)
+	// Remove removes a stored file.
+	Remove(target, name string) error
+}
+
+// newArtifactStore returns the store for the daemon options: --dir indexed
+// in SQLite if --store is a sqlite:// URL, the S3 bucket in --store if it is
+// set, or --dir otherwise.
+func newArtifactStore(opts daemonOptions) (artifactStore, error) {
+	switch {
+	case opts.Store == "":
+		return diskStore(opts.Dir), nil
+	case strings.HasPrefix(opts.Store, "sqlite:"):
+		return newSQLiteStore(opts.Store, opts.Dir)
+	}
+	return newS3Store(opts.Store, opts.S3Endpoint)
+}
+
+// diskStore stores files in a directory, under a directory for each target.
+type diskStore string
+
+func (s diskStore) String() string {
+	return string(s)
+}
+
+func (s diskStore) Put(target, name string, data []byte) error {
+	dir := filepath.Join(string(s), target)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return fmt.Errorf("could not create directory: %v", err)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, name), data, 0666)
+}
+
+func (s diskStore) Get(target, name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(string(s), target, name))
+	if os.IsNotExist(err) {
+		return nil, errArtifactNotFound
+	}
+	return data, err
+}
+
+func (s diskStore) List(target string) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(string(s), target))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s diskStore) Remove(target, name string) error {
+	return os.Remove(filepath.Join(string(s), target, name))
+}
+
+// sqliteStore stores files in a directory like diskStore, and indexes them
+// in a SQLite database, so listing the files of a target, which the index
+// and /history do on every request, does not read its directory.
+type sqliteStore struct {
+	diskStore
+	path string
+	db   *sql.DB
+}
+
+// newSQLiteStore returns a store for the files in dir, indexed in the
+// database of a sqlite://path URL. The index is rebuilt from dir, so it
+// includes files stored before it was used.
+func newSQLiteStore(storeURL, dir string) (*sqliteStore, error) {
+	path := strings.TrimPrefix(strings.TrimPrefix(storeURL, "sqlite:"), "//")
+	if path == "" {
+		return nil, fmt.Errorf("%q is not a sqlite://path URL", storeURL)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open index %v: %v", path, err)
+	}
+	// SQLite allows one writer at a time, so requests share a connection
+	// rather than failing as busy.
+	db.SetMaxOpenConns(1)
+
+	s := &sqliteStore{diskStore: diskStore(dir), path: path, db: db}
+	if err := s.reindex(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not index %v in %v: %v", dir, path, err)
+	}
+	return s, nil
+}
+
+func (s *sqliteStore) String() string {
+	return string(s.diskStore) + " indexed in " + s.path
+}
+
+// reindex replaces the index with the files in the directory.
+func (s *sqliteStore) reindex() error {
+	if _, err := s.db.Exec("CREATE TABLE IF NOT EXISTS artifacts (target TEXT NOT NULL, name TEXT NOT NULL, PRIMARY KEY (target, name))"); err != nil {
+		return err
+	}
+	targets, err := ioutil.ReadDir(string(s.diskStore))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM artifacts"); err != nil {
+		return err
+	}
+	for _, t := range targets {
+		if !t.IsDir() {
+			continue
+		}
+		names, err := s.diskStore.List(t.Name())
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if _, err := tx.Exec("INSERT INTO artifacts (target, name) VALUES (?, ?)", t.Name(), name); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Put(target, name string, data []byte) error {
+	if err := s.diskStore.Put(target, name, data); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("INSERT OR IGNORE INTO artifacts (target, name) VALUES (?, ?)", target, name)
+	return err
+}
+
+func (s *sqliteStore) List(target string) ([]string, error) {
+	rows, err := s.db.Query("SELECT name FROM artifacts WHERE target = ? ORDER BY name", target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (s *sqliteStore) Remove(target, name string) error {
+	if err := s.diskStore.Remove(target, name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM artifacts WHERE target = ? AND name = ?", target, name)
+	return err
+}
+
+// s3Timeout is how long to wait for each S3 request.
+var s3Timeout = 30 * time.Second
+
+// s3Store stores files in an S3 bucket, as <prefix><target>/<name> objects.
+// Requests are signed with the credentials in the standard AWS environment
+// variables.
+type s3Store struct {
+	endpoint  string
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+	client    *http.Client
+	now       func() time.Time
+}
+
+// newS3Store returns a store for an s3://bucket/prefix URL. The endpoint
+// defaults to the AWS endpoint of the region, and can be set to use an
+// S3-compatible service instead.
+func newS3Store(storeURL, endpoint string) (*s3Store, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an s3://bucket/prefix URL", storeURL)
+	}
+	s := &s3Store{
+		bucket:    u.Host,
+		prefix:    strings.TrimPrefix(u.Path, "/"),
+		region:    os.Getenv("AWS_REGION"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		client:    &http.Client{Timeout: s3Timeout},
+		now:       time.Now,
+	}
+	if s.prefix != "" && !strings.HasSuffix(s.prefix, "/") {
+		s.prefix += "/"
+	}
+	if s.region == "" {
+		s.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to store files in S3")
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + s.region + ".amazonaws.com"
+	}
+	s.endpoint = strings.TrimSuffix(endpoint, "/")
+	return s, nil
+}
+
+func (s *s3Store) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+func (s *s3Store) Put(target, name string, data []byte) error {
+	resp, err := s.do("PUT", s.prefix+target+"/"+name, nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Store) Get(target, name string) ([]byte, error) {
+	resp, err := s.do("GET", s.prefix+target+"/"+name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (s *s3Store) List(target string) ([]string, error) {
+	prefix := s.prefix + target + "/"
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	var names []string
+	for {
+		resp, err := s.do("GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse S3 object list: %v", err)
+		}
+		for _, c := range result.Contents {
+			if name := strings.TrimPrefix(c.Key, prefix); !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if !result.IsTruncated {
+			return names, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *s3Store) Remove(target, name string) error {
+	resp, err := s.do("DELETE", s.prefix+target+"/"+name, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request for an object key, or for the bucket if key is
+// empty. Responses other than 2xx are returned as errors, with 404 as
+// errArtifactNotFound.
+func (s *s3Store) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	rawURL := s.endpoint + "/" + s3Escape(s.bucket, false)
+	if key != "" {
+		rawURL += "/" + s3Escape(key, false)
+	}
+	if len(query) > 0 {
+		rawURL += "?" + s3Query(query)
+	}
+	req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+	s.sign(req, body)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 request failed: %v", err)
+	}
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		resp.Body.Close()
+		return nil, errArtifactNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 %v %v returned %v: %s", method, key, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req, which
+// signs the host and every header already set on req.
+func (s *s3Store) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%v:%v\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		s3Query(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Query returns the query in the canonical form that is signed, sorted by
+// key with both keys and values escaped.
+func s3Query(query url.Values) string {
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape escapes s as AWS signatures require, escaping every byte other
+// than unreserved characters, and '/' if escapeSlash is set.
+func s3Escape(s string, escapeSlash bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !escapeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
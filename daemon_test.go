@@ -0,0 +1,236 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDaemonTargets(t *testing.T) {
+	file := writeTempFile(t, "# services\napi = http://10.0.0.1:8080\n\n; workers\nworker-1 = unix:///var/run/worker.sock\n")
+	defer os.Remove(file)
+
+	got, err := parseDaemonTargets(file)
+	if err != nil {
+		t.Fatalf("parseDaemonTargets failed: %v", err)
+	}
+	want := []daemonTarget{
+		{Name: "api", URL: "http://10.0.0.1:8080"},
+		{Name: "worker-1", URL: "unix:///var/run/worker.sock"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDaemonTargets = %v, want %v", got, want)
+	}
+}
+
+func TestParseDaemonTargetsErrors(t *testing.T) {
+	tests := []struct {
+		contents string
+		wantErr  string
+	}{
+		{"api http://10.0.0.1:8080", "expected <name> = <base URL>"},
+		{"api/v1 = http://10.0.0.1:8080", "can only contain"},
+		{"api = 10.0.0.1:8080", "is not a base URL"},
+		{"api = http://a:8080\napi = http://b:8080", "listed more than once"},
+		{"# nothing", "has no targets"},
+	}
+	for _, tt := range tests {
+		file := writeTempFile(t, tt.contents)
+		_, err := parseDaemonTargets(file)
+		os.Remove(file)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parseDaemonTargets(%q) error = %v, want %q", tt.contents, err, tt.wantErr)
+		}
+	}
+}
+
+func newTestDaemon(t *testing.T, targets []daemonTarget, now time.Time) (*daemon, string) {
+	dir, err := ioutil.TempDir("", "go-torch-daemon")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	opts, _, _, err := parseArgs([]string{"daemon", "--dir", dir, "-t", "1"}, "", "")
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	return &daemon{opts: opts, targets: targets, store: diskStore(dir), now: func() time.Time { return now }}, dir
+}
+
+func TestDaemonProfileAndPrune(t *testing.T) {
+	server := newProfileServer(t)
+	defer server.Close()
+
+	now := time.Date(2017, 10, 10, 10, 10, 10, 0, time.Local)
+	targets := []daemonTarget{{Name: "api", URL: server.URL}}
+	d, dir := newTestDaemon(t, targets, now)
+	defer os.RemoveAll(dir)
+
+	withScriptsInPath(t, func() {
+		d.profileAll()
+	})
+	for _, f := range []string{"20171010-101010.000.profile", "20171010-101010.000.svg"} {
+		if _, err := os.Stat(filepath.Join(dir, "api", f)); err != nil {
+			t.Errorf("Expected %v to be stored: %v", f, err)
+		}
+	}
+
+	runs, err := d.runs(targets[0])
+	if err != nil {
+		t.Fatalf("runs failed: %v", err)
+	}
+	if len(runs) != 1 || !runs[0].Time.Equal(now) || len(runs[0].Files) != 2 {
+		t.Errorf("runs = %+v, want a run at %v with 2 files", runs, now)
+	}
+
+	// Files are kept until they are older than the retention.
+	d.now = func() time.Time { return now.Add(d.opts.daemon.Retention) }
+	if err := d.prune(targets[0]); err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	if runs, _ := d.runs(targets[0]); len(runs) != 1 {
+		t.Errorf("prune removed files within the retention")
+	}
+	d.now = func() time.Time { return now.Add(d.opts.daemon.Retention + time.Second) }
+	if err := d.prune(targets[0]); err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	if runs, _ := d.runs(targets[0]); len(runs) != 0 {
+		t.Errorf("prune did not remove expired files, got %+v", runs)
+	}
+}
+
+func TestDaemonIndex(t *testing.T) {
+	now := time.Date(2017, 10, 10, 10, 10, 10, 0, time.Local)
+	targets := []daemonTarget{{Name: "api", URL: "http://api:8080"}, {Name: "worker", URL: "http://worker:8080"}}
+	d, dir := newTestDaemon(t, targets, now)
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "api"), 0777); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	for _, f := range []string{"20171010-101010.000.svg", "20171010-101010.000.profile", "20171010-102010.000.svg", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "api", f), []byte("<svg/>"), 0666); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	d.handler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("index got status %v: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`<a href="/files/api/20171010-101010.000.svg">svg</a>`,
+		`<a href="/files/api/20171010-101010.000.profile">profile</a>`,
+		"<h2>worker</h2>\n<p>No profiles yet.</p>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("index is missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Index(body, "10:20:10") > strings.Index(body, "10:10:10") {
+		t.Errorf("index should list the newest profiles first:\n%s", body)
+	}
+	if strings.Contains(body, "notes.txt") {
+		t.Errorf("index should only list files named by a timestamp:\n%s", body)
+	}
+
+	w = httptest.NewRecorder()
+	d.handler().ServeHTTP(w, httptest.NewRequest("GET", "/files/api/20171010-101010.000.svg", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "<svg/>" {
+		t.Errorf("serving a stored file got %v: %s", w.Code, w.Body.String())
+	}
+	for _, path := range []string{"/files/api/notes.txt", "/files/other/20171010-101010.000.svg", "/files/api/../api/20171010-101010.000.svg"} {
+		w = httptest.NewRecorder()
+		d.handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code == http.StatusOK {
+			t.Errorf("%v should not be served", path)
+		}
+	}
+}
+
+func TestDaemonHistory(t *testing.T) {
+	now := time.Date(2017, 10, 10, 12, 0, 0, 0, time.Local)
+	targets := []daemonTarget{{Name: "api", URL: "http://api:8080"}, {Name: "worker", URL: "http://worker:8080"}}
+	d, dir := newTestDaemon(t, targets, now)
+	defer os.RemoveAll(dir)
+
+	for _, f := range []string{"20171010-100000.000.svg", "20171010-100000.000.profile", "20171010-101000.000.svg", "20171010-110000.000.profile"} {
+		if err := d.store.Put("api", f, []byte(f)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{"target=api&at=2017-10-10T10:04", http.StatusOK, "20171010-100000.000.svg"},
+		{"target=api&at=2017-10-10T10:06:00", http.StatusOK, "20171010-101000.000.svg"},
+		// The run at 11:00 is nearer, but it has no flame graph.
+		{"target=api&at=2017-10-10T11:00", http.StatusOK, "20171010-101000.000.svg"},
+		{"target=api&at=2017-10-10T11:00&type=profile", http.StatusOK, "20171010-110000.000.profile"},
+		{"target=api&at=2017-10-01T10:00", http.StatusNotFound, "only kept for 168h0m0s"},
+		{"target=worker&at=2017-10-10T10:00", http.StatusNotFound, "no svg files are stored for worker"},
+		{"target=db&at=2017-10-10T10:00", http.StatusBadRequest, `unknown target "db"`},
+		{"target=api&at=yesterday", http.StatusBadRequest, "at must be a time"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		d.handler().ServeHTTP(w, httptest.NewRequest("GET", "/history?"+tt.query, nil))
+		if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+			t.Errorf("/history?%v got %v: %s, want %v: %v", tt.query, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+		if w.Code == http.StatusOK && w.Header().Get("Content-Location") != "/files/api/"+tt.wantBody {
+			t.Errorf("/history?%v Content-Location = %v", tt.query, w.Header().Get("Content-Location"))
+		}
+	}
+}
+
+func TestDaemonInvalidOptions(t *testing.T) {
+	tests := [][]string{
+		{"daemon"},
+		{"daemon", "--targets", "targets.ini", "--interval", "0s"},
+		{"daemon", "--targets", "targets.ini", "--merge"},
+		{"daemon", "--targets", "targets.ini", "--print"},
+		{"daemon", "--targets", "targets.ini", "--trend", "daily"},
+		{"daemon", "--targets", "targets.ini", "--webhook", "http://hooks/trend"},
+		{"daemon", "--targets", "targets.ini", "--trend", "weekly", "--webhook", "http://hooks/trend"},
+	}
+	withScriptsInPath(t, func() {
+		for _, args := range tests {
+			err := runWithArgs(args...)
+			if err == nil || !strings.Contains(err.Error(), "invalid options") {
+				t.Errorf("runWithArgs(%v) error = %v, want invalid options", args, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber/go-torch/torchlog"
+)
+
+// defaultConsulAddr is the address of the Consul agent if CONSUL_HTTP_ADDR
+// is not set, the same default as the consul CLI.
+const defaultConsulAddr = "127.0.0.1:8500"
+
+// discoveryTimeout is how long to wait for a service to be resolved.
+var discoveryTimeout = 30 * time.Second
+
+// resolver resolves a service to the base URLs of its instances.
+type resolver interface {
+	Resolve(name string) ([]string, error)
+}
+
+// resolvers are the resolvers for each scheme of --service.
+var resolvers = map[string]resolver{
+	"static": staticResolver{},
+	"srv":    srvResolver{lookup: net.LookupSRV},
+	"consul": consulResolver{},
+	"k8s":    k8sResolver{},
+}
+
+// resolveService resolves a --service, given as scheme:name, to the base
+// URLs of its instances, sorted so they are listed in a stable order.
+func resolveService(service string) ([]string, error) {
+	parts := strings.SplitN(service, ":", 2)
+	r, ok := resolvers[parts[0]]
+	if len(parts) != 2 || !ok || parts[1] == "" {
+		return nil, fmt.Errorf("service %q must be static:<base URLs>, srv:<name>, consul:<name> or k8s:<namespace>/<service>[:port]", service)
+	}
+	hosts, err := r.Resolve(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve service %v: %v", service, err)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("service %v has no instances", service)
+	}
+	sort.Strings(hosts)
+	torchlog.Printf("Resolved service %v to %v instances", service, len(hosts))
+	return hosts, nil
+}
+
+// sampleHosts returns n of the hosts, chosen at random, or all of the hosts
+// if n is 0 or there are not more than n.
+func sampleHosts(hosts []string, n int) []string {
+	if n == 0 || n >= len(hosts) {
+		return hosts
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	sampled := make([]string, 0, n)
+	for _, i := range r.Perm(len(hosts))[:n] {
+		sampled = append(sampled, hosts[i])
+	}
+	sort.Strings(sampled)
+	return sampled
+}
+
+// staticResolver resolves a comma separated list of base URLs.
+type staticResolver struct{}
+
+func (staticResolver) Resolve(name string) ([]string, error) {
+	var hosts []string
+	for _, host := range strings.Split(name, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+// srvResolver resolves a DNS SRV record, such as _pprof._tcp.api.example.com,
+// to the host and port of each record.
+type srvResolver struct {
+	lookup func(service, proto, name string) (string, []*net.SRV, error)
+}
+
+func (r srvResolver) Resolve(name string) ([]string, error) {
+	_, records, err := r.lookup("", "", name)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, srv := range records {
+		hostPort := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		hosts = append(hosts, "http://"+hostPort)
+	}
+	return hosts, nil
+}
+
+// consulResolver resolves a service registered in Consul to the address and
+// port of each instance that passes its health checks, using the agent at
+// CONSUL_HTTP_ADDR.
+type consulResolver struct{}
+
+func (consulResolver) Resolve(name string) ([]string, error) {
+	addr := os.Getenv("CONSUL_HTTP_ADDR")
+	if addr == "" {
+		addr = defaultConsulAddr
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/health/service/"+url.PathEscape(name)+"?passing", nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	client := &http.Client{Timeout: discoveryTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul returned %v", resp.Status)
+	}
+
+	var entries []struct {
+		Node struct {
+			Address string
+		}
+		Service struct {
+			Address string
+			Port    int
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("could not parse consul response: %v", err)
+	}
+	var hosts []string
+	for _, e := range entries {
+		// The service address defaults to the address of its node.
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		hosts = append(hosts, "http://"+net.JoinHostPort(host, strconv.Itoa(e.Service.Port)))
+	}
+	return hosts, nil
+}
+
+// k8sResolver resolves a Kubernetes service, given as
+// namespace/service[:port], to the addresses of its ready endpoints using
+// kubectl. The pods are profiled directly, so their addresses must be
+// reachable, e.g. from inside the cluster.
+type k8sResolver struct{}
+
+func (k8sResolver) Resolve(name string) ([]string, error) {
+	// Services are parsed the same way as pods in --k8s.
+	t, err := parseK8sTarget(name)
+	if err != nil {
+		return nil, fmt.Errorf("service %q must be in the form namespace/service[:port]", name)
+	}
+
+	cmd := exec.Command("kubectl", "get", "endpoints", t.Pod, "--namespace", t.Namespace,
+		"-o", "jsonpath={.subsets[*].addresses[*].ip}")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	torchlog.Debugf("Run kubectl command: %v", strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("kubectl get endpoints failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var hosts []string
+	for _, ip := range strings.Fields(string(out)) {
+		hosts = append(hosts, "http://"+net.JoinHostPort(ip, strconv.Itoa(t.Port)))
+	}
+	return hosts, nil
+}
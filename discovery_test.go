@@ -0,0 +1,139 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveServiceStatic(t *testing.T) {
+	got, err := resolveService("static:http://b:8080, http://a:8080,")
+	if err != nil {
+		t.Fatalf("resolveService failed: %v", err)
+	}
+	if want := []string{"http://a:8080", "http://b:8080"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveService = %v, want %v", got, want)
+	}
+}
+
+func TestResolveServiceErrors(t *testing.T) {
+	tests := []struct {
+		service string
+		wantErr string
+	}{
+		{"api", "must be static:<base URLs>"},
+		{"eureka:api", "must be static:<base URLs>"},
+		{"consul:", "must be static:<base URLs>"},
+		{"static:,", "has no instances"},
+		{"k8s:api", "namespace/service[:port]"},
+	}
+	for _, tt := range tests {
+		if _, err := resolveService(tt.service); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("resolveService(%q) error = %v, want %q", tt.service, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSRVResolver(t *testing.T) {
+	r := srvResolver{lookup: func(service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_pprof._tcp.api.example.com" {
+			t.Errorf("lookup got name %v", name)
+		}
+		return "", []*net.SRV{{Target: "api-1.example.com.", Port: 6060}, {Target: "10.0.0.2.", Port: 8080}}, nil
+	}}
+	got, err := r.Resolve("_pprof._tcp.api.example.com")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if want := []string{"http://api-1.example.com:6060", "http://10.0.0.2:8080"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve = %v, want %v", got, want)
+	}
+}
+
+func TestConsulResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.URL.Path != "/v1/health/service/api" && r.URL.Path != "/v1/health/service/api?v=2#/x") || r.URL.RawQuery != "passing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[
+			{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": "", "Port": 8080}},
+			{"Node": {"Address": "10.0.0.2"}, "Service": {"Address": "10.1.0.2", "Port": 6060}}
+		]`))
+	}))
+	defer server.Close()
+
+	os.Setenv("CONSUL_HTTP_ADDR", strings.TrimPrefix(server.URL, "http://"))
+	defer os.Unsetenv("CONSUL_HTTP_ADDR")
+
+	got, err := consulResolver{}.Resolve("api")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if want := []string{"http://10.0.0.1:8080", "http://10.1.0.2:6060"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve = %v, want %v", got, want)
+	}
+	// Names are escaped, so they cannot change the query or path.
+	if got, err := (consulResolver{}).Resolve("api?v=2#/x"); err != nil || len(got) != 2 {
+		t.Errorf("Resolve of a name with ?, # and / = %v, %v, want 2 base URLs", got, err)
+	}
+	if _, err := (consulResolver{}).Resolve("db"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Resolve of an unknown service error = %v, want 404", err)
+	}
+}
+
+func TestK8sResolver(t *testing.T) {
+	const script = `[ "$*" = "get endpoints api --namespace prod -o jsonpath={.subsets[*].addresses[*].ip}" ] || exit 1
+echo "10.2.0.5 10.2.1.7"`
+	withFakeCommand(t, "kubectl", script, func() {
+		got, err := k8sResolver{}.Resolve("prod/api:6060")
+		if err != nil {
+			t.Fatalf("Resolve failed: %v", err)
+		}
+		if want := []string{"http://10.2.0.5:6060", "http://10.2.1.7:6060"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Resolve = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestSampleHosts(t *testing.T) {
+	hosts := []string{"http://a:8080", "http://b:8080", "http://c:8080", "http://d:8080"}
+	if got := sampleHosts(hosts, 0); !reflect.DeepEqual(got, hosts) {
+		t.Errorf("sampleHosts(0) = %v, want all hosts", got)
+	}
+	if got := sampleHosts(hosts, 5); !reflect.DeepEqual(got, hosts) {
+		t.Errorf("sampleHosts(5) = %v, want all hosts", got)
+	}
+
+	got := sampleHosts(hosts, 2)
+	seen := make(map[string]bool)
+	for _, h := range got {
+		seen[h] = true
+	}
+	if len(got) != 2 || len(seen) != 2 {
+		t.Errorf("sampleHosts(2) = %v, want 2 different hosts", got)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/uber/go-torch/torchlog"
+)
+
+// defaultContainerPort is the pprof port of a container if --docker does
+// not specify one, the same as the default --url.
+const defaultContainerPort = 8080
+
+// dockerIPFormat is the docker inspect format that prints the IP addresses
+// of a container on each of its networks.
+const dockerIPFormat = "{{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}"
+
+// dockerTarget is a container to profile, given as container[:port].
+type dockerTarget struct {
+	Container string
+	Port      int
+}
+
+// parseDockerTarget parses a --docker target, container[:port].
+func parseDockerTarget(target string) (dockerTarget, error) {
+	t := dockerTarget{Container: target, Port: defaultContainerPort}
+	if idx := strings.LastIndex(target, ":"); idx >= 0 {
+		port, err := strconv.Atoi(target[idx+1:])
+		if err != nil || port <= 0 || port > 65535 {
+			return t, fmt.Errorf("invalid container port %q", target[idx+1:])
+		}
+		t.Container, t.Port = target[:idx], port
+	}
+	if t.Container == "" {
+		return t, fmt.Errorf("container %q must be in the form container[:port]", target)
+	}
+	return t, nil
+}
+
+// dockerBaseURL returns the base URL to profile a container with. This is
+// the host address that the pprof port is published on, or the container's
+// IP address if the port is not published, which is only reachable if
+// Docker runs on this machine, e.g. on Linux.
+func dockerBaseURL(t dockerTarget) (string, error) {
+	port := strconv.Itoa(t.Port)
+	if out, err := runDocker("port", t.Container, port+"/tcp"); err == nil {
+		// Each published address is on its own line, e.g. 0.0.0.0:49153.
+		if host, hostPort, err := net.SplitHostPort(firstLine(out)); err == nil {
+			if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+				host = "127.0.0.1"
+			}
+			return "http://" + net.JoinHostPort(host, hostPort), nil
+		}
+	}
+
+	torchlog.Printf("Port %v of container %v is not published, using the container's IP address", port, t.Container)
+	out, err := runDocker("inspect", "--format", dockerIPFormat, t.Container)
+	if err != nil {
+		return "", err
+	}
+	ips := strings.Fields(out)
+	if len(ips) == 0 {
+		return "", fmt.Errorf("container %v does not publish port %v and has no IP address", t.Container, port)
+	}
+	return "http://" + net.JoinHostPort(ips[0], port), nil
+}
+
+// runDocker runs a docker command, and returns its output.
+func runDocker(args ...string) (string, error) {
+	cmd := exec.Command("docker", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	torchlog.Debugf("Run docker command: %v", strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("docker %v failed: %v: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return string(out), nil
+}
+
+// firstLine returns the first line of s, without surrounding whitespace.
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if idx := strings.IndexByte(s, '\n'); idx >= 0 {
+		s = s[:idx]
+	}
+	return strings.TrimSpace(s)
+}
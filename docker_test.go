@@ -0,0 +1,132 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDockerTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		want    dockerTarget
+		wantErr string
+	}{
+		{target: "api", want: dockerTarget{Container: "api", Port: 8080}},
+		{target: "3f1c9e2a:6060", want: dockerTarget{Container: "3f1c9e2a", Port: 6060}},
+		{target: ":6060", wantErr: "container[:port]"},
+		{target: "api:debug", wantErr: "invalid container port"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDockerTarget(tt.target)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseDockerTarget(%q) error = %v, want %q", tt.target, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDockerTarget(%q) failed: %v", tt.target, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseDockerTarget(%q) = %+v, want %+v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestDockerBaseURL(t *testing.T) {
+	tests := []struct {
+		msg     string
+		script  string
+		want    string
+		wantErr string
+	}{
+		{
+			msg: "published port",
+			script: `[ "$1 $2 $3" = "port api 6060/tcp" ] || exit 1
+echo 0.0.0.0:49153
+echo [::]:49153`,
+			want: "http://127.0.0.1:49153",
+		},
+		{
+			msg:    "published on a host address",
+			script: `[ "$1" = "port" ] && echo 192.168.1.5:49153`,
+			want:   "http://192.168.1.5:49153",
+		},
+		{
+			msg: "unpublished port",
+			script: `if [ "$1" = "port" ]; then
+  echo "Error: No public port '6060/tcp' published for api" >&2
+  exit 1
+fi
+echo "172.17.0.2 "`,
+			want: "http://172.17.0.2:6060",
+		},
+		{
+			msg: "no IP address",
+			script: `[ "$1" = "port" ] && exit 1
+echo " "`,
+			wantErr: "does not publish port 6060 and has no IP address",
+		},
+		{
+			msg: "missing container",
+			script: `echo "Error: No such container: api" >&2
+exit 1`,
+			wantErr: "No such container: api",
+		},
+	}
+
+	for _, tt := range tests {
+		withFakeCommand(t, "docker", tt.script, func() {
+			got, err := dockerBaseURL(dockerTarget{Container: "api", Port: 6060})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("%v: error = %v, want %q", tt.msg, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%v: dockerBaseURL failed: %v", tt.msg, err)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("%v: got %v, want %v", tt.msg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDockerInvalidOptions(t *testing.T) {
+	tests := [][]string{
+		{"--docker", "api", "--k8s", "default/api-0"},
+		{"--docker", "api", "--binaryinput", "cpu.prof"},
+		{"--docker", "api:http"},
+	}
+	for _, args := range tests {
+		err := runWithArgs(args...)
+		if err == nil || !strings.Contains(err.Error(), "invalid options") {
+			t.Errorf("runWithArgs(%v) error = %v, want invalid options", args, err)
+		}
+	}
+}
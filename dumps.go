@@ -0,0 +1,150 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/uber/go-torch/pprof"
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torchlog"
+)
+
+// runningStates are the states of goroutines that are not blocked.
+var runningStates = map[string]bool{
+	"running":  true,
+	"runnable": true,
+}
+
+// readTracebackDir reads the goroutine tracebacks in every file in dir, and
+// merges them into one profile. Files without tracebacks are skipped.
+func readTracebackDir(dir string, opts pprof.ParseOptions) (*stack.Profile, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read traceback input: %v", err)
+	}
+
+	var profiles []*stack.Profile
+	for _, f := range files {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		file := filepath.Join(dir, f.Name())
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read traceback input: %v", err)
+		}
+		profile, err := pprof.ParseTraceback(input, opts)
+		if err != nil {
+			torchlog.Printf("Skipping %v: %v", file, err)
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("could not parse traceback input: no goroutine tracebacks found in %v", dir)
+	}
+	torchlog.Printf("Read goroutine tracebacks from %v files in %v", len(profiles), dir)
+
+	profile, err := stack.Merge(profiles...)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Focus.Enabled() && len(profile.Samples) == 0 {
+		return nil, stack.ErrNoFocusedSamples
+	}
+	return profile, nil
+}
+
+// blockedStack is a stack of blocked goroutines and how many there are.
+type blockedStack struct {
+	state string
+	funcs []string
+	count int64
+}
+
+// writeBlocked writes the n most common stacks of goroutines that are
+// blocked, such as waiting on a channel or a lock, in a profile read from
+// tracebacks. Stacks are written leaf first, as in the tracebacks.
+func writeBlocked(w io.Writer, profile *stack.Profile, n int) error {
+	var total, blocked int64
+	stacks := make(map[string]*blockedStack)
+	for _, s := range profile.Samples {
+		count := s.Counts[0]
+		total += count
+		state := strings.Join(s.Labels[pprof.TracebackStateLabel], ",")
+		if runningStates[state] {
+			continue
+		}
+		blocked += count
+
+		key := state + "\x00" + strings.Join(s.Funcs, ";")
+		if b, ok := stacks[key]; ok {
+			b.count += count
+			continue
+		}
+		stacks[key] = &blockedStack{state: state, funcs: s.Funcs, count: count}
+	}
+	if len(stacks) == 0 {
+		_, err := fmt.Fprintf(w, "None of the %v goroutines are blocked\n", total)
+		return err
+	}
+
+	sorted := make([]*blockedStack, 0, len(stacks))
+	for _, b := range stacks {
+		sorted = append(sorted, b)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		if sorted[i].state != sorted[j].state {
+			return sorted[i].state < sorted[j].state
+		}
+		return strings.Join(sorted[i].funcs, ";") < strings.Join(sorted[j].funcs, ";")
+	})
+	if n > len(sorted) {
+		n = len(sorted)
+	}
+
+	if _, err := fmt.Fprintf(w, "Showing the %v of %v most common stacks of blocked goroutines, %v of %v goroutines are blocked\n",
+		n, len(sorted), blocked, total); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%10s %7s  %v\n", "goroutines", "share", "state and stack"); err != nil {
+		return err
+	}
+	for _, b := range sorted[:n] {
+		if _, err := fmt.Fprintf(w, "%10d %6.2f%%  [%v]\n", b.count, percent(b.count, total), b.state); err != nil {
+			return err
+		}
+		for i := len(b.funcs) - 1; i >= 0; i-- {
+			if _, err := fmt.Fprintf(w, "%20s%v\n", "", b.funcs[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
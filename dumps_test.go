@@ -0,0 +1,158 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/uber/go-torch/stack"
+)
+
+const testDump = `goroutine 1 [running]:
+main.main()
+	/src/app/main.go:20 +0x1d
+
+goroutine 7 [chan receive, 5 minutes]:
+main.worker(0xc000010000)
+	/src/app/worker.go:30 +0x45
+created by main.main in goroutine 1
+	/src/app/main.go:15 +0x2f
+
+goroutine 8 [chan receive, 5 minutes]:
+main.worker(0xc000010000)
+	/src/app/worker.go:30 +0x45
+created by main.main in goroutine 1
+	/src/app/main.go:15 +0x2f
+
+goroutine 9 [sync.Mutex.Lock]:
+sync.runtime_SemacquireMutex(0xc000012345?, 0x0?, 0x1?)
+	/usr/local/go/src/runtime/sema.go:77 +0x25
+main.(*cache).get(0xc000014000)
+	/src/app/cache.go:12 +0x55
+`
+
+func TestRunDumps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-dumps")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for name, contents := range map[string]string{
+		"host1.txt":  testDump,
+		"host2.txt":  testDump,
+		"README.txt": "goroutine dumps from SIGQUIT\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0666); err != nil {
+			t.Fatalf("Failed to write %v: %v", name, err)
+		}
+	}
+
+	rawFile := getTempFilename(t, ".folded")
+	defer os.Remove(rawFile)
+	out := captureStdout(t, func() {
+		if err := runWithArgs("dumps", "--blocked", "1", dir, "--raw-file", rawFile); err != nil {
+			t.Fatalf("Run with dumps failed: %v", err)
+		}
+	})
+
+	raw, err := ioutil.ReadFile(rawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw output file: %v", err)
+	}
+	if !strings.Contains(string(raw), "main.worker 4") {
+		t.Errorf("Raw output should merge the dumps, got:\n%s", raw)
+	}
+
+	want := `Showing the 1 of 2 most common stacks of blocked goroutines, 6 of 8 goroutines are blocked
+goroutines   share  state and stack
+         4  50.00%  [chan receive]
+                    main.worker
+`
+	if out != want {
+		t.Errorf("Unexpected blocked report, got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestRunDumpsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-dumps")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"dumps", dir}, "no goroutine tracebacks found"},
+		{[]string{"dumps", "--blocked", "-1", dir}, "--blocked must not be negative"},
+		{[]string{"dumps", dir, "--print"}, "--blocked cannot be used with --print or --raw"},
+		{[]string{"dumps", dir, "extra"}, "only takes the directory"},
+		{[]string{"dumps", dir, "--k8s", "ns/pod"}, "cannot be used with the dumps command"},
+	}
+	for _, tt := range tests {
+		err := runWithArgs(tt.args...)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("runWithArgs(%v) got error %v, want %q", tt.args, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWriteBlocked(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"goroutines/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main.main"}, Counts: []int64{2}, Labels: stack.Labels{"state": {"running"}}},
+			{Funcs: []string{"main.main", "main.wait"}, Counts: []int64{1}, Labels: stack.Labels{"state": {"select"}}},
+			{Funcs: []string{"main.main", "main.read"}, Counts: []int64{1}, Labels: stack.Labels{"state": {"IO wait"}}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeBlocked(&buf, profile, 10); err != nil {
+		t.Fatalf("writeBlocked failed: %v", err)
+	}
+	want := `Showing the 2 of 2 most common stacks of blocked goroutines, 2 of 4 goroutines are blocked
+goroutines   share  state and stack
+         1  25.00%  [IO wait]
+                    main.read
+                    main.main
+         1  25.00%  [select]
+                    main.wait
+                    main.main
+`
+	if buf.String() != want {
+		t.Errorf("writeBlocked got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	profile.Samples = profile.Samples[:1]
+	buf.Reset()
+	if err := writeBlocked(&buf, profile, 10); err != nil {
+		t.Fatalf("writeBlocked failed: %v", err)
+	}
+	if want := "None of the 2 goroutines are blocked\n"; buf.String() != want {
+		t.Errorf("writeBlocked got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// defaultFilterConfig is the file, relative to the home directory, that
+// named filter chains are read from if --filter-config is not specified.
+const defaultFilterConfig = ".go-torch/filters"
+
+// filterOps are the operations that can be used in a filter chain, which
+// create a filter from the argument of the operation.
+var filterOps = map[string]func(arg string) (stack.Filter, error){
+	"hide": func(arg string) (stack.Filter, error) {
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return stack.HideFrames(re), nil
+	},
+	"squash": func(arg string) (stack.Filter, error) {
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return stack.SquashFrames(re), nil
+	},
+	"trim": func(arg string) (stack.Filter, error) {
+		return stack.TrimPrefix(arg), nil
+	},
+}
+
+// newChainFilter returns the filter for an operation of a filter chain.
+func newChainFilter(op, arg string) (stack.Filter, error) {
+	newFilter, ok := filterOps[op]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter operation %q, expected hide, squash or trim", op)
+	}
+	filter, err := newFilter(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v filter: %v", op, err)
+	}
+	return filter, nil
+}
+
+// parseFilterChains parses named filter chains from a config file, which
+// has a section for each chain, containing operations applied in order:
+//
+//	[service-noise]
+//	hide = ^github.com/uber/service/middleware\.
+//	squash = ^runtime\.
+//	trim = github.com/uber/
+//
+// Lines starting with ; or # are comments.
+func parseFilterChains(file string) (map[string][]stack.Filter, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	chains := make(map[string][]stack.Filter)
+	var name string
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name = strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := chains[name]; ok || name == "" {
+				return nil, fmt.Errorf("%v:%v: invalid or duplicate filter chain %q", file, lineNum, name)
+			}
+			chains[name] = nil
+			continue
+		}
+
+		if name == "" {
+			return nil, fmt.Errorf("%v:%v: filter must be in a [name] section", file, lineNum)
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%v:%v: expected <operation> = <argument>, got %q", file, lineNum, line)
+		}
+		filter, err := newChainFilter(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", file, lineNum, err)
+		}
+		chains[name] = append(chains[name], filter)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return chains, nil
+}
+
+// buildFilter returns the filter for the stack filtering options, or nil if
+// stacks should not be filtered. Runtime functions are stripped before named
+// filter chains are applied.
+func buildFilter(opts *options) (stack.Filter, error) {
+	var filters []stack.Filter
+	// Wrappers are merged first, so other filters only see the methods.
+	if !opts.KeepWrappers {
+		filters = append(filters, stack.MergeWrappers())
+	}
+	switch opts.StripRuntime {
+	case "remove":
+		filters = append(filters, stack.StripRuntime())
+	case "collapse":
+		filters = append(filters, stack.CollapseRuntime())
+	}
+
+	named, err := loadFilters(opts.Filters, opts.FilterConfig, opts.filterChains)
+	if err != nil {
+		return nil, err
+	}
+	if named != nil {
+		filters = append(filters, named)
+	}
+	// Recursion is collapsed after the named filters, which may hide the
+	// frames between recursive calls.
+	if opts.CollapseRecursion {
+		filters = append(filters, stack.CollapseRecursion())
+	}
+
+	// Owners are added last, so they are not removed by the named filters.
+	if opts.Owners != "" {
+		owners, err := parseCodeowners(opts.Owners)
+		if err != nil {
+			return nil, fmt.Errorf("could not read owners: %v", err)
+		}
+		filters = append(filters, owners.filter())
+	}
+
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return stack.Chain(filters...), nil
+}
+
+// buildFocus returns the filter for the --focus and --ignore options.
+func buildFocus(opts *options) (stack.FocusFilter, error) {
+	var f stack.FocusFilter
+	var err error
+	if opts.Focus != "" {
+		if f.Focus, err = regexp.Compile(opts.Focus); err != nil {
+			return f, fmt.Errorf("invalid focus regexp: %v", err)
+		}
+	}
+	if opts.Ignore != "" {
+		if f.Ignore, err = regexp.Compile(opts.Ignore); err != nil {
+			return f, fmt.Errorf("invalid ignore regexp: %v", err)
+		}
+	}
+	return f, nil
+}
+
+// loadFilters returns a filter that applies the comma separated named
+// chains in names, in order, or nil if names is empty. Chains are defined in
+// the config file, as configChains, and in the filter config file, which is
+// not required if it is not set and the config file defines chains.
+func loadFilters(names, filterConfig string, configChains map[string][]stack.Filter) (stack.Filter, error) {
+	if names == "" {
+		return nil, nil
+	}
+
+	chains := make(map[string][]stack.Filter)
+	var sources []string
+	if len(configChains) > 0 {
+		for name, chain := range configChains {
+			chains[name] = chain
+		}
+		sources = append(sources, "the config file")
+	}
+	if filterConfig == "" {
+		filterConfig = filepath.Join(os.Getenv("HOME"), defaultFilterConfig)
+		if _, err := os.Stat(filterConfig); err != nil && len(configChains) > 0 {
+			filterConfig = ""
+		}
+	}
+	if filterConfig != "" {
+		fileChains, err := parseFilterChains(filterConfig)
+		if err != nil {
+			return nil, fmt.Errorf("could not read filter config: %v", err)
+		}
+		for name, chain := range fileChains {
+			if _, ok := chains[name]; ok {
+				return nil, fmt.Errorf("filter chain %q is defined in both the config file and %v", name, filterConfig)
+			}
+			chains[name] = chain
+		}
+		sources = append(sources, filterConfig)
+	}
+
+	var filters []stack.Filter
+	for _, name := range strings.Split(names, ",") {
+		chain, ok := chains[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("filter chain %q is not defined in %v", name, strings.Join(sources, " or "))
+		}
+		filters = append(filters, chain...)
+	}
+	return stack.Chain(filters...), nil
+}
+
+// parseLabels returns the label selector for --label options, which have
+// been validated to be key=value. Multiple values for a key match any of them.
+func parseLabels(labels []string) stack.Labels {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	selector := make(stack.Labels)
+	for _, label := range labels {
+		parts := strings.SplitN(label, "=", 2)
+		selector[parts[0]] = append(selector[parts[0]], parts[1])
+	}
+	return selector
+}
+
+// labelSelector returns the label selector for the --label options, and the
+// --trace-id label if --trace-only is set.
+func (opts *options) labelSelector() stack.Labels {
+	selector := parseLabels(opts.Labels)
+	if !opts.TraceOnly {
+		return selector
+	}
+	if selector == nil {
+		selector = make(stack.Labels)
+	}
+	selector[opts.TraceLabel] = append(selector[opts.TraceLabel], opts.TraceID)
+	return selector
+}
+
+// splitLabelKeys returns the label keys in the comma separated --split-by option.
+func splitLabelKeys(keys string) []string {
+	if keys == "" {
+		return nil
+	}
+
+	var result []string
+	for _, key := range strings.Split(keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			result = append(result, key)
+		}
+	}
+	return result
+}
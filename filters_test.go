@@ -0,0 +1,313 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uber/go-torch/stack"
+)
+
+const testFilterConfig = `
+; filters for the test service
+[noise]
+hide = ^runtime\.goexit$
+squash = ^runtime\.
+
+# trim the package path
+[short]
+trim = main.
+`
+
+func writeFilterConfig(t *testing.T, contents string) string {
+	file := getTempFilename(t, ".filters")
+	if err := ioutil.WriteFile(file, []byte(contents), 0666); err != nil {
+		t.Fatalf("Failed to write filter config: %v", err)
+	}
+	return file
+}
+
+func TestLoadFilters(t *testing.T) {
+	config := writeFilterConfig(t, testFilterConfig)
+	defer os.Remove(config)
+
+	filter, err := loadFilters("noise, short", config, nil)
+	if err != nil {
+		t.Fatalf("loadFilters failed: %v", err)
+	}
+	got := filter([]string{"runtime.goexit", "main.main", "main.fib", "runtime.mallocgc", "runtime.gcStart"})
+	want := []string{"main", "fib", "runtime.mallocgc"}
+	if strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Errorf("filter got %v, want %v", got, want)
+	}
+
+	if filter, err := loadFilters("", config, nil); filter != nil || err != nil {
+		t.Errorf("loadFilters with no names should return nil, got %v", err)
+	}
+}
+
+func TestLoadFiltersErrors(t *testing.T) {
+	tests := []struct {
+		config string
+		names  string
+		errMsg string
+	}{
+		{
+			config: testFilterConfig,
+			names:  "missing",
+			errMsg: `filter chain "missing" is not defined`,
+		},
+		{
+			config: "hide = foo",
+			names:  "noise",
+			errMsg: "filter must be in a [name] section",
+		},
+		{
+			config: "[noise]\n[noise]",
+			names:  "noise",
+			errMsg: `invalid or duplicate filter chain "noise"`,
+		},
+		{
+			config: "[noise]\nfocus = foo",
+			names:  "noise",
+			errMsg: `unknown filter operation "focus"`,
+		},
+		{
+			config: "[noise]\nhide",
+			names:  "noise",
+			errMsg: "expected <operation> = <argument>",
+		},
+		{
+			config: "[noise]\nsquash = (",
+			names:  "noise",
+			errMsg: "invalid squash filter",
+		},
+	}
+
+	for _, tt := range tests {
+		config := writeFilterConfig(t, tt.config)
+		_, err := loadFilters(tt.names, config, nil)
+		os.Remove(config)
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("loadFilters(%q) with config %q got error %v, want %q", tt.names, tt.config, err, tt.errMsg)
+		}
+	}
+
+	_, err := loadFilters("noise", "/dev/zero/invalid/file", nil)
+	if err == nil || !strings.Contains(err.Error(), "could not read filter config") {
+		t.Errorf("Expected missing filter config to fail, got %v", err)
+	}
+}
+
+func TestLoadFiltersConfigChains(t *testing.T) {
+	config := writeFilterConfig(t, testFilterConfig)
+	defer os.Remove(config)
+
+	configChains := map[string][]stack.Filter{"main": {stack.TrimPrefix("main.")}}
+	filter, err := loadFilters("noise,main", config, configChains)
+	if err != nil {
+		t.Fatalf("loadFilters failed: %v", err)
+	}
+	got := filter([]string{"runtime.goexit", "main.main", "runtime.gcStart"})
+	if want := []string{"main", "runtime.gcStart"}; strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Errorf("filter got %v, want %v", got, want)
+	}
+
+	_, err = loadFilters("missing", config, configChains)
+	if want := `filter chain "missing" is not defined in the config file or ` + config; err == nil || err.Error() != want {
+		t.Errorf("loadFilters of a missing chain got error %v, want %v", err, want)
+	}
+	configChains["short"] = nil
+	if _, err := loadFilters("short", config, configChains); err == nil || !strings.Contains(err.Error(), `filter chain "short" is defined in both the config file and`) {
+		t.Errorf("loadFilters of a chain defined twice got error %v", err)
+	}
+}
+
+func TestRunWithFilters(t *testing.T) {
+	config := writeFilterConfig(t, testFilterConfig)
+	defer os.Remove(config)
+
+	if err := runWithArgs("--raw", "--binaryinput", testPProfInputFile, "--filters", "noise", "--filter-config", config); err != nil {
+		t.Errorf("Run with --filters failed: %v", err)
+	}
+}
+
+func TestBuildFilter(t *testing.T) {
+	config := writeFilterConfig(t, testFilterConfig)
+	defer os.Remove(config)
+
+	tests := []struct {
+		stripRuntime string
+		filters      string
+		want         []string
+	}{
+		{"", "", []string{"runtime.goexit", "main.main", "main.fib", "runtime.mallocgc", "runtime.gcStart"}},
+		{"remove", "", []string{"main.main", "main.fib"}},
+		{"collapse", "", []string{"runtime", "main.main", "main.fib", "runtime"}},
+		{"collapse", "short", []string{"runtime", "main", "fib", "runtime"}},
+	}
+
+	for _, tt := range tests {
+		opts := getDefaultOptions()
+		opts.StripRuntime = tt.stripRuntime
+		opts.Filters = tt.filters
+		opts.FilterConfig = config
+
+		filter, err := buildFilter(opts)
+		if err != nil {
+			t.Errorf("buildFilter(%q, %q) failed: %v", tt.stripRuntime, tt.filters, err)
+			continue
+		}
+		got := filter([]string{"runtime.goexit", "main.main", "main.fib", "runtime.mallocgc", "runtime.gcStart"})
+		if strings.Join(got, ";") != strings.Join(tt.want, ";") {
+			t.Errorf("buildFilter(%q, %q) got %v, want %v", tt.stripRuntime, tt.filters, got, tt.want)
+		}
+	}
+}
+
+func TestRunStripRuntime(t *testing.T) {
+	if err := runWithArgs("--raw", "--binaryinput", testPProfInputFile, "--strip-runtime"); err != nil {
+		t.Errorf("Run with --strip-runtime failed: %v", err)
+	}
+	if err := runWithArgs("--raw", "--binaryinput", testPProfInputFile, "--strip-runtime=collapse"); err != nil {
+		t.Errorf("Run with --strip-runtime=collapse failed: %v", err)
+	}
+	if err := runWithArgs("--raw", "--binaryinput", testPProfInputFile, "--strip-runtime=all"); err == nil {
+		t.Errorf("Run with invalid --strip-runtime expected to fail")
+	}
+}
+
+func TestBuildFilterKeepWrappers(t *testing.T) {
+	opts := getDefaultOptions()
+	filter, err := buildFilter(opts)
+	if err != nil {
+		t.Fatalf("buildFilter failed: %v", err)
+	}
+	got := filter([]string{"main.main", "main.(*server).handle-fm", "main.(*server).handle"})
+	if want := "main.main;main.(*server).handle"; strings.Join(got, ";") != want {
+		t.Errorf("buildFilter got %v, want %v", got, want)
+	}
+
+	opts.KeepWrappers = true
+	if filter, err := buildFilter(opts); err != nil || filter != nil {
+		t.Errorf("buildFilter with --keep-wrappers and no other options got %v, %v, want nil", filter, err)
+	}
+}
+
+func TestBuildFilterCollapseRecursion(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.CollapseRecursion = true
+	opts.StripRuntime = "remove"
+
+	filter, err := buildFilter(opts)
+	if err != nil {
+		t.Fatalf("buildFilter failed: %v", err)
+	}
+	// Recursive calls separated by runtime frames are collapsed once the
+	// runtime frames are removed.
+	got := filter([]string{"main.main", "main.fib", "runtime.morestack", "main.fib"})
+	if want := "main.main;main.fib [depth 2]"; strings.Join(got, ";") != want {
+		t.Errorf("buildFilter got %v, want %v", got, want)
+	}
+}
+
+func TestBuildFocus(t *testing.T) {
+	opts := getDefaultOptions()
+	focus, err := buildFocus(opts)
+	if err != nil || focus.Enabled() {
+		t.Errorf("buildFocus with no options got %v, %v, want disabled filter", focus, err)
+	}
+
+	opts.Focus = `^main\.fib$`
+	opts.Ignore = `^runtime\.`
+	focus, err = buildFocus(opts)
+	if err != nil {
+		t.Fatalf("buildFocus failed: %v", err)
+	}
+	if !focus.Keep([]string{"main.main", "main.fib"}) || focus.Keep([]string{"main.main", "main.fib", "runtime.mallocgc"}) {
+		t.Errorf("buildFocus got unexpected filter %v", focus)
+	}
+
+	opts.Ignore = "("
+	if _, err := buildFocus(opts); err == nil || !strings.Contains(err.Error(), "invalid ignore regexp") {
+		t.Errorf("buildFocus with invalid ignore expected to fail, got %v", err)
+	}
+}
+
+func TestRunFocus(t *testing.T) {
+	if err := runWithArgs("--raw", "--binaryinput", testPProfInputFile, "--focus", "main.fib"); err != nil {
+		t.Errorf("Run with --focus failed: %v", err)
+	}
+	if err := runWithArgs("--raw", "--binaryinput", testPProfInputFile, "--ignore", "."); err == nil {
+		t.Errorf("Run with --ignore dropping every sample expected to fail")
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	if got := parseLabels(nil); got != nil {
+		t.Errorf("parseLabels(nil) got %v, want nil", got)
+	}
+
+	got := parseLabels([]string{"handler=/foo", "region=a", "handler=/bar", "query=a=b"})
+	want := stack.Labels{"handler": {"/foo", "/bar"}, "region": {"a"}, "query": {"a=b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLabels got %v, want %v", got, want)
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.TraceID = "4bf92f3577b34da6"
+	if got := opts.labelSelector(); got != nil {
+		t.Errorf("labelSelector without --trace-only got %v, want nil", got)
+	}
+
+	opts.TraceOnly = true
+	want := stack.Labels{"trace_id": {"4bf92f3577b34da6"}}
+	if got := opts.labelSelector(); !reflect.DeepEqual(got, want) {
+		t.Errorf("labelSelector got %v, want %v", got, want)
+	}
+
+	opts.Labels = []string{"handler=/foo"}
+	opts.TraceLabel = "span_id"
+	want = stack.Labels{"handler": {"/foo"}, "span_id": {"4bf92f3577b34da6"}}
+	if got := opts.labelSelector(); !reflect.DeepEqual(got, want) {
+		t.Errorf("labelSelector with --label got %v, want %v", got, want)
+	}
+}
+
+func TestSplitLabelKeys(t *testing.T) {
+	tests := map[string][]string{
+		"":                nil,
+		"tid":             {"tid"},
+		"handler, region": {"handler", "region"},
+		"tid,,":           {"tid"},
+	}
+	for keys, want := range tests {
+		if got := splitLabelKeys(keys); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitLabelKeys(%q) got %v, want %v", keys, got, want)
+		}
+	}
+}
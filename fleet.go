@@ -0,0 +1,235 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
+	"github.com/uber/go-torch/torchlog"
+
+	gflags "github.com/jessevdk/go-flags"
+)
+
+// fleetOptions are the options for the fleet command.
+type fleetOptions struct {
+	HostsFile string `long:"hosts-file" description:"File of base URLs to profile, one per line, in addition to the base URLs given as arguments"`
+	Workers   int    `long:"workers" default:"8" description:"Maximum number of hosts to profile at once"`
+	PerHost   bool   `long:"per-host" description:"Write a flame graph for each host, named after the host, instead of merging them into one"`
+	Service   string `long:"service" description:"Service whose instances to profile, as static:<base URLs>, srv:<DNS SRV name>, consul:<name> or k8s:<namespace>/<service>[:port]"`
+	Instances int    `long:"instances" description:"Only profile this many hosts, chosen at random (default: all hosts)"`
+	Variance  int    `long:"variance" description:"Print the N functions whose share of samples varies the most across hosts to stdout, to find hosts that differ from the rest, such as a hot shard"`
+}
+
+// addFleetCommand adds the fleet command to parser.
+func addFleetCommand(parser *gflags.Parser, opts *fleetOptions) error {
+	_, err := parser.AddCommand("fleet", "Profile many hosts at once",
+		"Profile every base URL given as an argument, in --hosts-file or resolved from --service at the same time, using at most --workers at once, and merge the profiles into one flame graph, or write a flame graph per host using --per-host.", opts)
+	return err
+}
+
+// runFleet profiles the hosts given in remaining and the hosts file.
+func runFleet(allOpts *options, remaining []string) error {
+	opts := allOpts.OutputOpts
+	fleetOpts := allOpts.fleet
+	if fleetOpts.Workers < 1 {
+		return fmt.Errorf("invalid options: --workers must be greater than 0")
+	}
+	if fleetOpts.Instances < 0 || fleetOpts.Variance < 0 {
+		return fmt.Errorf("invalid options: --instances and --variance must not be negative")
+	}
+	if fleetOpts.Variance > 0 && (fleetOpts.PerHost || opts.Print || opts.Raw) {
+		return fmt.Errorf("invalid options: --variance cannot be used with --per-host, or with --print or --raw, which also write to stdout")
+	}
+	pprofOpts := allOpts.PProfOptions
+	if pprofOpts.BinaryFile != "" || pprofOpts.BaseURL2 != "" || allOpts.Watch > 0 {
+		return fmt.Errorf("invalid options: the fleet command cannot be used with --binaryinput, --base-url2 or --watch")
+	}
+	if allOpts.FoldedInput != "" || allOpts.PerfInput != "" || allOpts.HeapInput != "" || allOpts.DotInput != "" || allOpts.TracebackInput != "" {
+		return fmt.Errorf("invalid options: the fleet command cannot be used with --folded-input, --perf-input, --heap-input, --dot-input or --traceback-input")
+	}
+	if fleetOpts.PerHost && (opts.Print || opts.Raw || opts.OutDir != "" || printsReport(opts)) {
+		return fmt.Errorf("invalid options: --per-host cannot be used with --print, --raw, --out-dir, --top or --cost-by")
+	}
+
+	args := remaining
+	if fleetOpts.Service != "" {
+		serviceHosts, err := resolveService(fleetOpts.Service)
+		if err != nil {
+			return err
+		}
+		args = append(append([]string(nil), remaining...), serviceHosts...)
+	}
+	hosts, err := fleetHosts(fleetOpts.HostsFile, args)
+	if err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+	if n := fleetOpts.Instances; n > 0 && n < len(hosts) {
+		torchlog.Printf("Profiling %v of %v hosts, chosen at random", n, len(hosts))
+		hosts = sampleHosts(hosts, n)
+	}
+
+	if !fleetOpts.PerHost {
+		torchlog.Printf("Profiling %v hosts, %v at a time", len(hosts), fleetOpts.Workers)
+		runOpts := *allOpts
+		runOpts.PProfOptions.Merge = true
+		runOpts.workers = fleetOpts.Workers
+		runOpts.variance = fleetOpts.Variance
+		return runWithOptions(&runOpts, hosts)
+	}
+	return runPerHost(allOpts, hosts)
+}
+
+// runPerHost writes a flame graph for each host, profiling at most
+// --workers hosts at once. Every host is profiled even if some fail.
+func runPerHost(allOpts *options, hosts []string) error {
+	sem := make(chan struct{}, allOpts.fleet.Workers)
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		runOpts := *allOpts
+		runOpts.PProfOptions.BaseURL = host
+		runOpts.OutputOpts.File = hostFile(allOpts.OutputOpts.File, host)
+		if allOpts.OutputOpts.RawFile != "" {
+			runOpts.OutputOpts.RawFile = hostFile(allOpts.OutputOpts.RawFile, host)
+		}
+
+		wg.Add(1)
+		go func(i int, runOpts *options) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = runWithOptions(runOpts, nil)
+		}(i, &runOpts)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			torchlog.Printf("Failed to profile %v: %v", hosts[i], err)
+			failed = append(failed, hosts[i])
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to profile %v of %v hosts: %v", len(failed), len(hosts), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// writeVariance writes the n functions whose share of samples varies the
+// most across the profiles of the hosts, and the host that is furthest from
+// the mean share of each function.
+func writeVariance(w io.Writer, result *torch.Result, hosts []string, n int) error {
+	if len(result.SourceProfiles) != len(hosts) {
+		return fmt.Errorf("profiles of each host are not available")
+	}
+	variance := stack.Variance(result.SourceProfiles, result.SampleIndex)
+	if len(variance) == 0 {
+		_, err := fmt.Fprintf(w, "No functions vary across %v hosts\n", len(hosts))
+		return err
+	}
+	if n > len(variance) {
+		n = len(variance)
+	}
+
+	if _, err := fmt.Fprintf(w, "Showing the %v of %v functions whose share of samples varies the most across %v hosts\n",
+		n, len(variance), len(hosts)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%7s %7s %7s %7s  %v\n", "stddev", "mean", "min", "max", "function (furthest host)"); err != nil {
+		return err
+	}
+	for _, v := range variance[:n] {
+		min, max := v.Shares[0], v.Shares[0]
+		for _, share := range v.Shares {
+			min = math.Min(min, share)
+			max = math.Max(max, share)
+		}
+		if _, err := fmt.Fprintf(w, "%6.2f%% %6.2f%% %6.2f%% %6.2f%%  %v (%v)\n",
+			v.StdDev*100, v.Mean*100, min*100, max*100, v.Func, hosts[v.Outlier()]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fleetHosts returns the base URLs given as arguments and in hostsFile,
+// which has a base URL on each line. Blank lines and lines starting with #
+// are ignored.
+func fleetHosts(hostsFile string, args []string) ([]string, error) {
+	hosts := append([]string(nil), args...)
+	if hostsFile != "" {
+		f, err := os.Open(hostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read hosts file: %v", err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			hosts = append(hosts, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("could not read hosts file: %v", err)
+		}
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("the fleet command requires base URLs as arguments, in --hosts-file or from --service")
+	}
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		u, err := url.Parse(host)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("host %q must be a base URL, such as http://host:8080", host)
+		}
+		if seen[host] {
+			return nil, fmt.Errorf("host %v is listed more than once", host)
+		}
+		seen[host] = true
+	}
+	return hosts, nil
+}
+
+// hostFile returns the output file for a host, adding the host before the
+// extension of file, e.g. torch-10.0.0.1_8080.svg.
+func hostFile(file, host string) string {
+	name := host
+	if u, err := url.Parse(host); err == nil {
+		name = u.Host
+	}
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "-" + sourceDirName(name) + ext
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
+)
+
+// newProfileServer returns a server that serves the test pprof profile.
+func newProfileServer(t *testing.T) *httptest.Server {
+	profile, err := ioutil.ReadFile(testPProfInputFile)
+	if err != nil {
+		t.Fatalf("Failed to read test profile: %v", err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(profile)
+	}))
+}
+
+func TestFleetHosts(t *testing.T) {
+	hostsFile := writeTempFile(t, "# api hosts\nhttp://10.0.0.2:8080\n\n  https://10.0.0.3:8443  \n")
+	defer os.Remove(hostsFile)
+
+	got, err := fleetHosts(hostsFile, []string{"http://10.0.0.1:8080"})
+	if err != nil {
+		t.Fatalf("fleetHosts failed: %v", err)
+	}
+	want := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "https://10.0.0.3:8443"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fleetHosts = %v, want %v", got, want)
+	}
+}
+
+func TestFleetHostsErrors(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{nil, "requires base URLs"},
+		{[]string{"10.0.0.1:8080"}, "must be a base URL"},
+		{[]string{"cpu.prof"}, "must be a base URL"},
+		{[]string{"http://a:8080", "http://a:8080"}, "listed more than once"},
+	}
+	for _, tt := range tests {
+		_, err := fleetHosts("", tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("fleetHosts(%v) error = %v, want %q", tt.args, err, tt.wantErr)
+		}
+	}
+
+	if _, err := fleetHosts("/does/not/exist", nil); err == nil || !strings.Contains(err.Error(), "could not read hosts file") {
+		t.Errorf("fleetHosts with missing file error = %v", err)
+	}
+}
+
+func TestHostFile(t *testing.T) {
+	tests := []struct {
+		file, host, want string
+	}{
+		{"torch.svg", "http://10.0.0.1:8080", "torch-10.0.0.1_8080.svg"},
+		{"out/api.json", "https://api-3.prod:8443/", "out/api-api-3.prod_8443.json"},
+	}
+	for _, tt := range tests {
+		if got := hostFile(tt.file, tt.host); got != tt.want {
+			t.Errorf("hostFile(%q, %q) = %q, want %q", tt.file, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestFleetMerge(t *testing.T) {
+	server1, server2 := newProfileServer(t), newProfileServer(t)
+	defer server1.Close()
+	defer server2.Close()
+
+	rawFile := getTempFilename(t, ".txt")
+	defer os.Remove(rawFile)
+	if err := runWithArgs("fleet", "--workers", "1", "--raw-file", rawFile, server1.URL, server2.URL); err != nil {
+		t.Fatalf("fleet failed: %v", err)
+	}
+	merged, err := ioutil.ReadFile(rawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw file: %v", err)
+	}
+	if len(merged) == 0 {
+		t.Errorf("fleet wrote an empty raw file")
+	}
+}
+
+func TestFleetService(t *testing.T) {
+	server1, server2 := newProfileServer(t), newProfileServer(t)
+	defer server1.Close()
+	defer server2.Close()
+
+	rawFile := getTempFilename(t, ".txt")
+	defer os.Remove(rawFile)
+	service := "static:" + server1.URL + "," + server2.URL
+	if err := runWithArgs("fleet", "--service", service, "--instances", "1", "--raw-file", rawFile); err != nil {
+		t.Fatalf("fleet failed: %v", err)
+	}
+	if raw, err := ioutil.ReadFile(rawFile); err != nil || len(raw) == 0 {
+		t.Errorf("fleet did not write the raw file: %v", err)
+	}
+
+	if err := runWithArgs("fleet", "--service", service, "--instances", "-1"); err == nil || !strings.Contains(err.Error(), "--instances and --variance must not be negative") {
+		t.Errorf("fleet with negative --instances error = %v", err)
+	}
+}
+
+func TestFleetVariance(t *testing.T) {
+	server1, server2 := newProfileServer(t), newProfileServer(t)
+	defer server1.Close()
+	defer server2.Close()
+
+	rawFile := getTempFilename(t, ".txt")
+	defer os.Remove(rawFile)
+	out := captureStdout(t, func() {
+		if err := runWithArgs("fleet", "--variance", "5", "--raw-file", rawFile, server1.URL, server2.URL); err != nil {
+			t.Fatalf("fleet failed: %v", err)
+		}
+	})
+	// Both hosts serve the same profile.
+	if want := "No functions vary across 2 hosts\n"; out != want {
+		t.Errorf("fleet --variance printed %q, want %q", out, want)
+	}
+
+	if err := runWithArgs("fleet", "--variance", "5", "--print", server1.URL); err == nil || !strings.Contains(err.Error(), "--variance cannot be used") {
+		t.Errorf("fleet --variance --print error = %v", err)
+	}
+}
+
+func TestWriteVariance(t *testing.T) {
+	profile := func(a, b, c int64) *stack.Profile {
+		return &stack.Profile{
+			SampleNames: []string{"samples/count"},
+			Samples: []*stack.Sample{
+				stack.NewSample([]string{"main", "a"}, []int64{a}),
+				stack.NewSample([]string{"main", "b"}, []int64{b}),
+				stack.NewSample([]string{"main", "c"}, []int64{c}),
+			},
+		}
+	}
+	result := &torch.Result{SourceProfiles: []*stack.Profile{profile(40, 40, 20), profile(40, 40, 20), profile(30, 10, 60)}}
+	hosts := []string{"http://shard-1:8080", "http://shard-2:8080", "http://shard-3:8080"}
+
+	var buf bytes.Buffer
+	if err := writeVariance(&buf, result, hosts, 1); err != nil {
+		t.Fatalf("writeVariance failed: %v", err)
+	}
+	want := `Showing the 1 of 3 functions whose share of samples varies the most across 3 hosts
+ stddev    mean     min     max  function (furthest host)
+ 18.86%  33.33%  20.00%  60.00%  c (http://shard-3:8080)
+`
+	if buf.String() != want {
+		t.Errorf("writeVariance wrote:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestFleetPerHost(t *testing.T) {
+	server := newProfileServer(t)
+	defer server.Close()
+	failing := httptest.NewServer(http.NotFoundHandler())
+	defer failing.Close()
+
+	dir, err := ioutil.TempDir("", "go-torch-fleet")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "torch.svg")
+
+	withScriptsInPath(t, func() {
+		err = runWithArgs("fleet", "--per-host", "--file", file, server.URL, failing.URL)
+	})
+	if err == nil || !strings.Contains(err.Error(), "failed to profile 1 of 2 hosts: "+failing.URL) {
+		t.Errorf("fleet error = %v, want failure for %v", err, failing.URL)
+	}
+	if _, err := os.Stat(hostFile(file, server.URL)); err != nil {
+		t.Errorf("Flame graph for %v was not written: %v", server.URL, err)
+	}
+}
+
+func TestFleetInvalidOptions(t *testing.T) {
+	tests := [][]string{
+		{"fleet", "--workers", "0", "http://a:8080"},
+		{"fleet", "--base-url2", "http://b:8080", "http://a:8080"},
+		{"fleet", "--per-host", "--print", "http://a:8080"},
+		{"fleet", "--heap-input", "heap.txt", "http://a:8080"},
+	}
+	for _, args := range tests {
+		err := runWithArgs(args...)
+		if err == nil || !strings.Contains(err.Error(), "invalid options") {
+			t.Errorf("runWithArgs(%v) error = %v, want invalid options", args, err)
+		}
+	}
+}
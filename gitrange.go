@@ -0,0 +1,225 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/uber/go-torch/torchlog"
+)
+
+// hunkRE matches the header of a hunk of a diff, and captures the start and
+// length of the lines in the new file, e.g. @@ -10,2 +12,3 @@ func main() {
+var hunkRE = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// changedFuncs are the functions that were changed in a git range, named
+// like the frames of a profile, but without the package's import path up to
+// its directory in the repository, e.g. stack.(*Profile).Merge.
+type changedFuncs map[string]bool
+
+// gitChangedFuncs returns the Go functions that were changed in gitRange,
+// which is passed to git diff, e.g. v1.2.0..HEAD, in the git repository of
+// dir. Functions are found by parsing the files at the end of the range and
+// matching the changed lines to the functions that contain them.
+func gitChangedFuncs(dir, gitRange string) (changedFuncs, error) {
+	root, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	root = strings.TrimSpace(root)
+
+	diff, err := runGit(root, "diff", "--unified=0", "--no-color", "--no-ext-diff", "--no-renames", gitRange, "--", "*.go")
+	if err != nil {
+		return nil, err
+	}
+	changedLines, err := parseDiffLines(diff)
+	if err != nil {
+		return nil, err
+	}
+
+	// The files are read at the end of the range, or from the working tree
+	// if the range has no end, as git diff compares it to the working tree.
+	rev := ""
+	if parts := strings.SplitN(strings.Replace(gitRange, "...", "..", 1), "..", 2); len(parts) == 2 {
+		rev = parts[1]
+		if rev == "" {
+			rev = "HEAD"
+		}
+	}
+
+	funcs := make(changedFuncs)
+	for file, lines := range changedLines {
+		var src []byte
+		if rev != "" {
+			out, err := runGit(root, "show", rev+":"+file)
+			if err != nil {
+				return nil, err
+			}
+			src = []byte(out)
+		} else if src, err = ioutil.ReadFile(filepath.Join(root, file)); err != nil {
+			return nil, err
+		}
+
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, file, src, 0)
+		if err != nil {
+			torchlog.Printf("Warning: skipping changes to %v, which could not be parsed: %v", file, err)
+			continue
+		}
+		pkg := f.Name.Name
+		if dir := path.Dir(file); pkg != "main" && dir != "." {
+			pkg = dir
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			start, end := fset.Position(fn.Pos()).Line, fset.Position(fn.End()).Line
+			for _, line := range lines {
+				if line >= start && line <= end {
+					funcs[pkg+"."+funcDeclName(fn)] = true
+					break
+				}
+			}
+		}
+	}
+	return funcs, nil
+}
+
+// funcDeclName returns the name of a function as it is named in profiles,
+// e.g. (*Profile).Merge for a method with a pointer receiver.
+func funcDeclName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return fn.Name.Name
+	}
+	recv := fn.Recv.List[0].Type
+	pointer := false
+	if star, ok := recv.(*ast.StarExpr); ok {
+		pointer, recv = true, star.X
+	}
+	typeName := "?"
+	switch t := recv.(type) {
+	case *ast.Ident:
+		typeName = t.Name
+	case *ast.IndexExpr:
+		// Type parameters are named [...] in profiles.
+		if id, ok := t.X.(*ast.Ident); ok {
+			typeName = id.Name + "[...]"
+		}
+	}
+	if pointer {
+		return "(*" + typeName + ")." + fn.Name.Name
+	}
+	return typeName + "." + fn.Name.Name
+}
+
+// parseDiffLines returns the lines of each file that were added or changed
+// in a diff with no context lines. Where lines were only removed, the line
+// after them is used, so the function they were removed from is found.
+func parseDiffLines(diff string) (map[string][]int, error) {
+	lines := make(map[string][]int)
+	file := ""
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			file = strings.TrimPrefix(line, "+++ ")
+			if file == "/dev/null" {
+				// The file was deleted.
+				file = ""
+			}
+			file = strings.TrimPrefix(file, "b/")
+		case strings.HasPrefix(line, "@@ ") && file != "":
+			m := hunkRE.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("malformed diff hunk: %v", line)
+			}
+			start, _ := strconv.Atoi(m[1])
+			count := 1
+			if m[2] != "" {
+				count, _ = strconv.Atoi(m[2])
+			}
+			if count == 0 {
+				// Only removed lines, which were after line start.
+				start, count = start+1, 1
+			}
+			for i := 0; i < count; i++ {
+				lines[file] = append(lines[file], start+i)
+			}
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// notes returns a note for each function in frames that was changed,
+// including the closures of changed functions.
+func (c changedFuncs) notes(frames []string, note string) map[string]string {
+	notes := make(map[string]string)
+	for _, frame := range frames {
+		if c.changed(frame) {
+			notes[frame] = note
+		}
+	}
+	return notes
+}
+
+// changed returns whether the function fn, named as in a profile, such as
+// github.com/uber/go-torch/stack.(*Profile).Merge.func1, was changed.
+func (c changedFuncs) changed(fn string) bool {
+	fn = trimClosures(fn)
+
+	// Changed functions are named after the directory of their package in
+	// the repository, which is the end of its import path.
+	for name := range c {
+		if fn == name || strings.HasSuffix(fn, "/"+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// runGit runs a git command in dir, and returns its output.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	torchlog.Debugf("Run git command: %v", strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %v failed: %v: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return string(out), nil
+}
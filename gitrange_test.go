@@ -0,0 +1,175 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const gitRangeBefore = `package cache
+
+type Cache struct{}
+
+func (c *Cache) Get(key string) string {
+	return key
+}
+
+func (c Cache) Len() int {
+	return 0
+}
+
+func New() *Cache {
+	return &Cache{}
+}
+`
+
+const gitRangeAfter = `package cache
+
+type Cache struct{}
+
+func (c *Cache) Get(key string) string {
+	return "v:" + key
+}
+
+func (c Cache) Len() int {
+	return 0
+}
+
+func New() *Cache {
+	return &Cache{}
+}
+`
+
+// newGitRepo creates a git repository with cache/cache.go committed before
+// and after changing (*Cache).Get.
+func newGitRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "go-torch-git")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "cache"), 0777); err != nil {
+		t.Fatalf("Failed to create package dir: %v", err)
+	}
+	file := filepath.Join(dir, "cache", "cache.go")
+
+	git := func(args ...string) {
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		if _, err := runGit(dir, args...); err != nil {
+			t.Fatalf("git failed: %v", err)
+		}
+	}
+	git("init", "-q")
+	for _, src := range []string{gitRangeBefore, gitRangeAfter} {
+		if err := ioutil.WriteFile(file, []byte(src), 0666); err != nil {
+			t.Fatalf("Failed to write %v: %v", file, err)
+		}
+		git("add", ".")
+		git("commit", "-q", "-m", "change")
+	}
+	return dir
+}
+
+func TestGitChangedFuncs(t *testing.T) {
+	dir := newGitRepo(t)
+	defer os.RemoveAll(dir)
+
+	changed, err := gitChangedFuncs(filepath.Join(dir, "cache"), "HEAD~1..HEAD")
+	if err != nil {
+		t.Fatalf("gitChangedFuncs failed: %v", err)
+	}
+	if want := (changedFuncs{"cache.(*Cache).Get": true}); !reflect.DeepEqual(changed, want) {
+		t.Errorf("gitChangedFuncs = %v, want %v", changed, want)
+	}
+
+	// Without the end of the range, changes in the working tree are included.
+	src := gitRangeAfter + "\nfunc Reset(c *Cache) {\n}\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "cache", "cache.go"), []byte(src), 0666); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	changed, err = gitChangedFuncs(dir, "HEAD")
+	if err != nil {
+		t.Fatalf("gitChangedFuncs failed: %v", err)
+	}
+	if want := (changedFuncs{"cache.Reset": true}); !reflect.DeepEqual(changed, want) {
+		t.Errorf("gitChangedFuncs with the working tree = %v, want %v", changed, want)
+	}
+
+	if _, err := gitChangedFuncs(dir, "no-such-tag..HEAD"); err == nil {
+		t.Errorf("gitChangedFuncs with an unknown revision should fail")
+	}
+}
+
+func TestParseDiffLines(t *testing.T) {
+	diff := `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -3 +3 @@ import "fmt"
+-func a() {}
++func a() { fmt.Println() }
+@@ -10,2 +10,0 @@ func b() {
+-	x++
+-	y++
+@@ -20,0 +19,3 @@ func c() {
++	x++
++	y++
++	z++
+diff --git a/old.go b/old.go
+--- a/old.go
++++ /dev/null
+@@ -1,3 +0,0 @@
+-package main
+`
+	got, err := parseDiffLines(diff)
+	if err != nil {
+		t.Fatalf("parseDiffLines failed: %v", err)
+	}
+	want := map[string][]int{"main.go": {3, 11, 19, 20, 21}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDiffLines = %v, want %v", got, want)
+	}
+}
+
+func TestChangedFuncsNotes(t *testing.T) {
+	changed := changedFuncs{"cache.(*Cache).Get": true, "main.main": true}
+	frames := []string{
+		"github.com/uber/app/cache.(*Cache).Get",
+		"github.com/uber/app/cache.(*Cache).Get.func1",
+		"github.com/uber/app/cache.(*Cache).GetAll",
+		"github.com/uber/app/othercache.(*Cache).Get",
+		"main.main",
+		"runtime.main",
+	}
+	want := map[string]string{
+		"github.com/uber/app/cache.(*Cache).Get":       "changed",
+		"github.com/uber/app/cache.(*Cache).Get.func1": "changed",
+		"main.main": "changed",
+	}
+	if got := changed.notes(frames, "changed"); !reflect.DeepEqual(got, want) {
+		t.Errorf("notes = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber/go-torch/torchlog"
+)
+
+// defaultPodPort is the pprof port of a pod if --k8s does not specify one,
+// the same as the default --url.
+const defaultPodPort = 8080
+
+// portForwardTimeout is how long to wait for kubectl to start forwarding.
+var portForwardTimeout = 30 * time.Second
+
+// forwardingRE matches the line kubectl port-forward prints once it is
+// listening, e.g. "Forwarding from 127.0.0.1:54321 -> 8080".
+var forwardingRE = regexp.MustCompile(`^Forwarding from (127\.0\.0\.1:\d+) -> \d+`)
+
+// k8sTarget is a pod to profile, given as namespace/pod[:port].
+type k8sTarget struct {
+	Namespace string
+	Pod       string
+	Port      int
+}
+
+// portForward is a running kubectl port-forward to a pod.
+type portForward struct {
+	cmd *exec.Cmd
+	// Addr is the local address that is forwarded to the pod.
+	Addr string
+}
+
+// parseK8sTarget parses a --k8s target, namespace/pod[:port].
+func parseK8sTarget(target string) (k8sTarget, error) {
+	t := k8sTarget{Port: defaultPodPort}
+	if idx := strings.LastIndex(target, ":"); idx >= 0 {
+		port, err := strconv.Atoi(target[idx+1:])
+		if err != nil || port <= 0 || port > 65535 {
+			return t, fmt.Errorf("invalid pod port %q", target[idx+1:])
+		}
+		t.Port = port
+		target = target[:idx]
+	}
+	parts := strings.Split(target, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return t, fmt.Errorf("pod %q must be in the form namespace/pod[:port]", target)
+	}
+	t.Namespace, t.Pod = parts[0], parts[1]
+	return t, nil
+}
+
+// startPortForward runs kubectl port-forward to the pod's pprof port on a
+// random local port, and waits until it is forwarding. The port-forward
+// must be stopped using Close.
+func startPortForward(t k8sTarget) (*portForward, error) {
+	cmd := exec.Command("kubectl", "port-forward", "--namespace", t.Namespace,
+		"pod/"+t.Pod, fmt.Sprintf(":%v", t.Port))
+	torchlog.Debugf("Run kubectl command: %v", strings.Join(cmd.Args, " "))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	torchlog.Printf("Run kubectl command: %v", strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not run kubectl: %v", err)
+	}
+
+	addrs := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if m := forwardingRE.FindStringSubmatch(scanner.Text()); m != nil {
+				addrs <- m[1]
+				break
+			}
+		}
+		close(addrs)
+		// Keep reading, so kubectl does not block writing to stdout.
+		io.Copy(ioutil.Discard, stdout)
+	}()
+
+	pf := &portForward{cmd: cmd}
+	select {
+	case addr, ok := <-addrs:
+		if ok {
+			pf.Addr = addr
+			torchlog.Printf("Forwarding %v to %v/%v:%v", addr, t.Namespace, t.Pod, t.Port)
+			return pf, nil
+		}
+		// kubectl exited before it started forwarding.
+		cmd.Wait()
+		return nil, fmt.Errorf("kubectl port-forward failed: %s", bytes.TrimSpace(stderr.Bytes()))
+	case <-time.After(portForwardTimeout):
+		pf.Close()
+		return nil, fmt.Errorf("kubectl port-forward did not start forwarding within %v", portForwardTimeout)
+	}
+}
+
+// Close stops the port-forward.
+func (pf *portForward) Close() error {
+	if err := pf.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	// kubectl exits with an error as it was killed.
+	pf.cmd.Wait()
+	return nil
+}
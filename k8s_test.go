@@ -0,0 +1,152 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseK8sTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		want    k8sTarget
+		wantErr string
+	}{
+		{
+			target: "default/api-0",
+			want:   k8sTarget{Namespace: "default", Pod: "api-0", Port: 8080},
+		},
+		{
+			target: "prod/api-7d9f:6060",
+			want:   k8sTarget{Namespace: "prod", Pod: "api-7d9f", Port: 6060},
+		},
+		{target: "api-0", wantErr: "namespace/pod[:port]"},
+		{target: "default/", wantErr: "namespace/pod[:port]"},
+		{target: "a/b/c", wantErr: "namespace/pod[:port]"},
+		{target: "default/api-0:http", wantErr: "invalid pod port"},
+		{target: "default/api-0:70000", wantErr: "invalid pod port"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseK8sTarget(tt.target)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseK8sTarget(%q) error = %v, want %q", tt.target, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseK8sTarget(%q) failed: %v", tt.target, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseK8sTarget(%q) = %+v, want %+v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestK8sInvalidOptions(t *testing.T) {
+	tests := [][]string{
+		{"--k8s", "default/api-0", "--binaryinput", "cpu.prof"},
+		{"--k8s", "default/api-0", "--folded-input", "stacks.txt"},
+		{"--k8s", "default/api-0", "--merge", "http://a:8080"},
+		{"--k8s", "default/api-0", "diff", "a.prof", "b.prof"},
+		{"--k8s", "api-0"},
+	}
+	for _, args := range tests {
+		err := runWithArgs(args...)
+		if err == nil || !strings.Contains(err.Error(), "invalid options") {
+			t.Errorf("runWithArgs(%v) error = %v, want invalid options", args, err)
+		}
+	}
+}
+
+// withFakeCommand runs f with a script called name in PATH that runs the
+// given shell commands.
+func withFakeCommand(t *testing.T, name, script string, f func()) {
+	dir, err := ioutil.TempDir("", "go-torch-"+name)
+	if err != nil {
+		t.Fatalf("Failed to create temporary dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte("#!/bin/sh\n"+script+"\n"), 0777); err != nil {
+		t.Fatalf("Failed to write %v: %v", file, err)
+	}
+
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", dir+":"+oldPath)
+	f()
+}
+
+func TestStartPortForward(t *testing.T) {
+	const script = `echo "args: $@" >&2
+echo "Forwarding from 127.0.0.1:45678 -> 6060"
+echo "Forwarding from [::1]:45678 -> 6060"
+exec sleep 60`
+	withFakeCommand(t, "kubectl", script, func() {
+		pf, err := startPortForward(k8sTarget{Namespace: "prod", Pod: "api-0", Port: 6060})
+		if err != nil {
+			t.Fatalf("startPortForward failed: %v", err)
+		}
+		if pf.Addr != "127.0.0.1:45678" {
+			t.Errorf("Addr = %v, want 127.0.0.1:45678", pf.Addr)
+		}
+		wantArgs := []string{"kubectl", "port-forward", "--namespace", "prod", "pod/api-0", ":6060"}
+		if got := strings.Join(pf.cmd.Args, " "); got != strings.Join(wantArgs, " ") {
+			t.Errorf("kubectl args = %v, want %v", got, wantArgs)
+		}
+		if err := pf.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	})
+}
+
+func TestStartPortForwardFailed(t *testing.T) {
+	const script = `echo 'Error from server (NotFound): pods "api-0" not found' >&2
+exit 1`
+	withFakeCommand(t, "kubectl", script, func() {
+		_, err := startPortForward(k8sTarget{Namespace: "prod", Pod: "api-0", Port: 8080})
+		if err == nil || !strings.Contains(err.Error(), `pods "api-0" not found`) {
+			t.Errorf("startPortForward error = %v, want kubectl error", err)
+		}
+	})
+}
+
+func TestStartPortForwardTimeout(t *testing.T) {
+	oldTimeout := portForwardTimeout
+	defer func() { portForwardTimeout = oldTimeout }()
+	portForwardTimeout = 100 * time.Millisecond
+
+	withFakeCommand(t, "kubectl", "exec sleep 60", func() {
+		_, err := startPortForward(k8sTarget{Namespace: "prod", Pod: "api-0", Port: 8080})
+		if err == nil || !strings.Contains(err.Error(), "did not start forwarding") {
+			t.Errorf("startPortForward error = %v, want timeout", err)
+		}
+	})
+}
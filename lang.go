@@ -0,0 +1,166 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+// messages are the user-facing text of reports and flame graph labels in a
+// language, which is selected using --lang. Format strings take the same
+// arguments in every language.
+type messages struct {
+	// flameGraph is the default title of flame graphs.
+	flameGraph string
+	// nameType labels the function of the hovered frame in flame graphs.
+	nameType string
+	// gcSubtitle is the subtitle of --gc-before-heap snapshots.
+	gcSubtitle string
+	// trace is followed by the --trace-id in subtitles.
+	trace string
+
+	// topHeading is formatted with the number of functions shown, the
+	// number of functions, the sample name and the total.
+	topHeading string
+	// groupHeading is formatted with the kind of group, such as packages,
+	// the sample name and the total.
+	groupHeading string
+	packages     string
+	modules      string
+	owners       string
+	// flat, sum, cum and self are column headings, which are also followed
+	// by % for the column of the percentage of the total.
+	flat string
+	sum  string
+	cum  string
+	self string
+	// selfNote is formatted with the self count and percentage of a function
+	// for --show-self.
+	selfNote string
+
+	// counts translates the names of counts shown in frame titles, such as
+	// samples or bytes.
+	counts map[string]string
+}
+
+// languages are the supported --lang values.
+var languages = map[string]*messages{
+	"en": english,
+	"de": {
+		flameGraph:   "Flammendiagramm",
+		nameType:     "Funktion:",
+		gcSubtitle:   "Heap-Snapshot nach einer erzwungenen GC: belegte Werte sind nur lebende Objekte",
+		trace:        "Trace",
+		topHeading:   "Top %v von %v Funktionen nach %v, insgesamt %v",
+		groupHeading: "%v nach %v, insgesamt %v",
+		packages:     "Pakete",
+		modules:      "Module",
+		owners:       "Verantwortliche",
+		flat:         "eigen",
+		sum:          "summe",
+		cum:          "kumul",
+		self:         "eigen",
+		selfNote:     "eigen: %v (%.2f%%)",
+		counts: map[string]string{
+			"samples":     "Stichproben",
+			"nanoseconds": "Nanosekunden",
+			"objects":     "Objekte",
+			"goroutines":  "Goroutinen",
+			"contentions": "Konflikte",
+		},
+	},
+	"es": {
+		flameGraph:   "Gráfico de llamas",
+		nameType:     "Función:",
+		gcSubtitle:   "Instantánea del heap tras una GC forzada: los valores en uso son solo objetos vivos",
+		trace:        "Traza",
+		topHeading:   "Top %v de %v funciones por %v, %v en total",
+		groupHeading: "%v por %v, %v en total",
+		packages:     "Paquetes",
+		modules:      "Módulos",
+		owners:       "Responsables",
+		flat:         "propio",
+		sum:          "suma",
+		cum:          "acum",
+		self:         "propio",
+		selfNote:     "propio: %v (%.2f%%)",
+		counts: map[string]string{
+			"samples":     "muestras",
+			"nanoseconds": "nanosegundos",
+			"objects":     "objetos",
+			"goroutines":  "gorrutinas",
+			"contentions": "contenciones",
+		},
+	},
+	"fr": {
+		flameGraph:   "Graphe de flammes",
+		nameType:     "Fonction :",
+		gcSubtitle:   "Instantané du tas après un GC forcé : les valeurs utilisées sont les objets vivants uniquement",
+		trace:        "Trace",
+		topHeading:   "Top %v sur %v fonctions par %v, %v au total",
+		groupHeading: "%v par %v, %v au total",
+		packages:     "Paquets",
+		modules:      "Modules",
+		owners:       "Responsables",
+		flat:         "propre",
+		sum:          "somme",
+		cum:          "cumul",
+		self:         "propre",
+		selfNote:     "propre : %v (%.2f%%)",
+		counts: map[string]string{
+			"samples":     "échantillons",
+			"bytes":       "octets",
+			"nanoseconds": "nanosecondes",
+			"objects":     "objets",
+		},
+	},
+}
+
+// english are the messages used by default.
+var english = &messages{
+	flameGraph:   "Flame Graph",
+	nameType:     "Function:",
+	gcSubtitle:   gcSubtitle,
+	trace:        "Trace",
+	topHeading:   "Showing top %v of %v functions by %v, %v total",
+	groupHeading: "%v by %v, %v total",
+	packages:     "Packages",
+	modules:      "Modules",
+	owners:       "Owners",
+	flat:         "flat",
+	sum:          "sum",
+	cum:          "cum",
+	self:         "self",
+	selfNote:     "self: %v (%.2f%%)",
+}
+
+// language returns the messages for lang, or English if it is not set.
+func language(lang string) *messages {
+	if m, ok := languages[lang]; ok {
+		return m
+	}
+	return english
+}
+
+// countName returns the translation of a count name, such as samples, or
+// the name if it has no translation.
+func (m *messages) countName(name string) string {
+	if translated, ok := m.counts[name]; ok {
+		return translated
+	}
+	return name
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/uber/go-torch/stack"
+)
+
+var formatVerbRE = regexp.MustCompile(`%[^%\s]*[a-z]|%%`)
+
+func TestLanguagesComplete(t *testing.T) {
+	formats := func(m *messages) [][]string {
+		return [][]string{
+			formatVerbRE.FindAllString(m.topHeading, -1),
+			formatVerbRE.FindAllString(m.groupHeading, -1),
+			formatVerbRE.FindAllString(m.selfNote, -1),
+		}
+	}
+
+	for lang, m := range languages {
+		for _, s := range []string{m.flameGraph, m.nameType, m.gcSubtitle, m.trace, m.packages, m.modules, m.owners, m.flat, m.sum, m.cum, m.self} {
+			if s == "" {
+				t.Errorf("%v is missing a message: %+v", lang, m)
+			}
+		}
+		if got, want := formats(m), formats(english); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v format strings have verbs %v, want %v", lang, got, want)
+		}
+	}
+}
+
+func TestWriteTopLanguage(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main", "a"}, Counts: []int64{3}},
+			{Funcs: []string{"main"}, Counts: []int64{1}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeTop(&buf, profile, 0, 1, false, language("de")); err != nil {
+		t.Fatalf("writeTop failed: %v", err)
+	}
+	expected := "Top 1 von 2 Funktionen nach samples/count, insgesamt 4\n" +
+		"       eigen  eigen%  summe%        kumul  kumul%\n" +
+		"           3  75.00%  75.00%            3  75.00%  a\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected table, got:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+
+	if notes := selfNotes(profile, 0, language("fr")); notes["a"] != "propre : 3 (75.00%)" {
+		t.Errorf("Unexpected self note %q", notes["a"])
+	}
+}
+
+func TestFlameGraphArgsLanguage(t *testing.T) {
+	opts := getDefaultOptions().OutputOpts
+	opts.Lang = "es"
+	opts.PlainTitle = true
+	opts.sampleType = stack.ParseSampleType("samples/count")
+
+	want := []string{"--title", "Gráfico de llamas", "--width", "1200", "--nametype", "Función:", "--countname", "muestras"}
+	if got := buildFlameGraphArgs(opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildFlameGraphArgs = %v, want %v", got, want)
+	}
+
+	// Options that are set are not translated, nor are counts without a translation.
+	opts.Title = "Pagos"
+	opts.NameType = "Func:"
+	opts.sampleType = stack.ParseSampleType("alloc_space/bytes")
+	want = []string{"--title", "Pagos", "--width", "1200", "--nametype", "Func:", "--countname", "bytes"}
+	if got := buildFlameGraphArgs(opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildFlameGraphArgs = %v, want %v", got, want)
+	}
+
+	if got := language("xx"); got != english {
+		t.Errorf("Unknown languages should use English, got %+v", got)
+	}
+}
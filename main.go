@@ -23,38 +23,177 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/uber/go-torch/perf"
 	"github.com/uber/go-torch/pprof"
 	"github.com/uber/go-torch/renderer"
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
 	"github.com/uber/go-torch/torchlog"
 
 	gflags "github.com/jessevdk/go-flags"
 )
 
+// defaultOutputFile is the default value of --file.
+const defaultOutputFile = "torch.svg"
+
 // options are the parameters for go-torch.
 type options struct {
-	PProfOptions pprof.Options `group:"pprof Options"`
-	OutputOpts   outputOptions `group:"Output Options"`
+	PProfOptions      pprof.Options `group:"pprof Options"`
+	OutputOpts        outputOptions `group:"Output Options"`
+	FoldedInput       string        `long:"folded-input" description:"Render a file of collapsed stacks (e.g. from --raw, perf or eBPF tools) instead of running pprof"`
+	Collapse          string        `long:"collapse" optional:"yes" optional-value:"auto" choice:"auto" choice:"perf" choice:"pprof" choice:"gdb" description:"Collapse the --folded-input file from perf script, go tool pprof -traces or gdb backtrace stacks, detecting the format if not given, without needing stackcollapse.pl"`
+	PerfInput         string        `long:"perf-input" description:"Render the output of perf script, or a perf.data file, instead of running pprof"`
+	K8s               string        `long:"k8s" description:"Profile a Kubernetes pod, given as namespace/pod[:port] (default port 8080), using kubectl port-forward"`
+	Docker            string        `long:"docker" description:"Profile a Docker container, given as container[:port] (default port 8080), using its published port or its IP address"`
+	HeapInput         string        `long:"heap-input" description:"Render a text heap profile from /debug/pprof/heap?debug=1 without running pprof"`
+	DotInput          string        `long:"dot-input" description:"Render a call graph exported by pprof -dot, reconstructing stacks from the weights of its calls, without running pprof"`
+	DotBreakCycles    bool          `long:"dot-break-cycles" description:"Remove the lightest call of each cycle in the --dot-input graph, counting it as the caller's own samples, rather than only cutting recursive calls on each path"`
+	TracebackInput    string        `long:"traceback-input" description:"Render the goroutine tracebacks in a log file, such as from panics, fatal errors or SIGQUIT, counting goroutines with the same stack"`
+	StripRuntime      string        `long:"strip-runtime" optional:"yes" optional-value:"remove" choice:"remove" choice:"collapse" description:"Remove runtime functions such as the scheduler and GC from stacks, or collapse them into a single runtime frame"`
+	KeepWrappers      bool          `long:"keep-wrappers" description:"Keep the wrappers generated for method values (-fm) and value receiver methods as separate frames, rather than merging them into the methods they call"`
+	CollapseRecursion bool          `long:"collapse-recursion" description:"Replace consecutive calls of the same function with a single frame annotated with the recursion depth, e.g. main.fib [depth 25]"`
+	Labels            []string      `long:"label" description:"Only include samples with a label, as key=value (e.g. a pprof label set using pprof.Do); may be repeated"`
+	TraceID           string        `long:"trace-id" description:"Trace or span ID that the profile is for, which is shown in the subtitle and recorded in the --out-dir manifest"`
+	TraceLabel        string        `long:"trace-label" default:"trace_id" description:"pprof label that holds the trace or span ID of samples, for --trace-only"`
+	TraceOnly         bool          `long:"trace-only" description:"Only include samples whose --trace-label label is the --trace-id, to see the CPU cost of a distributed trace"`
+	SplitBy           string        `long:"split-by" description:"Comma separated labels (e.g. a thread or pprof label) to add root frames for, to split the flame graph by label value"`
+	ErrorBands        int64         `long:"error-bands" default:"0" description:"Annotate frames with fewer than this many samples with their 95% sampling error, e.g. [3 samples, ±1.7%]"`
+	HideInsignificant bool          `long:"hide-insignificant" description:"Replace frames with too few samples to be statistically significant with a single [insignificant] frame"`
+	Filters           string        `long:"filters" description:"Comma separated names of filter chains, defined in the filter config, to apply to stacks"`
+	FilterConfig      string        `long:"filter-config" description:"File defining named filter chains, in addition to those in the config file (default: ~/.go-torch/filters)"`
+	Owners            string        `long:"owners" description:"CODEOWNERS file; frames are labeled with the owners of their package, and the samples of each owner are printed"`
+	Focus             string        `long:"focus" description:"Only include samples with a function matching this regexp; applied while parsing, so large profiles use less memory"`
+	Ignore            string        `long:"ignore" description:"Drop samples with a function matching this regexp; applied while parsing"`
+	MissingFunctions  string        `long:"missing-functions" default:"keep" choice:"keep" choice:"drop" choice:"unknown" choice:"address" description:"How to show frames without a function name: keep them as missing-function-N, drop them, merge them into [unknown], or name them by their address"`
+	NoInlines         bool          `long:"no-inlines" description:"Do not add frames for inlined functions; name each frame by the function they were inlined into, as pprof -noinlines does; requires a pprof profile"`
+	Granularity       string        `long:"granularity" default:"function" choice:"package" choice:"file" choice:"function" choice:"line" description:"Name frames by their package, source file, function or source line; requires a pprof profile"`
+	Lines             bool          `long:"lines" description:"Append the source file and line to frame names, e.g. main.parse parse.go:42; the same as --granularity line"`
+	Timeout           time.Duration `long:"timeout" description:"Maximum time to wait for pprof to fetch profiles, e.g. 45s (default: no timeout)"`
+	ExcludeFirst      time.Duration `long:"exclude-first" description:"Drop the warm-up period (e.g. 5s) at the start of the profile: perf samples are dropped by their timestamp, and CPU profiles are fetched for this long first and discarded"`
+	TargetSamples     int64         `long:"target-samples" description:"Capture a short probe CPU profile first, and choose how long to profile for from its sample rate so the profile has about this many samples (e.g. 5000); replaces --seconds"`
+	Watch             time.Duration `long:"watch" description:"Regenerate the flame graph every interval (e.g. 1m) until interrupted"`
+	WatchStamp        bool          `long:"watch-timestamp" description:"In watch mode, write each flame graph to a timestamped file instead of overwriting the output file"`
+	Script            string        `long:"script" description:"Record the options used, except profile sources, to a script file that can be replayed using --apply-script"`
+	ApplyScript       string        `long:"apply-script" description:"Apply the options recorded in a script file; options on the command line take precedence"`
+	Config            string        `long:"config" description:"YAML file of default options, such as width, colors and filters; options on the command line take precedence (default: ~/.gotorch.yaml if it exists)"`
+
+	// Quiet, Verbose, LogJSON and NoColor control logging, which is written
+	// to stderr.
+	Quiet   bool `long:"quiet" description:"Only log errors"`
+	Verbose bool `long:"verbose" description:"Log debug messages, including the commands that are run, such as go tool pprof and flamegraph.pl"`
+	LogJSON bool `long:"log-json" description:"Log JSON objects with level, time and msg fields, one per line, rather than colored text"`
+	NoColor bool `long:"no-color" description:"Do not color log messages; they are also not colored if stderr is not a terminal or $NO_COLOR is set"`
+
+	// baseline are the options for the baseline command.
+	baseline *baselineOptions
+	// check are the options for the check command.
+	check *checkOptions
+	// collect are the options for the collect command.
+	collect *collectOptions
+	// fleet are the options for the fleet command.
+	fleet *fleetOptions
+	// daemon are the options for the daemon command.
+	daemon *daemonOptions
+	// bench are the options for the bench command.
+	bench *benchOptions
+	// run are the options for the run command.
+	run *runOptions
+	// serve are the options for the serve command.
+	serve *serveOptions
+	// onProgress is called as each stage of fetching and processing a
+	// profile starts. It is set by the serve command for each request.
+	onProgress torch.ProgressFunc
+	// workers limits how many profile sources are fetched at once, or is
+	// unlimited if 0. It is set by the fleet command.
+	workers int
+	// variance is the number of functions to print whose share of samples
+	// varies the most across the merged profiles. It is set by the fleet
+	// command.
+	variance int
+	// blocked is the number of the most common stacks of blocked goroutines
+	// to print. It is set by the dumps command.
+	blocked int
+	// commands are the arguments of the profile commands, such as diff.
+	commands *commandOptions
+	// filterChains are the named filter chains defined in the config file.
+	filterChains map[string][]stack.Filter
 }
 
 type outputOptions struct {
-	File              string `short:"f" long:"file" default:"torch.svg" description:"Output file name (must be .svg)"`
+	File              string `short:"f" long:"file" default:"torch.svg" description:"Output file name (must end in the output format, or .json for speedscope output)"`
+	OutFormat         string `long:"out-format" default:"svg" description:"Output format: svg for a flame graph, png or pdf for a flame graph that can be added to documents, speedscope for a JSON profile that can be explored at https://www.speedscope.app, json for the call tree with the counts of each sample type, callgrind for a profile that can be opened in KCachegrind, or otlp for an experimental OpenTelemetry profiles export request in OTLP/JSON"`
+	OutDir            string `long:"out-dir" description:"Write an output file for each sample type, and a manifest.json describing them, to a new timestamped directory under this directory"`
+	AllSamples        bool   `long:"all-samples" description:"Write an output file for each sample type, named after the output file and the sample, e.g. torch.alloc_space_bytes.svg"`
 	Print             bool   `short:"p" long:"print" description:"Print the generated svg to stdout instead of writing to file"`
 	Raw               bool   `short:"r" long:"raw" description:"Print the raw call graph output to stdout instead of creating a flame graph; use with Brendan Gregg's flame graph perl script (see https://github.com/brendangregg/FlameGraph)"`
+	RawFile           string `long:"raw-file" description:"Write the raw call graph output to this file instead of stdout; implies --raw"`
+	NoEmbeddedScripts bool   `long:"no-embedded-scripts" description:"Only run flamegraph.pl from PATH, rather than the copy bundled in go-torch when it is not found, if go-torch was built with bundled scripts"`
 	Title             string `long:"title" default:"Flame Graph" description:"Graph title to display in the output file"`
+	PlainTitle        bool   `long:"plain-title" description:"Use the title as is, rather than adding the sample type and profile duration to flame graph titles"`
+	Subtitle          string `long:"subtitle" description:"Second level title to display below the title of the flame graph"`
 	Width             int64  `long:"width" default:"1200" description:"Generated graph width"`
+	FontSize          int64  `long:"fontsize" description:"Font size of the flame graph (default: 12)"`
+	FontType          string `long:"fonttype" description:"Font of the flame graph (default: Verdana)"`
+	MinWidth          string `long:"minwidth" description:"Omit frames narrower than this many pixels, or this percentage of the width if it ends in % (default: 0.1)"`
+	NameType          string `long:"nametype" description:"Name type label shown for the hovered frame (default: Function:)"`
+	CountName         string `long:"countname" description:"Name of the counts shown in frame titles (default: the unit of the sample type, e.g. bytes or samples)"`
+	Lang              string `long:"lang" default:"en" choice:"en" choice:"de" choice:"es" choice:"fr" description:"Language of reports such as --top, and of the default flame graph title and labels"`
 	Hash              bool   `long:"hash" description:"Colors are keyed by function name hash"`
-	Colors            string `long:"colors" default:"" description:"set color palette. choices are: hot (default), mem, io, wakeup, chain, java, js, perl, red, green, blue, aqua, yellow, purple, orange"`
+	Colors            string `long:"colors" default:"" description:"set color palette. choices are: hot (default), mem, io, wakeup, chain, java, js, perl, red, green, blue, aqua, yellow, purple, orange, cb-safe for a color-blind safe palette, or cb-aqua and cb-orange, which are the same as aqua and orange"`
 	ConsistentPalette bool   `long:"cp" description:"Use consistent palette (palette.map)"`
 	Reverse           bool   `long:"reverse" description:"Generate stack-reversed flame graph"`
 	Inverted          bool   `long:"inverted" description:"icicle graph"`
+	Negate            bool   `long:"negate" description:"Switch the red and blue of differential flame graphs, so that red shows a decrease"`
+	Annotations       string `long:"annotations" description:"File of notes for functions, as function = note lines; frames of the functions are outlined, and show the note when hovered over"`
+	GitRange          string `long:"git-range" description:"Outline the frames of functions that were changed in this git range of the repository in the current directory (e.g. v1.2.0..HEAD), using git diff"`
+	CheckSource       string `long:"check-source" description:"Warn about functions of the Go packages in this directory (e.g. ./) that are not found in its source, such as from dead code or a stale binary, and outline their frames in flame graphs"`
+	SourceRoot        string `long:"source-root" description:"Add the source lines of each frame's function, or of its line with --lines, from the Go packages in this directory (e.g. ./) to the frame titles shown when hovering over the flame graph"`
+	Top               int    `long:"top" description:"Print a table of the N functions with the most samples to stdout; the flame graph is only written as well if --file is set"`
+	CostBy            string `long:"cost-by" choice:"package" choice:"module" description:"Print the samples of each package or Go module to stdout; the flame graph is only written as well if --file is set"`
+	SortCum           bool   `long:"sort-cum" description:"Sort the --top and --cost-by reports by cumulative samples, which include callees, rather than self (flat) samples"`
+	ShowSelf          bool   `long:"show-self" description:"Add each function's self samples, summed across all of its frames, to the frame titles shown when hovering over the flame graph"`
+	FlameChart        bool   `long:"flamechart" description:"Generate a time-ordered flame chart rather than merging identical stacks; requires --perf-input or time-ordered --folded-input"`
+
+	// Pin keeps the layout of flame graphs consistent across profiles.
+	Pin []string `long:"pin" description:"Function to always place first (leftmost) among the frames with the same parent, e.g. main.main, so that flame graphs of the same service have a consistent layout; may be repeated, in order"`
+
+	// The OTLP options describe and send --out-format otlp profiles.
+	OTLPService  string   `long:"otlp-service" description:"Service name of --out-format otlp profiles, which is their service.name resource attribute"`
+	OTLPResource []string `long:"otlp-resource" description:"Resource attribute of --out-format otlp profiles, as key=value (e.g. deployment.environment=prod); may be repeated"`
+	OTLPEndpoint string   `long:"otlp-endpoint" description:"Send --out-format otlp profiles to this OTLP/HTTP endpoint (e.g. http://localhost:4318/v1development/profiles) instead of writing them to --file"`
+
+	// sampleType and duration describe the sample of the profile that is
+	// rendered, to label flame graphs. They are set by renderOutput.
+	sampleType stack.SampleType
+	duration   time.Duration
+
+	// subtitle labels how the profile was collected, e.g. gcSubtitle or the
+	// --trace-id. It is not an option, so it is not recorded by --script.
+	subtitle string
+
+	// staleNotes are the notes for functions not found by --check-source.
+	// They are set by generate.
+	staleNotes map[string]string
 }
 
+// minWidthRE matches the values of --minwidth accepted by flamegraph.pl.
+var minWidthRE = regexp.MustCompile(`^([0-9]+\.?[0-9]*|\.[0-9]+)%?$`)
+
+// gcSubtitle labels heap profiles taken using --gc-before-heap.
+const gcSubtitle = "Heap snapshot taken after a forced GC: in-use values are live objects only"
+
 // main is the entry point of the application
 func main() {
 	if err := runWithArgs(os.Args[1:]...); err != nil {
@@ -63,77 +202,915 @@ func main() {
 }
 
 func runWithArgs(args ...string) error {
-	opts := &options{}
+	opts, parser, remaining, err := parseArgs(args, "", "")
+	if err != nil {
+		return err
+	}
+	if config := configFile(opts); config != "" || opts.ApplyScript != "" {
+		// Parse the arguments again after the config file and script, so
+		// that the arguments take precedence over them.
+		opts, parser, remaining, err = parseArgs(args, config, opts.ApplyScript)
+		if err != nil {
+			return err
+		}
+	}
+	if err := applyLogOptions(opts); err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+	renderer.UseEmbeddedScripts(!opts.OutputOpts.NoEmbeddedScripts)
+	command := ""
+	if parser.Active != nil {
+		command = parser.Active.Name
+	}
+	if err := applyCommand(opts, command, remaining); err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+	if command != "run" {
+		// The arguments of the run command are passed to the command.
+		if remaining, err = applyStdinSource(opts, remaining); err != nil {
+			return fmt.Errorf("invalid options: %v", err)
+		}
+	}
+	setOutputFileDefault(opts)
+	if err := validateOptions(opts); err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+	if opts.FoldedInput == "" && opts.PerfInput == "" && opts.HeapInput == "" && opts.DotInput == "" && opts.TracebackInput == "" && command != "collect" {
+		// Fail before waiting for a profile if pprof cannot be run.
+		if err := pprof.CheckPProf(opts.PProfOptions.GoBinary); err != nil {
+			return err
+		}
+	}
+	if opts.K8s != "" {
+		target, err := parseK8sTarget(opts.K8s)
+		if err != nil {
+			return fmt.Errorf("invalid options: %v", err)
+		}
+		if err := validateTarget("--k8s", opts, command, remaining); err != nil {
+			return fmt.Errorf("invalid options: %v", err)
+		}
+		pf, err := startPortForward(target)
+		if err != nil {
+			return err
+		}
+		defer pf.Close()
+		opts.PProfOptions.BaseURL = "http://" + pf.Addr
+	}
+	if opts.Docker != "" {
+		target, err := parseDockerTarget(opts.Docker)
+		if err != nil {
+			return fmt.Errorf("invalid options: %v", err)
+		}
+		if err := validateTarget("--docker", opts, command, remaining); err != nil {
+			return fmt.Errorf("invalid options: %v", err)
+		}
+		if opts.PProfOptions.BaseURL, err = dockerBaseURL(target); err != nil {
+			return err
+		}
+	}
+	opts.OutputOpts.subtitle = collectionSubtitle(opts)
+
+	switch {
+	case command == "baseline":
+		if err := runBaseline(opts, parser.Active.Active.Name, remaining, os.Stdout); err != nil {
+			return err
+		}
+	case command == "check":
+		if err := runCheck(opts, remaining, os.Stdout); err != nil {
+			return err
+		}
+	case command == "collect":
+		if err := runCollect(opts); err != nil {
+			return err
+		}
+	case command == "fleet":
+		if err := runFleet(opts, remaining); err != nil {
+			return err
+		}
+	case command == "bench":
+		if err := runBench(opts, remaining); err != nil {
+			return err
+		}
+	case command == "run":
+		if err := runCommand(opts, remaining); err != nil {
+			return err
+		}
+	case command == "daemon":
+		if rendersSVG(opts.OutputOpts) {
+			if err := renderer.CheckScripts(); err != nil {
+				return err
+			}
+		}
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		if err := runDaemon(opts, stop); err != nil {
+			return err
+		}
+	case command == "serve":
+		if rendersSVG(opts.OutputOpts) {
+			if err := renderer.CheckScripts(); err != nil {
+				return err
+			}
+		}
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		if err := runServe(opts, remaining, stop); err != nil {
+			return err
+		}
+	case opts.Watch > 0:
+		// Check for the flame graph script once, rather than failing every interval.
+		if rendersSVG(opts.OutputOpts) {
+			if err := renderer.CheckScripts(); err != nil {
+				return err
+			}
+		}
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		runWatch(opts, remaining, stop)
+	default:
+		if err := runWithOptions(opts, remaining); err != nil {
+			return err
+		}
+	}
+
+	if opts.Script != "" {
+		torchlog.Printf("Writing script to %v", opts.Script)
+		if err := writeScript(parser, opts.Script); err != nil {
+			return fmt.Errorf("could not write script: %v", err)
+		}
+	}
+	return nil
+}
+
+// parseArgs parses the command line arguments, after applying the defaults
+// in configFile and the options in scriptFile if they are specified.
+func parseArgs(args []string, configFile, scriptFile string) (*options, *gflags.Parser, []string, error) {
+	opts := &options{baseline: &baselineOptions{}, check: &checkOptions{}, collect: &collectOptions{}, fleet: &fleetOptions{}, daemon: &daemonOptions{}, bench: &benchOptions{}, run: &runOptions{}, serve: &serveOptions{}, commands: &commandOptions{}}
 
 	parser := gflags.NewParser(opts, gflags.Default|gflags.IgnoreUnknown)
 	parser.Usage = "[options] [binary] <profile source>"
+	parser.SubcommandsOptional = true
+	if err := addProfileCommands(parser, opts.commands); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := addBaselineCommand(parser, opts.baseline); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := addCheckCommand(parser, opts.check); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := addCollectCommand(parser, opts.collect); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := addFleetCommand(parser, opts.fleet); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := addDaemonCommand(parser, opts.daemon); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := addBenchCommand(parser, opts.bench); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := addRunCommand(parser, opts.run); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := addServeCommand(parser, opts.serve); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if configFile != "" {
+		chains, err := applyConfig(parser, configFile)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not apply config file %v: %v", configFile, err)
+		}
+		opts.filterChains = chains
+	}
+	if scriptFile != "" {
+		if err := gflags.NewIniParser(parser).ParseFile(scriptFile); err != nil {
+			return nil, nil, nil, fmt.Errorf("could not apply script: %v", err)
+		}
+	}
 
 	remaining, err := parser.ParseArgs(args)
 	if err != nil {
 		if flagErr, ok := err.(*gflags.Error); ok && flagErr.Type == gflags.ErrHelp {
 			os.Exit(0)
 		}
-		return fmt.Errorf("could not parse options: %v", err)
+		return nil, nil, nil, fmt.Errorf("could not parse options: %v", err)
 	}
-	if err := validateOptions(opts); err != nil {
-		return fmt.Errorf("invalid options: %v", err)
+	return opts, parser, remaining, nil
+}
+
+// applyStdinSource reads the binary profile from stdin if the profile source
+// is "-", which may follow the binary the profile is for. It returns the
+// remaining arguments to pass to pprof.
+func applyStdinSource(opts *options, remaining []string) ([]string, error) {
+	pprofOpts := &opts.PProfOptions
+	sources := append([]string{pprofOpts.BinaryFile, pprofOpts.BaseURL2}, remaining...)
+	stdinSources := 0
+	for _, source := range sources {
+		if source == pprof.StdinSource {
+			stdinSources++
+		}
+	}
+	if stdinSources == 0 {
+		return remaining, nil
+	}
+	if stdinSources > 1 {
+		return nil, fmt.Errorf("only one profile source can be read from stdin")
+	}
+	if opts.Watch > 0 {
+		return nil, fmt.Errorf("--watch cannot read the profile from stdin, which can only be read once")
 	}
 
-	return runWithOptions(opts, remaining)
+	n := len(remaining)
+	if pprofOpts.Merge || n == 0 || n > 2 || remaining[n-1] != pprof.StdinSource {
+		return remaining, nil
+	}
+	pprofOpts.BinaryFile = pprof.StdinSource
+	if n == 2 {
+		pprofOpts.BinaryName = remaining[0]
+	}
+	return nil, nil
 }
 
 func runWithOptions(allOpts *options, remaining []string) error {
-	pprofRawOutput, err := pprof.GetRaw(allOpts.PProfOptions, remaining)
-	if err != nil {
-		return fmt.Errorf("could not get raw output from pprof: %v", err)
+	opts := allOpts.OutputOpts
+
+	ctx, cancel := newContext(allOpts.Timeout)
+	defer cancel()
+
+	if allOpts.TargetSamples > 0 {
+		calibrated, err := calibrateDuration(ctx, allOpts, remaining)
+		if err != nil {
+			return err
+		}
+		allOpts = calibrated
 	}
 
-	profile, err := pprof.ParseRaw(pprofRawOutput)
-	if err != nil {
-		return fmt.Errorf("could not parse raw pprof output: %v", err)
+	if opts.OutDir != "" {
+		dir, err := runOutDir(ctx, allOpts, remaining, time.Now())
+		if err != nil {
+			return err
+		}
+		torchlog.Printf("Wrote output files and %v to %v", manifestFile, dir)
+		return nil
+	}
+	if opts.AllSamples {
+		return runAllSamples(ctx, allOpts, remaining)
 	}
 
-	sampleIndex := pprof.SelectSample(remaining, profile.SampleNames)
-	flameInput, err := renderer.ToFlameInput(profile, sampleIndex)
+	genOpts := allOpts
+	reportOnly := printsReport(opts) && opts.File == "" && opts.RawFile == ""
+	if reportOnly {
+		// Only the tables are printed, so the flame graph is not rendered.
+		rawOpts := *allOpts
+		rawOpts.OutputOpts.Raw = true
+		genOpts = &rawOpts
+	}
+	result, output, err := generate(ctx, genOpts, remaining)
 	if err != nil {
-		return fmt.Errorf("could not convert stacks to flamegraph input: %v", err)
+		return err
 	}
+	flameInput := result.FlameInput
 
-	opts := allOpts.OutputOpts
+	if allOpts.Owners != "" {
+		if err := printOwners(os.Stdout, result, opts.messages()); err != nil {
+			return err
+		}
+	}
+	if opts.Top > 0 {
+		if err := printTop(os.Stdout, result, opts.Top, opts.SortCum, opts.messages()); err != nil {
+			return err
+		}
+	}
+	if opts.CostBy != "" {
+		if err := printCost(os.Stdout, result, opts.CostBy, opts.SortCum, opts.messages()); err != nil {
+			return err
+		}
+	}
+	if allOpts.variance > 0 {
+		if err := writeVariance(os.Stdout, result, remaining, allOpts.variance); err != nil {
+			return err
+		}
+	}
+	if allOpts.blocked > 0 {
+		if err := writeBlocked(os.Stdout, result.Profile, allOpts.blocked); err != nil {
+			return err
+		}
+	}
+	if reportOnly {
+		return nil
+	}
+
+	if opts.RawFile != "" {
+		torchlog.Printf("Writing raw flamegraph input to %v", opts.RawFile)
+		if err := ioutil.WriteFile(opts.RawFile, flameInput, 0666); err != nil {
+			return fmt.Errorf("could not write raw output file: %v", err)
+		}
+		return nil
+	}
 	if opts.Raw {
 		torchlog.Print("Printing raw flamegraph input to stdout")
 		fmt.Printf("%s\n", flameInput)
 		return nil
 	}
 
-	var flameGraphArgs = buildFlameGraphArgs(opts)
-	flameGraph, err := renderer.GenerateFlameGraph(flameInput, flameGraphArgs...)
-	if err != nil {
-		return fmt.Errorf("could not generate flame graph: %v", err)
+	if opts.OTLPEndpoint != "" {
+		torchlog.Printf("Sending OTLP profile to %v", opts.OTLPEndpoint)
+		return sendOTLP(opts.OTLPEndpoint, output)
 	}
-
 	if opts.Print {
-		torchlog.Print("Printing svg to stdout")
-		fmt.Printf("%s\n", flameGraph)
+		torchlog.Printf("Printing %v to stdout", opts.OutFormat)
+		fmt.Printf("%s\n", output)
 		return nil
 	}
 
-	torchlog.Printf("Writing svg to %v", opts.File)
-	if err := ioutil.WriteFile(opts.File, flameGraph, 0666); err != nil {
+	torchlog.Printf("Writing %v to %v", opts.OutFormat, opts.File)
+	if err := ioutil.WriteFile(opts.File, output, 0666); err != nil {
 		return fmt.Errorf("could not write output file: %v", err)
 	}
 
 	return nil
 }
 
+// newContext returns a context that is cancelled after timeout (if non-zero),
+// or when the process is interrupted, so that pprof is stopped.
+func newContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	ctx, cancelSignal := context.WithCancel(ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			torchlog.Printf("Received %v, stopping", sig)
+			cancelSignal()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		cancelSignal()
+		cancel()
+	}
+}
+
+// generate returns the result with the flame graph input, and the output in
+// the requested format unless raw output is requested. The result's Profile
+// is nil for --folded-input.
+func generate(ctx context.Context, allOpts *options, remaining []string) (result *torch.Result, output []byte, err error) {
+	opts := allOpts.OutputOpts
+	if allOpts.FoldedInput != "" {
+		if len(remaining) > 0 {
+			return nil, nil, fmt.Errorf("profile sources %v cannot be used with --folded-input", remaining)
+		}
+		if allOpts.Filters != "" || allOpts.Owners != "" || allOpts.Focus != "" || allOpts.Ignore != "" || allOpts.StripRuntime != "" || allOpts.CollapseRecursion || allOpts.SplitBy != "" || allOpts.labelSelector() != nil || allOpts.samplingError().Enabled() {
+			return nil, nil, fmt.Errorf("stack filters and sampling error options cannot be used with --folded-input")
+		}
+		if allOpts.granularity() != stack.FunctionGranularity {
+			return nil, nil, fmt.Errorf("--granularity cannot be used with --folded-input, which only has function names")
+		}
+		if allOpts.PProfOptions.Sample != "" {
+			return nil, nil, fmt.Errorf("--sample cannot be used with --folded-input, which only has one sample")
+		}
+		flameInput, err := ioutil.ReadFile(allOpts.FoldedInput)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read folded input: %v", err)
+		}
+		if allOpts.Collapse != "" {
+			if flameInput, err = renderer.CollapseStacks(flameInput, allOpts.Collapse); err != nil {
+				return nil, nil, fmt.Errorf("could not collapse stacks: %v", err)
+			}
+		}
+		result := &torch.Result{FlameInput: flameInput}
+		if err := checkSource(&opts, result); err != nil {
+			return nil, nil, err
+		}
+		_, output, err := renderOutput(nil, 0, flameInput, opts)
+		return result, output, err
+	}
+
+	result, err = generateResult(ctx, allOpts, remaining)
+	if err != nil {
+		return nil, nil, err
+	}
+	output, err = renderGenerated(allOpts, result)
+	return result, output, err
+}
+
+// renderGenerated returns the output of a result from generateResult in the
+// requested format.
+func renderGenerated(allOpts *options, result *torch.Result) ([]byte, error) {
+	opts := allOpts.OutputOpts
+	if err := checkSource(&opts, result); err != nil {
+		return nil, err
+	}
+	_, output, err := renderOutput(result.Profile, result.SampleIndex, result.FlameInput, opts)
+	return output, err
+}
+
+// generateResult fetches the profile using pprof, or reads the perf input,
+// and processes it using the stack options. The flame graph is not rendered,
+// as renderOutput labels it using the selected sample type.
+func generateResult(ctx context.Context, allOpts *options, remaining []string) (*torch.Result, error) {
+	filter, err := buildFilter(allOpts)
+	if err != nil {
+		return nil, err
+	}
+	focus, err := buildFocus(allOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings := newWarningSummary()
+	defer warnings.log()
+
+	torchOpts := torch.Options{
+		PProf:            allOpts.PProfOptions,
+		Remaining:        remaining,
+		Concurrency:      allOpts.workers,
+		ExcludeFirst:     allOpts.ExcludeFirst,
+		SkipRender:       true,
+		OnWarning:        warnings.add,
+		Filter:           filter,
+		Focus:            focus,
+		Labels:           allOpts.labelSelector(),
+		SplitBy:          splitLabelKeys(allOpts.SplitBy),
+		SamplingError:    allOpts.samplingError(),
+		Granularity:      allOpts.granularity(),
+		MissingFunctions: allOpts.missingFunctions(),
+		NoInlines:        allOpts.NoInlines,
+		OnProgress:       allOpts.onProgress,
+	}
+	if allOpts.PerfInput == "" && allOpts.HeapInput == "" && allOpts.DotInput == "" && allOpts.TracebackInput == "" {
+		result, err := torch.GenerateContext(ctx, torchOpts)
+		if err != nil {
+			return nil, err
+		}
+		logSampleRate(allOpts.PProfOptions.Hz, result.Profile)
+		return result, nil
+	}
+
+	if len(remaining) > 0 {
+		return nil, fmt.Errorf("profile sources %v cannot be used with --perf-input, --heap-input, --dot-input or --traceback-input", remaining)
+	}
+	if allOpts.HeapInput != "" {
+		profile, err := readHeapInput(allOpts.HeapInput, pprof.ParseOptions{Focus: focus, Granularity: torchOpts.Granularity})
+		if err != nil {
+			return nil, err
+		}
+		// Select inuse_space by default, as for heap profiles read by pprof.
+		torchOpts.PProf.Heap = true
+		return torch.FromStacks(profile, torchOpts)
+	}
+	if allOpts.TracebackInput != "" {
+		profile, err := readTracebackInput(allOpts.TracebackInput, pprof.ParseOptions{Focus: focus, Granularity: torchOpts.Granularity})
+		if err != nil {
+			return nil, err
+		}
+		return torch.FromStacks(profile, torchOpts)
+	}
+	if torchOpts.Granularity != stack.FunctionGranularity {
+		return nil, fmt.Errorf("--granularity cannot be used with --perf-input or --dot-input, which only have function names")
+	}
+	if allOpts.DotInput != "" {
+		profile, err := readDotInput(allOpts.DotInput, pprof.ParseOptions{Focus: focus, BreakCycles: allOpts.DotBreakCycles})
+		if err != nil {
+			return nil, err
+		}
+		return torch.FromStacks(profile, torchOpts)
+	}
+	profile, err := perf.ReadFile(ctx, allOpts.PerfInput, perf.ParseOptions{
+		OnWarning:    warnings.add,
+		TimeOrdered:  allOpts.OutputOpts.FlameChart,
+		Focus:        focus,
+		ExcludeFirst: allOpts.ExcludeFirst,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read perf input: %v", err)
+	}
+	return torch.FromStacks(profile, torchOpts)
+}
+
+// readHeapInput reads and parses a text heap profile.
+func readHeapInput(file string, opts pprof.ParseOptions) (*stack.Profile, error) {
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read heap input: %v", err)
+	}
+	profile, err := pprof.ParseHeapText(input, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse heap input: %v", err)
+	}
+	if opts.Focus.Enabled() && len(profile.Samples) == 0 {
+		return nil, stack.ErrNoFocusedSamples
+	}
+	return profile, nil
+}
+
+// readTracebackInput reads and parses the goroutine tracebacks in a log file,
+// or in every file in a directory of dumps.
+func readTracebackInput(file string, opts pprof.ParseOptions) (*stack.Profile, error) {
+	if info, err := os.Stat(file); err == nil && info.IsDir() {
+		return readTracebackDir(file, opts)
+	}
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read traceback input: %v", err)
+	}
+	profile, err := pprof.ParseTraceback(input, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse traceback input: %v", err)
+	}
+	if opts.Focus.Enabled() && len(profile.Samples) == 0 {
+		return nil, stack.ErrNoFocusedSamples
+	}
+	return profile, nil
+}
+
+// readDotInput reads and parses a call graph exported by pprof -dot.
+func readDotInput(file string, opts pprof.ParseOptions) (*stack.Profile, error) {
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read dot input: %v", err)
+	}
+	profile, err := pprof.ParseDot(input, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse dot input: %v", err)
+	}
+	if opts.Focus.Enabled() && len(profile.Samples) == 0 {
+		return nil, stack.ErrNoFocusedSamples
+	}
+	return profile, nil
+}
+
+// renderOutput renders the profile in the requested format unless raw output
+// is requested. If profile is nil, it is parsed from flameInput if required.
+func renderOutput(profile *stack.Profile, sampleIdx int, flameInput []byte, opts outputOptions) ([]byte, []byte, error) {
+	if opts.Raw || opts.RawFile != "" {
+		return flameInput, nil, nil
+	}
+
+	if !isFlameGraphFormat(opts.OutFormat) {
+		if profile == nil {
+			var err error
+			if profile, err = renderer.ParseFlameInput(flameInput); err != nil {
+				return nil, nil, fmt.Errorf("could not parse flame graph input: %v", err)
+			}
+		}
+		if opts.OutFormat == "json" {
+			output, err := renderer.ToCallTree(profile, opts.fullTitle())
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not generate call tree: %v", err)
+			}
+			return flameInput, output, nil
+		}
+		if opts.OutFormat == "otlp" {
+			output, err := renderer.ToOTLP(profile, sampleIdx, opts.otlpResource(), time.Now())
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not generate OTLP profile: %v", err)
+			}
+			return flameInput, output, nil
+		}
+		if opts.OutFormat == "callgrind" {
+			output, err := renderer.ToCallgrind(profile, sampleIdx, opts.fullTitle())
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not generate callgrind profile: %v", err)
+			}
+			return flameInput, output, nil
+		}
+		output, err := renderer.ToSpeedscope(profile, sampleIdx, opts.fullTitle())
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not generate speedscope profile: %v", err)
+		}
+		return flameInput, output, nil
+	}
+
+	if profile != nil {
+		opts.sampleType = stack.ParseSampleType(profile.SampleNames[sampleIdx])
+		opts.duration = profile.Duration
+	}
+	graphInput := flameInput
+	if len(opts.Pin) > 0 {
+		graphInput = renderer.PinFrames(flameInput, opts.Pin)
+	}
+	flameGraph, err := renderer.GenerateFlameGraph(graphInput, buildFlameGraphArgs(opts)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not generate flame graph: %v", err)
+	}
+	output, err := finishFlameGraph(flameGraph, opts, &torch.Result{Profile: profile, SampleIndex: sampleIdx, FlameInput: flameInput})
+	return flameInput, output, err
+}
+
+// convertFlameGraph converts the svg generated by the flame graph script to
+// the output format.
+func convertFlameGraph(svg []byte, format string) ([]byte, error) {
+	var output []byte
+	var err error
+	switch format {
+	case "png":
+		output, err = renderer.SVGToPNG(svg)
+	case "pdf":
+		output, err = renderer.SVGToPDF(svg)
+	default:
+		return svg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not convert flame graph to %v: %v", format, err)
+	}
+	return output, nil
+}
+
+// warningSummary collects warnings by kind, so that a profile with many
+// similar problems only logs a line per kind.
+type warningSummary struct {
+	kinds  []stack.WarningKind
+	counts map[stack.WarningKind]int
+	first  map[stack.WarningKind]stack.Warning
+}
+
+func newWarningSummary() *warningSummary {
+	return &warningSummary{
+		counts: make(map[stack.WarningKind]int),
+		first:  make(map[stack.WarningKind]stack.Warning),
+	}
+}
+
+func (s *warningSummary) add(w stack.Warning) {
+	if s.counts[w.Kind] == 0 {
+		s.kinds = append(s.kinds, w.Kind)
+		s.first[w.Kind] = w
+	}
+	s.counts[w.Kind]++
+}
+
+func (s *warningSummary) log() {
+	for _, kind := range s.kinds {
+		if count := s.counts[kind]; count > 1 {
+			torchlog.Printf("Warning: %v (and %v more %v warnings)", s.first[kind], count-1, kind)
+		} else {
+			torchlog.Printf("Warning: %v", s.first[kind])
+		}
+	}
+}
+
+// granularity returns the granularity to name frames at. --granularity is
+// restricted to valid choices when it is parsed.
+func (opts *options) granularity() stack.Granularity {
+	if opts.Lines {
+		return stack.LineGranularity
+	}
+	g, _ := stack.ParseGranularity(opts.Granularity)
+	return g
+}
+
+// missingFunctions returns how frames without a function name are named.
+// --missing-functions is restricted to valid choices when it is parsed.
+func (opts *options) missingFunctions() pprof.MissingFunctions {
+	m, _ := pprof.ParseMissingFunctions(opts.MissingFunctions)
+	return m
+}
+
+// samplingError returns the sampling error options.
+func (opts *options) samplingError() stack.SamplingErrorOptions {
+	return stack.SamplingErrorOptions{
+		AnnotateBelow:     opts.ErrorBands,
+		HideInsignificant: opts.HideInsignificant,
+	}
+}
+
+// setOutputFileDefault changes the default output file to match the output
+// format, so that it does not have to be specified for non-svg output.
+// With --top or --cost-by, there is no default output file, so only the
+// tables are printed.
+func setOutputFileDefault(opts *options) {
+	if printsReport(opts.OutputOpts) && opts.OutputOpts.File == defaultOutputFile {
+		// Only the tables are printed unless an output file is specified.
+		opts.OutputOpts.File = ""
+		return
+	}
+	if opts.OutputOpts.File == defaultOutputFile {
+		opts.OutputOpts.File = strings.TrimSuffix(defaultOutputFile, ".svg") + "." + outputExt(opts.OutputOpts.OutFormat)
+	}
+}
+
+// outputExt returns the file extension for an output format.
+func outputExt(format string) string {
+	if format == "speedscope" || format == "json" || format == "otlp" {
+		return "json"
+	}
+	return format
+}
+
+// applyLogOptions sets the level, format and colors of torchlog.
+func applyLogOptions(opts *options) error {
+	if opts.Quiet && opts.Verbose {
+		return fmt.Errorf("--quiet cannot be used with --verbose")
+	}
+	switch {
+	case opts.Quiet:
+		torchlog.SetLevel(torchlog.FatalLevel)
+	case opts.Verbose:
+		torchlog.SetLevel(torchlog.DebugLevel)
+	default:
+		torchlog.SetLevel(torchlog.InfoLevel)
+	}
+	torchlog.SetJSON(opts.LogJSON)
+	torchlog.SetColor(!opts.NoColor)
+	return nil
+}
+
 func validateOptions(opts *options) error {
 	file := opts.OutputOpts.File
-	if file != "" && !strings.HasSuffix(file, ".svg") {
-		return fmt.Errorf("output file must end in .svg")
+	switch format := opts.OutputOpts.OutFormat; format {
+	case "svg", "png", "pdf", "callgrind":
+		if file != "" && !strings.HasSuffix(file, "."+format) {
+			return fmt.Errorf("output file must end in .%v", format)
+		}
+	case "speedscope", "json", "otlp":
+		if file != "" && !strings.HasSuffix(file, ".json") {
+			return fmt.Errorf("output file must end in .json for %v output", format)
+		}
+	default:
+		return fmt.Errorf("unknown output format %q, expected svg, png, pdf, speedscope, json, callgrind or otlp", opts.OutputOpts.OutFormat)
 	}
 	if opts.PProfOptions.TimeSeconds < 1 {
 		return fmt.Errorf("seconds must be an integer greater than 0")
 	}
+	inputs := 0
+	for _, input := range []string{opts.FoldedInput, opts.PerfInput, opts.HeapInput, opts.DotInput, opts.TracebackInput} {
+		if input != "" {
+			inputs++
+		}
+	}
+	if inputs > 1 {
+		return fmt.Errorf("only one of --folded-input, --perf-input, --heap-input, --dot-input and --traceback-input can be used")
+	}
+	if opts.Collapse != "" && opts.FoldedInput == "" {
+		return fmt.Errorf("--collapse requires --folded-input")
+	}
+	if opts.DotBreakCycles && opts.DotInput == "" {
+		return fmt.Errorf("--dot-break-cycles requires --dot-input")
+	}
+	if opts.PProfOptions.GCBeforeHeap {
+		if !opts.PProfOptions.Heap {
+			return fmt.Errorf("--gc-before-heap requires --heap")
+		}
+		if inputs > 0 || opts.PProfOptions.BinaryFile != "" {
+			return fmt.Errorf("--gc-before-heap only applies to heap profiles fetched from a URL")
+		}
+	}
+	if opts.PProfOptions.Hz < 0 {
+		return fmt.Errorf("hz must not be negative")
+	}
+	if opts.TargetSamples < 0 {
+		return fmt.Errorf("target samples must not be negative")
+	}
+	if opts.TargetSamples > 0 {
+		p := opts.PProfOptions
+		if p.Heap || p.Block || p.Mutex || p.Goroutine {
+			return fmt.Errorf("--target-samples only applies to CPU profiles")
+		}
+		if inputs > 0 || p.BinaryFile != "" {
+			return fmt.Errorf("--target-samples only applies to CPU profiles fetched from a URL")
+		}
+	}
+	if opts.PProfOptions.Hz > 0 {
+		p := opts.PProfOptions
+		if p.Heap || p.Block || p.Mutex || p.Goroutine {
+			return fmt.Errorf("--hz only applies to CPU profiles")
+		}
+		if inputs > 0 || p.BinaryFile != "" {
+			return fmt.Errorf("--hz only applies to CPU profiles fetched from a URL")
+		}
+	}
+	if opts.MissingFunctions != "keep" && inputs > 0 {
+		return fmt.Errorf("--missing-functions requires a pprof profile")
+	}
+	if opts.NoInlines && inputs > 0 {
+		return fmt.Errorf("--no-inlines requires a pprof profile")
+	}
+	if opts.Lines && opts.Granularity != "function" && opts.Granularity != "line" {
+		return fmt.Errorf("--lines cannot be used with --granularity %v", opts.Granularity)
+	}
+	if opts.PProfOptions.BaseURL2 != "" {
+		if inputs > 0 {
+			return fmt.Errorf("--base-url2 cannot be used with --folded-input, --perf-input, --heap-input, --dot-input or --traceback-input")
+		}
+		if !isFlameGraphFormat(opts.OutputOpts.OutFormat) {
+			return fmt.Errorf("--base-url2 only supports flame graph output")
+		}
+	}
+	if opts.OutputOpts.OutDir != "" {
+		if opts.OutputOpts.Print || opts.OutputOpts.RawFile != "" {
+			return fmt.Errorf("--out-dir cannot be used with --print or --raw-file")
+		}
+		if opts.FoldedInput != "" {
+			return fmt.Errorf("--out-dir cannot be used with --folded-input")
+		}
+	}
+	if opts.OutputOpts.AllSamples {
+		if opts.OutputOpts.OutDir != "" {
+			return fmt.Errorf("--all-samples cannot be used with --out-dir, which already writes every sample type")
+		}
+		if opts.OutputOpts.Print || opts.OutputOpts.Raw || opts.OutputOpts.RawFile != "" || opts.OutputOpts.OTLPEndpoint != "" {
+			return fmt.Errorf("--all-samples cannot be used with --print, --raw, --raw-file or --otlp-endpoint, which write a single output")
+		}
+		if opts.FoldedInput != "" {
+			return fmt.Errorf("--all-samples cannot be used with --folded-input, which only has one sample")
+		}
+		if printsReport(opts.OutputOpts) {
+			return fmt.Errorf("--top and --cost-by cannot be used with --all-samples")
+		}
+	}
+	if opts.OutputOpts.Annotations != "" && !rendersSVG(opts.OutputOpts) {
+		return fmt.Errorf("--annotations only supports flame graph output")
+	}
+	if opts.OutputOpts.GitRange != "" && !rendersSVG(opts.OutputOpts) {
+		return fmt.Errorf("--git-range only supports flame graph output")
+	}
+	if opts.OutputOpts.SourceRoot != "" && !rendersSVG(opts.OutputOpts) {
+		return fmt.Errorf("--source-root only supports flame graph output")
+	}
+	if err := validateOTLP(opts.OutputOpts); err != nil {
+		return err
+	}
+	if opts.OutputOpts.ShowSelf && !rendersSVG(opts.OutputOpts) {
+		return fmt.Errorf("--show-self only supports flame graph output")
+	}
+	if opts.OutputOpts.SortCum && !printsReport(opts.OutputOpts) {
+		return fmt.Errorf("--sort-cum requires --top or --cost-by")
+	}
+	if opts.Owners != "" && (opts.OutputOpts.Print || opts.OutputOpts.Raw) {
+		return fmt.Errorf("--owners cannot be used with --print or --raw, which also write to stdout")
+	}
+	if opts.OutputOpts.Top < 0 {
+		return fmt.Errorf("top must not be negative")
+	}
+	if printsReport(opts.OutputOpts) {
+		if opts.OutputOpts.Print || opts.OutputOpts.Raw {
+			return fmt.Errorf("--top and --cost-by cannot be used with --print or --raw, which also write to stdout")
+		}
+		if opts.OutputOpts.OutDir != "" {
+			return fmt.Errorf("--top and --cost-by cannot be used with --out-dir")
+		}
+	}
+	if opts.OutputOpts.FlameChart {
+		if opts.PerfInput == "" && opts.FoldedInput == "" {
+			return fmt.Errorf("--flamechart requires --perf-input or --folded-input, as pprof profiles do not record when samples were taken")
+		}
+		if !isFlameGraphFormat(opts.OutputOpts.OutFormat) {
+			return fmt.Errorf("--flamechart only supports flame graph output")
+		}
+	}
+	if len(opts.OutputOpts.Pin) > 0 {
+		if opts.OutputOpts.FlameChart || opts.OutputOpts.Reverse {
+			return fmt.Errorf("--pin cannot be used with --flamechart or --reverse, which order frames differently")
+		}
+		if !isFlameGraphFormat(opts.OutputOpts.OutFormat) {
+			return fmt.Errorf("--pin only supports flame graph output")
+		}
+	}
+	if opts.OutputOpts.FontSize < 0 {
+		return fmt.Errorf("fontsize must not be negative")
+	}
+	if opts.OutputOpts.MinWidth != "" && !minWidthRE.MatchString(opts.OutputOpts.MinWidth) {
+		return fmt.Errorf("invalid --minwidth %q, expected pixels (e.g. 0.5) or a percentage (e.g. 0.1%%)", opts.OutputOpts.MinWidth)
+	}
+	if opts.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if err := validateExcludeFirst(opts); err != nil {
+		return err
+	}
+	for _, label := range opts.Labels {
+		if parts := strings.SplitN(label, "=", 2); len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("label %q must be in the form key=value", label)
+		}
+	}
+	if opts.TraceOnly {
+		if opts.TraceID == "" {
+			return fmt.Errorf("--trace-only requires --trace-id")
+		}
+		if opts.TraceLabel == "" {
+			return fmt.Errorf("--trace-only requires a --trace-label")
+		}
+	}
+	if opts.ErrorBands < 0 {
+		return fmt.Errorf("error bands must not be negative")
+	}
+	if opts.Watch < 0 {
+		return fmt.Errorf("watch interval must not be negative")
+	}
 
 	// extra FlameGraph options
 	if opts.OutputOpts.Title == "" {
@@ -147,26 +1124,241 @@ func validateOptions(opts *options) error {
 		case "hot", "mem", "io", "wakeup", "chain", "java", "js", "perl", "red", "green", "blue", "aqua", "yellow", "purple", "orange":
 			// valid
 		default:
-			return fmt.Errorf("unknown flamegraph colors %q", opts.OutputOpts.Colors)
+			if _, ok := colorBlindPalettes[opts.OutputOpts.Colors]; !ok {
+				return fmt.Errorf("unknown flamegraph colors %q", opts.OutputOpts.Colors)
+			}
 		}
 	}
 
 	return nil
 }
 
+// validateExcludeFirst checks that the warm-up period can be dropped, which
+// requires timestamps (perf) or capturing a live CPU profile.
+func validateExcludeFirst(opts *options) error {
+	switch {
+	case opts.ExcludeFirst < 0:
+		return fmt.Errorf("--exclude-first must not be negative")
+	case opts.ExcludeFirst == 0 || opts.PerfInput != "":
+		return nil
+	case opts.FoldedInput != "" || opts.HeapInput != "" || opts.DotInput != "" || opts.TracebackInput != "" || opts.PProfOptions.BinaryFile != "":
+		return fmt.Errorf("--exclude-first requires --perf-input or a CPU profile that is captured by go-torch, as other inputs do not record when samples were taken")
+	case opts.PProfOptions.Heap || opts.PProfOptions.Block || opts.PProfOptions.Mutex || opts.PProfOptions.Goroutine:
+		return fmt.Errorf("--exclude-first requires a CPU profile, as --heap, --block, --mutex and --goroutine profiles are snapshots")
+	case opts.ExcludeFirst%time.Second != 0:
+		return fmt.Errorf("--exclude-first must be whole seconds for CPU profiles, which are captured in seconds")
+	}
+	return nil
+}
+
+// rendersSVG returns whether the output is a flame graph generated by the
+// flame graph script, which may then be converted to png or pdf.
+func rendersSVG(opts outputOptions) bool {
+	return !opts.Raw && opts.RawFile == "" && isFlameGraphFormat(opts.OutFormat)
+}
+
+// printsReport returns whether a table, such as --top, is printed to stdout.
+func printsReport(opts outputOptions) bool {
+	return opts.Top > 0 || opts.CostBy != ""
+}
+
+// validateTarget returns an error if an option that finds the program to
+// profile, such as --k8s, is used with options that choose another profile
+// source.
+func validateTarget(name string, opts *options, command string, remaining []string) error {
+	pprofOpts := opts.PProfOptions
+	switch {
+	case opts.K8s != "" && opts.Docker != "":
+		return fmt.Errorf("only one of --k8s and --docker can be used")
+	case command == "diff" || command == "convert" || command == "dumps" || command == "collect" || command == "fleet" || command == "daemon" || command == "bench":
+		return fmt.Errorf("%v cannot be used with the %v command", name, command)
+	case len(remaining) > 0 || pprofOpts.BinaryFile != "" || pprofOpts.Merge || pprofOpts.BaseURL2 != "":
+		return fmt.Errorf("%v cannot be used with other profile sources, --merge or --base-url2", name)
+	case opts.FoldedInput != "" || opts.PerfInput != "" || opts.HeapInput != "" || opts.DotInput != "" || opts.TracebackInput != "":
+		return fmt.Errorf("%v cannot be used with --folded-input, --perf-input, --heap-input, --dot-input or --traceback-input", name)
+	}
+	return nil
+}
+
+// graphTitle returns the flame graph title, which has the sample type and
+// the duration of the profile if they are known, e.g. Flame Graph (cpu, 30s).
+func (opts outputOptions) graphTitle() string {
+	if opts.PlainTitle || opts.sampleType.Name == "" {
+		return opts.title()
+	}
+	details := []string{opts.sampleType.Name}
+	if opts.duration > 0 {
+		details = append(details, roundDuration(opts.duration).String())
+	}
+	return fmt.Sprintf("%v (%v)", opts.title(), strings.Join(details, ", "))
+}
+
+// title returns the --title, or the default title in the --lang language.
+func (opts outputOptions) title() string {
+	if opts.Title == english.flameGraph {
+		return opts.messages().flameGraph
+	}
+	return opts.Title
+}
+
+// messages returns the messages in the --lang language.
+func (opts outputOptions) messages() *messages {
+	return language(opts.Lang)
+}
+
+// nameType returns the --nametype for flame graphs, which is the label of
+// the function in the --lang language unless it is set. It is empty for
+// English, which is the default of the flame graph script.
+func (opts outputOptions) nameType() string {
+	if opts.NameType != "" || opts.messages() == english {
+		return opts.NameType
+	}
+	return opts.messages().nameType
+}
+
+// countName returns the --countname for flame graphs, which is the unit of
+// the sample type unless it is set.
+func (opts outputOptions) countName() string {
+	if opts.CountName != "" {
+		return opts.CountName
+	}
+	return opts.messages().countName(opts.sampleType.CountName())
+}
+
+// roundDuration rounds durations of a second or more to the nearest second,
+// as profile durations include the time taken to write the profile.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d
+	}
+	return (d + time.Second/2) / time.Second * time.Second
+}
+
+// logSampleRate logs the effective CPU sampling rate of the profile, and
+// warns if it differs from the rate requested with --hz, since targets that
+// use net/http/pprof ignore the hz parameter.
+func logSampleRate(hz int, profile *stack.Profile) {
+	if profile == nil || profile.Period <= 0 {
+		return
+	}
+	rate := sampleRate(profile.Period)
+	if hz > 0 && math.Abs(rate-float64(hz)) > 0.5 {
+		torchlog.Printf("Warning: requested --hz %v but the target sampled at %.0f Hz", hz, rate)
+		return
+	}
+	torchlog.Debugf("Profile sampled at %.0f Hz", rate)
+}
+
+// sampleRate returns the number of CPU samples per second for period.
+func sampleRate(period time.Duration) float64 {
+	return float64(time.Second) / float64(period)
+}
+
+// collectionSubtitle returns the subtitle describing how the profile was
+// collected: after a forced GC for --gc-before-heap, and for --trace-id.
+func collectionSubtitle(opts *options) string {
+	msgs := opts.OutputOpts.messages()
+	var subtitles []string
+	if opts.PProfOptions.GCBeforeHeap {
+		subtitles = append(subtitles, msgs.gcSubtitle)
+	}
+	if opts.TraceID != "" {
+		subtitles = append(subtitles, msgs.trace+" "+opts.TraceID)
+	}
+	return strings.Join(subtitles, "; ")
+}
+
+// fullSubtitle returns the --subtitle followed by the subtitle describing
+// how the profile was collected, if either is set.
+func (opts outputOptions) fullSubtitle() string {
+	switch {
+	case opts.Subtitle == "":
+		return opts.subtitle
+	case opts.subtitle == "":
+		return opts.Subtitle
+	}
+	return opts.Subtitle + "; " + opts.subtitle
+}
+
+// fullTitle returns the title including the subtitle, for output formats
+// that only have a title.
+func (opts outputOptions) fullTitle() string {
+	subtitle := opts.fullSubtitle()
+	if subtitle == "" {
+		return opts.title()
+	}
+	return fmt.Sprintf("%v (%v)", opts.title(), subtitle)
+}
+
+// isFlameGraphFormat returns whether the output format is a flame graph,
+// rather than a profile or call tree that is generated without the script.
+func isFlameGraphFormat(format string) bool {
+	switch format {
+	case "svg", "png", "pdf":
+		return true
+	}
+	return false
+}
+
+// colorBlindSafe is the palette that colors frames using
+// renderer.ColorBlindSafeColors after flamegraph.pl renders them.
+const colorBlindSafe = "cb-safe"
+
+// colorBlindPalettes maps the cb- palettes to the flamegraph.pl palette that
+// they are rendered with. cb-safe frames are then recolored, except in
+// differential flame graphs, while cb-aqua and cb-orange are aliases of the
+// single-hue aqua and orange palettes.
+var colorBlindPalettes = map[string]string{
+	"cb-safe":   "blue",
+	"cb-aqua":   "aqua",
+	"cb-orange": "orange",
+}
+
+// flameGraphColors returns the flamegraph.pl palette for colors.
+func flameGraphColors(colors string) string {
+	if palette, ok := colorBlindPalettes[colors]; ok {
+		return palette
+	}
+	return colors
+}
+
 func buildFlameGraphArgs(opts outputOptions) []string {
 	var args []string
 
 	if opts.Title != "" {
-		args = append(args, "--title", opts.Title)
+		args = append(args, "--title", opts.graphTitle())
+	}
+
+	if subtitle := opts.fullSubtitle(); subtitle != "" {
+		args = append(args, "--subtitle", subtitle)
 	}
 
 	if opts.Width > 0 {
 		args = append(args, "--width", strconv.FormatInt(opts.Width, 10))
 	}
 
+	if opts.FontSize > 0 {
+		args = append(args, "--fontsize", strconv.FormatInt(opts.FontSize, 10))
+	}
+
+	if opts.FontType != "" {
+		args = append(args, "--fonttype", opts.FontType)
+	}
+
+	if opts.MinWidth != "" {
+		args = append(args, "--minwidth", opts.MinWidth)
+	}
+
+	if nameType := opts.nameType(); nameType != "" {
+		args = append(args, "--nametype", nameType)
+	}
+
+	if countName := opts.countName(); countName != "" {
+		args = append(args, "--countname", countName)
+	}
+
 	if opts.Colors != "" {
-		args = append(args, "--colors", opts.Colors)
+		args = append(args, "--colors", flameGraphColors(opts.Colors))
 	}
 
 	if opts.Hash {
@@ -185,5 +1377,15 @@ func buildFlameGraphArgs(opts outputOptions) []string {
 		args = append(args, "--inverted")
 	}
 
+	if opts.Negate {
+		args = append(args, "--negate")
+	}
+
+	// Pinned frames are kept in the order of the input by rendering it as a
+	// flame chart, which flamegraph.pl does not sort.
+	if opts.FlameChart || len(opts.Pin) > 0 {
+		args = append(args, "--flamechart")
+	}
+
 	return args
 }
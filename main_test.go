@@ -22,17 +22,27 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/uber/go-torch/stack"
 
 	gflags "github.com/jessevdk/go-flags"
 )
 
-const testPProfInputFile = "./pprof/testdata/pprof.1.pb.gz"
+const (
+	testPProfInputFile = "./pprof/testdata/pprof.1.pb.gz"
+	testHeapTextFile   = "./pprof/testdata/heap-debug1.txt"
+	testDotFile        = "./pprof/testdata/pprof.dot"
+	testTracebackFile  = "./pprof/testdata/traceback.log"
+)
 
 func getDefaultOptions() *options {
 	opts := &options{}
@@ -81,6 +91,118 @@ func TestInvalidOptions(t *testing.T) {
 			args:         []string{"--file", "bad.jpg"},
 			errorMessage: "must end in .svg",
 		},
+		{
+			args:         []string{"--quiet", "--verbose"},
+			errorMessage: "--quiet cannot be used with --verbose",
+		},
+		{
+			args:         []string{"--out-format", "speedscope", "--file", "out.svg"},
+			errorMessage: "must end in .json for speedscope output",
+		},
+		{
+			args:         []string{"--out-format", "callgrind", "--file", "out.json"},
+			errorMessage: "output file must end in .callgrind",
+		},
+		{
+			args:         []string{"--trace-only"},
+			errorMessage: "--trace-only requires --trace-id",
+		},
+		{
+			args:         []string{"--trace-only", "--trace-id", "abc", "--trace-label", ""},
+			errorMessage: "--trace-only requires a --trace-label",
+		},
+		{
+			args:         []string{"--fontsize", "-1"},
+			errorMessage: "fontsize must not be negative",
+		},
+		{
+			args:         []string{"--minwidth", "1px"},
+			errorMessage: `invalid --minwidth "1px"`,
+		},
+		{
+			args:         []string{"--collapse", "gdb"},
+			errorMessage: "--collapse requires --folded-input",
+		},
+		{
+			args:         []string{"--otlp-service", "svc"},
+			errorMessage: "require --out-format otlp",
+		},
+		{
+			args:         []string{"--out-format", "otlp"},
+			errorMessage: "--out-format otlp requires --otlp-service",
+		},
+		{
+			args:         []string{"--out-format", "otlp", "--otlp-service", "svc", "--otlp-resource", "region"},
+			errorMessage: `invalid --otlp-resource "region", expected key=value`,
+		},
+		{
+			args:         []string{"--out-format", "otlp", "--otlp-service", "svc", "--otlp-resource", "service.name=other"},
+			errorMessage: "use --otlp-service rather than --otlp-resource",
+		},
+		{
+			args:         []string{"--out-format", "otlp", "--otlp-service", "svc", "--otlp-endpoint", "http://localhost:4318", "--print"},
+			errorMessage: "--otlp-endpoint cannot be used with --print or --out-dir",
+		},
+		{
+			args:         []string{"--out-format", "json", "--file", "out.svg"},
+			errorMessage: "must end in .json for json output",
+		},
+		{
+			args:         []string{"--out-format", "gif"},
+			errorMessage: "unknown output format \"gif\"",
+		},
+		{
+			args:         []string{"--lines", "--granularity", "package"},
+			errorMessage: "--lines cannot be used with --granularity package",
+		},
+		{
+			args:         []string{"--gc-before-heap"},
+			errorMessage: "--gc-before-heap requires --heap",
+		},
+		{
+			args:         []string{"--heap", "--gc-before-heap", "--binaryinput", "heap.prof"},
+			errorMessage: "only applies to heap profiles fetched from a URL",
+		},
+		{
+			args:         []string{"--target-samples", "-1"},
+			errorMessage: "target samples must not be negative",
+		},
+		{
+			args:         []string{"--target-samples", "5000", "--mutex"},
+			errorMessage: "--target-samples only applies to CPU profiles",
+		},
+		{
+			args:         []string{"--target-samples", "5000", "--folded-input", "stacks.txt"},
+			errorMessage: "only applies to CPU profiles fetched from a URL",
+		},
+		{
+			args:         []string{"--dot-break-cycles"},
+			errorMessage: "--dot-break-cycles requires --dot-input",
+		},
+		{
+			args:         []string{"--hz", "-1"},
+			errorMessage: "hz must not be negative",
+		},
+		{
+			args:         []string{"--hz", "250", "--heap"},
+			errorMessage: "--hz only applies to CPU profiles",
+		},
+		{
+			args:         []string{"--hz", "250", "--binaryinput", "cpu.prof"},
+			errorMessage: "only applies to CPU profiles fetched from a URL",
+		},
+		{
+			args:         []string{"--base-url2", "-", "-"},
+			errorMessage: "only one profile source can be read from stdin",
+		},
+		{
+			args:         []string{"--watch", "1m", "-"},
+			errorMessage: "--watch cannot read the profile from stdin",
+		},
+		{
+			args:         []string{"--out-format", "png", "--file", "out.svg"},
+			errorMessage: "must end in .png",
+		},
 		{
 			args:         []string{"-t", "0"},
 			errorMessage: "seconds must be an integer greater than 0",
@@ -93,6 +215,130 @@ func TestInvalidOptions(t *testing.T) {
 			args:         []string{"--width", "0"},
 			errorMessage: "flamegraph default width is 1200 pixels",
 		},
+		{
+			args:         []string{"--base-url2", "http://production:8080", "--perf-input", "perf.data"},
+			errorMessage: "--base-url2 cannot be used with --folded-input, --perf-input, --heap-input, --dot-input or --traceback-input",
+		},
+		{
+			args:         []string{"--base-url2", "http://production:8080", "--out-format", "speedscope"},
+			errorMessage: "--base-url2 only supports flame graph output",
+		},
+		{
+			args:         []string{"--annotations", "notes", "--out-format", "speedscope"},
+			errorMessage: "--annotations only supports flame graph output",
+		},
+		{
+			args:         []string{"--git-range", "v1.2.0..HEAD", "--raw"},
+			errorMessage: "--git-range only supports flame graph output",
+		},
+		{
+			args:         []string{"--no-inlines", "--folded-input", "stacks.folded"},
+			errorMessage: "--no-inlines requires a pprof profile",
+		},
+		{
+			args:         []string{"--source-root", "./", "--out-format", "speedscope", "-f", "torch.json"},
+			errorMessage: "--source-root only supports flame graph output",
+		},
+		{
+			args:         []string{"--show-self", "--out-format", "json"},
+			errorMessage: "--show-self only supports flame graph output",
+		},
+		{
+			args:         []string{"--sort-cum"},
+			errorMessage: "--sort-cum requires --top or --cost-by",
+		},
+		{
+			args:         []string{"--top", "-1"},
+			errorMessage: "top must not be negative",
+		},
+		{
+			args:         []string{"--top", "10", "--raw"},
+			errorMessage: "--top and --cost-by cannot be used with --print or --raw",
+		},
+		{
+			args:         []string{"--cost-by", "module", "--print"},
+			errorMessage: "--top and --cost-by cannot be used with --print or --raw",
+		},
+		{
+			args:         []string{"--top", "10", "--out-dir", "results"},
+			errorMessage: "--top and --cost-by cannot be used with --out-dir",
+		},
+		{
+			args:         []string{"--out-dir", "results", "--print"},
+			errorMessage: "--out-dir cannot be used with --print or --raw-file",
+		},
+		{
+			args:         []string{"--out-dir", "results", "--folded-input", "stacks.folded"},
+			errorMessage: "--out-dir cannot be used with --folded-input",
+		},
+		{
+			args:         []string{"--flamechart"},
+			errorMessage: "--flamechart requires --perf-input or --folded-input, as pprof profiles do not record when samples were taken",
+		},
+		{
+			args:         []string{"--flamechart", "--perf-input", "perf.data", "--out-format", "speedscope"},
+			errorMessage: "--flamechart only supports flame graph output",
+		},
+		{
+			args:         []string{"--pin", "main.main", "--flamechart", "--perf-input", "perf.data"},
+			errorMessage: "--pin cannot be used with --flamechart or --reverse, which order frames differently",
+		},
+		{
+			args:         []string{"--pin", "main.main", "--out-format", "speedscope"},
+			errorMessage: "--pin only supports flame graph output",
+		},
+		{
+			args:         []string{"--all-samples", "--out-dir", "results"},
+			errorMessage: "--all-samples cannot be used with --out-dir, which already writes every sample type",
+		},
+		{
+			args:         []string{"--all-samples", "--raw"},
+			errorMessage: "--all-samples cannot be used with --print, --raw, --raw-file or --otlp-endpoint, which write a single output",
+		},
+		{
+			args:         []string{"--all-samples", "--folded-input", "stacks.folded"},
+			errorMessage: "--all-samples cannot be used with --folded-input, which only has one sample",
+		},
+		{
+			args:         []string{"--all-samples", "--top", "10"},
+			errorMessage: "--top and --cost-by cannot be used with --all-samples",
+		},
+		{
+			args:         []string{"--timeout", "-1s"},
+			errorMessage: "timeout must not be negative",
+		},
+		{
+			args:         []string{"--exclude-first", "-5s"},
+			errorMessage: "--exclude-first must not be negative",
+		},
+		{
+			args:         []string{"--exclude-first", "5s", "--binaryinput", "cpu.prof"},
+			errorMessage: "--exclude-first requires --perf-input or a CPU profile that is captured by go-torch, as other inputs do not record when samples were taken",
+		},
+		{
+			args:         []string{"--exclude-first", "5s", "--heap"},
+			errorMessage: "--exclude-first requires a CPU profile, as --heap, --block, --mutex and --goroutine profiles are snapshots",
+		},
+		{
+			args:         []string{"--exclude-first", "2500ms"},
+			errorMessage: "--exclude-first must be whole seconds for CPU profiles, which are captured in seconds",
+		},
+		{
+			args:         []string{"--folded-input", "stacks.folded", "--perf-input", "perf.data"},
+			errorMessage: "only one of --folded-input, --perf-input, --heap-input, --dot-input and --traceback-input can be used",
+		},
+		{
+			args:         []string{"--label", "handler"},
+			errorMessage: "label \"handler\" must be in the form key=value",
+		},
+		{
+			args:         []string{"--error-bands", "-1"},
+			errorMessage: "error bands must not be negative",
+		},
+		{
+			args:         []string{"--watch", "-1m"},
+			errorMessage: "watch interval must not be negative",
+		},
 		{
 			args:         []string{"--colors", "foo"},
 			errorMessage: "unknown flamegraph colors \"foo\"",
@@ -112,6 +358,188 @@ func TestInvalidOptions(t *testing.T) {
 	}
 }
 
+func TestApplyStdinSource(t *testing.T) {
+	tests := []struct {
+		args           []string
+		wantBinaryFile string
+		wantBinaryName string
+		wantRemaining  []string
+	}{
+		{
+			args:           []string{"-"},
+			wantBinaryFile: "-",
+		},
+		{
+			args:           []string{"app", "-"},
+			wantBinaryFile: "-",
+			wantBinaryName: "app",
+		},
+		{
+			args:          []string{"--merge", "a.pb.gz", "-"},
+			wantRemaining: []string{"a.pb.gz", "-"},
+		},
+		{
+			args:          []string{"app", "profile.pb.gz"},
+			wantRemaining: []string{"app", "profile.pb.gz"},
+		},
+	}
+
+	for _, tt := range tests {
+		opts, _, remaining, err := parseArgs(tt.args, "", "")
+		if err != nil {
+			t.Fatalf("parseArgs(%v) failed: %v", tt.args, err)
+		}
+		remaining, err = applyStdinSource(opts, remaining)
+		if err != nil {
+			t.Errorf("applyStdinSource(%v) failed: %v", tt.args, err)
+			continue
+		}
+		pprofOpts := opts.PProfOptions
+		if pprofOpts.BinaryFile != tt.wantBinaryFile || pprofOpts.BinaryName != tt.wantBinaryName {
+			t.Errorf("applyStdinSource(%v) got binary file %q and binary %q, want %q and %q",
+				tt.args, pprofOpts.BinaryFile, pprofOpts.BinaryName, tt.wantBinaryFile, tt.wantBinaryName)
+		}
+		if !reflect.DeepEqual(remaining, tt.wantRemaining) {
+			t.Errorf("applyStdinSource(%v) got remaining %v, want %v", tt.args, remaining, tt.wantRemaining)
+		}
+	}
+}
+
+func TestRunSpeedscope(t *testing.T) {
+	file := getTempFilename(t, ".json")
+	defer os.Remove(file)
+
+	if err := runWithArgs("--binaryinput", testPProfInputFile, "--out-format", "speedscope", "--file", file); err != nil {
+		t.Fatalf("Run with speedscope output failed: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	var profile struct {
+		Profiles []struct {
+			Name string `json:"name"`
+		} `json:"profiles"`
+	}
+	if err := json.Unmarshal(out, &profile); err != nil {
+		t.Fatalf("Output is not valid JSON: %v", err)
+	}
+	if len(profile.Profiles) != 2 || profile.Profiles[1].Name != "cpu/nanoseconds" {
+		t.Errorf("Unexpected speedscope profiles: %+v", profile.Profiles)
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	file := getTempFilename(t, ".json")
+	defer os.Remove(file)
+
+	if err := runWithArgs("--binaryinput", testPProfInputFile, "--out-format", "json", "--file", file); err != nil {
+		t.Fatalf("Run with json output failed: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	var tree struct {
+		SampleTypes []string `json:"sampleTypes"`
+		Root        struct {
+			Total    []int64           `json:"total"`
+			Children []json.RawMessage `json:"children"`
+		} `json:"root"`
+	}
+	if err := json.Unmarshal(out, &tree); err != nil {
+		t.Fatalf("Output is not valid JSON: %v", err)
+	}
+	if len(tree.SampleTypes) != 2 || tree.SampleTypes[1] != "cpu/nanoseconds" {
+		t.Errorf("Unexpected sample types: %v", tree.SampleTypes)
+	}
+	if len(tree.Root.Total) != 2 || tree.Root.Total[0] == 0 || len(tree.Root.Children) == 0 {
+		t.Errorf("Unexpected call tree root: %+v", tree.Root)
+	}
+}
+
+func TestConvertFlameGraph(t *testing.T) {
+	svg := []byte(`<svg width="10" height="10"><rect x="0" y="0" width="10" height="10" fill="rgb(1,2,3)" /></svg>`)
+	tests := []struct {
+		format string
+		prefix string
+	}{
+		{"svg", "<svg"},
+		{"png", "\x89PNG"},
+		{"pdf", "%PDF-"},
+	}
+	for _, tt := range tests {
+		out, err := convertFlameGraph(svg, tt.format)
+		if err != nil {
+			t.Errorf("convertFlameGraph to %v failed: %v", tt.format, err)
+			continue
+		}
+		if !strings.HasPrefix(string(out), tt.prefix) {
+			t.Errorf("convertFlameGraph to %v got unexpected output %q", tt.format, out)
+		}
+	}
+
+	if _, err := convertFlameGraph([]byte("not svg"), "png"); err == nil || !strings.Contains(err.Error(), "could not convert flame graph to png") {
+		t.Errorf("convertFlameGraph of invalid svg got unexpected error: %v", err)
+	}
+}
+
+func TestRunCallgrind(t *testing.T) {
+	file := getTempFilename(t, ".callgrind")
+	defer os.Remove(file)
+
+	if err := runWithArgs("--binaryinput", testPProfInputFile, "--out-format", "callgrind", "--file", file); err != nil {
+		t.Fatalf("Run with callgrind output failed: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if !strings.HasPrefix(string(out), "# callgrind format\n") || !strings.Contains(string(out), "\nevents: samples cpu\n") {
+		t.Errorf("Unexpected callgrind output:\n%s", out)
+	}
+}
+
+func TestSetOutputFileDefault(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.OutputOpts.OutFormat = "speedscope"
+	setOutputFileDefault(opts)
+	if opts.OutputOpts.File != "torch.json" {
+		t.Errorf("Expected default speedscope output file torch.json, got %v", opts.OutputOpts.File)
+	}
+
+	opts.OutputOpts.File = defaultOutputFile
+	opts.OutputOpts.OutFormat = "pdf"
+	setOutputFileDefault(opts)
+	if opts.OutputOpts.File != "torch.pdf" {
+		t.Errorf("Expected default pdf output file torch.pdf, got %v", opts.OutputOpts.File)
+	}
+
+	opts.OutputOpts.File = "custom.json"
+	setOutputFileDefault(opts)
+	if opts.OutputOpts.File != "custom.json" {
+		t.Errorf("Explicit output file should not be changed, got %v", opts.OutputOpts.File)
+	}
+}
+
+func TestSetOutputFileDefaultTop(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.OutputOpts.Top = 10
+	setOutputFileDefault(opts)
+	if opts.OutputOpts.File != "" {
+		t.Errorf("Expected no default output file with --top, got %v", opts.OutputOpts.File)
+	}
+
+	opts.OutputOpts.File = "custom.svg"
+	setOutputFileDefault(opts)
+	if opts.OutputOpts.File != "custom.svg" {
+		t.Errorf("Explicit output file should not be changed, got %v", opts.OutputOpts.File)
+	}
+}
+
 func TestRunRaw(t *testing.T) {
 	opts := getDefaultOptions()
 	opts.OutputOpts.Raw = true
@@ -121,6 +549,448 @@ func TestRunRaw(t *testing.T) {
 	}
 }
 
+func TestRunRawFile(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.OutputOpts.RawFile = getTempFilename(t, ".folded")
+	defer os.Remove(opts.OutputOpts.RawFile)
+
+	if err := runWithOptions(opts, nil); err != nil {
+		t.Fatalf("Run with RawFile failed: %v", err)
+	}
+
+	contents, err := ioutil.ReadFile(opts.OutputOpts.RawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw output file: %v", err)
+	}
+	if !strings.Contains(string(contents), "main.fib") {
+		t.Errorf("Raw output file is missing stacks, got:\n%s", contents)
+	}
+}
+
+func TestRunBadRawFile(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.OutputOpts.RawFile = "/dev/zero/invalid/file"
+
+	if err := runWithOptions(opts, nil); err == nil {
+		t.Fatalf("Run with bad raw file expected to fail")
+	}
+}
+
+func TestRunFoldedInput(t *testing.T) {
+	input := getTempFilename(t, ".folded")
+	defer os.Remove(input)
+	if err := ioutil.WriteFile(input, []byte("main;foo 10\nmain;bar 5\n"), 0666); err != nil {
+		t.Fatalf("Failed to write folded input: %v", err)
+	}
+
+	opts := getDefaultOptions()
+	opts.FoldedInput = input
+	opts.OutputOpts.File = getTempFilename(t, ".svg")
+	defer os.Remove(opts.OutputOpts.File)
+
+	withScriptsInPath(t, func() {
+		if err := runWithOptions(opts, nil); err != nil {
+			t.Fatalf("Run with FoldedInput failed: %v", err)
+		}
+	})
+
+	out, err := ioutil.ReadFile(opts.OutputOpts.File)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if !strings.Contains(string(out), "main;foo 10") {
+		t.Errorf("Output file is missing folded input, got:\n%s", out)
+	}
+	if strings.Contains(string(out), "main.fib") {
+		t.Errorf("Output file should not contain the pprof profile, got:\n%s", out)
+	}
+}
+
+func TestRunCollapse(t *testing.T) {
+	input := getTempFilename(t, ".txt")
+	defer os.Remove(input)
+	backtraces := "Thread 1 (LWP 1234):\n#0  0x00007f2b in epoll_wait (epfd=4) at epoll_wait.c:30\n#1  main.main () at main.go:10\n"
+	if err := ioutil.WriteFile(input, []byte(backtraces), 0666); err != nil {
+		t.Fatalf("Failed to write backtraces: %v", err)
+	}
+
+	// Collapsing stacks does not need PATH, as no scripts are run.
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+
+	opts := getDefaultOptions()
+	opts.FoldedInput = input
+	opts.Collapse = "auto"
+	opts.OutputOpts.RawFile = getTempFilename(t, ".folded")
+	defer os.Remove(opts.OutputOpts.RawFile)
+	if err := runWithOptions(opts, nil); err != nil {
+		t.Fatalf("Run with --collapse failed: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(opts.OutputOpts.RawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw output file: %v", err)
+	}
+	if string(out) != "main.main;epoll_wait 1\n" {
+		t.Errorf("Unexpected collapsed stacks, got:\n%s", out)
+	}
+
+	opts.Collapse = "pprof"
+	if err := runWithOptions(opts, nil); err == nil || !strings.Contains(err.Error(), "could not collapse stacks") {
+		t.Errorf("Run with --collapse of the wrong format got unexpected error: %v", err)
+	}
+}
+
+func TestRunFoldedInputErrors(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.OutputOpts.Raw = true
+
+	opts.FoldedInput = "/dev/zero/invalid/file"
+	if err := runWithOptions(opts, nil); err == nil {
+		t.Errorf("Run with missing folded input expected to fail")
+	}
+
+	opts.FoldedInput = testPProfInputFile
+	if err := runWithOptions(opts, []string{"binary", "profile"}); err == nil {
+		t.Errorf("Run with folded input and profile sources expected to fail")
+	}
+
+	opts.Granularity = "package"
+	err := runWithOptions(opts, nil)
+	if err == nil || !strings.Contains(err.Error(), "--granularity cannot be used with --folded-input") {
+		t.Errorf("Run with folded input and --granularity got unexpected error: %v", err)
+	}
+
+	opts.Granularity = "function"
+	opts.PProfOptions.Sample = "cpu"
+	err = runWithOptions(opts, nil)
+	if err == nil || !strings.Contains(err.Error(), "--sample cannot be used with --folded-input") {
+		t.Errorf("Run with folded input and --sample got unexpected error: %v", err)
+	}
+}
+
+func TestRunWithoutPProf(t *testing.T) {
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+
+	err := runWithArgs("--raw", "--binaryinput", testPProfInputFile)
+	if err == nil || !strings.Contains(err.Error(), "cannot find go or pprof in the PATH") {
+		t.Errorf("Run without go got unexpected error: %v", err)
+	}
+}
+
+func TestRunGranularity(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.OutputOpts.Raw = true
+	opts.Granularity = "package"
+
+	result, _, err := generate(context.Background(), opts, nil)
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	if want := "runtime;main "; !strings.Contains(string(result.FlameInput), want) {
+		t.Errorf("package flame input missing %q, got:\n%s", want, result.FlameInput)
+	}
+	if strings.Contains(string(result.FlameInput), "main.fib") {
+		t.Errorf("package flame input has function names:\n%s", result.FlameInput)
+	}
+
+	opts.Granularity = "function"
+	opts.Lines = true
+	if got := opts.granularity(); got != stack.LineGranularity {
+		t.Errorf("--lines got granularity %v, want line", got)
+	}
+}
+
+func TestRunPerfInput(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.PerfInput = "./perf/testdata/perf.script.txt"
+	opts.OutputOpts.RawFile = getTempFilename(t, ".folded")
+	defer os.Remove(opts.OutputOpts.RawFile)
+
+	if err := runWithOptions(opts, nil); err != nil {
+		t.Fatalf("Run with PerfInput failed: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(opts.OutputOpts.RawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw output file: %v", err)
+	}
+	if !strings.Contains(string(out), "main.main;main.fib;runtime.mallocgc 2") {
+		t.Errorf("Raw output is missing perf stacks, got:\n%s", out)
+	}
+
+	opts.PerfInput = "/dev/zero/invalid/file"
+	if err := runWithOptions(opts, nil); err == nil {
+		t.Errorf("Run with missing perf input expected to fail")
+	}
+}
+
+func TestRunExcludeFirst(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.PerfInput = "./perf/testdata/perf.script.txt"
+	opts.ExcludeFirst = 150 * time.Millisecond
+	opts.OutputOpts.RawFile = getTempFilename(t, ".folded")
+	defer os.Remove(opts.OutputOpts.RawFile)
+
+	if err := runWithOptions(opts, nil); err != nil {
+		t.Fatalf("Run with ExcludeFirst failed: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(opts.OutputOpts.RawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw output file: %v", err)
+	}
+	if !strings.Contains(string(out), "main.main;main.fib;runtime.mallocgc 1") {
+		t.Errorf("Raw output should only have samples after the warm-up period, got:\n%s", out)
+	}
+	if strings.Contains(string(out), "__memcpy_avx_unaligned") {
+		t.Errorf("Raw output has samples from the warm-up period, got:\n%s", out)
+	}
+}
+
+func TestRunHeapInput(t *testing.T) {
+	// Text heap profiles are read without pprof.
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+
+	rawFile := getTempFilename(t, ".folded")
+	defer os.Remove(rawFile)
+	if err := runWithArgs("--heap-input", testHeapTextFile, "--raw-file", rawFile); err != nil {
+		t.Fatalf("Run with --heap-input failed: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(rawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw output file: %v", err)
+	}
+	// The inuse_space sample is selected by default.
+	if !strings.Contains(string(out), "runtime.main;main.main;main.alloc 8332018") {
+		t.Errorf("Raw output is missing heap stacks, got:\n%s", out)
+	}
+
+	err = runWithArgs("--heap-input", "/dev/zero/invalid/file", "--raw")
+	if err == nil || !strings.Contains(err.Error(), "could not read heap input") {
+		t.Errorf("Run with missing heap input got unexpected error: %v", err)
+	}
+}
+
+func TestRunTracebackInput(t *testing.T) {
+	rawFile := getTempFilename(t, ".folded")
+	defer os.Remove(rawFile)
+	if err := runWithArgs("--traceback-input", testTracebackFile, "--raw-file", rawFile); err != nil {
+		t.Fatalf("Run with --traceback-input failed: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(rawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw output file: %v", err)
+	}
+	if !strings.Contains(string(out), "net/http.HandlerFunc.ServeHTTP;main.(*server).handle;main.parse 2") {
+		t.Errorf("Raw output is missing the panic stacks, got:\n%s", out)
+	}
+
+	err = runWithArgs("--traceback-input", testHeapTextFile, "--raw")
+	if err == nil || !strings.Contains(err.Error(), "no goroutine tracebacks found") {
+		t.Errorf("Run without tracebacks got unexpected error: %v", err)
+	}
+}
+
+func TestRunDotInput(t *testing.T) {
+	// pprof -dot call graphs are read without pprof.
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+
+	rawFile := getTempFilename(t, ".folded")
+	defer os.Remove(rawFile)
+	if err := runWithArgs("--dot-input", testDotFile, "--raw-file", rawFile); err != nil {
+		t.Fatalf("Run with --dot-input failed: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(rawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw output file: %v", err)
+	}
+	if !strings.Contains(string(out), "main.main;main.work;main.parse 500000000") {
+		t.Errorf("Raw output is missing reconstructed stacks, got:\n%s", out)
+	}
+
+	err = runWithArgs("--dot-input", testHeapTextFile, "--raw")
+	if err == nil || !strings.Contains(err.Error(), "could not parse dot input") {
+		t.Errorf("Run with invalid dot input got unexpected error: %v", err)
+	}
+}
+
+func TestRunDiff(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.PProfOptions.BaseURL2 = testPProfInputFile
+	opts.OutputOpts.File = getTempFilename(t, ".svg")
+	defer os.Remove(opts.OutputOpts.File)
+
+	withScriptsInPath(t, func() {
+		if err := runWithOptions(opts, nil); err != nil {
+			t.Fatalf("Run with BaseURL2 failed: %v", err)
+		}
+	})
+
+	out, err := ioutil.ReadFile(opts.OutputOpts.File)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if !strings.Contains(string(out), "main.fib") {
+		t.Errorf("Output file is missing differential input, got:\n%s", out)
+	}
+}
+
+func TestRunFlameChart(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.PerfInput = "./perf/testdata/perf.script.txt"
+	opts.OutputOpts.FlameChart = true
+	opts.OutputOpts.RawFile = getTempFilename(t, ".folded")
+	defer os.Remove(opts.OutputOpts.RawFile)
+
+	if err := runWithOptions(opts, nil); err != nil {
+		t.Fatalf("Run with FlameChart failed: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(opts.OutputOpts.RawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw output file: %v", err)
+	}
+	expected := strings.Join([]string{
+		"main.main;main.fib;runtime.mallocgc 1",
+		"main.main;main._Cfunc_copy;__memcpy_avx_unaligned 1",
+		"main.main;main.fib;runtime.mallocgc 1",
+		"main.main;[unknown];[kernel.kallsyms] 1",
+	}, "\n") + "\n"
+	if string(out) != expected {
+		t.Errorf("Raw output is not in time order, got:\n%s\nwant:\n%s", out, expected)
+	}
+}
+
+func TestRunPin(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.PerfInput = "./perf/testdata/perf.script.txt"
+	opts.OutputOpts.Pin = []string{"main._Cfunc_copy"}
+	opts.OutputOpts.File = getTempFilename(t, ".svg")
+	defer os.Remove(opts.OutputOpts.File)
+
+	withScriptsInPath(t, func() {
+		if err := runWithOptions(opts, nil); err != nil {
+			t.Fatalf("Run with Pin failed: %v", err)
+		}
+	})
+
+	out, err := ioutil.ReadFile(opts.OutputOpts.File)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	expected := strings.Join([]string{
+		"main.main;main._Cfunc_copy;__memcpy_avx_unaligned 1",
+		"main.main;[unknown];[kernel.kallsyms] 1",
+		"main.main;main.fib;runtime.mallocgc 2",
+	}, "\n") + "\n"
+	if !strings.HasSuffix(string(out), expected) {
+		t.Errorf("Flame graph input should start with the pinned frame, got:\n%s\nwant:\n%s", out, expected)
+	}
+
+	args := buildFlameGraphArgs(opts.OutputOpts)
+	if args[len(args)-1] != "--flamechart" {
+		t.Errorf("Pinned frames should be rendered with --flamechart to keep their order, got %v", args)
+	}
+}
+
+func TestRunSplitBy(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.PerfInput = "./perf/testdata/perf.script.txt"
+	opts.SplitBy = "comm,tid"
+	opts.Labels = []string{"tid=12345"}
+	opts.OutputOpts.RawFile = getTempFilename(t, ".folded")
+	defer os.Remove(opts.OutputOpts.RawFile)
+
+	if err := runWithOptions(opts, nil); err != nil {
+		t.Fatalf("Run with SplitBy failed: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(opts.OutputOpts.RawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw output file: %v", err)
+	}
+	if !strings.Contains(string(out), "comm=myapp;tid=12345;main.main;main.fib;runtime.mallocgc 2") {
+		t.Errorf("Raw output is missing label frames, got:\n%s", out)
+	}
+	if strings.Contains(string(out), "tid=12346") {
+		t.Errorf("Raw output should only contain samples matching --label, got:\n%s", out)
+	}
+}
+
+func TestRunTraceOnly(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.PerfInput = "./perf/testdata/perf.script.txt"
+	opts.TraceID = "12345"
+	opts.TraceLabel = "tid"
+	opts.TraceOnly = true
+	opts.OutputOpts.RawFile = getTempFilename(t, ".folded")
+	defer os.Remove(opts.OutputOpts.RawFile)
+
+	if err := runWithOptions(opts, nil); err != nil {
+		t.Fatalf("Run with --trace-only failed: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(opts.OutputOpts.RawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw output file: %v", err)
+	}
+	if !strings.Contains(string(out), "main.main;main.fib;runtime.mallocgc 2") || strings.Contains(string(out), "[kernel.kallsyms]") {
+		t.Errorf("Raw output should only contain samples of the trace, got:\n%s", out)
+	}
+
+	opts.TraceID = "99999"
+	if err := runWithOptions(opts, nil); err == nil || !strings.Contains(err.Error(), "no samples match labels") {
+		t.Errorf("Run with --trace-only for an unknown trace got unexpected error: %v", err)
+	}
+}
+
+func TestRunSamplingError(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.ErrorBands = 10
+	opts.HideInsignificant = true
+	opts.OutputOpts.RawFile = getTempFilename(t, ".folded")
+	defer os.Remove(opts.OutputOpts.RawFile)
+
+	for _, perfInput := range []string{"", "./perf/testdata/perf.script.txt"} {
+		opts.PerfInput = perfInput
+		if err := runWithOptions(opts, nil); err != nil {
+			t.Fatalf("Run with sampling error options and perf input %q failed: %v", perfInput, err)
+		}
+
+		out, err := ioutil.ReadFile(opts.OutputOpts.RawFile)
+		if err != nil {
+			t.Fatalf("Failed to read raw output file: %v", err)
+		}
+		if !strings.Contains(string(out), " samples, ±") {
+			t.Errorf("Raw output is missing sampling error annotations, got:\n%s", out)
+		}
+	}
+}
+
+func TestNewContext(t *testing.T) {
+	ctx, cancel := newContext(0)
+	if _, ok := ctx.Deadline(); ok {
+		t.Errorf("context without timeout should not have a deadline")
+	}
+	cancel()
+	<-ctx.Done()
+
+	ctx, cancel = newContext(time.Millisecond)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Errorf("context with timeout was not cancelled")
+	}
+}
+
 func TestFlameGraphArgs(t *testing.T) {
 	opts := getDefaultOptions()
 	opts.OutputOpts.Raw = true
@@ -130,9 +1000,18 @@ func TestFlameGraphArgs(t *testing.T) {
 	opts.OutputOpts.ConsistentPalette = true
 	opts.OutputOpts.Reverse = true
 	opts.OutputOpts.Inverted = true
+	opts.OutputOpts.FlameChart = true
+	opts.OutputOpts.Subtitle = "v1.2"
+	opts.OutputOpts.FontSize = 10
+	opts.OutputOpts.FontType = "Monospace"
+	opts.OutputOpts.MinWidth = "0.5%"
+	opts.OutputOpts.NameType = "Frame:"
+	opts.OutputOpts.CountName = "bytes"
+	opts.OutputOpts.Negate = true
 
-	expectedCommandWithArgs := []string{"--title", "Flame Graph", "--width", "1200", "--colors", "perl",
-		"--hash", "--cp", "--reverse", "--inverted"}
+	expectedCommandWithArgs := []string{"--title", "Flame Graph", "--subtitle", "v1.2", "--width", "1200",
+		"--fontsize", "10", "--fonttype", "Monospace", "--minwidth", "0.5%", "--nametype", "Frame:",
+		"--countname", "bytes", "--colors", "perl", "--hash", "--cp", "--reverse", "--inverted", "--negate", "--flamechart"}
 
 	if !reflect.DeepEqual(expectedCommandWithArgs, buildFlameGraphArgs(opts.OutputOpts)) {
 		t.Fatalf("Invalid extra FlameGraph arguments!")
@@ -143,6 +1022,111 @@ func TestFlameGraphArgs(t *testing.T) {
 	}
 }
 
+func TestFlameGraphArgsColorBlindPalette(t *testing.T) {
+	tests := map[string]string{
+		"cb-safe":   "blue",
+		"cb-aqua":   "aqua",
+		"cb-orange": "orange",
+		"mem":       "mem",
+	}
+	for colors, want := range tests {
+		opts := getDefaultOptions()
+		opts.OutputOpts.Colors = colors
+		if err := validateOptions(opts); err != nil {
+			t.Errorf("validateOptions with --colors %v failed: %v", colors, err)
+		}
+
+		args := buildFlameGraphArgs(opts.OutputOpts)
+		if got := args[len(args)-1]; got != want {
+			t.Errorf("--colors %v: got flamegraph.pl palette %v, want %v", colors, got, want)
+		}
+	}
+}
+
+func TestSubtitle(t *testing.T) {
+	opts := getDefaultOptions().OutputOpts
+	opts.subtitle = gcSubtitle
+
+	want := []string{"--title", "Flame Graph", "--subtitle", gcSubtitle, "--width", "1200"}
+	if got := buildFlameGraphArgs(opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildFlameGraphArgs = %v, want %v", got, want)
+	}
+	if got, want := opts.fullTitle(), "Flame Graph ("+gcSubtitle+")"; got != want {
+		t.Errorf("fullTitle = %q, want %q", got, want)
+	}
+
+	allOpts := getDefaultOptions()
+	allOpts.PProfOptions.GCBeforeHeap = true
+	allOpts.TraceID = "4bf92f3577b34da6"
+	if got, want := collectionSubtitle(allOpts), gcSubtitle+"; Trace 4bf92f3577b34da6"; got != want {
+		t.Errorf("collectionSubtitle = %q, want %q", got, want)
+	}
+
+	opts.Subtitle = "prod"
+	if got, want := opts.fullTitle(), "Flame Graph (prod; "+gcSubtitle+")"; got != want {
+		t.Errorf("fullTitle with --subtitle = %q, want %q", got, want)
+	}
+	opts.subtitle = ""
+	if got, want := opts.fullSubtitle(), "prod"; got != want {
+		t.Errorf("fullSubtitle = %q, want %q", got, want)
+	}
+}
+
+func TestFlameGraphArgsSampleType(t *testing.T) {
+	opts := getDefaultOptions().OutputOpts
+	opts.sampleType = stack.ParseSampleType("alloc_space/bytes")
+
+	want := []string{"--title", "Flame Graph (alloc_space)", "--width", "1200", "--countname", "bytes"}
+	if got := buildFlameGraphArgs(opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildFlameGraphArgs = %v, want %v", got, want)
+	}
+
+	opts.sampleType = stack.ParseSampleType("cpu/nanoseconds")
+	opts.duration = 30*time.Second + 20*time.Millisecond
+	if got, want := opts.graphTitle(), "Flame Graph (cpu, 30s)"; got != want {
+		t.Errorf("graphTitle = %q, want %q", got, want)
+	}
+
+	opts.PlainTitle = true
+	opts.CountName = "ns"
+	want = []string{"--title", "Flame Graph", "--width", "1200", "--countname", "ns"}
+	if got := buildFlameGraphArgs(opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildFlameGraphArgs with --plain-title and --countname = %v, want %v", got, want)
+	}
+}
+
+func TestRoundDuration(t *testing.T) {
+	tests := map[time.Duration]time.Duration{
+		250 * time.Millisecond:   250 * time.Millisecond,
+		3 * time.Second:          3 * time.Second,
+		29600 * time.Millisecond: 30 * time.Second,
+		90400 * time.Millisecond: 90 * time.Second,
+	}
+	for d, want := range tests {
+		if got := roundDuration(d); got != want {
+			t.Errorf("roundDuration(%v) = %v, want %v", d, got, want)
+		}
+	}
+}
+
+func TestWarningSummary(t *testing.T) {
+	s := newWarningSummary()
+	s.add(stack.Warning{Kind: stack.SkippedLine, Message: "first"})
+	s.add(stack.Warning{Kind: stack.MissingFunction, Message: "missing"})
+	s.add(stack.Warning{Kind: stack.SkippedLine, Message: "second"})
+
+	if want := []stack.WarningKind{stack.SkippedLine, stack.MissingFunction}; !reflect.DeepEqual(s.kinds, want) {
+		t.Errorf("warning kinds: got %v, want %v", s.kinds, want)
+	}
+	if got := s.counts[stack.SkippedLine]; got != 2 {
+		t.Errorf("skipped line count: got %v, want 2", got)
+	}
+	if got := s.first[stack.SkippedLine].Message; got != "first" {
+		t.Errorf("first skipped line: got %v, want first", got)
+	}
+	s.log()
+}
+
 func getTempFilename(t *testing.T, suffix string) string {
 	f, err := ioutil.TempFile("", "")
 	if err != nil {
@@ -196,11 +1180,55 @@ func TestRunPrint(t *testing.T) {
 	opts.OutputOpts.Print = true
 
 	withScriptsInPath(t, func() {
+		out := captureStdout(t, func() {
+			if err := runWithOptions(opts, nil); err != nil {
+				t.Fatalf("Run with Print failed: %v", err)
+			}
+		})
+		if !strings.Contains(out, "flamegraph.pl") {
+			t.Errorf("Run with Print did not print the flame graph to stdout:\n%s", out)
+		}
+		if strings.Contains(out, "INFO[") {
+			t.Errorf("Run with Print wrote log messages to stdout:\n%s", out)
+		}
+	})
+}
+
+func TestRunRawStdout(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.OutputOpts.Raw = true
+
+	out := captureStdout(t, func() {
 		if err := runWithOptions(opts, nil); err != nil {
-			t.Fatalf("Run with Print failed: %v", err)
+			t.Fatalf("Run with Raw failed: %v", err)
 		}
-		// TODO(prashantv): Verify that output is printed to stdout.
 	})
+	// Every line must be a stack and count, so it can be piped to flamegraph.pl.
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if fields := strings.Fields(line); len(fields) < 2 || strings.HasPrefix(line, "INFO[") {
+			t.Errorf("Run with Raw wrote a line that is not a stack to stdout: %q", line)
+		}
+	}
+}
+
+// captureStdout returns what f writes to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe failed: %v", err)
+	}
+	oldStdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = oldStdout }()
+
+	out := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- b
+	}()
+	f()
+	w.Close()
+	return string(<-out)
 }
 
 // scriptsPath is used to cache the fake scripts if we've already created it.
@@ -0,0 +1,92 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/uber/go-torch/renderer"
+)
+
+// otlpServiceKey is the resource attribute for --otlp-service.
+const otlpServiceKey = "service.name"
+
+// otlpTimeout is how long to wait for the OTLP endpoint to accept a profile.
+var otlpTimeout = 30 * time.Second
+
+// validateOTLP checks the OTLP options, which are only used by
+// --out-format otlp.
+func validateOTLP(opts outputOptions) error {
+	if opts.OutFormat != "otlp" {
+		if opts.OTLPService != "" || len(opts.OTLPResource) > 0 || opts.OTLPEndpoint != "" {
+			return fmt.Errorf("--otlp-service, --otlp-resource and --otlp-endpoint require --out-format otlp")
+		}
+		return nil
+	}
+	if opts.OTLPService == "" {
+		return fmt.Errorf("--out-format otlp requires --otlp-service, as profiles are keyed by service.name")
+	}
+	for _, attr := range opts.OTLPResource {
+		parts := strings.SplitN(attr, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("invalid --otlp-resource %q, expected key=value", attr)
+		}
+		if parts[0] == otlpServiceKey {
+			return fmt.Errorf("use --otlp-service rather than --otlp-resource to set %v", otlpServiceKey)
+		}
+	}
+	if opts.OTLPEndpoint != "" && (opts.Print || opts.OutDir != "") {
+		return fmt.Errorf("--otlp-endpoint cannot be used with --print or --out-dir")
+	}
+	return nil
+}
+
+// otlpResource returns the resource attributes of OTLP profiles, with
+// service.name first. The options must have been validated.
+func (opts outputOptions) otlpResource() []renderer.OTLPAttribute {
+	resource := []renderer.OTLPAttribute{{Key: otlpServiceKey, Value: opts.OTLPService}}
+	for _, attr := range opts.OTLPResource {
+		parts := strings.SplitN(attr, "=", 2)
+		resource = append(resource, renderer.OTLPAttribute{Key: parts[0], Value: parts[1]})
+	}
+	return resource
+}
+
+// sendOTLP sends an OTLP/JSON export request to an OTLP/HTTP endpoint.
+func sendOTLP(endpoint string, body []byte) error {
+	client := &http.Client{Timeout: otlpTimeout}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not send OTLP profile: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("OTLP endpoint returned %v: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
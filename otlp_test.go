@@ -0,0 +1,93 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uber/go-torch/renderer"
+)
+
+func TestOTLPResource(t *testing.T) {
+	opts := getDefaultOptions().OutputOpts
+	opts.OTLPService = "svc"
+	opts.OTLPResource = []string{"deployment.environment=prod", "query=a=b"}
+	want := []renderer.OTLPAttribute{
+		{Key: "service.name", Value: "svc"},
+		{Key: "deployment.environment", Value: "prod"},
+		{Key: "query", Value: "a=b"},
+	}
+	if got := opts.otlpResource(); !reflect.DeepEqual(got, want) {
+		t.Errorf("otlpResource got %v, want %v", got, want)
+	}
+}
+
+func TestRunOTLPEndpoint(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected Content-Type %q", r.Header.Get("Content-Type"))
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	if err := runWithArgs("--binaryinput", testPProfInputFile, "--out-format", "otlp",
+		"--otlp-service", "svc", "--otlp-endpoint", server.URL); err != nil {
+		t.Fatalf("Run with --otlp-endpoint failed: %v", err)
+	}
+	var request struct {
+		ResourceProfiles []struct {
+			Resource struct {
+				Attributes []struct {
+					Key string `json:"key"`
+				} `json:"attributes"`
+			} `json:"resource"`
+		} `json:"resourceProfiles"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatalf("Request is not valid JSON: %v", err)
+	}
+	if len(request.ResourceProfiles) != 1 || request.ResourceProfiles[0].Resource.Attributes[0].Key != "service.name" {
+		t.Errorf("Unexpected request: %s", body)
+	}
+}
+
+func TestSendOTLPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "profiles are not supported", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	err := sendOTLP(server.URL, []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "404 Not Found: profiles are not supported") {
+		t.Errorf("sendOTLP got unexpected error: %v", err)
+	}
+	if err := sendOTLP("http://invalid host", nil); err == nil || !strings.Contains(err.Error(), "could not send OTLP profile") {
+		t.Errorf("sendOTLP to invalid URL got unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/uber/go-torch/renderer"
+	"github.com/uber/go-torch/torch"
+	"github.com/uber/go-torch/torchlog"
+)
+
+// manifestFile is the name of the file describing the artifacts in an
+// --out-dir run directory.
+const manifestFile = "manifest.json"
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// manifest describes the artifacts written to an --out-dir run directory.
+type manifest struct {
+	Created        time.Time  `json:"created"`
+	Format         string     `json:"format"`
+	Sources        []string   `json:"sources,omitempty"`
+	SelectedSample string     `json:"selectedSample"`
+	TraceID        string     `json:"traceId,omitempty"`
+	Artifacts      []artifact `json:"artifacts"`
+
+	// SampleRate is the effective CPU sampling rate in Hz, which may differ
+	// from --hz if the target ignored it.
+	SampleRate float64 `json:"sampleRate,omitempty"`
+}
+
+// artifact is a single output file in an --out-dir run directory.
+type artifact struct {
+	Sample string `json:"sample"`
+	File   string `json:"file"`
+	Total  int64  `json:"total"`
+}
+
+// runOutDir writes an artifact for each sample type in the profile to a new
+// directory named after the current time under opts.OutputOpts.OutDir, along
+// with a manifest describing them. It returns the directory that was written.
+func runOutDir(ctx context.Context, allOpts *options, remaining []string, now time.Time) (string, error) {
+	opts := allOpts.OutputOpts
+	result, err := generateResult(ctx, allOpts, remaining)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(opts.OutDir, now.Format(watchTimestampFormat))
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", fmt.Errorf("could not create output directory: %v", err)
+	}
+
+	format, ext := outDirFormat(opts)
+	m := manifest{
+		Created:        now,
+		Format:         format,
+		Sources:        remaining,
+		SelectedSample: result.Profile.SampleNames[result.SampleIndex],
+		TraceID:        allOpts.TraceID,
+	}
+	if period := result.Profile.Period; period > 0 {
+		m.SampleRate = sampleRate(period)
+	}
+	for i, sampleName := range result.Profile.SampleNames {
+		output, err := renderSampleType(result, i, opts)
+		if err != nil {
+			return "", fmt.Errorf("could not render %v: %v", sampleName, err)
+		}
+
+		file := sampleFileName(sampleName) + "." + ext
+		torchlog.Printf("Writing %v to %v", format, filepath.Join(dir, file))
+		if err := ioutil.WriteFile(filepath.Join(dir, file), output, 0666); err != nil {
+			return "", fmt.Errorf("could not write output file: %v", err)
+		}
+		m.Artifacts = append(m.Artifacts, artifact{
+			Sample: sampleName,
+			File:   file,
+			Total:  sampleTotal(result, i),
+		})
+	}
+
+	manifestBytes, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("could not encode manifest: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, manifestFile), append(manifestBytes, '\n'), 0666); err != nil {
+		return "", fmt.Errorf("could not write manifest: %v", err)
+	}
+	return dir, nil
+}
+
+// runAllSamples writes an output file for each sample type in the profile,
+// named after opts.OutputOpts.File and the sample. See allSamplesFileName.
+func runAllSamples(ctx context.Context, allOpts *options, remaining []string) error {
+	opts := allOpts.OutputOpts
+	result, err := generateResult(ctx, allOpts, remaining)
+	if err != nil {
+		return err
+	}
+
+	for i, sampleName := range result.Profile.SampleNames {
+		output, err := renderSampleType(result, i, opts)
+		if err != nil {
+			return fmt.Errorf("could not render %v: %v", sampleName, err)
+		}
+
+		file := allSamplesFileName(opts.File, sampleName)
+		torchlog.Printf("Writing %v to %v", opts.OutFormat, file)
+		if err := ioutil.WriteFile(file, output, 0666); err != nil {
+			return fmt.Errorf("could not write output file: %v", err)
+		}
+	}
+	return nil
+}
+
+// allSamplesFileName returns the file that a sample type is written to for
+// --all-samples, e.g. alloc_space/bytes is written to torch.alloc_space_bytes.svg
+// if the output file is torch.svg.
+func allSamplesFileName(file, sampleName string) string {
+	name := unsafeFileChars.ReplaceAllString(sampleName, "_")
+	if strings.Trim(name, "._") == "" {
+		name = "sample"
+	}
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "." + name + ext
+}
+
+// renderSampleType renders the result's profile using the given sample index.
+func renderSampleType(result *torch.Result, sampleIdx int, opts outputOptions) ([]byte, error) {
+	var flameInput []byte
+	var err error
+	if result.BaseProfile != nil {
+		flameInput, err = renderer.ToDiffFlameInput(result.BaseProfile, result.Profile, sampleIdx, renderer.FlameInputOptions{})
+	} else {
+		flameInput, err = renderer.ToFlameInput(result.Profile, sampleIdx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not convert stacks to flamegraph input: %v", err)
+	}
+
+	flameInput, output, err := renderOutput(result.Profile, sampleIdx, flameInput, opts)
+	if opts.Raw {
+		return flameInput, err
+	}
+	return output, err
+}
+
+// outDirFormat returns the format name and file extension of artifacts.
+func outDirFormat(opts outputOptions) (format, ext string) {
+	switch {
+	case opts.Raw:
+		return "folded", "folded"
+	default:
+		return opts.OutFormat, outputExt(opts.OutFormat)
+	}
+}
+
+// sampleFileName returns the file name used for a sample type,
+// e.g. cpu/nanoseconds is written to cpu.svg.
+func sampleFileName(sampleName string) string {
+	name := strings.SplitN(sampleName, "/", 2)[0]
+	name = unsafeFileChars.ReplaceAllString(name, "_")
+	if name == "" || name == "." || name == ".." {
+		return "sample"
+	}
+	return name
+}
+
+// sampleTotal returns the total value of a sample type in the profile.
+func sampleTotal(result *torch.Result, sampleIdx int) int64 {
+	var total int64
+	for _, s := range result.Profile.Samples {
+		total += s.Counts[sampleIdx]
+	}
+	return total
+}
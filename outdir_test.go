@@ -0,0 +1,196 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSampleFileName(t *testing.T) {
+	tests := []struct {
+		sampleName string
+		expected   string
+	}{
+		{"samples/count", "samples"},
+		{"cpu/nanoseconds", "cpu"},
+		{"alloc_space/bytes", "alloc_space"},
+		{"weird name:x/y", "weird_name_x"},
+		{"..", "sample"},
+		{"", "sample"},
+	}
+
+	for _, tt := range tests {
+		if got := sampleFileName(tt.sampleName); got != tt.expected {
+			t.Errorf("sampleFileName(%q) got %v, want %v", tt.sampleName, got, tt.expected)
+		}
+	}
+}
+
+func TestAllSamplesFileName(t *testing.T) {
+	tests := []struct {
+		file       string
+		sampleName string
+		expected   string
+	}{
+		{"torch.svg", "samples/count", "torch.samples_count.svg"},
+		{"torch.svg", "cpu/nanoseconds", "torch.cpu_nanoseconds.svg"},
+		{"out/heap.json", "alloc_space/bytes", "out/heap.alloc_space_bytes.json"},
+		{"torch.svg", "weird name:x/y", "torch.weird_name_x_y.svg"},
+		{"torch.svg", "..", "torch.sample.svg"},
+		{"torch", "", "torch.sample"},
+	}
+
+	for _, tt := range tests {
+		if got := allSamplesFileName(tt.file, tt.sampleName); got != tt.expected {
+			t.Errorf("allSamplesFileName(%q, %q) got %v, want %v", tt.file, tt.sampleName, got, tt.expected)
+		}
+	}
+}
+
+func TestRunAllSamples(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-all-samples")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := getDefaultOptions()
+	opts.OutputOpts.AllSamples = true
+	opts.OutputOpts.File = filepath.Join(dir, "torch.svg")
+
+	withScriptsInPath(t, func() {
+		if err := runWithOptions(opts, nil); err != nil {
+			t.Fatalf("Run with AllSamples failed: %v", err)
+		}
+	})
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	if want := []string{"torch.cpu_nanoseconds.svg", "torch.samples_count.svg"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Unexpected output files: %v, want %v", names, want)
+	}
+
+	samples, err := ioutil.ReadFile(filepath.Join(dir, "torch.samples_count.svg"))
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	cpu, err := ioutil.ReadFile(filepath.Join(dir, "torch.cpu_nanoseconds.svg"))
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if string(samples) == string(cpu) {
+		t.Errorf("Each sample type should be rendered with its own counts, got the same output:\n%s", cpu)
+	}
+}
+
+func TestRunOutDir(t *testing.T) {
+	outDir, err := ioutil.TempDir("", "go-torch-out-dir")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	opts := getDefaultOptions()
+	opts.OutputOpts.OutDir = outDir
+	opts.OutputOpts.Raw = true
+	opts.TraceID = "4bf92f3577b34da6"
+
+	now := time.Date(2017, 10, 10, 8, 30, 15, 123000000, time.UTC)
+	dir, err := runOutDir(context.Background(), opts, nil, now)
+	if err != nil {
+		t.Fatalf("runOutDir failed: %v", err)
+	}
+	if want := filepath.Join(outDir, "20171010-083015.123"); dir != want {
+		t.Errorf("runOutDir wrote to %v, want %v", dir, want)
+	}
+
+	manifestBytes, err := ioutil.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(manifestBytes, &m); err != nil {
+		t.Fatalf("Manifest is not valid JSON: %v", err)
+	}
+	if m.Format != "folded" || m.SelectedSample != "samples/count" || m.TraceID != "4bf92f3577b34da6" || !m.Created.Equal(now) {
+		t.Errorf("Unexpected manifest: %+v", m)
+	}
+	if m.SampleRate != 100 {
+		t.Errorf("Manifest sample rate = %v, want 100 Hz from the profile's period", m.SampleRate)
+	}
+
+	var files []string
+	for _, a := range m.Artifacts {
+		files = append(files, a.File)
+		if a.Total <= 0 {
+			t.Errorf("Artifact %v has unexpected total %v", a.File, a.Total)
+		}
+
+		out, err := ioutil.ReadFile(filepath.Join(dir, a.File))
+		if err != nil {
+			t.Errorf("Failed to read artifact %v: %v", a.File, err)
+			continue
+		}
+		if !strings.Contains(string(out), "main.main") {
+			t.Errorf("Artifact %v is missing stacks, got:\n%s", a.File, out)
+		}
+	}
+	if want := []string{"samples.folded", "cpu.folded"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("Unexpected artifacts %v, want %v", files, want)
+	}
+}
+
+func TestRunOutDirSVG(t *testing.T) {
+	outDir, err := ioutil.TempDir("", "go-torch-out-dir")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	opts := getDefaultOptions()
+	opts.OutputOpts.OutDir = outDir
+
+	withScriptsInPath(t, func() {
+		if err := runWithOptions(opts, nil); err != nil {
+			t.Fatalf("Run with OutDir failed: %v", err)
+		}
+	})
+
+	files, _ := filepath.Glob(filepath.Join(outDir, "*", "*"))
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	if want := []string{"cpu.svg", "manifest.json", "samples.svg"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Unexpected files in output directory: %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
+)
+
+// unownedTeam is the team reported for samples of functions without owners.
+const unownedTeam = "(unowned)"
+
+// codeowners maps functions to the owners of their package, using the path
+// patterns of a CODEOWNERS file.
+type codeowners struct {
+	rules []ownerRule
+	// module is the Go module path of the repository, if its go.mod was
+	// found, which is removed from package paths before matching.
+	module string
+
+	mu     sync.Mutex
+	byFunc map[string]string
+}
+
+// ownerRule is a line of a CODEOWNERS file.
+type ownerRule struct {
+	pattern *regexp.Regexp
+	owners  string
+}
+
+// parseCodeowners parses a CODEOWNERS file, which has a path pattern and the
+// owners of matching paths on each line:
+//
+//	**             @org/platform
+//	/pkg/cache/    @org/cache-team
+//	/internal/**/rpc  @org/rpc-team alice@example.com
+//
+// As in GitHub, the last matching pattern takes precedence. Lines starting
+// with # are comments.
+func parseCodeowners(file string) (*codeowners, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &codeowners{
+		module: findModule(file),
+		byFunc: make(map[string]string),
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// A pattern without owners removes the owners of matching paths.
+		fields := strings.Fields(line)
+		c.rules = append(c.rules, ownerRule{
+			pattern: codeownersPattern(fields[0]),
+			owners:  strings.Join(fields[1:], " "),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// codeownersPattern converts a gitignore style pattern to a regexp. Patterns
+// that start with or contain a / are relative to the repository root, and
+// other patterns match at any depth. A pattern also matches everything under
+// a matching directory.
+func codeownersPattern(pattern string) *regexp.Regexp {
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	p := strings.Trim(pattern, "/")
+
+	var re bytes.Buffer
+	switch {
+	case strings.HasPrefix(p, "**/"):
+		re.WriteString("^(.*/)?")
+		p = p[len("**/"):]
+	case anchored:
+		re.WriteString("^")
+	default:
+		re.WriteString("(^|/)")
+	}
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case c == '*' && i+1 < len(p) && p[i+1] == '*':
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("(/|$)")
+	return regexp.MustCompile(re.String())
+}
+
+// findModule returns the module path from the go.mod in the repository of a
+// CODEOWNERS file, which may be in the root, .github or docs directory.
+func findModule(codeownersFile string) string {
+	dir := filepath.Dir(codeownersFile)
+	dirs := []string{dir}
+	if base := filepath.Base(dir); base == ".github" || base == "docs" {
+		dirs = append(dirs, filepath.Dir(dir))
+	}
+
+	for _, dir := range dirs {
+		f, err := os.Open(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && fields[0] == "module" {
+				return strings.Trim(fields[1], `"`)
+			}
+		}
+	}
+	return ""
+}
+
+// owner returns the owners of a function's package, or "" if it has none.
+func (c *codeowners) owner(fn string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if owners, ok := c.byFunc[fn]; ok {
+		return owners
+	}
+	owners := c.pathOwner(stack.FuncPackage(fn))
+	c.byFunc[fn] = owners
+	return owners
+}
+
+// pathOwner returns the owners of a package path. If the module is not
+// known, the package could be anywhere in the repository, so each suffix of
+// the path is matched, and the last rule matching any of them is used.
+func (c *codeowners) pathOwner(pkg string) string {
+	if pkg == "" {
+		return ""
+	}
+	if c.module != "" {
+		if pkg == c.module {
+			return c.ruleOwners(c.match(""))
+		}
+		if !strings.HasPrefix(pkg, c.module+"/") {
+			// Dependencies are not owned by the repository.
+			return ""
+		}
+		return c.ruleOwners(c.match(strings.TrimPrefix(pkg, c.module+"/")))
+	}
+
+	last := -1
+	for path := pkg; ; {
+		if i := c.match(path); i > last {
+			last = i
+		}
+		idx := strings.Index(path, "/")
+		if idx < 0 {
+			return c.ruleOwners(last)
+		}
+		path = path[idx+1:]
+	}
+}
+
+// ruleOwners returns the owners of the rule at index i, or "" if i is -1.
+func (c *codeowners) ruleOwners(i int) string {
+	if i < 0 {
+		return ""
+	}
+	return c.rules[i].owners
+}
+
+// match returns the index of the last rule matching path, or -1 if no
+// rule matches.
+func (c *codeowners) match(path string) int {
+	for i := len(c.rules) - 1; i >= 0; i-- {
+		if c.rules[i].pattern.MatchString(path) {
+			return i
+		}
+	}
+	return -1
+}
+
+// filter returns a filter that adds the owners of each function to its
+// frame, e.g. github.com/uber/foo/cache.Get [@org/cache-team].
+func (c *codeowners) filter() stack.Filter {
+	return func(funcs []string) []string {
+		for i, f := range funcs {
+			if owners := c.owner(f); owners != "" {
+				funcs[i] = f + " [" + owners + "]"
+			}
+		}
+		return funcs
+	}
+}
+
+// frameOwner returns the owners added to a frame by the codeowners filter.
+func frameOwner(frame string) string {
+	idx := strings.LastIndex(frame, " [")
+	if idx < 0 || !strings.HasSuffix(frame, "]") {
+		return ""
+	}
+	return frame[idx+2 : len(frame)-1]
+}
+
+// printOwners writes the samples of each owner in the result's profile.
+func printOwners(w io.Writer, result *torch.Result, msgs *messages) error {
+	profile, err := resultProfile(result)
+	if err != nil {
+		return err
+	}
+	return writeOwnerReport(w, profile, result.SampleIndex, msgs)
+}
+
+// writeOwnerReport writes the self and cumulative samples of each owner in
+// a profile whose frames were labeled by the codeowners filter.
+func writeOwnerReport(w io.Writer, profile *stack.Profile, sampleIdx int, msgs *messages) error {
+	totals := stack.TopGroups(profile, sampleIdx, func(frame string) string {
+		if owner := frameOwner(frame); owner != "" {
+			return owner
+		}
+		return unownedTeam
+	})
+	return writeGroupReport(w, msgs.owners, profile, sampleIdx, totals, msgs)
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/uber/go-torch/stack"
+)
+
+const testCodeowners = `
+# default owners
+*                     @org/platform
+/pkg/cache/           @org/cache
+/internal/**/rpc      @org/rpc alice@example.com
+/pkg/cache/unowned
+`
+
+// writeCodeowners writes a CODEOWNERS file to a new directory, with a
+// go.mod if module is set, and returns the path of the file.
+func writeCodeowners(t *testing.T, subdir, module string) string {
+	dir, err := ioutil.TempDir("", "go-torch-owners")
+	if err != nil {
+		t.Fatalf("Failed to create temporary dir: %v", err)
+	}
+	if module != "" {
+		if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module "+module+"\n\ngo 1.12\n"), 0666); err != nil {
+			t.Fatalf("Failed to write go.mod: %v", err)
+		}
+	}
+	file := filepath.Join(dir, subdir, "CODEOWNERS")
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(file, []byte(testCodeowners), 0666); err != nil {
+		t.Fatalf("Failed to write CODEOWNERS: %v", err)
+	}
+	return file
+}
+
+func TestCodeownersPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		misses  []string
+	}{
+		{"*", []string{"", "a", "a/b"}, nil},
+		{"/pkg/cache/", []string{"pkg/cache", "pkg/cache/lru"}, []string{"pkg/cachex", "x/pkg/cache"}},
+		{"cache", []string{"cache", "pkg/cache", "pkg/cache/lru"}, []string{"pkg/caches"}},
+		{"pkg/*/rpc", []string{"pkg/a/rpc"}, []string{"pkg/a/b/rpc", "x/pkg/a/rpc"}},
+		{"/internal/**/rpc", []string{"internal/a/b/rpc"}, []string{"internal/rpcs"}},
+		{"**/rpc", []string{"rpc", "a/rpc", "a/b/rpc/c"}, []string{"rpcs"}},
+		{"v?", []string{"v1", "a/v2"}, []string{"v10"}},
+	}
+
+	for _, tt := range tests {
+		re := codeownersPattern(tt.pattern)
+		for _, path := range tt.matches {
+			if !re.MatchString(path) {
+				t.Errorf("Pattern %q should match %q (regexp %v)", tt.pattern, path, re)
+			}
+		}
+		for _, path := range tt.misses {
+			if re.MatchString(path) {
+				t.Errorf("Pattern %q should not match %q (regexp %v)", tt.pattern, path, re)
+			}
+		}
+	}
+}
+
+func TestCodeownersOwner(t *testing.T) {
+	tests := []struct {
+		fn   string
+		want string
+	}{
+		{"github.com/uber/svc/pkg/cache.(*Cache).Get", "@org/cache"},
+		{"github.com/uber/svc/pkg/cache/lru.New", "@org/cache"},
+		{"github.com/uber/svc/pkg/cache/unowned.F", ""},
+		{"github.com/uber/svc/internal/a/rpc.Call", "@org/rpc alice@example.com"},
+		{"github.com/uber/svc/handler.Serve", "@org/platform"},
+		{"github.com/uber/svc.Run", "@org/platform"},
+	}
+
+	for _, subdir := range []string{"", ".github"} {
+		file := writeCodeowners(t, subdir, "github.com/uber/svc")
+		defer os.RemoveAll(filepath.Dir(strings.TrimSuffix(file, filepath.Join(subdir, "CODEOWNERS"))))
+
+		owners, err := parseCodeowners(file)
+		if err != nil {
+			t.Fatalf("parseCodeowners failed: %v", err)
+		}
+		if owners.module != "github.com/uber/svc" {
+			t.Errorf("Expected module from go.mod, got %q", owners.module)
+		}
+		for _, tt := range tests {
+			if got := owners.owner(tt.fn); got != tt.want {
+				t.Errorf("owner(%v) in %q got %q, want %q", tt.fn, subdir, got, tt.want)
+			}
+		}
+		for _, fn := range []string{"runtime.mallocgc", "github.com/other/lib.F", "main.main"} {
+			if got := owners.owner(fn); got != "" {
+				t.Errorf("owner(%v) got %q, want no owners outside the module", fn, got)
+			}
+		}
+	}
+}
+
+func TestCodeownersWithoutModule(t *testing.T) {
+	file := writeCodeowners(t, "", "")
+	defer os.RemoveAll(filepath.Dir(file))
+
+	owners, err := parseCodeowners(file)
+	if err != nil {
+		t.Fatalf("parseCodeowners failed: %v", err)
+	}
+	if got := owners.owner("github.com/uber/svc/pkg/cache.Get"); got != "@org/cache" {
+		t.Errorf("Expected the package path suffix to match, got %q", got)
+	}
+	if got := owners.owner("runtime.mallocgc"); got != "@org/platform" {
+		t.Errorf("Expected the default owners without a module, got %q", got)
+	}
+}
+
+func TestWriteOwnerReport(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"svc.Run [@a]", "cache.Get [@b]"}, Counts: []int64{6}},
+			{Funcs: []string{"svc.Run [@a]", "cache.Get [@b]", "runtime.memmove"}, Counts: []int64{3}},
+			{Funcs: []string{"runtime.main"}, Counts: []int64{1}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeOwnerReport(&buf, profile, 0, english); err != nil {
+		t.Fatalf("writeOwnerReport failed: %v", err)
+	}
+	expected := "Owners by samples/count, 10 total\n" +
+		"        self   self%          cum    cum%\n" +
+		"           6  60.00%            9  90.00%  @b\n" +
+		"           4  40.00%            4  40.00%  (unowned)\n" +
+		"           0   0.00%            9  90.00%  @a\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected report, got:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+}
+
+func TestRunOwners(t *testing.T) {
+	file := writeCodeowners(t, "", "")
+	defer os.RemoveAll(filepath.Dir(file))
+
+	opts := getDefaultOptions()
+	opts.Owners = file
+	opts.OutputOpts.RawFile = getTempFilename(t, ".folded")
+	defer os.Remove(opts.OutputOpts.RawFile)
+	if err := runWithOptions(opts, nil); err != nil {
+		t.Fatalf("Run with --owners failed: %v", err)
+	}
+
+	contents, err := ioutil.ReadFile(opts.OutputOpts.RawFile)
+	if err != nil {
+		t.Fatalf("Failed to read raw output file: %v", err)
+	}
+	if !strings.Contains(string(contents), "main.fib [@org/platform]") {
+		t.Errorf("Expected frames to be labeled with owners, got:\n%s", contents)
+	}
+
+	opts.Owners = "/dev/zero/invalid/file"
+	if err := runWithOptions(opts, nil); err == nil || !strings.Contains(err.Error(), "could not read owners") {
+		t.Errorf("Run with missing owners file got unexpected error: %v", err)
+	}
+}
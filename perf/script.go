@@ -0,0 +1,278 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package perf converts Linux perf profiles into stacks, so flame graphs can
+// include cgo and kernel frames that runtime/pprof profiles do not have.
+package perf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torchlog"
+)
+
+// perfDataMagic is the header of perf.data files written by perf record.
+var perfDataMagic = []byte("PERFILE2")
+
+// maxLineLength is the longest line accepted in perf script output. Demangled
+// C++ symbols can be much longer than the default bufio.Scanner limit.
+const maxLineLength = 1 << 20
+
+var (
+	symbolOffsetRE = regexp.MustCompile(`\+0x[0-9a-fA-F]+$`)
+
+	// sampleHeaderRE matches the command, and the optional pid and the thread
+	// ID at the start of a sample header, e.g. "myapp 1234/1235 [001] ...".
+	sampleHeaderRE = regexp.MustCompile(`^\s*(.*?)\s+(?:(\d+)/)?(\d+)\s`)
+
+	// timestampRE matches the time of a sample in seconds, e.g. "1000.123456:".
+	timestampRE = regexp.MustCompile(`\s(\d+\.\d+):\s`)
+)
+
+// ParseOptions are optional parameters for ParseScript.
+type ParseOptions struct {
+	// OnWarning is called for each non-fatal problem found in the input,
+	// such as skipped lines and samples without a call stack.
+	OnWarning stack.WarningFunc
+
+	// TimeOrdered keeps the samples in the order they were recorded, for a
+	// flame chart, rather than combining all samples with the same stack.
+	// perf script writes samples in time order.
+	TimeOrdered bool
+
+	// Focus drops samples as they are parsed, so large profiles that are
+	// restricted to a small part of the program use less memory.
+	Focus stack.FocusFilter
+
+	// ExcludeFirst drops the samples recorded within this long of the first
+	// sample, so warm-up effects do not dominate short profiles. Sample
+	// headers must have a timestamp, which perf script writes by default.
+	ExcludeFirst time.Duration
+}
+
+// ReadFile returns the stacks in a perf profile, which is either the output
+// of perf script, or a perf.data file which is converted by running perf script.
+func ReadFile(ctx context.Context, file string, opts ParseOptions) (*stack.Profile, error) {
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(input, perfDataMagic) {
+		input, err = runPerfScript(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ParseScript(bytes.NewReader(input), opts)
+}
+
+func runPerfScript(ctx context.Context, file string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "perf", "script", "-i", file)
+	torchlog.Debugf("Run perf command: %v", strings.Join(cmd.Args, " "))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("perf script stopped: %v", ctx.Err())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not run perf script: %v, stderr: %s", err, stderr.Bytes())
+	}
+	return out, nil
+}
+
+// ParseScript parses the output of perf script, which is a header line for
+// each sample followed by its call stack, leaf first, e.g.
+//
+//	myapp 1234 1000.123456:     250000 cpu-clock:
+//		  45d1a0 runtime.mallocgc+0x10 (/usr/local/bin/myapp)
+//		  401000 main.main+0x20 (/usr/local/bin/myapp)
+//
+// Samples are counted once each, as perf script output does not have a
+// consistent weight for samples across events. Samples are labelled with
+// their command (comm), thread ID (tid), and process ID (pid) if present.
+func ParseScript(r io.Reader, opts ParseOptions) (*stack.Profile, error) {
+	p := &stack.Profile{SampleNames: []string{"samples/count"}, TimeOrdered: opts.TimeOrdered}
+
+	var (
+		funcs    []string
+		labels   stack.Labels
+		inSample bool
+		warmUp   bool
+		start    time.Duration
+		started  bool
+	)
+	flush := func(lineNum int) {
+		if !inSample {
+			return
+		}
+		inSample = false
+		if warmUp {
+			funcs = nil
+			return
+		}
+		if len(funcs) == 0 {
+			opts.OnWarning.Warn(stack.EmptyStack, "", "sample ending on line %v has no call stack", lineNum)
+			return
+		}
+		if !opts.Focus.Keep(funcs) {
+			funcs = nil
+			return
+		}
+		reverse(funcs)
+		p.Samples = append(p.Samples, &stack.Sample{Funcs: funcs, Counts: []int64{1}, Labels: labels})
+		funcs = nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineLength)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush(lineNum)
+		case strings.HasPrefix(line, "#"):
+			// Comments are only written by perf script --header.
+		case line[0] != ' ' && line[0] != '\t':
+			flush(lineNum)
+			inSample = true
+			labels = parseHeaderLabels(line)
+			if opts.ExcludeFirst > 0 {
+				ts, ok := parseTimestamp(line)
+				if !ok {
+					return nil, fmt.Errorf("sample on line %v has no timestamp, which is required to exclude the first %v", lineNum, opts.ExcludeFirst)
+				}
+				if !started {
+					start, started = ts, true
+				}
+				warmUp = ts-start < opts.ExcludeFirst
+			}
+		case !inSample:
+			opts.OnWarning.Warn(stack.SkippedLine, line, "skipped frame on line %v outside of a sample", lineNum)
+		default:
+			funcName, ok := parseFrame(line)
+			if !ok {
+				opts.OnWarning.Warn(stack.SkippedLine, line, "skipped malformed frame on line %v", lineNum)
+				continue
+			}
+			funcs = append(funcs, funcName)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read perf script output: %v", err)
+	}
+	flush(lineNum)
+
+	if len(p.Samples) == 0 {
+		if opts.Focus.Enabled() {
+			return nil, stack.ErrNoFocusedSamples
+		}
+		if started {
+			return nil, fmt.Errorf("no samples found in perf script output after the first %v", opts.ExcludeFirst)
+		}
+		return nil, fmt.Errorf("no samples found in perf script output")
+	}
+
+	// Merge combines the samples with identical stacks, or only consecutive
+	// samples if the profile is time ordered.
+	return stack.Merge(p)
+}
+
+// parseFrame returns the function name for a frame line, which is
+// "<address> <symbol>[+offset] (<dso>)". Unknown symbols are named after
+// their binary or library, as in Brendan Gregg's stackcollapse-perf.pl.
+func parseFrame(line string) (string, bool) {
+	parts := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	symbol, dso := parts[1], ""
+	if idx := strings.LastIndex(symbol, " ("); idx >= 0 && strings.HasSuffix(symbol, ")") {
+		symbol, dso = symbol[:idx], symbol[idx+2:len(symbol)-1]
+	}
+	symbol = symbolOffsetRE.ReplaceAllString(strings.TrimSpace(symbol), "")
+
+	if symbol == "" || symbol == "[unknown]" {
+		if dso == "" || dso == "[unknown]" {
+			return "[unknown]", true
+		}
+		if strings.HasPrefix(dso, "[") {
+			// Special mappings are already bracketed, e.g. [kernel.kallsyms].
+			return dso, true
+		}
+		return "[" + filepath.Base(dso) + "]", true
+	}
+	return symbol, true
+}
+
+// parseHeaderLabels returns the labels for a sample header, or nil if the
+// header is not in the default perf script format.
+func parseHeaderLabels(header string) stack.Labels {
+	match := sampleHeaderRE.FindStringSubmatch(header)
+	if match == nil {
+		return nil
+	}
+
+	labels := stack.Labels{
+		"comm": {match[1]},
+		"tid":  {match[3]},
+	}
+	if match[2] != "" {
+		labels["pid"] = []string{match[2]}
+	}
+	return labels
+}
+
+// parseTimestamp returns the time of a sample from its header, relative to
+// an arbitrary start, such as boot.
+func parseTimestamp(header string) (time.Duration, bool) {
+	match := timestampRE.FindStringSubmatch(header)
+	if match == nil {
+		return 0, false
+	}
+	secs, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
+
+func reverse(funcs []string) {
+	for i, j := 0, len(funcs)-1; i < j; i, j = i+1, j-1 {
+		funcs[i], funcs[j] = funcs[j], funcs[i]
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package perf
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+const testScriptFile = "testdata/perf.script.txt"
+
+func TestParseScript(t *testing.T) {
+	profile, err := ReadFile(context.Background(), testScriptFile, ParseOptions{})
+	require.NoError(t, err, "ReadFile failed")
+
+	thread1 := stack.Labels{"comm": {"myapp"}, "tid": {"12345"}}
+	thread2 := stack.Labels{"comm": {"myapp"}, "tid": {"12346"}}
+	expected := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main.main", "main.fib", "runtime.mallocgc"}, Counts: []int64{2}, Labels: thread1},
+			{Funcs: []string{"main.main", "main._Cfunc_copy", "__memcpy_avx_unaligned"}, Counts: []int64{1}, Labels: thread1},
+			{Funcs: []string{"main.main", "[unknown]", "[kernel.kallsyms]"}, Counts: []int64{1}, Labels: thread2},
+		},
+	}
+	assert.Equal(t, expected, profile)
+}
+
+func TestParseScriptTimeOrdered(t *testing.T) {
+	profile, err := ReadFile(context.Background(), testScriptFile, ParseOptions{TimeOrdered: true})
+	require.NoError(t, err, "ReadFile failed")
+
+	var stacks []string
+	for _, s := range profile.Samples {
+		stacks = append(stacks, strings.Join(s.Funcs, ";"))
+	}
+	expected := []string{
+		"main.main;main.fib;runtime.mallocgc",
+		"main.main;main._Cfunc_copy;__memcpy_avx_unaligned",
+		"main.main;main.fib;runtime.mallocgc",
+		"main.main;[unknown];[kernel.kallsyms]",
+	}
+	assert.Equal(t, expected, stacks, "samples should be in time order")
+	assert.True(t, profile.TimeOrdered, "profile should be time ordered")
+}
+
+func TestParseScriptFocus(t *testing.T) {
+	focus := stack.FocusFilter{Focus: regexp.MustCompile(`^main\.fib$`)}
+	profile, err := ReadFile(context.Background(), testScriptFile, ParseOptions{Focus: focus})
+	require.NoError(t, err, "ReadFile failed")
+
+	require.Len(t, profile.Samples, 1)
+	assert.Equal(t, []string{"main.main", "main.fib", "runtime.mallocgc"}, profile.Samples[0].Funcs)
+
+	focus = stack.FocusFilter{Ignore: regexp.MustCompile(`^main\.main$`)}
+	_, err = ReadFile(context.Background(), testScriptFile, ParseOptions{Focus: focus})
+	assert.Equal(t, stack.ErrNoFocusedSamples, err)
+}
+
+func TestParseScriptExcludeFirst(t *testing.T) {
+	profile, err := ReadFile(context.Background(), testScriptFile, ParseOptions{ExcludeFirst: 150 * time.Millisecond})
+	require.NoError(t, err, "ReadFile failed")
+
+	thread1 := stack.Labels{"comm": {"myapp"}, "tid": {"12345"}}
+	thread2 := stack.Labels{"comm": {"myapp"}, "tid": {"12346"}}
+	expected := []*stack.Sample{
+		{Funcs: []string{"main.main", "main.fib", "runtime.mallocgc"}, Counts: []int64{1}, Labels: thread1},
+		{Funcs: []string{"main.main", "[unknown]", "[kernel.kallsyms]"}, Counts: []int64{1}, Labels: thread2},
+	}
+	assert.Equal(t, expected, profile.Samples, "samples in the first 150ms should be dropped")
+
+	_, err = ReadFile(context.Background(), testScriptFile, ParseOptions{ExcludeFirst: time.Second})
+	assert.Contains(t, err.Error(), "no samples found in perf script output after the first 1s")
+
+	input := "myapp 1 cpu-clock:\n\t401000 main.main (/bin/myapp)\n"
+	_, err = ParseScript(strings.NewReader(input), ParseOptions{ExcludeFirst: time.Second})
+	assert.Contains(t, err.Error(), "sample on line 1 has no timestamp")
+}
+
+func TestParseScriptWarnings(t *testing.T) {
+	input := strings.Join([]string{
+		"\t401000 main.stray (/bin/myapp)",
+		"myapp 1 1.0: 1 cpu-clock:",
+		"",
+		"myapp 1 1.1: 1 cpu-clock:",
+		"\tmalformed",
+		"\t401000 main.main (/bin/myapp)",
+	}, "\n")
+
+	var warnings []stack.WarningKind
+	onWarning := func(w stack.Warning) { warnings = append(warnings, w.Kind) }
+	profile, err := ParseScript(strings.NewReader(input), ParseOptions{OnWarning: onWarning})
+	require.NoError(t, err, "ParseScript failed")
+
+	assert.Equal(t, []stack.WarningKind{stack.SkippedLine, stack.EmptyStack, stack.SkippedLine}, warnings)
+	require.Len(t, profile.Samples, 1)
+	assert.Equal(t, []string{"main.main"}, profile.Samples[0].Funcs)
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	_, err := ParseScript(strings.NewReader("# no samples\n"), ParseOptions{})
+	assert.Error(t, err, "expected input with no samples to fail")
+
+	_, err = ReadFile(context.Background(), "/dev/zero/invalid/file", ParseOptions{})
+	assert.Error(t, err, "expected missing file to fail")
+}
+
+func TestReadPerfData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-perf")
+	require.NoError(t, err, "failed to create temporary dir")
+	defer os.RemoveAll(dir)
+
+	// Use a fake perf that prints the test script output.
+	script, err := filepath.Abs(testScriptFile)
+	require.NoError(t, err)
+	fakePerf := "#!/bin/sh\ncat " + script + "\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "perf"), []byte(fakePerf), 0777))
+
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", dir+":"+oldPath)
+
+	dataFile := filepath.Join(dir, "perf.data")
+	require.NoError(t, ioutil.WriteFile(dataFile, append(perfDataMagic, 0, 0, 0), 0666))
+
+	profile, err := ReadFile(context.Background(), dataFile, ParseOptions{})
+	require.NoError(t, err, "ReadFile failed")
+	assert.Len(t, profile.Samples, 3)
+}
+
+func TestParseHeaderLabels(t *testing.T) {
+	tests := []struct {
+		header string
+		want   stack.Labels
+	}{
+		{
+			header: "myapp 12345 [001] 1000.100000:     250000 cpu-clock: ",
+			want:   stack.Labels{"comm": {"myapp"}, "tid": {"12345"}},
+		},
+		{
+			header: "    Web Content  100/101  1000.1: cycles:",
+			want:   stack.Labels{"comm": {"Web Content"}, "pid": {"100"}, "tid": {"101"}},
+		},
+		{
+			header: "malformed",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, parseHeaderLabels(tt.header), "parseHeaderLabels(%q)", tt.header)
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"\t45d1a0 runtime.mallocgc+0x10 (/bin/myapp)", "runtime.mallocgc"},
+		{"\t45d1a0 std::vector<int>::push_back(int const&) (/usr/lib/libfoo.so)", "std::vector<int>::push_back(int const&)"},
+		{"\t45d1a0 [unknown] (/usr/lib/libfoo.so)", "[libfoo.so]"},
+		{"\t45d1a0 [unknown] ([unknown])", "[unknown]"},
+		{"\t45d1a0 main.main", "main.main"},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseFrame(tt.line)
+		assert.True(t, ok, "parseFrame(%q) failed", tt.line)
+		assert.Equal(t, tt.want, got, "parseFrame(%q)", tt.line)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// parallelRecords is the number of unique stacks above which they are named
+// and aggregated across multiple goroutines.
+const parallelRecords = 10000
+
+// namedRecord is a record with the function names of its stack, and the key
+// that records with the same function names and labels share.
+type namedRecord struct {
+	record *stackRecord
+	funcs  []string
+	key    string
+	hash   uint64
+}
+
+// nameRecords returns the named records that pass the focus filter, naming
+// them on the given number of goroutines, and the number of samples with
+// and without missing functions.
+func (p *rawParser) nameRecords(workers int) (named []namedRecord, missingSamples, totalSamples int64) {
+	// Frames are looked up in order before naming, so missing functions
+	// are reported in the same order for a given input, and the workers
+	// only read the frames.
+	frames := make(map[funcID][]stack.Frame)
+	var kept []*stackRecord
+	for _, r := range p.records {
+		if !p.keepRecord(r) {
+			continue
+		}
+		for i := len(r.stack) - 1; i >= 0; i-- {
+			if _, ok := frames[r.stack[i]]; !ok {
+				frames[r.stack[i]] = p.getFrames(r.stack[i])
+			}
+		}
+		kept = append(kept, r)
+	}
+
+	named = make([]namedRecord, len(kept))
+	missing := make([]bool, len(kept))
+	forEachChunk(len(kept), workers, func(start, end int) {
+		getFrames := func(id funcID) []stack.Frame { return frames[id] }
+		for i := start; i < end; i++ {
+			named[i], missing[i] = p.nameRecord(kept[i], getFrames)
+		}
+	})
+
+	for i, r := range kept {
+		totalSamples += r.samples[0]
+		if missing[i] {
+			missingSamples += r.samples[0]
+		}
+	}
+	return named, missingSamples, totalSamples
+}
+
+// nameRecord names the frames of r, and returns whether it has missing
+// functions. It only reads from p, so it is safe to call concurrently.
+func (p *rawParser) nameRecord(r *stackRecord, getFrames func(funcID) []stack.Frame) (namedRecord, bool) {
+	frames := r.frames(getFrames)
+	missing := p.hasMissing(r)
+	if missing {
+		frames = p.mergeMissing(frames)
+	}
+
+	funcNames := p.granularity.Names(frames)
+	funcKey := strings.Join(funcNames, ";")
+	if len(r.labels) > 0 {
+		funcKey += "\x00" + r.labels.String()
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(funcKey))
+	return namedRecord{record: r, funcs: funcNames, key: funcKey, hash: h.Sum64()}, missing
+}
+
+// aggregateRecords sums the samples of records with the same key, and
+// returns a sample for each key in the order it was first seen. Records
+// are sharded by the hash of their key, so each shard is aggregated by a
+// separate goroutine, and the shards are then merged.
+func aggregateRecords(named []namedRecord, shards int) ([]*stack.Sample, error) {
+	results := make([][]firstSample, shards)
+	errs := make([]error, shards)
+	var wg sync.WaitGroup
+	for shard := 0; shard < shards; shard++ {
+		wg.Add(1)
+		go func(shard int) {
+			defer wg.Done()
+			results[shard], errs[shard] = aggregateShard(named, shard, shards)
+		}(shard)
+	}
+	wg.Wait()
+
+	var merged []firstSample
+	for shard, result := range results {
+		if errs[shard] != nil {
+			return nil, errs[shard]
+		}
+		merged = append(merged, result...)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].first < merged[j].first
+	})
+
+	samples := make([]*stack.Sample, len(merged))
+	for i, s := range merged {
+		samples[i] = s.sample
+	}
+	return samples, nil
+}
+
+// firstSample is an aggregated sample, and the index of the first record
+// it was aggregated from.
+type firstSample struct {
+	first  int
+	sample *stack.Sample
+}
+
+// aggregateShard sums the samples of the records in the given shard.
+func aggregateShard(named []namedRecord, shard, shards int) ([]firstSample, error) {
+	var result []firstSample
+	samples := make(map[string]*stack.Sample)
+	for i := range named {
+		n := &named[i]
+		if n.hash%uint64(shards) != uint64(shard) {
+			continue
+		}
+
+		if sample, ok := samples[n.key]; ok {
+			if err := sample.Add(n.record.samples); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		sample := stack.NewSample(n.funcs, n.record.samples)
+		sample.Labels = n.record.labels
+		samples[n.key] = sample
+		result = append(result, firstSample{i, sample})
+	}
+	return result, nil
+}
+
+// forEachChunk splits [0, n) into a contiguous chunk for each worker, and
+// calls f with each chunk on a separate goroutine.
+func forEachChunk(n, workers int, f func(start, end int)) {
+	if workers <= 1 {
+		f(0, n)
+		return
+	}
+
+	size := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			f(start, end)
+		}(start, end)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+// largeRawProfile returns raw pprof output with the given number of sample
+// lines with random stacks, some of which are repeated or labelled.
+func largeRawProfile(numSamples int) []byte {
+	const numFuncs = 40
+	rng := rand.New(rand.NewSource(1))
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("Samples:\nsamples/count cpu/nanoseconds\n")
+	for i := 0; i < numSamples; i++ {
+		fmt.Fprintf(buf, "    1   10000000:")
+		depth := 2 + rng.Intn(6)
+		for j := 0; j < depth; j++ {
+			fmt.Fprintf(buf, " %v", 1+rng.Intn(numFuncs))
+		}
+		buf.WriteString("\n")
+		if rng.Intn(10) == 0 {
+			fmt.Fprintf(buf, "                handler:[/h%v]\n", rng.Intn(3))
+		}
+	}
+	buf.WriteString("Locations\n")
+	for i := 1; i <= numFuncs; i++ {
+		// Locations without a function name are merged as unknown frames.
+		if i%10 == 0 {
+			fmt.Fprintf(buf, "    %v: 0x%x\n", i, i)
+			continue
+		}
+		fmt.Fprintf(buf, "    %v: 0x%x pkg%v.f%v /src/pkg%v/f.go:%v s=0\n", i, i, i%4, i, i%4, i)
+	}
+	return buf.Bytes()
+}
+
+func TestAggregateRecordsParallel(t *testing.T) {
+	raw := largeRawProfile(20000)
+
+	for _, g := range []stack.Granularity{stack.FunctionGranularity, stack.PackageGranularity} {
+		var results [][]*stack.Sample
+		for _, workers := range []int{1, 4} {
+			parser := newRawParser()
+			parser.granularity = g
+			parser.missing = UnknownMissing
+			require.NoError(t, parser.parse(bytes.NewReader(raw)), "parse failed")
+
+			named, missing, total := parser.nameRecords(workers)
+			assert.True(t, missing > 0 && missing < total, "some samples should have missing functions")
+			samples, err := aggregateRecords(named, workers)
+			require.NoError(t, err, "aggregateRecords failed")
+			results = append(results, samples)
+		}
+		assert.Equal(t, results[0], results[1], "%v: parallel aggregation should match sequential", g)
+	}
+
+	// ParseRaw aggregates large profiles in parallel.
+	profile, err := ParseRaw(raw)
+	require.NoError(t, err, "ParseRaw failed")
+	var total int64
+	for _, s := range profile.Samples {
+		total += s.Counts[0]
+	}
+	assert.Equal(t, int64(20000), total, "all samples should be aggregated")
+}
+
+func TestForEachChunk(t *testing.T) {
+	for _, n := range []int{0, 1, 7, 100} {
+		for _, workers := range []int{1, 3, 8} {
+			seen := make([]int, n)
+			forEachChunk(n, workers, func(start, end int) {
+				for i := start; i < end; i++ {
+					seen[i]++
+				}
+			})
+			for i, count := range seen {
+				assert.Equal(t, 1, count, "n=%v workers=%v: index %v", n, workers, i)
+			}
+		}
+	}
+}
+
+func BenchmarkParseRawLarge(b *testing.B) {
+	raw := largeRawProfile(200000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseRaw(raw); err != nil {
+			b.Fatalf("ParseRaw failed: %v", err)
+		}
+	}
+}
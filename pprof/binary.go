@@ -0,0 +1,311 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// binaryInfo is the metadata read from a binary that can be compared
+// against the mappings recorded in a profile.
+type binaryInfo struct {
+	arch string
+	// goarch is the GOARCH of the binary's machine type, or empty if it is
+	// not known.
+	goarch  string
+	is64Bit bool
+	// buildID is the GNU build ID of ELF binaries, which is what the Go
+	// runtime records in profile mappings.
+	buildID string
+}
+
+// CheckBinary verifies that the binary passed to pprof matches the main
+// mapping of the profile. Symbolizing a profile with a binary built for a
+// different architecture or from a different build produces plausible but
+// wrong function names, so a mismatch is an error unless
+// Options.AllowMismatch is set, in which case it is reported to onWarning.
+// It runs after parsing, so it also covers profiles that pprof fetched
+// itself, which CheckProfileFile cannot read.
+func CheckBinary(opts Options, remaining []string, profile *stack.Profile, onWarning stack.WarningFunc) error {
+	binaryPath, explicit := getBinaryPath(opts, remaining)
+	if binaryPath == "" || len(profile.Mappings) == 0 {
+		return nil
+	}
+
+	info, err := readBinaryInfo(binaryPath)
+	if err != nil {
+		if explicit {
+			return fmt.Errorf("could not read binary %v: %v", binaryPath, err)
+		}
+		// The argument may not be a binary (e.g. a pprof flag value).
+		return nil
+	}
+
+	if err := checkMappings(info, profile.Mappings); err != nil {
+		if !opts.AllowMismatch {
+			return fmt.Errorf("binary %v does not match profile: %v", binaryPath, err)
+		}
+		onWarning.Warn(stack.BinaryMismatch, binaryPath, "binary %v does not match profile: %v", binaryPath, err)
+	}
+	return nil
+}
+
+// CheckProfileFile verifies that the binary passed to pprof matches the
+// saved profile that pprof will read, before pprof symbolizes it. Besides
+// the build ID and mappings checked by CheckBinary, it compares the binary's
+// architecture against the architecture-specific Go source files of the
+// profile's functions, such as runtime/asm_arm64.s. Binaries or profiles
+// that cannot be read are not checked here. If Options.AllowMismatch is set,
+// a mismatched architecture is reported to onWarning instead, and the
+// mappings are left for CheckBinary to report.
+func CheckProfileFile(opts Options, remaining []string, onWarning stack.WarningFunc) error {
+	binaryPath, _ := getBinaryPath(opts, remaining)
+	err := checkProfileFile(opts, remaining, opts.AllowMismatch)
+	if err != nil && opts.AllowMismatch {
+		onWarning.Warn(stack.BinaryMismatch, binaryPath, "%v", err)
+		return nil
+	}
+	return err
+}
+
+// checkProfileFile implements CheckProfileFile, only checking the
+// architecture if archOnly is set.
+func checkProfileFile(opts Options, remaining []string, archOnly bool) error {
+	binaryPath, _ := getBinaryPath(opts, remaining)
+	profilePath := opts.BinaryFile
+	if len(remaining) > 0 {
+		profilePath = remaining[len(remaining)-1]
+	}
+	if binaryPath == "" || profilePath == "" {
+		return nil
+	}
+
+	info, err := readBinaryInfo(binaryPath)
+	if err != nil {
+		// CheckBinary reports binaries that cannot be read.
+		return nil
+	}
+	profile, err := readProfileInfo(profilePath)
+	if err != nil {
+		// The profile may be a URL that pprof fetches, or not a protobuf.
+		return nil
+	}
+
+	err = checkArch(info, profile.goarchs)
+	if err == nil && !archOnly && len(profile.mappings) > 0 {
+		err = checkMappings(info, profile.mappings)
+	}
+	if err != nil {
+		return fmt.Errorf("binary %v does not match profile: %v", binaryPath, err)
+	}
+	return nil
+}
+
+// checkArch compares the GOARCH of the binary against the architectures
+// of the profile's Go source files.
+func checkArch(info binaryInfo, goarchs []string) error {
+	if info.goarch == "" || len(goarchs) == 0 {
+		return nil
+	}
+	for _, goarch := range goarchs {
+		if goarch == info.goarch {
+			return nil
+		}
+	}
+	return fmt.Errorf("binary is %v (%v), but the profile is from %v", info.goarch, info.arch, strings.Join(goarchs, ", "))
+}
+
+// getBinaryPath returns the path of the binary that pprof will use to
+// symbolize the profile, and whether it was explicitly specified using
+// Options.BinaryName rather than guessed from the remaining arguments.
+func getBinaryPath(opts Options, remaining []string) (string, bool) {
+	if len(remaining) > 0 {
+		// pprof takes [binary] <profile source> as the final arguments.
+		if len(remaining) < 2 {
+			return "", false
+		}
+		candidate := remaining[len(remaining)-2]
+		if strings.HasPrefix(candidate, "-") {
+			return "", false
+		}
+		return candidate, false
+	}
+
+	if opts.BinaryFile != "" && opts.BinaryName != "" {
+		return opts.BinaryName, true
+	}
+	return "", false
+}
+
+// checkMappings compares the binary against the main (first) mapping.
+func checkMappings(info binaryInfo, mappings []*stack.Mapping) error {
+	mainMapping := mappings[0]
+	if mainMapping.BuildID != "" && info.buildID != "" && !strings.EqualFold(mainMapping.BuildID, info.buildID) {
+		return fmt.Errorf("build ID %v does not match profile build ID %v", info.buildID, mainMapping.BuildID)
+	}
+
+	if !info.is64Bit {
+		for _, m := range mappings {
+			if m.Limit > 0xffffffff {
+				return fmt.Errorf("%v binary cannot have produced 64-bit mapping %#x/%#x %v",
+					info.arch, m.Start, m.Limit, m.File)
+			}
+		}
+	}
+
+	return nil
+}
+
+// readBinaryInfo reads the architecture and build ID of an ELF, Mach-O or PE binary.
+func readBinaryInfo(path string) (binaryInfo, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		return binaryInfo{
+			arch:    f.Machine.String(),
+			goarch:  elfGOARCH(f),
+			is64Bit: f.Class == elf.ELFCLASS64,
+			buildID: elfBuildID(f),
+		}, nil
+	}
+
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		return binaryInfo{
+			arch:    f.Cpu.String(),
+			goarch:  machoGOARCH[f.Cpu],
+			is64Bit: f.Magic == macho.Magic64,
+		}, nil
+	}
+
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		_, is64Bit := f.OptionalHeader.(*pe.OptionalHeader64)
+		return binaryInfo{
+			arch:    fmt.Sprintf("pe-machine-%#x", f.Machine),
+			goarch:  peGOARCH[f.Machine],
+			is64Bit: is64Bit,
+		}, nil
+	}
+
+	return binaryInfo{}, fmt.Errorf("unrecognized binary format")
+}
+
+var (
+	machoGOARCH = map[macho.Cpu]string{
+		macho.Cpu386:   "386",
+		macho.CpuAmd64: "amd64",
+		macho.CpuArm:   "arm",
+		macho.CpuArm64: "arm64",
+		macho.CpuPpc64: "ppc64",
+	}
+	peGOARCH = map[uint16]string{
+		pe.IMAGE_FILE_MACHINE_I386:  "386",
+		pe.IMAGE_FILE_MACHINE_AMD64: "amd64",
+		pe.IMAGE_FILE_MACHINE_ARMNT: "arm",
+		pe.IMAGE_FILE_MACHINE_ARM64: "arm64",
+	}
+)
+
+// elfGOARCH returns the GOARCH of an ELF binary, or empty if it is not
+// known. Some machine types are used by several GOARCHes, which differ in
+// their class or byte order.
+func elfGOARCH(f *elf.File) string {
+	bigEndian := f.Data == elf.ELFDATA2MSB
+	is64Bit := f.Class == elf.ELFCLASS64
+	switch f.Machine {
+	case elf.EM_386:
+		return "386"
+	case elf.EM_X86_64:
+		return "amd64"
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_AARCH64:
+		return "arm64"
+	case elf.EM_PPC64:
+		if bigEndian {
+			return "ppc64"
+		}
+		return "ppc64le"
+	case elf.EM_S390:
+		return "s390x"
+	case elf.EM_RISCV:
+		return "riscv64"
+	case elf.EM_MIPS:
+		switch {
+		case is64Bit && bigEndian:
+			return "mips64"
+		case is64Bit:
+			return "mips64le"
+		case bigEndian:
+			return "mips"
+		}
+		return "mipsle"
+	}
+	return ""
+}
+
+// elfBuildID returns the hex encoded GNU build ID note of the binary, if any.
+func elfBuildID(f *elf.File) string {
+	const ntGNUBuildID = 3
+
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			continue
+		}
+
+		// Each note is: namesz, descsz, type, name (padded), desc (padded).
+		for len(data) >= 12 {
+			nameSize := f.ByteOrder.Uint32(data[0:4])
+			descSize := f.ByteOrder.Uint32(data[4:8])
+			noteType := f.ByteOrder.Uint32(data[8:12])
+			data = data[12:]
+
+			nameEnd := align4(nameSize)
+			descEnd := nameEnd + align4(descSize)
+			if uint64(len(data)) < descEnd {
+				break
+			}
+
+			name := bytes.TrimRight(data[:nameSize], "\x00")
+			if noteType == ntGNUBuildID && string(name) == "GNU" {
+				return hex.EncodeToString(data[nameEnd : nameEnd+uint64(descSize)])
+			}
+			data = data[descEnd:]
+		}
+	}
+	return ""
+}
+
+func align4(n uint32) uint64 {
+	return (uint64(n) + 3) &^ 3
+}
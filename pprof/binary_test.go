@@ -0,0 +1,144 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+func TestGetBinaryPath(t *testing.T) {
+	tests := []struct {
+		opts         Options
+		remaining    []string
+		wantPath     string
+		wantExplicit bool
+	}{
+		{
+			opts: Options{BinaryFile: "profile"},
+		},
+		{
+			opts:         Options{BinaryFile: "profile", BinaryName: "binary"},
+			wantPath:     "binary",
+			wantExplicit: true,
+		},
+		{
+			remaining: []string{"profile"},
+		},
+		{
+			remaining: []string{"binary", "profile"},
+			wantPath:  "binary",
+		},
+		{
+			remaining: []string{"--alloc_objects", "profile"},
+		},
+	}
+
+	for _, tt := range tests {
+		path, explicit := getBinaryPath(tt.opts, tt.remaining)
+		assert.Equal(t, tt.wantPath, path, "binary path for %v %v", tt.opts, tt.remaining)
+		assert.Equal(t, tt.wantExplicit, explicit, "explicit for %v %v", tt.opts, tt.remaining)
+	}
+}
+
+func TestCheckMappings(t *testing.T) {
+	tests := []struct {
+		msg      string
+		info     binaryInfo
+		mappings []*stack.Mapping
+		wantErr  string
+	}{
+		{
+			msg:      "matching build ID",
+			info:     binaryInfo{arch: "EM_X86_64", is64Bit: true, buildID: "abcd"},
+			mappings: []*stack.Mapping{{Limit: 0x4a2000, BuildID: "ABCD"}},
+		},
+		{
+			msg:      "no build ID in profile",
+			info:     binaryInfo{arch: "EM_X86_64", is64Bit: true, buildID: "abcd"},
+			mappings: []*stack.Mapping{{Limit: 0x4a2000}},
+		},
+		{
+			msg:      "mismatched build ID",
+			info:     binaryInfo{arch: "EM_X86_64", is64Bit: true, buildID: "abcd"},
+			mappings: []*stack.Mapping{{Limit: 0x4a2000, BuildID: "1234"}},
+			wantErr:  "build ID abcd does not match profile build ID 1234",
+		},
+		{
+			msg:  "32-bit binary with 64-bit mapping",
+			info: binaryInfo{arch: "EM_386"},
+			mappings: []*stack.Mapping{
+				{Limit: 0x4a2000},
+				{Start: 0x7f0000000000, Limit: 0x7f0000001000, File: "libc.so"},
+			},
+			wantErr: "EM_386 binary cannot have produced 64-bit mapping",
+		},
+	}
+
+	for _, tt := range tests {
+		err := checkMappings(tt.info, tt.mappings)
+		if tt.wantErr == "" {
+			assert.NoError(t, err, tt.msg)
+			continue
+		}
+		if assert.Error(t, err, tt.msg) {
+			assert.Contains(t, err.Error(), tt.wantErr, tt.msg)
+		}
+	}
+}
+
+func TestCheckBinary(t *testing.T) {
+	// The test binary itself is a valid binary for the current platform.
+	binary := os.Args[0]
+	info, err := readBinaryInfo(binary)
+	require.NoError(t, err, "failed to read test binary")
+
+	profile := &stack.Profile{
+		Mappings: []*stack.Mapping{{Limit: 0x4a2000, BuildID: info.buildID + "ff"}},
+	}
+	opts := Options{BinaryFile: "profile", BinaryName: binary}
+
+	var warnings []stack.Warning
+	onWarning := func(w stack.Warning) { warnings = append(warnings, w) }
+
+	err = CheckBinary(opts, nil, profile, onWarning)
+	if info.buildID != "" {
+		require.Error(t, err, "expected build ID mismatch to fail")
+		assert.Contains(t, err.Error(), "does not match profile")
+	}
+
+	opts.AllowMismatch = true
+	assert.NoError(t, CheckBinary(opts, nil, profile, onWarning), "mismatch should only warn with AllowMismatch")
+	if info.buildID != "" {
+		require.Len(t, warnings, 1, "expected mismatch warning")
+		assert.Equal(t, stack.BinaryMismatch, warnings[0].Kind)
+		assert.Equal(t, binary, warnings[0].Detail)
+	}
+
+	opts.BinaryName = "testdata/pprof.raw.txt"
+	assert.Error(t, CheckBinary(opts, nil, profile, nil), "expected non-binary to fail")
+	assert.NoError(t, CheckBinary(Options{}, []string{"testdata/pprof.raw.txt", "profile"}, profile, nil),
+		"guessed binaries that cannot be read should be ignored")
+}
@@ -0,0 +1,509 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber/go-torch/stack"
+)
+
+var (
+	// dotNodeRE matches a node of a pprof -dot graph, e.g.
+	//   N1 [label="main\nmain\n0 of 1.50s (100%)" tooltip="main.main (1.50s)"]
+	dotNodeRE = regexp.MustCompile(`^(N\d+) \[(.*)\]$`)
+
+	// dotEdgeRE matches an edge of a pprof -dot graph, e.g.
+	//   N1 -> N2 [label=" 1.50s" weight=100]
+	dotEdgeRE = regexp.MustCompile(`^(N\d+) -> (N\d+) \[(.*)\]$`)
+
+	// dotAttrRE matches an attribute of a node or edge, which may be quoted.
+	dotAttrRE = regexp.MustCompile(`(\w+)=("(?:[^"\\]|\\.)*"|[^ \]]+)`)
+
+	// dotValueRE matches a value formatted by pprof, e.g. 1.50s or 12kB.
+	dotValueRE = regexp.MustCompile(`^(-?[0-9.]+(?:e[+-]?\d+)?)([a-zA-Zµ]*)$`)
+)
+
+// dotUnits are the units of values formatted by pprof, and the unit of the
+// sample type they are converted to.
+var dotUnits = map[string]struct {
+	scale float64
+	unit  string
+}{
+	"ns":   {1, "nanoseconds"},
+	"us":   {1e3, "nanoseconds"},
+	"µs":   {1e3, "nanoseconds"},
+	"ms":   {1e6, "nanoseconds"},
+	"s":    {1e9, "nanoseconds"},
+	"mins": {60e9, "nanoseconds"},
+	"hrs":  {3600e9, "nanoseconds"},
+	"B":    {1, "bytes"},
+	"kB":   {1 << 10, "bytes"},
+	"MB":   {1 << 20, "bytes"},
+	"GB":   {1 << 30, "bytes"},
+	"TB":   {1 << 40, "bytes"},
+	"":     {1, "count"},
+}
+
+// dotMinShare is the share of the total below which a path is not followed
+// any further, and is counted as its last function instead. It bounds the
+// number of paths through graphs where many functions call each other.
+const dotMinShare = 1e-4
+
+type dotNode struct {
+	name      string
+	flat, cum float64
+	unit      string
+	edges     []dotEdge
+	hasCaller bool
+}
+
+type dotEdge struct {
+	to     string
+	weight float64
+}
+
+// addEdge adds a call to the node to. Graphs may have several edges between
+// the same nodes, such as inlined and regular calls, which are combined so
+// their paths are only followed once.
+func (n *dotNode) addEdge(to string, weight float64) {
+	for i := range n.edges {
+		if n.edges[i].to == to {
+			n.edges[i].weight += weight
+			return
+		}
+	}
+	n.edges = append(n.edges, dotEdge{to, weight})
+}
+
+// ParseDot parses the call graph written by pprof -dot, so profiles that
+// were only exported as a graph can be rendered as a flame graph. The graph
+// only has the total of each call, not the stacks it was part of, so stacks
+// are reconstructed by splitting the samples of each function between its
+// callers in proportion to the weight of their calls. The flame graph is an
+// approximation if functions are called from several places. The Limits
+// and Focus options are used.
+func ParseDot(input []byte, opts ParseOptions) (*stack.Profile, error) {
+	limits := opts.Limits.withDefaults()
+	if len(input) > limits.MaxInputSize {
+		return nil, &LimitError{"MaxInputSize", limits.MaxInputSize}
+	}
+
+	nodes := make(map[string]*dotNode)
+	sampleType := "value"
+	var duration time.Duration
+	sawGraph := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	scanner.Buffer(nil, limits.MaxLineLength)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "digraph") {
+			sawGraph = true
+		}
+		if m := dotEdgeRE.FindStringSubmatch(line); m != nil {
+			from, to := nodes[m[1]], nodes[m[2]]
+			if from == nil || to == nil {
+				return nil, fmt.Errorf("malformed dot graph, edge before its nodes: %v", line)
+			}
+			weight, _, err := parseDotValue(dotAttrs(m[3])["label"])
+			if err != nil {
+				return nil, fmt.Errorf("malformed dot graph edge %v: %v", line, err)
+			}
+			if m[1] != m[2] {
+				from.addEdge(m[2], weight)
+				to.hasCaller = true
+			}
+			continue
+		}
+		if m := dotNodeRE.FindStringSubmatch(line); m != nil {
+			if len(nodes) >= limits.MaxSamples {
+				return nil, &LimitError{"MaxSamples", limits.MaxSamples}
+			}
+			node, err := parseDotNode(dotAttrs(m[2]))
+			if err != nil {
+				return nil, fmt.Errorf("malformed dot graph node %v: %v", line, err)
+			}
+			nodes[m[1]] = node
+			continue
+		}
+		// The legend has the sample type and duration of the profile.
+		if label, ok := dotAttrs(line)["label"]; ok && strings.HasPrefix(label, "File: ") {
+			sampleType, duration = parseDotLegend(label, sampleType)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return nil, &LimitError{"MaxLineLength", limits.MaxLineLength}
+		}
+		return nil, err
+	}
+	if !sawGraph {
+		return nil, fmt.Errorf("input is not a dot graph")
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("dot graph has no functions")
+	}
+	return dotProfile(nodes, sampleType, duration, opts)
+}
+
+// dotAttrs returns the attributes of a node or edge, with quoted values
+// unescaped.
+func dotAttrs(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range dotAttrRE.FindAllStringSubmatch(s, -1) {
+		value := m[2]
+		if strings.HasPrefix(value, `"`) {
+			value = dotUnescape(value[1 : len(value)-1])
+		}
+		attrs[m[1]] = value
+	}
+	return attrs
+}
+
+// dotUnescape replaces the escape sequences of a quoted dot string. Line
+// breaks (\n, \l and \r) are replaced by newlines.
+func dotUnescape(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'l', 'r':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// parseDotNode parses the function name and values of a node. The label has
+// the function name split over several lines, then the flat value, and the
+// cumulative value if it differs, e.g. "main\nwork\n1s (50%)\nof 2s (100%)",
+// or "main\nmain\n0 of 2s (100%)" if the flat value is 0.
+func parseDotNode(attrs map[string]string) (*dotNode, error) {
+	lines := strings.Split(strings.TrimRight(attrs["label"], "\n"), "\n")
+	valueLine := len(lines) - 1
+	if valueLine > 0 && strings.HasPrefix(lines[valueLine], "of ") {
+		valueLine--
+	}
+	if valueLine < 1 {
+		return nil, fmt.Errorf("label has no function name")
+	}
+	values := strings.Join(lines[valueLine:], " ")
+
+	node := &dotNode{}
+	flat, cum := values, ""
+	if idx := strings.Index(values, " of "); idx >= 0 {
+		flat, cum = values[:idx], values[idx+len(" of "):]
+	}
+	var err error
+	if node.flat, node.unit, err = parseDotValue(flat); err != nil {
+		return nil, err
+	}
+	node.cum = node.flat
+	if cum != "" {
+		if node.cum, node.unit, err = parseDotValue(cum); err != nil {
+			return nil, err
+		}
+	}
+
+	// The tooltip has the full function name, e.g. "main.work (2s)".
+	node.name = attrs["tooltip"]
+	if idx := strings.LastIndex(node.name, " ("); idx > 0 {
+		node.name = node.name[:idx]
+	}
+	if node.name == "" {
+		node.name = strings.Join(lines[:valueLine], ".")
+	}
+	return node, nil
+}
+
+// parseDotValue parses the first value in s, such as "1.50s (75%)", scaled
+// to the base unit of its sample type.
+func parseDotValue(s string) (float64, string, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, "", fmt.Errorf("missing value")
+	}
+	m := dotValueRE.FindStringSubmatch(fields[0])
+	if m == nil {
+		return 0, "", fmt.Errorf("malformed value %q", fields[0])
+	}
+	unit, ok := dotUnits[m[2]]
+	if !ok {
+		return 0, "", fmt.Errorf("unknown unit in %q", fields[0])
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed value %q", fields[0])
+	}
+	if v == 0 {
+		// 0 has no unit, so it does not determine the sample type.
+		return 0, "", nil
+	}
+	return v * unit.scale, unit.unit, nil
+}
+
+// parseDotLegend returns the sample type and duration from the legend,
+// which has lines such as "Type: cpu" and "Duration: 30s, Total samples = 2s".
+func parseDotLegend(label, sampleType string) (string, time.Duration) {
+	var duration time.Duration
+	for _, line := range strings.Split(label, "\n") {
+		switch {
+		case strings.HasPrefix(line, "Type: "):
+			sampleType = strings.TrimSpace(strings.TrimPrefix(line, "Type: "))
+		case strings.HasPrefix(line, "Duration: "):
+			d := strings.TrimPrefix(line, "Duration: ")
+			if idx := strings.Index(d, ","); idx >= 0 {
+				d = d[:idx]
+			}
+			// The duration is optional, so a malformed duration is ignored.
+			duration, _ = time.ParseDuration(strings.TrimSpace(d))
+		}
+	}
+	return sampleType, duration
+}
+
+// dotProfile reconstructs the stacks of the graph, starting from functions
+// that are not called by other functions in the graph.
+func dotProfile(nodes map[string]*dotNode, sampleType string, duration time.Duration, opts ParseOptions) (*stack.Profile, error) {
+	if opts.BreakCycles {
+		breakDotCycles(nodes)
+	}
+
+	unit := ""
+	var roots []string
+	var total float64
+	for id, n := range nodes {
+		if n.unit != "" {
+			if unit != "" && n.unit != unit {
+				return nil, fmt.Errorf("dot graph has values in %v and %v", unit, n.unit)
+			}
+			unit = n.unit
+		}
+		if !n.hasCaller {
+			roots = append(roots, id)
+			total += n.cum
+		}
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("dot graph has no function that is not called by another function")
+	}
+	if unit == "" {
+		unit = "count"
+	}
+	sort.Strings(roots)
+
+	profile, err := stack.NewProfile([]string{sampleType + "/" + unit})
+	if err != nil {
+		return nil, err
+	}
+	profile.Duration = duration
+
+	values := dotStacks(nodes, roots, total*dotMinShare)
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := int64(math.Floor(values[key] + 0.5))
+		if value <= 0 {
+			continue
+		}
+		funcs := strings.Split(key, "\x00")
+		if !opts.Focus.Keep(funcs) {
+			continue
+		}
+		profile.Samples = append(profile.Samples, stack.NewSample(funcs, []int64{value}))
+	}
+	return profile, nil
+}
+
+// dotCall is the call at index idx of the edges of the node from.
+type dotCall struct {
+	from string
+	idx  int
+}
+
+// breakDotCycles removes the lightest call of each cycle in the graph, so
+// it has no cycles. The weight of the call is counted as the caller's own,
+// rather than dropping the paths through the cycle when they reach it again.
+func breakDotCycles(nodes map[string]*dotNode) {
+	for {
+		cycle := findDotCycle(nodes)
+		if cycle == nil {
+			break
+		}
+		lightest := cycle[0]
+		for _, c := range cycle[1:] {
+			if nodes[c.from].edges[c.idx].weight < nodes[lightest.from].edges[lightest.idx].weight {
+				lightest = c
+			}
+		}
+		n := nodes[lightest.from]
+		n.flat += n.edges[lightest.idx].weight
+		n.edges = append(n.edges[:lightest.idx:lightest.idx], n.edges[lightest.idx+1:]...)
+	}
+
+	for _, n := range nodes {
+		n.hasCaller = false
+	}
+	for _, n := range nodes {
+		for _, e := range n.edges {
+			nodes[e.to].hasCaller = true
+		}
+	}
+}
+
+// findDotCycle returns the calls of a cycle in the graph, or nil if it has
+// no cycles. Like dotStacks, it uses an explicit stack rather than recursion.
+func findDotCycle(nodes map[string]*dotNode) []dotCall {
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	const (
+		unvisited = iota
+		onStack
+		done
+	)
+	state := make(map[string]int)
+	for _, start := range ids {
+		if state[start] != unvisited {
+			continue
+		}
+		// Each call is the next edge of its node to follow.
+		calls := []dotCall{{start, 0}}
+		state[start] = onStack
+		for len(calls) > 0 {
+			top := &calls[len(calls)-1]
+			edges := nodes[top.from].edges
+			if top.idx == len(edges) {
+				state[top.from] = done
+				calls = calls[:len(calls)-1]
+				continue
+			}
+			to := edges[top.idx].to
+			top.idx++
+
+			switch state[to] {
+			case unvisited:
+				state[to] = onStack
+				calls = append(calls, dotCall{to, 0})
+			case onStack:
+				var cycle []dotCall
+				for i := len(calls) - 1; i >= 0; i-- {
+					cycle = append(cycle, dotCall{calls[i].from, calls[i].idx - 1})
+					if calls[i].from == to {
+						break
+					}
+				}
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// dotPath is a path through a dot graph that is yet to be followed, and the
+// amount of the cumulative value of its last node that is called along it.
+type dotPath struct {
+	ids    []string
+	amount float64
+}
+
+// dotStacks follows the paths through a dot graph from roots, and returns the
+// value of each stack, keyed by its function names joined by NUL. Paths are
+// followed using an explicit stack rather than recursion, so deep graphs do
+// not grow the goroutine stack. Paths with less than minimum are not
+// followed any further, so the value along the paths at each depth adds up
+// to at most the total, and the number of paths followed is bounded even for
+// graphs with many diamonds.
+func dotStacks(nodes map[string]*dotNode, roots []string, minimum float64) map[string]float64 {
+	values := make(map[string]float64)
+	var pending []dotPath
+	for i := len(roots) - 1; i >= 0; i-- {
+		pending = append(pending, dotPath{[]string{roots[i]}, nodes[roots[i]].cum})
+	}
+
+	for len(pending) > 0 {
+		p := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		n := nodes[p.ids[len(p.ids)-1]]
+		if n.cum <= 0 {
+			continue
+		}
+		share := math.Min(p.amount/n.cum, 1)
+
+		value := n.flat * share
+		if p.amount < minimum {
+			// Too little to follow any further.
+			value = p.amount
+		} else {
+			for _, e := range n.edges {
+				weight := e.weight * share
+				if dotPathHas(p.ids, e.to) {
+					// Recursive calls are counted as the caller.
+					value += weight
+					continue
+				}
+				// The full slice expression makes append copy the path,
+				// so paths that share a prefix do not share ids.
+				pending = append(pending, dotPath{append(p.ids[:len(p.ids):len(p.ids)], e.to), weight})
+			}
+		}
+
+		names := make([]string, len(p.ids))
+		for i, id := range p.ids {
+			names[i] = nodes[id].name
+		}
+		values[strings.Join(names, "\x00")] += value
+	}
+	return values
+}
+
+// dotPathHas returns whether the path ids includes the node id.
+func dotPathHas(ids []string, id string) bool {
+	for _, pathID := range ids {
+		if pathID == id {
+			return true
+		}
+	}
+	return false
+}
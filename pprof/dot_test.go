@@ -0,0 +1,191 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+func TestParseDot(t *testing.T) {
+	input, err := ioutil.ReadFile("testdata/pprof.dot")
+	require.NoError(t, err, "failed to read test dot graph")
+
+	profile, err := ParseDot(input, ParseOptions{})
+	require.NoError(t, err, "ParseDot failed")
+	assert.Equal(t, []string{"cpu/nanoseconds"}, profile.SampleNames)
+	assert.Equal(t, 3*time.Second, profile.Duration, "duration should be parsed from the legend")
+
+	// main.parse is called by both main.main and main.work, so its samples
+	// are split between them by the weight of the calls.
+	expected := []*stack.Sample{
+		{Funcs: []string{"main.main", "main.parse"}, Counts: []int64{5e8}},
+		{Funcs: []string{"main.main", "main.work"}, Counts: []int64{1e9}},
+		{Funcs: []string{"main.main", "main.work", "main.parse"}, Counts: []int64{5e8}},
+		{Funcs: []string{"runtime.gcBgMarkWorker"}, Counts: []int64{2e8}},
+	}
+	assert.Equal(t, expected, profile.Samples)
+
+	profile, err = ParseDot(input, ParseOptions{Focus: stack.FocusFilter{Focus: regexp.MustCompile(`^main\.work$`)}})
+	require.NoError(t, err, "ParseDot failed")
+	assert.Len(t, profile.Samples, 2, "only stacks with main.work should be kept")
+}
+
+func TestParseDotValues(t *testing.T) {
+	input := []byte(`digraph "unnamed" {
+N1 [label="alloc\n10MB (50%)\nof 20MB (100%)"]
+N2 [label="pkg\n(*T)\nGrow\n10MB (50%)"]
+N1 -> N2 [label=" 10MB"]
+}
+`)
+	profile, err := ParseDot(input, ParseOptions{})
+	require.NoError(t, err, "ParseDot failed")
+	assert.Equal(t, []string{"value/bytes"}, profile.SampleNames, "sample type should default to value")
+	expected := []*stack.Sample{
+		{Funcs: []string{"alloc"}, Counts: []int64{10 << 20}},
+		{Funcs: []string{"alloc", "pkg.(*T).Grow"}, Counts: []int64{10 << 20}},
+	}
+	assert.Equal(t, expected, profile.Samples, "names should be joined from the label without a tooltip")
+}
+
+func TestParseDotLargeGraphs(t *testing.T) {
+	// A chain of 2000 calls, and 40 diamonds in a row, which have 2^40
+	// paths through them.
+	var chain, diamonds bytes.Buffer
+	chain.WriteString("digraph \"chain\" {\n")
+	for i := 1; i <= 2000; i++ {
+		fmt.Fprintf(&chain, "N%v [label=\"f%v\\n1s\\nof %vs\"]\n", i, i, 2001-i)
+		if i > 1 {
+			fmt.Fprintf(&chain, "N%v -> N%v [label=\" %vs\"]\n", i-1, i, 2001-i)
+		}
+	}
+	chain.WriteString("}\n")
+
+	diamonds.WriteString("digraph \"diamonds\" {\nN0 [label=\"top\\n0 of 1s\"]\n")
+	for i := 1; i <= 40; i++ {
+		fmt.Fprintf(&diamonds, "N%v [label=\"left%v\\n0 of 0.50s\"]\nN%v [label=\"right%v\\n0 of 0.50s\"]\n", 3*i-2, i, 3*i-1, i)
+		fmt.Fprintf(&diamonds, "N%v [label=\"join%v\\n0 of 1s\"]\n", 3*i, i)
+		fmt.Fprintf(&diamonds, "N%v -> N%v [label=\" 0.50s\"]\nN%v -> N%v [label=\" 0.50s\"]\n", 3*i-3, 3*i-2, 3*i-3, 3*i-1)
+		fmt.Fprintf(&diamonds, "N%v -> N%v [label=\" 0.50s\"]\nN%v -> N%v [label=\" 0.50s\"]\n", 3*i-2, 3*i, 3*i-1, 3*i)
+	}
+	diamonds.WriteString("N121 [label=\"leaf\\n1s\"]\nN120 -> N121 [label=\" 1s\"]\n}\n")
+
+	tests := []struct {
+		input *bytes.Buffer
+		total float64
+	}{
+		{&chain, 2000e9},
+		{&diamonds, 1e9},
+	}
+	for _, tt := range tests {
+		profile, err := ParseDot(tt.input.Bytes(), ParseOptions{})
+		require.NoError(t, err, "ParseDot failed")
+
+		var total int64
+		for _, s := range profile.Samples {
+			total += s.Counts[0]
+		}
+		// Paths that are not followed are counted as their last function,
+		// so no samples are lost, apart from rounding.
+		assert.InDelta(t, tt.total, total, 1e4, "total of the reconstructed stacks")
+	}
+}
+
+func TestParseDotDuplicateEdges(t *testing.T) {
+	input := []byte(`digraph "unnamed" {
+N1 [label="main\n0 of 2s"]
+N2 [label="work\n2s"]
+N1 -> N2 [label=" 1.50s"]
+N1 -> N2 [label=" 0.50s\n (inline)" style="dotted"]
+}
+`)
+	profile, err := ParseDot(input, ParseOptions{})
+	require.NoError(t, err, "ParseDot failed")
+	expected := []*stack.Sample{
+		{Funcs: []string{"main", "work"}, Counts: []int64{2e9}},
+	}
+	assert.Equal(t, expected, profile.Samples, "inlined and regular calls should be combined")
+}
+
+func TestParseDotBreakCycles(t *testing.T) {
+	// a and b call each other, so neither is a root unless the lighter call
+	// from b to a is removed.
+	input := []byte(`digraph "unnamed" {
+N1 [label="a\n1s\nof 2s"]
+N2 [label="b\n1s\nof 2s"]
+N1 -> N2 [label=" 1s"]
+N2 -> N1 [label=" 0.50s"]
+}
+`)
+	_, err := ParseDot(input, ParseOptions{})
+	assert.Error(t, err, "ParseDot should fail without a root")
+
+	profile, err := ParseDot(input, ParseOptions{BreakCycles: true})
+	require.NoError(t, err, "ParseDot failed")
+	expected := []*stack.Sample{
+		{Funcs: []string{"a"}, Counts: []int64{1e9}},
+		{Funcs: []string{"a", "b"}, Counts: []int64{75e7}},
+	}
+	assert.Equal(t, expected, profile.Samples, "the call from b to a should count as b's own")
+
+	// Graphs without cycles are not changed.
+	input, err = ioutil.ReadFile("testdata/pprof.dot")
+	require.NoError(t, err, "failed to read test dot graph")
+	withCycles, err := ParseDot(input, ParseOptions{})
+	require.NoError(t, err, "ParseDot failed")
+	broken, err := ParseDot(input, ParseOptions{BreakCycles: true})
+	require.NoError(t, err, "ParseDot failed")
+	assert.Equal(t, withCycles, broken)
+}
+
+func TestParseDotErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		errMsg string
+	}{
+		{"", "not a dot graph"},
+		{"heap profile: 1: 1 [1: 1] @ heap/2\n", "not a dot graph"},
+		{"digraph \"x\" {\n}\n", "has no functions"},
+		{"digraph \"x\" {\nN1 -> N2 [label=\" 1s\"]\n}\n", "edge before its nodes"},
+		{"digraph \"x\" {\nN1 [label=\"1s\"]\n}\n", "no function name"},
+		{"digraph \"x\" {\nN1 [label=\"main\\n1parsec\"]\n}\n", "unknown unit"},
+		{"digraph \"x\" {\nN1 [label=\"a\\n1s\"]\nN2 [label=\"b\\n1MB\"]\n}\n", "values in"},
+		{"digraph \"x\" {\nN1 [label=\"a\\n1s\"]\nN2 [label=\"b\\n1s\"]\nN1 -> N2 [label=\" 1s\"]\nN2 -> N1 [label=\" 1s\"]\n}\n", "not called by another function"},
+	}
+	for _, tt := range tests {
+		_, err := ParseDot([]byte(tt.input), ParseOptions{})
+		if assert.Error(t, err, "ParseDot(%q) should fail", tt.input) {
+			assert.True(t, strings.Contains(err.Error(), tt.errMsg), "ParseDot(%q) got error %v, want %q", tt.input, err, tt.errMsg)
+		}
+	}
+
+	_, err := ParseDot([]byte("digraph \"x\" {\n}\n"), ParseOptions{Limits: Limits{MaxInputSize: 10}})
+	assert.Equal(t, &LimitError{"MaxInputSize", 10}, err)
+}
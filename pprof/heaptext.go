@@ -0,0 +1,228 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// heapSampleNames are the sample types of a text heap profile, in the same
+// order as pprof.
+var heapSampleNames = []string{"alloc_objects/count", "alloc_space/bytes", "inuse_objects/count", "inuse_space/bytes"}
+
+var (
+	// heapHeaderRE matches the first line of a text heap profile, which has
+	// the totals and the sampling rate, e.g.
+	//   heap profile: 1279: 5288448 [1285: 5386240] @ heap/8192
+	heapHeaderRE = regexp.MustCompile(`^heap profile: *\d+: *\d+ *\[ *\d+: *\d+ *\] *@ *(heap|heap_v2)/(\d+)`)
+
+	// heapRecordRE matches the counts and addresses of a stack, e.g.
+	//   1: 4096 [2: 8192] @ 0x47c0ba 0x47f3e7
+	heapRecordRE = regexp.MustCompile(`^(\d+): *(\d+) *\[ *(\d+): *(\d+) *\] *@(.*)$`)
+)
+
+// heapRecord is a stack in a text heap profile, before it is scaled.
+type heapRecord struct {
+	inuseObjects, inuseBytes int64
+	allocObjects, allocBytes int64
+	addrs                    []string
+	frames                   []stack.Frame
+}
+
+// ParseHeapText parses the text heap profile written by
+// /debug/pprof/heap?debug=1, so heap profiles can be rendered without
+// running pprof. Counts are scaled by the sampling rate in the same way as
+// pprof. The Limits, Focus and Granularity options are used.
+func ParseHeapText(input []byte, opts ParseOptions) (*stack.Profile, error) {
+	limits := opts.Limits.withDefaults()
+	if len(input) > limits.MaxInputSize {
+		return nil, &LimitError{"MaxInputSize", limits.MaxInputSize}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	scanner.Buffer(nil, limits.MaxLineLength)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("heap profile is empty")
+	}
+	header := heapHeaderRE.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+	if header == nil {
+		return nil, fmt.Errorf("malformed heap profile header: %v", scanner.Text())
+	}
+	rate, err := strconv.ParseInt(header[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if header[1] == "heap" {
+		// The runtime writes twice the sampling rate in the legacy header.
+		rate /= 2
+	}
+
+	var records []*heapRecord
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# runtime.MemStats"):
+			// The memory statistics follow the last stack.
+			return heapProfile(records, rate, opts)
+		case strings.HasPrefix(line, "#"):
+			if len(records) == 0 {
+				return nil, fmt.Errorf("malformed heap profile, frame before the first stack: %v", line)
+			}
+			r := records[len(records)-1]
+			r.frames = append(r.frames, parseHeapFrame(line))
+		default:
+			if len(records) >= limits.MaxSamples {
+				return nil, &LimitError{"MaxSamples", limits.MaxSamples}
+			}
+			r, err := parseHeapRecord(line)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return nil, &LimitError{"MaxLineLength", limits.MaxLineLength}
+		}
+		return nil, err
+	}
+	return heapProfile(records, rate, opts)
+}
+
+// parseHeapRecord parses the counts and addresses of a stack.
+func parseHeapRecord(line string) (*heapRecord, error) {
+	m := heapRecordRE.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("malformed heap profile stack: %v", line)
+	}
+	var counts [4]int64
+	for i := range counts {
+		v, err := strconv.ParseInt(m[i+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed heap profile stack: %v", line)
+		}
+		counts[i] = v
+	}
+	return &heapRecord{
+		inuseObjects: counts[0],
+		inuseBytes:   counts[1],
+		allocObjects: counts[2],
+		allocBytes:   counts[3],
+		addrs:        strings.Fields(m[5]),
+	}, nil
+}
+
+// parseHeapFrame parses a frame of a stack, which looks like:
+//
+//	#	0x4de7a8	main.alloc+0x88		/tmp/prof/main.go:13
+func parseHeapFrame(line string) stack.Frame {
+	fields := strings.Fields(strings.TrimPrefix(line, "#"))
+	if len(fields) < 2 {
+		return stack.Frame{Func: strings.Join(fields, " ")}
+	}
+
+	f := stack.Frame{Func: fields[1]}
+	if idx := strings.LastIndex(f.Func, "+0x"); idx > 0 {
+		f.Func = f.Func[:idx]
+	}
+	if len(fields) > 2 {
+		if fl, ok := parseFileLine(fields[2]); ok {
+			f.File, f.Line = fl.file, fl.line
+		}
+	}
+	return f
+}
+
+// heapProfile scales the records, and merges records with the same stack.
+func heapProfile(records []*heapRecord, rate int64, opts ParseOptions) (*stack.Profile, error) {
+	profile, err := stack.NewProfile(heapSampleNames)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make(map[string]*stack.Sample)
+	for _, r := range records {
+		frames := r.frames
+		if len(frames) == 0 {
+			// Stacks are only symbolized if the binary has symbols.
+			for _, addr := range r.addrs {
+				frames = append(frames, stack.Frame{Func: addr})
+			}
+		}
+		// Frames are leaf first, but samples are parent first.
+		parentFirst := make([]stack.Frame, len(frames))
+		for i, f := range frames {
+			parentFirst[len(frames)-1-i] = f
+		}
+
+		funcs := make([]string, len(parentFirst))
+		for i, f := range parentFirst {
+			funcs[i] = f.Func
+		}
+		if !opts.Focus.Keep(funcs) {
+			continue
+		}
+
+		allocObjects, allocBytes := scaleHeapSample(r.allocObjects, r.allocBytes, rate)
+		inuseObjects, inuseBytes := scaleHeapSample(r.inuseObjects, r.inuseBytes, rate)
+		counts := []int64{allocObjects, allocBytes, inuseObjects, inuseBytes}
+
+		names := opts.Granularity.Names(parentFirst)
+		key := strings.Join(names, ";")
+		if sample, ok := samples[key]; ok {
+			if err := sample.Add(counts); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		sample := stack.NewSample(names, counts)
+		samples[key] = sample
+		profile.Samples = append(profile.Samples, sample)
+	}
+	return profile, nil
+}
+
+// scaleHeapSample estimates the number and size of objects that were
+// allocated from the sampled counts, in the same way as pprof. Each object
+// of average size s is sampled with probability 1-exp(-s/rate).
+func scaleHeapSample(count, size, rate int64) (int64, int64) {
+	if count == 0 || size == 0 {
+		return 0, 0
+	}
+	if rate <= 1 {
+		// Every allocation was sampled.
+		return count, size
+	}
+
+	avgSize := float64(size) / float64(count)
+	scale := 1 / (1 - math.Exp(-avgSize/float64(rate)))
+	return int64(float64(count) * scale), int64(float64(size) * scale)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+func TestParseHeapText(t *testing.T) {
+	input, err := ioutil.ReadFile("testdata/heap-debug1.txt")
+	require.NoError(t, err, "failed to read test heap profile")
+
+	profile, err := ParseHeapText(input, ParseOptions{})
+	require.NoError(t, err, "ParseHeapText failed")
+	assert.Equal(t, heapSampleNames, profile.SampleNames)
+
+	// The totals match those of the same profile read by pprof, which
+	// scales the samples in the same way.
+	totals := make([]int64, len(profile.SampleNames))
+	for _, s := range profile.Samples {
+		for i, c := range s.Counts {
+			totals[i] += c
+		}
+	}
+	assert.Equal(t, []int64{2039, 8441837, 2029, 8336375}, totals)
+
+	var allocStack []string
+	for _, s := range profile.Samples {
+		if s.Funcs[len(s.Funcs)-1] == "main.alloc" {
+			allocStack = s.Funcs
+		}
+	}
+	assert.Equal(t, []string{"runtime.main", "main.main", "main.alloc"}, allocStack)
+}
+
+func TestParseHeapTextOptions(t *testing.T) {
+	input := []byte(`heap profile: 3: 3072 [3: 3072] @ heap_v2/1
+1: 1024 [1: 1024] @ 0x1 0x2
+#	0x1	main.a+0x10	/src/main.go:10
+#	0x2	main.main+0x20	/src/main.go:20
+
+2: 2048 [2: 2048] @ 0x3 0x2
+#	0x3	main.b+0x10	/src/main.go:30
+#	0x2	main.main+0x20	/src/main.go:20
+
+0: 0 [0: 0] @ 0x4 0x5
+
+# runtime.MemStats
+# Alloc = 3072
+`)
+	profile, err := ParseHeapText(input, ParseOptions{})
+	require.NoError(t, err, "ParseHeapText failed")
+	expected := []*stack.Sample{
+		{Funcs: []string{"main.main", "main.a"}, Counts: []int64{1, 1024, 1, 1024}},
+		{Funcs: []string{"main.main", "main.b"}, Counts: []int64{2, 2048, 2, 2048}},
+		{Funcs: []string{"0x5", "0x4"}, Counts: []int64{0, 0, 0, 0}},
+	}
+	assert.Equal(t, expected, profile.Samples, "rate 1 samples should not be scaled")
+
+	profile, err = ParseHeapText(input, ParseOptions{Granularity: stack.FileGranularity})
+	require.NoError(t, err, "ParseHeapText failed")
+	assert.Equal(t, []string{"/src/main.go"}, profile.Samples[0].Funcs)
+	assert.Equal(t, []int64{3, 3072, 3, 3072}, profile.Samples[0].Counts, "stacks in the same file should be merged")
+
+	profile, err = ParseHeapText(input, ParseOptions{Focus: stack.FocusFilter{Focus: regexp.MustCompile(`^main\.b$`)}})
+	require.NoError(t, err, "ParseHeapText failed")
+	require.Len(t, profile.Samples, 1)
+	assert.Equal(t, []string{"main.main", "main.b"}, profile.Samples[0].Funcs)
+}
+
+func TestParseHeapTextErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		errMsg string
+	}{
+		{"", "heap profile is empty"},
+		{"Samples:\n", "malformed heap profile header"},
+		{"heap profile: 1: 1 [1: 1] @ heap/2\n#\t0x1\tmain.a\n", "frame before the first stack"},
+		{"heap profile: 1: 1 [1: 1] @ heap/2\n1: 1 @ 0x1\n", "malformed heap profile stack"},
+	}
+	for _, tt := range tests {
+		_, err := ParseHeapText([]byte(tt.input), ParseOptions{})
+		if assert.Error(t, err, "ParseHeapText(%q) should fail", tt.input) {
+			assert.True(t, strings.Contains(err.Error(), tt.errMsg), "ParseHeapText(%q) got error %v, want %q", tt.input, err, tt.errMsg)
+		}
+	}
+
+	_, err := ParseHeapText([]byte("heap profile: 1: 1 [1: 1] @ heap/2\n"), ParseOptions{Limits: Limits{MaxInputSize: 10}})
+	assert.Equal(t, &LimitError{"MaxInputSize", 10}, err)
+}
+
+func TestScaleHeapSample(t *testing.T) {
+	count, size := scaleHeapSample(1, 40960, 4096)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, int64(40961), size)
+
+	count, size = scaleHeapSample(10, 160, 512*1024)
+	assert.True(t, count > 10000 && size > 160000, "small objects should be scaled up, got %v, %v", count, size)
+
+	count, size = scaleHeapSample(0, 0, 512*1024)
+	assert.Equal(t, int64(0), count+size)
+}
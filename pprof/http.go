@@ -0,0 +1,205 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/uber/go-torch/torchlog"
+)
+
+// maxErrorBody is the most of an error response that is included in errors.
+const maxErrorBody = 1024
+
+// unixURLPrefix is the prefix of base URLs of a Unix domain socket,
+// e.g. unix:///var/run/app.sock:/debug/pprof/profile.
+const unixURLPrefix = "unix://"
+
+var errCertWithoutKey = errors.New("client certificate and key must be specified together")
+
+// SplitUnixURL splits a base URL of a Unix socket, unix://<socket>[:<path>],
+// into the path of the socket and an http URL that is fetched over the
+// socket. ok is false if baseURL is not a Unix socket URL.
+func SplitUnixURL(baseURL string) (socket, httpURL string, ok bool) {
+	if !strings.HasPrefix(baseURL, unixURLPrefix) {
+		return "", "", false
+	}
+
+	socket = strings.TrimPrefix(baseURL, unixURLPrefix)
+	path := ""
+	if idx := strings.Index(socket, ":"); idx >= 0 {
+		socket, path = socket[:idx], socket[idx+1:]
+	}
+	return socket, "http://localhost" + path, true
+}
+
+// download fetches the profile for opts into a temporary file, and returns
+// the name of the file. The caller must remove the file.
+func download(ctx context.Context, opts Options) (string, error) {
+	f, err := ioutil.TempFile("", "go-torch-profile")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+
+	if err := Download(ctx, opts, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Download fetches the profile for opts from opts.BaseURL, and writes it to
+// file. The file is not written if the profile cannot be fetched.
+func Download(ctx context.Context, opts Options, file string) error {
+	profileURL, err := profileURL(opts)
+	if err != nil {
+		return err
+	}
+	client, err := newHTTPClient(opts)
+	if err != nil {
+		return err
+	}
+	req, err := newRequest(ctx, opts, profileURL)
+	if err != nil {
+		return err
+	}
+
+	if socket, _, ok := SplitUnixURL(opts.BaseURL); ok {
+		torchlog.Printf("Fetching profile from %v over %v", profileURL, socket)
+	} else {
+		torchlog.Printf("Fetching profile from %v", profileURL)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("fetch stopped: %v", ctxErr)
+		}
+		return fmt.Errorf("could not fetch profile: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("could not fetch profile from %v: %v: %s", profileURL, resp.Status, body)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		os.Remove(file)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("fetch stopped: %v", ctxErr)
+		}
+		return fmt.Errorf("could not read profile: %v", err)
+	}
+	return nil
+}
+
+// newRequest returns a request for the profile with the headers and basic
+// auth credentials of opts.
+func newRequest(ctx context.Context, opts Options, profileURL string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", profileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, header := range opts.Headers {
+		parts := strings.SplitN(header, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("header %q must be in the form \"Name: value\"", header)
+		}
+		value := strings.TrimSpace(parts[1])
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Add(name, value)
+	}
+
+	if opts.BasicAuth != "" {
+		parts := strings.SplitN(opts.BasicAuth, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("basic auth must be in the form user:password")
+		}
+		req.SetBasicAuth(parts[0], parts[1])
+	}
+	return req.WithContext(ctx), nil
+}
+
+// newHTTPClient returns a client that uses the TLS options of opts, and
+// connects to the Unix socket of opts.BaseURL if it has one.
+func newHTTPClient(opts Options) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.Insecure}
+
+	if opts.Cert != "" || opts.Key != "" {
+		if opts.Cert == "" || opts.Key == "" {
+			return nil, errCertWithoutKey
+		}
+		cert, err := tls.LoadX509KeyPair(opts.Cert, opts.Key)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.CA != "" {
+		pem, err := ioutil.ReadFile(opts.CA)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA certificate: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", opts.CA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	if socket, _, ok := SplitUnixURL(opts.BaseURL); ok {
+		// Services that only expose pprof on a Unix socket are fetched over
+		// the socket, whatever the host of the request.
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+	return &http.Client{Transport: transport}, nil
+}
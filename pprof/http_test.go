@@ -0,0 +1,268 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readProfile(t *testing.T) []byte {
+	profile, err := ioutil.ReadFile("testdata/pprof.1.pb.gz")
+	if err != nil {
+		t.Fatalf("Failed to read test profile: %v", err)
+	}
+	return profile
+}
+
+func TestGetRawFromURL(t *testing.T) {
+	profile := readProfile(t)
+	var gotReq *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotReq = r
+		w.Write(profile)
+	}))
+	defer server.Close()
+
+	opts := Options{
+		BaseURL:     server.URL,
+		URLSuffix:   "/debug/pprof/profile",
+		TimeSeconds: 7,
+		Headers:     []string{"X-Auth-Token: secret", "Host: myapp.internal"},
+		BasicAuth:   "user:pass:word",
+	}
+	raw, err := GetRaw(context.Background(), opts, nil)
+	if err != nil {
+		t.Fatalf("GetRaw failed: %v", err)
+	}
+	if !strings.Contains(string(raw), "main.fib") {
+		t.Errorf("pprof raw output is missing main.fib, got:\n%s", raw)
+	}
+
+	if gotReq.URL.Path != "/debug/pprof/profile" || gotReq.URL.Query().Get("seconds") != "7" {
+		t.Errorf("Unexpected request URL %v", gotReq.URL)
+	}
+	if got := gotReq.Header.Get("X-Auth-Token"); got != "secret" {
+		t.Errorf("Expected X-Auth-Token header secret, got %q", got)
+	}
+	if gotReq.Host != "myapp.internal" {
+		t.Errorf("Expected Host myapp.internal, got %q", gotReq.Host)
+	}
+	if user, pass, ok := gotReq.BasicAuth(); !ok || user != "user" || pass != "pass:word" {
+		t.Errorf("Unexpected basic auth %q %q %v", user, pass, ok)
+	}
+}
+
+func TestSplitUnixURL(t *testing.T) {
+	tests := []struct {
+		baseURL     string
+		wantSocket  string
+		wantHTTPURL string
+		wantOK      bool
+	}{
+		{baseURL: "http://localhost:8080"},
+		{baseURL: "/var/run/app.sock"},
+		{
+			baseURL:     "unix:///var/run/app.sock",
+			wantSocket:  "/var/run/app.sock",
+			wantHTTPURL: "http://localhost",
+			wantOK:      true,
+		},
+		{
+			baseURL:     "unix:///var/run/app.sock:/debug/pprof/profile",
+			wantSocket:  "/var/run/app.sock",
+			wantHTTPURL: "http://localhost/debug/pprof/profile",
+			wantOK:      true,
+		},
+		{
+			baseURL:     "unix://app.sock:/prefix",
+			wantSocket:  "app.sock",
+			wantHTTPURL: "http://localhost/prefix",
+			wantOK:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		socket, httpURL, ok := SplitUnixURL(tt.baseURL)
+		if socket != tt.wantSocket || httpURL != tt.wantHTTPURL || ok != tt.wantOK {
+			t.Errorf("SplitUnixURL(%v) got (%q, %q, %v), want (%q, %q, %v)", tt.baseURL,
+				socket, httpURL, ok, tt.wantSocket, tt.wantHTTPURL, tt.wantOK)
+		}
+	}
+}
+
+func TestGetRawFromUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-unix")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "app.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %v: %v", socket, err)
+	}
+
+	profile := readProfile(t)
+	var gotPath string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Write(profile)
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	opts := Options{
+		BaseURL:   "unix://" + socket + ":/debug/pprof/profile",
+		URLSuffix: "/debug/pprof/profile",
+	}
+	raw, err := GetRaw(context.Background(), opts, nil)
+	if err != nil {
+		t.Fatalf("GetRaw over a Unix socket failed: %v", err)
+	}
+	if !strings.Contains(string(raw), "main.fib") {
+		t.Errorf("pprof raw output is missing main.fib, got:\n%s", raw)
+	}
+	if gotPath != "/debug/pprof/profile" {
+		t.Errorf("Unexpected request path %v", gotPath)
+	}
+
+	opts.BaseURL = "unix://" + filepath.Join(dir, "missing.sock")
+	if _, err := GetRaw(context.Background(), opts, nil); err == nil {
+		t.Errorf("GetRaw with a missing socket expected to fail")
+	}
+}
+
+func TestGetRawHTTPErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		opts    Options
+		wantErr string
+	}{
+		{
+			opts:    Options{BaseURL: server.URL},
+			wantErr: "401 Unauthorized: authentication required",
+		},
+		{
+			opts:    Options{BaseURL: server.URL, Headers: []string{"no-colon"}},
+			wantErr: `header "no-colon" must be in the form "Name: value"`,
+		},
+		{
+			opts:    Options{BaseURL: server.URL, BasicAuth: "user"},
+			wantErr: "basic auth must be in the form user:password",
+		},
+		{
+			opts:    Options{BaseURL: server.URL, Cert: "client.pem"},
+			wantErr: errCertWithoutKey.Error(),
+		},
+		{
+			opts:    Options{BaseURL: server.URL, Cert: "missing.pem", Key: "missing.key"},
+			wantErr: "could not load client certificate",
+		},
+		{
+			opts:    Options{BaseURL: server.URL, CA: "testdata/pprof.1.pb.gz"},
+			wantErr: "no certificates found in testdata/pprof.1.pb.gz",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := GetRaw(context.Background(), tt.opts, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("GetRaw(%+v) expected error %q, got %v", tt.opts, tt.wantErr, err)
+		}
+	}
+}
+
+func TestGetRawTLS(t *testing.T) {
+	profile := readProfile(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(profile)
+	}))
+	defer server.Close()
+
+	caFile, err := ioutil.TempFile("", "go-torch-ca")
+	if err != nil {
+		t.Fatalf("Failed to create CA file: %v", err)
+	}
+	defer os.Remove(caFile.Name())
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	caFile.Close()
+
+	tests := []struct {
+		msg     string
+		opts    Options
+		wantErr bool
+	}{
+		{
+			msg:     "unknown CA",
+			opts:    Options{BaseURL: server.URL},
+			wantErr: true,
+		},
+		{
+			msg:  "CA",
+			opts: Options{BaseURL: server.URL, CA: caFile.Name()},
+		},
+		{
+			msg:  "insecure",
+			opts: Options{BaseURL: server.URL, Insecure: true},
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := GetRaw(context.Background(), tt.opts, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: wantErr %v got error: %v", tt.msg, tt.wantErr, err)
+		}
+	}
+}
+
+func TestGetRawFetchCancelled(t *testing.T) {
+	// The server never responds, so the fetch only stops once it is cancelled.
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := GetRaw(ctx, Options{BaseURL: server.URL}, nil)
+	if err == nil || !strings.Contains(err.Error(), "fetch stopped") {
+		t.Errorf("expected fetch to be stopped when the context times out, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import "fmt"
+
+// Limits bound the resources used when parsing untrusted input. Any limit
+// that is zero uses the value from DefaultLimits.
+type Limits struct {
+	// MaxInputSize is the maximum size of the raw pprof output in bytes.
+	MaxInputSize int
+	// MaxLineLength is the maximum length of a single line in bytes.
+	MaxLineLength int
+	// MaxRecords is the maximum number of sample, location and mapping lines.
+	MaxRecords int
+	// MaxSamples is the maximum number of sample lines.
+	MaxSamples int
+	// MaxFunctions is the maximum number of locations with function names.
+	MaxFunctions int
+	// MaxStackDepth is the maximum number of frames in a single sample.
+	MaxStackDepth int
+}
+
+// DefaultLimits are the limits used for any limit that is not set. They are
+// large enough for any profile captured by the Go runtime.
+var DefaultLimits = Limits{
+	MaxInputSize:  1 << 30,
+	MaxLineLength: 1 << 20,
+	MaxRecords:    1 << 24,
+	MaxSamples:    1 << 24,
+	MaxFunctions:  1 << 22,
+	MaxStackDepth: 1 << 16,
+}
+
+// withDefaults returns a copy of the limits, with unset limits set to
+// the default.
+func (l Limits) withDefaults() Limits {
+	setDefault := func(v *int, def int) {
+		if *v <= 0 {
+			*v = def
+		}
+	}
+	setDefault(&l.MaxInputSize, DefaultLimits.MaxInputSize)
+	setDefault(&l.MaxLineLength, DefaultLimits.MaxLineLength)
+	setDefault(&l.MaxRecords, DefaultLimits.MaxRecords)
+	setDefault(&l.MaxSamples, DefaultLimits.MaxSamples)
+	setDefault(&l.MaxFunctions, DefaultLimits.MaxFunctions)
+	setDefault(&l.MaxStackDepth, DefaultLimits.MaxStackDepth)
+	return l
+}
+
+// LimitError is returned when the input exceeds one of the Limits.
+type LimitError struct {
+	// Limit is the name of the field in Limits that was exceeded.
+	Limit string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("input exceeds %v of %v", e.Limit, e.Max)
+}
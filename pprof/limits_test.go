@@ -0,0 +1,41 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitsWithDefaults(t *testing.T) {
+	assert.Equal(t, DefaultLimits, Limits{}.withDefaults(), "zero limits should use the defaults")
+
+	limits := Limits{MaxStackDepth: 64, MaxSamples: -1}.withDefaults()
+	assert.Equal(t, 64, limits.MaxStackDepth, "set limits should be kept")
+	assert.Equal(t, DefaultLimits.MaxSamples, limits.MaxSamples, "negative limits should use the default")
+	assert.Equal(t, DefaultLimits.MaxLineLength, limits.MaxLineLength, "unset limits should use the default")
+}
+
+func TestLimitError(t *testing.T) {
+	err := &LimitError{Limit: "MaxSamples", Max: 10}
+	assert.Equal(t, "input exceeds MaxSamples of 10", err.Error())
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import "fmt"
+
+// MissingFunctions is how frames are named for locations that have no
+// function name, such as code without symbols.
+type MissingFunctions int
+
+// Ways to handle locations without a function name.
+const (
+	// KeepMissing names each location missing-function-<location ID>, the
+	// default.
+	KeepMissing MissingFunctions = iota
+	// DropMissing removes the frames, so their callees are attributed to
+	// their callers.
+	DropMissing
+	// UnknownMissing merges the frames into a single [unknown] frame.
+	UnknownMissing
+	// AddressMissing names the frames by their address, or as KeepMissing
+	// if the address is not known.
+	AddressMissing
+)
+
+// unknownFunction is the name of frames merged by UnknownMissing.
+const unknownFunction = "[unknown]"
+
+var missingFunctionsNames = map[MissingFunctions]string{
+	KeepMissing:    "keep",
+	DropMissing:    "drop",
+	UnknownMissing: "unknown",
+	AddressMissing: "address",
+}
+
+// ParseMissingFunctions returns the MissingFunctions with the given name,
+// which is one of keep, drop, unknown or address.
+func ParseMissingFunctions(name string) (MissingFunctions, error) {
+	for m, n := range missingFunctionsNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return KeepMissing, fmt.Errorf("unknown missing functions handling %q, must be keep, drop, unknown or address", name)
+}
+
+func (m MissingFunctions) String() string {
+	if name, ok := missingFunctionsNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("MissingFunctions(%d)", int(m))
+}
+
+// describe returns how the frames of missing functions were handled, for
+// the summary warning.
+func (m MissingFunctions) describe() string {
+	switch m {
+	case DropMissing:
+		return "dropped"
+	case UnknownMissing:
+		return "merged into " + unknownFunction
+	case AddressMissing:
+		return "named by their address"
+	}
+	return "named missing-function-<location>"
+}
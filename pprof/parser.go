@@ -23,11 +23,15 @@ package pprof
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/uber/go-torch/stack"
 )
@@ -51,47 +55,189 @@ type rawParser struct {
 
 	state       readState
 	funcNames   map[funcID]string
+	fileLines   map[funcID]fileLine
+	addresses   map[funcID]string
 	sampleNames []string
-	records     []*stackRecord
+	mappings    []*stack.Mapping
+	duration    time.Duration
+	periodType  string
+	period      time.Duration
+
+	warn          stack.WarningFunc
+	missingWarned map[funcID]bool
+	missing       MissingFunctions
+
+	// records are the unique stacks in the order they were first seen, with
+	// the samples of each stack summed as they are read. pending is the last
+	// sample line, which is only added once its labels have been read.
+	records []*stackRecord
+	stacks  map[uint64][]stackEntry
+	pending *stackRecord
+
+	limits     Limits
+	numRecords int
+	numSamples int
+
+	focus       stack.FocusFilter
+	focusByFunc map[funcID]focusMatch
+
+	granularity stack.Granularity
+
+	// format records the variations of the output that were seen.
+	format rawFormat
+
+	// inlined are the functions that the function of each location was
+	// inlined into, from the innermost to the outermost, unless noInlines
+	// is set. lastLocation is the location that inlined lines belong to.
+	inlined         map[funcID][]stack.Frame
+	numInlined      int
+	noInlines       bool
+	lastLocation    funcID
+	hasLastLocation bool
+}
+
+// fileLine is the source location of a Location in the pprof raw output.
+type fileLine struct {
+	file string
+	line int
+}
+
+// focusMatch caches whether a function matches the focus filter.
+type focusMatch struct {
+	focus, ignore bool
+}
+
+// ParseOptions are optional parameters for ParseRawWithOptions.
+type ParseOptions struct {
+	// OnWarning is called for each non-fatal problem found in the input,
+	// such as skipped lines and missing function names.
+	OnWarning stack.WarningFunc
+
+	// Limits bound the resources used to parse the input. If a limit is
+	// exceeded, a *LimitError is returned.
+	Limits Limits
+
+	// Focus drops samples before they are aggregated, so profiles that are
+	// restricted to a small part of a large program use less memory.
+	Focus stack.FocusFilter
+
+	// Granularity names frames by their function (the default), package,
+	// file or line. Frames are renamed before samples are aggregated, so
+	// samples with the same package or file stacks are merged.
+	Granularity stack.Granularity
+
+	// MissingFunctions is how frames of locations without a function name
+	// are named. If any samples have such frames, a MissingFunctionSamples
+	// warning summarizes how many.
+	MissingFunctions MissingFunctions
+
+	// NoInlines names each location by the function that its other
+	// functions were inlined into, as with pprof -noinlines, rather than
+	// adding a frame for each function that was inlined.
+	NoInlines bool
+
+	// BreakCycles removes the lightest call of each cycle in a dot graph,
+	// counting its weight as the caller's own, before stacks are rebuilt.
+	// It is only used by ParseDot.
+	BreakCycles bool
 }
 
 // ParseRaw parses the raw pprof output and returns call stacks.
 func ParseRaw(input []byte) (*stack.Profile, error) {
+	return ParseRawWithOptions(input, ParseOptions{})
+}
+
+// ParseRawWithOptions parses the raw pprof output using the given options
+// and returns call stacks. It returns an error rather than panicking for
+// any input, so it is safe to use with untrusted input.
+func ParseRawWithOptions(input []byte, opts ParseOptions) (*stack.Profile, error) {
+	return ParseRawReader(bytes.NewReader(input), opts)
+}
+
+// ParseRawReader is like ParseRawWithOptions, but parses the raw pprof output
+// as it is read from r. Samples are summed by stack as they are read, so
+// only the unique stacks are held in memory rather than the whole output.
+func ParseRawReader(r io.Reader, opts ParseOptions) (*stack.Profile, error) {
 	parser := newRawParser()
-	if err := parser.parse(input); err != nil {
+	parser.warn = opts.OnWarning
+	parser.limits = opts.Limits.withDefaults()
+	parser.focus = opts.Focus
+	parser.granularity = opts.Granularity
+	parser.missing = opts.MissingFunctions
+	parser.noInlines = opts.NoInlines
+	if err := parser.parse(r); err != nil {
 		return nil, err
 	}
-
 	return parser.toProfile()
 }
 
 func newRawParser() *rawParser {
 	return &rawParser{
-		funcNames: make(map[funcID]string),
+		funcNames:     make(map[funcID]string),
+		fileLines:     make(map[funcID]fileLine),
+		addresses:     make(map[funcID]string),
+		missingWarned: make(map[funcID]bool),
+		focusByFunc:   make(map[funcID]focusMatch),
+		stacks:        make(map[uint64][]stackEntry),
+		inlined:       make(map[funcID][]stack.Frame),
+		limits:        DefaultLimits,
 	}
 }
 
-func (p *rawParser) parse(input []byte) error {
-	reader := bufio.NewReader(bytes.NewReader(input))
+func (p *rawParser) parse(r io.Reader) error {
+	input := &limitedReader{r: r, max: p.limits.MaxInputSize}
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(nil, p.limits.MaxLineLength)
 
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				if p.state < locations {
-					p.setError(fmt.Errorf("parser ended before processing locations, state: %v", p.state))
-				}
-				break
+	for p.err == nil && scanner.Scan() {
+		if p.state >= samples {
+			p.numRecords++
+			if p.numRecords > p.limits.MaxRecords {
+				return &LimitError{"MaxRecords", p.limits.MaxRecords}
 			}
-			return err
 		}
 
-		p.processLine(strings.TrimSpace(line))
+		p.processLine(strings.TrimSpace(scanner.Text()))
+	}
+	if input.exceeded {
+		return &LimitError{"MaxInputSize", p.limits.MaxInputSize}
+	}
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return &LimitError{"MaxLineLength", p.limits.MaxLineLength}
+		}
+		return err
 	}
 
+	if p.state < locations {
+		p.setError(fmt.Errorf("parser ended before processing locations, state: %v", p.state))
+	}
 	return p.err
 }
 
+// limitedReader reads from r until more than max bytes have been read, and
+// then reports that the limit was exceeded by returning io.EOF.
+type limitedReader struct {
+	r        io.Reader
+	max      int
+	read     int
+	exceeded bool
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	if l.read > l.max {
+		l.exceeded = true
+		return 0, io.EOF
+	}
+	if len(b) > l.max-l.read+1 {
+		// Read at most one byte past the limit to detect that it was exceeded.
+		b = b[:l.max-l.read+1]
+	}
+	n, err := l.r.Read(b)
+	l.read += n
+	return n, err
+}
+
 func (p *rawParser) setError(err error) {
 	if p.err != nil {
 		return
@@ -106,23 +252,44 @@ func (p *rawParser) processLine(line string) {
 			p.state = samplesHeader
 			return
 		}
+		if strings.HasPrefix(line, "Duration:") {
+			// The duration is optional, so a malformed duration is ignored.
+			p.duration, _ = time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(line, "Duration:")))
+		}
+		if strings.HasPrefix(line, "PeriodType:") {
+			p.periodType = strings.TrimSpace(strings.TrimPrefix(line, "PeriodType:"))
+		}
+		if strings.HasPrefix(line, "Period:") && p.periodType == "cpu nanoseconds" {
+			// Like the duration, the period is optional.
+			period, _ := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "Period:")), 10, 64)
+			p.period = time.Duration(period)
+		}
 	case samplesHeader:
-		p.sampleNames = strings.Split(line, " ")
+		p.sampleNames = p.format.parseSampleNames(line)
+		for i, name := range p.sampleNames {
+			// Use the same names for each sample type across Go versions.
+			p.sampleNames[i] = stack.ParseSampleType(name).String()
+		}
 		p.state = samples
 	case samples:
 		if strings.HasPrefix(line, "Locations") {
+			p.addPending()
 			p.state = locations
 			return
 		}
 		p.addSample(line)
 	case locations:
+		if line == "" {
+			// Ignore blank lines, such as trailing whitespace at the end of the input.
+			return
+		}
 		if strings.HasPrefix(line, "Mappings") {
 			p.state = mappings
 			return
 		}
 		p.addLocation(line)
 	case mappings:
-		// Nothing to process.
+		p.addMapping(line)
 	}
 }
 
@@ -133,61 +300,248 @@ func (p *rawParser) toProfile() (*stack.Profile, error) {
 		return nil, err
 	}
 
-	samples := make(map[string]*stack.Sample)
-	for _, r := range p.records {
-		funcNames := r.funcNames(p.funcNames)
-		funcKey := strings.Join(funcNames, ";")
+	// Samples are returned in the order each stack was first seen, so the
+	// output is stable for a given input.
+	workers := 1
+	if len(p.records) >= parallelRecords {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	named, missingSamples, totalSamples := p.nameRecords(workers)
+	if profile.Samples, err = aggregateRecords(named, workers); err != nil {
+		return nil, err
+	}
+	profile.Mappings = p.mappings
+	profile.Duration = p.duration
+	profile.Period = p.period
 
-		if sample, ok := samples[funcKey]; ok {
-			if err := sample.Add(r.samples); err != nil {
-				return nil, err
+	if missingSamples > 0 {
+		p.warn.Warn(stack.MissingFunctionSamples, "", "%v of %v %v (%.1f%%) have frames without a function name, which were %v",
+			missingSamples, totalSamples, p.sampleNames[0], 100*float64(missingSamples)/float64(totalSamples), p.missing.describe())
+	}
+	if (p.granularity == stack.FileGranularity || p.granularity == stack.LineGranularity) && len(p.funcNames) > 0 && !p.hasLineInformation() {
+		p.warn.Warn(stack.NoLineInformation, "", "the profile has no source lines, so frames are named by their function rather than their %v; "+
+			"the binary may have been built without debug information", p.granularity)
+	}
+	return profile, nil
+}
+
+// hasLineInformation returns whether any location has a source file.
+func (p *rawParser) hasLineInformation() bool {
+	if len(p.fileLines) > 0 {
+		return true
+	}
+	for _, frames := range p.inlined {
+		for _, f := range frames {
+			if f.File != "" {
+				return true
 			}
-			continue
 		}
+	}
+	return false
+}
 
-		samples[funcKey] = stack.NewSample(funcNames, r.samples)
+// hasMissing returns whether the record has locations without a function name.
+func (p *rawParser) hasMissing(r *stackRecord) bool {
+	for _, id := range r.stack {
+		if _, ok := p.funcNames[id]; !ok {
+			return true
+		}
 	}
+	return false
+}
 
-	profile.Samples = make([]*stack.Sample, 0, len(samples))
-	for _, s := range samples {
-		profile.Samples = append(profile.Samples, s)
+// mergeMissing removes the frames of missing functions if they are dropped,
+// or merges consecutive frames if they are merged into unknownFunction.
+func (p *rawParser) mergeMissing(frames []stack.Frame) []stack.Frame {
+	if p.missing != DropMissing && p.missing != UnknownMissing {
+		return frames
+	}
+	merged := frames[:0]
+	for i, f := range frames {
+		switch {
+		case p.missing == DropMissing && f.Func == "":
+			continue
+		case p.missing == UnknownMissing && f.Func == unknownFunction && i > 0 && frames[i-1].Func == unknownFunction:
+			continue
+		}
+		merged = append(merged, f)
 	}
+	return merged
+}
 
-	return profile, nil
+// keepRecord returns whether the record passes the focus filter. It only
+// matches each function once, and does not build the function names of
+// records that are dropped.
+func (p *rawParser) keepRecord(r *stackRecord) bool {
+	if !p.focus.Enabled() {
+		return true
+	}
+
+	focused := p.focus.Focus == nil
+	for _, id := range r.stack {
+		m, ok := p.focusByFunc[id]
+		if !ok {
+			// Missing functions have no name to match.
+			if funcName, ok := p.funcNames[id]; ok {
+				m.focus, m.ignore = p.focus.Match(funcName)
+			}
+			for _, f := range p.inlined[id] {
+				focus, ignore := p.focus.Match(f.Func)
+				m.focus, m.ignore = m.focus || focus, m.ignore || ignore
+			}
+			p.focusByFunc[id] = m
+		}
+		if m.ignore {
+			return false
+		}
+		focused = focused || m.focus
+	}
+	return focused
 }
 
 // addLocation parses a location that looks like:
 //   292: 0x49dee1 github.com/uber/tchannel/golang.(*Frame).ReadIn :0 s=0
-// and creates a mapping from funcID to function name.
+// and creates a mapping from funcID to function name. See parseLocation for
+// the variations of location lines.
 func (p *rawParser) addLocation(line string) {
-	parts := splitBySpace(line)
-	if len(parts) < 4 {
-		switch {
-		case len(parts) == 2:
-			// Some lines just have an ID and an address, we can ignore those.
-		case len(parts) == 3 && strings.HasPrefix(parts[2], "M="):
-			// Some lines have an ID, a mapping ID and an address, we can
-			// ignore those as well.
-		case len(parts) == 3 && strings.HasPrefix(parts[2], "s="):
-			// See https://github.com/uber/go-torch/issues/63#issuecomment-315658039.
-			// The raw "format" sometimes prints multiple lines per location. We can't
-			// see previous lines here, so for now we just skip them.
-		default:
-			p.setError(fmt.Errorf("malformed location line: %v", line))
+	loc, ok := p.format.parseLocation(line)
+	if !ok {
+		p.setError(fmt.Errorf("malformed location line: %v", line))
+		return
+	}
+	switch {
+	case loc.inlined:
+		p.addInlined(line, loc)
+		return
+	case loc.funcName == "":
+		// Some lines just have an ID and an address, and possibly a
+		// mapping ID, but no function name. The address is used to
+		// name the frame if requested.
+		p.addresses[p.toFuncID(loc.id)] = loc.address
+		p.hasLastLocation = false
+		return
+	}
+	if len(p.funcNames)+p.numInlined >= p.limits.MaxFunctions {
+		p.setError(&LimitError{"MaxFunctions", p.limits.MaxFunctions})
+		return
+	}
+
+	funcID := p.toFuncID(loc.id)
+	p.funcNames[funcID] = loc.funcName
+	if loc.hasFileLine {
+		p.fileLines[funcID] = loc.fileLine
+	}
+	p.lastLocation, p.hasLastLocation = funcID, true
+}
+
+// addInlined adds an additional line of the previous location, which is
+// the function that the previous line was inlined into. See
+// https://github.com/uber/go-torch/issues/63#issuecomment-315658039.
+func (p *rawParser) addInlined(line string, loc rawLocation) {
+	if !p.hasLastLocation {
+		p.warn.Warn(stack.SkippedLine, line, "skipped inlined frame %v of a location without a function name", loc.funcName)
+		return
+	}
+	if p.noInlines {
+		// The location is named by the outermost function, which is on
+		// its last line, as with pprof -noinlines.
+		p.funcNames[p.lastLocation] = loc.funcName
+		if loc.hasFileLine {
+			p.fileLines[p.lastLocation] = loc.fileLine
+		} else {
+			delete(p.fileLines, p.lastLocation)
 		}
 		return
 	}
-	funcID := p.toFuncID(strings.TrimSuffix(parts[0], ":"))
-	if strings.HasPrefix(parts[2], "M=") {
-		p.funcNames[funcID] = parts[3]
-	} else {
-		p.funcNames[funcID] = parts[2]
+	if len(p.funcNames)+p.numInlined >= p.limits.MaxFunctions {
+		p.setError(&LimitError{"MaxFunctions", p.limits.MaxFunctions})
+		return
+	}
+
+	p.numInlined++
+	p.inlined[p.lastLocation] = append(p.inlined[p.lastLocation], stack.Frame{
+		Func: loc.funcName,
+		File: loc.fileLine.file,
+		Line: loc.fileLine.line,
+	})
+}
+
+// parseFileLine parses the source location of a location, which looks like
+// /src/pkg/file.go:123, or :0 if the profile has no line information.
+// Newer versions of pprof also print the column, e.g. /src/pkg/file.go:123:5.
+func parseFileLine(s string) (fileLine, bool) {
+	file, line, ok := cutNumber(s)
+	if !ok {
+		return fileLine{}, false
+	}
+	if f, l, ok := cutNumber(file); ok {
+		// The column is not used.
+		file, line = f, l
+	}
+	if file == "" {
+		return fileLine{}, false
+	}
+	return fileLine{file: file, line: line}, true
+}
+
+// cutNumber splits s at its last ":", if it is followed by a number.
+func cutNumber(s string) (string, int, bool) {
+	idx := strings.LastIndex(s, ":")
+	if idx < 0 {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(s[idx+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return s[:idx], n, true
+}
+
+// addMapping parses a mapping that looks like:
+//   1: 0x400000/0x4a2000/0x0 /usr/local/bin/service 3f2a9c... [FN][FL][LN][IN]
+// The file, build ID and flags are all optional. Lines that cannot be
+// parsed are ignored, as mappings are only used for sanity checks.
+func (p *rawParser) addMapping(line string) {
+	parts := splitBySpace(line)
+	if len(parts) < 2 || !strings.HasSuffix(parts[0], ":") {
+		return
+	}
+
+	addrs := strings.Split(parts[1], "/")
+	if len(addrs) != 3 {
+		return
+	}
+
+	m := &stack.Mapping{}
+	for i, dst := range []*uint64{&m.Start, &m.Limit, &m.Offset} {
+		v, err := strconv.ParseUint(addrs[i], 0, 64)
+		if err != nil {
+			return
+		}
+		*dst = v
+	}
+
+	var fields []string
+	for _, part := range parts[2:] {
+		// Flags such as [FN][FL] describe available symbol information.
+		if !strings.HasPrefix(part, "[") {
+			fields = append(fields, part)
+		}
+	}
+	if len(fields) > 0 {
+		m.File = fields[0]
+	}
+	if len(fields) > 1 {
+		m.BuildID = fields[1]
 	}
+
+	p.mappings = append(p.mappings, m)
 }
 
 type stackRecord struct {
 	samples []int64
 	stack   []funcID
+	labels  stack.Labels
 }
 
 // addSample parses a sample that looks like:
@@ -199,6 +553,10 @@ func (p *rawParser) addSample(line string) {
 		// of the object. Skip these lines as we do not use it currently.
 		return
 	}
+	if labelLineRE.MatchString(line) {
+		p.addLabels(line)
+		return
+	}
 
 	// Split by ":" which separates the data from the function IDs.
 	lineParts := strings.Split(line, ":")
@@ -207,8 +565,18 @@ func (p *rawParser) addSample(line string) {
 		return
 	}
 
+	if p.numSamples >= p.limits.MaxSamples {
+		p.setError(&LimitError{"MaxSamples", p.limits.MaxSamples})
+		return
+	}
+	p.numSamples++
+
 	samples := p.parseInts(lineParts[0])
 	funcIDs := p.parseFuncIDs(lineParts[1])
+	if len(funcIDs) > p.limits.MaxStackDepth {
+		p.setError(&LimitError{"MaxStackDepth", p.limits.MaxStackDepth})
+		return
+	}
 
 	if len(samples) != len(p.sampleNames) {
 		p.setError(fmt.Errorf("line has a different sample count (%v) than sample names (%v): %v",
@@ -216,28 +584,146 @@ func (p *rawParser) addSample(line string) {
 		return
 	}
 
-	p.records = append(p.records, &stackRecord{
+	p.addPending()
+	p.pending = &stackRecord{
 		samples: samples,
 		stack:   funcIDs,
-	})
+	}
 }
 
-func getFunctionName(funcNames map[funcID]string, funcID funcID) string {
-	if funcName, ok := funcNames[funcID]; ok {
+// addPending adds the samples of the pending sample line to the record with
+// the same stack and labels, or adds a new record if the stack is new.
+func (p *rawParser) addPending() {
+	r := p.pending
+	if r == nil {
+		return
+	}
+	p.pending = nil
+
+	labels := ""
+	if len(r.labels) > 0 {
+		labels = r.labels.String()
+	}
+	key := stackKey(r.stack, labels)
+	for _, e := range p.stacks[key] {
+		if e.labels == labels && sameStack(e.record.stack, r.stack) {
+			for i, v := range r.samples {
+				e.record.samples[i] += v
+			}
+			return
+		}
+	}
+	p.stacks[key] = append(p.stacks[key], stackEntry{labels, r})
+	p.records = append(p.records, r)
+}
+
+// stackEntry is a record with the same stack hash as other records, which
+// are compared by their stacks and labels.
+type stackEntry struct {
+	labels string
+	record *stackRecord
+}
+
+// stackKey hashes the location IDs of a stack and its labels.
+func stackKey(ids []funcID, labels string) uint64 {
+	h := fnv.New64a()
+	var buf [binary.MaxVarintLen64]byte
+	for _, id := range ids {
+		n := binary.PutVarint(buf[:], int64(id))
+		h.Write(buf[:n])
+	}
+	h.Write([]byte{0})
+	h.Write([]byte(labels))
+	return h.Sum64()
+}
+
+func sameStack(a, b []funcID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	labelLineRE = regexp.MustCompile(`^[^\s:]+:\[`)
+	labelRE     = regexp.MustCompile(`([^\s:]+):\[([^\]]*)\]`)
+)
+
+// addLabels parses the labels of the previous sample, which look like:
+//   handler:[/foo] region:[a b]
+func (p *rawParser) addLabels(line string) {
+	r := p.pending
+	if r == nil {
+		p.warn.Warn(stack.SkippedLine, line, "skipped labels before the first sample")
+		return
+	}
+
+	if r.labels == nil {
+		r.labels = make(stack.Labels)
+	}
+	for _, match := range labelRE.FindAllStringSubmatch(line, -1) {
+		r.labels[match[1]] = append(r.labels[match[1]], strings.Fields(match[2])...)
+	}
+}
+
+// getFunctionName returns the function name for funcID, or if the location
+// has no function name, a name chosen by p.missing, which is empty if the
+// frame is dropped. Each missing function is only reported as a warning once.
+func (p *rawParser) getFunctionName(funcID funcID) string {
+	if funcName, ok := p.funcNames[funcID]; ok {
 		return funcName
 	}
-	return fmt.Sprintf("missing-function-%v", funcID)
+
+	name := fmt.Sprintf("missing-function-%v", funcID)
+	if !p.missingWarned[funcID] {
+		p.missingWarned[funcID] = true
+		p.warn.Warn(stack.MissingFunction, name, "no function name for location %v", funcID)
+	}
+	switch p.missing {
+	case DropMissing:
+		return ""
+	case UnknownMissing:
+		return unknownFunction
+	case AddressMissing:
+		if addr, ok := p.addresses[funcID]; ok {
+			return addr
+		}
+	}
+	return name
+}
+
+// getFrame returns the frame for funcID, with the source location if the
+// profile has line information.
+func (p *rawParser) getFrame(funcID funcID) stack.Frame {
+	fl := p.fileLines[funcID]
+	return stack.Frame{Func: p.getFunctionName(funcID), File: fl.file, Line: fl.line}
+}
+
+// getFrames returns the frames for funcID in parent first order: the
+// functions that it was inlined into, from the outermost, and then the
+// frame of funcID itself.
+func (p *rawParser) getFrames(funcID funcID) []stack.Frame {
+	inlined := p.inlined[funcID]
+	frames := make([]stack.Frame, 0, len(inlined)+1)
+	for i := len(inlined) - 1; i >= 0; i-- {
+		frames = append(frames, inlined[i])
+	}
+	return append(frames, p.getFrame(funcID))
 }
 
-// funcNames returns the function names for this stack sample.
+// frames returns the frames for this stack sample.
 // It returns in parent first order.
-func (r *stackRecord) funcNames(funcNames map[funcID]string) []string {
-	var names []string
+func (r *stackRecord) frames(getFrames func(funcID) []stack.Frame) []stack.Frame {
+	frames := make([]stack.Frame, 0, len(r.stack))
 	for i := len(r.stack) - 1; i >= 0; i-- {
-		funcID := r.stack[i]
-		names = append(names, getFunctionName(funcNames, funcID))
+		frames = append(frames, getFrames(r.stack[i])...)
 	}
-	return names
+	return frames
 }
 
 func (p *rawParser) parseFuncIDs(s string) []funcID {
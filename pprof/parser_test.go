@@ -21,10 +21,14 @@
 package pprof
 
 import (
+	"bytes"
 	"io/ioutil"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -38,7 +42,7 @@ func parseTestRawData(t *testing.T, file string) ([]byte, *rawParser) {
 	}
 
 	parser := newRawParser()
-	if err := parser.parse(rawBytes); err != nil {
+	if err := parser.parse(bytes.NewReader(rawBytes)); err != nil {
 		t.Fatalf("Parse failed: %v", err)
 	}
 
@@ -58,6 +62,8 @@ func TestParse(t *testing.T) {
 	_, parser := parseTest1(t)
 
 	assert.Equal(t, []string{"samples/count", "cpu/nanoseconds"}, parser.sampleNames)
+	assert.Equal(t, 3*time.Second, parser.duration, "duration should be parsed from the header")
+	assert.Equal(t, 10*time.Millisecond, parser.period, "period should be parsed from the header")
 
 	// line 7 - 249 are stack records in the test file.
 	const expectedNumRecords = 242
@@ -108,6 +114,29 @@ func TestParseWithM(t *testing.T) {
 	assert.Equal(t, "runtime.scanobject", parser.funcNames[1], "location with with m=1 failed")
 }
 
+func TestParseMappings(t *testing.T) {
+	contents := `Samples:
+samples/count cpu/nanoseconds
+    2   10000000: 1
+Locations
+     1: 0x206f main.fib :0 s=0
+Mappings
+1: 0x400000/0x4a2000/0x0 /usr/local/bin/service 3f2a9c  [FN][FL][LN][IN]
+2: 0x0/0x0/0x0 zap.test  [FN][FL][LN][IN]
+3: 0x7f0000000000/0x7f0000001000/0x1000
+4: malformed
+`
+	profile, err := ParseRaw([]byte(contents))
+	require.NoError(t, err, "ParseRaw failed")
+
+	expected := []*stack.Mapping{
+		{Start: 0x400000, Limit: 0x4a2000, File: "/usr/local/bin/service", BuildID: "3f2a9c"},
+		{File: "zap.test"},
+		{Start: 0x7f0000000000, Limit: 0x7f0000001000, Offset: 0x1000},
+	}
+	assert.Equal(t, expected, profile.Mappings)
+}
+
 func TestParseRawValid(t *testing.T) {
 	rawBytes, _ := parseTest1(t)
 	got, err := ParseRaw(rawBytes)
@@ -120,6 +149,215 @@ func TestParseRawValid(t *testing.T) {
 	}
 }
 
+func TestParseRawStableOrder(t *testing.T) {
+	contents := `Samples:
+samples/count cpu/nanoseconds
+    1   10000000: 2 1
+    1   10000000: 3 1
+    1   10000000: 2 1
+    1   10000000: 1
+Locations
+     1: 0x206f main.main :0 s=0
+     2: 0x207a main.b :0 s=0
+     3: 0x208b main.a :0 s=0
+`
+	got, err := ParseRaw([]byte(contents))
+	require.NoError(t, err, "ParseRaw failed")
+
+	var stacks []string
+	for _, s := range got.Samples {
+		stacks = append(stacks, strings.Join(s.Funcs, ";"))
+	}
+	assert.Equal(t, []string{"main.main;main.b", "main.main;main.a", "main.main"}, stacks,
+		"samples should be in the order they were first seen")
+}
+
+func TestParseRawReader(t *testing.T) {
+	contents := `Samples:
+samples/count cpu/nanoseconds
+    1   10000000: 2 1
+    2   20000000: 3 1
+    3   30000000: 2 1
+                handler:[/foo]
+    4   40000000: 2 1
+Locations
+     1: 0x206f main.main :0 s=0
+     2: 0x207a main.b :0 s=0
+     3: 0x208b main.a :0 s=0
+`
+	// Samples are summed by stack and labels as they are read.
+	parser := newRawParser()
+	require.NoError(t, parser.parse(iotest.OneByteReader(strings.NewReader(contents))), "parse failed")
+	expected := []*stackRecord{
+		{samples: []int64{5, 50000000}, stack: []funcID{2, 1}},
+		{samples: []int64{2, 20000000}, stack: []funcID{3, 1}},
+		{samples: []int64{3, 30000000}, stack: []funcID{2, 1}, labels: stack.Labels{"handler": {"/foo"}}},
+	}
+	assert.Equal(t, expected, parser.records, "unexpected records")
+
+	got, err := ParseRawReader(strings.NewReader(contents), ParseOptions{})
+	require.NoError(t, err, "ParseRawReader failed")
+	want, err := ParseRaw([]byte(contents))
+	require.NoError(t, err, "ParseRaw failed")
+	assert.Equal(t, want, got, "ParseRawReader should match ParseRaw")
+
+	_, err = ParseRawReader(strings.NewReader(contents), ParseOptions{Limits: Limits{MaxInputSize: len(contents) - 1}})
+	assert.Equal(t, &LimitError{"MaxInputSize", len(contents) - 1}, err)
+	_, err = ParseRawReader(strings.NewReader(contents), ParseOptions{Limits: Limits{MaxInputSize: len(contents)}})
+	assert.NoError(t, err, "input of exactly MaxInputSize should not fail")
+}
+
+func TestParseFocus(t *testing.T) {
+	contents := `Samples:
+samples/count cpu/nanoseconds
+    1   10000000: 2 1
+    2   20000000: 3 1
+    3   30000000: 4 3 1
+    4   40000000: 5 1
+Locations
+     1: 0x206f main.main :0 s=0
+     2: 0x207a main.b :0 s=0
+     3: 0x208b db.Query :0 s=0
+     4: 0x209c db.retry :0 s=0
+`
+	tests := []struct {
+		msg   string
+		focus stack.FocusFilter
+		want  []string
+	}{
+		{
+			msg:  "no filter",
+			want: []string{"main.main;main.b", "main.main;db.Query", "main.main;db.Query;db.retry", "main.main;missing-function-5"},
+		},
+		{
+			msg:   "focus",
+			focus: stack.FocusFilter{Focus: regexp.MustCompile(`^db\.`)},
+			want:  []string{"main.main;db.Query", "main.main;db.Query;db.retry"},
+		},
+		{
+			msg:   "focus and ignore",
+			focus: stack.FocusFilter{Focus: regexp.MustCompile(`^db\.`), Ignore: regexp.MustCompile(`retry`)},
+			want:  []string{"main.main;db.Query"},
+		},
+		{
+			msg:   "ignore",
+			focus: stack.FocusFilter{Ignore: regexp.MustCompile(`^db\.`)},
+			want:  []string{"main.main;main.b", "main.main;missing-function-5"},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRawWithOptions([]byte(contents), ParseOptions{Focus: tt.focus})
+		require.NoError(t, err, "%v: ParseRawWithOptions failed", tt.msg)
+
+		var stacks []string
+		for _, s := range got.Samples {
+			stacks = append(stacks, strings.Join(s.Funcs, ";"))
+		}
+		assert.Equal(t, tt.want, stacks, tt.msg)
+	}
+}
+
+func TestParseGranularity(t *testing.T) {
+	contents := `Samples:
+samples/count cpu/nanoseconds
+    1   10000000: 2 1
+    2   20000000: 3 1
+    3   30000000: 4 1
+    4   40000000: 5 1
+Locations
+     1: 0x206f M=1 main.main /src/app/main.go:10 s=0
+     2: 0x207a M=1 github.com/uber/foo/db.(*DB).Query /src/foo/db/query.go:42 s=0
+     3: 0x208b M=1 github.com/uber/foo/db.(*DB).Query /src/foo/db/query.go:57 s=0
+     4: 0x209c M=1 github.com/uber/foo/db.retry /src/foo/db/retry.go:7 s=0
+     5: 0x20ad cgo_call :0 s=0
+`
+	tests := []struct {
+		granularity stack.Granularity
+		want        []string
+	}{
+		{
+			granularity: stack.FunctionGranularity,
+			want:        []string{"main.main;github.com/uber/foo/db.(*DB).Query", "main.main;github.com/uber/foo/db.retry", "main.main;cgo_call"},
+		},
+		{
+			granularity: stack.PackageGranularity,
+			want:        []string{"main;github.com/uber/foo/db", "main;cgo_call"},
+		},
+		{
+			granularity: stack.FileGranularity,
+			want:        []string{"/src/app/main.go;/src/foo/db/query.go", "/src/app/main.go;/src/foo/db/retry.go", "/src/app/main.go;cgo_call"},
+		},
+		{
+			granularity: stack.LineGranularity,
+			want: []string{
+				"main.main main.go:10;github.com/uber/foo/db.(*DB).Query query.go:42",
+				"main.main main.go:10;github.com/uber/foo/db.(*DB).Query query.go:57",
+				"main.main main.go:10;github.com/uber/foo/db.retry retry.go:7",
+				"main.main main.go:10;cgo_call",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRawWithOptions([]byte(contents), ParseOptions{Granularity: tt.granularity})
+		require.NoError(t, err, "%v: ParseRawWithOptions failed", tt.granularity)
+
+		var stacks []string
+		for _, s := range got.Samples {
+			stacks = append(stacks, strings.Join(s.Funcs, ";"))
+		}
+		assert.Equal(t, tt.want, stacks, "%v granularity", tt.granularity)
+	}
+}
+
+func TestParseFileLine(t *testing.T) {
+	tests := []struct {
+		s      string
+		want   fileLine
+		wantOK bool
+	}{
+		{"/src/app/main.go:10", fileLine{file: "/src/app/main.go", line: 10}, true},
+		{"C:/src/app/main.go:10", fileLine{file: "C:/src/app/main.go", line: 10}, true},
+		{"/src/app/main.go:10:3", fileLine{file: "/src/app/main.go", line: 10}, true},
+		{":0", fileLine{}, false},
+		{":0:0", fileLine{}, false},
+		{"s=0", fileLine{}, false},
+		{"main.go:x", fileLine{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseFileLine(tt.s)
+		assert.Equal(t, tt.wantOK, ok, "parseFileLine(%v) ok", tt.s)
+		assert.Equal(t, tt.want, got, "parseFileLine(%v)", tt.s)
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	contents := `Samples:
+samples/count cpu/nanoseconds
+    1   10000000: 2 1
+                handler:[/foo] region:[a b]
+    2   20000000: 2 1
+                handler:[/bar]
+    3   30000000: 2 1
+                handler:[/foo] region:[a b]
+    4   40000000: 2 1
+Locations
+     1: 0x206f main.main :0 s=0
+     2: 0x207a main.b :0 s=0
+`
+	got, err := ParseRaw([]byte(contents))
+	require.NoError(t, err, "ParseRaw failed")
+
+	funcs := []string{"main.main", "main.b"}
+	expected := []*stack.Sample{
+		{Funcs: funcs, Counts: []int64{4, 40000000}, Labels: stack.Labels{"handler": {"/foo"}, "region": {"a", "b"}}},
+		{Funcs: funcs, Counts: []int64{2, 20000000}, Labels: stack.Labels{"handler": {"/bar"}}},
+		{Funcs: funcs, Counts: []int64{4, 40000000}},
+	}
+	assert.Equal(t, expected, got.Samples, "samples with different labels should not be combined")
+}
+
 func TestParseLocation(t *testing.T) {
 	contents := `Samples:
 samples/count cpu/nanoseconds
@@ -189,6 +427,208 @@ func TestParseMissingSourceLine(t *testing.T) {
 	}
 }
 
+func TestParseWarnings(t *testing.T) {
+	contents := `Samples:
+samples/count cpu/nanoseconds
+    2   10000000: 1 2 3
+    1   10000000: 3 1
+Locations
+     1: 0x4021625 runtime.heapBits.next /src/runtime/mbitmap.go:464 s=0
+     2: 0x206f main.fib :0 s=0
+     3: 0x16e1
+             runtime.scanobject /src/runtime/mgcmark.go:1162 s=0
+`
+	var warnings []stack.Warning
+	_, err := ParseRawWithOptions([]byte(contents), ParseOptions{
+		OnWarning: func(w stack.Warning) { warnings = append(warnings, w) },
+	})
+	require.NoError(t, err, "ParseRawWithOptions failed")
+
+	require.Len(t, warnings, 3, "missing functions should only be reported once")
+	assert.Equal(t, stack.SkippedLine, warnings[0].Kind)
+	assert.Contains(t, warnings[0].Detail, "runtime.scanobject")
+	assert.Equal(t, stack.MissingFunction, warnings[1].Kind)
+	assert.Equal(t, "missing-function-3", warnings[1].Detail)
+	assert.Equal(t, stack.MissingFunctionSamples, warnings[2].Kind)
+	assert.Equal(t, "3 of 3 samples/count (100.0%) have frames without a function name, which were named missing-function-<location>", warnings[2].Message)
+}
+
+func TestParseInlined(t *testing.T) {
+	contents := `Samples:
+samples/count cpu/nanoseconds
+    2   10000000: 1 2
+    1   10000000: 3 2
+Locations
+     1: 0x4021625 runtime.heapBits.next /src/runtime/mbitmap.go:464 s=0
+             runtime.heapBitsForObject /src/runtime/mbitmap.go:395 s=0
+             runtime.scanobject /src/runtime/mgcmark.go:1162 s=0
+     2: 0x206f runtime.gcDrain /src/runtime/mgcmark.go:1016 s=0
+     3: 0x16e1 runtime.scanblock /src/runtime/mgcmark.go:1100 s=0
+`
+	tests := []struct {
+		noInlines bool
+		want      [][]string
+	}{
+		{
+			want: [][]string{
+				{"runtime.gcDrain mgcmark.go:1016", "runtime.scanobject mgcmark.go:1162", "runtime.heapBitsForObject mbitmap.go:395", "runtime.heapBits.next mbitmap.go:464"},
+				{"runtime.gcDrain mgcmark.go:1016", "runtime.scanblock mgcmark.go:1100"},
+			},
+		},
+		{
+			noInlines: true,
+			want: [][]string{
+				{"runtime.gcDrain mgcmark.go:1016", "runtime.scanobject mgcmark.go:1162"},
+				{"runtime.gcDrain mgcmark.go:1016", "runtime.scanblock mgcmark.go:1100"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var warnings []stack.Warning
+		profile, err := ParseRawWithOptions([]byte(contents), ParseOptions{
+			Granularity: stack.LineGranularity,
+			NoInlines:   tt.noInlines,
+			OnWarning:   func(w stack.Warning) { warnings = append(warnings, w) },
+		})
+		require.NoError(t, err, "ParseRawWithOptions(NoInlines: %v) failed", tt.noInlines)
+		assert.Empty(t, warnings, "inlined frames should not be skipped")
+
+		var got [][]string
+		for _, s := range profile.Samples {
+			got = append(got, s.Funcs)
+		}
+		assert.Equal(t, tt.want, got, "frames with NoInlines: %v", tt.noInlines)
+	}
+}
+
+func TestParseNoLineInformation(t *testing.T) {
+	contents := `Samples:
+samples/count cpu/nanoseconds
+    2   10000000: 1 2
+Locations
+     1: 0x206f main.fib :0 s=0
+     2: 0x207a main.main :0 s=0
+`
+	tests := []struct {
+		granularity stack.Granularity
+		wantWarning bool
+	}{
+		{granularity: stack.FunctionGranularity},
+		{granularity: stack.FileGranularity, wantWarning: true},
+		{granularity: stack.LineGranularity, wantWarning: true},
+	}
+
+	for _, tt := range tests {
+		var warnings []stack.Warning
+		profile, err := ParseRawWithOptions([]byte(contents), ParseOptions{
+			Granularity: tt.granularity,
+			OnWarning:   func(w stack.Warning) { warnings = append(warnings, w) },
+		})
+		require.NoError(t, err, "ParseRawWithOptions(%v) failed", tt.granularity)
+		require.Len(t, profile.Samples, 1, "unexpected samples for %v", tt.granularity)
+		assert.Equal(t, []string{"main.main", "main.fib"}, profile.Samples[0].Funcs, "frames should be named by their function")
+
+		if !tt.wantWarning {
+			assert.Empty(t, warnings, "unexpected warnings for %v", tt.granularity)
+			continue
+		}
+		require.Len(t, warnings, 1, "expected a warning for %v", tt.granularity)
+		assert.Equal(t, stack.NoLineInformation, warnings[0].Kind)
+		assert.Contains(t, warnings[0].Message, "rather than their "+tt.granularity.String())
+	}
+}
+
+func TestParseInlinedFocus(t *testing.T) {
+	contents := `Samples:
+samples/count cpu/nanoseconds
+    2   10000000: 1 2
+    1   10000000: 3 2
+Locations
+     1: 0x4021625 runtime.heapBits.next /src/runtime/mbitmap.go:464 s=0
+             runtime.scanobject /src/runtime/mgcmark.go:1162 s=0
+     2: 0x206f runtime.gcDrain /src/runtime/mgcmark.go:1016 s=0
+     3: 0x16e1 runtime.scanblock /src/runtime/mgcmark.go:1100 s=0
+`
+	focus := stack.FocusFilter{Focus: regexp.MustCompile(`scanobject`)}
+	profile, err := ParseRawWithOptions([]byte(contents), ParseOptions{Focus: focus})
+	require.NoError(t, err, "ParseRawWithOptions failed")
+
+	require.Len(t, profile.Samples, 1, "only the sample with the inlined function should be kept")
+	assert.Equal(t, []string{"runtime.gcDrain", "runtime.scanobject", "runtime.heapBits.next"}, profile.Samples[0].Funcs)
+}
+
+func TestParseMissingFunctions(t *testing.T) {
+	contents := `Samples:
+samples/count cpu/nanoseconds
+    1   10000000: 2 1
+    2   20000000: 3 4 1
+    3   30000000: 3 1
+    4   40000000: 5 1
+Locations
+     1: 0x206f main.main :0 s=0
+     2: 0x207a main.b :0 s=0
+     3: 0x208b M=1
+     4: 0x209c
+`
+	tests := []struct {
+		missing MissingFunctions
+		want    []string
+		summary string
+	}{
+		{
+			missing: KeepMissing,
+			want:    []string{"main.main;main.b", "main.main;missing-function-4;missing-function-3", "main.main;missing-function-3", "main.main;missing-function-5"},
+			summary: "9 of 10 samples/count (90.0%) have frames without a function name, which were named missing-function-<location>",
+		},
+		{
+			missing: DropMissing,
+			want:    []string{"main.main;main.b", "main.main"},
+			summary: "which were dropped",
+		},
+		{
+			missing: UnknownMissing,
+			want:    []string{"main.main;main.b", "main.main;[unknown]"},
+			summary: "which were merged into [unknown]",
+		},
+		{
+			missing: AddressMissing,
+			want:    []string{"main.main;main.b", "main.main;0x209c;0x208b", "main.main;0x208b", "main.main;missing-function-5"},
+			summary: "which were named by their address",
+		},
+	}
+
+	for _, tt := range tests {
+		var summary string
+		got, err := ParseRawWithOptions([]byte(contents), ParseOptions{
+			MissingFunctions: tt.missing,
+			OnWarning: func(w stack.Warning) {
+				if w.Kind == stack.MissingFunctionSamples {
+					summary = w.Message
+				}
+			},
+		})
+		require.NoError(t, err, "%v: ParseRawWithOptions failed", tt.missing)
+
+		var stacks []string
+		for _, s := range got.Samples {
+			stacks = append(stacks, strings.Join(s.Funcs, ";"))
+		}
+		assert.Equal(t, tt.want, stacks, "%v: unexpected stacks", tt.missing)
+		assert.Contains(t, summary, tt.summary, "%v: unexpected summary", tt.missing)
+	}
+}
+
+func TestParseMissingFunctionsName(t *testing.T) {
+	for _, name := range []string{"keep", "drop", "unknown", "address"} {
+		m, err := ParseMissingFunctions(name)
+		require.NoError(t, err, "ParseMissingFunctions(%q) failed", name)
+		assert.Equal(t, name, m.String(), "ParseMissingFunctions(%q) round trip", name)
+	}
+	_, err := ParseMissingFunctions("hide")
+	assert.Error(t, err, "ParseMissingFunctions should fail for unknown names")
+}
+
 func TestParseEmptySampleName(t *testing.T) {
 	contents := `Samples:
 	samples/count  cpu/nanoseconds
@@ -302,6 +742,66 @@ samples/count cpu/nanoseconds
 	testParseRawBad(t, "no locations", "parser ended before processing locations", contents)
 }
 
+func TestParseRawLimits(t *testing.T) {
+	rawBytes, _ := parseTest1(t)
+
+	tests := []struct {
+		limits    Limits
+		wantLimit string
+	}{
+		{Limits{MaxInputSize: 1024}, "MaxInputSize"},
+		{Limits{MaxLineLength: 64}, "MaxLineLength"},
+		{Limits{MaxRecords: 100}, "MaxRecords"},
+		{Limits{MaxSamples: 100}, "MaxSamples"},
+		{Limits{MaxFunctions: 10}, "MaxFunctions"},
+		{Limits{MaxStackDepth: 10}, "MaxStackDepth"},
+		{
+			// Limits larger than the input should not fail.
+			limits: Limits{
+				MaxInputSize:  len(rawBytes),
+				MaxLineLength: 1024,
+				MaxRecords:    1000,
+				MaxSamples:    242,
+				MaxFunctions:  41,
+				MaxStackDepth: 100,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseRawWithOptions(rawBytes, ParseOptions{Limits: tt.limits})
+		if tt.wantLimit == "" {
+			assert.NoError(t, err, "unexpected error for limits %+v", tt.limits)
+			continue
+		}
+
+		limitErr, ok := err.(*LimitError)
+		if !assert.True(t, ok, "expected LimitError for %+v, got %v", tt.limits, err) {
+			continue
+		}
+		assert.Equal(t, tt.wantLimit, limitErr.Limit, "unexpected limit exceeded")
+		assert.Contains(t, limitErr.Error(), "input exceeds "+tt.wantLimit)
+	}
+}
+
+// TestParseRawNoPanics checks that truncated and corrupted input returns
+// errors rather than panicking.
+func TestParseRawNoPanics(t *testing.T) {
+	rawBytes, _ := parseTestRawData(t, "testdata/pprof2.raw.txt")
+	if len(rawBytes) > 8192 {
+		rawBytes = rawBytes[:8192]
+	}
+
+	replacements := []byte{0, '\n', ' ', ':', '-', '/', '[', '9', 'M', '='}
+	for i := 0; i < len(rawBytes); i += 7 {
+		ParseRaw(rawBytes[:i])
+
+		corrupted := append([]byte(nil), rawBytes...)
+		corrupted[i] = replacements[i%len(replacements)]
+		ParseRaw(corrupted)
+	}
+}
+
 func TestSplitBySpace(t *testing.T) {
 	tests := []struct {
 		s        string
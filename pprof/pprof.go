@@ -22,87 +22,326 @@ package pprof
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/url"
+	"os"
 	"os/exec"
 	"strings"
 
+	"github.com/uber/go-torch/stack"
 	"github.com/uber/go-torch/torchlog"
 )
 
 // Options are parameters for pprof.
 type Options struct {
-	BaseURL     string   `short:"u" long:"url" default:"http://localhost:8080" description:"Base URL of your Go program"`
+	BaseURL     string   `short:"u" long:"url" default:"http://localhost:8080" description:"Base URL of your Go program, or unix://<socket> if it serves pprof on a Unix socket"`
 	URLSuffix   string   `long:"suffix" default:"/debug/pprof/profile" description:"URL path of pprof profile"`
 	BinaryFile  string   `short:"b" long:"binaryinput" description:"File path of previously saved binary profile. (binary profile is anything accepted by https://golang.org/cmd/pprof)"`
 	BinaryName  string   `long:"binaryname" description:"File path of the binary that the binaryinput is for, used for pprof inputs"`
 	TimeSeconds int      `short:"t" long:"seconds" default:"30" description:"Number of seconds to profile for"`
 	ExtraArgs   []string `long:"pprofArgs"  description:"Extra arguments for pprof"`
 	TimeAlias   *int     `hidden:"true" long:"time" description:"Alias for backwards compatibility"`
+
+	Heap      bool `long:"heap" description:"Profile heap memory, using /debug/pprof/heap and the inuse_space sample by default"`
+	Block     bool `long:"block" description:"Profile blocking events, using /debug/pprof/block and the delay sample by default"`
+	Mutex     bool `long:"mutex" description:"Profile mutex contention, using /debug/pprof/mutex and the delay sample by default"`
+	Goroutine bool `long:"goroutine" description:"Profile goroutine stacks, using /debug/pprof/goroutine"`
+
+	Sample string `long:"sample" description:"Sample type to render, by name or a unique prefix of its name (e.g. alloc_space/bytes or alloc), rather than by pprof flags such as -alloc_space; takes precedence over the default sample of --heap, --block and --mutex"`
+
+	GCBeforeHeap bool `long:"gc-before-heap" description:"Run a garbage collection before the --heap snapshot (/debug/pprof/heap?gc=1), so in-use values only include live objects"`
+
+	Hz int `long:"hz" description:"CPU sampling rate to request from the target (e.g. 250), sent as the hz query parameter; only honored by targets whose profile handler supports it, as net/http/pprof always samples at 100 Hz"`
+
+	Merge bool `long:"merge" description:"Merge the profiles from all sources given as arguments (files or base URLs) into one flame graph"`
+
+	BaseURL2 string `long:"base-url2" description:"Base URL (or saved profile) of a second Go program, e.g. production, to profile at the same time and generate a differential flame graph against"`
+
+	GoBinary string `long:"go-binary" description:"go binary to run pprof with (default: go in the PATH), e.g. the go matching the version of the binary being profiled"`
+
+	AllowMismatch bool `long:"allow-mismatch" description:"Warn instead of failing when the binary's architecture or build ID does not match the profile"`
+
+	Headers   []string `long:"header" description:"HTTP header to send when fetching the profile, in the form \"Name: value\"; can be repeated"`
+	BasicAuth string   `long:"basic-auth" description:"Basic auth credentials to fetch the profile with, in the form user:password"`
+	Cert      string   `long:"cert" description:"Client certificate file (PEM) to fetch the profile with over TLS; requires --key"`
+	Key       string   `long:"key" description:"Private key file (PEM) of the client certificate"`
+	CA        string   `long:"ca" description:"CA certificate file (PEM) used to verify the server's certificate"`
+	Insecure  bool     `long:"insecure" description:"Do not verify the server's TLS certificate"`
 }
 
-// GetRaw returns the raw output from pprof for the given options.
-func GetRaw(opts Options, remaining []string) ([]byte, error) {
-	args, err := getArgs(opts, remaining)
+// StdinSource is the binary profile source that is read from stdin.
+const StdinSource = "-"
+
+// stdin is the reader that StdinSource is read from.
+var stdin io.Reader = os.Stdin
+
+// GetRaw returns the raw output from pprof for the given options. Profiles
+// are fetched from opts.BaseURL using an HTTP client configured by opts, and
+// then passed to pprof. If remaining is set, it is passed to pprof as is.
+// If ctx is cancelled or times out, the fetch or the pprof process is stopped.
+// Warnings, such as a mismatched binary allowed by opts.AllowMismatch, are
+// ignored; use StreamRaw to receive them.
+func GetRaw(ctx context.Context, opts Options, remaining []string) ([]byte, error) {
+	var out []byte
+	err := StreamRaw(ctx, opts, remaining, nil, func(r io.Reader) error {
+		var err error
+		out, err = ioutil.ReadAll(r)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	return out, nil
+}
+
+// StreamRaw is like GetRaw, but calls read with pprof's output as it is
+// written, such as to parse it with ParseRawReader without holding all of
+// it in memory. An error returned by read is returned as is, unless pprof
+// itself failed. Problems that opts allows, such as a mismatched binary, are
+// reported to onWarning.
+func StreamRaw(ctx context.Context, opts Options, remaining []string, onWarning stack.WarningFunc, read func(io.Reader) error) error {
+	if opts.BinaryFile == StdinSource {
+		// pprof cannot read from stdin, so it is saved to a file first.
+		file, err := saveStdin()
+		if err != nil {
+			return err
+		}
+		defer os.Remove(file)
+		opts.BinaryFile = file
+	}
+	if len(remaining) == 0 && opts.BinaryFile == "" {
+		file, err := download(ctx, opts)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(file)
+		opts.BinaryFile = file
+	}
 
-	return runPProf(args...)
+	// Check the binary before pprof symbolizes the profile with it.
+	if err := CheckProfileFile(opts, remaining, onWarning); err != nil {
+		return err
+	}
+
+	args, err := getArgs(opts, remaining)
+	if err != nil {
+		return err
+	}
+
+	return runPProf(ctx, opts.GoBinary, read, args...)
+}
+
+// saveStdin copies the profile from stdin to a temporary file, and returns
+// the name of the file. The caller must remove the file.
+func saveStdin() (string, error) {
+	f, err := ioutil.TempFile("", "go-torch-stdin")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, stdin)
+	if err == nil && n == 0 {
+		err = errors.New("no profile was written to stdin")
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("could not read profile from stdin: %v", err)
+	}
+	return f.Name(), nil
+}
+
+// ForSource returns options that fetch the profile from a single source,
+// which is either a base URL (http, https or unix), a saved binary profile,
+// or StdinSource.
+// This is used to fetch each of the sources in merge and diff mode.
+func (opts Options) ForSource(source string) Options {
+	opts.Merge = false
+	opts.BaseURL2 = ""
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "unix") {
+		opts.BaseURL = source
+		opts.BinaryFile = ""
+	} else {
+		opts.BinaryFile = source
+	}
+	return opts
 }
 
 // getArgs gets the arguments to run pprof with for a given set of Options.
+// Profiles from a URL must have been downloaded to opts.BinaryFile.
 func getArgs(opts Options, remaining []string) ([]string, error) {
-	if opts.TimeAlias != nil {
-		opts.TimeSeconds = *opts.TimeAlias
+	if _, err := getPreset(opts); err != nil {
+		return nil, err
 	}
 	if len(remaining) > 0 {
 		var pprofArgs []string
-		if opts.TimeSeconds > 0 {
-			pprofArgs = append(pprofArgs, "-seconds", fmt.Sprint(opts.TimeSeconds))
+		if seconds := opts.seconds(); seconds > 0 {
+			pprofArgs = append(pprofArgs, "-seconds", fmt.Sprint(seconds))
 		}
 		pprofArgs = append(pprofArgs, remaining...)
 		return pprofArgs, nil
 	}
+	if opts.BinaryFile == "" {
+		return nil, errors.New("profile must be downloaded before running pprof")
+	}
 
-	pprofArgs := opts.ExtraArgs
-	if opts.BinaryFile != "" {
-		if opts.BinaryName != "" {
-			pprofArgs = append(pprofArgs, opts.BinaryName)
-		}
-		pprofArgs = append(pprofArgs, opts.BinaryFile)
-	} else {
-		u, err := url.Parse(opts.BaseURL)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse URL: %v", err)
+	// Copy ExtraArgs, as appending to it would write to the caller's backing
+	// array, which is shared by copies of opts used by concurrent fetches.
+	pprofArgs := append([]string(nil), opts.ExtraArgs...)
+	if opts.BinaryName != "" {
+		pprofArgs = append(pprofArgs, opts.BinaryName)
+	}
+	return append(pprofArgs, opts.BinaryFile), nil
+}
+
+// profileURL returns the URL to fetch the profile from. For a Unix socket,
+// this is an http URL that must be fetched over the socket.
+func profileURL(opts Options) (string, error) {
+	preset, err := getPreset(opts)
+	if err != nil {
+		return "", err
+	}
+	baseURL := opts.BaseURL
+	if _, httpURL, ok := SplitUnixURL(baseURL); ok {
+		baseURL = httpURL
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse URL: %v", err)
+	}
+
+	if preset != nil {
+		// Presets are snapshots rather than CPU profiles. Recent versions of
+		// net/http/pprof return a delta profile if seconds is specified.
+		u.Path = preset.urlSuffix
+		if opts.GCBeforeHeap && preset.name == heapPreset.name {
+			// net/http/pprof runs a GC before the heap snapshot if gc is set,
+			// so in-use values do not include garbage that is not yet freed.
+			query := u.Query()
+			query.Set("gc", "1")
+			u.RawQuery = query.Encode()
 		}
+		return u.String(), nil
+	}
+
+	u.Path = opts.URLSuffix
+	query := u.Query()
+	query.Set("seconds", fmt.Sprint(opts.seconds()))
+	if opts.Hz > 0 {
+		query.Set("hz", fmt.Sprint(opts.Hz))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// seconds returns the number of seconds to profile for, which may be set
+// using the --time alias for backwards compatibility.
+func (opts Options) seconds() int {
+	if opts.TimeAlias != nil {
+		return *opts.TimeAlias
+	}
+	return opts.TimeSeconds
+}
+
+// errNoPProf is returned when neither go nor a standalone pprof is installed.
+var errNoPProf = errors.New("cannot find go or pprof in the PATH, which are needed to read pprof profiles. " +
+	"Install Go from https://golang.org/dl/, or add a standalone pprof binary (https://github.com/google/pprof) to the PATH. " +
+	"Alternatively, go-torch can render profiles without pprof using --folded-input (collapsed stacks), " +
+	"--perf-input (perf.data or perf script output) or --heap-input (/debug/pprof/heap?debug=1 output).")
 
-		u.Path = opts.URLSuffix
-		pprofArgs = append(pprofArgs, "-seconds", fmt.Sprint(opts.TimeSeconds), u.String())
+// pprofCommand returns the command used to run pprof, which is go tool pprof
+// using goBinary if it is set, or a standalone pprof if the go toolchain is
+// not installed.
+func pprofCommand(goBinary string) ([]string, error) {
+	if goBinary != "" {
+		if _, err := exec.LookPath(goBinary); err != nil {
+			return nil, fmt.Errorf("could not find go binary: %v", err)
+		}
+		return []string{goBinary, "tool", "pprof"}, nil
+	}
+	if _, err := exec.LookPath("go"); err == nil {
+		return []string{"go", "tool", "pprof"}, nil
+	}
+	if _, err := exec.LookPath("pprof"); err == nil {
+		return []string{"pprof"}, nil
 	}
+	return nil, errNoPProf
+}
 
-	return pprofArgs, nil
+// CheckPProf returns an error describing the alternatives if pprof cannot
+// be run using goBinary (or go in the PATH if it is empty), so go-torch can
+// fail at startup rather than when pprof is run.
+func CheckPProf(goBinary string) error {
+	_, err := pprofCommand(goBinary)
+	return err
 }
 
-func runPProf(args ...string) ([]byte, error) {
-	allArgs := []string{"tool", "pprof", "-raw"}
+// runPProf runs pprof with the given arguments, and calls read with its
+// stdout. The environment, such as GOROOT and GOFLAGS, is passed through to
+// pprof.
+func runPProf(ctx context.Context, goBinary string, read func(io.Reader) error, args ...string) error {
+	command, err := pprofCommand(goBinary)
+	if err != nil {
+		return err
+	}
+	allArgs := append(command[1:], "-raw")
 	allArgs = append(allArgs, args...)
 
+	// pprof is stopped if read returns early, so it does not block writing
+	// output that is never read.
+	pprofCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	var buf bytes.Buffer
-	torchlog.Printf("Run pprof command: go %v", strings.Join(allArgs, " "))
-	cmd := exec.Command("go", allArgs...)
+	torchlog.Debugf("Run pprof command: %v %v", command[0], strings.Join(allArgs, " "))
+	cmd := exec.CommandContext(pprofCtx, command[0], allArgs...)
 	cmd.Stderr = &buf
-	out, err := cmd.Output()
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, fmt.Errorf("pprof error: %v\nSTDERR:\n%s", err, buf.Bytes())
+		return fmt.Errorf("pprof error: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("pprof error: %v", err)
+	}
+
+	out := &countingReader{r: stdout}
+	readErr := read(out)
+	if readErr != nil {
+		cancel()
+	} else {
+		// Drain any output that read did not need.
+		io.Copy(ioutil.Discard, out)
+	}
+	err = cmd.Wait()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("pprof stopped: %v", ctxErr)
+	}
+	if err != nil && readErr == nil {
+		return fmt.Errorf("pprof error: %v\nSTDERR:\n%s", err, buf.Bytes())
 	}
 
 	// @HACK because 'go tool pprof' doesn't exit on errors with nonzero status codes.
 	// Ironically, this means that Go's own os/exec package does not detect its errors.
 	// See issue here https://github.com/golang/go/issues/11510
-	if len(out) == 0 {
-		return nil, fmt.Errorf("pprof error:\n%s", buf.Bytes())
+	if out.n == 0 {
+		return fmt.Errorf("pprof error:\n%s", buf.Bytes())
 	}
 
-	return out, nil
+	return readErr
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
 }
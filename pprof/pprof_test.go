@@ -21,53 +21,31 @@
 package pprof
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestGetArgs(t *testing.T) {
-	four := 4
 	tests := []struct {
 		opts      Options
 		remaining []string
 		expected  []string
 		wantErr   bool
 	}{
-		{
-			opts: Options{
-				BaseURL:     "http://localhost:1234",
-				URLSuffix:   "/path/to/profile",
-				TimeSeconds: 5,
-			},
-			expected: []string{"-seconds", "5", "http://localhost:1234/path/to/profile"},
-		},
-		{
-			opts: Options{
-				BaseURL:     "http://localhost:1234/",
-				URLSuffix:   "/path/to/profile",
-				TimeSeconds: 5,
-			},
-			expected: []string{"-seconds", "5", "http://localhost:1234/path/to/profile"},
-		},
-		{
-			opts: Options{
-				BaseURL:   "http://localhost:1234/",
-				URLSuffix: "/path/to/profile",
-				TimeAlias: &four,
-			},
-			expected: []string{"-seconds", "4", "http://localhost:1234/path/to/profile"},
-		},
-		{
-			opts: Options{
-				BaseURL:     "http://localhost:1234/test",
-				URLSuffix:   "/path/to/profile",
-				TimeSeconds: 5,
-			},
-			expected: []string{"-seconds", "5", "http://localhost:1234/path/to/profile"},
-		},
 		{
 			opts: Options{
 				BinaryFile:  "/path/to/binaryfile",
@@ -96,10 +74,19 @@ func TestGetArgs(t *testing.T) {
 		},
 		{
 			opts: Options{
-				BaseURL:     "%-0", // this makes url.Parse fail.
-				URLSuffix:   "/profile",
+				BaseURL:     "http://localhost:1234",
+				URLSuffix:   "/path/to/profile",
 				TimeSeconds: 5,
 			},
+			// The profile must be downloaded before pprof is run.
+			wantErr: true,
+		},
+		{
+			opts: Options{
+				BaseURL: "http://localhost:1234",
+				Block:   true,
+				Mutex:   true,
+			},
 			wantErr: true,
 		},
 		{
@@ -142,14 +129,216 @@ func TestGetArgs(t *testing.T) {
 	}
 }
 
+func TestGetArgsConcurrent(t *testing.T) {
+	// ExtraArgs has spare capacity, so appending to it without copying would
+	// share its backing array between the calls.
+	extraArgs := make([]string, 1, 10)
+	extraArgs[0] = "-symbolize=none"
+
+	const calls = 20
+	var wg sync.WaitGroup
+	results := make([][]string, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			opts := Options{
+				ExtraArgs:  extraArgs,
+				BinaryName: fmt.Sprintf("binary%v", i),
+				BinaryFile: fmt.Sprintf("profile%v", i),
+			}
+			results[i], _ = getArgs(opts, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, got := range results {
+		want := []string{"-symbolize=none", fmt.Sprintf("binary%v", i), fmt.Sprintf("profile%v", i)}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("getArgs %v got %v, want %v", i, got, want)
+		}
+	}
+	if len(extraArgs) != 1 {
+		t.Errorf("getArgs modified ExtraArgs: %v", extraArgs)
+	}
+}
+
+func TestProfileURL(t *testing.T) {
+	four := 4
+	tests := []struct {
+		opts     Options
+		expected string
+		wantErr  bool
+	}{
+		{
+			opts: Options{
+				BaseURL:     "http://localhost:1234",
+				URLSuffix:   "/path/to/profile",
+				TimeSeconds: 5,
+			},
+			expected: "http://localhost:1234/path/to/profile?seconds=5",
+		},
+		{
+			opts: Options{
+				BaseURL:     "http://localhost:1234/test",
+				URLSuffix:   "/path/to/profile",
+				TimeSeconds: 5,
+			},
+			expected: "http://localhost:1234/path/to/profile?seconds=5",
+		},
+		{
+			opts: Options{
+				BaseURL:   "http://localhost:1234/",
+				URLSuffix: "/path/to/profile",
+				TimeAlias: &four,
+			},
+			expected: "http://localhost:1234/path/to/profile?seconds=4",
+		},
+		{
+			opts: Options{
+				BaseURL:     "http://localhost:1234",
+				URLSuffix:   "/path/to/profile",
+				TimeSeconds: 5,
+				Hz:          250,
+			},
+			expected: "http://localhost:1234/path/to/profile?hz=250&seconds=5",
+		},
+		{
+			opts: Options{
+				BaseURL:     "https://localhost:1234?debug=0",
+				URLSuffix:   "/path/to/profile",
+				TimeSeconds: 5,
+			},
+			expected: "https://localhost:1234/path/to/profile?debug=0&seconds=5",
+		},
+		{
+			opts: Options{
+				BaseURL:     "unix:///var/run/app.sock:/ignored",
+				URLSuffix:   "/path/to/profile",
+				TimeSeconds: 5,
+			},
+			expected: "http://localhost/path/to/profile?seconds=5",
+		},
+		{
+			opts: Options{
+				BaseURL:     "%-0", // this makes url.Parse fail.
+				URLSuffix:   "/profile",
+				TimeSeconds: 5,
+			},
+			wantErr: true,
+		},
+		{
+			opts: Options{
+				BaseURL:     "http://localhost:1234",
+				URLSuffix:   "/ignored",
+				TimeSeconds: 5,
+				Heap:        true,
+			},
+			expected: "http://localhost:1234/debug/pprof/heap",
+		},
+		{
+			opts: Options{
+				BaseURL:      "http://localhost:1234?debug=0",
+				Heap:         true,
+				GCBeforeHeap: true,
+			},
+			expected: "http://localhost:1234/debug/pprof/heap?debug=0&gc=1",
+		},
+		{
+			opts: Options{
+				BaseURL: "http://localhost:1234",
+				Mutex:   true,
+			},
+			expected: "http://localhost:1234/debug/pprof/mutex",
+		},
+		{
+			opts: Options{
+				BaseURL: "http://localhost:1234",
+				Block:   true,
+				Mutex:   true,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := profileURL(tt.opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("wantErr %v got error: %v", tt.wantErr, err)
+			continue
+		}
+		if err == nil && got != tt.expected {
+			t.Errorf("got incorrect URL for %v:\n  got %v\n want %v", tt.opts, got, tt.expected)
+		}
+	}
+}
+
+func TestForSource(t *testing.T) {
+	base := Options{
+		BaseURL:    "http://localhost:8080",
+		BinaryFile: "/path/to/binaryfile",
+		BinaryName: "/path/to/binaryname",
+		Merge:      true,
+	}
+
+	tests := []struct {
+		source     string
+		wantURL    string
+		wantBinary string
+	}{
+		{
+			source:     "cpu.prof",
+			wantURL:    "http://localhost:8080",
+			wantBinary: "cpu.prof",
+		},
+		{
+			source:  "http://host1:1234",
+			wantURL: "http://host1:1234",
+		},
+		{
+			source:  "https://host2",
+			wantURL: "https://host2",
+		},
+		{
+			source:  "unix:///var/run/app.sock",
+			wantURL: "unix:///var/run/app.sock",
+		},
+	}
+
+	for _, tt := range tests {
+		got := base.ForSource(tt.source)
+		if got.BaseURL != tt.wantURL || got.BinaryFile != tt.wantBinary {
+			t.Errorf("ForSource(%v) got url %q binary %q, want url %q binary %q",
+				tt.source, got.BaseURL, got.BinaryFile, tt.wantURL, tt.wantBinary)
+		}
+		if got.Merge {
+			t.Errorf("ForSource(%v) should not set Merge", tt.source)
+		}
+		if got.BinaryName != base.BinaryName {
+			t.Errorf("ForSource(%v) should keep BinaryName", tt.source)
+		}
+	}
+}
+
+// readPProf runs pprof and returns all of its output.
+func readPProf(ctx context.Context, goBinary string, args ...string) ([]byte, error) {
+	var out []byte
+	err := runPProf(ctx, goBinary, func(r io.Reader) error {
+		var err error
+		out, err = ioutil.ReadAll(r)
+		return err
+	}, args...)
+	return out, err
+}
+
 func TestRunPProfUnknownFlag(t *testing.T) {
-	if _, err := runPProf("-unknownFlag"); err == nil {
+	if _, err := readPProf(context.Background(), "", "-unknownFlag"); err == nil {
 		t.Fatalf("expected error for unknown flag")
 	}
 }
 
 func TestRunPProfMissingFile(t *testing.T) {
-	if _, err := runPProf("unknown-file"); err == nil {
+	if _, err := readPProf(context.Background(), "", "unknown-file"); err == nil {
 		t.Fatalf("expected error for unknown file")
 	}
 }
@@ -158,16 +347,41 @@ func TestRunPProfInvalidURL(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(http.NotFound))
 	defer server.Close()
 
-	if _, err := runPProf(server.URL); err == nil {
+	if _, err := readPProf(context.Background(), "", server.URL); err == nil {
 		t.Fatalf("expected error for unknown file")
 	}
 }
 
+func TestRunPProfCancelled(t *testing.T) {
+	// The server never responds, so pprof only exits once it is killed.
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := readPProf(ctx, "", server.URL)
+	if err == nil {
+		t.Fatalf("expected error when context times out")
+	}
+	if !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("expected deadline exceeded error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("pprof was not stopped when the context timed out, took %v", elapsed)
+	}
+}
+
 func TestGetPProfRawBadURL(t *testing.T) {
 	opts := Options{
 		BaseURL: "%-0",
 	}
-	if _, err := GetRaw(opts, nil); err == nil {
+	if _, err := GetRaw(context.Background(), opts, nil); err == nil {
 		t.Error("expected bad BaseURL to fail")
 	}
 }
@@ -176,7 +390,7 @@ func TestGetPProfRawSuccess(t *testing.T) {
 	opts := Options{
 		BinaryFile: "testdata/pprof.1.pb.gz",
 	}
-	raw, err := GetRaw(opts, nil)
+	raw, err := GetRaw(context.Background(), opts, nil)
 	if err != nil {
 		t.Fatalf("getPProfRaw failed: %v", err)
 	}
@@ -193,3 +407,129 @@ func TestGetPProfRawSuccess(t *testing.T) {
 		}
 	}
 }
+
+func TestGetPProfRawStdin(t *testing.T) {
+	contents, err := ioutil.ReadFile("testdata/pprof.1.pb.gz")
+	if err != nil {
+		t.Fatalf("failed to read test profile: %v", err)
+	}
+	defer func(old io.Reader) { stdin = old }(stdin)
+	stdin = bytes.NewReader(contents)
+
+	opts := Options{
+		BinaryFile: StdinSource,
+	}
+	raw, err := GetRaw(context.Background(), opts, nil)
+	if err != nil {
+		t.Fatalf("GetRaw from stdin failed: %v", err)
+	}
+	if !bytes.Contains(raw, []byte("main.fib")) {
+		t.Errorf("pprof raw output from stdin missing main.fib, got:\n%s", raw)
+	}
+}
+
+func TestGetPProfRawStdinEmpty(t *testing.T) {
+	defer func(old io.Reader) { stdin = old }(stdin)
+	stdin = &bytes.Buffer{}
+
+	opts := Options{
+		BinaryFile: StdinSource,
+	}
+	_, err := GetRaw(context.Background(), opts, nil)
+	if err == nil {
+		t.Fatal("expected empty stdin to fail")
+	}
+	if !strings.Contains(err.Error(), "no profile was written to stdin") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPProfCommand(t *testing.T) {
+	if command, err := pprofCommand(""); err != nil || command[0] != "go" {
+		t.Errorf("pprofCommand got %v, %v, want go tool pprof", command, err)
+	}
+
+	dir, err := ioutil.TempDir("", "go-torch-path")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir)
+
+	if err := CheckPProf(""); err != errNoPProf {
+		t.Errorf("CheckPProf without go got %v, want %v", err, errNoPProf)
+	}
+	if _, err := readPProf(context.Background(), "", "profile"); err != errNoPProf {
+		t.Errorf("runPProf without go got %v, want %v", err, errNoPProf)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "pprof"), []byte("#!/bin/sh\necho \"$@\"\n"), 0777); err != nil {
+		t.Fatalf("Failed to write pprof: %v", err)
+	}
+	if err := CheckPProf(""); err != nil {
+		t.Errorf("CheckPProf with a standalone pprof failed: %v", err)
+	}
+	out, err := readPProf(context.Background(), "", "profile")
+	if err != nil {
+		t.Fatalf("runPProf with a standalone pprof failed: %v", err)
+	}
+	if want := "-raw profile\n"; string(out) != want {
+		t.Errorf("standalone pprof got args %q, want %q", out, want)
+	}
+}
+
+func TestPProfGoBinary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-go")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	goBinary := filepath.Join(dir, "go1.9")
+	if err := CheckPProf(goBinary); err == nil || !strings.Contains(err.Error(), "could not find go binary") {
+		t.Errorf("CheckPProf with missing go binary got unexpected error: %v", err)
+	}
+
+	script := "#!/bin/sh\necho \"$GOFLAGS $@\"\n"
+	if err := ioutil.WriteFile(goBinary, []byte(script), 0777); err != nil {
+		t.Fatalf("Failed to write go binary: %v", err)
+	}
+	defer os.Setenv("GOFLAGS", os.Getenv("GOFLAGS"))
+	os.Setenv("GOFLAGS", "-mod=vendor")
+
+	out, err := readPProf(context.Background(), goBinary, "profile")
+	if err != nil {
+		t.Fatalf("runPProf with --go-binary failed: %v", err)
+	}
+	if want := "-mod=vendor tool pprof -raw profile\n"; string(out) != want {
+		t.Errorf("go binary got %q, want %q", out, want)
+	}
+}
+
+func TestRunPProfReadError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-go")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// pprof writes output forever, so it must be stopped once read fails.
+	goBinary := filepath.Join(dir, "go")
+	if err := ioutil.WriteFile(goBinary, []byte("#!/bin/sh\nwhile :; do echo sample; done\n"), 0777); err != nil {
+		t.Fatalf("Failed to write go binary: %v", err)
+	}
+
+	readErr := errors.New("read failed")
+	var line string
+	err = runPProf(context.Background(), goBinary, func(r io.Reader) error {
+		line, _ = bufio.NewReader(r).ReadString('\n')
+		return readErr
+	}, "profile")
+	if err != readErr {
+		t.Errorf("runPProf got error %v, want %v", err, readErr)
+	}
+	if line != "sample\n" {
+		t.Errorf("runPProf read %q, want the first line of output", line)
+	}
+}
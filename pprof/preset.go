@@ -0,0 +1,85 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"fmt"
+	"strings"
+)
+
+// preset describes the pprof endpoint and default sample for a profile type.
+type preset struct {
+	name      string
+	urlSuffix string
+	// sampleArgs are passed to SelectSample before any user arguments,
+	// so they only choose the default sample.
+	sampleArgs []string
+}
+
+var (
+	heapPreset      = preset{"heap", "/debug/pprof/heap", []string{"-inuse_space"}}
+	blockPreset     = preset{"block", "/debug/pprof/block", []string{"-total_delay"}}
+	mutexPreset     = preset{"mutex", "/debug/pprof/mutex", []string{"-total_delay"}}
+	goroutinePreset = preset{"goroutine", "/debug/pprof/goroutine", nil}
+)
+
+// getPreset returns the preset selected in opts, or nil if none is selected.
+func getPreset(opts Options) (*preset, error) {
+	var selected []preset
+	if opts.Heap {
+		selected = append(selected, heapPreset)
+	}
+	if opts.Block {
+		selected = append(selected, blockPreset)
+	}
+	if opts.Mutex {
+		selected = append(selected, mutexPreset)
+	}
+	if opts.Goroutine {
+		selected = append(selected, goroutinePreset)
+	}
+
+	switch len(selected) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &selected[0], nil
+	}
+
+	names := make([]string, len(selected))
+	for i, p := range selected {
+		names[i] = "--" + p.name
+	}
+	return nil, fmt.Errorf("only one profile type can be selected, got %v", strings.Join(names, ", "))
+}
+
+// SampleArgs returns the arguments to pass to SelectSample. The default
+// sample of the selected preset is included before the extra pprof and
+// remaining arguments, so any sample selected explicitly by the user takes
+// precedence.
+func (opts Options) SampleArgs(remaining []string) []string {
+	var args []string
+	if p, err := getPreset(opts); err == nil && p != nil {
+		args = append(args, p.sampleArgs...)
+	}
+	args = append(args, opts.ExtraArgs...)
+	return append(args, remaining...)
+}
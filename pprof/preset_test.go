@@ -0,0 +1,81 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPreset(t *testing.T) {
+	p, err := getPreset(Options{})
+	assert.NoError(t, err)
+	assert.Nil(t, p, "no preset should be selected by default")
+
+	p, err = getPreset(Options{Goroutine: true})
+	assert.NoError(t, err)
+	assert.Equal(t, &goroutinePreset, p)
+
+	_, err = getPreset(Options{Heap: true, Block: true, Goroutine: true})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "--heap, --block, --goroutine")
+	}
+}
+
+func TestSampleArgs(t *testing.T) {
+	tests := []struct {
+		opts      Options
+		remaining []string
+		want      []string
+	}{
+		{
+			remaining: []string{"-alloc_space"},
+			want:      []string{"-alloc_space"},
+		},
+		{
+			opts: Options{Heap: true},
+			want: []string{"-inuse_space"},
+		},
+		{
+			opts:      Options{Heap: true},
+			remaining: []string{"-alloc_space"},
+			want:      []string{"-inuse_space", "-alloc_space"},
+		},
+		{
+			opts: Options{Heap: true, ExtraArgs: []string{"-alloc_objects"}},
+			want: []string{"-inuse_space", "-alloc_objects"},
+		},
+		{
+			opts: Options{Block: true},
+			want: []string{"-total_delay"},
+		},
+		{
+			opts:      Options{Goroutine: true},
+			remaining: []string{"binary"},
+			want:      []string{"binary"},
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.opts.SampleArgs(tt.remaining), "SampleArgs(%v) for %+v", tt.remaining, tt.opts)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// Field numbers of the messages in pprof's profile.proto that are read.
+const (
+	profileMapping     = 3
+	profileFunction    = 5
+	profileStringTable = 6
+
+	mappingStart   = 2
+	mappingLimit   = 3
+	mappingOffset  = 4
+	mappingFile    = 5
+	mappingBuildID = 6
+
+	functionFilename = 4
+)
+
+// goarchFileRE matches Go source files that are only built for one GOARCH,
+// such as runtime/asm_amd64.s or syscall/zsyscall_linux_arm64.go.
+var goarchFileRE = regexp.MustCompile(`_(386|amd64|arm|arm64|loong64|mips|mipsle|mips64|mips64le|ppc64|ppc64le|riscv64|s390x)\.(s|go)$`)
+
+// profileInfo is the metadata read from a pprof protobuf profile that can be
+// compared against a binary before running pprof.
+type profileInfo struct {
+	mappings []*stack.Mapping
+	// goarchs are the architectures of the arch-specific Go source files
+	// of the profile's functions, which are usually a single GOARCH.
+	goarchs []string
+}
+
+// readProfileInfo reads the mappings and the architectures of the function
+// source files of a pprof protobuf profile, which may be gzipped.
+func readProfileInfo(path string) (profileInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return profileInfo{}, err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return profileInfo{}, err
+		}
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return profileInfo{}, err
+		}
+	}
+
+	type rawMapping struct {
+		mapping       stack.Mapping
+		file, buildID uint64
+	}
+	var (
+		strs      []string
+		mappings  []rawMapping
+		filenames []uint64
+	)
+	err = forEachField(data, func(num int, wire int, v uint64, b []byte) error {
+		switch {
+		case num == profileStringTable && wire == 2:
+			strs = append(strs, string(b))
+		case num == profileMapping && wire == 2:
+			var m rawMapping
+			err := forEachField(b, func(num int, wire int, v uint64, _ []byte) error {
+				switch num {
+				case mappingStart:
+					m.mapping.Start = v
+				case mappingLimit:
+					m.mapping.Limit = v
+				case mappingOffset:
+					m.mapping.Offset = v
+				case mappingFile:
+					m.file = v
+				case mappingBuildID:
+					m.buildID = v
+				}
+				return nil
+			})
+			mappings = append(mappings, m)
+			return err
+		case num == profileFunction && wire == 2:
+			return forEachField(b, func(num int, wire int, v uint64, _ []byte) error {
+				if num == functionFilename && wire == 0 {
+					filenames = append(filenames, v)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return profileInfo{}, fmt.Errorf("invalid profile: %v", err)
+	}
+
+	str := func(i uint64) string {
+		if i < uint64(len(strs)) {
+			return strs[i]
+		}
+		return ""
+	}
+	var info profileInfo
+	for i := range mappings {
+		m := mappings[i].mapping
+		m.File = str(mappings[i].file)
+		m.BuildID = str(mappings[i].buildID)
+		info.mappings = append(info.mappings, &m)
+	}
+	seen := make(map[string]bool)
+	for _, f := range filenames {
+		if match := goarchFileRE.FindStringSubmatch(str(f)); match != nil && !seen[match[1]] {
+			seen[match[1]] = true
+			info.goarchs = append(info.goarchs, match[1])
+		}
+	}
+	sort.Strings(info.goarchs)
+	return info, nil
+}
+
+var errTruncatedField = errors.New("truncated field")
+
+// forEachField calls f with each field of the protobuf message in data: its
+// number, wire type, and value for varint and fixed fields, or bytes for
+// length-delimited fields.
+func forEachField(data []byte, f func(num int, wire int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errTruncatedField
+		}
+		data = data[n:]
+		num, wire := int(key>>3), int(key&7)
+
+		var (
+			v uint64
+			b []byte
+		)
+		switch wire {
+		case 0:
+			if v, n = binary.Uvarint(data); n <= 0 {
+				return errTruncatedField
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return errTruncatedField
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errTruncatedField
+			}
+			b, data = data[n:n+int(size)], data[n+int(size):]
+		case 5:
+			if len(data) < 4 {
+				return errTruncatedField
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %v", wire)
+		}
+		if err := f(num, wire, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+// protoField appends a protobuf field to b, which is a varint if v is a
+// uint64, or length-delimited if it is a []byte or string.
+func protoField(b []byte, num int, v interface{}) []byte {
+	switch v := v.(type) {
+	case uint64:
+		b = appendUvarint(b, uint64(num)<<3)
+		return appendUvarint(b, v)
+	case string:
+		return protoField(b, num, []byte(v))
+	case []byte:
+		b = appendUvarint(b, uint64(num)<<3|2)
+		b = appendUvarint(b, uint64(len(v)))
+		return append(b, v...)
+	}
+	panic("unsupported field type")
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// writeTestProfile writes a gzipped protobuf profile with a main mapping
+// with buildID, and a function in each of files.
+func writeTestProfile(t *testing.T, buildID string, files ...string) string {
+	strs := append([]string{"", "/app/main", buildID}, files...)
+
+	var mapping []byte
+	mapping = protoField(mapping, 1, uint64(1))
+	mapping = protoField(mapping, mappingStart, uint64(0x400000))
+	mapping = protoField(mapping, mappingLimit, uint64(0x4a2000))
+	mapping = protoField(mapping, mappingFile, uint64(1))
+	mapping = protoField(mapping, mappingBuildID, uint64(2))
+
+	var profile []byte
+	profile = protoField(profile, profileMapping, mapping)
+	for i := range files {
+		var function []byte
+		function = protoField(function, 1, uint64(i+1))
+		function = protoField(function, functionFilename, uint64(3+i))
+		profile = protoField(profile, profileFunction, function)
+	}
+	for _, s := range strs {
+		profile = protoField(profile, profileStringTable, s)
+	}
+
+	f, err := ioutil.TempFile("", "go-torch-profile-test")
+	require.NoError(t, err, "failed to create profile")
+	defer f.Close()
+	w := gzip.NewWriter(f)
+	_, err = w.Write(profile)
+	require.NoError(t, err, "failed to write profile")
+	require.NoError(t, w.Close(), "failed to write profile")
+	return f.Name()
+}
+
+func TestReadProfileInfo(t *testing.T) {
+	file := writeTestProfile(t, "abcd",
+		"/usr/local/go/src/runtime/asm_arm64.s",
+		"/usr/local/go/src/syscall/zsyscall_linux_arm64.go",
+		"/usr/local/go/src/runtime/proc.go",
+		"/app/main.go",
+	)
+	defer os.Remove(file)
+
+	info, err := readProfileInfo(file)
+	require.NoError(t, err, "readProfileInfo failed")
+	assert.Equal(t, []string{"arm64"}, info.goarchs, "architectures of the source files")
+	assert.Equal(t, []*stack.Mapping{{Start: 0x400000, Limit: 0x4a2000, File: "/app/main", BuildID: "abcd"}}, info.mappings)
+
+	_, err = readProfileInfo("testdata/pprof.raw.txt")
+	assert.Error(t, err, "text files are not protobuf profiles")
+}
+
+func TestForEachFieldTruncated(t *testing.T) {
+	valid := protoField(nil, 6, "string")
+	for i := 1; i < len(valid); i++ {
+		err := forEachField(valid[:i], func(int, int, uint64, []byte) error { return nil })
+		assert.Error(t, err, "expected an error for %v of %v bytes", i, len(valid))
+	}
+}
+
+func TestCheckArch(t *testing.T) {
+	info := binaryInfo{arch: "EM_X86_64", goarch: "amd64"}
+	assert.NoError(t, checkArch(info, nil), "profiles without arch-specific files are not checked")
+	assert.NoError(t, checkArch(info, []string{"amd64"}), "matching architecture")
+	assert.NoError(t, checkArch(binaryInfo{}, []string{"arm64"}), "binaries with an unknown GOARCH are not checked")
+
+	err := checkArch(info, []string{"arm64"})
+	require.Error(t, err, "expected architecture mismatch")
+	assert.Equal(t, "binary is amd64 (EM_X86_64), but the profile is from arm64", err.Error())
+}
+
+func TestCheckProfileFile(t *testing.T) {
+	binary := os.Args[0]
+	info, err := readBinaryInfo(binary)
+	require.NoError(t, err, "failed to read test binary")
+	require.Equal(t, runtime.GOARCH, info.goarch, "GOARCH of the test binary")
+
+	otherArch := "arm64"
+	if runtime.GOARCH == otherArch {
+		otherArch = "amd64"
+	}
+	matching := writeTestProfile(t, info.buildID, "/go/src/runtime/asm_"+runtime.GOARCH+".s")
+	defer os.Remove(matching)
+	otherArchProfile := writeTestProfile(t, info.buildID, "/go/src/runtime/asm_"+otherArch+".s")
+	defer os.Remove(otherArchProfile)
+
+	assert.NoError(t, CheckProfileFile(Options{BinaryFile: matching, BinaryName: binary}, nil, nil), "matching profile")
+	assert.NoError(t, CheckProfileFile(Options{BinaryFile: otherArchProfile}, nil, nil), "profiles without a binary are not checked")
+
+	err = CheckProfileFile(Options{BinaryFile: otherArchProfile, BinaryName: binary}, nil, nil)
+	require.Error(t, err, "expected architecture mismatch")
+	assert.Contains(t, err.Error(), "but the profile is from "+otherArch)
+
+	err = CheckProfileFile(Options{}, []string{binary, otherArchProfile}, nil)
+	assert.Error(t, err, "expected architecture mismatch for the binary and profile arguments")
+	assert.NoError(t, CheckProfileFile(Options{}, []string{binary, "http://localhost:8080/debug/pprof/profile"}, nil),
+		"profiles fetched by pprof are not checked")
+
+	if info.buildID != "" {
+		otherBuild := writeTestProfile(t, info.buildID+"ff")
+		defer os.Remove(otherBuild)
+		err = CheckProfileFile(Options{BinaryFile: otherBuild, BinaryName: binary}, nil, nil)
+		require.Error(t, err, "expected build ID mismatch")
+		assert.Contains(t, err.Error(), "build ID")
+
+		// Mismatched mappings are reported by CheckBinary after parsing.
+		var warnings []stack.Warning
+		onWarning := func(w stack.Warning) { warnings = append(warnings, w) }
+		assert.NoError(t, CheckProfileFile(Options{BinaryFile: otherBuild, BinaryName: binary, AllowMismatch: true}, nil, onWarning))
+		assert.Empty(t, warnings, "mappings should not be checked when mismatches are allowed")
+	}
+}
+
+func TestStreamRawChecksBinaryFirst(t *testing.T) {
+	otherArch := "arm64"
+	if runtime.GOARCH == otherArch {
+		otherArch = "amd64"
+	}
+	profile := writeTestProfile(t, "", "/go/src/runtime/asm_"+otherArch+".s")
+	defer os.Remove(profile)
+
+	// pprof is never run, so the mismatch is the error rather than a
+	// failure to run pprof.
+	opts := Options{BinaryFile: profile, BinaryName: os.Args[0], GoBinary: "/does/not/exist"}
+	err := StreamRaw(context.Background(), opts, nil, nil, func(io.Reader) error { return nil })
+	require.Error(t, err, "expected StreamRaw to fail")
+	assert.Contains(t, err.Error(), "does not match profile")
+
+	opts.AllowMismatch = true
+	var warnings []stack.Warning
+	onWarning := func(w stack.Warning) { warnings = append(warnings, w) }
+	err = StreamRaw(context.Background(), opts, nil, onWarning, func(io.Reader) error { return nil })
+	require.Error(t, err, "pprof does not exist")
+	assert.NotContains(t, err.Error(), "does not match profile", "mismatches are allowed")
+	if assert.Len(t, warnings, 1, "the mismatch should be reported to onWarning") {
+		assert.Equal(t, stack.BinaryMismatch, warnings[0].Kind)
+		assert.Equal(t, os.Args[0], warnings[0].Detail)
+		assert.Contains(t, warnings[0].Message, "but the profile is from "+otherArch)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import "strings"
+
+// rawFormat records the variations of the raw pprof output seen by the
+// parser. The output is the String method of pprof's profile, which has
+// changed across the versions of pprof shipped with Go, so lines are parsed
+// by what each field looks like rather than by its position.
+type rawFormat struct {
+	// mappingIDs is set if locations have the ID of their mapping, e.g. M=1.
+	mappingIDs bool
+	// defaultType is set if the default sample type is marked, e.g.
+	// samples/count cpu/nanoseconds[dflt].
+	defaultType bool
+	// folded is set if locations can be marked as folded, e.g. [F].
+	folded bool
+	// columns is set if source locations have a column, e.g. main.go:12:5.
+	columns bool
+	// systemNames is set if functions have a system name that differs from
+	// their name after their start line, e.g. s=0(_ZN3foo3barEv).
+	systemNames bool
+	// inlined is set if locations have additional lines for inlined frames.
+	inlined bool
+}
+
+// rawFormatFeatures names each variation, in the order they are described.
+var rawFormatFeatures = []struct {
+	name string
+	seen func(f rawFormat) bool
+}{
+	{"mapping IDs", func(f rawFormat) bool { return f.mappingIDs }},
+	{"default sample types", func(f rawFormat) bool { return f.defaultType }},
+	{"folded locations", func(f rawFormat) bool { return f.folded }},
+	{"columns", func(f rawFormat) bool { return f.columns }},
+	{"system names", func(f rawFormat) bool { return f.systemNames }},
+	{"inlined frames", func(f rawFormat) bool { return f.inlined }},
+}
+
+// String describes the variations that were seen, e.g. "mapping IDs, columns".
+func (f rawFormat) String() string {
+	var seen []string
+	for _, feature := range rawFormatFeatures {
+		if feature.seen(f) {
+			seen = append(seen, feature.name)
+		}
+	}
+	if len(seen) == 0 {
+		return "basic"
+	}
+	return strings.Join(seen, ", ")
+}
+
+// defaultTypeMarker marks the default sample type in the samples header.
+const defaultTypeMarker = "[dflt]"
+
+// parseSampleNames parses the names of the sample types, which look like:
+//
+//	samples/count cpu/nanoseconds[dflt]
+func (f *rawFormat) parseSampleNames(line string) []string {
+	names := strings.Split(line, " ")
+	for i, name := range names {
+		if strings.HasSuffix(name, defaultTypeMarker) {
+			f.defaultType = true
+			names[i] = strings.TrimSuffix(name, defaultTypeMarker)
+		}
+	}
+	return names
+}
+
+// rawLocation is a parsed location line.
+type rawLocation struct {
+	// id and address are empty for the additional lines of inlined frames.
+	id      string
+	address string
+
+	// funcName is empty if the location has no function name.
+	funcName    string
+	fileLine    fileLine
+	hasFileLine bool
+
+	// inlined is set for the additional lines of a location, which are
+	// the functions that the previous line was inlined into.
+	inlined bool
+}
+
+// parseLocation parses a location line, which looks like:
+//
+//	292: 0x49dee1 M=1 [F] main.(*Frame).ReadIn /src/frame.go:42:5 s=40
+//
+// The mapping ID, folded marker, source location and start line are
+// optional, and the function name is ?? or missing if it is not known.
+// If the first function was inlined, the function it was inlined into is
+// on an additional line without the ID and address, and so on:
+//
+//	main.(*Conn).Read /src/conn.go:12 s=10
+//
+// It returns false if the line is malformed.
+func (f *rawFormat) parseLocation(line string) (rawLocation, bool) {
+	parts := splitBySpace(line)
+	var loc rawLocation
+	if strings.HasSuffix(parts[0], ":") {
+		if len(parts) < 2 {
+			return loc, false
+		}
+		loc.id, loc.address = strings.TrimSuffix(parts[0], ":"), parts[1]
+		parts = parts[2:]
+		if len(parts) > 0 && strings.HasPrefix(parts[0], "M=") {
+			f.mappingIDs = true
+			parts = parts[1:]
+		}
+		if len(parts) > 0 && parts[0] == "[F]" {
+			f.folded = true
+			parts = parts[1:]
+		}
+	} else {
+		loc.inlined = true
+	}
+
+	// The function name is followed by its source location and start line,
+	// which are both optional in older versions.
+	name := len(parts)
+	for i, part := range parts {
+		if hasNumber(part) || strings.HasPrefix(part, "s=") {
+			name = i
+			break
+		}
+	}
+	loc.funcName = strings.Join(parts[:name], " ")
+	if loc.funcName == "??" {
+		loc.funcName = ""
+	}
+	if loc.inlined && (loc.funcName == "" || name == len(parts)) {
+		// Additional lines always have a source location or start line,
+		// so this is not a location line.
+		return loc, false
+	}
+
+	for _, part := range parts[name:] {
+		if strings.HasPrefix(part, "s=") {
+			if strings.Contains(part, "(") {
+				f.systemNames = true
+			}
+			continue
+		}
+		if fl, ok := parseFileLine(part); ok {
+			loc.fileLine, loc.hasFileLine = fl, true
+			if file, _, _ := cutNumber(part); hasNumber(file) {
+				f.columns = true
+			}
+		}
+	}
+	if loc.inlined {
+		f.inlined = true
+	}
+	return loc, true
+}
+
+// hasNumber returns whether s ends with ":" followed by a number.
+func hasNumber(s string) bool {
+	_, _, ok := cutNumber(s)
+	return ok
+}
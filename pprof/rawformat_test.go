@@ -0,0 +1,134 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files of raw pprof output")
+
+// TestParseRawGoVersions parses raw output in the format printed by the
+// pprof of each Go release, and compares the detected format and the
+// parsed stacks to the golden file of each release.
+func TestParseRawGoVersions(t *testing.T) {
+	files, err := filepath.Glob("testdata/raw/go*.txt")
+	require.NoError(t, err, "Glob failed")
+	require.NotEmpty(t, files, "missing raw output for Go versions")
+
+	for _, file := range files {
+		input, err := ioutil.ReadFile(file)
+		require.NoError(t, err, "failed to read %v", file)
+
+		parser := newRawParser()
+		require.NoError(t, parser.parse(bytes.NewReader(input)), "failed to parse %v", file)
+		profile, err := parser.toProfile()
+		require.NoError(t, err, "failed to convert %v", file)
+
+		got := &bytes.Buffer{}
+		fmt.Fprintf(got, "format: %v\n", parser.format)
+		fmt.Fprintf(got, "samples: %v\n", strings.Join(profile.SampleNames, " "))
+		for _, s := range profile.Samples {
+			fmt.Fprintf(got, "%v %v", strings.Join(s.Funcs, ";"), s.Counts)
+			if len(s.Labels) > 0 {
+				fmt.Fprintf(got, " %v", s.Labels)
+			}
+			got.WriteString("\n")
+		}
+
+		golden := strings.TrimSuffix(file, ".txt") + ".golden"
+		if *update {
+			require.NoError(t, ioutil.WriteFile(golden, got.Bytes(), 0666), "failed to update %v", golden)
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		require.NoError(t, err, "failed to read %v, run go test -update to create it", golden)
+		assert.Equal(t, string(want), got.String(), "unexpected output for %v", file)
+	}
+}
+
+func TestRawFormatParseLocation(t *testing.T) {
+	tests := []struct {
+		line string
+		want rawLocation
+	}{
+		{
+			line: "1: 0x206f main.fib :0 s=0",
+			want: rawLocation{id: "1", address: "0x206f", funcName: "main.fib"},
+		},
+		{
+			line: "2: 0x16e1 M=1",
+			want: rawLocation{id: "2", address: "0x16e1"},
+		},
+		{
+			line: "3: 0x16e1 M=1 ??",
+			want: rawLocation{id: "3", address: "0x16e1"},
+		},
+		{
+			line: "4: 0x16e1 main.fib",
+			want: rawLocation{id: "4", address: "0x16e1", funcName: "main.fib"},
+		},
+		{
+			line: "5: 0x1052060 M=1 [F] main.fib /src/main.go:11:9 s=9",
+			want: rawLocation{id: "5", address: "0x1052060", funcName: "main.fib", fileLine: fileLine{"/src/main.go", 11}, hasFileLine: true},
+		},
+		{
+			line: "6: 0x1060000 M=1 foo::bar(int, char) /src/foo.cc:7 s=0(_ZN3foo3barEic)",
+			want: rawLocation{id: "6", address: "0x1060000", funcName: "foo::bar(int, char)", fileLine: fileLine{"/src/foo.cc", 7}, hasFileLine: true},
+		},
+		{
+			line: "main.fib /src/main.go:11 s=9",
+			want: rawLocation{funcName: "main.fib", fileLine: fileLine{"/src/main.go", 11}, hasFileLine: true, inlined: true},
+		},
+	}
+
+	for _, tt := range tests {
+		var f rawFormat
+		got, ok := f.parseLocation(tt.line)
+		if assert.True(t, ok, "parseLocation(%q) failed", tt.line) {
+			assert.Equal(t, tt.want, got, "parseLocation(%q)", tt.line)
+		}
+	}
+
+	for _, line := range []string{"3", "main.fib", "3:"} {
+		var f rawFormat
+		_, ok := f.parseLocation(line)
+		assert.False(t, ok, "parseLocation(%q) should fail", line)
+	}
+}
+
+func TestRawFormatString(t *testing.T) {
+	assert.Equal(t, "basic", rawFormat{}.String())
+	assert.Equal(t, "mapping IDs, columns", rawFormat{columns: true, mappingIDs: true}.String())
+
+	var f rawFormat
+	assert.Equal(t, []string{"samples/count", "cpu/nanoseconds"}, f.parseSampleNames("samples/count cpu/nanoseconds[dflt]"))
+	assert.True(t, f.defaultType, "the default sample type should be detected")
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2017 Uber Technologies, Inc.
+// Copyright (c) 2015 Uber Technologies, Inc.
 //
 // Permission is hereby granted, free of charge, to any person obtaining a copy
 // of this software and associated documentation files (the "Software"), to deal
@@ -20,53 +20,136 @@
 
 package pprof
 
-import "strconv"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// sampleFlags are the pprof flags that select a sample, and the name of the
+// sample type that they select.
+var sampleFlags = map[string]string{
+	"-inuse_space":   "inuse_space",
+	"-inuse_objects": "inuse_objects",
+	"-alloc_space":   "alloc_space",
+	"-alloc_objects": "alloc_objects",
+	"-contentions":   "contentions",
+	"-total_delay":   "delay",
+}
 
 // SelectSample returns the index of the sample to use given the
 // sample names.
 func SelectSample(args, names []string) int {
+	return SelectSampleType(args, stack.ParseSampleTypes(names))
+}
+
+// SelectSampleType returns the index of the sample to use given the sample
+// types. Samples are matched by the name of their type, so the selected
+// sample does not depend on the order or units of the sample types, which
+// differ between Go and pprof versions.
+func SelectSampleType(args []string, types []stack.SampleType) int {
 	selected := 0
 
 	findName := func(needle string) {
-		for i, name := range names {
-			if name == needle {
+		for i, t := range types {
+			if t.Name == needle {
 				selected = i
 			}
 		}
 	}
 
 	for i, arg := range args {
-		switch arg {
-		case "-inuse_space":
-			findName("inuse_space/bytes")
-		case "-inuse_objects":
-			findName("inuse_objects/count")
-		case "-alloc_space":
-			findName("alloc_space/bytes")
-		case "-alloc_objects":
-			findName("alloc_objects/count")
-		case "-sample_index":
-			// Check if there's another argument after this
-			if i+1 >= len(args) {
-				continue
-			}
-
-			if parsed, ok := parseSampleIndex(args[i+1], names); ok {
-				selected = parsed
-			}
+		if name, ok := sampleFlags[arg]; ok {
+			findName(name)
+			continue
+		}
+		value, hasValue := "", false
+		switch {
+		case arg == "-sample_index" && i+1 < len(args):
+			value, hasValue = args[i+1], true
+		case strings.HasPrefix(arg, "-sample_index="):
+			value, hasValue = strings.TrimPrefix(arg, "-sample_index="), true
+		}
+		if !hasValue {
+			continue
+		}
+		if parsed, ok := parseSampleIndex(value, types); ok {
+			selected = parsed
 		}
 	}
 
 	return selected
 }
 
-func parseSampleIndex(s string, names []string) (int, bool) {
+// SelectSampleName returns the index of the sample type selected by name,
+// which is a sample name (e.g. alloc_space/bytes), the name of a sample type
+// without its unit (alloc_space), or a prefix of exactly one sample name
+// (alloc_s). Sample types that are not known to go-torch, such as those added
+// by new versions of Go, can be selected this way.
+func SelectSampleName(name string, types []stack.SampleType) (int, error) {
+	want := stack.ParseSampleType(name)
+	for i, t := range types {
+		if t == want || (!strings.Contains(name, "/") && t.Name == want.Name) {
+			return i, nil
+		}
+	}
+
+	prefix := strings.ToLower(strings.TrimSpace(name))
+	var matches []int
+	for i, t := range types {
+		if prefix != "" && strings.HasPrefix(t.String(), prefix) {
+			matches = append(matches, i)
+		}
+	}
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		return 0, fmt.Errorf("unknown sample %q, available samples are %v", name, sampleTypeList(types))
+	}
+	matched := make([]stack.SampleType, len(matches))
+	for i, idx := range matches {
+		matched[i] = types[idx]
+	}
+	return 0, fmt.Errorf("sample %q matches more than one sample: %v", name, sampleTypeList(matched))
+}
+
+// sampleTypeList returns the names of types as a comma separated list.
+func sampleTypeList(types []stack.SampleType) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// SampleIndex returns the index of the sample to render: the sample selected
+// by opts.Sample if it is set, or else the sample selected by the preset and
+// the pprof arguments. See SelectSampleName and SelectSampleType.
+func (opts Options) SampleIndex(remaining []string, types []stack.SampleType) (int, error) {
+	if opts.Sample != "" {
+		return SelectSampleName(opts.Sample, types)
+	}
+	return SelectSampleType(opts.SampleArgs(remaining), types), nil
+}
+
+// parseSampleIndex parses the value of -sample_index, which is the index or
+// the name of a sample type, as with pprof.
+func parseSampleIndex(s string, types []stack.SampleType) (int, bool) {
 	parsed, err := strconv.Atoi(s)
 	if err != nil {
+		name := stack.ParseSampleType(s).Name
+		for i, t := range types {
+			if t.Name == name {
+				return i, true
+			}
+		}
 		return 0, false
 	}
 
-	if parsed >= len(names) || parsed < 0 {
+	if parsed >= len(types) || parsed < 0 {
 		return 0, false
 	}
 
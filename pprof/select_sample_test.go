@@ -24,6 +24,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/uber/go-torch/stack"
 )
 
 func TestSelectSample(t *testing.T) {
@@ -34,6 +35,8 @@ func TestSelectSample(t *testing.T) {
 		"alloc_space/bytes",
 		"inuse_objects/count",
 		"inuse_space/bytes",
+		"contentions/count",
+		"delay/nanoseconds",
 	}
 
 	tests := []struct {
@@ -88,6 +91,27 @@ func TestSelectSample(t *testing.T) {
 			args: []string{"-inuse_space"},
 			want: 5,
 		},
+		{
+			args: []string{"-contentions"},
+			want: 6,
+		},
+		{
+			args: []string{"-total_delay"},
+			want: 7,
+		},
+		{
+			args: []string{"-sample_index", "alloc_space"},
+			want: 3,
+		},
+		{
+			args: []string{"-sample_index=delay"},
+			want: 7,
+		},
+		{
+			// later arguments take precedence.
+			args: []string{"-inuse_space", "-alloc_space"},
+			want: 3,
+		},
 	}
 
 	for _, tt := range tests {
@@ -96,3 +120,59 @@ func TestSelectSample(t *testing.T) {
 	}
 
 }
+
+func TestSelectSampleAliases(t *testing.T) {
+	// Older versions name the sample types differently, and in another order.
+	names := []string{"total_delay/ns", "contention/count"}
+	assert.Equal(t, 1, SelectSample([]string{"-contentions"}, names), "-contentions")
+	assert.Equal(t, 0, SelectSample([]string{"-total_delay"}, names), "-total_delay")
+}
+
+func TestSelectSampleName(t *testing.T) {
+	types := stack.ParseSampleTypes([]string{
+		"alloc_objects/count",
+		"alloc_space/bytes",
+		"inuse_objects/count",
+		"inuse_space/bytes",
+		"gc_cycles/count",
+	})
+	tests := []struct {
+		name    string
+		want    int
+		wantErr string
+	}{
+		{name: "alloc_space/bytes", want: 1},
+		{name: "alloc_space", want: 1},
+		{name: "Inuse_Space/B", want: 3},
+		{name: "inuse_o", want: 2},
+		{name: "gc", want: 4},
+		{name: "alloc_space/count", wantErr: `unknown sample "alloc_space/count", available samples are alloc_objects/count, alloc_space/bytes, inuse_objects/count, inuse_space/bytes, gc_cycles/count`},
+		{name: "cpu", wantErr: `unknown sample "cpu"`},
+		{name: "alloc", want: 1},
+		{name: "alloc_", wantErr: `sample "alloc_" matches more than one sample: alloc_objects/count, alloc_space/bytes`},
+	}
+
+	for _, tt := range tests {
+		got, err := SelectSampleName(tt.name, types)
+		if tt.wantErr != "" {
+			if assert.Error(t, err, "SelectSampleName(%q)", tt.name) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+			continue
+		}
+		assert.NoError(t, err, "SelectSampleName(%q)", tt.name)
+		assert.Equal(t, tt.want, got, "SelectSampleName(%q)", tt.name)
+	}
+}
+
+func TestOptionsSampleIndex(t *testing.T) {
+	types := stack.ParseSampleTypes([]string{"alloc_objects/count", "alloc_space/bytes", "inuse_objects/count", "inuse_space/bytes"})
+
+	got, err := Options{Heap: true}.SampleIndex(nil, types)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, got, "--heap should select inuse_space by default")
+
+	got, err = Options{Heap: true, Sample: "alloc_space"}.SampleIndex([]string{"-inuse_objects"}, types)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, got, "--sample should take precedence over the preset and pprof arguments")
+}
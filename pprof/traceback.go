@@ -0,0 +1,191 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// tracebackSampleNames are the sample types of profiles read from
+// tracebacks, which count goroutines.
+var tracebackSampleNames = []string{"goroutines/count"}
+
+// tracebackHeaderRE matches the line that starts the stack of a goroutine in
+// a traceback, e.g.
+//
+//	goroutine 1 [running]:
+//	goroutine 18 [chan receive, 2 minutes]:
+var tracebackHeaderRE = regexp.MustCompile(`^goroutine \d+ .*\[(.*)\]:$`)
+
+// TracebackStateLabel is the label that holds the state of each goroutine
+// in profiles read from tracebacks, such as running or chan receive.
+const TracebackStateLabel = "state"
+
+// tracebackGoroutine is the stack of a goroutine in a traceback.
+type tracebackGoroutine struct {
+	state string
+	// frames are leaf first.
+	frames []stack.Frame
+}
+
+// ParseTraceback parses Go tracebacks, such as those printed by a panic or
+// a fatal error, by SIGQUIT, or by /debug/pprof/goroutine?debug=2. The
+// input can be a log file with other lines between the tracebacks, which
+// are ignored. Each goroutine is a sample, so identical stacks are counted
+// together, and the flame graph shows where goroutines were when the
+// tracebacks were printed, such as the sites of crashes. Samples have a
+// TracebackStateLabel label with the state of the goroutine, without how
+// long it has been waiting. The Limits, Focus and Granularity options are
+// used.
+func ParseTraceback(input []byte, opts ParseOptions) (*stack.Profile, error) {
+	limits := opts.Limits.withDefaults()
+	if len(input) > limits.MaxInputSize {
+		return nil, &LimitError{"MaxInputSize", limits.MaxInputSize}
+	}
+
+	var (
+		goroutines  []*tracebackGoroutine
+		inGoroutine bool
+		skipFile    bool
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	scanner.Buffer(nil, limits.MaxLineLength)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if m := tracebackHeaderRE.FindStringSubmatch(trimmed); m != nil {
+			if len(goroutines) >= limits.MaxSamples {
+				return nil, &LimitError{"MaxSamples", limits.MaxSamples}
+			}
+			// The state may be followed by how long the goroutine has been
+			// waiting, e.g. chan receive, 2 minutes.
+			state := strings.TrimSpace(strings.SplitN(m[1], ",", 2)[0])
+			goroutines = append(goroutines, &tracebackGoroutine{state: state})
+			inGoroutine, skipFile = true, false
+			continue
+		}
+		if !inGoroutine {
+			continue
+		}
+
+		g := goroutines[len(goroutines)-1]
+		frames := g.frames
+		switch {
+		case trimmed != "" && (line[0] == '\t' || line[0] == ' '):
+			// The file and line of the previous frame, e.g.
+			//   /src/main.go:20 +0x1d
+			if skipFile || len(frames) == 0 {
+				skipFile = false
+				continue
+			}
+			fields := strings.Fields(trimmed)
+			if fl, ok := parseFileLine(fields[0]); ok {
+				frames[len(frames)-1].File, frames[len(frames)-1].Line = fl.file, fl.line
+			}
+		case strings.HasPrefix(trimmed, "created by "):
+			// The function that started the goroutine is not part of its
+			// stack.
+			skipFile = true
+		case trimmed == "...additional frames elided...":
+		default:
+			fn, ok := parseTracebackFunc(trimmed)
+			if !ok {
+				// The end of the goroutine, e.g. a blank line or a log line.
+				inGoroutine = false
+				continue
+			}
+			g.frames = append(frames, stack.Frame{Func: fn})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return nil, &LimitError{"MaxLineLength", limits.MaxLineLength}
+		}
+		return nil, err
+	}
+	if len(goroutines) == 0 {
+		return nil, fmt.Errorf("no goroutine tracebacks found")
+	}
+	return tracebackProfile(goroutines, opts)
+}
+
+// tracebackProfile merges goroutines with the same stack.
+func tracebackProfile(goroutines []*tracebackGoroutine, opts ParseOptions) (*stack.Profile, error) {
+	profile, err := stack.NewProfile(tracebackSampleNames)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make(map[string]*stack.Sample)
+	for _, g := range goroutines {
+		frames := g.frames
+		if len(frames) == 0 {
+			// e.g. goroutine running on other thread; stack unavailable
+			continue
+		}
+		// Frames are leaf first, but samples are parent first.
+		parentFirst := make([]stack.Frame, len(frames))
+		for i, f := range frames {
+			parentFirst[len(frames)-1-i] = f
+		}
+		funcs := make([]string, len(parentFirst))
+		for i, f := range parentFirst {
+			funcs[i] = f.Func
+		}
+		if !opts.Focus.Keep(funcs) {
+			continue
+		}
+
+		names := opts.Granularity.Names(parentFirst)
+		key := g.state + "\x00" + strings.Join(names, ";")
+		if sample, ok := samples[key]; ok {
+			if err := sample.Add([]int64{1}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		sample := stack.NewSample(names, []int64{1})
+		sample.Labels = stack.Labels{TracebackStateLabel: {g.state}}
+		samples[key] = sample
+		profile.Samples = append(profile.Samples, sample)
+	}
+	return profile, nil
+}
+
+// parseTracebackFunc returns the function name of a frame in a traceback,
+// which is followed by its arguments, e.g. main.(*T).parse(0xc000010000, 0x5).
+func parseTracebackFunc(line string) (string, bool) {
+	idx := strings.LastIndex(line, "(")
+	if idx <= 0 || !strings.HasSuffix(line, ")") {
+		return "", false
+	}
+	fn := line[:idx]
+	if strings.ContainsAny(fn, " \t") {
+		return "", false
+	}
+	return fn, true
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pprof
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+func TestParseTraceback(t *testing.T) {
+	input, err := ioutil.ReadFile("testdata/traceback.log")
+	require.NoError(t, err, "failed to read test traceback log")
+
+	profile, err := ParseTraceback(input, ParseOptions{})
+	require.NoError(t, err, "ParseTraceback failed")
+	assert.Equal(t, tracebackSampleNames, profile.SampleNames)
+
+	// Both panics have the same stack, so they are counted together. The
+	// goroutine without a stack is skipped.
+	expected := []*stack.Sample{
+		{
+			Funcs:  []string{"net/http.HandlerFunc.ServeHTTP", "main.(*server).handle", "main.parse"},
+			Counts: []int64{2},
+			Labels: stack.Labels{"state": {"running"}},
+		},
+		{
+			Funcs:  []string{"main.main", "internal/poll.runtime_pollWait"},
+			Counts: []int64{1},
+			Labels: stack.Labels{"state": {"IO wait"}},
+		},
+	}
+	assert.Equal(t, expected, profile.Samples)
+
+	profile, err = ParseTraceback(input, ParseOptions{Granularity: stack.LineGranularity})
+	require.NoError(t, err, "ParseTraceback failed")
+	assert.Equal(t, "main.parse parse.go:12", profile.Samples[0].Funcs[2], "frames should have their file and line")
+
+	profile, err = ParseTraceback(input, ParseOptions{Focus: stack.FocusFilter{Focus: regexp.MustCompile(`^main\.main$`)}})
+	require.NoError(t, err, "ParseTraceback failed")
+	require.Len(t, profile.Samples, 1)
+	assert.Equal(t, []int64{1}, profile.Samples[0].Counts)
+}
+
+func TestParseTracebackFunc(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{"main.main()", "main.main", true},
+		{"main.parse(...)", "main.parse", true},
+		{"main.(*T).Method(0xc000010000, 0x5)", "main.(*T).Method", true},
+		{"panic({0x4b1e40?, 0xc000012345?})", "panic", true},
+		{"exit status 2", "", false},
+		{"2017/10/10 08:30:16 handled (200)", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTracebackFunc(tt.line)
+		assert.Equal(t, tt.ok, ok, "parseTracebackFunc(%q) ok", tt.line)
+		assert.Equal(t, tt.want, got, "parseTracebackFunc(%q)", tt.line)
+	}
+}
+
+func TestParseTracebackErrors(t *testing.T) {
+	_, err := ParseTraceback([]byte("2017/10/10 08:30:15 starting server\n"), ParseOptions{})
+	if assert.Error(t, err, "ParseTraceback without tracebacks should fail") {
+		assert.True(t, strings.Contains(err.Error(), "no goroutine tracebacks"), "unexpected error %v", err)
+	}
+
+	_, err = ParseTraceback([]byte("goroutine 1 [running]:\n"), ParseOptions{Limits: Limits{MaxInputSize: 10}})
+	assert.Equal(t, &LimitError{"MaxInputSize", 10}, err)
+}
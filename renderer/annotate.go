@@ -0,0 +1,88 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// frameRE matches the title and rectangle of a frame in a flame graph
+// generated by flamegraph.pl, e.g.
+//
+//	<title>main.fib (3 samples, 10.00%)</title><rect x="10.0" ... />
+var frameRE = regexp.MustCompile(`<title>([^<]*)</title>(\s*<rect\b[^>]*?)(\s*/>)`)
+
+// annotationStroke outlines annotated frames, so they stand out without
+// changing the colors of the flame graph.
+const annotationStroke = ` stroke="rgb(0,0,0)" stroke-width="1.5"`
+
+// AnnotateFlameGraph adds notes to the frames of functions in a flame graph
+// generated by flamegraph.pl. The note is added to the frame's title, which
+// is shown when hovering over the frame, and annotated frames are outlined.
+func AnnotateFlameGraph(svg []byte, notes map[string]string) []byte {
+	return addNotes(svg, notes, annotationStroke)
+}
+
+// NoteFlameGraph adds notes to the titles of the frames of functions in a
+// flame graph generated by flamegraph.pl, without outlining the frames.
+func NoteFlameGraph(svg []byte, notes map[string]string) []byte {
+	return addNotes(svg, notes, "")
+}
+
+// addNotes adds notes to the titles of frames, and adds stroke to the
+// rectangles of the frames that have a note.
+func addNotes(svg []byte, notes map[string]string, stroke string) []byte {
+	if len(notes) == 0 {
+		return svg
+	}
+	return frameRE.ReplaceAllFunc(svg, func(frame []byte) []byte {
+		m := frameRE.FindSubmatch(frame)
+		title := html.UnescapeString(string(m[1]))
+		note, ok := notes[titleFunc(title)]
+		if !ok {
+			return frame
+		}
+
+		var out []byte
+		out = append(out, "<title>"...)
+		out = append(out, m[1]...)
+		out = append(out, html.EscapeString("\n"+note)...)
+		out = append(out, "</title>"...)
+		out = append(out, m[2]...)
+		out = append(out, stroke...)
+		return append(out, m[3]...)
+	})
+}
+
+// titleFunc returns the function name from a frame title, which is followed
+// by the sample count, e.g. "main.fib (3 samples, 10.00%)", and any notes
+// on the following lines.
+func titleFunc(title string) string {
+	if idx := strings.Index(title, "\n"); idx >= 0 {
+		title = title[:idx]
+	}
+	if idx := strings.LastIndex(title, " ("); idx >= 0 {
+		return title[:idx]
+	}
+	return title
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotateFlameGraph(t *testing.T) {
+	svg := []byte(`<g class="func_g">
+<title>main.fib (3 samples, 75.00%)</title><rect x="10.0" y="33" width="180.0" height="15.0" fill="rgb(230,100,40)" rx="2" ry="2" />
+</g>
+<g class="func_g">
+<title>main.(*T).run (1 samples, 25.00%)</title><rect x="190.0" y="33" width="60.0" height="15.0" fill="rgb(230,100,40)" rx="2" ry="2" />
+</g>
+<g class="func_g">
+<title>main.other (1 samples, 25.00%)</title><rect x="250.0" y="33" width="60.0" height="15.0" fill="rgb(230,100,40)" rx="2" ry="2" />
+</g>`)
+	notes := map[string]string{
+		"main.fib":      "known issue <JIRA-123>",
+		"main.(*T).run": "fixed in v1.4",
+	}
+
+	got := string(AnnotateFlameGraph(svg, notes))
+	assert.Contains(t, got, "<title>main.fib (3 samples, 75.00%)\nknown issue &lt;JIRA-123&gt;</title>")
+	assert.Contains(t, got, `<title>main.(*T).run (1 samples, 25.00%)`+"\nfixed in v1.4</title>")
+	assert.Contains(t, got, `<title>main.other (1 samples, 25.00%)</title>`, "other frames should not change")
+	assert.Equal(t, 2, strings.Count(got, annotationStroke), "annotated frames should be outlined")
+	assert.Contains(t, got, `rx="2" ry="2"`+annotationStroke+" />")
+
+	assert.Equal(t, svg, AnnotateFlameGraph(svg, nil))
+}
+
+func TestAnnotateFlameGraphEscaped(t *testing.T) {
+	svg := []byte(`<title>std::vector&lt;int&gt;::push_back (1 samples, 100%)</title><rect x="0" y="0" width="10" height="10" />`)
+	got := AnnotateFlameGraph(svg, map[string]string{"std::vector<int>::push_back": "note"})
+	assert.Contains(t, string(got), "\nnote</title>")
+}
+
+func TestAnnotatedRendering(t *testing.T) {
+	svg := AnnotateFlameGraph([]byte(testSVG), map[string]string{"main.fib": "hot"})
+
+	img, err := parseSVG(svg)
+	require.NoError(t, err)
+	require.Len(t, img.Rects, 2)
+	assert.Equal(t, black, img.Rects[1].Stroke)
+	assert.Equal(t, 1.5, img.Rects[1].StrokeWidth)
+
+	out, err := SVGToPNG(svg)
+	require.NoError(t, err)
+	decoded, err := png.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	assert.Equal(t, black, color.RGBAModel.Convert(decoded.At(100, 33)), "outline")
+	assert.Equal(t, color.RGBA{230, 100, 40, 0xff}, color.RGBAModel.Convert(decoded.At(100, 36)), "inside outline")
+
+	pdf, err := SVGToPDF(svg)
+	require.NoError(t, err)
+	assert.Contains(t, string(pdf), "0.000 0.000 0.000 RG 1.50 w 10.00 18.00 180.00 15.00 re S\n")
+}
+
+func TestNoteFlameGraph(t *testing.T) {
+	svg := []byte(`<title>main.fib (3 samples, 75.00%)</title><rect x="0" y="0" width="10" height="10" />`)
+	got := NoteFlameGraph(svg, map[string]string{"main.fib": "self: 2 (50.00%)"})
+	assert.Equal(t, `<title>main.fib (3 samples, 75.00%)`+"\nself: 2 (50.00%)</title>"+`<rect x="0" y="0" width="10" height="10" />`, string(got))
+
+	got = AnnotateFlameGraph(got, map[string]string{"main.fib": "hot"})
+	assert.Contains(t, string(got), "\nself: 2 (50.00%)\nhot</title>", "frames with notes can be annotated")
+	assert.Contains(t, string(got), annotationStroke)
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// callgrindEventRE matches the characters that cannot be used in callgrind
+// event names.
+var callgrindEventRE = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// callgrindFunc is the self cost of a function, and the inclusive cost of
+// each function that it calls, in the order that they were first seen.
+type callgrindFunc struct {
+	id      int
+	self    []int64
+	callees []int
+	calls   map[int][]int64
+}
+
+// ToCallgrind converts the given profile to the callgrind format, which can
+// be opened in KCachegrind or QCachegrind to see the callers and callees of
+// each function, see http://valgrind.org/docs/manual/cl-format.html.
+// Each sample type is an event, with the event for sampleIdx first so that
+// it is shown when the file is opened. Profiles do not record source
+// positions or call counts, so all costs are at line 0 and calls are 0.
+func ToCallgrind(profile *stack.Profile, sampleIdx int, name string) ([]byte, error) {
+	if sampleIdx < 0 || sampleIdx >= len(profile.SampleNames) {
+		return nil, fmt.Errorf("sample index %v is out of range for %v samples", sampleIdx, len(profile.SampleNames))
+	}
+
+	order := []int{sampleIdx}
+	for i := range profile.SampleNames {
+		if i != sampleIdx {
+			order = append(order, i)
+		}
+	}
+	costs := func(counts []int64) []int64 {
+		ordered := make([]int64, len(order))
+		for i, idx := range order {
+			ordered[i] = counts[idx]
+		}
+		return ordered
+	}
+	add := func(total, counts []int64) {
+		for i := range total {
+			total[i] += counts[i]
+		}
+	}
+
+	var funcs []*callgrindFunc
+	var names []string
+	byName := make(map[string]*callgrindFunc)
+	getFunc := func(name string) *callgrindFunc {
+		f, ok := byName[name]
+		if !ok {
+			f = &callgrindFunc{
+				id:    len(funcs) + 1,
+				self:  make([]int64, len(order)),
+				calls: make(map[int][]int64),
+			}
+			byName[name] = f
+			funcs = append(funcs, f)
+			names = append(names, name)
+		}
+		return f
+	}
+
+	summary := make([]int64, len(order))
+	for _, s := range profile.Samples {
+		if len(s.Funcs) == 0 {
+			continue
+		}
+		counts := costs(s.Counts)
+		add(summary, counts)
+
+		// Recursive calls are only counted once per sample, so the inclusive
+		// cost of a call is never more than the total.
+		seen := make(map[[2]int]bool)
+		caller := getFunc(s.Funcs[0])
+		for _, fn := range s.Funcs[1:] {
+			callee := getFunc(fn)
+			edge := [2]int{caller.id, callee.id}
+			if !seen[edge] {
+				seen[edge] = true
+				total, ok := caller.calls[callee.id]
+				if !ok {
+					total = make([]int64, len(order))
+					caller.calls[callee.id] = total
+					caller.callees = append(caller.callees, callee.id)
+				}
+				add(total, counts)
+			}
+			caller = callee
+		}
+		add(caller.self, counts)
+	}
+
+	var events []string
+	var buf bytes.Buffer
+	buf.WriteString("# callgrind format\nversion: 1\ncreator: go-torch\n")
+	fmt.Fprintf(&buf, "cmd: %v\npositions: line\n", name)
+	for _, idx := range order {
+		sampleName := profile.SampleNames[idx]
+		event := callgrindEventRE.ReplaceAllString(strings.SplitN(sampleName, "/", 2)[0], "_")
+		fmt.Fprintf(&buf, "event: %v : %v\n", event, sampleName)
+		events = append(events, event)
+	}
+	fmt.Fprintf(&buf, "events: %v\nsummary: %v\n", strings.Join(events, " "), callgrindCosts(summary))
+
+	// Names are compressed, so each name is only written the first time that
+	// its id is used.
+	written := make(map[int]bool)
+	writeName := func(key string, id int) {
+		if written[id] {
+			fmt.Fprintf(&buf, "%v=(%v)\n", key, id)
+			return
+		}
+		written[id] = true
+		fmt.Fprintf(&buf, "%v=(%v) %v\n", key, id, names[id-1])
+	}
+	for _, f := range funcs {
+		buf.WriteString("\n")
+		writeName("fn", f.id)
+		fmt.Fprintf(&buf, "0 %v\n", callgrindCosts(f.self))
+		for _, callee := range f.callees {
+			writeName("cfn", callee)
+			fmt.Fprintf(&buf, "calls=0 0\n0 %v\n", callgrindCosts(f.calls[callee]))
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// callgrindCosts formats the costs of each event for a cost line.
+func callgrindCosts(costs []int64) string {
+	formatted := make([]string, len(costs))
+	for i, c := range costs {
+		formatted[i] = fmt.Sprint(c)
+	}
+	return strings.Join(formatted, " ")
+}
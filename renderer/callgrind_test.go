@@ -0,0 +1,92 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+func TestToCallgrind(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count", "cpu/nanoseconds"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main", "foo"}, Counts: []int64{2, 20}},
+			{Funcs: []string{"main", "bar", "foo"}, Counts: []int64{1, 0}},
+			{Funcs: nil, Counts: []int64{3, 30}},
+		},
+	}
+
+	out, err := ToCallgrind(profile, 1, "test")
+	require.NoError(t, err, "ToCallgrind failed")
+
+	expected := `# callgrind format
+version: 1
+creator: go-torch
+cmd: test
+positions: line
+event: cpu : cpu/nanoseconds
+event: samples : samples/count
+events: cpu samples
+summary: 20 3
+
+fn=(1) main
+0 0 0
+cfn=(2) foo
+calls=0 0
+0 20 2
+cfn=(3) bar
+calls=0 0
+0 0 1
+
+fn=(2)
+0 20 3
+
+fn=(3)
+0 0 0
+cfn=(2)
+calls=0 0
+0 0 1
+`
+	assert.Equal(t, expected, string(out))
+}
+
+func TestToCallgrindRecursion(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main", "fib", "fib", "fib"}, Counts: []int64{4}},
+		},
+	}
+
+	out, err := ToCallgrind(profile, 0, "test")
+	require.NoError(t, err, "ToCallgrind failed")
+	assert.Contains(t, string(out), "\nfn=(2)\n0 4\ncfn=(2)\ncalls=0 0\n0 4\n", "recursive calls should only be counted once")
+}
+
+func TestToCallgrindInvalidSample(t *testing.T) {
+	profile := &stack.Profile{SampleNames: []string{"samples/count"}}
+	_, err := ToCallgrind(profile, 1, "test")
+	assert.Error(t, err, "expected out of range sample index to fail")
+}
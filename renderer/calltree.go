@@ -0,0 +1,110 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// callTreeFile is the JSON export of a profile's call tree.
+type callTreeFile struct {
+	Name string `json:"name,omitempty"`
+	// SampleTypes are the names of the counts of each node, e.g. cpu/nanoseconds.
+	SampleTypes []string      `json:"sampleTypes"`
+	Root        *callTreeNode `json:"root"`
+}
+
+// callTreeNode is a call stack in the call tree. Total includes the samples
+// of all of its children, and Self only includes samples where the stack
+// ends at the node.
+type callTreeNode struct {
+	Name     string          `json:"name"`
+	Self     []int64         `json:"self"`
+	Total    []int64         `json:"total"`
+	Children []*callTreeNode `json:"children,omitempty"`
+
+	byName map[string]*callTreeNode
+}
+
+func newCallTreeNode(name string, numSamples int) *callTreeNode {
+	return &callTreeNode{
+		Name:  name,
+		Self:  make([]int64, numSamples),
+		Total: make([]int64, numSamples),
+	}
+}
+
+// child returns the child of the node for a function, adding it if needed.
+func (n *callTreeNode) child(name string) *callTreeNode {
+	if c, ok := n.byName[name]; ok {
+		return c
+	}
+	if n.byName == nil {
+		n.byName = make(map[string]*callTreeNode)
+	}
+	c := newCallTreeNode(name, len(n.Total))
+	n.byName[name] = c
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// ToCallTree converts the given profile to a JSON call tree, with the counts
+// of every sample type for each node. Stacks are merged from the root, so
+// each node is a unique call stack, and children are sorted by name so that
+// the output of the same profile is the same.
+func ToCallTree(profile *stack.Profile, name string) ([]byte, error) {
+	numSamples := len(profile.SampleNames)
+	root := newCallTreeNode("root", numSamples)
+	for _, s := range profile.Samples {
+		node := root
+		addCounts(node.Total, s.Counts)
+		for _, f := range s.Funcs {
+			node = node.child(f)
+			addCounts(node.Total, s.Counts)
+		}
+		addCounts(node.Self, s.Counts)
+	}
+	sortCallTree(root)
+
+	return json.Marshal(&callTreeFile{
+		Name:        name,
+		SampleTypes: profile.SampleNames,
+		Root:        root,
+	})
+}
+
+func addCounts(dst, counts []int64) {
+	for i, c := range counts {
+		dst[i] += c
+	}
+}
+
+func sortCallTree(n *callTreeNode) {
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, c := range n.Children {
+		sortCallTree(c)
+	}
+}
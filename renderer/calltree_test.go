@@ -0,0 +1,64 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+func TestToCallTree(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count", "cpu/nanoseconds"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main", "foo"}, Counts: []int64{2, 20}},
+			{Funcs: []string{"main", "bar", "foo"}, Counts: []int64{1, 10}},
+			{Funcs: []string{"main"}, Counts: []int64{1, 5}},
+			{Funcs: []string{"main", "foo"}, Counts: []int64{3, 30}},
+		},
+	}
+
+	got, err := ToCallTree(profile, "test")
+	require.NoError(t, err)
+
+	expected := `{
+		"name": "test",
+		"sampleTypes": ["samples/count", "cpu/nanoseconds"],
+		"root": {"name": "root", "self": [0, 0], "total": [7, 65], "children": [
+			{"name": "main", "self": [1, 5], "total": [7, 65], "children": [
+				{"name": "bar", "self": [0, 0], "total": [1, 10], "children": [
+					{"name": "foo", "self": [1, 10], "total": [1, 10]}
+				]},
+				{"name": "foo", "self": [5, 50], "total": [5, 50]}
+			]}
+		]}
+	}`
+	assert.JSONEq(t, expected, string(got))
+}
+
+func TestToCallTreeEmpty(t *testing.T) {
+	got, err := ToCallTree(&stack.Profile{SampleNames: []string{"samples/count"}}, "")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"sampleTypes": ["samples/count"], "root": {"name": "root", "self": [0], "total": [0]}}`, string(got))
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber/go-torch/perf"
+	"github.com/uber/go-torch/stack"
+)
+
+// Formats of stacks that CollapseStacks can collapse.
+const (
+	AutoStacks  = "auto"
+	PerfStacks  = "perf"
+	PProfStacks = "pprof"
+	GDBStacks   = "gdb"
+)
+
+var (
+	// gdbFrameRE matches a frame of a gdb backtrace, with an optional
+	// address, e.g. "#1  0x000000000045f0 in main.work (n=3) at main.go:10".
+	gdbFrameRE = regexp.MustCompile(`^#\d+\s+(?:0x[0-9a-fA-F]+\s+in\s+)?(.*?)(?:\s+\(.*)?$`)
+
+	// tracesValueRE matches the value of a trace in go tool pprof -traces
+	// output, which has a unit for durations and sizes, e.g. 10ms or 1.50MB.
+	tracesValueRE = regexp.MustCompile(`^(-?[0-9.]+)([a-zA-Zµ]*)$`)
+)
+
+// tracesSeparator separates the traces in go tool pprof -traces output.
+const tracesSeparator = "-----------+"
+
+// tracesUnits are the units of values in go tool pprof -traces output, as
+// multiples of nanoseconds or bytes.
+var tracesUnits = map[string]float64{
+	"":   1,
+	"ns": float64(time.Nanosecond),
+	"us": float64(time.Microsecond),
+	"µs": float64(time.Microsecond),
+	"ms": float64(time.Millisecond),
+	"s":  float64(time.Second),
+	"B":  1,
+	"kB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+}
+
+// CollapseStacks collapses stacks into flame graph input, without needing
+// Brendan Gregg's stackcollapse scripts. The format is one of:
+//
+//	perf: the output of perf script
+//	pprof: the output of go tool pprof -traces
+//	gdb: backtraces of each thread from gdb, e.g. repeated
+//	     thread apply all bt output, which are counted once each
+//
+// or auto to detect the format from the stacks.
+func CollapseStacks(stacks []byte, format string) ([]byte, error) {
+	if format == AutoStacks {
+		format = detectStacks(stacks)
+	}
+
+	var profile *stack.Profile
+	var err error
+	switch format {
+	case PerfStacks:
+		profile, err = perf.ParseScript(bytes.NewReader(stacks), perf.ParseOptions{})
+	case PProfStacks:
+		profile, err = collapseTraces(stacks)
+	case GDBStacks:
+		profile, err = collapseGDB(stacks)
+	default:
+		return nil, fmt.Errorf("unknown stack format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ToFlameInput(profile, 0)
+}
+
+// detectStacks returns the format of stacks: pprof if they have the
+// separator of go tool pprof -traces output, gdb if they have a gdb frame,
+// or perf otherwise.
+func detectStacks(stacks []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(stacks))
+	scanner.Buffer(nil, len(stacks)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, tracesSeparator) {
+			return PProfStacks
+		}
+		if gdbFrameRE.MatchString(line) {
+			return GDBStacks
+		}
+	}
+	return PerfStacks
+}
+
+// collapseTraces parses go tool pprof -traces output, which has a trace for
+// each sample with its value and the leaf frame on the first line, e.g.
+//
+//	-----------+-------------------------------------------------------
+//	      10ms   runtime.memmove
+//	             main.main
+//
+// Lines before the value, such as labels, are skipped.
+func collapseTraces(traces []byte) (*stack.Profile, error) {
+	profile := &stack.Profile{SampleNames: []string{"samples/count"}}
+
+	var funcs []string
+	var value int64
+	inTrace := false
+	flush := func() {
+		if len(funcs) > 0 {
+			reverseFuncs(funcs)
+			profile.Samples = append(profile.Samples, &stack.Sample{Funcs: funcs, Counts: []int64{value}})
+		}
+		funcs = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(traces))
+	scanner.Buffer(nil, len(traces)+1)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, tracesSeparator):
+			flush()
+			inTrace = true
+		case !inTrace || line == "":
+			// The header before the first trace is skipped.
+		case len(funcs) > 0:
+			funcs = append(funcs, tracesFunc(line))
+		default:
+			fields := strings.SplitN(line, " ", 2)
+			v, ok := parseTracesValue(fields[0])
+			if !ok || len(fields) < 2 {
+				continue
+			}
+			value = v
+			funcs = append(funcs, tracesFunc(fields[1]))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read pprof traces: %v", err)
+	}
+	flush()
+
+	if len(profile.Samples) == 0 {
+		return nil, fmt.Errorf("no traces found in pprof output")
+	}
+	return stack.Merge(profile)
+}
+
+// tracesFunc returns the function of a frame in go tool pprof -traces
+// output, without the marker for inlined functions.
+func tracesFunc(frame string) string {
+	return strings.TrimSuffix(strings.TrimSpace(frame), " (inline)")
+}
+
+// parseTracesValue parses a value in go tool pprof -traces output, in
+// nanoseconds for durations and bytes for sizes.
+func parseTracesValue(s string) (int64, bool) {
+	m := tracesValueRE.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	scale, ok := tracesUnits[m[2]]
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return int64(math.Floor(v*scale + 0.5)), true
+}
+
+// collapseGDB parses gdb backtraces, which are separated by blank lines or
+// thread headers, and have the leaf frame first, e.g.
+//
+//	Thread 2 (Thread 0x7f2c (LWP 1235)):
+//	#0  0x00007f2c in epoll_wait (epfd=4) at epoll_wait.c:30
+//	#1  0x0000000000401000 in main.main () at main.go:10
+func collapseGDB(backtraces []byte) (*stack.Profile, error) {
+	profile := &stack.Profile{SampleNames: []string{"samples/count"}}
+
+	var funcs []string
+	flush := func() {
+		if len(funcs) > 0 {
+			reverseFuncs(funcs)
+			profile.Samples = append(profile.Samples, &stack.Sample{Funcs: funcs, Counts: []int64{1}})
+		}
+		funcs = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(backtraces))
+	scanner.Buffer(nil, len(backtraces)+1)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := gdbFrameRE.FindStringSubmatch(line); m != nil {
+			funcs = append(funcs, m[1])
+			continue
+		}
+		// Any other line, such as a thread header, ends the backtrace.
+		flush()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read gdb backtraces: %v", err)
+	}
+	flush()
+
+	if len(profile.Samples) == 0 {
+		return nil, fmt.Errorf("no backtraces found in gdb output")
+	}
+	return stack.Merge(profile)
+}
+
+// reverseFuncs reverses funcs, which are leaf first, to be parent first.
+func reverseFuncs(funcs []string) {
+	for i, j := 0, len(funcs)-1; i < j; i, j = i+1, j-1 {
+		funcs[i], funcs[j] = funcs[j], funcs[i]
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTraces = `File: app
+Type: cpu
+Duration: 30s, Total samples = 1.52s ( 5.07%)
+-----------+-------------------------------------------------------
+      10ms   runtime.memmove
+             bytes.(*Buffer).Write (inline)
+             main.main
+-----------+-------------------------------------------------------
+         handler:[/foo]
+    1.50s   main.work
+             main.main
+-----------+-------------------------------------------------------
+       5ms   runtime.memmove
+             bytes.(*Buffer).Write
+             main.main
+-----------+-------------------------------------------------------
+`
+
+const testGDB = `Thread 2 (Thread 0x7f2c (LWP 1235)):
+#0  0x00007f2c in epoll_wait (epfd=4, events=0x7ffd) at ../sysdeps/epoll_wait.c:30
+#1  0x0000000000401000 in main.poll () at main.go:20
+#2  main.main () at main.go:10
+
+Thread 1 (Thread 0x7f2b (LWP 1234)):
+#0  0x00007f2b in ?? ()
+#1  0x0000000000401000 in main.main () at main.go:10
+Thread 2 (Thread 0x7f2c (LWP 1235)):
+#0  0x00007f2c in epoll_wait (epfd=4, events=0x7ffd) at ../sysdeps/epoll_wait.c:30
+#1  0x0000000000401000 in main.poll () at main.go:20
+#2  main.main () at main.go:10
+`
+
+const testPerfScript = `myapp 1234 1000.123456:     250000 cpu-clock:
+	  45d1a0 runtime.mallocgc+0x10 (/usr/local/bin/myapp)
+	  401000 main.main+0x20 (/usr/local/bin/myapp)
+
+myapp 1234 1000.123457:     250000 cpu-clock:
+	  45d1a0 runtime.mallocgc+0x10 (/usr/local/bin/myapp)
+	  401000 main.main+0x20 (/usr/local/bin/myapp)
+`
+
+func TestCollapseStacks(t *testing.T) {
+	tests := []struct {
+		format string
+		stacks string
+		want   string
+	}{
+		{
+			format: PProfStacks,
+			stacks: testTraces,
+			want: "main.main;bytes.(*Buffer).Write;runtime.memmove 15000000\n" +
+				"main.main;main.work 1500000000\n",
+		},
+		{
+			format: GDBStacks,
+			stacks: testGDB,
+			want:   "main.main;main.poll;epoll_wait 2\nmain.main;?? 1\n",
+		},
+		{
+			format: PerfStacks,
+			stacks: testPerfScript,
+			want:   "main.main;runtime.mallocgc 2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		out, err := CollapseStacks([]byte(tt.stacks), tt.format)
+		require.NoError(t, err, "CollapseStacks %v failed", tt.format)
+		assert.Equal(t, tt.want, string(out), "CollapseStacks %v", tt.format)
+
+		out, err = CollapseStacks([]byte(tt.stacks), AutoStacks)
+		require.NoError(t, err, "CollapseStacks auto failed for %v", tt.format)
+		assert.Equal(t, tt.want, string(out), "CollapseStacks auto should detect %v", tt.format)
+	}
+}
+
+func TestCollapseStacksErrors(t *testing.T) {
+	_, err := CollapseStacks([]byte(testGDB), "jstack")
+	assert.EqualError(t, err, `unknown stack format "jstack"`)
+
+	_, err = CollapseStacks([]byte("File: app\n"), PProfStacks)
+	assert.EqualError(t, err, "no traces found in pprof output")
+
+	_, err = CollapseStacks([]byte("Thread 1 (LWP 1234):\n"), GDBStacks)
+	assert.EqualError(t, err, "no backtraces found in gdb output")
+}
+
+func TestParseTracesValue(t *testing.T) {
+	tests := map[string]int64{
+		"5":      5,
+		"10ms":   10000000,
+		"1.50s":  1500000000,
+		"250us":  250000,
+		"512kB":  512 * 1024,
+		"1.50MB": 1572864,
+		"-2ms":   -2000000,
+	}
+	for s, want := range tests {
+		got, ok := parseTracesValue(s)
+		assert.True(t, ok, "parseTracesValue(%q) failed", s)
+		assert.Equal(t, want, got, "parseTracesValue(%q)", s)
+	}
+
+	for _, s := range []string{"main.main", "10xs", "handler:[/foo]"} {
+		_, ok := parseTracesValue(s)
+		assert.False(t, ok, "parseTracesValue(%q) should fail", s)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// RenderOptions are the parameters for RenderDeterministic.
+type RenderOptions struct {
+	// SampleIndex is the index of the sample to render.
+	SampleIndex int
+	// FlameGraphArgs are passed to the flame graph script.
+	FlameGraphArgs []string
+	// OnWarning is called for each sample that is skipped.
+	OnWarning stack.WarningFunc
+}
+
+// RenderDeterministic renders the profile as a flame graph SVG. Identical
+// profiles produce byte-identical output regardless of the order of samples,
+// which makes the output suitable for golden file tests.
+func RenderDeterministic(profile *stack.Profile, opts RenderOptions) ([]byte, error) {
+	flameInput, err := ToFlameInputWithOptions(sortedProfile(profile), opts.SampleIndex, FlameInputOptions{OnWarning: opts.OnWarning})
+	if err != nil {
+		return nil, err
+	}
+
+	return GenerateFlameGraph(flameInput, deterministicArgs(opts.FlameGraphArgs)...)
+}
+
+// sortedProfile returns a copy of profile with samples sorted by stack.
+func sortedProfile(profile *stack.Profile) *stack.Profile {
+	sorted := *profile
+	sorted.Samples = make([]*stack.Sample, len(profile.Samples))
+	copy(sorted.Samples, profile.Samples)
+
+	keys := make(map[*stack.Sample]string, len(sorted.Samples))
+	for _, s := range sorted.Samples {
+		keys[s] = strings.Join(s.Funcs, ";")
+	}
+	sort.SliceStable(sorted.Samples, func(i, j int) bool {
+		return keys[sorted.Samples[i]] < keys[sorted.Samples[j]]
+	})
+	return &sorted
+}
+
+// deterministicArgs adds --hash to args, as the flame graph script
+// otherwise picks random colors for each frame.
+func deterministicArgs(args []string) []string {
+	for _, arg := range args {
+		if arg == "--hash" {
+			return args
+		}
+	}
+
+	withHash := make([]string, 0, len(args)+1)
+	withHash = append(withHash, args...)
+	return append(withHash, "--hash")
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/uber/go-torch/stack"
+)
+
+func TestRenderDeterministic(t *testing.T) {
+	samples := []*stack.Sample{
+		{Funcs: []string{"main", "b"}, Counts: []int64{2}},
+		{Funcs: []string{"main", "a"}, Counts: []int64{1}},
+		{Funcs: []string{"main"}, Counts: []int64{3}},
+	}
+	reversed := []*stack.Sample{samples[2], samples[1], samples[0]}
+
+	// Use a script that prints its arguments and input as the flame graph script.
+	dir, err := ioutil.TempDir("", "go-torch-scripts")
+	if err != nil {
+		t.Fatalf("Failed to create temporary scripts dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "flamegraph.pl")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$@\"\ncat\n"), 0777); err != nil {
+		t.Fatalf("Failed to create script: %v", err)
+	}
+
+	origVal := flameGraphScripts[0]
+	flameGraphScripts[0] = script
+	defer func() { flameGraphScripts[0] = origVal }()
+
+	var outputs []string
+	for _, s := range [][]*stack.Sample{samples, reversed} {
+		profile := &stack.Profile{SampleNames: []string{"samples/count"}, Samples: s}
+		out, err := RenderDeterministic(profile, RenderOptions{})
+		if err != nil {
+			t.Fatalf("RenderDeterministic failed: %v", err)
+		}
+		outputs = append(outputs, string(out))
+	}
+
+	const expected = "--hash\nmain 3\nmain;a 1\nmain;b 2\n"
+	for _, out := range outputs {
+		if out != expected {
+			t.Errorf("RenderDeterministic got unexpected output:\n  got %s\n want %s", out, expected)
+		}
+	}
+
+	if samples[0].Funcs[1] != "b" {
+		t.Errorf("RenderDeterministic should not reorder the input profile")
+	}
+}
+
+func TestDeterministicArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{nil, []string{"--hash"}},
+		{[]string{"--title", "t"}, []string{"--title", "t", "--hash"}},
+		{[]string{"--hash", "--reverse"}, []string{"--hash", "--reverse"}},
+	}
+
+	for _, tt := range tests {
+		if got := deterministicArgs(tt.args); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("deterministicArgs(%v) got %v, want %v", tt.args, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// ToDiffFlameInput converts two profiles to differential flame graph input,
+// which has a line of "func1;func2 <base count> <current count>" for each
+// stack in either profile. The flame graph script sizes frames using the
+// current profile, and colors them by the difference from the base profile.
+func ToDiffFlameInput(base, current *stack.Profile, sampleIdx int, opts FlameInputOptions) ([]byte, error) {
+	var stacks []string
+	counts := make(map[string]*[2]int64)
+	for i, p := range []*stack.Profile{base, current} {
+		if sampleIdx >= len(p.SampleNames) {
+			return nil, fmt.Errorf("sample index %v is out of range for %v samples", sampleIdx, len(p.SampleNames))
+		}
+
+		for _, s := range p.Samples {
+			if len(s.Funcs) == 0 {
+				opts.OnWarning.Warn(stack.EmptyStack, "", "skipped sample with no frames and value %v", s.Counts[sampleIdx])
+				continue
+			}
+
+			key := strings.Join(s.Funcs, ";")
+			c, ok := counts[key]
+			if !ok {
+				c = &[2]int64{}
+				counts[key] = c
+				stacks = append(stacks, key)
+			}
+			c[i] += s.Counts[sampleIdx]
+		}
+	}
+
+	size := 0
+	for _, key := range stacks {
+		size += len(key) + 2*(maxInt64Len+1)
+	}
+	out := make([]byte, 0, size)
+	for _, key := range stacks {
+		c := counts[key]
+		out = append(out, key...)
+		out = append(out, ' ')
+		out = strconv.AppendInt(out, c[0], 10)
+		out = append(out, ' ')
+		out = strconv.AppendInt(out, c[1], 10)
+		out = append(out, '\n')
+	}
+	return out, nil
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+func TestToDiffFlameInput(t *testing.T) {
+	names := []string{"samples/count", "cpu/nanoseconds"}
+	base := &stack.Profile{
+		SampleNames: names,
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main", "a"}, Counts: []int64{1, 10}},
+			{Funcs: []string{"main", "b"}, Counts: []int64{2, 20}},
+		},
+	}
+	current := &stack.Profile{
+		SampleNames: names,
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main", "c"}, Counts: []int64{3, 30}},
+			{Funcs: []string{"main", "a"}, Counts: []int64{4, 40}, Labels: stack.Labels{"handler": {"/foo"}}},
+			{Funcs: []string{"main", "a"}, Counts: []int64{5, 50}},
+			{Funcs: nil, Counts: []int64{6, 60}},
+		},
+	}
+
+	var warnings []stack.Warning
+	opts := FlameInputOptions{OnWarning: func(w stack.Warning) { warnings = append(warnings, w) }}
+	out, err := ToDiffFlameInput(base, current, 1, opts)
+	require.NoError(t, err, "ToDiffFlameInput failed")
+
+	expected := "main;a 10 90\n" +
+		"main;b 20 0\n" +
+		"main;c 0 30\n"
+	assert.Equal(t, expected, string(out))
+	assert.Len(t, warnings, 1, "expected a warning for the empty stack")
+
+	_, err = ToDiffFlameInput(base, current, 2, FlameInputOptions{})
+	assert.Error(t, err, "expected out of range sample index to fail")
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+//go:generate go run gen_scripts.go
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// embeddedScripts are the FlameGraph scripts that are bundled in the binary,
+// by file name. They are added by embedded_scripts.go, which is written by
+// go generate and is not committed, so builds without it only use scripts
+// found in PATH.
+var embeddedScripts map[string][]byte
+
+var (
+	embeddedMu       sync.Mutex
+	embeddedDisabled bool
+)
+
+// UseEmbeddedScripts sets whether embedded scripts are used when a script
+// is not found in PATH. If it is false, only a system copy is used.
+func UseEmbeddedScripts(enabled bool) {
+	embeddedMu.Lock()
+	defer embeddedMu.Unlock()
+	embeddedDisabled = !enabled
+}
+
+// findEmbedded returns the path of the first of candidates that is
+// embedded, extracting the embedded scripts if they have not been extracted
+// yet.
+func findEmbedded(candidates []string) (string, error) {
+	embeddedMu.Lock()
+	defer embeddedMu.Unlock()
+	if embeddedDisabled {
+		return "", nil
+	}
+	for _, c := range candidates {
+		name := filepath.Base(c)
+		if _, ok := embeddedScripts[name]; !ok {
+			continue
+		}
+		dir, err := extractScripts()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, name), nil
+	}
+	return "", nil
+}
+
+// extractScripts writes the embedded scripts to a directory in the user's
+// cache directory that is named by their contents, so each version is
+// extracted once and reused by later runs. All the scripts are extracted
+// together, so the stackcollapse scripts can also be run from it.
+func extractScripts() (string, error) {
+	dir, err := scriptsDir()
+	if err != nil {
+		return "", fmt.Errorf("could not extract scripts: %v", err)
+	}
+	for name, contents := range embeddedScripts {
+		if err := extractScript(dir, name, contents); err != nil {
+			return "", fmt.Errorf("could not extract %v: %v", name, err)
+		}
+	}
+	return dir, nil
+}
+
+// extractScript writes an embedded script to dir, unless it has already
+// been extracted.
+func extractScript(dir, name string, contents []byte) error {
+	path := filepath.Join(dir, name)
+	if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+		if existing, err := ioutil.ReadFile(path); err == nil && string(existing) == string(contents) {
+			return nil
+		}
+	}
+
+	// Write to a temporary file first, so other processes never run a
+	// partially written script.
+	tmp, err := ioutil.TempFile(dir, name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(contents)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0700)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	return err
+}
+
+// scriptsDir returns a directory to extract the embedded scripts to, which
+// only the current user can write to, so other users cannot replace the
+// scripts that are run. It is in the user's cache directory if there is one,
+// and otherwise a new temporary directory, since a shared directory in the
+// temporary directory could be created by another user first.
+func scriptsDir() (string, error) {
+	cacheDir, err := userCacheDir()
+	if err != nil {
+		return ioutil.TempDir("", "go-torch-scripts")
+	}
+
+	names := make([]string, 0, len(embeddedScripts))
+	for name := range embeddedScripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%v\x00%v\x00", name, len(embeddedScripts[name]))
+		h.Write(embeddedScripts[name])
+	}
+
+	parent := filepath.Join(cacheDir, "go-torch")
+	dir := filepath.Join(parent, "scripts-"+hex.EncodeToString(h.Sum(nil)[:6]))
+	if err := os.MkdirAll(parent, 0700); err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// userCacheDir is os.UserCacheDir, and is replaced by tests.
+var userCacheDir = os.UserCacheDir
+
+// checkPrivateDir returns an error if dir is not a directory that is owned
+// by the current user and only accessible by them.
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%v is not a directory", dir)
+	}
+	return checkOwner(dir, info)
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withCacheDir sets the user cache directory to a new temporary directory
+// until the returned function is called.
+func withCacheDir(t *testing.T) (string, func()) {
+	cacheDir, err := ioutil.TempDir("", "go-torch-cache")
+	require.NoError(t, err)
+	orig := userCacheDir
+	userCacheDir = func() (string, error) { return cacheDir, nil }
+	return cacheDir, func() {
+		userCacheDir = orig
+		os.RemoveAll(cacheDir)
+	}
+}
+
+func TestEmbeddedScripts(t *testing.T) {
+	origScripts, origCandidates := embeddedScripts, flameGraphScripts
+	cacheDir, restoreCacheDir := withCacheDir(t)
+	defer func() {
+		embeddedScripts, flameGraphScripts = origScripts, origCandidates
+		scripts = newScriptCache()
+		UseEmbeddedScripts(true)
+		restoreCacheDir()
+	}()
+
+	// A script that is not in PATH uses the embedded copy.
+	embeddedScripts = map[string][]byte{"go-torch-embedded.pl": []byte("#!/bin/sh\necho embedded\n")}
+	flameGraphScripts = []string{"go-torch-embedded.pl", "./FlameGraph/go-torch-embedded.pl"}
+	scripts = newScriptCache()
+
+	out, err := GenerateFlameGraph(nil)
+	require.NoError(t, err, "GenerateFlameGraph should run the embedded script")
+	assert.Equal(t, "embedded\n", string(out))
+
+	path, err := findEmbedded(flameGraphScripts)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err, "embedded script should be extracted")
+	assert.NotZero(t, info.Mode()&0100, "extracted script should be executable")
+	assert.Equal(t, cacheDir, filepath.Dir(filepath.Dir(filepath.Dir(path))), "script should be extracted to the cache directory")
+	dirInfo, err := os.Stat(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), dirInfo.Mode().Perm(), "scripts directory should be private")
+
+	// Changed scripts are extracted to a new directory.
+	embeddedScripts["go-torch-embedded.pl"] = []byte("#!/bin/sh\necho changed\n")
+	changed, err := findEmbedded(flameGraphScripts)
+	require.NoError(t, err)
+	assert.NotEqual(t, path, changed)
+
+	UseEmbeddedScripts(false)
+	scripts = newScriptCache()
+	_, err = GenerateFlameGraph(nil)
+	assert.Equal(t, errNoPerlScript, err, "disabled embedded scripts should not be used")
+	assert.Equal(t, errNoPerlScript, CheckScripts())
+}
+
+func TestEmbeddedScriptsExtractedTogether(t *testing.T) {
+	origScripts := embeddedScripts
+	_, restoreCacheDir := withCacheDir(t)
+	defer func() {
+		embeddedScripts = origScripts
+		restoreCacheDir()
+	}()
+
+	embeddedScripts = map[string][]byte{
+		"flamegraph.pl":    []byte("#!/bin/sh\necho flamegraph\n"),
+		"stackcollapse.pl": []byte("#!/bin/sh\necho collapse\n"),
+	}
+	path, err := findEmbedded([]string{"flamegraph.pl"})
+	require.NoError(t, err)
+	contents, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), "stackcollapse.pl"))
+	require.NoError(t, err, "stackcollapse scripts should be extracted with flamegraph.pl")
+	assert.Equal(t, "#!/bin/sh\necho collapse\n", string(contents))
+}
+
+func TestEmbeddedScriptsNotPrivate(t *testing.T) {
+	origScripts := embeddedScripts
+	cacheDir, restoreCacheDir := withCacheDir(t)
+	defer func() {
+		embeddedScripts = origScripts
+		restoreCacheDir()
+	}()
+	embeddedScripts = map[string][]byte{"flamegraph.pl": []byte("#!/bin/sh\n")}
+
+	// Find the directory the scripts are extracted to, then make it
+	// writable by other users, as if another user had created it first.
+	path, err := findEmbedded([]string{"flamegraph.pl"})
+	require.NoError(t, err)
+	dir := filepath.Dir(path)
+	require.NoError(t, os.Chmod(dir, 0777))
+	_, err = findEmbedded([]string{"flamegraph.pl"})
+	if assert.Error(t, err, "scripts should not be run from a directory other users can write to") {
+		assert.Contains(t, err.Error(), "is accessible by other users")
+	}
+
+	// A symlink to another directory is not followed.
+	require.NoError(t, os.RemoveAll(dir))
+	other := filepath.Join(cacheDir, "other")
+	require.NoError(t, os.Mkdir(other, 0700))
+	require.NoError(t, os.Symlink(other, dir))
+	_, err = findEmbedded([]string{"flamegraph.pl"})
+	if assert.Error(t, err, "scripts should not be extracted through a symlink") {
+		assert.Contains(t, err.Error(), "is not a directory")
+	}
+}
@@ -25,6 +25,10 @@ import (
 	"errors"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/uber/go-torch/torchlog"
 )
 
 var errNoPerlScript = errors.New("Cannot find flamegraph scripts in the PATH or current " +
@@ -32,10 +36,84 @@ var errNoPerlScript = errors.New("Cannot find flamegraph scripts in the PATH or
 	"These scripts should be added to your PATH or in the directory where go-torch is executed. " +
 	"Alternatively, you can run go-torch with the --raw flag.")
 
-var (
-	stackCollapseScripts = []string{"stackcollapse.pl", "./stackcollapse.pl", "./FlameGraph/stackcollapse.pl"}
-	flameGraphScripts    = []string{"flamegraph", "flamegraph.pl", "./flamegraph.pl", "./FlameGraph/flamegraph.pl", "flame-graph-gen"}
-)
+var flameGraphScripts = []string{"flamegraph", "flamegraph.pl", "./flamegraph.pl", "./FlameGraph/flamegraph.pl", "flame-graph-gen"}
+
+// scripts caches the paths of the flame graph scripts.
+var scripts = newScriptCache()
+
+// scriptCache caches the path that each list of candidate scripts resolves
+// to, so processes that render many flame graphs, such as watch mode, only
+// search PATH once. Scripts that are not found are not cached, so they can
+// be installed while the process is running.
+type scriptCache struct {
+	mu    sync.Mutex
+	paths map[string]string
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{paths: make(map[string]string)}
+}
+
+// find returns the first of candidates that is found in PATH, or the path
+// of an embedded copy of it if none are found.
+func (c *scriptCache) find(candidates []string) string {
+	key := strings.Join(candidates, "\x00")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if path, ok := c.paths[key]; ok {
+		return path
+	}
+	path := findInPath(candidates)
+	if path == "" {
+		var err error
+		if path, err = findEmbedded(candidates); err != nil {
+			torchlog.Printf("Failed to use the embedded scripts: %v", err)
+		}
+	}
+	if path != "" {
+		c.paths[key] = path
+	}
+	return path
+}
+
+// forget removes the cached path for candidates, so the next find searches
+// PATH again.
+func (c *scriptCache) forget(candidates []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.paths, strings.Join(candidates, "\x00"))
+}
+
+// run runs the first of candidates that is found in PATH. If the cached
+// script cannot be started, e.g. as it was removed or PATH changed, PATH is
+// searched again.
+func (c *scriptCache) run(candidates []string, args []string, inData []byte) ([]byte, error) {
+	script := c.find(candidates)
+	if script == "" {
+		return nil, errNoPerlScript
+	}
+
+	out, err := runScript(script, args, inData)
+	if _, exited := err.(*exec.ExitError); err == nil || exited {
+		return out, err
+	}
+
+	c.forget(candidates)
+	if script = c.find(candidates); script == "" {
+		return nil, errNoPerlScript
+	}
+	return runScript(script, args, inData)
+}
+
+// CheckScripts returns an error if the flame graph script cannot be found,
+// so long running processes can fail at startup rather than on each render.
+func CheckScripts() error {
+	if scripts.find(flameGraphScripts) == "" {
+		return errNoPerlScript
+	}
+	return nil
+}
 
 // findInPath returns the first path that is found in PATH.
 func findInPath(paths []string) string {
@@ -51,27 +129,13 @@ func findInPath(paths []string) string {
 // It returns the stdout on success.
 func runScript(scriptName string, args []string, inData []byte) ([]byte, error) {
 	cmd := exec.Command(scriptName, args...)
+	torchlog.Debugf("Run script: %v", strings.Join(cmd.Args, " "))
 	cmd.Stdin = bytes.NewReader(inData)
 	cmd.Stderr = os.Stderr
 	return cmd.Output()
 }
 
-// CollapseStacks runs the flamegraph's collapse stacks script.
-func CollapseStacks(stacks []byte, args ...string) ([]byte, error) {
-	stackCollapse := findInPath(stackCollapseScripts)
-	if stackCollapse == "" {
-		return nil, errNoPerlScript
-	}
-
-	return runScript(stackCollapse, nil, stacks)
-}
-
 // GenerateFlameGraph runs the flamegraph script to generate a flame graph SVG.
 func GenerateFlameGraph(graphInput []byte, args ...string) ([]byte, error) {
-	flameGraph := findInPath(flameGraphScripts)
-	if flameGraph == "" {
-		return nil, errNoPerlScript
-	}
-
-	return runScript(flameGraph, args, graphInput)
+	return scripts.run(flameGraphScripts, args, graphInput)
 }
@@ -21,6 +21,7 @@
 package renderer
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -117,9 +118,62 @@ func testScriptNotFound(t *testing.T, sliceToStub *[]string, f scriptFn) {
 	}
 }
 
-func TestCollapseStacks(t *testing.T) {
-	testScriptFound(t, stackCollapseScripts, CollapseStacks)
-	testScriptNotFound(t, &stackCollapseScripts, CollapseStacks)
+func TestScriptCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-script-cache")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+oldPath)
+
+	writeScript := func(name, output string) {
+		contents := "#!/bin/sh\necho " + output + "\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0777); err != nil {
+			t.Fatalf("Failed to write script: %v", err)
+		}
+	}
+	candidates := []string{"go-torch-test-a.pl", "go-torch-test-b.pl"}
+	cache := newScriptCache()
+
+	if _, err := cache.run(candidates, nil, nil); err != errNoPerlScript {
+		t.Errorf("Expected missing scripts to fail with errNoPerlScript, got %v", err)
+	}
+
+	// Missing scripts are not cached, so scripts can be installed later.
+	writeScript("go-torch-test-b.pl", "b")
+	if out, err := cache.run(candidates, nil, nil); err != nil || string(out) != "b\n" {
+		t.Errorf("cache.run got %q, %v, want b", out, err)
+	}
+
+	// The cached script is used even if a preferred script is installed.
+	writeScript("go-torch-test-a.pl", "a")
+	if got := filepath.Base(cache.find(candidates)); got != "go-torch-test-b.pl" {
+		t.Errorf("cache.find got %v, want the cached go-torch-test-b.pl", got)
+	}
+
+	// If the cached script cannot be run, PATH is searched again.
+	os.Remove(filepath.Join(dir, "go-torch-test-b.pl"))
+	if out, err := cache.run(candidates, nil, nil); err != nil || string(out) != "a\n" {
+		t.Errorf("cache.run after removing the cached script got %q, %v, want a", out, err)
+	}
+}
+
+func TestCheckScripts(t *testing.T) {
+	origVal := flameGraphScripts
+	defer func() { flameGraphScripts = origVal }()
+
+	flameGraphScripts = []string{"should-not-find-this", "cat"}
+	if err := CheckScripts(); err != nil {
+		t.Errorf("CheckScripts failed: %v", err)
+	}
+
+	flameGraphScripts = []string{"should-not-find-this"}
+	if err := CheckScripts(); err != errNoPerlScript {
+		t.Errorf("CheckScripts got %v, want %v", err, errNoPerlScript)
+	}
 }
 
 func TestGenerateFlameGraph(t *testing.T) {
@@ -0,0 +1,71 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// ParseFlameInput parses flame graph input, which is a line of collapsed
+// stacks for each sample, "func1;func2;func3 <count>". The returned profile
+// has a single value per sample, named samples/count, as the input does not
+// record what was sampled.
+func ParseFlameInput(input []byte) (*stack.Profile, error) {
+	profile := &stack.Profile{SampleNames: []string{"samples/count"}}
+
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	scanner.Buffer(nil, len(input)+1)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		idx := strings.LastIndex(line, " ")
+		if idx < 0 {
+			return nil, fmt.Errorf("line %v: missing count in %q", lineNum, line)
+		}
+		count, err := strconv.ParseInt(line[idx+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid count: %v", lineNum, err)
+		}
+
+		funcs := strings.Split(strings.TrimSpace(line[:idx]), ";")
+		profile.Samples = append(profile.Samples, &stack.Sample{
+			Funcs:  funcs,
+			Counts: []int64{count},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Merge combines the samples with identical stacks.
+	return stack.Merge(profile)
+}
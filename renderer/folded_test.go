@@ -0,0 +1,66 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+func TestParseFlameInput(t *testing.T) {
+	input := "main;foo 10\n\nmain;bar;baz 5\nmain;foo 2\n"
+	profile, err := ParseFlameInput([]byte(input))
+	require.NoError(t, err, "ParseFlameInput failed")
+
+	expected := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main", "foo"}, Counts: []int64{12}},
+			{Funcs: []string{"main", "bar", "baz"}, Counts: []int64{5}},
+		},
+	}
+	assert.Equal(t, expected, profile)
+
+	// The profile should convert back to the same stacks.
+	flameInput, err := ToFlameInput(profile, 0)
+	require.NoError(t, err, "ToFlameInput failed")
+	assert.Equal(t, "main;foo 12\nmain;bar;baz 5\n", string(flameInput))
+}
+
+func TestParseFlameInputErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		errMsg string
+	}{
+		{"main;foo", "line 1: missing count"},
+		{"main;foo 10\nmain;bar ten", "line 2: invalid count"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseFlameInput([]byte(tt.input))
+		if assert.Error(t, err, "ParseFlameInput(%q) expected to fail", tt.input) {
+			assert.Contains(t, err.Error(), tt.errMsg)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build ignore
+// +build ignore
+
+// gen_scripts writes embedded_scripts.go, which bundles the FlameGraph
+// scripts that go-torch runs in the binary. The scripts are read from a
+// FlameGraph checkout given by -dir, or downloaded from GitHub at -ref,
+// which defaults to flameGraphRef so builds are reproducible.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+// scripts are the FlameGraph scripts that are bundled: flamegraph.pl, which
+// go-torch runs, and the stackcollapse scripts for the formats that
+// --collapse reads, which are extracted with it.
+var scripts = []string{
+	"flamegraph.pl",
+	"stackcollapse.pl",
+	"stackcollapse-perf.pl",
+	"stackcollapse-go.pl",
+	"stackcollapse-gdb.pl",
+}
+
+// flameGraphRef is the FlameGraph tag that the scripts are downloaded from.
+// Update it, and regenerate embedded_scripts.go, to bundle newer scripts.
+const flameGraphRef = "v1.0"
+
+const rawURL = "https://raw.githubusercontent.com/brendangregg/FlameGraph/%v/%v"
+
+func main() {
+	dir := flag.String("dir", "", "FlameGraph checkout to read the scripts from, instead of downloading them")
+	ref := flag.String("ref", flameGraphRef, "FlameGraph commit or tag to download the scripts from")
+	out := flag.String("out", "embedded_scripts.go", "File to write")
+	flag.Parse()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by gen_scripts.go from FlameGraph %v; DO NOT EDIT.\n\n", source(*dir, *ref))
+	buf.WriteString("// The FlameGraph scripts are Copyright Brendan Gregg and others, and are\n")
+	buf.WriteString("// licensed under the CDDL, https://github.com/brendangregg/FlameGraph.\n\n")
+	buf.WriteString("package renderer\n\nfunc init() {\n\tembeddedScripts = map[string][]byte{\n")
+	for _, name := range scripts {
+		contents, err := readScript(*dir, *ref, name)
+		if err != nil {
+			log.Fatalf("could not read %v: %v", name, err)
+		}
+		fmt.Fprintf(&buf, "\t\t%q: []byte(%v),\n", name, strconv.Quote(string(contents)))
+	}
+	buf.WriteString("\t}\n}\n")
+
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("could not format %v: %v", *out, err)
+	}
+	if err := ioutil.WriteFile(*out, formatted, 0666); err != nil {
+		log.Fatalf("could not write %v: %v", *out, err)
+	}
+}
+
+func source(dir, ref string) string {
+	if dir != "" {
+		return dir
+	}
+	return "at " + ref
+}
+
+func readScript(dir, ref, name string) ([]byte, error) {
+	if dir != "" {
+		return ioutil.ReadFile(filepath.Join(dir, name))
+	}
+
+	resp, err := http.Get(fmt.Sprintf(rawURL, ref, name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download returned %v", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// OTLPAttribute is a resource attribute of an OTLP profile, such as
+// service.name.
+type OTLPAttribute struct {
+	Key   string
+	Value string
+}
+
+// The otlp types are the OTLP/JSON encoding of an ExportProfilesServiceRequest
+// for the experimental OpenTelemetry profiles signal, as defined by
+// opentelemetry-proto v1.5.0 (profiles/v1development). As in proto3 JSON,
+// int64 values are encoded as strings.
+type otlpRequest struct {
+	ResourceProfiles []otlpResourceProfiles `json:"resourceProfiles"`
+}
+
+type otlpResourceProfiles struct {
+	Resource      otlpResource        `json:"resource"`
+	ScopeProfiles []otlpScopeProfiles `json:"scopeProfiles"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpScopeProfiles struct {
+	Scope    otlpScope     `json:"scope"`
+	Profiles []otlpProfile `json:"profiles"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpProfile struct {
+	SampleType      []otlpValueType `json:"sampleType"`
+	Sample          []otlpSample    `json:"sample"`
+	LocationTable   []otlpLocation  `json:"locationTable"`
+	LocationIndices []int           `json:"locationIndices"`
+	FunctionTable   []otlpFunction  `json:"functionTable"`
+	AttributeTable  []otlpKeyValue  `json:"attributeTable,omitempty"`
+	StringTable     []string        `json:"stringTable"`
+	TimeNanos       string          `json:"timeNanos"`
+}
+
+type otlpValueType struct {
+	TypeStrindex int `json:"typeStrindex"`
+	UnitStrindex int `json:"unitStrindex"`
+}
+
+type otlpSample struct {
+	LocationsStartIndex int      `json:"locationsStartIndex"`
+	LocationsLength     int      `json:"locationsLength"`
+	Value               []string `json:"value"`
+	AttributeIndices    []int    `json:"attributeIndices,omitempty"`
+}
+
+type otlpLocation struct {
+	Line []otlpLine `json:"line"`
+}
+
+type otlpLine struct {
+	FunctionIndex int `json:"functionIndex"`
+}
+
+type otlpFunction struct {
+	NameStrindex       int `json:"nameStrindex"`
+	SystemNameStrindex int `json:"systemNameStrindex"`
+}
+
+// ToOTLP converts the given profile to an OTLP/JSON export request for the
+// OpenTelemetry profiles signal, with the given resource attributes, so it
+// can be sent to an OTLP/HTTP profiles endpoint. The sample type at
+// sampleIdx is the first sample type, and sample labels are exported as
+// sample attributes. Profiles do not record addresses or source positions,
+// so each function has a single location.
+func ToOTLP(profile *stack.Profile, sampleIdx int, resource []OTLPAttribute, collected time.Time) ([]byte, error) {
+	if sampleIdx < 0 || sampleIdx >= len(profile.SampleNames) {
+		return nil, fmt.Errorf("sample index %v is out of range for %v samples", sampleIdx, len(profile.SampleNames))
+	}
+
+	p := otlpProfile{
+		StringTable: []string{""},
+		TimeNanos:   strconv.FormatInt(collected.UnixNano(), 10),
+	}
+	stringIdx := make(map[string]int)
+	str := func(s string) int {
+		if s == "" {
+			return 0
+		}
+		idx, ok := stringIdx[s]
+		if !ok {
+			idx = len(p.StringTable)
+			stringIdx[s] = idx
+			p.StringTable = append(p.StringTable, s)
+		}
+		return idx
+	}
+
+	order := []int{sampleIdx}
+	for i := range profile.SampleNames {
+		if i != sampleIdx {
+			order = append(order, i)
+		}
+	}
+	for _, idx := range order {
+		typ, unit := splitSampleName(profile.SampleNames[idx])
+		p.SampleType = append(p.SampleType, otlpValueType{TypeStrindex: str(typ), UnitStrindex: str(unit)})
+	}
+
+	// Each function has one location, so they share an index.
+	locations := make(map[string]int)
+	location := func(fn string) int {
+		idx, ok := locations[fn]
+		if !ok {
+			idx = len(p.LocationTable)
+			locations[fn] = idx
+			name := str(fn)
+			p.FunctionTable = append(p.FunctionTable, otlpFunction{NameStrindex: name, SystemNameStrindex: name})
+			p.LocationTable = append(p.LocationTable, otlpLocation{Line: []otlpLine{{FunctionIndex: idx}}})
+		}
+		return idx
+	}
+	attributes := make(map[otlpKeyValue]int)
+	attribute := func(key, value string) int {
+		kv := otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: value}}
+		idx, ok := attributes[kv]
+		if !ok {
+			idx = len(p.AttributeTable)
+			attributes[kv] = idx
+			p.AttributeTable = append(p.AttributeTable, kv)
+		}
+		return idx
+	}
+
+	for _, s := range profile.Samples {
+		if len(s.Funcs) == 0 {
+			continue
+		}
+		sample := otlpSample{
+			LocationsStartIndex: len(p.LocationIndices),
+			LocationsLength:     len(s.Funcs),
+		}
+		// Locations are leaf first, as in pprof.
+		for i := len(s.Funcs) - 1; i >= 0; i-- {
+			p.LocationIndices = append(p.LocationIndices, location(s.Funcs[i]))
+		}
+		for _, idx := range order {
+			sample.Value = append(sample.Value, strconv.FormatInt(s.Counts[idx], 10))
+		}
+		keys := make([]string, 0, len(s.Labels))
+		for k := range s.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range s.Labels[k] {
+				sample.AttributeIndices = append(sample.AttributeIndices, attribute(k, v))
+			}
+		}
+		p.Sample = append(p.Sample, sample)
+	}
+
+	var attrs []otlpKeyValue
+	for _, a := range resource {
+		attrs = append(attrs, otlpKeyValue{Key: a.Key, Value: otlpAnyValue{StringValue: a.Value}})
+	}
+	return json.Marshal(&otlpRequest{
+		ResourceProfiles: []otlpResourceProfiles{{
+			Resource: otlpResource{Attributes: attrs},
+			ScopeProfiles: []otlpScopeProfiles{{
+				Scope:    otlpScope{Name: "go-torch"},
+				Profiles: []otlpProfile{p},
+			}},
+		}},
+	})
+}
+
+// splitSampleName returns the type and unit of a pprof sample name, which
+// is formatted as type/unit, e.g. cpu/nanoseconds.
+func splitSampleName(sampleName string) (string, string) {
+	parts := strings.SplitN(sampleName, "/", 2)
+	if len(parts) != 2 {
+		return sampleName, ""
+	}
+	return parts[0], parts[1]
+}
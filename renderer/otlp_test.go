@@ -0,0 +1,83 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+func TestToOTLP(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count", "cpu/nanoseconds"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main", "foo"}, Counts: []int64{2, 20}},
+			{Funcs: []string{"main", "bar", "foo"}, Counts: []int64{1, 10}, Labels: stack.Labels{"handler": {"/foo"}}},
+			{Funcs: nil, Counts: []int64{3, 30}},
+		},
+	}
+	resource := []OTLPAttribute{{"service.name", "svc"}, {"deployment.environment", "prod"}}
+
+	out, err := ToOTLP(profile, 1, resource, time.Unix(10, 5))
+	require.NoError(t, err, "ToOTLP failed")
+
+	expected := `{"resourceProfiles": [{
+		"resource": {"attributes": [
+			{"key": "service.name", "value": {"stringValue": "svc"}},
+			{"key": "deployment.environment", "value": {"stringValue": "prod"}}
+		]},
+		"scopeProfiles": [{
+			"scope": {"name": "go-torch"},
+			"profiles": [{
+				"sampleType": [{"typeStrindex": 1, "unitStrindex": 2}, {"typeStrindex": 3, "unitStrindex": 4}],
+				"sample": [
+					{"locationsStartIndex": 0, "locationsLength": 2, "value": ["20", "2"]},
+					{"locationsStartIndex": 2, "locationsLength": 3, "value": ["10", "1"], "attributeIndices": [0]}
+				],
+				"locationTable": [
+					{"line": [{"functionIndex": 0}]},
+					{"line": [{"functionIndex": 1}]},
+					{"line": [{"functionIndex": 2}]}
+				],
+				"locationIndices": [0, 1, 0, 2, 1],
+				"functionTable": [
+					{"nameStrindex": 5, "systemNameStrindex": 5},
+					{"nameStrindex": 6, "systemNameStrindex": 6},
+					{"nameStrindex": 7, "systemNameStrindex": 7}
+				],
+				"attributeTable": [{"key": "handler", "value": {"stringValue": "/foo"}}],
+				"stringTable": ["", "cpu", "nanoseconds", "samples", "count", "foo", "main", "bar"],
+				"timeNanos": "10000000005"
+			}]
+		}]
+	}]}`
+	assert.JSONEq(t, expected, string(out))
+}
+
+func TestToOTLPInvalidSample(t *testing.T) {
+	profile := &stack.Profile{SampleNames: []string{"samples/count"}}
+	_, err := ToOTLP(profile, 1, nil, time.Now())
+	assert.Error(t, err, "expected out of range sample index to fail")
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"bytes"
+	"hash/fnv"
+	"html"
+	"regexp"
+)
+
+// ColorBlindSafeColors is the Okabe-Ito palette, whose colors stay distinct
+// with the common forms of color blindness. The colors are lightened by 35%
+// towards white, so the black frame labels stay readable on them.
+var ColorBlindSafeColors = []string{
+	"rgb(239,193,89)",  // orange
+	"rgb(145,206,241)", // sky blue
+	"rgb(89,192,164)",  // bluish green
+	"rgb(245,237,132)", // yellow
+	"rgb(89,163,205)",  // blue
+	"rgb(228,150,89)",  // vermillion
+	"rgb(222,168,198)", // reddish purple
+}
+
+// fillRE matches the fill of a frame's rectangle.
+var fillRE = regexp.MustCompile(`\bfill="[^"]*"`)
+
+// diffLineRE matches a line of differential flame graph input, which has
+// the base and current counts, e.g. "main.main;main.fib 10 12".
+var diffLineRE = regexp.MustCompile(`\s\d+\s+\d+\s*$`)
+
+// ColorFlameGraph sets the fill of each frame in a flame graph generated by
+// flamegraph.pl to one of colors, chosen by a hash of the frame's function,
+// so that a function has the same color wherever it appears.
+func ColorFlameGraph(svg []byte, colors []string) []byte {
+	if len(colors) == 0 {
+		return svg
+	}
+	return frameRE.ReplaceAllFunc(svg, func(frame []byte) []byte {
+		m := frameRE.FindSubmatch(frame)
+		fn := titleFunc(html.UnescapeString(string(m[1])))
+		h := fnv.New32a()
+		h.Write([]byte(fn))
+		fill := []byte(`fill="` + colors[h.Sum32()%uint32(len(colors))] + `"`)
+
+		var out []byte
+		out = append(out, "<title>"...)
+		out = append(out, m[1]...)
+		out = append(out, "</title>"...)
+		out = append(out, fillRE.ReplaceAllLiteral(m[2], fill)...)
+		return append(out, m[3]...)
+	})
+}
+
+// IsDiffFlameInput returns whether flameInput is differential flame graph
+// input, whose frames are colored by how their counts changed.
+func IsDiffFlameInput(flameInput []byte) bool {
+	for _, line := range bytes.Split(flameInput, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			return diffLineRE.Match(line)
+		}
+	}
+	return false
+}
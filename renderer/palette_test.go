@@ -0,0 +1,71 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColorFlameGraph(t *testing.T) {
+	svg := []byte(`<g class="func_g">
+<title>main.fib (3 samples, 60.00%)</title><rect x="10.0" y="33" width="180.0" height="15.0" fill="rgb(230,100,40)" rx="2" ry="2" />
+</g>
+<g class="func_g">
+<title>main.main (5 samples, 100.00%)</title><rect x="10.0" y="49" width="300.0" height="15.0" fill="rgb(220,80,30)" rx="2" ry="2" />
+</g>
+<g class="func_g">
+<title>main.fib (2 samples, 40.00%)</title><rect x="190.0" y="17" width="120.0" height="15.0" fill="rgb(210,90,50)" rx="2" ry="2" />
+</g>`)
+
+	got := string(ColorFlameGraph(svg, ColorBlindSafeColors))
+	fills := regexp.MustCompile(`fill="([^"]*)"`).FindAllStringSubmatch(got, -1)
+	if assert.Len(t, fills, 3, "each frame should have a fill") {
+		for _, fill := range fills {
+			assert.Contains(t, ColorBlindSafeColors, fill[1], "frames should use the palette")
+		}
+		assert.Equal(t, fills[0][1], fills[2][1], "frames of the same function should have the same color")
+	}
+	assert.Contains(t, got, `<title>main.fib (3 samples, 60.00%)</title><rect x="10.0" y="33" width="180.0" height="15.0" fill="`, "other attributes should not change")
+	assert.Equal(t, svg, ColorFlameGraph(svg, nil))
+
+	img, err := parseSVG(ColorFlameGraph([]byte(testSVG), ColorBlindSafeColors))
+	if assert.NoError(t, err, "recolored flame graph should be parsed") {
+		assert.NotEmpty(t, img.Rects)
+	}
+}
+
+func TestIsDiffFlameInput(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"main.main;main.fib 10\nmain.main 2\n", false},
+		{"\nmain.main;main.fib 10 12\nmain.main 2 0\n", true},
+		{"main.main;main.fib 10 12\r\n", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, IsDiffFlameInput([]byte(tt.input)), "IsDiffFlameInput(%q)", tt.input)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+)
+
+// helveticaWidth is the approximate average width of a Helvetica character
+// relative to the font size, used to position centered text.
+const helveticaWidth = 0.55
+
+// SVGToPDF converts a flame graph generated by flamegraph.pl to a single
+// page PDF. The rectangles and text are kept as vectors, and text uses the
+// standard Helvetica font, which PDF viewers provide, so no font is embedded.
+func SVGToPDF(svg []byte) ([]byte, error) {
+	img, err := parseSVG(svg)
+	if err != nil {
+		return nil, err
+	}
+
+	// PDF coordinates start at the bottom left, rather than the top left.
+	var content bytes.Buffer
+	for _, r := range img.Rects {
+		fmt.Fprintf(&content, "%v rg %.2f %.2f %.2f %.2f re f\n",
+			pdfColor(r.Fill), r.X, img.Height-r.Y-r.Height, r.Width, r.Height)
+		if r.StrokeWidth > 0 {
+			fmt.Fprintf(&content, "%v RG %.2f w %.2f %.2f %.2f %.2f re S\n",
+				pdfColor(r.Stroke), r.StrokeWidth, r.X, img.Height-r.Y-r.Height, r.Width, r.Height)
+		}
+	}
+	for _, t := range img.Texts {
+		x := t.X
+		width := float64(len([]rune(t.Text))) * t.FontSize * helveticaWidth
+		switch t.Anchor {
+		case "middle":
+			x -= width / 2
+		case "end":
+			x -= width
+		}
+		fmt.Fprintf(&content, "%v rg BT /F1 %.2f Tf %.2f %.2f Td (%s) Tj ET\n",
+			pdfColor(t.Fill), t.FontSize, x, img.Height-t.Y, pdfString(t.Text))
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+			img.Width, img.Height),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %v >>\nstream\n%sendstream", content.Len(), content.Bytes()),
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%v 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %v\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %v /Root 1 0 R >>\nstartxref\n%v\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes(), nil
+}
+
+func pdfColor(c color.RGBA) string {
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+}
+
+// pdfString escapes text for a PDF string literal. Characters outside of
+// Latin-1 are replaced, as the standard fonts cannot show them.
+func pdfString(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < ' ' || r > 0xff:
+			b.WriteByte('?')
+		case r > '~':
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PinFrames orders the lines of flame graph input so that the pinned
+// functions are first, in the order given, among the frames with the same
+// parent, and other frames are in alphabetical order. flamegraph.pl sorts its
+// input unless it renders a flame chart, so the output must be rendered with
+// --flamechart to keep this order.
+func PinFrames(flameInput []byte, pinned []string) []byte {
+	rank := make(map[string]int, len(pinned))
+	for i, f := range pinned {
+		if _, ok := rank[f]; !ok {
+			rank[f] = i
+		}
+	}
+
+	type flameLine struct {
+		text  []byte
+		funcs []string
+	}
+	var lines []flameLine
+	for _, line := range bytes.Split(flameInput, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		funcs := strings.Split(flameStack(string(line)), ";")
+		lines = append(lines, flameLine{line, funcs})
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lessPinned(lines[i].funcs, lines[j].funcs, rank)
+	})
+
+	out := make([]byte, 0, len(flameInput)+1)
+	for _, line := range lines {
+		out = append(out, line.text...)
+		out = append(out, '\n')
+	}
+	return out
+}
+
+// flameStack returns the stack of a line of flame graph input without its
+// count, or both counts of differential flame graph input.
+func flameStack(line string) string {
+	line = strings.TrimSpace(line)
+	for i := 0; i < 2; i++ {
+		idx := strings.LastIndex(line, " ")
+		if idx < 0 {
+			break
+		}
+		if _, err := strconv.ParseFloat(line[idx+1:], 64); err != nil {
+			break
+		}
+		line = strings.TrimSpace(line[:idx])
+	}
+	return line
+}
+
+// lessPinned returns whether stack a is placed before stack b, comparing
+// their first different frames by rank if pinned, or else by name.
+func lessPinned(a, b []string, rank map[string]int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		rankA, pinnedA := rank[a[i]]
+		rankB, pinnedB := rank[b[i]]
+		switch {
+		case pinnedA && pinnedB:
+			return rankA < rankB
+		case pinnedA != pinnedB:
+			return pinnedA
+		}
+		return a[i] < b[i]
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinFrames(t *testing.T) {
+	input := []byte(`main.main;worker.Run;worker.process 3
+runtime.gcBgMarkWorker;runtime.gcDrain 2
+main.main;server.Serve;http.HandlerFunc 5
+main.main;init 1
+main.main;server.Serve 4
+`)
+	expected := `main.main;server.Serve 4
+main.main;server.Serve;http.HandlerFunc 5
+main.main;init 1
+main.main;worker.Run;worker.process 3
+runtime.gcBgMarkWorker;runtime.gcDrain 2
+`
+	assert.Equal(t, expected, string(PinFrames(input, []string{"main.main", "server.Serve"})))
+
+	// Without pinned frames, stacks are in alphabetical order.
+	expected = `main.main;init 1
+main.main;server.Serve 4
+main.main;server.Serve;http.HandlerFunc 5
+main.main;worker.Run;worker.process 3
+runtime.gcBgMarkWorker;runtime.gcDrain 2
+`
+	assert.Equal(t, expected, string(PinFrames(input, nil)))
+}
+
+func TestPinFramesDiff(t *testing.T) {
+	input := []byte("main.main;b 1 2\nmain.main;a 3 4\nmain.main;c [depth 2] 5 6\n")
+	expected := "main.main;c [depth 2] 5 6\nmain.main;a 3 4\nmain.main;b 1 2\n"
+	assert.Equal(t, expected, string(PinFrames(input, []string{"c [depth 2]"})))
+}
+
+func TestFlameStack(t *testing.T) {
+	tests := map[string]string{
+		"main.main;main.fib 10":              "main.main;main.fib",
+		"main.main;main.fib 10 12":           "main.main;main.fib",
+		"main.main;main.parse parse.go:42 3": "main.main;main.parse parse.go:42",
+		"main.main;main.fib [depth 25] 1.5":  "main.main;main.fib [depth 25]",
+		"main.main;missing count":            "main.main;missing count",
+	}
+	for line, want := range tests {
+		assert.Equal(t, want, flameStack(line), "flameStack(%q)", line)
+	}
+}
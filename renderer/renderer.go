@@ -21,27 +21,66 @@
 package renderer
 
 import (
-	"bytes"
-	"fmt"
-	"io"
-	"strings"
+	"strconv"
 
 	"github.com/uber/go-torch/stack"
 )
 
+// maxInt64Len is the length of the longest formatted int64,
+// -9223372036854775808.
+const maxInt64Len = 20
+
+// FlameInputOptions are optional parameters for ToFlameInputWithOptions.
+type FlameInputOptions struct {
+	// OnWarning is called for each sample that is skipped.
+	OnWarning stack.WarningFunc
+}
+
 // ToFlameInput converts the given profile to flame graph input.
 func ToFlameInput(profile *stack.Profile, sampleIdx int) ([]byte, error) {
-	buf := &bytes.Buffer{}
+	return ToFlameInputWithOptions(profile, sampleIdx, FlameInputOptions{})
+}
+
+// ToFlameInputWithOptions converts the given profile to flame graph input
+// using the given options.
+func ToFlameInputWithOptions(profile *stack.Profile, sampleIdx int, opts FlameInputOptions) ([]byte, error) {
+	// Profiles can have hundreds of thousands of stacks, so the output is
+	// allocated once, and each line is appended to it without formatting.
+	out := make([]byte, 0, flameInputSize(profile))
+	for _, s := range profile.Samples {
+		if len(s.Funcs) == 0 {
+			opts.OnWarning.Warn(stack.EmptyStack, "", "skipped sample with no frames and value %v", s.Counts[sampleIdx])
+			continue
+		}
+		out = appendSample(out, s.Funcs, s.Counts[sampleIdx])
+	}
+	return out, nil
+}
+
+// flameInputSize returns the most space needed for the flame graph input
+// of profile, for any sample index.
+func flameInputSize(profile *stack.Profile) int {
+	size := 0
 	for _, s := range profile.Samples {
-		if err := renderSample(buf, s, sampleIdx); err != nil {
-			return nil, err
+		// Each function is followed by a ";" or " ", and the count by "\n".
+		for _, f := range s.Funcs {
+			size += len(f) + 1
 		}
+		size += maxInt64Len + 1
 	}
-	return buf.Bytes(), nil
+	return size
 }
 
-// renderSample renders a single stack sample as flame graph input.
-func renderSample(w io.Writer, s *stack.Sample, sampleIdx int) error {
-	_, err := fmt.Fprintf(w, "%s %v\n", strings.Join(s.Funcs, ";"), s.Counts[sampleIdx])
-	return err
+// appendSample appends a line of flame graph input for a single stack,
+// "func1;func2 <count>", to out.
+func appendSample(out []byte, funcs []string, count int64) []byte {
+	for i, f := range funcs {
+		if i > 0 {
+			out = append(out, ';')
+		}
+		out = append(out, f...)
+	}
+	out = append(out, ' ')
+	out = strconv.AppendInt(out, count, 10)
+	return append(out, '\n')
 }
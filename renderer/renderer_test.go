@@ -21,6 +21,8 @@
 package renderer
 
 import (
+	"fmt"
+	"math"
 	"reflect"
 	"testing"
 
@@ -48,3 +50,78 @@ func TestToFlameInput(t *testing.T) {
 		t.Errorf("ToFlameInput failed:\n  got %s\n want %s", out, expected)
 	}
 }
+
+func TestToFlameInputCapacity(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count", "delta/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"func1", "func2"}, Counts: []int64{math.MaxInt64, math.MinInt64}},
+			{Funcs: []string{"func3"}, Counts: []int64{0, -1}},
+		},
+	}
+
+	tests := []struct {
+		sampleIdx int
+		expected  string
+	}{
+		{0, "func1;func2 9223372036854775807\nfunc3 0\n"},
+		{1, "func1;func2 -9223372036854775808\nfunc3 -1\n"},
+	}
+
+	for _, tt := range tests {
+		out, err := ToFlameInput(profile, tt.sampleIdx)
+		if err != nil {
+			t.Fatalf("ToFlameInput failed: %v", err)
+		}
+		if string(out) != tt.expected {
+			t.Errorf("ToFlameInput(%v) failed:\n  got %s\n want %s", tt.sampleIdx, out, tt.expected)
+		}
+		if cap(out) != flameInputSize(profile) {
+			t.Errorf("ToFlameInput(%v) output was reallocated, cap %v, want %v", tt.sampleIdx, cap(out), flameInputSize(profile))
+		}
+	}
+}
+
+func BenchmarkToFlameInput(b *testing.B) {
+	profile := &stack.Profile{SampleNames: []string{"samples/count"}}
+	for i := 0; i < 100000; i++ {
+		funcs := []string{"runtime.goexit", "main.main", "github.com/uber/service/handler.(*Handler).ServeHTTP"}
+		for j := 0; j < i%30; j++ {
+			funcs = append(funcs, fmt.Sprintf("github.com/uber/service/pkg.func%v", j))
+		}
+		profile.Samples = append(profile.Samples, &stack.Sample{Funcs: funcs, Counts: []int64{int64(i)}})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ToFlameInput(profile, 0); err != nil {
+			b.Fatalf("ToFlameInput failed: %v", err)
+		}
+	}
+}
+
+func TestToFlameInputEmptyStack(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"func1"}, Counts: []int64{10}},
+			{Funcs: nil, Counts: []int64{3}},
+		},
+	}
+
+	var warnings []stack.Warning
+	out, err := ToFlameInputWithOptions(profile, 0, FlameInputOptions{
+		OnWarning: func(w stack.Warning) { warnings = append(warnings, w) },
+	})
+	if err != nil {
+		t.Fatalf("ToFlameInputWithOptions failed: %v", err)
+	}
+
+	if expected := "func1 10\n"; string(out) != expected {
+		t.Errorf("ToFlameInputWithOptions failed:\n  got %s\n want %s", out, expected)
+	}
+	if len(warnings) != 1 || warnings[0].Kind != stack.EmptyStack {
+		t.Errorf("expected a single empty stack warning, got %v", warnings)
+	}
+}
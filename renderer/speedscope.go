@@ -0,0 +1,132 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// speedscopeSchema is the schema of the speedscope file format, see
+// https://github.com/jlfwong/speedscope/wiki/Importing-from-custom-sources
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+type speedscopeFile struct {
+	Schema             string              `json:"$schema"`
+	Shared             speedscopeShared    `json:"shared"`
+	Profiles           []speedscopeProfile `json:"profiles"`
+	Name               string              `json:"name,omitempty"`
+	ActiveProfileIndex int                 `json:"activeProfileIndex"`
+	Exporter           string              `json:"exporter"`
+}
+
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+}
+
+type speedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Samples    [][]int `json:"samples"`
+	Weights    []int64 `json:"weights"`
+}
+
+// ToSpeedscope converts the given profile to the speedscope file format, for
+// interactive exploration at https://www.speedscope.app. Each sample type
+// is exported as a separate profile, with the profile for sampleIdx shown
+// when the file is opened.
+func ToSpeedscope(profile *stack.Profile, sampleIdx int, name string) ([]byte, error) {
+	if sampleIdx < 0 || sampleIdx >= len(profile.SampleNames) {
+		return nil, fmt.Errorf("sample index %v is out of range for %v samples", sampleIdx, len(profile.SampleNames))
+	}
+
+	file := &speedscopeFile{
+		Schema:             speedscopeSchema,
+		Name:               name,
+		ActiveProfileIndex: sampleIdx,
+		Exporter:           "go-torch",
+	}
+
+	// Stacks are shared by all of the profiles, and so are their frames.
+	frameIDs := make(map[string]int)
+	stacks := make([][]int, len(profile.Samples))
+	for i, s := range profile.Samples {
+		stack := make([]int, len(s.Funcs))
+		for j, f := range s.Funcs {
+			id, ok := frameIDs[f]
+			if !ok {
+				id = len(file.Shared.Frames)
+				frameIDs[f] = id
+				file.Shared.Frames = append(file.Shared.Frames, speedscopeFrame{Name: f})
+			}
+			stack[j] = id
+		}
+		stacks[i] = stack
+	}
+
+	for idx, sampleName := range profile.SampleNames {
+		p := speedscopeProfile{
+			Type:    "sampled",
+			Name:    sampleName,
+			Unit:    speedscopeUnit(sampleName),
+			Samples: make([][]int, 0, len(stacks)),
+			Weights: make([]int64, 0, len(stacks)),
+		}
+		for i, s := range profile.Samples {
+			// Speedscope cannot display samples without frames, or with no weight.
+			if len(s.Funcs) == 0 || s.Counts[idx] == 0 {
+				continue
+			}
+			p.Samples = append(p.Samples, stacks[i])
+			p.Weights = append(p.Weights, s.Counts[idx])
+			p.EndValue += s.Counts[idx]
+		}
+		file.Profiles = append(file.Profiles, p)
+	}
+
+	return json.Marshal(file)
+}
+
+// speedscopeUnit returns the speedscope unit for a pprof sample name, which
+// is formatted as type/unit, e.g. cpu/nanoseconds.
+func speedscopeUnit(sampleName string) string {
+	parts := strings.SplitN(sampleName, "/", 2)
+	if len(parts) != 2 {
+		return "none"
+	}
+
+	switch parts[1] {
+	case "nanoseconds", "microseconds", "milliseconds", "seconds", "bytes":
+		return parts[1]
+	default:
+		return "none"
+	}
+}
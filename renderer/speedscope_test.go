@@ -0,0 +1,83 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/stack"
+)
+
+func TestToSpeedscope(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count", "cpu/nanoseconds"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main", "foo"}, Counts: []int64{2, 20}},
+			{Funcs: []string{"main", "bar", "foo"}, Counts: []int64{1, 0}},
+			{Funcs: nil, Counts: []int64{3, 30}},
+		},
+	}
+
+	out, err := ToSpeedscope(profile, 1, "test")
+	require.NoError(t, err, "ToSpeedscope failed")
+
+	expected := `{
+		"$schema": "https://www.speedscope.app/file-format-schema.json",
+		"shared": {"frames": [{"name": "main"}, {"name": "foo"}, {"name": "bar"}]},
+		"profiles": [
+			{
+				"type": "sampled", "name": "samples/count", "unit": "none",
+				"startValue": 0, "endValue": 3,
+				"samples": [[0, 1], [0, 2, 1]], "weights": [2, 1]
+			},
+			{
+				"type": "sampled", "name": "cpu/nanoseconds", "unit": "nanoseconds",
+				"startValue": 0, "endValue": 20,
+				"samples": [[0, 1]], "weights": [20]
+			}
+		],
+		"name": "test",
+		"activeProfileIndex": 1,
+		"exporter": "go-torch"
+	}`
+	assert.JSONEq(t, expected, string(out))
+}
+
+func TestToSpeedscopeInvalidSample(t *testing.T) {
+	profile := &stack.Profile{SampleNames: []string{"samples/count"}}
+	_, err := ToSpeedscope(profile, 1, "test")
+	assert.Error(t, err, "expected out of range sample index to fail")
+}
+
+func TestSpeedscopeUnit(t *testing.T) {
+	tests := map[string]string{
+		"samples/count":     "none",
+		"cpu/nanoseconds":   "nanoseconds",
+		"alloc_space/bytes": "bytes",
+		"delay/seconds":     "seconds",
+		"invalid":           "none",
+	}
+	for sampleName, want := range tests {
+		assert.Equal(t, want, speedscopeUnit(sampleName), "speedscopeUnit(%v)", sampleName)
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// svgImage is the rectangles and text of a flame graph generated by
+// flamegraph.pl, which is all that is needed to draw it without a browser.
+type svgImage struct {
+	Width, Height float64
+	Rects         []svgRect
+	Texts         []svgText
+}
+
+type svgRect struct {
+	X, Y, Width, Height float64
+	Fill                color.RGBA
+	// StrokeWidth is the width of the outline, or 0 if there is none.
+	Stroke      color.RGBA
+	StrokeWidth float64
+}
+
+type svgText struct {
+	X, Y     float64
+	FontSize float64
+	// Anchor is the SVG text-anchor: start, middle or end.
+	Anchor string
+	Fill   color.RGBA
+	Text   string
+}
+
+const defaultFontSize = 12
+
+var (
+	black = color.RGBA{0, 0, 0, 0xff}
+	// backgroundFill is used for the gradient background of flame graphs.
+	backgroundFill = color.RGBA{0xee, 0xee, 0xee, 0xff}
+)
+
+// interactiveIDs are the ids of text that is only shown by the flame graph's
+// script, such as the details of the frame under the mouse.
+var interactiveIDs = map[string]bool{
+	"details":    true,
+	"unzoom":     true,
+	"search":     true,
+	"ignorecase": true,
+	"matched":    true,
+}
+
+// parseSVG parses the rectangles and visible text of a flame graph. Other
+// elements, such as the script and the titles shown on hover, are ignored.
+func parseSVG(svg []byte) (*svgImage, error) {
+	img := &svgImage{}
+	decoder := xml.NewDecoder(bytes.NewReader(svg))
+	var text *svgText
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse svg: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			attrs := make(map[string]string, len(t.Attr))
+			for _, attr := range t.Attr {
+				attrs[attr.Name.Local] = attr.Value
+			}
+
+			switch t.Name.Local {
+			case "svg":
+				if img.Width, err = parseLength(attrs["width"]); err != nil {
+					return nil, fmt.Errorf("invalid svg width: %v", err)
+				}
+				if img.Height, err = parseLength(attrs["height"]); err != nil {
+					return nil, fmt.Errorf("invalid svg height: %v", err)
+				}
+				if img.Width <= 0 || img.Height <= 0 {
+					return nil, fmt.Errorf("invalid svg size %vx%v", img.Width, img.Height)
+				}
+			case "rect":
+				if hidden(attrs) || attrs["fill"] == "none" {
+					continue
+				}
+				rect, err := parseRect(attrs)
+				if err != nil {
+					return nil, err
+				}
+				img.Rects = append(img.Rects, rect)
+			case "text":
+				if hidden(attrs) {
+					continue
+				}
+				if text, err = parseText(attrs); err != nil {
+					return nil, err
+				}
+			}
+		case xml.CharData:
+			if text != nil {
+				text.Text += string(t)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "text" && text != nil {
+				if text.Text = strings.TrimSpace(text.Text); text.Text != "" {
+					img.Texts = append(img.Texts, *text)
+				}
+				text = nil
+			}
+		}
+	}
+
+	if img.Width == 0 {
+		return nil, fmt.Errorf("could not parse svg: no svg element")
+	}
+	return img, nil
+}
+
+// hidden returns whether an element is not shown until the user interacts
+// with the flame graph.
+func hidden(attrs map[string]string) bool {
+	if interactiveIDs[attrs["id"]] || attrs["class"] == "hide" {
+		return true
+	}
+	for _, decl := range strings.Split(attrs["style"], ";") {
+		parts := strings.SplitN(decl, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if name == "display" && value == "none" {
+			return true
+		}
+		if opacity, err := strconv.ParseFloat(value, 64); name == "opacity" && err == nil && opacity == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRect(attrs map[string]string) (svgRect, error) {
+	var rect svgRect
+	var err error
+	for _, v := range []struct {
+		name string
+		dest *float64
+	}{
+		{"x", &rect.X},
+		{"y", &rect.Y},
+		{"width", &rect.Width},
+		{"height", &rect.Height},
+	} {
+		if *v.dest, err = parseLength(attrs[v.name]); err != nil {
+			return rect, fmt.Errorf("invalid rect %v: %v", v.name, err)
+		}
+	}
+	if rect.Fill, err = parseColor(attrs["fill"], black); err != nil {
+		return rect, fmt.Errorf("invalid rect fill: %v", err)
+	}
+	if stroke := attrs["stroke"]; stroke != "" && stroke != "none" {
+		if rect.Stroke, err = parseColor(stroke, black); err != nil {
+			return rect, fmt.Errorf("invalid rect stroke: %v", err)
+		}
+		rect.StrokeWidth = 1
+		if width := attrs["stroke-width"]; width != "" {
+			if rect.StrokeWidth, err = parseLength(width); err != nil {
+				return rect, fmt.Errorf("invalid rect stroke-width: %v", err)
+			}
+		}
+	}
+	return rect, nil
+}
+
+func parseText(attrs map[string]string) (*svgText, error) {
+	text := &svgText{FontSize: defaultFontSize, Anchor: attrs["text-anchor"]}
+	var err error
+	if text.X, err = parseLength(attrs["x"]); err != nil {
+		return nil, fmt.Errorf("invalid text x: %v", err)
+	}
+	if text.Y, err = parseLength(attrs["y"]); err != nil {
+		return nil, fmt.Errorf("invalid text y: %v", err)
+	}
+	if size := attrs["font-size"]; size != "" {
+		if text.FontSize, err = parseLength(size); err != nil {
+			return nil, fmt.Errorf("invalid text font-size: %v", err)
+		}
+	}
+	if attrs["id"] == "title" {
+		// Newer versions of flamegraph.pl style the title using CSS.
+		text.Anchor = "middle"
+		if attrs["font-size"] == "" {
+			text.FontSize = 17
+		}
+	}
+	if text.Anchor == "" {
+		text.Anchor = "start"
+	}
+	if text.Fill, err = parseColor(attrs["fill"], black); err != nil {
+		return nil, fmt.Errorf("invalid text fill: %v", err)
+	}
+	return text, nil
+}
+
+// parseLength parses an SVG length in pixels, such as "12" or "12px".
+// An empty length is 0.
+func parseLength(s string) (float64, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "px")
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// parseColor parses the colors used by flamegraph.pl: rgb(r,g,b), #rrggbb,
+// or the url of its background gradient. An empty color is defaultColor.
+func parseColor(s string, defaultColor color.RGBA) (color.RGBA, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return defaultColor, nil
+	case strings.HasPrefix(s, "url("):
+		return backgroundFill, nil
+	case strings.HasPrefix(s, "rgb(") && strings.HasSuffix(s, ")"):
+		parts := strings.Split(s[len("rgb("):len(s)-1], ",")
+		if len(parts) != 3 {
+			return defaultColor, fmt.Errorf("expected 3 components in %q", s)
+		}
+		var rgb [3]uint8
+		for i, part := range parts {
+			v, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8)
+			if err != nil {
+				return defaultColor, fmt.Errorf("invalid color %q: %v", s, err)
+			}
+			rgb[i] = uint8(v)
+		}
+		return color.RGBA{rgb[0], rgb[1], rgb[2], 0xff}, nil
+	case strings.HasPrefix(s, "#") && len(s) == 7:
+		v, err := strconv.ParseUint(s[1:], 16, 32)
+		if err != nil {
+			return defaultColor, fmt.Errorf("invalid color %q: %v", s, err)
+		}
+		return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+	}
+	return defaultColor, fmt.Errorf("unsupported color %q", s)
+}
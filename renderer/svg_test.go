@@ -0,0 +1,177 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package renderer
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSVG is a trimmed flame graph as generated by flamegraph.pl.
+const testSVG = `<?xml version="1.0" standalone="no"?>
+<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">
+<svg version="1.1" width="200" height="66" onload="init(evt)" viewBox="0 0 200 66" xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">
+<defs>
+	<linearGradient id="background" y1="0" y2="1" x1="0" x2="0" >
+		<stop stop-color="#eeeeee" offset="5%" />
+		<stop stop-color="#eeeeb0" offset="95%" />
+	</linearGradient>
+</defs>
+<script type="text/ecmascript">
+<![CDATA[
+	function init(evt) { details = document.getElementById("details").firstChild; }
+]]>
+</script>
+<rect x="0.0" y="0" width="200.0" height="66.0" fill="url(#background)"  />
+<text text-anchor="middle" x="100.00" y="24" font-size="17" font-family="Verdana" fill="rgb(0,0,0)"  >Flame Graph</text>
+<text text-anchor="" x="10.00" y="49" font-size="12" font-family="Verdana" fill="rgb(0,0,0)" id="details" > </text>
+<text text-anchor="" x="10.00" y="24" font-size="12" font-family="Verdana" fill="rgb(0,0,0)" id="unzoom" onclick="unzoom()" style="opacity:0.0;cursor:pointer" >Reset Zoom</text>
+<g class="func_g" onmouseover="s(this)" onmouseout="c()" onclick="zoom(this)">
+<title>main.fib (3 samples, 100.00%)</title><rect x="10.0" y="33" width="180.0" height="15.0" fill="rgb(230,100,40)" rx="2" ry="2" />
+<text text-anchor="" x="13.00" y="43.5" font-size="12" font-family="Verdana" fill="rgb(0,0,0)"  >main.fib &amp; co</text>
+</g>
+</svg>
+`
+
+func TestParseSVG(t *testing.T) {
+	img, err := parseSVG([]byte(testSVG))
+	require.NoError(t, err)
+
+	assert.Equal(t, &svgImage{
+		Width:  200,
+		Height: 66,
+		Rects: []svgRect{
+			{X: 0, Y: 0, Width: 200, Height: 66, Fill: backgroundFill},
+			{X: 10, Y: 33, Width: 180, Height: 15, Fill: color.RGBA{230, 100, 40, 0xff}},
+		},
+		Texts: []svgText{
+			{X: 100, Y: 24, FontSize: 17, Anchor: "middle", Fill: black, Text: "Flame Graph"},
+			{X: 13, Y: 43.5, FontSize: 12, Anchor: "start", Fill: black, Text: "main.fib & co"},
+		},
+	}, img)
+}
+
+func TestParseSVGErrors(t *testing.T) {
+	tests := []struct {
+		svg  string
+		want string
+	}{
+		{"not svg", "no svg element"},
+		{`<svg width="10"`, "could not parse svg"},
+		{`<svg width="0" height="10"></svg>`, "invalid svg size"},
+		{`<svg width="10" height="10"><rect x="a" /></svg>`, "invalid rect x"},
+		{`<svg width="10" height="10"><rect fill="rgb(1,2)" /></svg>`, "invalid rect fill"},
+		{`<svg width="10" height="10"><rect stroke="blue" /></svg>`, "invalid rect stroke"},
+		{`<svg width="10" height="10"><rect stroke="#000000" stroke-width="thick" /></svg>`, "invalid rect stroke-width"},
+		{`<svg width="10" height="10"><text font-size="big">a</text></svg>`, "invalid text font-size"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseSVG([]byte(tt.svg))
+		if assert.Error(t, err, "%v should fail", tt.svg) {
+			assert.Contains(t, err.Error(), tt.want, "%v", tt.svg)
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		s    string
+		want color.RGBA
+	}{
+		{"", black},
+		{"rgb(1, 2, 3)", color.RGBA{1, 2, 3, 0xff}},
+		{"#0a0b0c", color.RGBA{10, 11, 12, 0xff}},
+		{"url(#background)", backgroundFill},
+	}
+	for _, tt := range tests {
+		c, err := parseColor(tt.s, black)
+		if assert.NoError(t, err, "%q", tt.s) {
+			assert.Equal(t, tt.want, c, "%q", tt.s)
+		}
+	}
+
+	for _, s := range []string{"red", "rgb(256,0,0)", "#zzzzzz"} {
+		_, err := parseColor(s, black)
+		assert.Error(t, err, "%q should fail", s)
+	}
+}
+
+func TestSVGToPNG(t *testing.T) {
+	out, err := SVGToPNG([]byte(testSVG))
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	assert.Equal(t, 200, img.Bounds().Dx())
+	assert.Equal(t, 66, img.Bounds().Dy())
+
+	rgba := func(x, y int) color.RGBA {
+		return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+	}
+	assert.Equal(t, backgroundFill, rgba(5, 60), "background")
+	assert.Equal(t, color.RGBA{230, 100, 40, 0xff}, rgba(180, 45), "frame")
+
+	// The first column of "m" is drawn from the text's x, up to its baseline.
+	assert.Equal(t, black, rgba(13, 42), "text")
+	assert.Equal(t, color.RGBA{230, 100, 40, 0xff}, rgba(13, 36), "above text")
+
+	_, err = SVGToPNG([]byte("not svg"))
+	assert.Error(t, err)
+}
+
+func TestSVGToPDF(t *testing.T) {
+	out, err := SVGToPDF([]byte(testSVG))
+	require.NoError(t, err)
+
+	pdf := string(out)
+	assert.Contains(t, pdf, "%PDF-1.4\n")
+	assert.Contains(t, pdf, "/MediaBox [0 0 200.00 66.00]")
+	assert.Contains(t, pdf, "0.902 0.392 0.157 rg 10.00 18.00 180.00 15.00 re f\n")
+	assert.Contains(t, pdf, "(main.fib & co) Tj")
+	assert.NotContains(t, pdf, "Reset Zoom")
+
+	// The xref table must have the offset of each object.
+	xref := strings.Index(pdf, "xref\n")
+	require.True(t, xref > 0, "missing xref")
+	assert.True(t, strings.HasSuffix(pdf, "startxref\n"+strconv.Itoa(xref)+"\n%%EOF\n"), "bad startxref")
+	entries := strings.Split(pdf[xref:], "\n")[3:8]
+	for i, entry := range entries {
+		offset, err := strconv.Atoi(strings.Fields(entry)[0])
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(pdf[offset:], fmt.Sprintf("%v 0 obj\n", i+1)), "object %v", i+1)
+	}
+
+	_, err = SVGToPDF([]byte("not svg"))
+	assert.Error(t, err)
+}
+
+func TestPDFString(t *testing.T) {
+	assert.Equal(t, `f\(x\) \\ caf\351 ?`, pdfString("f(x) \\ café 世"))
+}
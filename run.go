@@ -0,0 +1,250 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/uber/go-torch/pprof"
+	"github.com/uber/go-torch/torchlog"
+
+	gflags "github.com/jessevdk/go-flags"
+)
+
+const (
+	// profilePlaceholder is replaced by the path of the profile file in the
+	// arguments of the run command.
+	profilePlaceholder = "{profile}"
+
+	// runReadyTimeout is how long the run command waits for the command to
+	// serve pprof, and runStopTimeout is how long it waits for the command
+	// to exit after it is interrupted before killing it.
+	runReadyTimeout = 30 * time.Second
+	runStopTimeout  = 5 * time.Second
+)
+
+// runOptions are the options for the run command.
+type runOptions struct {
+	ProfileEnv string `long:"profile-env" description:"Set this environment variable to the path of the profile file, for commands that write a CPU profile to it before exiting"`
+	Args       struct {
+		Command string `positional-arg-name:"command" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+// addRunCommand adds the run command to parser.
+func addRunCommand(parser *gflags.Parser, opts *runOptions) error {
+	_, err := parser.AddCommand("run", "Run a command and profile it",
+		"Run a command and render its profile. If an argument contains "+profilePlaceholder+" or --profile-env is set, the command writes a profile to that file before exiting; "+
+			"otherwise it is profiled for --seconds using the pprof endpoints it serves at --url, and is then interrupted. Arguments after the command are passed to it.", opts)
+	return err
+}
+
+// runCommand runs the command of the run command with remaining as its
+// arguments, and renders its profile.
+func runCommand(allOpts *options, remaining []string) error {
+	pprofOpts := allOpts.PProfOptions
+	if pprofOpts.BinaryFile != "" || pprofOpts.Merge || pprofOpts.BaseURL2 != "" || allOpts.Watch > 0 {
+		return fmt.Errorf("invalid options: the run command cannot be used with --binaryinput, --merge, --base-url2 or --watch")
+	}
+	if allOpts.FoldedInput != "" || allOpts.PerfInput != "" || allOpts.HeapInput != "" || allOpts.DotInput != "" || allOpts.TracebackInput != "" {
+		return fmt.Errorf("invalid options: the run command cannot be used with --folded-input, --perf-input, --heap-input, --dot-input or --traceback-input")
+	}
+
+	command := allOpts.run.Args.Command
+	if allOpts.run.ProfileEnv != "" || hasProfilePlaceholder(remaining) {
+		return runProfileFile(allOpts, command, remaining)
+	}
+	return runPProfServer(allOpts, command, remaining)
+}
+
+// hasProfilePlaceholder returns whether any argument contains
+// profilePlaceholder.
+func hasProfilePlaceholder(args []string) bool {
+	for _, arg := range args {
+		if strings.Contains(arg, profilePlaceholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// newRunCmd returns the command to run. Its output is written to stderr, as
+// stdout may be used for the output.
+func newRunCmd(ctx context.Context, command string, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// runProfileFile runs the command until it exits, and renders the profile
+// that it writes to the file named by its arguments or environment.
+func runProfileFile(allOpts *options, command string, args []string) error {
+	dir, err := ioutil.TempDir("", "go-torch-run")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	profile := filepath.Join(dir, "run.prof")
+	cmdArgs := make([]string, len(args))
+	for i, arg := range args {
+		cmdArgs[i] = strings.Replace(arg, profilePlaceholder, profile, -1)
+	}
+
+	ctx, cancel := newContext(allOpts.Timeout)
+	defer cancel()
+	cmd := newRunCmd(ctx, command, cmdArgs)
+	if env := allOpts.run.ProfileEnv; env != "" {
+		cmd.Env = append(os.Environ(), env+"="+profile)
+	}
+	torchlog.Printf("Run command: %v %v", command, strings.Join(cmdArgs, " "))
+	runErr := cmd.Run()
+	if _, err := os.Stat(profile); err != nil {
+		if runErr != nil {
+			return fmt.Errorf("command failed: %v", runErr)
+		}
+		return fmt.Errorf("command did not write a profile to %v: %v", profilePlaceholder, err)
+	}
+	if runErr != nil {
+		// Commands often exit with an error after writing their profile,
+		// which is still worth rendering.
+		torchlog.Printf("Warning: command failed: %v", runErr)
+	}
+
+	runOpts := *allOpts
+	runOpts.PProfOptions.BinaryFile = profile
+	return runWithOptions(&runOpts, nil)
+}
+
+// runPProfServer starts the command, waits for it to serve pprof at the base
+// URL, profiles it, and then interrupts it.
+func runPProfServer(allOpts *options, command string, args []string) error {
+	network, address, err := dialAddress(allOpts.PProfOptions.BaseURL)
+	if err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+
+	cmd := newRunCmd(context.Background(), command, args)
+	torchlog.Printf("Run command: %v %v", command, strings.Join(args, " "))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not run command: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	defer stopCommand(cmd, exited)
+
+	if err := waitForServer(network, address, runReadyTimeout, exited); err != nil {
+		return err
+	}
+
+	profiled := make(chan error, 1)
+	go func() { profiled <- runWithOptions(allOpts, nil) }()
+	select {
+	case err := <-profiled:
+		return err
+	case err := <-exited:
+		// Put the result back so that stopCommand does not wait for it.
+		exited <- err
+		if err := <-profiled; err != nil {
+			return fmt.Errorf("command exited before it was profiled (%v): %v", exitStatus(err), err)
+		}
+		return nil
+	}
+}
+
+// dialAddress returns the network and address to connect to for baseURL,
+// which is either an HTTP URL or unix://<socket>[:<path>].
+func dialAddress(baseURL string) (string, string, error) {
+	if socket, _, ok := pprof.SplitUnixURL(baseURL); ok {
+		return "unix", socket, nil
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return "", "", fmt.Errorf("invalid --url %q", baseURL)
+	}
+	if u.Port() != "" {
+		return "tcp", u.Host, nil
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return "tcp", net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// waitForServer waits until address accepts connections, failing if the
+// command exits or it does not accept connections within timeout.
+func waitForServer(network, address string, timeout time.Duration, exited chan error) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if conn, err := net.DialTimeout(network, address, time.Second); err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case err := <-exited:
+			exited <- err
+			return fmt.Errorf("command exited (%v) before serving pprof at %v; use %v to profile commands that write a profile file", exitStatus(err), address, profilePlaceholder)
+		case <-deadline:
+			return fmt.Errorf("command did not serve pprof at %v within %v", address, timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// stopCommand interrupts the command if it is still running, and kills it
+// if it does not exit within runStopTimeout.
+func stopCommand(cmd *exec.Cmd, exited chan error) {
+	select {
+	case <-exited:
+		return
+	default:
+	}
+	cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-exited:
+	case <-time.After(runStopTimeout):
+		cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// exitStatus describes the result of cmd.Wait.
+func exitStatus(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeRunScript writes a shell script to dir that runs body, with $PROFILE
+// set to the test pprof profile.
+func writeRunScript(t *testing.T, dir, body string) string {
+	profile, err := filepath.Abs(testPProfInputFile)
+	if err != nil {
+		t.Fatalf("Abs failed: %v", err)
+	}
+	script := filepath.Join(dir, "cmd.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nPROFILE="+profile+"\n"+body+"\n"), 0777); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	return script
+}
+
+func TestRunProfileFile(t *testing.T) {
+	tests := []struct {
+		msg  string
+		body string
+		args func(script string) []string
+	}{
+		{
+			msg:  "placeholder",
+			body: `cp "$PROFILE" "$2"`,
+			args: func(script string) []string { return []string{"--", script, "-cpuprofile", "{profile}"} },
+		},
+		{
+			msg:  "placeholder in a flag",
+			body: `cp "$PROFILE" "${1#-cpuprofile=}"`,
+			args: func(script string) []string { return []string{script, "-cpuprofile={profile}"} },
+		},
+		{
+			msg:  "environment",
+			body: `cp "$PROFILE" "$CPU_PROFILE"`,
+			args: func(script string) []string { return []string{"--profile-env", "CPU_PROFILE", script} },
+		},
+		{
+			msg:  "failed after writing the profile",
+			body: `cp "$PROFILE" "$1"; exit 2`,
+			args: func(script string) []string { return []string{script, "{profile}"} },
+		},
+	}
+
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "go-torch-run-test")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		script := writeRunScript(t, dir, tt.body)
+		rawFile := filepath.Join(dir, "run.folded")
+
+		args := append([]string{"run", "--raw-file", rawFile}, tt.args(script)...)
+		if err := runWithArgs(args...); err != nil {
+			t.Errorf("%v: run failed: %v", tt.msg, err)
+			continue
+		}
+		if raw, err := ioutil.ReadFile(rawFile); err != nil || len(raw) == 0 {
+			t.Errorf("%v: run did not write the raw output: %v", tt.msg, err)
+		}
+	}
+}
+
+func TestRunNoProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-run-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{body: "exit 0", want: "did not write a profile"},
+		{body: "exit 3", want: "command failed: exit status 3"},
+	}
+	for _, tt := range tests {
+		script := writeRunScript(t, dir, tt.body)
+		err := runWithArgs("run", "--raw", script, "{profile}")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("run %q error = %v, want %v", tt.body, err, tt.want)
+		}
+	}
+}
+
+func TestRunExitsBeforeServing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-run-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	script := writeRunScript(t, dir, "exit 3")
+
+	err = runWithArgs("run", "--raw", "--url", "unix://"+filepath.Join(dir, "missing.sock"), script)
+	if err == nil || !strings.Contains(err.Error(), "command exited (exit status 3) before serving pprof") {
+		t.Errorf("run error = %v, want command exited before serving pprof", err)
+	}
+}
+
+func TestRunInvalidOptions(t *testing.T) {
+	tests := [][]string{
+		{"run", "--binaryinput", "cpu.prof", "./app"},
+		{"run", "--merge", "./app"},
+		{"run", "--folded-input", "stacks.folded", "./app"},
+	}
+	for _, args := range tests {
+		err := runWithArgs(args...)
+		if err == nil || !strings.Contains(err.Error(), "invalid options") {
+			t.Errorf("runWithArgs(%v) error = %v, want invalid options", args, err)
+		}
+	}
+}
+
+func TestDialAddress(t *testing.T) {
+	tests := []struct {
+		baseURL          string
+		network, address string
+	}{
+		{"http://localhost:8080", "tcp", "localhost:8080"},
+		{"http://localhost", "tcp", "localhost:80"},
+		{"https://app.internal", "tcp", "app.internal:443"},
+		{"http://[::1]:6060", "tcp", "[::1]:6060"},
+		{"unix:///var/run/app.sock", "unix", "/var/run/app.sock"},
+		{"unix:///var/run/app.sock:/debug/pprof/profile", "unix", "/var/run/app.sock"},
+	}
+	for _, tt := range tests {
+		network, address, err := dialAddress(tt.baseURL)
+		if err != nil || network != tt.network || address != tt.address {
+			t.Errorf("dialAddress(%q) = %v, %v, %v, want %v, %v", tt.baseURL, network, address, err, tt.network, tt.address)
+		}
+	}
+	if _, _, err := dialAddress("localhost:8080/path"); err == nil {
+		t.Errorf("dialAddress should fail for a URL without a host")
+	}
+}
+
+func TestWaitForServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	addr := ln.Addr().String()
+	if err := waitForServer("tcp", addr, time.Second, make(chan error, 1)); err != nil {
+		t.Errorf("waitForServer failed for a listening address: %v", err)
+	}
+
+	ln.Close()
+	err = waitForServer("tcp", addr, 200*time.Millisecond, make(chan error, 1))
+	if err == nil || !strings.Contains(err.Error(), "did not serve pprof") {
+		t.Errorf("waitForServer error = %v, want timeout", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strings"
+
+	gflags "github.com/jessevdk/go-flags"
+)
+
+// scriptExcludedOptions are the options that are not recorded in scripts,
+// as they specify the profile source, how to connect to it, or the script
+// itself rather than how the profile is processed. Headers and credentials
+// are also secrets that should not be written to shared scripts.
+var scriptExcludedOptions = map[string]bool{
+	"BaseURL":     true,
+	"BaseURL2":    true,
+	"BinaryFile":  true,
+	"BinaryName":  true,
+	"FoldedInput": true,
+	"PerfInput":   true,
+	"Headers":     true,
+	"BasicAuth":   true,
+	"Cert":        true,
+	"Key":         true,
+	"CA":          true,
+	"Insecure":    true,
+	"Script":      true,
+	"ApplyScript": true,
+	"Config":      true,
+	"Quiet":       true,
+	"Verbose":     true,
+	"LogJSON":     true,
+	"NoColor":     true,
+}
+
+// writeScript records the options that were set in parser to file, in the
+// ini format used by go-flags.
+func writeScript(parser *gflags.Parser, file string) error {
+	var buf bytes.Buffer
+	gflags.NewIniParser(parser).Write(&buf, gflags.IniNone)
+
+	script := &bytes.Buffer{}
+	script.WriteString("; go-torch script, apply using: go-torch --apply-script <file>\n")
+
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if key := strings.SplitN(line, "=", 2)[0]; scriptExcludedOptions[strings.TrimSpace(key)] {
+			continue
+		}
+		script.WriteString(line + "\n")
+	}
+
+	return ioutil.WriteFile(file, script.Bytes(), 0666)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestScriptRoundTrip(t *testing.T) {
+	script := getTempFilename(t, ".torchscript")
+	defer os.Remove(script)
+
+	err := runWithArgs("--raw", "--binaryinput", testPProfInputFile, "--title", "Recorded",
+		"--width", "800", "--header", "X-Token: secret", "--basic-auth", "user:password", "--script", script)
+	if err != nil {
+		t.Fatalf("Run with --script failed: %v", err)
+	}
+
+	contents, err := ioutil.ReadFile(script)
+	if err != nil {
+		t.Fatalf("Failed to read script: %v", err)
+	}
+	for _, want := range []string{"Title = Recorded", "Width = 800", "Raw = true"} {
+		if !strings.Contains(string(contents), want) {
+			t.Errorf("Script is missing %q, got:\n%s", want, contents)
+		}
+	}
+	for _, excluded := range []string{"BinaryFile", "Script", "secret", "password"} {
+		if strings.Contains(string(contents), excluded) {
+			t.Errorf("Script should not contain %v, got:\n%s", excluded, contents)
+		}
+	}
+
+	opts, _, _, err := parseArgs([]string{"--width", "600", "--apply-script", script}, "", script)
+	if err != nil {
+		t.Fatalf("parseArgs with script failed: %v", err)
+	}
+	if opts.OutputOpts.Title != "Recorded" {
+		t.Errorf("Script title was not applied, got %q", opts.OutputOpts.Title)
+	}
+	if opts.OutputOpts.Width != 600 {
+		t.Errorf("Command line width should override script, got %v", opts.OutputOpts.Width)
+	}
+
+	if err := runWithArgs("--binaryinput", testPProfInputFile, "--apply-script", script); err != nil {
+		t.Errorf("Run with --apply-script failed: %v", err)
+	}
+}
+
+func TestApplyMissingScript(t *testing.T) {
+	err := runWithArgs("--apply-script", "/dev/zero/invalid/file")
+	if err == nil || !strings.Contains(err.Error(), "could not apply script") {
+		t.Errorf("Expected missing script to fail, got %v", err)
+	}
+}
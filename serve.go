@@ -0,0 +1,371 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/uber/go-torch/torch"
+	"github.com/uber/go-torch/torchlog"
+
+	gflags "github.com/jessevdk/go-flags"
+)
+
+// serveResultsPath is the path that the outputs of events requests are
+// served under.
+const serveResultsPath = "/results/"
+
+// maxServeResults is the number of outputs of events requests that are kept.
+const maxServeResults = 10
+
+// servePage shows the progress of a profile, using the events at its URL,
+// and then the flame graph.
+var servePage = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><title>go-torch</title></head>
+<body>
+<p id="status">Connecting</p>
+<object id="graph" type="image/svg+xml" style="width: 100%"></object>
+<script>
+var message = document.getElementById("status");
+var events = new EventSource({{.}});
+events.addEventListener("progress", function(e) {
+	message.textContent = JSON.parse(e.data).message;
+});
+events.addEventListener("done", function(e) {
+	events.close();
+	message.textContent = "";
+	document.getElementById("graph").data = JSON.parse(e.data).url;
+});
+events.addEventListener("failed", function(e) {
+	events.close();
+	message.textContent = "Failed: " + JSON.parse(e.data).error;
+});
+events.onerror = function() {
+	events.close();
+	message.textContent = "Lost the connection to go-torch";
+};
+</script>
+</body>
+</html>
+`))
+
+// serveOptions are the options for the serve command.
+type serveOptions struct {
+	Listen        string `long:"listen" default:"localhost:9092" description:"Address to serve flame graphs on"`
+	MaxSeconds    int    `long:"max-seconds" default:"300" description:"Maximum number of seconds that a request can profile for"`
+	RenderWorkers int    `long:"render-workers" default:"0" description:"Maximum number of flame graphs to render at once, while other requests profile the program (default: the number of CPUs)"`
+}
+
+// server profiles the target each time a flame graph is requested.
+type server struct {
+	opts      *options
+	remaining []string
+
+	// profiling holds a token while a profile is fetched, so requests
+	// profile the target one at a time: Go programs only allow one CPU
+	// profile at once.
+	profiling chan struct{}
+	// rendering holds a token for each flame graph that is rendered, to
+	// bound the number of flame graph scripts that run at once.
+	rendering chan struct{}
+
+	mu sync.Mutex
+	// results are the outputs of events requests by ID, and resultIDs are
+	// their IDs, oldest first.
+	results   map[string]renderResult
+	resultIDs []string
+	lastID    int
+}
+
+// addServeCommand adds the serve command to parser.
+func addServeCommand(parser *gflags.Parser, opts *serveOptions) error {
+	_, err := parser.AddCommand("serve", "Serve flame graphs of a program on request",
+		"Serve a flame graph of the profile source at /flamegraph on --listen, profiling it for --seconds, or the seconds parameter, each time the flame graph is requested. The page at / shows the progress of the profile, streamed from /events, and then the flame graph.", opts)
+	return err
+}
+
+// runServe serves flame graphs until a value is received on stop.
+func runServe(allOpts *options, remaining []string, stop <-chan os.Signal) error {
+	if err := validateServe(allOpts); err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+
+	s := newServer(allOpts, remaining)
+	ln, err := net.Listen("tcp", allOpts.serve.Listen)
+	if err != nil {
+		return fmt.Errorf("could not listen for requests: %v", err)
+	}
+	srv := &http.Server{Handler: s.handler()}
+	go srv.Serve(ln)
+	defer srv.Close()
+	torchlog.Printf("Serving flame graphs on http://%v/", ln.Addr())
+
+	<-stop
+	torchlog.Print("Stopped serving flame graphs")
+	return nil
+}
+
+// validateServe returns an error if the options cannot be used to serve
+// flame graphs, as they write to stdout, or do not fetch a new profile for
+// each request.
+func validateServe(allOpts *options) error {
+	opts := allOpts.OutputOpts
+	if opts.Print || opts.Raw || opts.RawFile != "" || opts.OutDir != "" || opts.AllSamples || printsReport(opts) || allOpts.Watch > 0 || allOpts.TargetSamples > 0 {
+		return fmt.Errorf("the serve command cannot be used with --print, --raw, --raw-file, --out-dir, --all-samples, --top, --cost-by, --watch or --target-samples")
+	}
+	if allOpts.PProfOptions.BinaryFile != "" {
+		return fmt.Errorf("the serve command cannot be used with --binaryinput")
+	}
+	if allOpts.FoldedInput != "" || allOpts.PerfInput != "" || allOpts.HeapInput != "" || allOpts.DotInput != "" || allOpts.TracebackInput != "" {
+		return fmt.Errorf("the serve command cannot be used with --folded-input, --perf-input, --heap-input, --dot-input or --traceback-input")
+	}
+	if allOpts.serve.MaxSeconds < allOpts.PProfOptions.TimeSeconds {
+		return fmt.Errorf("--max-seconds cannot be less than --seconds")
+	}
+	if allOpts.serve.RenderWorkers < 0 {
+		return fmt.Errorf("--render-workers cannot be negative")
+	}
+	return nil
+}
+
+// renderWorkers returns the number of flame graphs to render at once for
+// --render-workers.
+func renderWorkers(n int) int {
+	if n == 0 {
+		return runtime.NumCPU()
+	}
+	return n
+}
+
+func newServer(allOpts *options, remaining []string) *server {
+	return &server{
+		opts:      allOpts,
+		remaining: remaining,
+		profiling: make(chan struct{}, 1),
+		rendering: make(chan struct{}, renderWorkers(allOpts.serve.RenderWorkers)),
+		results:   make(map[string]renderResult),
+	}
+}
+
+// handler returns the handler that serves flame graphs.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flamegraph", s.serveFlameGraph)
+	mux.HandleFunc("/events", s.serveEvents)
+	mux.HandleFunc(serveResultsPath, s.serveResult)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := servePage.Execute(w, "/events?"+r.URL.RawQuery); err != nil {
+			torchlog.Printf("Failed to serve the page: %v", err)
+		}
+	})
+	return mux
+}
+
+// serveFlameGraph profiles the target, and serves the output in the
+// requested format.
+func (s *server) serveFlameGraph(w http.ResponseWriter, r *http.Request) {
+	reqOpts, err := s.requestOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.render(r.Context(), reqOpts)
+	if err != nil {
+		torchlog.Printf("Failed to serve flame graph: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", res.contentType)
+	w.Write(res.output)
+}
+
+// serveEvents profiles the target, and streams its progress as server-sent
+// events, so a page can show each stage rather than a blank page until the
+// profile is captured. A progress event is sent as each stage starts, then
+// a done event with the URL of the output, or a failed event.
+func (s *server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	reqOpts, err := s.requestOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	send := func(event string, data interface{}) {
+		encoded, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event, encoded)
+		flusher.Flush()
+	}
+
+	// Generate reports progress from the goroutine that calls it.
+	reqOpts.onProgress = func(p torch.Progress) {
+		send("progress", serveProgress{Stage: p.Stage.String(), Message: progressMessage(reqOpts, p)})
+	}
+	res, err := s.render(r.Context(), reqOpts)
+	if err != nil {
+		torchlog.Printf("Failed to serve flame graph: %v", err)
+		send("failed", map[string]string{"error": err.Error()})
+		return
+	}
+	send("done", map[string]string{"url": serveResultsPath + s.addResult(res)})
+}
+
+// serveProgress is the data of a progress event.
+type serveProgress struct {
+	Stage   string `json:"stage"`
+	Message string `json:"message"`
+}
+
+// progressMessage describes the progress of a profile for the page.
+func progressMessage(opts *options, p torch.Progress) string {
+	stats := p.Stats
+	switch p.Stage {
+	case torch.StageFetch:
+		return fmt.Sprintf("Fetching profile for %v seconds", opts.PProfOptions.TimeSeconds)
+	case torch.StageParse:
+		return fmt.Sprintf("Parsing %v bytes of pprof output, fetched in %v", stats.RawBytes, stats.FetchDuration)
+	case torch.StageRender:
+		return fmt.Sprintf("Rendering %v stacks with %v %v", stats.Stacks, stats.SampleTotal, stats.SampleName)
+	}
+	return fmt.Sprintf("Finishing %v", opts.OutputOpts.OutFormat)
+}
+
+// renderResult is the output of a request, and its content type.
+type renderResult struct {
+	output      []byte
+	contentType string
+}
+
+// render profiles the target using reqOpts, waiting for other requests to
+// finish first.
+func (s *server) render(ctx context.Context, reqOpts *options) (renderResult, error) {
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+		defer cancel()
+	}
+
+	// The target is only profiled by one request at a time, but the
+	// flame graphs of profiles that have been fetched are rendered while
+	// the next request profiles the target.
+	if err := acquire(ctx, s.profiling); err != nil {
+		return renderResult{}, err
+	}
+	result, err := generateResult(ctx, reqOpts, s.remaining)
+	<-s.profiling
+	if err != nil {
+		return renderResult{}, err
+	}
+
+	if err := acquire(ctx, s.rendering); err != nil {
+		return renderResult{}, err
+	}
+	output, err := renderGenerated(reqOpts, result)
+	<-s.rendering
+	if err != nil {
+		return renderResult{}, err
+	}
+	contentType := mime.TypeByExtension("." + outputExt(reqOpts.OutputOpts.OutFormat))
+	if contentType == "" {
+		contentType = http.DetectContentType(output)
+	}
+	return renderResult{output: output, contentType: contentType}, nil
+}
+
+// acquire waits for a token from tokens, unless ctx is done first.
+func acquire(ctx context.Context, tokens chan struct{}) error {
+	select {
+	case tokens <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// addResult stores the result of an events request so the page can load
+// it, and returns its ID. Only the last maxServeResults are kept.
+func (s *server) addResult(res renderResult) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	id := strconv.Itoa(s.lastID)
+	s.results[id] = res
+	s.resultIDs = append(s.resultIDs, id)
+	if len(s.resultIDs) > maxServeResults {
+		delete(s.results, s.resultIDs[0])
+		s.resultIDs = s.resultIDs[1:]
+	}
+	return id
+}
+
+// serveResult serves a stored result, at /results/<id>.
+func (s *server) serveResult(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	res, ok := s.results[strings.TrimPrefix(r.URL.Path, serveResultsPath)]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", res.contentType)
+	w.Write(res.output)
+}
+
+// requestOptions returns the options for a request, which can set how many
+// seconds to profile for using the seconds parameter.
+func (s *server) requestOptions(r *http.Request) (*options, error) {
+	reqOpts := *s.opts
+	param := r.URL.Query().Get("seconds")
+	if param == "" {
+		return &reqOpts, nil
+	}
+	seconds, err := strconv.Atoi(param)
+	if err != nil || seconds < 1 || seconds > s.opts.serve.MaxSeconds {
+		return nil, fmt.Errorf("seconds must be an integer from 1 to %v", s.opts.serve.MaxSeconds)
+	}
+	reqOpts.PProfOptions.TimeSeconds = seconds
+	reqOpts.PProfOptions.TimeAlias = nil
+	return &reqOpts, nil
+}
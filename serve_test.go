@@ -0,0 +1,265 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, args ...string) *server {
+	opts, _, remaining, err := parseArgs(append([]string{"serve"}, args...), "", "")
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	if err := validateServe(opts); err != nil {
+		t.Fatalf("validateServe failed: %v", err)
+	}
+	return newServer(opts, remaining)
+}
+
+func TestServeFlameGraph(t *testing.T) {
+	profile, err := ioutil.ReadFile(testPProfInputFile)
+	if err != nil {
+		t.Fatalf("Failed to read test profile: %v", err)
+	}
+	var mu sync.Mutex
+	var seconds []string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seconds = append(seconds, r.URL.Query().Get("seconds"))
+		mu.Unlock()
+		w.Write(profile)
+	}))
+	defer target.Close()
+
+	s := newTestServer(t, "-u", target.URL, "-t", "1")
+	withScriptsInPath(t, func() {
+		for _, path := range []string{"/flamegraph", "/flamegraph?seconds=5"} {
+			w := httptest.NewRecorder()
+			s.handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %v returned %v: %s", path, w.Code, w.Body)
+			}
+			if got := w.Header().Get("Content-Type"); got != "image/svg+xml" {
+				t.Errorf("GET %v Content-Type = %q, want image/svg+xml", path, got)
+			}
+			if !strings.Contains(w.Body.String(), "flamegraph.pl") {
+				t.Errorf("GET %v did not render a flame graph: %s", path, w.Body)
+			}
+		}
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seconds) != 2 || seconds[0] != "1" || seconds[1] != "5" {
+		t.Errorf("target was profiled for %v seconds, want [1 5]", seconds)
+	}
+}
+
+func TestServeRendersWhileProfiling(t *testing.T) {
+	profile, err := ioutil.ReadFile(testPProfInputFile)
+	if err != nil {
+		t.Fatalf("Failed to read test profile: %v", err)
+	}
+	profiled := make(chan struct{}, 2)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(profile)
+		profiled <- struct{}{}
+	}))
+	defer target.Close()
+
+	s := newTestServer(t, "-u", target.URL, "-t", "1", "--render-workers", "1")
+	if cap(s.rendering) != 1 {
+		t.Fatalf("rendering pool has %v workers, want 1", cap(s.rendering))
+	}
+	// Block rendering, so requests wait for a render worker once they have
+	// profiled the target.
+	s.rendering <- struct{}{}
+
+	withScriptsInPath(t, func() {
+		codes := make(chan int, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				w := httptest.NewRecorder()
+				s.handler().ServeHTTP(w, httptest.NewRequest("GET", "/flamegraph", nil))
+				codes <- w.Code
+			}()
+		}
+
+		// Both requests profile the target while neither can render.
+		for i := 0; i < 2; i++ {
+			select {
+			case <-profiled:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("request %v did not profile the target while another was waiting to render", i)
+			}
+		}
+
+		<-s.rendering
+		for i := 0; i < 2; i++ {
+			if code := <-codes; code != http.StatusOK {
+				t.Errorf("request returned %v, want %v", code, http.StatusOK)
+			}
+		}
+	})
+}
+
+func TestServeEvents(t *testing.T) {
+	server := newProfileServer(t)
+	defer server.Close()
+
+	s := newTestServer(t, "-u", server.URL, "-t", "1")
+	w := httptest.NewRecorder()
+	withScriptsInPath(t, func() {
+		s.handler().ServeHTTP(w, httptest.NewRequest("GET", "/events?seconds=2", nil))
+	})
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	var events []string
+	var done map[string]string
+	for _, block := range strings.Split(strings.TrimSpace(w.Body.String()), "\n\n") {
+		lines := strings.SplitN(block, "\n", 2)
+		if len(lines) != 2 || !strings.HasPrefix(lines[1], "data: ") {
+			t.Fatalf("invalid event %q", block)
+		}
+		event, data := strings.TrimPrefix(lines[0], "event: "), strings.TrimPrefix(lines[1], "data: ")
+		if event == "progress" {
+			var p serveProgress
+			if err := json.Unmarshal([]byte(data), &p); err != nil {
+				t.Fatalf("invalid progress event %q: %v", data, err)
+			}
+			event += " " + p.Stage
+		}
+		if event == "done" {
+			if err := json.Unmarshal([]byte(data), &done); err != nil {
+				t.Fatalf("invalid done event %q: %v", data, err)
+			}
+		}
+		events = append(events, event)
+	}
+	want := []string{"progress fetch", "progress parse", "progress render", "progress done", "done"}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	if !strings.Contains(w.Body.String(), "Fetching profile for 2 seconds") {
+		t.Errorf("fetch progress does not use the seconds parameter: %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	s.handler().ServeHTTP(w, httptest.NewRequest("GET", done["url"], nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "flamegraph.pl") {
+		t.Errorf("GET %v returned %v: %s", done["url"], w.Code, w.Body)
+	}
+}
+
+func TestServeEventsFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "profiling is disabled", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	s := newTestServer(t, "-u", server.URL, "-t", "1")
+	w := httptest.NewRecorder()
+	s.handler().ServeHTTP(w, httptest.NewRequest("GET", "/events", nil))
+	if !strings.Contains(w.Body.String(), "event: failed\ndata: {\"error\":") {
+		t.Errorf("events did not report the failure: %s", w.Body)
+	}
+}
+
+func TestServePage(t *testing.T) {
+	s := newTestServer(t, "-u", "http://localhost:1")
+	w := httptest.NewRecorder()
+	s.handler().ServeHTTP(w, httptest.NewRequest("GET", "/?seconds=5", nil))
+	if body := w.Body.String(); !strings.Contains(body, "new EventSource(") || !strings.Contains(body, "events?seconds=5\")") {
+		t.Errorf("page does not stream events for its parameters: %s", w.Body)
+	}
+}
+
+func TestServeResults(t *testing.T) {
+	s := newTestServer(t, "-u", "http://localhost:1")
+	var ids []string
+	for i := 0; i <= maxServeResults; i++ {
+		ids = append(ids, s.addResult(renderResult{output: []byte("<svg/>"), contentType: "image/svg+xml"}))
+	}
+
+	tests := []struct {
+		id   string
+		code int
+	}{
+		{id: ids[0], code: http.StatusNotFound},
+		{id: ids[1], code: http.StatusOK},
+		{id: ids[maxServeResults], code: http.StatusOK},
+		{id: "unknown", code: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.handler().ServeHTTP(w, httptest.NewRequest("GET", serveResultsPath+tt.id, nil))
+		if w.Code != tt.code {
+			t.Errorf("GET result %v returned %v, want %v", tt.id, w.Code, tt.code)
+		}
+	}
+}
+
+func TestServeBadRequest(t *testing.T) {
+	s := newTestServer(t, "-u", "http://localhost:1", "-t", "1", "--max-seconds", "60")
+	for _, path := range []string{"/flamegraph?seconds=0", "/flamegraph?seconds=61", "/flamegraph?seconds=ten", "/events?seconds=0"} {
+		w := httptest.NewRecorder()
+		s.handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET %v returned %v, want %v", path, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.handler().ServeHTTP(w, httptest.NewRequest("GET", "/other", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /other returned %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestServeInvalidOptions(t *testing.T) {
+	tests := [][]string{
+		{"serve", "--print"},
+		{"serve", "--raw"},
+		{"serve", "--binaryinput", testPProfInputFile},
+		{"serve", "--folded-input", "stacks.txt"},
+		{"serve", "-t", "30", "--max-seconds", "10"},
+		{"serve", "--render-workers", "-1"},
+	}
+	withScriptsInPath(t, func() {
+		for _, args := range tests {
+			err := runWithArgs(args...)
+			if err == nil || !strings.Contains(err.Error(), "invalid options") {
+				t.Errorf("runWithArgs(%v) error = %v, want invalid options", args, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
+	"github.com/uber/go-torch/torchlog"
+)
+
+const (
+	// snippetLines is the number of source lines shown for a frame by
+	// --source-root, around the frame's line, or from the declaration of
+	// the frame's function.
+	snippetLines = 5
+
+	// maxSnippetWidth is the number of characters shown of each line.
+	maxSnippetWidth = 100
+)
+
+// sourceSnippets returns a note with the source lines of each function in
+// the result's profile, or of its line for frames named at line granularity,
+// e.g. main.parse parse.go:42, from the Go packages in dir.
+func sourceSnippets(dir string, result *torch.Result) (map[string]string, error) {
+	funcs, err := goSourceFuncs(dir)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := resultProfile(result)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]string)
+	notes := make(map[string]string)
+	for _, t := range stack.Top(profile, result.SampleIndex) {
+		fn, base, line := splitFrameLine(t.Func)
+		pos, found, _ := funcs.lookup(fn)
+		if !found || pos.file == "" {
+			continue
+		}
+		start := pos.line
+		if base != "" {
+			if filepath.Base(pos.file) != base {
+				// The line is in another file, such as a function that
+				// was inlined into fn.
+				continue
+			}
+			pos.line, start = line, line-snippetLines/2
+		}
+
+		lines, ok := files[pos.file]
+		if !ok {
+			if src, err := ioutil.ReadFile(pos.file); err != nil {
+				torchlog.Printf("Warning: could not read source snippets from %v: %v", pos.file, err)
+			} else {
+				lines = strings.Split(string(src), "\n")
+			}
+			files[pos.file] = lines
+		}
+		if snippet := sourceSnippet(lines, start, pos.line); snippet != "" {
+			rel, err := filepath.Rel(dir, pos.file)
+			if err != nil {
+				rel = pos.file
+			}
+			notes[t.Func] = fmt.Sprintf("%v:%v\n%v", filepath.ToSlash(rel), pos.line, snippet)
+		}
+	}
+	return notes, nil
+}
+
+// splitFrameLine splits a frame named at line granularity, such as
+// main.parse parse.go:42, into its function, file and line. Other frames
+// are returned as the function, with no file.
+func splitFrameLine(frame string) (fn, file string, line int) {
+	idx := strings.LastIndex(frame, " ")
+	if idx < 0 {
+		return frame, "", 0
+	}
+	colon := strings.LastIndex(frame, ":")
+	if colon < idx {
+		return frame, "", 0
+	}
+	line, err := strconv.Atoi(frame[colon+1:])
+	if err != nil {
+		return frame, "", 0
+	}
+	return frame[:idx], frame[idx+1 : colon], line
+}
+
+// sourceSnippet returns snippetLines lines from the 1-based line start,
+// with their line numbers, and marks the given line.
+func sourceSnippet(lines []string, start, line int) string {
+	if start < 1 {
+		start = 1
+	}
+	var buf bytes.Buffer
+	for n := start; n < start+snippetLines && n <= len(lines); n++ {
+		marker := " "
+		if n == line {
+			marker = ">"
+		}
+		text := []rune(strings.Replace(strings.TrimRight(lines[n-1], " \t\r"), "\t", "    ", -1))
+		if len(text) > maxSnippetWidth {
+			text = append(text[:maxSnippetWidth], []rune("...")...)
+		}
+		fmt.Fprintf(&buf, "%v%5d  %v\n", marker, n, string(text))
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
+)
+
+func TestSplitFrameLine(t *testing.T) {
+	tests := []struct {
+		frame, fn, file string
+		line            int
+	}{
+		{"main.parse", "main.parse", "", 0},
+		{"main.parse parse.go:42", "main.parse", "parse.go", 42},
+		{"main.(*T).M.func1 t.go:7", "main.(*T).M.func1", "t.go", 7},
+		{"main.parse parse.go:x", "main.parse parse.go:x", "", 0},
+		{"pkg:1 main.parse", "pkg:1 main.parse", "", 0},
+	}
+	for _, tt := range tests {
+		fn, file, line := splitFrameLine(tt.frame)
+		if fn != tt.fn || file != tt.file || line != tt.line {
+			t.Errorf("splitFrameLine(%q) = %q, %q, %v, want %q, %q, %v", tt.frame, fn, file, line, tt.fn, tt.file, tt.line)
+		}
+	}
+}
+
+func TestSourceSnippet(t *testing.T) {
+	lines := []string{"package main", "", "func main() {", "\tx := 1", "\t_ = x", "}", ""}
+	want := strings.Join([]string{
+		"     2  ",
+		"     3  func main() {",
+		">    4      x := 1",
+		"     5      _ = x",
+		"     6  }",
+	}, "\n")
+	if got := sourceSnippet(lines, 2, 4); got != want {
+		t.Errorf("sourceSnippet got:\n%v\nwant:\n%v", got, want)
+	}
+
+	if got := sourceSnippet(lines, -1, 1); !strings.HasPrefix(got, ">    1  package main\n") {
+		t.Errorf("sourceSnippet before the first line got:\n%v", got)
+	}
+	if got := sourceSnippet(lines, 10, 10); got != "" {
+		t.Errorf("sourceSnippet after the last line got %q, want none", got)
+	}
+
+	long := []string{strings.Repeat("x", maxSnippetWidth+10)}
+	if got := sourceSnippet(long, 1, 1); got != ">    1  "+strings.Repeat("x", maxSnippetWidth)+"..." {
+		t.Errorf("sourceSnippet should truncate long lines, got %q", got)
+	}
+}
+
+func TestSourceSnippets(t *testing.T) {
+	dir := newSourceTree(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {\n\trun()\n}\n\nfunc run() {\n\tfor {\n\t}\n}\n",
+	})
+	defer os.RemoveAll(dir)
+
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main.main", "main.run main.go:8"}, Counts: []int64{3}},
+			{Funcs: []string{"main.main", "main.run other.go:8", "runtime.mallocgc"}, Counts: []int64{1}},
+		},
+	}
+	notes, err := sourceSnippets(dir, &torch.Result{Profile: profile})
+	if err != nil {
+		t.Fatalf("sourceSnippets failed: %v", err)
+	}
+
+	want := map[string]string{
+		"main.main": "main.go:3\n" +
+			">    3  func main() {\n" +
+			"     4      run()\n" +
+			"     5  }\n" +
+			"     6  \n" +
+			"     7  func run() {",
+		"main.run main.go:8": "main.go:8\n" +
+			"     6  \n" +
+			"     7  func run() {\n" +
+			">    8      for {\n" +
+			"     9      }\n" +
+			"    10  }",
+	}
+	if !reflect.DeepEqual(notes, want) {
+		t.Errorf("sourceSnippets got %q, want %q", notes, want)
+	}
+}
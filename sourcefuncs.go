@@ -0,0 +1,285 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
+	"github.com/uber/go-torch/torchlog"
+)
+
+// maxStaleListed is the number of functions listed in the --check-source
+// warning, which are the functions with the most samples.
+const maxStaleListed = 10
+
+// moduleRE matches the module directive of a go.mod file.
+var moduleRE = regexp.MustCompile(`(?m)^module\s+"?([^\s"]+)"?`)
+
+// sourceFuncs are the functions declared in the Go packages of a source
+// tree, by the import path of their package, named as in profiles.
+type sourceFuncs struct {
+	dir        string
+	importPath string
+	pkgs       map[string]map[string]sourcePos
+}
+
+// sourcePos is the file and line of a function's declaration. It is empty
+// for functions generated by the compiler.
+type sourcePos struct {
+	file string
+	line int
+}
+
+// goSourceFuncs parses the Go packages in dir and its subdirectories, other
+// than vendor and testdata directories and nested modules. Build tags are
+// ignored, so functions for other platforms are included.
+func goSourceFuncs(dir string) (*sourceFuncs, error) {
+	importPath, err := sourceImportPath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := &sourceFuncs{dir: dir, importPath: importPath, pkgs: make(map[string]map[string]sourcePos)}
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if rel == "." {
+				return nil
+			}
+			name := info.Name()
+			if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(file, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(file, ".go") || strings.HasSuffix(file, "_test.go") {
+			return nil
+		}
+
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			torchlog.Printf("Warning: skipping %v, which could not be parsed: %v", file, err)
+			return nil
+		}
+		pkg := path.Join(importPath, filepath.ToSlash(filepath.Dir(rel)))
+		funcs.add(pkg, fset, f)
+		if f.Name.Name == "main" {
+			// Functions of main packages are named main in profiles.
+			funcs.add("main", fset, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return funcs, nil
+}
+
+func (s *sourceFuncs) add(pkg string, fset *token.FileSet, f *ast.File) {
+	if s.pkgs[pkg] == nil {
+		s.pkgs[pkg] = make(map[string]sourcePos)
+	}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		name := funcDeclName(fn)
+		if _, ok := s.pkgs[pkg][name]; ok {
+			// Keep the first of the main functions with the same name.
+			continue
+		}
+		pos := fset.Position(fn.Pos())
+		s.pkgs[pkg][name] = sourcePos{file: pos.Filename, line: pos.Line}
+	}
+}
+
+// sourceImportPath returns the import path of the package in dir, from the
+// module path in its go.mod, or its path in the GOPATH.
+func sourceImportPath(dir string) (string, error) {
+	if goMod, err := ioutil.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+		if m := moduleRE.FindSubmatch(goMod); m != nil {
+			return string(m[1]), nil
+		}
+		return "", fmt.Errorf("no module directive in %v", filepath.Join(dir, "go.mod"))
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+		src := filepath.Join(gopath, "src")
+		if rel, err := filepath.Rel(src, abs); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel), nil
+		}
+	}
+	return "", fmt.Errorf("%v has no go.mod and is not in the GOPATH", dir)
+}
+
+// stale returns whether fn, named as in a profile, is in a package of the
+// source tree, but is not declared in it, or its package no longer exists.
+func (s *sourceFuncs) stale(fn string) bool {
+	_, found, inTree := s.lookup(fn)
+	return inTree && !found
+}
+
+// lookup returns the declaration of fn, named as in a profile, whether it
+// was found, and whether fn is in a package of the source tree. Closures
+// are found at the declaration of the function they are in.
+func (s *sourceFuncs) lookup(fn string) (pos sourcePos, found, inTree bool) {
+	pkg := stack.FuncPackage(fn)
+	if pkg == "" || strings.Contains(pkg, "/vendor/") {
+		return sourcePos{}, false, false
+	}
+	funcs, ok := s.pkgs[pkg]
+	if pkg == "main" {
+		if !ok {
+			// There are no main packages in the source tree.
+			return sourcePos{}, false, false
+		}
+	} else if pkg != s.importPath && !strings.HasPrefix(pkg, s.importPath+"/") {
+		return sourcePos{}, false, false
+	}
+	if !ok {
+		return sourcePos{}, false, true
+	}
+
+	name := strings.TrimSuffix(trimClosures(fn[len(pkg)+1:]), "-fm")
+	if name == "init" || strings.HasPrefix(name, "init.") || strings.HasPrefix(name, "glob.") || strings.HasPrefix(name, "type.") {
+		// Functions generated by the compiler for package initialization,
+		// closures of package variables and type equality.
+		return sourcePos{}, true, true
+	}
+	name = strings.TrimSuffix(name, "[...]")
+	if pos, ok := funcs[name]; ok {
+		return pos, true, true
+	}
+	if strings.HasPrefix(name, "(*") {
+		// Methods with value receivers are also called through pointers.
+		if idx := strings.Index(name, ")."); idx > 0 {
+			if pos, ok := funcs[name[2:idx]+name[idx+1:]]; ok {
+				return pos, true, true
+			}
+		}
+	}
+	return sourcePos{}, false, true
+}
+
+// trimClosures removes the suffixes of closures from a function name, which
+// are named after the function they are in with a .funcN suffix, and may be
+// nested, e.g. main.run.func1.2.
+func trimClosures(fn string) string {
+	for {
+		idx := strings.LastIndex(fn, ".")
+		if idx < 0 {
+			return fn
+		}
+		suffix := fn[idx+1:]
+		if _, err := strconv.Atoi(strings.TrimPrefix(suffix, "func")); err != nil || suffix == "" {
+			return fn
+		}
+		fn = fn[:idx]
+	}
+}
+
+// checkSource sets the notes of functions in the result that are not found
+// in the source tree of --check-source, if it is set.
+func checkSource(opts *outputOptions, result *torch.Result) error {
+	if opts.CheckSource == "" {
+		return nil
+	}
+	notes, err := staleNotes(opts.CheckSource, result)
+	if err != nil {
+		return fmt.Errorf("could not check functions against the source in %v: %v", opts.CheckSource, err)
+	}
+	opts.staleNotes = notes
+	return nil
+}
+
+// staleNotes returns a note for the functions of the result's profile that
+// are stale in the source tree in dir, and warns how many samples have
+// frames of them.
+func staleNotes(dir string, result *torch.Result) (map[string]string, error) {
+	funcs, err := goSourceFuncs(dir)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := resultProfile(result)
+	if err != nil {
+		return nil, err
+	}
+
+	notes := make(map[string]string)
+	var stale []string
+	for _, t := range stack.Top(profile, result.SampleIndex) {
+		if funcs.stale(t.Func) {
+			notes[t.Func] = "not found in " + dir
+			stale = append(stale, t.Func)
+		}
+	}
+	if len(stale) == 0 {
+		torchlog.Debugf("All functions of the packages in %v were found in its source", dir)
+		return notes, nil
+	}
+
+	var total, staleTotal int64
+	for _, s := range profile.Samples {
+		count := s.Counts[result.SampleIndex]
+		total += count
+		for _, fn := range s.Funcs {
+			if _, ok := notes[fn]; ok {
+				staleTotal += count
+				break
+			}
+		}
+	}
+	listed := stale
+	if len(listed) > maxStaleListed {
+		listed = append(listed[:maxStaleListed:maxStaleListed], "...")
+	}
+	torchlog.Printf("Warning: %v functions, in %.1f%% of samples, were not found in the source in %v, so they may be from dead code or a stale binary: %v",
+		len(stale), percent(staleTotal, total), dir, strings.Join(listed, ", "))
+	return notes, nil
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
+)
+
+// newSourceTree creates a module with the given files, by their path in the
+// module, and returns its directory.
+func newSourceTree(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "go-torch-source")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	files["go.mod"] = "module github.com/uber/app\n"
+	for name, contents := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(file, []byte(contents), 0666); err != nil {
+			t.Fatalf("Failed to write %v: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestSourceFuncsStale(t *testing.T) {
+	dir := newSourceTree(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n\nfunc run() {}\n",
+		"cache/cache.go": `package cache
+
+type Cache struct{}
+
+func (c *Cache) Get(key string) string { return key }
+
+func (c Cache) Len() int { return 0 }
+
+func New() *Cache { return &Cache{} }
+`,
+		"cache/cache_test.go":    "package cache\n\nfunc helper() {}\n",
+		"vendor/x/x.go":          "package x\n\nfunc X() {}\n",
+		"nested/go.mod":          "module github.com/uber/nested\n",
+		"nested/nested.go":       "package nested\n\nfunc N() {}\n",
+		"testdata/broken/bad.go": "not go",
+	})
+	defer os.RemoveAll(dir)
+
+	funcs, err := goSourceFuncs(dir)
+	if err != nil {
+		t.Fatalf("goSourceFuncs failed: %v", err)
+	}
+
+	tests := []struct {
+		fn    string
+		stale bool
+	}{
+		{"main.main", false},
+		{"main.run.func1", false},
+		{"main.serve", true},
+		{"github.com/uber/app/cache.(*Cache).Get", false},
+		{"github.com/uber/app/cache.(*Cache).Get.func2.1", false},
+		{"github.com/uber/app/cache.(*Cache).Len", false},
+		{"github.com/uber/app/cache.Cache.Len", false},
+		{"github.com/uber/app/cache.(*Cache).Get-fm", false},
+		{"github.com/uber/app/cache.init", false},
+		{"github.com/uber/app/cache.init.0", false},
+		{"github.com/uber/app/cache.(*Cache).Put", true},
+		{"github.com/uber/app/cache.helper", true},
+		{"github.com/uber/app/cache.Old", true},
+		{"github.com/uber/app/removed.F", true},
+		{"github.com/uber/app.F", true},
+		{"github.com/uber/app/vendor/x.Y", false},
+		{"github.com/uber/other.F", false},
+		{"runtime.mallocgc", false},
+		{"github.com/uber/nested.N", false},
+		{"memeqbody", false},
+	}
+	for _, tt := range tests {
+		if got := funcs.stale(tt.fn); got != tt.stale {
+			t.Errorf("stale(%v) = %v, want %v", tt.fn, got, tt.stale)
+		}
+	}
+}
+
+func TestSourceImportPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-torch-source")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := sourceImportPath(dir); err == nil {
+		t.Errorf("sourceImportPath of a directory outside the GOPATH without go.mod expected to fail")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("// comment\nmodule \"github.com/uber/app\"\n\ngo 1.12\n"), 0666); err != nil {
+		t.Fatalf("Failed to write go.mod: %v", err)
+	}
+	if got, err := sourceImportPath(dir); err != nil || got != "github.com/uber/app" {
+		t.Errorf("sourceImportPath got %v, %v, want github.com/uber/app", got, err)
+	}
+}
+
+func TestTrimClosures(t *testing.T) {
+	tests := map[string]string{
+		"main.run":                   "main.run",
+		"main.run.func1":             "main.run",
+		"main.run.func1.2":           "main.run",
+		"main.(*T).M.func3":          "main.(*T).M",
+		"main.F[...].func1":          "main.F[...]",
+		"main.func":                  "main.func",
+		"main.function1":             "main.function1",
+		"github.com/uber/app/v2.Run": "github.com/uber/app/v2.Run",
+	}
+	for fn, want := range tests {
+		if got := trimClosures(fn); got != want {
+			t.Errorf("trimClosures(%v) = %v, want %v", fn, got, want)
+		}
+	}
+}
+
+func TestCheckSource(t *testing.T) {
+	dir := newSourceTree(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	defer os.RemoveAll(dir)
+
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main.main", "main.old"}, Counts: []int64{3}},
+			{Funcs: []string{"main.main", "runtime.mallocgc"}, Counts: []int64{1}},
+		},
+	}
+	opts := outputOptions{CheckSource: dir}
+	if err := checkSource(&opts, &torch.Result{Profile: profile}); err != nil {
+		t.Fatalf("checkSource failed: %v", err)
+	}
+	if want := map[string]string{"main.old": "not found in " + dir}; !reflect.DeepEqual(opts.staleNotes, want) {
+		t.Errorf("checkSource got notes %v, want %v", opts.staleNotes, want)
+	}
+
+	opts = outputOptions{CheckSource: filepath.Join(dir, "missing")}
+	if err := checkSource(&opts, &torch.Result{Profile: profile}); err == nil {
+		t.Errorf("checkSource of a missing directory expected to fail")
+	}
+}
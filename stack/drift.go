@@ -0,0 +1,102 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"math"
+	"sort"
+)
+
+// FuncDrift is the change in the fraction of samples that include a
+// function between a baseline profile and a current profile.
+type FuncDrift struct {
+	Func string
+	// Base and Current are the fraction of samples, between 0 and 1, that
+	// include Func in the baseline and current profiles.
+	Base    float64
+	Current float64
+}
+
+// Change returns the difference between the current and baseline fractions.
+func (d FuncDrift) Change() float64 {
+	return d.Current - d.Base
+}
+
+// Drift compares the fraction of samples at sampleIdx that include each
+// function in the baseline and current profiles, and returns the functions
+// whose fraction changed by at least threshold, largest change first.
+func Drift(base, current *Profile, sampleIdx int, threshold float64) []FuncDrift {
+	baseShares := funcShares(base, sampleIdx)
+	currentShares := funcShares(current, sampleIdx)
+
+	var drift []FuncDrift
+	add := func(f string) {
+		d := FuncDrift{Func: f, Base: baseShares[f], Current: currentShares[f]}
+		if math.Abs(d.Change()) >= threshold {
+			drift = append(drift, d)
+		}
+	}
+	for f := range baseShares {
+		add(f)
+	}
+	for f := range currentShares {
+		if _, ok := baseShares[f]; !ok {
+			add(f)
+		}
+	}
+
+	sort.Slice(drift, func(i, j int) bool {
+		ci, cj := math.Abs(drift[i].Change()), math.Abs(drift[j].Change())
+		if ci != cj {
+			return ci > cj
+		}
+		return drift[i].Func < drift[j].Func
+	})
+	return drift
+}
+
+// funcShares returns the fraction of the total samples that include each
+// function. Recursive functions are only counted once per sample.
+func funcShares(p *Profile, sampleIdx int) map[string]float64 {
+	var total int64
+	counts := make(map[string]int64)
+	for _, s := range p.Samples {
+		count := s.Counts[sampleIdx]
+		total += count
+
+		seen := make(map[string]bool, len(s.Funcs))
+		for _, f := range s.Funcs {
+			if !seen[f] {
+				seen[f] = true
+				counts[f] += count
+			}
+		}
+	}
+
+	shares := make(map[string]float64, len(counts))
+	if total == 0 {
+		return shares
+	}
+	for f, count := range counts {
+		shares[f] = float64(count) / float64(total)
+	}
+	return shares
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrift(t *testing.T) {
+	names := []string{"samples/count"}
+	base := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"main", "a"}, Counts: []int64{50}},
+			{Funcs: []string{"main", "b"}, Counts: []int64{48}},
+			{Funcs: []string{"main", "c"}, Counts: []int64{2}},
+		},
+	}
+	current := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"main", "a"}, Counts: []int64{20}},
+			{Funcs: []string{"main", "b"}, Counts: []int64{48}},
+			{Funcs: []string{"main", "d", "d"}, Counts: []int64{31}},
+			{Funcs: []string{"main", "c"}, Counts: []int64{1}},
+		},
+	}
+
+	expected := []FuncDrift{
+		{Func: "d", Base: 0, Current: 0.31},
+		{Func: "a", Base: 0.5, Current: 0.2},
+	}
+	drift := Drift(base, current, 0, 0.05)
+	assert.Equal(t, expected, drift)
+	assert.InDelta(t, 0.31, drift[0].Change(), 1e-9)
+	assert.InDelta(t, -0.3, drift[1].Change(), 1e-9)
+
+	assert.Empty(t, Drift(base, base, 0, 0.01), "profile should not drift from itself")
+	assert.Len(t, Drift(base, current, 0, 0), 5, "zero threshold should report every function")
+}
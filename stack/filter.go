@@ -0,0 +1,159 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter transforms the functions of a stack, which are parent first.
+// A filter may modify funcs in place, and returns the transformed stack.
+type Filter func(funcs []string) []string
+
+// Chain returns a filter that applies each of filters in order.
+func Chain(filters ...Filter) Filter {
+	return func(funcs []string) []string {
+		for _, f := range filters {
+			funcs = f(funcs)
+		}
+		return funcs
+	}
+}
+
+// HideFrames returns a filter that removes functions matching re, so their
+// callees are attributed to their callers.
+func HideFrames(re *regexp.Regexp) Filter {
+	return func(funcs []string) []string {
+		filtered := funcs[:0]
+		for _, f := range funcs {
+			if !re.MatchString(f) {
+				filtered = append(filtered, f)
+			}
+		}
+		return filtered
+	}
+}
+
+// SquashFrames returns a filter that collapses consecutive functions
+// matching re into the outermost of them.
+func SquashFrames(re *regexp.Regexp) Filter {
+	return func(funcs []string) []string {
+		filtered := funcs[:0]
+		squashing := false
+		for _, f := range funcs {
+			matches := re.MatchString(f)
+			if !matches || !squashing {
+				filtered = append(filtered, f)
+			}
+			squashing = matches
+		}
+		return filtered
+	}
+}
+
+// CollapseRecursion returns a filter that replaces consecutive calls of the
+// same function with a single frame annotated with the recursion depth, e.g.
+// main.fib;main.fib;main.fib becomes main.fib [depth 3].
+func CollapseRecursion() Filter {
+	return func(funcs []string) []string {
+		collapsed := funcs[:0]
+		for i := 0; i < len(funcs); {
+			j := i + 1
+			for j < len(funcs) && funcs[j] == funcs[i] {
+				j++
+			}
+			if depth := j - i; depth > 1 {
+				collapsed = append(collapsed, fmt.Sprintf("%v [depth %v]", funcs[i], depth))
+			} else {
+				collapsed = append(collapsed, funcs[i])
+			}
+			i = j
+		}
+		return collapsed
+	}
+}
+
+// ptrWrapperRE matches a method with a pointer receiver, e.g.
+// pkg.(*T).Method, capturing the package, type and method.
+var ptrWrapperRE = regexp.MustCompile(`^(.*)\.\(\*([^()]+)\)\.([^.()]+)$`)
+
+// MergeWrappers returns a filter that merges the wrappers generated by the
+// compiler into the methods they call, so the same work is not split across
+// frames. These are method value wrappers, e.g. pkg.(*T).Method-fm, and the
+// pkg.(*T).Method wrapper of a method with a value receiver, pkg.T.Method,
+// which is called through interfaces.
+func MergeWrappers() Filter {
+	return func(funcs []string) []string {
+		merged := funcs[:0]
+		for i, f := range funcs {
+			target := strings.TrimSuffix(f, "-fm")
+			if i+1 < len(funcs) {
+				next := funcs[i+1]
+				if target != f && next == target {
+					continue
+				}
+				if m := ptrWrapperRE.FindStringSubmatch(f); m != nil && next == m[1]+"."+m[2]+"."+m[3] {
+					continue
+				}
+			}
+			merged = append(merged, target)
+		}
+		return merged
+	}
+}
+
+// TrimPrefix returns a filter that removes prefix from function names,
+// such as a common import path.
+func TrimPrefix(prefix string) Filter {
+	return func(funcs []string) []string {
+		for i, f := range funcs {
+			funcs[i] = strings.TrimPrefix(f, prefix)
+		}
+		return funcs
+	}
+}
+
+// ApplyFilter returns a copy of the profile with filter applied to each
+// sample. Samples whose stacks are the same after filtering are combined.
+// The input profile is not modified.
+func ApplyFilter(p *Profile, filter Filter) (*Profile, error) {
+	filtered := &Profile{
+		SampleNames: p.SampleNames,
+		Samples:     make([]*Sample, 0, len(p.Samples)),
+		Mappings:    p.Mappings,
+		TimeOrdered: p.TimeOrdered,
+		Duration:    p.Duration,
+		Period:      p.Period,
+	}
+	for _, s := range p.Samples {
+		funcs := append([]string(nil), s.Funcs...)
+		filtered.Samples = append(filtered.Samples, &Sample{
+			Funcs:  filter(funcs),
+			Counts: s.Counts,
+			Labels: s.Labels,
+		})
+	}
+
+	// Merge combines samples with identical stacks, and copies the counts.
+	return Merge(filtered)
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilters(t *testing.T) {
+	runtimeRE := regexp.MustCompile(`^runtime\.`)
+	tests := []struct {
+		name   string
+		filter Filter
+		funcs  []string
+		want   []string
+	}{
+		{
+			name:   "hide",
+			filter: HideFrames(regexp.MustCompile(`^net/http\.`)),
+			funcs:  []string{"main.main", "net/http.(*conn).serve", "net/http.HandlerFunc.ServeHTTP", "main.handler"},
+			want:   []string{"main.main", "main.handler"},
+		},
+		{
+			name:   "squash",
+			filter: SquashFrames(runtimeRE),
+			funcs:  []string{"runtime.goexit", "runtime.main", "main.main", "runtime.mallocgc", "runtime.gcStart", "runtime.stopTheWorld"},
+			want:   []string{"runtime.goexit", "main.main", "runtime.mallocgc"},
+		},
+		{
+			name:   "trim prefix",
+			filter: TrimPrefix("github.com/uber/"),
+			funcs:  []string{"main.main", "github.com/uber/go-torch/stack.Merge"},
+			want:   []string{"main.main", "go-torch/stack.Merge"},
+		},
+		{
+			name:   "collapse recursion",
+			filter: CollapseRecursion(),
+			funcs:  []string{"main.main", "main.fib", "main.fib", "main.fib", "main.walk", "main.fib", "main.walk", "main.walk"},
+			want:   []string{"main.main", "main.fib [depth 3]", "main.walk", "main.fib", "main.walk [depth 2]"},
+		},
+		{
+			name:   "merge method value wrapper",
+			filter: MergeWrappers(),
+			funcs:  []string{"main.main", "main.(*server).handle-fm", "main.(*server).handle", "main.work-fm"},
+			want:   []string{"main.main", "main.(*server).handle", "main.work"},
+		},
+		{
+			name:   "merge value receiver wrapper",
+			filter: MergeWrappers(),
+			funcs:  []string{"main.main", "io.Copy", "main.(*reader).Read", "main.reader.Read", "main.reader.Read"},
+			want:   []string{"main.main", "io.Copy", "main.reader.Read", "main.reader.Read"},
+		},
+		{
+			name:   "merge nested wrappers",
+			filter: MergeWrappers(),
+			funcs:  []string{"main.main", "main.(*T).M-fm", "main.(*T).M", "main.T.M"},
+			want:   []string{"main.main", "main.T.M"},
+		},
+		{
+			name:   "keep calls between methods",
+			filter: MergeWrappers(),
+			funcs:  []string{"main.(*T).M", "main.U.M", "main.(*T).M", "main.(*T).M"},
+			want:   []string{"main.(*T).M", "main.U.M", "main.(*T).M", "main.(*T).M"},
+		},
+		{
+			name:   "chain",
+			filter: Chain(TrimPrefix("github.com/uber/"), HideFrames(regexp.MustCompile(`^go-torch/`)), SquashFrames(runtimeRE)),
+			funcs:  []string{"runtime.main", "github.com/uber/go-torch/torch.Generate", "runtime.mallocgc", "runtime.gcStart"},
+			want:   []string{"runtime.main"},
+		},
+		{
+			name:   "empty chain",
+			filter: Chain(),
+			funcs:  []string{"main.main"},
+			want:   []string{"main.main"},
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.filter(tt.funcs), "%v: unexpected stack", tt.name)
+	}
+}
+
+func TestApplyFilter(t *testing.T) {
+	names := []string{"samples/count"}
+	p := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"main.main", "middleware.Wrap", "main.handler"}, Counts: []int64{1}},
+			{Funcs: []string{"main.main", "main.handler"}, Counts: []int64{2}},
+			{Funcs: []string{"main.main", "main.other"}, Counts: []int64{3}},
+		},
+	}
+
+	filtered, err := ApplyFilter(p, HideFrames(regexp.MustCompile(`^middleware\.`)))
+	require.NoError(t, err, "ApplyFilter failed")
+
+	expected := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"main.main", "main.handler"}, Counts: []int64{3}},
+			{Funcs: []string{"main.main", "main.other"}, Counts: []int64{3}},
+		},
+	}
+	assert.Equal(t, expected, filtered)
+	assert.Equal(t, []string{"main.main", "middleware.Wrap", "main.handler"}, p.Samples[0].Funcs, "ApplyFilter should not modify its input")
+	assert.Equal(t, []int64{1}, p.Samples[0].Counts, "ApplyFilter should not modify its input")
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"errors"
+	"regexp"
+)
+
+// ErrNoFocusedSamples is returned when a FocusFilter drops every sample.
+var ErrNoFocusedSamples = errors.New("no samples match the focus and ignore filters")
+
+// FocusFilter selects samples by the functions in their stacks, like the
+// -focus and -ignore options of pprof. Unlike a Filter, it keeps or drops
+// whole samples, so parsers apply it before samples are aggregated, and the
+// dropped samples are never held in memory.
+type FocusFilter struct {
+	// Focus, if set, keeps only samples with a function matching it.
+	Focus *regexp.Regexp
+	// Ignore, if set, drops samples with a function matching it.
+	Ignore *regexp.Regexp
+}
+
+// Enabled returns whether the filter drops any samples.
+func (f FocusFilter) Enabled() bool {
+	return f.Focus != nil || f.Ignore != nil
+}
+
+// Keep returns whether a sample with the given functions is kept.
+func (f FocusFilter) Keep(funcs []string) bool {
+	focused := f.Focus == nil
+	for _, fn := range funcs {
+		focus, ignore := f.Match(fn)
+		if ignore {
+			return false
+		}
+		focused = focused || focus
+	}
+	return focused
+}
+
+// Match returns whether a single function matches Focus and Ignore, so
+// parsers can cache the result for each function.
+func (f FocusFilter) Match(funcName string) (focus, ignore bool) {
+	focus = f.Focus != nil && f.Focus.MatchString(funcName)
+	ignore = f.Ignore != nil && f.Ignore.MatchString(funcName)
+	return focus, ignore
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFocusFilter(t *testing.T) {
+	focus := regexp.MustCompile(`^github\.com/myorg/db\.`)
+	ignore := regexp.MustCompile(`^runtime\.gcBgMarkWorker$`)
+
+	tests := []struct {
+		msg    string
+		filter FocusFilter
+		funcs  []string
+		want   bool
+	}{
+		{
+			msg:   "no filter",
+			funcs: []string{"main.main"},
+			want:  true,
+		},
+		{
+			msg:    "focus matches",
+			filter: FocusFilter{Focus: focus},
+			funcs:  []string{"main.main", "github.com/myorg/db.Query"},
+			want:   true,
+		},
+		{
+			msg:    "focus does not match",
+			filter: FocusFilter{Focus: focus},
+			funcs:  []string{"main.main", "main.handler"},
+			want:   false,
+		},
+		{
+			msg:    "ignore matches",
+			filter: FocusFilter{Ignore: ignore},
+			funcs:  []string{"runtime.gcBgMarkWorker", "runtime.gcDrain"},
+			want:   false,
+		},
+		{
+			msg:    "ignore takes precedence over focus",
+			filter: FocusFilter{Focus: focus, Ignore: ignore},
+			funcs:  []string{"github.com/myorg/db.Query", "runtime.gcBgMarkWorker"},
+			want:   false,
+		},
+		{
+			msg:    "focus with ignore not matching",
+			filter: FocusFilter{Focus: focus, Ignore: ignore},
+			funcs:  []string{"github.com/myorg/db.Query", "runtime.mallocgc"},
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.filter.Keep(tt.funcs), tt.msg)
+	}
+
+	assert.False(t, FocusFilter{}.Enabled(), "empty filter should not be enabled")
+	assert.True(t, FocusFilter{Ignore: ignore}.Enabled(), "filter with ignore should be enabled")
+}
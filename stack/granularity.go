@@ -0,0 +1,123 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Granularity is the level of detail that frames are named at. Coarser
+// granularities merge the samples of many functions into one frame.
+type Granularity int
+
+// Granularities, from the coarsest to the finest.
+const (
+	// FunctionGranularity names frames by their function, the default.
+	FunctionGranularity Granularity = iota
+	// PackageGranularity names frames by their function's package.
+	PackageGranularity
+	// FileGranularity names frames by their source file.
+	FileGranularity
+	// LineGranularity names frames by their function and source line.
+	LineGranularity
+)
+
+var granularityNames = map[Granularity]string{
+	FunctionGranularity: "function",
+	PackageGranularity:  "package",
+	FileGranularity:     "file",
+	LineGranularity:     "line",
+}
+
+// ParseGranularity returns the granularity with the given name, which is
+// one of package, file, function or line.
+func ParseGranularity(name string) (Granularity, error) {
+	for g, n := range granularityNames {
+		if n == name {
+			return g, nil
+		}
+	}
+	return FunctionGranularity, fmt.Errorf("unknown granularity %q, must be package, file, function or line", name)
+}
+
+func (g Granularity) String() string {
+	if name, ok := granularityNames[g]; ok {
+		return name
+	}
+	return fmt.Sprintf("Granularity(%d)", int(g))
+}
+
+// Frame is a location in a stack. File is empty and Line is 0 if the
+// profile has no line information.
+type Frame struct {
+	Func string
+	File string
+	Line int
+}
+
+// Name returns the name of the frame at granularity g. Frames without a
+// package or file are named by their function.
+func (g Granularity) Name(f Frame) string {
+	switch g {
+	case PackageGranularity:
+		if pkg := FuncPackage(f.Func); pkg != "" {
+			return pkg
+		}
+	case FileGranularity:
+		if f.File != "" {
+			return f.File
+		}
+	case LineGranularity:
+		if f.File != "" {
+			return fmt.Sprintf("%v %v:%v", f.Func, filepath.Base(f.File), f.Line)
+		}
+	}
+	return f.Func
+}
+
+// Names returns the names of the frames at granularity g. At package and
+// file granularity, consecutive frames with the same name are merged, so a
+// package calling itself is a single frame.
+func (g Granularity) Names(frames []Frame) []string {
+	names := make([]string, 0, len(frames))
+	for _, f := range frames {
+		name := g.Name(f)
+		if (g == PackageGranularity || g == FileGranularity) && len(names) > 0 && names[len(names)-1] == name {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// FuncPackage returns the package path of a Go function name, e.g.
+// github.com/uber/foo/cache for github.com/uber/foo/cache.(*Cache).Get.
+// It returns "" for names that are not in a package, such as C functions.
+func FuncPackage(fn string) string {
+	slash := strings.LastIndex(fn, "/")
+	dot := strings.Index(fn[slash+1:], ".")
+	if dot < 0 {
+		return ""
+	}
+	return fn[:slash+1+dot]
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGranularity(t *testing.T) {
+	for _, g := range []Granularity{FunctionGranularity, PackageGranularity, FileGranularity, LineGranularity} {
+		got, err := ParseGranularity(g.String())
+		require.NoError(t, err, "ParseGranularity(%v) failed", g)
+		assert.Equal(t, g, got, "ParseGranularity(%v)", g)
+	}
+
+	_, err := ParseGranularity("module")
+	assert.EqualError(t, err, `unknown granularity "module", must be package, file, function or line`)
+	assert.Equal(t, "Granularity(9)", Granularity(9).String())
+}
+
+func TestGranularityNames(t *testing.T) {
+	frames := []Frame{
+		{Func: "main.main", File: "/src/app/main.go", Line: 10},
+		{Func: "github.com/uber/foo/cache.(*Cache).Get", File: "/src/foo/cache/cache.go", Line: 20},
+		{Func: "github.com/uber/foo/cache.(*Cache).load", File: "/src/foo/cache/cache.go", Line: 45},
+		{Func: "github.com/uber/foo/cache.hash", File: "/src/foo/cache/hash.go", Line: 3},
+		{Func: "malloc"},
+	}
+	tests := []struct {
+		granularity Granularity
+		want        []string
+	}{
+		{
+			granularity: FunctionGranularity,
+			want: []string{"main.main", "github.com/uber/foo/cache.(*Cache).Get", "github.com/uber/foo/cache.(*Cache).load",
+				"github.com/uber/foo/cache.hash", "malloc"},
+		},
+		{
+			granularity: PackageGranularity,
+			want:        []string{"main", "github.com/uber/foo/cache", "malloc"},
+		},
+		{
+			granularity: FileGranularity,
+			want:        []string{"/src/app/main.go", "/src/foo/cache/cache.go", "/src/foo/cache/hash.go", "malloc"},
+		},
+		{
+			granularity: LineGranularity,
+			want: []string{"main.main main.go:10", "github.com/uber/foo/cache.(*Cache).Get cache.go:20",
+				"github.com/uber/foo/cache.(*Cache).load cache.go:45", "github.com/uber/foo/cache.hash hash.go:3", "malloc"},
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.granularity.Names(frames), "%v granularity", tt.granularity)
+	}
+}
+
+func TestGranularityKeepsRecursion(t *testing.T) {
+	frames := []Frame{{Func: "main.fib"}, {Func: "main.fib"}}
+	assert.Equal(t, []string{"main.fib", "main.fib"}, FunctionGranularity.Names(frames))
+	assert.Equal(t, []string{"main"}, PackageGranularity.Names(frames))
+}
+
+func TestFuncPackage(t *testing.T) {
+	tests := []struct {
+		fn   string
+		want string
+	}{
+		{"main.fib", "main"},
+		{"runtime.mallocgc", "runtime"},
+		{"github.com/uber/foo/cache.(*Cache).Get", "github.com/uber/foo/cache"},
+		{"github.com/uber/foo/cache.Get.func1", "github.com/uber/foo/cache"},
+		{"gopkg.in/yaml.v2.Unmarshal", "gopkg.in/yaml"},
+		{"malloc", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, FuncPackage(tt.fn), "FuncPackage(%v)", tt.fn)
+	}
+}
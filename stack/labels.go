@@ -0,0 +1,128 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// noLabelValue is used in label frames for samples without the label.
+const noLabelValue = "<none>"
+
+// Labels are the labels of a sample, such as pprof labels set using
+// pprof.Do, or the thread that a perf sample was taken on. A key may have
+// multiple values.
+type Labels map[string][]string
+
+// String returns the labels formatted as they are by pprof, sorted by key,
+// e.g. "handler:[/foo] region:[a b]".
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%v:%v", k, l[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// Matches returns whether the sample has a value for each key in selector.
+// If selector has multiple values for a key, any of them match.
+func (l Labels) Matches(selector Labels) bool {
+	for key, want := range selector {
+		if !containsAny(l[key], want) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAny(values, want []string) bool {
+	for _, v := range values {
+		for _, w := range want {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FilterLabels returns a copy of the profile with only the samples whose
+// labels match selector. The input profile is not modified.
+func FilterLabels(p *Profile, selector Labels) (*Profile, error) {
+	result := &Profile{
+		SampleNames: p.SampleNames,
+		Mappings:    p.Mappings,
+		TimeOrdered: p.TimeOrdered,
+		Duration:    p.Duration,
+		Period:      p.Period,
+	}
+	for _, s := range p.Samples {
+		if s.Labels.Matches(selector) {
+			result.Samples = append(result.Samples, s)
+		}
+	}
+
+	// Merge copies the counts, so the result does not share them with p.
+	return Merge(result)
+}
+
+// AddLabelFrames returns a copy of the profile with a root frame of
+// "key=value" added to each stack for each of keys, so the flame graph is
+// split by the values of the keys, e.g. one subgraph per thread. Samples
+// without a label have a "key=<none>" frame. The input profile is not
+// modified.
+func AddLabelFrames(p *Profile, keys ...string) (*Profile, error) {
+	result := &Profile{
+		SampleNames: p.SampleNames,
+		Samples:     make([]*Sample, 0, len(p.Samples)),
+		Mappings:    p.Mappings,
+		TimeOrdered: p.TimeOrdered,
+		Duration:    p.Duration,
+		Period:      p.Period,
+	}
+	for _, s := range p.Samples {
+		funcs := make([]string, 0, len(s.Funcs)+len(keys))
+		for _, key := range keys {
+			value := noLabelValue
+			if values, ok := s.Labels[key]; ok {
+				value = strings.Join(values, ",")
+			}
+			funcs = append(funcs, key+"="+value)
+		}
+		funcs = append(funcs, s.Funcs...)
+		result.Samples = append(result.Samples, &Sample{
+			Funcs:  funcs,
+			Counts: s.Counts,
+			Labels: s.Labels,
+		})
+	}
+
+	// Merge copies the counts, so the result does not share them with p.
+	return Merge(result)
+}
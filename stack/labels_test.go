@@ -0,0 +1,146 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelsString(t *testing.T) {
+	tests := []struct {
+		labels Labels
+		want   string
+	}{
+		{nil, ""},
+		{Labels{"thread": {"7"}}, "thread:[7]"},
+		{Labels{"region": {"a", "b"}, "handler": {"/foo"}}, "handler:[/foo] region:[a b]"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.labels.String(), "String(%v)", map[string][]string(tt.labels))
+	}
+}
+
+func TestMergeLabels(t *testing.T) {
+	names := []string{"samples/count"}
+	p := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"main"}, Counts: []int64{1}, Labels: Labels{"thread": {"1"}}},
+			{Funcs: []string{"main"}, Counts: []int64{2}, Labels: Labels{"thread": {"2"}}},
+			{Funcs: []string{"main"}, Counts: []int64{3}, Labels: Labels{"thread": {"1"}}},
+			{Funcs: []string{"main"}, Counts: []int64{4}},
+		},
+	}
+
+	merged, err := Merge(p)
+	require.NoError(t, err, "Merge failed")
+
+	expected := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"main"}, Counts: []int64{4}, Labels: Labels{"thread": {"1"}}},
+			{Funcs: []string{"main"}, Counts: []int64{2}, Labels: Labels{"thread": {"2"}}},
+			{Funcs: []string{"main"}, Counts: []int64{4}},
+		},
+	}
+	assert.Equal(t, expected, merged)
+}
+
+func TestAddLabelFrames(t *testing.T) {
+	names := []string{"samples/count"}
+	p := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"main", "work"}, Counts: []int64{1}, Labels: Labels{"thread": {"1"}}},
+			{Funcs: []string{"main", "work"}, Counts: []int64{2}, Labels: Labels{"thread": {"2"}, "region": {"a"}}},
+			{Funcs: []string{"main", "idle"}, Counts: []int64{3}},
+		},
+	}
+
+	result, err := AddLabelFrames(p, "thread")
+	require.NoError(t, err, "AddLabelFrames failed")
+
+	expected := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"thread=1", "main", "work"}, Counts: []int64{1}, Labels: Labels{"thread": {"1"}}},
+			{Funcs: []string{"thread=2", "main", "work"}, Counts: []int64{2}, Labels: Labels{"thread": {"2"}, "region": {"a"}}},
+			{Funcs: []string{"thread=<none>", "main", "idle"}, Counts: []int64{3}},
+		},
+	}
+	assert.Equal(t, expected, result)
+	assert.Equal(t, []string{"main", "work"}, p.Samples[0].Funcs, "AddLabelFrames should not modify its input")
+}
+
+func TestAddLabelFramesMultipleKeys(t *testing.T) {
+	p := &Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*Sample{
+			{Funcs: []string{"main"}, Counts: []int64{1}, Labels: Labels{"handler": {"/foo"}, "region": {"a", "b"}}},
+		},
+	}
+
+	result, err := AddLabelFrames(p, "handler", "region", "user")
+	require.NoError(t, err, "AddLabelFrames failed")
+	assert.Equal(t, []string{"handler=/foo", "region=a,b", "user=<none>", "main"}, result.Samples[0].Funcs)
+}
+
+func TestFilterLabels(t *testing.T) {
+	names := []string{"samples/count"}
+	foo := Labels{"handler": {"/foo"}, "region": {"a", "b"}}
+	bar := Labels{"handler": {"/bar"}}
+	p := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"main", "a"}, Counts: []int64{1}, Labels: foo},
+			{Funcs: []string{"main", "b"}, Counts: []int64{2}, Labels: bar},
+			{Funcs: []string{"main", "c"}, Counts: []int64{3}},
+		},
+	}
+
+	tests := []struct {
+		selector Labels
+		want     []string
+	}{
+		{nil, []string{"a", "b", "c"}},
+		{Labels{"handler": {"/foo"}}, []string{"a"}},
+		{Labels{"handler": {"/foo", "/bar"}}, []string{"a", "b"}},
+		{Labels{"handler": {"/bar"}, "region": {"b"}}, nil},
+		{Labels{"region": {"b"}}, []string{"a"}},
+		{Labels{"missing": {"x"}}, nil},
+	}
+
+	for _, tt := range tests {
+		result, err := FilterLabels(p, tt.selector)
+		require.NoError(t, err, "FilterLabels failed")
+
+		var got []string
+		for _, s := range result.Samples {
+			got = append(got, s.Funcs[1])
+		}
+		assert.Equal(t, tt.want, got, "FilterLabels(%v)", tt.selector)
+	}
+	assert.Equal(t, []int64{1}, p.Samples[0].Counts, "FilterLabels should not modify its input")
+}
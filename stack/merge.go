@@ -0,0 +1,92 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var errMergeNoProfiles = errors.New("cannot merge zero profiles")
+
+// Merge combines the samples of the given profiles into a single profile.
+// All profiles must have the same sample names. Samples are returned in the
+// order each stack was first seen, and the mappings of the first profile are
+// used. If the first profile is time ordered, only consecutive samples with
+// the same stack are combined. The duration is the longest of the profiles,
+// as profiles of different processes are usually collected at the same time.
+// The input profiles are not modified.
+func Merge(profiles ...*Profile) (*Profile, error) {
+	if len(profiles) == 0 {
+		return nil, errMergeNoProfiles
+	}
+
+	merged, err := NewProfile(profiles[0].SampleNames)
+	if err != nil {
+		return nil, err
+	}
+	merged.Mappings = profiles[0].Mappings
+	merged.TimeOrdered = profiles[0].TimeOrdered
+	merged.Period = profiles[0].Period
+
+	samples := make(map[string]*Sample)
+	for i, p := range profiles {
+		if !reflect.DeepEqual(p.SampleNames, merged.SampleNames) {
+			return nil, fmt.Errorf("cannot merge profile %v with sample names %v into profile with sample names %v",
+				i, p.SampleNames, merged.SampleNames)
+		}
+		if p.Duration > merged.Duration {
+			merged.Duration = p.Duration
+		}
+
+		for _, s := range p.Samples {
+			key := s.key()
+			if existing, ok := samples[key]; ok {
+				if err := existing.Add(s.Counts); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			sample := NewSample(s.Funcs, s.Counts)
+			sample.Labels = s.Labels
+			if merged.TimeOrdered {
+				// Forget earlier stacks, so they are not combined with later samples.
+				samples = make(map[string]*Sample, 1)
+			}
+			samples[key] = sample
+			merged.Samples = append(merged.Samples, sample)
+		}
+	}
+
+	return merged, nil
+}
+
+// key identifies samples that can be combined.
+func (s *Sample) key() string {
+	key := strings.Join(s.Funcs, ";")
+	if len(s.Labels) > 0 {
+		key += "\x00" + s.Labels.String()
+	}
+	return key
+}
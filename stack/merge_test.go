@@ -0,0 +1,117 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	names := []string{"samples/count", "cpu/nanoseconds"}
+	p1 := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"main", "a"}, Counts: []int64{1, 10}},
+			{Funcs: []string{"main", "b"}, Counts: []int64{2, 20}},
+		},
+		Mappings: []*Mapping{{File: "binary"}},
+		Duration: 30 * time.Second,
+	}
+	p2 := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"main", "c"}, Counts: []int64{3, 30}},
+			{Funcs: []string{"main", "a"}, Counts: []int64{4, 40}},
+		},
+		Duration: 31 * time.Second,
+	}
+
+	merged, err := Merge(p1, p2)
+	require.NoError(t, err, "Merge failed")
+
+	expected := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"main", "a"}, Counts: []int64{5, 50}},
+			{Funcs: []string{"main", "b"}, Counts: []int64{2, 20}},
+			{Funcs: []string{"main", "c"}, Counts: []int64{3, 30}},
+		},
+		Mappings: []*Mapping{{File: "binary"}},
+		Duration: 31 * time.Second,
+	}
+	assert.Equal(t, expected, merged)
+	assert.Equal(t, []int64{1, 10}, p1.Samples[0].Counts, "Merge should not modify its input")
+}
+
+func TestMergeTimeOrdered(t *testing.T) {
+	p := &Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*Sample{
+			{Funcs: []string{"main", "init"}, Counts: []int64{1}},
+			{Funcs: []string{"main", "a"}, Counts: []int64{1}},
+			{Funcs: []string{"main", "a"}, Counts: []int64{2}},
+			{Funcs: []string{"main", "b"}, Counts: []int64{1}},
+			{Funcs: []string{"main", "a"}, Counts: []int64{3}},
+		},
+		TimeOrdered: true,
+	}
+
+	merged, err := Merge(p)
+	require.NoError(t, err, "Merge failed")
+
+	expected := &Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*Sample{
+			{Funcs: []string{"main", "init"}, Counts: []int64{1}},
+			{Funcs: []string{"main", "a"}, Counts: []int64{3}},
+			{Funcs: []string{"main", "b"}, Counts: []int64{1}},
+			{Funcs: []string{"main", "a"}, Counts: []int64{3}},
+		},
+		TimeOrdered: true,
+	}
+	assert.Equal(t, expected, merged)
+
+	filtered, err := ApplyFilter(merged, HideFrames(regexp.MustCompile(`^b$`)))
+	require.NoError(t, err, "ApplyFilter failed")
+	assert.Equal(t, []*Sample{
+		{Funcs: []string{"main", "init"}, Counts: []int64{1}},
+		{Funcs: []string{"main", "a"}, Counts: []int64{3}},
+		{Funcs: []string{"main"}, Counts: []int64{1}},
+		{Funcs: []string{"main", "a"}, Counts: []int64{3}},
+	}, filtered.Samples, "ApplyFilter should keep the time order")
+}
+
+func TestMergeErrors(t *testing.T) {
+	_, err := Merge()
+	assert.Equal(t, errMergeNoProfiles, err)
+
+	p1 := &Profile{SampleNames: []string{"samples/count"}}
+	p2 := &Profile{SampleNames: []string{"alloc_space/bytes"}}
+	_, err = Merge(p1, p2)
+	if assert.Error(t, err, "expected mismatched sample names to fail") {
+		assert.Contains(t, err.Error(), "cannot merge profile 1")
+	}
+}
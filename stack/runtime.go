@@ -0,0 +1,64 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import "regexp"
+
+// runtimeFrameRE matches functions in the Go runtime package, such as the
+// scheduler and garbage collector, but not packages such as runtime/pprof.
+var runtimeFrameRE = regexp.MustCompile(`^runtime\.`)
+
+// runtimeFrame replaces runtime functions in stacks that only have runtime
+// functions, or when runtime functions are collapsed.
+const runtimeFrame = "runtime"
+
+// StripRuntime returns a filter that removes runtime functions, such as
+// runtime.mcall, runtime.morestack and GC workers, so application functions
+// are not obscured. Stacks that only have runtime functions are replaced by
+// a single runtime frame, so the time spent in the runtime is still shown.
+func StripRuntime() Filter {
+	hide := HideFrames(runtimeFrameRE)
+	return func(funcs []string) []string {
+		if stripped := hide(funcs); len(stripped) > 0 {
+			return stripped
+		}
+		return append(funcs[:0], runtimeFrame)
+	}
+}
+
+// CollapseRuntime returns a filter that replaces consecutive runtime
+// functions with a single runtime frame.
+func CollapseRuntime() Filter {
+	return func(funcs []string) []string {
+		collapsed := funcs[:0]
+		inRuntime := false
+		for _, f := range funcs {
+			isRuntime := runtimeFrameRE.MatchString(f)
+			if !isRuntime {
+				collapsed = append(collapsed, f)
+			} else if !inRuntime {
+				collapsed = append(collapsed, runtimeFrame)
+			}
+			inRuntime = isRuntime
+		}
+		return collapsed
+	}
+}
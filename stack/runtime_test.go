@@ -0,0 +1,59 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuntimeFilters(t *testing.T) {
+	tests := []struct {
+		funcs     []string
+		stripped  []string
+		collapsed []string
+	}{
+		{
+			funcs:     []string{"runtime.goexit", "main.main", "main.fib", "runtime.morestack", "runtime.newstack"},
+			stripped:  []string{"main.main", "main.fib"},
+			collapsed: []string{"runtime", "main.main", "main.fib", "runtime"},
+		},
+		{
+			funcs:     []string{"runtime.goexit", "runtime.gcBgMarkWorker", "runtime.gcDrain"},
+			stripped:  []string{"runtime"},
+			collapsed: []string{"runtime"},
+		},
+		{
+			funcs:     []string{"main.main", "runtime/pprof.StartCPUProfile"},
+			stripped:  []string{"main.main", "runtime/pprof.StartCPUProfile"},
+			collapsed: []string{"main.main", "runtime/pprof.StartCPUProfile"},
+		},
+	}
+
+	for _, tt := range tests {
+		funcs := append([]string(nil), tt.funcs...)
+		assert.Equal(t, tt.stripped, StripRuntime()(funcs), "StripRuntime(%v)", tt.funcs)
+
+		funcs = append([]string(nil), tt.funcs...)
+		assert.Equal(t, tt.collapsed, CollapseRuntime()(funcs), "CollapseRuntime(%v)", tt.funcs)
+	}
+}
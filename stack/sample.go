@@ -23,6 +23,7 @@ package stack
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 var (
@@ -34,6 +35,32 @@ var (
 type Profile struct {
 	SampleNames []string
 	Samples     []*Sample
+
+	// Mappings are the binaries and libraries mapped into the profiled
+	// process, if the profile included them.
+	Mappings []*Mapping
+
+	// TimeOrdered is set if Samples are in the order they were recorded,
+	// for a flame chart. Only consecutive samples with the same stack are
+	// combined when the profile is merged or filtered.
+	TimeOrdered bool
+
+	// Duration is how long the profile was collected for, or 0 if unknown,
+	// such as for heap profiles which are a snapshot.
+	Duration time.Duration
+
+	// Period is the time between CPU samples, or 0 if unknown or the
+	// profile is not a CPU profile.
+	Period time.Duration
+}
+
+// Mapping represents a binary or shared library mapped into the profiled process.
+type Mapping struct {
+	Start   uint64
+	Limit   uint64
+	Offset  uint64
+	File    string
+	BuildID string
 }
 
 // Sample represents the sample count for a specific call stack.
@@ -41,6 +68,10 @@ type Sample struct {
 	// Funcs is parent first.
 	Funcs  []string
 	Counts []int64
+
+	// Labels are the labels of the sample, if any. Samples with the same
+	// stack but different labels are kept separate.
+	Labels Labels
 }
 
 // NewProfile returns a new profile with the specified sample names.
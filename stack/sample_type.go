@@ -0,0 +1,109 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import "strings"
+
+// SampleType is the type of the values of a sample, such as cpu in
+// nanoseconds, parsed from a sample name such as cpu/nanoseconds.
+type SampleType struct {
+	Name string
+	Unit string
+}
+
+// sampleNameAliases maps the names that some versions of Go and pprof use
+// for a sample type to the name used by current versions.
+var sampleNameAliases = map[string]string{
+	"sample":      "samples",
+	"contention":  "contentions",
+	"total_delay": "delay",
+	"inuse":       "inuse_space",
+	"alloc":       "alloc_space",
+}
+
+// sampleUnitAliases maps abbreviated or singular units to their canonical
+// names.
+var sampleUnitAliases = map[string]string{
+	"":           "count",
+	"ns":         "nanoseconds",
+	"nanosecond": "nanoseconds",
+	"b":          "bytes",
+	"byte":       "bytes",
+}
+
+// ParseSampleType parses a sample name, which is name/unit, or only a name
+// if the unit is a count. Known aliases of names and units are normalized,
+// so the same sample type has the same name across Go and pprof versions.
+func ParseSampleType(s string) SampleType {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return SampleType{}
+	}
+	t := SampleType{Name: s}
+	if idx := strings.LastIndex(s, "/"); idx >= 0 {
+		t.Name, t.Unit = s[:idx], s[idx+1:]
+	}
+	if alias, ok := sampleNameAliases[t.Name]; ok {
+		t.Name = alias
+	}
+	if alias, ok := sampleUnitAliases[t.Unit]; ok {
+		t.Unit = alias
+	}
+	return t
+}
+
+// ParseSampleTypes parses each of the sample names. See ParseSampleType.
+func ParseSampleTypes(names []string) []SampleType {
+	types := make([]SampleType, len(names))
+	for i, name := range names {
+		types[i] = ParseSampleType(name)
+	}
+	return types
+}
+
+// String returns the sample name of t, name/unit.
+func (t SampleType) String() string {
+	if t.Name == "" {
+		return ""
+	}
+	return t.Name + "/" + t.Unit
+}
+
+// CountName returns what the values of the sample type count, for labeling
+// them: the unit, such as bytes, or for counts, the kind of thing counted,
+// such as samples, objects or goroutines.
+func (t SampleType) CountName() string {
+	switch {
+	case t.Unit != "count" && t.Unit != "":
+		return t.Unit
+	case strings.HasSuffix(t.Name, "_objects"):
+		return "objects"
+	case t.Name == "" || strings.HasSuffix(t.Name, "s"):
+		return t.Name
+	}
+	return t.Name + "s"
+}
+
+// SampleTypes returns the types of the profile's samples, in the same
+// order as SampleNames.
+func (p *Profile) SampleTypes() []SampleType {
+	return ParseSampleTypes(p.SampleNames)
+}
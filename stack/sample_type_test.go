@@ -0,0 +1,72 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSampleType(t *testing.T) {
+	tests := []struct {
+		name string
+		want SampleType
+	}{
+		{"cpu/nanoseconds", SampleType{"cpu", "nanoseconds"}},
+		{"samples/count", SampleType{"samples", "count"}},
+		{"inuse_space/bytes", SampleType{"inuse_space", "bytes"}},
+		{"contention/count", SampleType{"contentions", "count"}},
+		{"total_delay/ns", SampleType{"delay", "nanoseconds"}},
+		{"Alloc_Space/B", SampleType{"alloc_space", "bytes"}},
+		{"samples", SampleType{"samples", "count"}},
+		{"delay/cycles", SampleType{"delay", "cycles"}},
+		{"", SampleType{}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ParseSampleType(tt.name), "ParseSampleType(%q)", tt.name)
+	}
+}
+
+func TestSampleTypeString(t *testing.T) {
+	assert.Equal(t, "delay/nanoseconds", ParseSampleType("total_delay/ns").String())
+	assert.Equal(t, "", SampleType{}.String())
+}
+
+func TestSampleTypeCountName(t *testing.T) {
+	tests := map[string]string{
+		"samples/count":       "samples",
+		"cpu/nanoseconds":     "nanoseconds",
+		"alloc_space/bytes":   "bytes",
+		"inuse_objects/count": "objects",
+		"contentions/count":   "contentions",
+		"goroutine/count":     "goroutines",
+		"":                    "",
+	}
+	for name, want := range tests {
+		assert.Equal(t, want, ParseSampleType(name).CountName(), "CountName of %q", name)
+	}
+}
+
+func TestProfileSampleTypes(t *testing.T) {
+	p := &Profile{SampleNames: []string{"contentions/count", "delay/nanoseconds"}}
+	assert.Equal(t, []SampleType{{"contentions", "count"}, {"delay", "nanoseconds"}}, p.SampleTypes())
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"fmt"
+	"math"
+)
+
+// z95 is the z-score for a 95% confidence interval.
+const z95 = 1.96
+
+// insignificantFrame replaces frames that are not statistically significant.
+const insignificantFrame = "[insignificant]"
+
+// SamplingErrorOptions control how ApplySamplingError shows the sampling
+// error of frames.
+type SamplingErrorOptions struct {
+	// AnnotateBelow is the number of samples below which frames are
+	// annotated with an estimate of their sampling error.
+	AnnotateBelow int64
+
+	// HideInsignificant replaces frames whose number of samples is too small
+	// to be distinguished from zero with a single [insignificant] frame.
+	HideInsignificant bool
+}
+
+// Enabled returns whether the options change the profile.
+func (opts SamplingErrorOptions) Enabled() bool {
+	return opts.AnnotateBelow > 0 || opts.HideInsignificant
+}
+
+// SamplingError returns the half-width of the 95% confidence interval of
+// the fraction of samples, count out of total, using the normal
+// approximation of the binomial distribution.
+func SamplingError(count, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	p := float64(count) / float64(total)
+	return z95 * math.Sqrt(p*(1-p)/float64(total))
+}
+
+// ApplySamplingError returns a copy of the profile with frames annotated
+// with, or hidden based on, their sampling error. The number of samples of
+// each frame is read from the values at countIdx, which should be a count
+// of samples rather than a weighted value such as CPU time.
+func ApplySamplingError(p *Profile, countIdx int, opts SamplingErrorOptions) (*Profile, error) {
+	// Frames are identified by their stack, as in the flame graph.
+	var total int64
+	frameCounts := make(map[string]int64)
+	for _, s := range p.Samples {
+		count := s.Counts[countIdx]
+		total += count
+
+		key := ""
+		for _, f := range s.Funcs {
+			key += ";" + f
+			frameCounts[key] += count
+		}
+	}
+
+	result := &Profile{
+		SampleNames: p.SampleNames,
+		Samples:     make([]*Sample, 0, len(p.Samples)),
+		Mappings:    p.Mappings,
+		TimeOrdered: p.TimeOrdered,
+		Duration:    p.Duration,
+		Period:      p.Period,
+	}
+	for _, s := range p.Samples {
+		funcs := make([]string, 0, len(s.Funcs))
+		key := ""
+		for _, f := range s.Funcs {
+			key += ";" + f
+			count := frameCounts[key]
+			samplingErr := SamplingError(count, total)
+
+			if opts.HideInsignificant && float64(count)/float64(total) <= samplingErr {
+				funcs = append(funcs, insignificantFrame)
+				break
+			}
+			if count < opts.AnnotateBelow {
+				f = fmt.Sprintf("%v [%v samples, ±%.1f%%]", f, count, samplingErr*100)
+			}
+			funcs = append(funcs, f)
+		}
+		result.Samples = append(result.Samples, &Sample{Funcs: funcs, Counts: s.Counts, Labels: s.Labels})
+	}
+
+	// Merge combines the samples of hidden frames, and copies the counts.
+	return Merge(result)
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSamplingError(t *testing.T) {
+	assert.Equal(t, 0.0, SamplingError(0, 0))
+	assert.Equal(t, 0.0, SamplingError(100, 100))
+	assert.InDelta(t, 0.0195, SamplingError(1, 100), 0.0001)
+	assert.InDelta(t, 0.098, SamplingError(50, 100), 0.0001)
+	assert.InDelta(t, 0.0098, SamplingError(5000, 10000), 0.0001)
+}
+
+func TestApplySamplingError(t *testing.T) {
+	names := []string{"samples/count", "cpu/nanoseconds"}
+	p := &Profile{
+		SampleNames: names,
+		Samples: []*Sample{
+			{Funcs: []string{"main", "big"}, Counts: []int64{897, 8970}},
+			{Funcs: []string{"main", "medium"}, Counts: []int64{97, 970}},
+			{Funcs: []string{"main", "tiny", "a"}, Counts: []int64{5, 50}},
+			{Funcs: []string{"main", "small", "b"}, Counts: []int64{1, 10}},
+		},
+	}
+
+	tests := []struct {
+		opts SamplingErrorOptions
+		want [][]string
+	}{
+		{
+			opts: SamplingErrorOptions{AnnotateBelow: 100},
+			want: [][]string{
+				{"main", "big"},
+				{"main", "medium [97 samples, ±1.8%]"},
+				{"main", "tiny [5 samples, ±0.4%]", "a [5 samples, ±0.4%]"},
+				{"main", "small [1 samples, ±0.2%]", "b [1 samples, ±0.2%]"},
+			},
+		},
+		{
+			opts: SamplingErrorOptions{HideInsignificant: true},
+			want: [][]string{
+				{"main", "big"},
+				{"main", "medium"},
+				{"main", "tiny", "a"},
+				{"main", "[insignificant]"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		result, err := ApplySamplingError(p, 0, tt.opts)
+		require.NoError(t, err, "ApplySamplingError failed")
+
+		var got [][]string
+		for _, s := range result.Samples {
+			got = append(got, s.Funcs)
+		}
+		assert.Equal(t, tt.want, got, "ApplySamplingError(%+v)", tt.opts)
+	}
+	assert.Equal(t, []string{"main", "small", "b"}, p.Samples[3].Funcs, "ApplySamplingError should not modify its input")
+}
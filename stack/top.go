@@ -0,0 +1,152 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import "sort"
+
+// FuncTotal is the number of samples for a function.
+type FuncTotal struct {
+	Func string
+	// Flat is the count of samples where Func is the leaf function, and Cum
+	// is the count of samples that include Func anywhere in the stack.
+	Flat int64
+	Cum  int64
+}
+
+// Top returns the flat and cumulative counts at sampleIdx for each function
+// in the profile, with the highest flat count first. Recursive functions are
+// only counted once per sample.
+func Top(p *Profile, sampleIdx int) []FuncTotal {
+	var totals []FuncTotal
+	byFunc := make(map[string]int)
+	// lastSample is the last sample that a function was counted for, so that
+	// recursive functions are not counted again.
+	var lastSample []int
+	for i, s := range p.Samples {
+		count := s.Counts[sampleIdx]
+		for j, f := range s.Funcs {
+			idx, ok := byFunc[f]
+			if !ok {
+				idx = len(totals)
+				byFunc[f] = idx
+				totals = append(totals, FuncTotal{Func: f})
+				lastSample = append(lastSample, -1)
+			}
+			if lastSample[idx] != i {
+				lastSample[idx] = i
+				totals[idx].Cum += count
+			}
+			if j == len(s.Funcs)-1 {
+				totals[idx].Flat += count
+			}
+		}
+	}
+
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Flat != totals[j].Flat {
+			return totals[i].Flat > totals[j].Flat
+		}
+		if totals[i].Cum != totals[j].Cum {
+			return totals[i].Cum > totals[j].Cum
+		}
+		return totals[i].Func < totals[j].Func
+	})
+	return totals
+}
+
+// GroupTotal is the number of samples for a group of functions, such as the
+// functions of a package.
+type GroupTotal struct {
+	Group string
+	// Self is the count of samples where the leaf function is in Group, and
+	// Cum is the count of samples that include any function in Group.
+	Self int64
+	Cum  int64
+}
+
+// TopGroups returns the self and cumulative counts at sampleIdx for each
+// group returned by groupOf for the functions in the profile, with the
+// highest self count first. Groups are only counted once per sample.
+func TopGroups(p *Profile, sampleIdx int, groupOf func(fn string) string) []GroupTotal {
+	funcGroups := make(map[string]string)
+	cachedGroupOf := func(f string) string {
+		group, ok := funcGroups[f]
+		if !ok {
+			group = groupOf(f)
+			funcGroups[f] = group
+		}
+		return group
+	}
+
+	byGroup := make(map[string]*GroupTotal)
+	total := func(group string) *GroupTotal {
+		t, ok := byGroup[group]
+		if !ok {
+			t = &GroupTotal{Group: group}
+			byGroup[group] = t
+		}
+		return t
+	}
+	for _, s := range p.Samples {
+		count := s.Counts[sampleIdx]
+		seen := make(map[string]bool)
+		for _, f := range s.Funcs {
+			if group := cachedGroupOf(f); !seen[group] {
+				seen[group] = true
+				total(group).Cum += count
+			}
+		}
+		if len(s.Funcs) > 0 {
+			total(cachedGroupOf(s.Funcs[len(s.Funcs)-1])).Self += count
+		}
+	}
+
+	totals := make([]GroupTotal, 0, len(byGroup))
+	for _, t := range byGroup {
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Self != totals[j].Self {
+			return totals[i].Self > totals[j].Self
+		}
+		if totals[i].Cum != totals[j].Cum {
+			return totals[i].Cum > totals[j].Cum
+		}
+		return totals[i].Group < totals[j].Group
+	})
+	return totals
+}
+
+// SortByCum sorts totals by their cumulative count, highest first, rather
+// than by their flat count.
+func SortByCum(totals []FuncTotal) {
+	sort.SliceStable(totals, func(i, j int) bool {
+		return totals[i].Cum > totals[j].Cum
+	})
+}
+
+// SortGroupsByCum sorts totals by their cumulative count, highest first,
+// rather than by their self count.
+func SortGroupsByCum(totals []GroupTotal) {
+	sort.SliceStable(totals, func(i, j int) bool {
+		return totals[i].Cum > totals[j].Cum
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTop(t *testing.T) {
+	p := &Profile{
+		SampleNames: []string{"samples/count", "cpu/nanoseconds"},
+		Samples: []*Sample{
+			{Funcs: []string{"main", "a"}, Counts: []int64{5, 50}},
+			{Funcs: []string{"main", "b", "a"}, Counts: []int64{3, 30}},
+			{Funcs: []string{"main", "b", "b"}, Counts: []int64{4, 10}},
+			{Funcs: []string{"main"}, Counts: []int64{1, 10}},
+		},
+	}
+
+	assert.Equal(t, []FuncTotal{
+		{Func: "a", Flat: 8, Cum: 8},
+		{Func: "b", Flat: 4, Cum: 7},
+		{Func: "main", Flat: 1, Cum: 13},
+	}, Top(p, 0))
+	assert.Equal(t, []FuncTotal{
+		{Func: "a", Flat: 80, Cum: 80},
+		{Func: "main", Flat: 10, Cum: 100},
+		{Func: "b", Flat: 10, Cum: 40},
+	}, Top(p, 1), "ties should be ordered by cumulative count")
+	assert.Empty(t, Top(&Profile{SampleNames: p.SampleNames}, 0))
+
+	totals := Top(p, 0)
+	SortByCum(totals)
+	assert.Equal(t, []FuncTotal{
+		{Func: "main", Flat: 1, Cum: 13},
+		{Func: "a", Flat: 8, Cum: 8},
+		{Func: "b", Flat: 4, Cum: 7},
+	}, totals)
+}
+
+func TestTopGroups(t *testing.T) {
+	p := &Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*Sample{
+			{Funcs: []string{"main.run", "a.F", "a.G"}, Counts: []int64{5}},
+			{Funcs: []string{"main.run", "b.F", "a.F"}, Counts: []int64{3}},
+			{Funcs: []string{"main.run", "b.F"}, Counts: []int64{2}},
+		},
+	}
+	pkg := func(fn string) string {
+		return strings.SplitN(fn, ".", 2)[0]
+	}
+
+	assert.Equal(t, []GroupTotal{
+		{Group: "a", Self: 8, Cum: 8},
+		{Group: "b", Self: 2, Cum: 5},
+		{Group: "main", Self: 0, Cum: 10},
+	}, TopGroups(p, 0, pkg))
+
+	totals := TopGroups(p, 0, pkg)
+	SortGroupsByCum(totals)
+	assert.Equal(t, []GroupTotal{
+		{Group: "main", Self: 0, Cum: 10},
+		{Group: "a", Self: 8, Cum: 8},
+		{Group: "b", Self: 2, Cum: 5},
+	}, totals)
+}
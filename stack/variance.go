@@ -0,0 +1,90 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"math"
+	"sort"
+)
+
+// FuncVariance is how much the fraction of samples that include a function
+// varies across profiles, such as the profiles of each instance of a
+// service.
+type FuncVariance struct {
+	Func string
+	// Shares are the fraction of samples, between 0 and 1, that include Func
+	// in each profile.
+	Shares []float64
+	Mean   float64
+	StdDev float64
+}
+
+// Outlier returns the index of the profile whose share is furthest from
+// the mean.
+func (v FuncVariance) Outlier() int {
+	outlier := 0
+	for i, share := range v.Shares {
+		if math.Abs(share-v.Mean) > math.Abs(v.Shares[outlier]-v.Mean) {
+			outlier = i
+		}
+	}
+	return outlier
+}
+
+// Variance compares the fraction of samples at sampleIdx that include each
+// function across the profiles, and returns the functions whose fraction
+// varies, largest standard deviation first.
+func Variance(profiles []*Profile, sampleIdx int) []FuncVariance {
+	shares := make([]map[string]float64, len(profiles))
+	funcs := make(map[string]bool)
+	for i, p := range profiles {
+		shares[i] = funcShares(p, sampleIdx)
+		for f := range shares[i] {
+			funcs[f] = true
+		}
+	}
+
+	var variance []FuncVariance
+	for f := range funcs {
+		v := FuncVariance{Func: f, Shares: make([]float64, len(profiles))}
+		for i := range profiles {
+			v.Shares[i] = shares[i][f]
+			v.Mean += v.Shares[i]
+		}
+		v.Mean /= float64(len(profiles))
+		for _, share := range v.Shares {
+			v.StdDev += (share - v.Mean) * (share - v.Mean)
+		}
+		v.StdDev = math.Sqrt(v.StdDev / float64(len(profiles)))
+		// Ignore rounding errors in functions with the same share in every profile.
+		if v.StdDev > 1e-9 {
+			variance = append(variance, v)
+		}
+	}
+
+	sort.Slice(variance, func(i, j int) bool {
+		if variance[i].StdDev != variance[j].StdDev {
+			return variance[i].StdDev > variance[j].StdDev
+		}
+		return variance[i].Func < variance[j].Func
+	})
+	return variance
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVariance(t *testing.T) {
+	names := []string{"samples/count"}
+	profile := func(a, b, c int64) *Profile {
+		return &Profile{
+			SampleNames: names,
+			Samples: []*Sample{
+				{Funcs: []string{"main", "a"}, Counts: []int64{a}},
+				{Funcs: []string{"main", "b"}, Counts: []int64{b}},
+				{Funcs: []string{"main", "c"}, Counts: []int64{c}},
+			},
+		}
+	}
+	// The third profile spends most of its time in c, e.g. a hot shard.
+	profiles := []*Profile{profile(40, 40, 20), profile(40, 40, 20), profile(30, 10, 60)}
+
+	variance := Variance(profiles, 0)
+	require.Len(t, variance, 3, "main has the same share in every profile")
+	var funcs []string
+	for _, v := range variance {
+		funcs = append(funcs, v.Func)
+	}
+	assert.Equal(t, []string{"c", "b", "a"}, funcs, "functions should be sorted by standard deviation")
+
+	c := variance[0]
+	assert.Equal(t, []float64{0.2, 0.2, 0.6}, c.Shares)
+	assert.InDelta(t, 0.3333, c.Mean, 1e-4)
+	assert.InDelta(t, 0.1886, c.StdDev, 1e-4)
+	assert.Equal(t, 2, c.Outlier())
+}
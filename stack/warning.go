@@ -0,0 +1,90 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import "fmt"
+
+// WarningKind identifies the type of a Warning.
+type WarningKind int
+
+const (
+	// SkippedLine is reported when an input line is not understood, or only
+	// partially used, and is skipped.
+	SkippedLine WarningKind = iota + 1
+	// MissingFunction is reported when a stack refers to a location that has
+	// no function name.
+	MissingFunction
+	// EmptyStack is reported when a sample has no frames and is not rendered.
+	EmptyStack
+	// BinaryMismatch is reported when the binary used to symbolize a profile
+	// does not appear to match the profile.
+	BinaryMismatch
+	// MissingFunctionSamples is reported once for a profile with missing
+	// functions, with how many samples have frames without a function name.
+	MissingFunctionSamples
+	// NoLineInformation is reported when frames are named by their source
+	// file or line, but the profile has no line information, so they are
+	// named by their function.
+	NoLineInformation
+)
+
+var warningKindNames = map[WarningKind]string{
+	SkippedLine:     "skipped line",
+	MissingFunction: "missing function",
+	EmptyStack:      "empty stack",
+	BinaryMismatch:  "binary mismatch",
+
+	MissingFunctionSamples: "samples with missing functions",
+	NoLineInformation:      "no line information",
+}
+
+func (k WarningKind) String() string {
+	if name, ok := warningKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("WarningKind(%d)", int(k))
+}
+
+// Warning is a non-fatal problem found while processing a profile. The
+// output is still usable, but may be incomplete.
+type Warning struct {
+	Kind WarningKind
+	// Detail is the input line, function or binary the warning refers to.
+	Detail string
+	// Message is a human readable description of the problem.
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%v: %v", w.Kind, w.Message)
+}
+
+// WarningFunc is called with each warning as it is found. A nil WarningFunc
+// discards warnings.
+type WarningFunc func(Warning)
+
+// Warn calls f with the given warning if f is not nil.
+func (f WarningFunc) Warn(kind WarningKind, detail, format string, args ...interface{}) {
+	if f == nil {
+		return
+	}
+	f(Warning{Kind: kind, Detail: detail, Message: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarningFunc(t *testing.T) {
+	var got []Warning
+	var f WarningFunc = func(w Warning) { got = append(got, w) }
+
+	f.Warn(MissingFunction, "missing-function-2", "no function name for location %v", 2)
+	assert.Equal(t, []Warning{{
+		Kind:    MissingFunction,
+		Detail:  "missing-function-2",
+		Message: "no function name for location 2",
+	}}, got)
+	assert.Equal(t, "missing function: no function name for location 2", got[0].String())
+
+	var nilFunc WarningFunc
+	assert.NotPanics(t, func() { nilFunc.Warn(SkippedLine, "", "ignored") }, "nil WarningFunc should discard warnings")
+}
+
+func TestWarningKindString(t *testing.T) {
+	assert.Equal(t, "skipped line", SkippedLine.String())
+	assert.Equal(t, "WarningKind(100)", WarningKind(100).String())
+}
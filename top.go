@@ -0,0 +1,109 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/uber/go-torch/renderer"
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
+)
+
+// printTop writes a table of the n functions in the result's profile with
+// the most samples, sorted by cumulative samples if byCum is set.
+func printTop(w io.Writer, result *torch.Result, n int, byCum bool, msgs *messages) error {
+	profile, err := resultProfile(result)
+	if err != nil {
+		return err
+	}
+	return writeTop(w, profile, result.SampleIndex, n, byCum, msgs)
+}
+
+// resultProfile returns the result's profile, parsing the flame graph input
+// if there is no profile, as for --folded-input.
+func resultProfile(result *torch.Result) (*stack.Profile, error) {
+	if result.Profile != nil {
+		return result.Profile, nil
+	}
+	profile, err := renderer.ParseFlameInput(result.FlameInput)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse flame graph input: %v", err)
+	}
+	return profile, nil
+}
+
+// writeTop writes the flat and cumulative counts of the n functions with the
+// highest flat count, or the highest cumulative count if byCum is set,
+// similar to pprof's top command.
+func writeTop(w io.Writer, profile *stack.Profile, sampleIdx, n int, byCum bool, msgs *messages) error {
+	totals := stack.Top(profile, sampleIdx)
+	if byCum {
+		stack.SortByCum(totals)
+	}
+	var total int64
+	for _, s := range profile.Samples {
+		total += s.Counts[sampleIdx]
+	}
+	if n > len(totals) {
+		n = len(totals)
+	}
+
+	if _, err := fmt.Fprintf(w, msgs.topHeading+"\n",
+		n, len(totals), profile.SampleNames[sampleIdx], total); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%12s %7s %7s %12s %7s\n", msgs.flat, msgs.flat+"%", msgs.sum+"%", msgs.cum, msgs.cum+"%"); err != nil {
+		return err
+	}
+	var sum int64
+	for _, t := range totals[:n] {
+		sum += t.Flat
+		if _, err := fmt.Fprintf(w, "%12d %6.2f%% %6.2f%% %12d %6.2f%%  %v\n",
+			t.Flat, percent(t.Flat, total), percent(sum, total), t.Cum, percent(t.Cum, total), t.Func); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func percent(count, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) * 100 / float64(total)
+}
+
+// selfNotes returns a note for each function in the profile with its flat
+// count, for --show-self. A flame graph frame only shows the cumulative
+// count of one stack, so the note is the total for the function.
+func selfNotes(profile *stack.Profile, sampleIdx int, msgs *messages) map[string]string {
+	var total int64
+	for _, s := range profile.Samples {
+		total += s.Counts[sampleIdx]
+	}
+	notes := make(map[string]string)
+	for _, t := range stack.Top(profile, sampleIdx) {
+		notes[t.Func] = fmt.Sprintf(msgs.selfNote, t.Flat, percent(t.Flat, total))
+	}
+	return notes
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torch"
+)
+
+func TestWriteTop(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main", "a"}, Counts: []int64{6}},
+			{Funcs: []string{"main", "b", "a"}, Counts: []int64{3}},
+			{Funcs: []string{"main"}, Counts: []int64{1}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeTop(&buf, profile, 0, 2, false, english); err != nil {
+		t.Fatalf("writeTop failed: %v", err)
+	}
+
+	expected := "Showing top 2 of 3 functions by samples/count, 10 total\n" +
+		"        flat   flat%    sum%          cum    cum%\n" +
+		"           9  90.00%  90.00%            9  90.00%  a\n" +
+		"           1  10.00% 100.00%           10 100.00%  main\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected table, got:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+
+	buf.Reset()
+	if err := writeTop(&buf, profile, 0, 2, true, english); err != nil {
+		t.Fatalf("writeTop failed: %v", err)
+	}
+	expected = "Showing top 2 of 3 functions by samples/count, 10 total\n" +
+		"        flat   flat%    sum%          cum    cum%\n" +
+		"           1  10.00%  10.00%           10 100.00%  main\n" +
+		"           9  90.00% 100.00%            9  90.00%  a\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected table sorted by cum, got:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+}
+
+func TestPrintTopFoldedInput(t *testing.T) {
+	result := &torch.Result{FlameInput: []byte("main;a 3\nmain;b 1\n")}
+
+	var buf bytes.Buffer
+	if err := printTop(&buf, result, 10, false, english); err != nil {
+		t.Fatalf("printTop failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Showing top 3 of 3 functions") || !strings.Contains(buf.String(), "  75.00%  a\n") {
+		t.Errorf("Unexpected table, got:\n%s", buf.String())
+	}
+
+	result.FlameInput = []byte("bad input")
+	if err := printTop(&buf, result, 10, false, english); err == nil {
+		t.Errorf("printTop with bad flame graph input expected to fail")
+	}
+}
+
+func TestRunTop(t *testing.T) {
+	opts := getDefaultOptions()
+	opts.OutputOpts.Top = 5
+	opts.OutputOpts.File = ""
+
+	if err := runWithOptions(opts, nil); err != nil {
+		t.Fatalf("Run with --top failed: %v", err)
+	}
+
+	// With an output file, the flame graph is written as well.
+	withScriptsInPath(t, func() {
+		opts.OutputOpts.File = getTempFilename(t, ".svg")
+		defer os.Remove(opts.OutputOpts.File)
+		if err := runWithOptions(opts, nil); err != nil {
+			t.Fatalf("Run with --top and --file failed: %v", err)
+		}
+		if _, err := ioutil.ReadFile(opts.OutputOpts.File); err != nil {
+			t.Errorf("Failed to read output file: %v", err)
+		}
+	})
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package torch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber/go-torch/pprof"
+	"github.com/uber/go-torch/stack"
+)
+
+var (
+	errMergeNoSources = errors.New("merge requires at least one profile source")
+	errDiffMerge      = errors.New("merge cannot be used with a second base URL")
+)
+
+// source is a single profile to fetch using pprof.
+type source struct {
+	opts      pprof.Options
+	remaining []string
+}
+
+// getSources returns the profiles to fetch for the given options.
+func getSources(opts Options) ([]source, error) {
+	if opts.PProf.BaseURL2 != "" {
+		if opts.PProf.Merge {
+			return nil, errDiffMerge
+		}
+		// The base profile is first, followed by the profile it is compared against.
+		current := opts.PProf
+		current.BaseURL2 = ""
+		return []source{
+			{opts: opts.PProf.ForSource(opts.PProf.BaseURL2)},
+			{current, opts.Remaining},
+		}, nil
+	}
+	if !opts.PProf.Merge {
+		return []source{{opts.PProf, opts.Remaining}}, nil
+	}
+
+	if len(opts.Remaining) == 0 {
+		return nil, errMergeNoSources
+	}
+	sources := make([]source, len(opts.Remaining))
+	for i, s := range opts.Remaining {
+		if strings.HasPrefix(s, "-") {
+			return nil, fmt.Errorf("merge sources cannot be flags, use --pprofArgs to pass %v to pprof", s)
+		}
+		sources[i] = source{opts: opts.PProf.ForSource(s)}
+	}
+	return sources, nil
+}
+
+// fetch runs pprof for all sources, and parses the raw output of each
+// source as pprof writes it, so the raw output is never held in memory.
+func fetch(ctx context.Context, opts Options, stats *Stats) ([]source, []*stack.Profile, error) {
+	sources, err := getSources(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Each source is parsed in its own goroutine.
+	opts.OnWarning = lockWarnings(opts.OnWarning)
+
+	start := time.Now()
+	if opts.ExcludeFirst > 0 {
+		discard := func(_ int, r io.Reader) error {
+			_, err := io.Copy(ioutil.Discard, r)
+			return err
+		}
+		if err := fetchAll(ctx, warmUpSources(sources, opts.ExcludeFirst), opts.Concurrency, nil, discard); err != nil {
+			return nil, nil, fmt.Errorf("could not fetch warm-up profile: %v", err)
+		}
+	}
+
+	profiles := make([]*stack.Profile, len(sources))
+	raws := make([]*rawReader, len(sources))
+	err = fetchAll(ctx, sources, opts.Concurrency, opts.OnWarning, func(i int, r io.Reader) error {
+		raws[i] = &rawReader{r: r}
+		p, err := pprof.ParseRawReader(raws[i], pprof.ParseOptions{
+			OnWarning:        opts.OnWarning,
+			Limits:           opts.Limits,
+			Focus:            opts.Focus,
+			Granularity:      opts.Granularity,
+			MissingFunctions: opts.MissingFunctions,
+			NoInlines:        opts.NoInlines,
+		})
+		raws[i].done()
+		if err != nil {
+			return parseError{err}
+		}
+		profiles[i] = p
+		return nil
+	})
+	stats.FetchDuration = time.Since(start)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, raw := range raws {
+		stats.RawBytes += raw.n
+		stats.ParseDuration += raw.parseDuration
+	}
+	return sources, profiles, nil
+}
+
+// lockWarnings returns a WarningFunc that calls f while holding a lock, so
+// that f is not called concurrently by the sources that are parsed at once.
+func lockWarnings(f stack.WarningFunc) stack.WarningFunc {
+	if f == nil {
+		return nil
+	}
+	var mu sync.Mutex
+	return func(w stack.Warning) {
+		mu.Lock()
+		defer mu.Unlock()
+		f(w)
+	}
+}
+
+// rawReader reads the raw output of pprof for the parser, and measures the
+// time spent parsing it, which excludes the time waiting for pprof.
+type rawReader struct {
+	r             io.Reader
+	n             int
+	last          time.Time
+	parseDuration time.Duration
+}
+
+func (r *rawReader) Read(b []byte) (int, error) {
+	r.done()
+	n, err := r.r.Read(b)
+	r.n += n
+	r.last = time.Now()
+	return n, err
+}
+
+// done adds the time since the last read to the parse duration.
+func (r *rawReader) done() {
+	if !r.last.IsZero() {
+		r.parseDuration += time.Since(r.last)
+	}
+	r.last = time.Time{}
+}
+
+// parseError is an error parsing the raw output of pprof, rather than an
+// error running pprof.
+type parseError struct {
+	err error
+}
+
+func (e parseError) Error() string {
+	return fmt.Sprintf("could not parse raw pprof output: %v", e.err)
+}
+
+// warmUpSources returns the sources to fetch and discard before the profile,
+// which are profiled for d rounded up to whole seconds.
+func warmUpSources(sources []source, d time.Duration) []source {
+	seconds := int((d + time.Second - 1) / time.Second)
+	warmUp := make([]source, len(sources))
+	for i, src := range sources {
+		src.opts.TimeSeconds = seconds
+		src.opts.TimeAlias = nil
+		warmUp[i] = src
+	}
+	return warmUp
+}
+
+// parse checks the parsed profile of each source, merging profiles if there
+// are multiple sources. If opts.PProf.BaseURL2 is set, the profile of
+// BaseURL2 is returned as the base profile instead.
+func parse(opts Options, sources []source, profiles []*stack.Profile, stats *Stats) (profile, base *stack.Profile, err error) {
+	start := time.Now()
+	defer func() { stats.ParseDuration += time.Since(start) }()
+
+	for i, src := range sources {
+		if err := pprof.CheckBinary(src.opts, src.remaining, profiles[i], opts.OnWarning); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch {
+	case opts.PProf.BaseURL2 != "":
+		profile, base = profiles[1], profiles[0]
+	case len(profiles) == 1:
+		profile = profiles[0]
+	default:
+		if profile, err = stack.Merge(profiles...); err != nil {
+			return nil, nil, err
+		}
+	}
+	if opts.Focus.Enabled() && len(profile.Samples) == 0 {
+		return nil, nil, stack.ErrNoFocusedSamples
+	}
+	return profile, base, nil
+}
+
+// fetchAll runs pprof for all sources concurrently, running at most
+// concurrency at once unless it is 0, and calls read with the index of each
+// source and its raw output.
+func fetchAll(ctx context.Context, sources []source, concurrency int, onWarning stack.WarningFunc, read func(int, io.Reader) error) error {
+	if concurrency <= 0 {
+		concurrency = len(sources)
+	}
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(sources))
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src source) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = pprof.StreamRaw(ctx, src.opts, src.remaining, onWarning, func(r io.Reader) error {
+				return read(i, r)
+			})
+		}(i, src)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if _, ok := err.(parseError); ok {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("could not get raw output from pprof: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package torch
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/pprof"
+	"github.com/uber/go-torch/stack"
+)
+
+func TestGenerateMerge(t *testing.T) {
+	single, err := Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile},
+		SkipRender: true,
+	})
+	require.NoError(t, err, "Generate failed")
+
+	merged, err := Generate(Options{
+		PProf:      pprof.Options{Merge: true},
+		Remaining:  []string{testPProfInputFile, testPProfInputFile},
+		SkipRender: true,
+	})
+	require.NoError(t, err, "Generate with merge failed")
+
+	assert.Equal(t, single.Stats.Stacks, merged.Stats.Stacks, "merging identical profiles should not add stacks")
+	assert.Equal(t, 2*single.Stats.SampleTotal, merged.Stats.SampleTotal, "merged sample total")
+	assert.Equal(t, 2*single.Stats.RawBytes, merged.Stats.RawBytes, "merged raw bytes")
+}
+
+func TestGenerateMergeConcurrency(t *testing.T) {
+	merged, err := Generate(Options{
+		PProf:       pprof.Options{Merge: true},
+		Remaining:   []string{testPProfInputFile, testPProfInputFile, testPProfInputFile},
+		Concurrency: 1,
+		SkipRender:  true,
+	})
+	require.NoError(t, err, "Generate with merge failed")
+
+	single, err := Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile},
+		SkipRender: true,
+	})
+	require.NoError(t, err, "Generate failed")
+	assert.Equal(t, 3*single.Stats.SampleTotal, merged.Stats.SampleTotal, "merged sample total")
+}
+
+func TestGenerateMergeWarnings(t *testing.T) {
+	// OnWarning is not synchronized, so the race detector reports it if
+	// the sources call it concurrently.
+	warnings := make(map[stack.WarningKind]int)
+	_, err := Generate(Options{
+		PProf:       pprof.Options{Merge: true},
+		Remaining:   []string{testPProfInputFile, testPProfInputFile, testPProfInputFile, testPProfInputFile},
+		Granularity: stack.LineGranularity,
+		SkipRender:  true,
+		OnWarning: func(w stack.Warning) {
+			warnings[w.Kind]++
+		},
+	})
+	require.NoError(t, err, "Generate with merge failed")
+	assert.Equal(t, 4, warnings[stack.NoLineInformation], "each source should warn that it has no line information")
+}
+
+func TestGenerateParseError(t *testing.T) {
+	_, err := Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile},
+		Limits:     pprof.Limits{MaxSamples: 1},
+		SkipRender: true,
+	})
+	require.Error(t, err, "Generate should fail when the output exceeds a limit")
+	assert.Contains(t, err.Error(), "could not parse raw pprof output: input exceeds MaxSamples of 1")
+}
+
+func TestWarmUpSources(t *testing.T) {
+	alias := 10
+	sources := []source{
+		{opts: pprof.Options{BaseURL: "http://a", TimeSeconds: 30}},
+		{opts: pprof.Options{BaseURL: "http://b", TimeAlias: &alias}, remaining: []string{"-alloc_space"}},
+	}
+	warmUp := warmUpSources(sources, 2500*time.Millisecond)
+	expected := []source{
+		{opts: pprof.Options{BaseURL: "http://a", TimeSeconds: 3}},
+		{opts: pprof.Options{BaseURL: "http://b", TimeSeconds: 3}, remaining: []string{"-alloc_space"}},
+	}
+	assert.Equal(t, expected, warmUp)
+	assert.Equal(t, 30, sources[0].opts.TimeSeconds, "sources should not be modified")
+}
+
+func TestGenerateExcludeFirst(t *testing.T) {
+	result, err := Generate(Options{
+		PProf:        pprof.Options{BinaryFile: testPProfInputFile},
+		ExcludeFirst: time.Second,
+		SkipRender:   true,
+	})
+	require.NoError(t, err, "Generate with a warm-up fetch failed")
+	assert.True(t, len(result.Profile.Samples) > 0, "missing samples")
+	assert.True(t, result.Stats.RawBytes > 0, "missing raw bytes")
+}
+
+func TestGetSourcesErrors(t *testing.T) {
+	tests := []struct {
+		remaining []string
+		wantErr   string
+	}{
+		{
+			remaining: nil,
+			wantErr:   errMergeNoSources.Error(),
+		},
+		{
+			remaining: []string{"cpu.prof", "-alloc_space"},
+			wantErr:   "use --pprofArgs to pass -alloc_space",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := getSources(Options{PProf: pprof.Options{Merge: true}, Remaining: tt.remaining})
+		if assert.Error(t, err, "expected error for %v", tt.remaining) {
+			assert.Contains(t, err.Error(), tt.wantErr)
+		}
+	}
+}
+
+func TestGenerateDiff(t *testing.T) {
+	result, err := Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile, BaseURL2: testPProfInputFile},
+		SkipRender: true,
+	})
+	require.NoError(t, err, "Generate with a second base URL failed")
+	require.NotNil(t, result.BaseProfile, "missing base profile")
+
+	// Comparing a profile against itself has the same counts in both columns.
+	lines := strings.Split(strings.TrimSpace(string(result.FlameInput)), "\n")
+	assert.Equal(t, len(result.Profile.Samples), len(lines))
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		require.True(t, len(fields) >= 3, "expected differential input, got %v", line)
+		assert.Equal(t, fields[len(fields)-2], fields[len(fields)-1], "counts should match in %v", line)
+	}
+}
+
+func TestGenerateDiffErrors(t *testing.T) {
+	_, err := Generate(Options{
+		PProf:     pprof.Options{Merge: true, BaseURL2: testPProfInputFile},
+		Remaining: []string{testPProfInputFile},
+	})
+	assert.Equal(t, errDiffMerge, err)
+
+	_, err = Generate(Options{
+		PProf:         pprof.Options{BinaryFile: testPProfInputFile, BaseURL2: testPProfInputFile},
+		SamplingError: stack.SamplingErrorOptions{HideInsignificant: true},
+	})
+	assert.Equal(t, errDiffSamplingError, err)
+}
+
+func TestGetSourcesDiff(t *testing.T) {
+	sources, err := getSources(Options{
+		PProf:     pprof.Options{BaseURL: "http://canary:8080", BaseURL2: "http://production:8080", TimeSeconds: 10},
+		Remaining: []string{"-alloc_space"},
+	})
+	require.NoError(t, err, "getSources failed")
+	require.Len(t, sources, 2)
+
+	assert.Equal(t, "http://production:8080", sources[0].opts.BaseURL, "base profile should be first")
+	assert.Empty(t, sources[0].opts.BaseURL2)
+	assert.Nil(t, sources[0].remaining)
+	assert.Equal(t, "http://canary:8080", sources[1].opts.BaseURL)
+	assert.Empty(t, sources[1].opts.BaseURL2)
+	assert.Equal(t, []string{"-alloc_space"}, sources[1].remaining)
+}
+
+func TestGenerateMergeFetchError(t *testing.T) {
+	_, err := Generate(Options{
+		PProf:     pprof.Options{Merge: true},
+		Remaining: []string{testPProfInputFile, "missing-file"},
+	})
+	if assert.Error(t, err, "expected missing merge source to fail") {
+		assert.Contains(t, err.Error(), "could not get raw output from pprof")
+	}
+}
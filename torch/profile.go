@@ -0,0 +1,108 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package torch
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/uber/go-torch/pprof"
+	"github.com/uber/go-torch/stack"
+)
+
+// Option configures FromProfile.
+type Option func(*Options)
+
+// WithBinary sets the path of the binary that the profile was captured
+// from, which pprof uses to symbolize profiles without symbols.
+func WithBinary(path string) Option {
+	return func(opts *Options) {
+		opts.PProf.BinaryName = path
+	}
+}
+
+// WithPProfArgs adds extra arguments for pprof. Sample selection arguments
+// such as -alloc_space are also used to select the sample to render.
+func WithPProfArgs(args ...string) Option {
+	return func(opts *Options) {
+		opts.PProf.ExtraArgs = append(opts.PProf.ExtraArgs, args...)
+	}
+}
+
+// WithFlameGraphArgs adds arguments for the flame graph script, such as
+// --title or --colors.
+func WithFlameGraphArgs(args ...string) Option {
+	return func(opts *Options) {
+		opts.FlameGraphArgs = append(opts.FlameGraphArgs, args...)
+	}
+}
+
+// WithWarnings sets the function called for each non-fatal problem found
+// in the profile.
+func WithWarnings(f stack.WarningFunc) Option {
+	return func(opts *Options) {
+		opts.OnWarning = f
+	}
+}
+
+// WithLimits sets the limits used when parsing the profile, which should
+// be used when the profile is untrusted.
+func WithLimits(limits pprof.Limits) Option {
+	return func(opts *Options) {
+		opts.Limits = limits
+	}
+}
+
+// FromProfile reads a binary profile (anything accepted by pprof, such as
+// the output of runtime/pprof) from r, and returns the flame graph SVG.
+func FromProfile(r io.Reader, options ...Option) ([]byte, error) {
+	result, err := FromProfileResult(r, options...)
+	if err != nil {
+		return nil, err
+	}
+	return result.FlameGraph, nil
+}
+
+// FromProfileResult is like FromProfile, but returns the full result,
+// including the parsed profile and stats.
+func FromProfileResult(r io.Reader, options ...Option) (*Result, error) {
+	f, err := ioutil.TempFile("", "go-torch-profile")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	// pprof reads profiles from a file, so copy the profile to a temporary file.
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	opts := Options{PProf: pprof.Options{BinaryFile: f.Name()}}
+	for _, option := range options {
+		option(&opts)
+	}
+	return Generate(opts)
+}
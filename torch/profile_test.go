@@ -0,0 +1,88 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package torch
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/pprof"
+	"github.com/uber/go-torch/stack"
+)
+
+func TestFromProfile(t *testing.T) {
+	f, err := os.Open(testPProfInputFile)
+	require.NoError(t, err, "failed to open test profile")
+	defer f.Close()
+
+	withScriptsInPath(t, func() {
+		var warnings []stack.Warning
+		svg, err := FromProfile(f,
+			WithFlameGraphArgs("--title", "from profile"),
+			WithPProfArgs("-sample_index", "1"),
+			WithWarnings(func(w stack.Warning) { warnings = append(warnings, w) }),
+		)
+		require.NoError(t, err, "FromProfile failed")
+		assert.Contains(t, string(svg), "--title from profile\n")
+		assert.Contains(t, string(svg), "main.fib")
+		assert.Empty(t, warnings, "unexpected warnings")
+	})
+}
+
+func TestFromProfileResult(t *testing.T) {
+	f, err := os.Open(testPProfInputFile)
+	require.NoError(t, err, "failed to open test profile")
+	defer f.Close()
+
+	withScriptsInPath(t, func() {
+		result, err := FromProfileResult(f, WithPProfArgs("-sample_index", "1"))
+		require.NoError(t, err, "FromProfileResult failed")
+		assert.Equal(t, 1, result.SampleIndex, "sample should be selected using pprof args")
+		assert.Equal(t, "cpu/nanoseconds", result.Stats.SampleName)
+	})
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestFromProfileErrors(t *testing.T) {
+	_, err := FromProfile(errReader{})
+	assert.Error(t, err, "expected read error to fail")
+
+	_, err = FromProfile(strings.NewReader("not a profile"), WithBinary("/path/to/binary"))
+	assert.Error(t, err, "expected invalid profile to fail")
+
+	f, err := os.Open(testPProfInputFile)
+	require.NoError(t, err, "failed to open test profile")
+	defer f.Close()
+
+	_, err = FromProfile(f, WithLimits(pprof.Limits{MaxStackDepth: 2}))
+	if assert.Error(t, err, "expected limits to be enforced") {
+		assert.Contains(t, err.Error(), "MaxStackDepth")
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package torch
+
+import "fmt"
+
+// Stage is a step of Generate.
+type Stage int
+
+const (
+	// StageFetch is when pprof is run to fetch profiles, and its raw output
+	// is parsed as it is written. For CPU profiles, this takes as long as the
+	// profile duration.
+	StageFetch Stage = iota + 1
+	// StageParse is when the parsed profiles are checked and merged.
+	StageParse
+	// StageRender is when the flame graph is rendered.
+	StageRender
+	// StageDone is when the flame graph is complete.
+	StageDone
+)
+
+var stageNames = map[Stage]string{
+	StageFetch:  "fetch",
+	StageParse:  "parse",
+	StageRender: "render",
+	StageDone:   "done",
+}
+
+func (s Stage) String() string {
+	if name, ok := stageNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Stage(%d)", int(s))
+}
+
+// Progress describes the state of Generate at the start of a stage.
+type Progress struct {
+	Stage Stage
+	// Stats are the stats of the stages that have completed.
+	Stats Stats
+	// Result is the partial result. Its fields are only valid once the
+	// stage that sets them has completed, e.g. Profile is set at StageRender.
+	// It must not be modified.
+	Result *Result
+}
+
+// ProgressFunc is called with the progress of Generate. A nil ProgressFunc
+// ignores progress.
+type ProgressFunc func(Progress)
+
+func (f ProgressFunc) report(stage Stage, result *Result) {
+	if f == nil {
+		return
+	}
+	f(Progress{Stage: stage, Stats: result.Stats, Result: result})
+}
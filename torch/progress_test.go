@@ -0,0 +1,56 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package torch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/pprof"
+)
+
+func TestGenerateProgress(t *testing.T) {
+	var progress []Progress
+	result, err := Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile},
+		SkipRender: true,
+		OnProgress: func(p Progress) { progress = append(progress, p) },
+	})
+	require.NoError(t, err, "Generate failed")
+
+	var stages []Stage
+	for _, p := range progress {
+		stages = append(stages, p.Stage)
+		assert.Equal(t, result, p.Result, "progress should include the partial result")
+	}
+	assert.Equal(t, []Stage{StageFetch, StageParse, StageRender, StageDone}, stages)
+
+	assert.Zero(t, progress[0].Stats.FetchDuration, "fetch stats should not be set before fetching")
+	assert.NotZero(t, progress[1].Stats.FetchDuration, "fetch stats should be set once fetched")
+	assert.NotZero(t, progress[2].Stats.Stacks, "sample stats should be set once parsed")
+	assert.Equal(t, result.Stats, progress[3].Stats, "final stats should match the result")
+}
+
+func TestStageString(t *testing.T) {
+	assert.Equal(t, "parse", StageParse.String())
+	assert.Equal(t, "Stage(10)", Stage(10).String())
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package torch
+
+import (
+	"time"
+
+	"github.com/uber/go-torch/stack"
+)
+
+// Stats are measurements of a single Generate call, which can be exported
+// to a metrics system.
+type Stats struct {
+	// FetchDuration is the time spent running pprof to fetch the profile,
+	// which includes parsing its raw output as it is written.
+	FetchDuration time.Duration
+	// ParseDuration is the time spent parsing the raw pprof output, not
+	// counting the time waiting for pprof to write it.
+	ParseDuration time.Duration
+	// RenderDuration is the time spent generating the flame graph input
+	// and running the flame graph script.
+	RenderDuration time.Duration
+
+	// RawBytes is the size of the raw pprof output.
+	RawBytes int
+	// Stacks is the number of unique stacks in the profile.
+	Stacks int
+	// SampleName is the name of the rendered sample, e.g. cpu/nanoseconds.
+	SampleName string
+	// SampleTotal is the sum of the rendered sample across all stacks.
+	SampleTotal int64
+}
+
+func (s *Stats) addSamples(profile *stack.Profile, sampleIdx int) {
+	s.Stacks = len(profile.Samples)
+	s.SampleName = profile.SampleNames[sampleIdx]
+	for _, sample := range profile.Samples {
+		s.SampleTotal += sample.Counts[sampleIdx]
+	}
+}
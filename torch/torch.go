@@ -0,0 +1,305 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package torch generates flame graphs from Go profiles. It runs the same
+// pipeline as the go-torch command, for services that embed go-torch.
+package torch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/uber/go-torch/pprof"
+	"github.com/uber/go-torch/renderer"
+	"github.com/uber/go-torch/stack"
+)
+
+var errDiffSamplingError = errors.New("sampling error options cannot be used with a differential flame graph")
+
+// Options are the parameters for Generate.
+type Options struct {
+	PProf pprof.Options
+	// Remaining are arguments passed through to pprof, such as
+	// [binary] <profile source>. See pprof.GetRaw.
+	Remaining []string
+	// Concurrency limits how many profile sources are fetched at once when
+	// merging profiles. If it is 0, all sources are fetched at once.
+	Concurrency int
+	// ExcludeFirst, if set, drops the warm-up period of CPU profiles by
+	// fetching each source for this long, rounded up to whole seconds, and
+	// discarding it before fetching the profile.
+	ExcludeFirst time.Duration
+
+	// FlameGraphArgs are passed to the flame graph script.
+	FlameGraphArgs []string
+	// SkipRender stops after generating the flame graph input, so the
+	// flame graph scripts are not required.
+	SkipRender bool
+
+	// OnWarning is called for each non-fatal problem found in the profile.
+	// It is never called concurrently, even when multiple sources are
+	// fetched and parsed at once.
+	OnWarning stack.WarningFunc
+	// Limits bound the resources used to parse untrusted profiles.
+	Limits pprof.Limits
+	// Focus selects samples by their functions while profiles are parsed,
+	// before they are aggregated. It is not used by FromStacks, as the
+	// profile has already been parsed.
+	Focus stack.FocusFilter
+	// Granularity names frames by their function, package, file or line
+	// while profiles are parsed. It is not used by FromStacks.
+	Granularity stack.Granularity
+	// MissingFunctions is how frames without a function name are named
+	// while profiles are parsed. It is not used by FromStacks.
+	MissingFunctions pprof.MissingFunctions
+	// NoInlines names each location by the function that the others were
+	// inlined into, rather than adding a frame for each inlined function,
+	// while profiles are parsed. It is not used by FromStacks.
+	NoInlines bool
+	// Filter, if set, is applied to each stack before rendering.
+	Filter stack.Filter
+	// Labels, if set, selects the samples with matching labels, such as
+	// those set using pprof.Do. See stack.Labels.Matches.
+	Labels stack.Labels
+	// SplitBy are label keys, such as a thread or pprof label. A root frame
+	// is added for the value of each label, so the flame graph has a
+	// subgraph for each value.
+	SplitBy []string
+	// SamplingError controls whether frames are annotated with, or hidden
+	// based on, their sampling error.
+	SamplingError stack.SamplingErrorOptions
+
+	// OnProgress is called as each stage of Generate starts, so callers can
+	// display progress while waiting for a profile to be captured.
+	OnProgress ProgressFunc
+}
+
+// Result is the output of Generate.
+type Result struct {
+	Profile *stack.Profile
+	// BaseProfile is the profile of opts.PProf.BaseURL2 that Profile is
+	// compared against, if it is set.
+	BaseProfile *stack.Profile
+	// SourceProfiles are the profiles of each source before they were
+	// merged into Profile, if opts.PProf.Merge is set. They are not
+	// filtered or transformed.
+	SourceProfiles []*stack.Profile
+	SampleIndex    int
+	// FlameInput is the collapsed stacks passed to the flame graph script.
+	FlameInput []byte
+	// FlameGraph is the generated SVG, or nil if Options.SkipRender is set.
+	FlameGraph []byte
+	Stats      Stats
+}
+
+// Generate fetches a profile using pprof, and renders it as a flame graph.
+// If opts.PProf.Merge is set, each of opts.Remaining is fetched as a separate
+// profile, and the profiles are merged into one flame graph. If
+// opts.PProf.BaseURL2 is set, it is profiled at the same time, and a
+// differential flame graph is rendered against it.
+func Generate(opts Options) (*Result, error) {
+	return GenerateContext(context.Background(), opts)
+}
+
+// GenerateContext is like Generate, but stops fetching profiles if ctx is
+// cancelled or times out.
+func GenerateContext(ctx context.Context, opts Options) (*Result, error) {
+	result := &Result{}
+	stats := &result.Stats
+
+	opts.OnProgress.report(StageFetch, result)
+	sources, sourceProfiles, err := fetch(ctx, opts, stats)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.OnProgress.report(StageParse, result)
+	profile, base, err := parse(opts, sources, sourceProfiles, stats)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PProf.Merge {
+		result.SourceProfiles = sourceProfiles
+	}
+	if base != nil {
+		err = processDiff(opts, base, profile, result)
+	} else {
+		err = process(opts, profile, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	opts.OnProgress.report(StageDone, result)
+	return result, nil
+}
+
+// FromStacks renders a profile that has already been parsed, such as a perf
+// profile, using the same options as Generate. opts.PProf and opts.Remaining
+// are only used to select the sample to render.
+func FromStacks(profile *stack.Profile, opts Options) (*Result, error) {
+	result := &Result{}
+	if err := process(opts, profile, result); err != nil {
+		return nil, err
+	}
+
+	opts.OnProgress.report(StageDone, result)
+	return result, nil
+}
+
+// process filters and transforms the parsed profile, and renders it.
+func process(opts Options, profile *stack.Profile, result *Result) error {
+	profile, err := transform(opts, profile)
+	if err != nil {
+		return err
+	}
+	result.Profile = profile
+
+	if result.SampleIndex, err = opts.PProf.SampleIndex(opts.Remaining, profile.SampleTypes()); err != nil {
+		return err
+	}
+	result.Stats.addSamples(profile, result.SampleIndex)
+
+	if opts.SamplingError.Enabled() {
+		countIdx := sampleCountIndex(profile.SampleNames, result.SampleIndex)
+		if result.Profile, err = stack.ApplySamplingError(profile, countIdx, opts.SamplingError); err != nil {
+			return fmt.Errorf("could not apply sampling error: %v", err)
+		}
+	}
+
+	opts.OnProgress.report(StageRender, result)
+	start := time.Now()
+	if err := render(opts, result); err != nil {
+		return err
+	}
+	result.Stats.RenderDuration = time.Since(start)
+	return nil
+}
+
+// processDiff filters and transforms the parsed profiles, and renders a
+// differential flame graph of profile against base.
+func processDiff(opts Options, base, profile *stack.Profile, result *Result) error {
+	if opts.SamplingError.Enabled() {
+		return errDiffSamplingError
+	}
+
+	var err error
+	if base, err = transform(opts, base); err != nil {
+		return err
+	}
+	if profile, err = transform(opts, profile); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(base.SampleNames, profile.SampleNames) {
+		return fmt.Errorf("cannot compare profile with sample names %v against profile with sample names %v",
+			profile.SampleNames, base.SampleNames)
+	}
+	result.Profile = profile
+	result.BaseProfile = base
+
+	if result.SampleIndex, err = opts.PProf.SampleIndex(opts.Remaining, profile.SampleTypes()); err != nil {
+		return err
+	}
+	result.Stats.addSamples(profile, result.SampleIndex)
+
+	opts.OnProgress.report(StageRender, result)
+	start := time.Now()
+	result.FlameInput, err = renderer.ToDiffFlameInput(base, profile, result.SampleIndex, renderer.FlameInputOptions{OnWarning: opts.OnWarning})
+	if err != nil {
+		return fmt.Errorf("could not convert stacks to flamegraph input: %v", err)
+	}
+	if err := renderFlameGraph(opts, result); err != nil {
+		return err
+	}
+	result.Stats.RenderDuration = time.Since(start)
+	return nil
+}
+
+// transform applies the filter, label and split options to a profile.
+func transform(opts Options, profile *stack.Profile) (*stack.Profile, error) {
+	var err error
+	if opts.Filter != nil {
+		if profile, err = stack.ApplyFilter(profile, opts.Filter); err != nil {
+			return nil, fmt.Errorf("could not filter stacks: %v", err)
+		}
+	}
+	if len(opts.Labels) > 0 {
+		if profile, err = stack.FilterLabels(profile, opts.Labels); err != nil {
+			return nil, fmt.Errorf("could not filter labels: %v", err)
+		}
+		if len(profile.Samples) == 0 {
+			return nil, fmt.Errorf("no samples match labels %v", opts.Labels)
+		}
+	}
+	if len(opts.SplitBy) > 0 {
+		if profile, err = stack.AddLabelFrames(profile, opts.SplitBy...); err != nil {
+			return nil, fmt.Errorf("could not split stacks by %v: %v", opts.SplitBy, err)
+		}
+	}
+	return profile, nil
+}
+
+// sampleCountIndex returns the index of the number of samples, which is
+// used to estimate sampling error. Profiles without a sample count, such as
+// heap profiles, use the first count of sampled events (e.g. alloc_objects).
+func sampleCountIndex(sampleNames []string, selected int) int {
+	countIdx := -1
+	for i, name := range sampleNames {
+		if name == "samples/count" {
+			return i
+		}
+		if countIdx < 0 && strings.HasSuffix(name, "/count") {
+			countIdx = i
+		}
+	}
+	if countIdx < 0 {
+		return selected
+	}
+	return countIdx
+}
+
+// render fills in the flame graph input and output for result.
+func render(opts Options, result *Result) error {
+	var err error
+	result.FlameInput, err = renderer.ToFlameInputWithOptions(result.Profile, result.SampleIndex, renderer.FlameInputOptions{OnWarning: opts.OnWarning})
+	if err != nil {
+		return fmt.Errorf("could not convert stacks to flamegraph input: %v", err)
+	}
+	return renderFlameGraph(opts, result)
+}
+
+// renderFlameGraph fills in the flame graph for result.FlameInput, unless
+// opts.SkipRender is set.
+func renderFlameGraph(opts Options, result *Result) error {
+	if opts.SkipRender {
+		return nil
+	}
+
+	var err error
+	result.FlameGraph, err = renderer.GenerateFlameGraph(result.FlameInput, opts.FlameGraphArgs...)
+	if err != nil {
+		return fmt.Errorf("could not generate flame graph: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package torch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/go-torch/pprof"
+	"github.com/uber/go-torch/stack"
+)
+
+const testPProfInputFile = "../pprof/testdata/pprof.1.pb.gz"
+
+func TestGenerateSkipRender(t *testing.T) {
+	result, err := Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile},
+		SkipRender: true,
+	})
+	require.NoError(t, err, "Generate failed")
+
+	assert.Contains(t, string(result.FlameInput), "main.fib")
+	assert.Nil(t, result.FlameGraph, "flame graph should not be rendered")
+
+	stats := result.Stats
+	assert.True(t, stats.FetchDuration > 0, "missing fetch duration")
+	assert.True(t, stats.ParseDuration > 0, "missing parse duration")
+	assert.True(t, stats.RenderDuration > 0, "missing render duration")
+	assert.True(t, stats.RawBytes > 0, "missing raw bytes")
+	assert.Equal(t, len(result.Profile.Samples), stats.Stacks)
+	assert.Equal(t, "samples/count", stats.SampleName)
+	assert.True(t, stats.SampleTotal > 0, "missing sample total")
+}
+
+func TestGenerateFilter(t *testing.T) {
+	result, err := Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile},
+		SkipRender: true,
+		Filter:     stack.HideFrames(regexp.MustCompile(`^runtime\.`)),
+	})
+	require.NoError(t, err, "Generate failed")
+
+	assert.Contains(t, string(result.FlameInput), "main.fib")
+	for _, s := range result.Profile.Samples {
+		for _, f := range s.Funcs {
+			assert.False(t, strings.HasPrefix(f, "runtime."), "runtime frame %v should be hidden", f)
+		}
+	}
+}
+
+func TestGenerateFocus(t *testing.T) {
+	result, err := Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile},
+		SkipRender: true,
+		Focus:      stack.FocusFilter{Focus: regexp.MustCompile(`^main\.fib$`)},
+	})
+	require.NoError(t, err, "Generate failed")
+
+	require.NotEmpty(t, result.Profile.Samples, "expected samples with main.fib")
+	for _, s := range result.Profile.Samples {
+		assert.Contains(t, s.Funcs, "main.fib", "sample without main.fib should be dropped")
+	}
+
+	_, err = Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile},
+		SkipRender: true,
+		Focus:      stack.FocusFilter{Ignore: regexp.MustCompile(`.`)},
+	})
+	assert.Equal(t, stack.ErrNoFocusedSamples, err)
+}
+
+func TestGenerateSplitBy(t *testing.T) {
+	result, err := Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile},
+		SkipRender: true,
+		SplitBy:    []string{"thread"},
+	})
+	require.NoError(t, err, "Generate failed")
+
+	// The test profile has no labels.
+	for _, line := range strings.Split(strings.TrimSpace(string(result.FlameInput)), "\n") {
+		assert.True(t, strings.HasPrefix(line, "thread=<none>;"), "missing label frame in %v", line)
+	}
+}
+
+func TestFromStacks(t *testing.T) {
+	profile := &stack.Profile{
+		SampleNames: []string{"samples/count"},
+		Samples: []*stack.Sample{
+			{Funcs: []string{"main", "a"}, Counts: []int64{1}, Labels: stack.Labels{"handler": {"/foo"}}},
+			{Funcs: []string{"main", "b"}, Counts: []int64{2}, Labels: stack.Labels{"handler": {"/bar"}}},
+		},
+	}
+
+	result, err := FromStacks(profile, Options{
+		SkipRender: true,
+		Labels:     stack.Labels{"handler": {"/foo"}},
+		SplitBy:    []string{"handler"},
+	})
+	require.NoError(t, err, "FromStacks failed")
+	assert.Equal(t, "handler=/foo;main;a 1\n", string(result.FlameInput))
+	assert.Equal(t, int64(1), result.Stats.SampleTotal)
+}
+
+func TestGenerateSample(t *testing.T) {
+	result, err := Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile, Sample: "cpu"},
+		SkipRender: true,
+	})
+	require.NoError(t, err, "Generate with a sample name failed")
+	assert.Equal(t, "cpu/nanoseconds", result.Profile.SampleNames[result.SampleIndex])
+
+	_, err = Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile, Sample: "inuse_space"},
+		SkipRender: true,
+	})
+	if assert.Error(t, err, "Generate with an unknown sample should fail") {
+		assert.Contains(t, err.Error(), "available samples are samples/count, cpu/nanoseconds")
+	}
+}
+
+func TestGenerateLabels(t *testing.T) {
+	_, err := Generate(Options{
+		PProf:      pprof.Options{BinaryFile: testPProfInputFile},
+		SkipRender: true,
+		Labels:     stack.Labels{"handler": {"/foo"}},
+	})
+	if assert.Error(t, err, "expected no samples to match labels") {
+		assert.Contains(t, err.Error(), "no samples match labels")
+	}
+}
+
+func TestGenerateSamplingError(t *testing.T) {
+	result, err := Generate(Options{
+		PProf:         pprof.Options{BinaryFile: testPProfInputFile},
+		SkipRender:    true,
+		SamplingError: stack.SamplingErrorOptions{AnnotateBelow: 1000},
+	})
+	require.NoError(t, err, "Generate failed")
+	assert.Contains(t, string(result.FlameInput), " samples, ±")
+}
+
+func TestSampleCountIndex(t *testing.T) {
+	tests := []struct {
+		names    []string
+		selected int
+		want     int
+	}{
+		{[]string{"samples/count", "cpu/nanoseconds"}, 1, 0},
+		{[]string{"alloc_space/bytes", "alloc_objects/count", "inuse_objects/count"}, 0, 1},
+		{[]string{"delay/nanoseconds"}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, sampleCountIndex(tt.names, tt.selected), "sampleCountIndex(%v)", tt.names)
+	}
+}
+
+// withScriptsInPath runs f with a fake flame graph script in the PATH,
+// which prints its arguments followed by its input.
+func withScriptsInPath(t *testing.T, f func()) {
+	dir, err := ioutil.TempDir("", "go-torch-scripts")
+	require.NoError(t, err, "failed to create temporary scripts dir")
+	defer os.RemoveAll(dir)
+
+	const script = "#!/bin/sh\necho \"$@\"\ncat\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "flamegraph.pl"), []byte(script), 0777))
+
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", dir+":"+oldPath)
+
+	f()
+}
+
+func TestGenerateRender(t *testing.T) {
+	withScriptsInPath(t, func() {
+		result, err := Generate(Options{
+			PProf:          pprof.Options{BinaryFile: testPProfInputFile},
+			FlameGraphArgs: []string{"--title", "test"},
+		})
+		require.NoError(t, err, "Generate failed")
+		assert.Contains(t, string(result.FlameGraph), "--title test\n")
+		assert.Contains(t, string(result.FlameGraph), "main.fib")
+	})
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		opts    Options
+		wantErr string
+	}{
+		{
+			opts:    Options{PProf: pprof.Options{BinaryFile: "missing-file"}},
+			wantErr: "could not get raw output from pprof",
+		},
+		{
+			opts:    Options{PProf: pprof.Options{BinaryFile: testPProfInputFile, Heap: true, Block: true}},
+			wantErr: "only one profile type",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := Generate(tt.opts)
+		if assert.Error(t, err, "expected error for %+v", tt.opts) {
+			assert.Contains(t, err.Error(), tt.wantErr)
+		}
+	}
+}
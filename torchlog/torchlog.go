@@ -18,46 +18,140 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
+// Package torchlog logs messages with a level, the current time and color,
+// or as JSON lines for machine consumption.
 package torchlog
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"time"
 
 	"github.com/fatih/color"
 )
 
+// Level is the severity of a message.
+type Level int
+
+// Levels of messages, from the least severe.
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	FatalLevel
+)
+
+var levelNames = map[Level]string{
+	DebugLevel: "DEBUG",
+	InfoLevel:  "INFO",
+	FatalLevel: "FATAL",
+}
+
+var levelColors = map[Level]*color.Color{
+	DebugLevel: color.New(color.Faint),
+	InfoLevel:  color.New(color.FgBlue),
+	FatalLevel: color.New(color.FgRed),
+}
+
+// colorSupported is whether stderr, where messages are logged, can show
+// colors: it is a terminal, and colors have not been disabled using the
+// NO_COLOR or TERM environment variables.
+var colorSupported = os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && isTerminal(os.Stderr)
+
+// logger writes messages to stderr by default, so they are not mixed with
+// output such as --print and --raw that is written to stdout.
+var logger = log.New(os.Stderr, "", 0)
+
 var (
-	redColor  = color.New(color.FgRed)
-	blueColor = color.New(color.FgBlue)
+	minLevel   = InfoLevel
+	jsonOutput = false
+	useColor   = colorSupported
 )
 
 func init() {
-	log.SetFlags(0) // disable default flags
+	// The color package only checks whether stdout is a terminal, but
+	// messages are logged to stderr, so whether to color them is decided
+	// by useColor instead.
+	for _, c := range levelColors {
+		c.EnableColor()
+	}
 }
 
-// getPrefix generates the log prefix in the given color
-func getPrefix(level string, color *color.Color) string {
-	currentTime := time.Now().Format("15:04:05")
-	toColoredString := color.SprintFunc()
-	return toColoredString(fmt.Sprintf("%s[%s] ", level, currentTime))
+// isTerminal returns whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
 }
 
-// Fatalf wraps log.Fatalf and adds the current time and color.
+// SetOutput sets where messages are written.
+func SetOutput(w io.Writer) {
+	logger.SetOutput(w)
+}
+
+// SetLevel sets the least severe level of messages that are logged.
+// Fatal messages are always logged.
+func SetLevel(level Level) {
+	minLevel = level
+}
+
+// SetColor sets whether the levels of text messages are colored. They are
+// never colored if stderr is not a terminal or NO_COLOR is set.
+func SetColor(enabled bool) {
+	useColor = enabled && colorSupported
+}
+
+// SetJSON sets whether messages are logged as JSON objects with level,
+// time and msg fields, one per line, rather than as colored text.
+func SetJSON(enabled bool) {
+	jsonOutput = enabled
+}
+
+// formatLine formats a message at the level as a log line.
+func formatLine(level Level, msg string) string {
+	now := time.Now()
+	if jsonOutput {
+		line, _ := json.Marshal(struct {
+			Level string `json:"level"`
+			Time  string `json:"time"`
+			Msg   string `json:"msg"`
+		}{levelNames[level], now.Format(time.RFC3339), msg})
+		return string(line)
+	}
+	prefix := fmt.Sprintf("%s[%s] ", levelNames[level], now.Format("15:04:05"))
+	if useColor {
+		prefix = levelColors[level].Sprint(prefix)
+	}
+	return prefix + msg
+}
+
+// logf logs a message at the level, if it is at least the minimum level.
+func logf(level Level, msg string) {
+	if level < minLevel {
+		return
+	}
+	logger.Print(formatLine(level, msg))
+}
+
+// Fatalf logs a message at the fatal level and exits.
 func Fatalf(format string, v ...interface{}) {
-	prefix := getPrefix("FATAL", redColor)
-	log.Fatalf(prefix+format, v...)
+	logf(FatalLevel, fmt.Sprintf(format, v...))
+	os.Exit(1)
 }
 
-// Printf wraps log.Printf and adds the current time and color.
+// Printf logs a message at the info level.
 func Printf(format string, v ...interface{}) {
-	prefix := getPrefix("INFO", blueColor)
-	log.Printf(prefix+format, v...)
+	logf(InfoLevel, fmt.Sprintf(format, v...))
 }
 
-// Print wraps log.Print and adds the current time and color.
+// Print logs a message at the info level.
 func Print(v ...interface{}) {
-	prefix := getPrefix("INFO", blueColor)
-	log.Print(prefix + fmt.Sprint(v...))
+	logf(InfoLevel, fmt.Sprint(v...))
+}
+
+// Debugf logs a message at the debug level, which is only shown with
+// --verbose.
+func Debugf(format string, v ...interface{}) {
+	logf(DebugLevel, fmt.Sprintf(format, v...))
 }
@@ -0,0 +1,91 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package torchlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func captureLog(f func()) string {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	SetColor(false)
+	defer SetOutput(os.Stderr)
+	defer SetColor(true)
+	defer SetLevel(InfoLevel)
+	defer SetJSON(false)
+	f()
+	return buf.String()
+}
+
+func TestLevels(t *testing.T) {
+	tests := []struct {
+		level Level
+		want  []string
+	}{
+		{DebugLevel, []string{"DEBUG", "INFO"}},
+		{InfoLevel, []string{"INFO"}},
+		{FatalLevel, nil},
+	}
+	for _, tt := range tests {
+		out := captureLog(func() {
+			SetLevel(tt.level)
+			Debugf("debug %v", 1)
+			Printf("info %v", 2)
+		})
+		var levels []string
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			if line != "" {
+				levels = append(levels, strings.SplitN(line, "[", 2)[0])
+			}
+		}
+		assert.Equal(t, tt.want, levels, "level %v", tt.level)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	out := captureLog(func() {
+		SetJSON(true)
+		Printf("profiling %v", "api")
+	})
+
+	var line map[string]string
+	require.NoError(t, json.Unmarshal([]byte(out), &line), "log line is not JSON: %s", out)
+	assert.Equal(t, "INFO", line["level"])
+	assert.Equal(t, "profiling api", line["msg"])
+	assert.NotEmpty(t, line["time"])
+}
+
+func TestColor(t *testing.T) {
+	defer func(orig bool) { useColor = orig }(useColor)
+
+	useColor = true
+	assert.Contains(t, formatLine(InfoLevel, "msg"), "\x1b[", "colored line")
+	useColor = false
+	assert.NotContains(t, formatLine(InfoLevel, "msg"), "\x1b[", "line without color")
+}
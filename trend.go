@@ -0,0 +1,258 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/uber/go-torch/stack"
+	"github.com/uber/go-torch/torchlog"
+)
+
+// trendTopFuncs is the number of functions that rose and fell the most
+// that are listed in trend reports.
+const trendTopFuncs = 10
+
+// webhookTimeout is how long to wait for the webhook to accept a report.
+var webhookTimeout = 30 * time.Second
+
+// trendReport compares the merged profiles of a target in a period against
+// the previous period. It is posted to the webhook as JSON, with the report
+// as text in the text field, as chat webhooks expect.
+type trendReport struct {
+	Text    string      `json:"text"`
+	Target  string      `json:"target"`
+	From    time.Time   `json:"from"`
+	To      time.Time   `json:"to"`
+	Risers  []trendFunc `json:"risers"`
+	Fallers []trendFunc `json:"fallers"`
+}
+
+// trendFunc is the percent of samples that include a function in the
+// previous period and the reported period.
+type trendFunc struct {
+	Func   string  `json:"func"`
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+}
+
+// validateTrend checks the trend options of the daemon.
+func validateTrend(opts *daemonOptions) error {
+	if opts.Trend == "" {
+		if opts.Webhook != "" {
+			return fmt.Errorf("--webhook requires --trend")
+		}
+		return nil
+	}
+	if opts.Webhook == "" {
+		return fmt.Errorf("--trend requires a --webhook to post reports to")
+	}
+	// Reports are sent at the start of a period, when the previous two
+	// periods must still be stored.
+	if keep := 2 * trendPeriod(opts.Trend); opts.Retention > 0 && opts.Retention < keep {
+		return fmt.Errorf("--retention must be at least %v for %v trends, to keep the previous two periods", keep, opts.Trend)
+	}
+	return nil
+}
+
+// trendPeriod returns the nominal length of a daily or weekly period.
+func trendPeriod(trend string) time.Duration {
+	if trend == "weekly" {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// trendPeriodStart returns the start of the day, or the week starting on
+// Monday, that contains t.
+func trendPeriodStart(trend string, t time.Time) time.Time {
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if trend == "weekly" {
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	}
+	return start
+}
+
+// previousPeriodStart returns the start of the period before the period
+// starting at start.
+func previousPeriodStart(trend string, start time.Time) time.Time {
+	if trend == "weekly" {
+		return start.AddDate(0, 0, -7)
+	}
+	return start.AddDate(0, 0, -1)
+}
+
+// reportTrends posts a trend report of each target once a period has
+// ended, comparing it against the period before it. Errors are logged.
+func (d *daemon) reportTrends() {
+	trend := d.opts.daemon.Trend
+	start := trendPeriodStart(trend, d.now())
+	if !start.After(d.trendStart) {
+		return
+	}
+	d.trendStart = start
+	to := start
+	from := previousPeriodStart(trend, to)
+	before := previousPeriodStart(trend, from)
+
+	for _, t := range d.targets {
+		report, err := d.trend(t, before, from, to)
+		if err != nil {
+			torchlog.Printf("Failed to compare the profiles of %v: %v", t.Name, err)
+			continue
+		}
+		if report == nil {
+			torchlog.Printf("Not reporting the trend of %v, as it has no profiles for the previous two periods", t.Name)
+			continue
+		}
+		if err := postWebhook(d.opts.daemon.Webhook, report); err != nil {
+			torchlog.Printf("Failed to post the trend of %v: %v", t.Name, err)
+			continue
+		}
+		torchlog.Printf("Posted the trend of %v from %v to %v", t.Name, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	}
+}
+
+// trend returns the trend report of the target for the period [from, to)
+// against [before, from), or nil if either period has no profiles.
+func (d *daemon) trend(t daemonTarget, before, from, to time.Time) (*trendReport, error) {
+	runs, err := d.runs(t)
+	if err != nil {
+		return nil, err
+	}
+	base, _, err := d.mergeRuns(t, runs, before, from)
+	if err != nil || base == nil {
+		return nil, err
+	}
+	current, sampleIdx, err := d.mergeRuns(t, runs, from, to)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	report := newTrendReport(t.Name, from, to, base, current, sampleIdx)
+	return &report, nil
+}
+
+// mergeRuns merges the stored profiles of the target taken in [from, to),
+// and returns the merged profile and its sample index, or nil if there are
+// no profiles. The profiles are copied from the store to a temporary
+// directory for pprof to read.
+func (d *daemon) mergeRuns(t daemonTarget, runs []daemonRun, from, to time.Time) (*stack.Profile, int, error) {
+	dir, err := ioutil.TempDir("", "go-torch-trend")
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []string
+	for _, r := range runs {
+		if r.Time.Before(from) || !r.Time.Before(to) {
+			continue
+		}
+		for _, f := range r.Files {
+			if filepath.Ext(f) != ".profile" {
+				continue
+			}
+			data, err := d.store.Get(t.Name, f)
+			if err != nil {
+				return nil, 0, fmt.Errorf("could not read %v: %v", f, err)
+			}
+			file := filepath.Join(dir, f)
+			if err := ioutil.WriteFile(file, data, 0666); err != nil {
+				return nil, 0, err
+			}
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return nil, 0, nil
+	}
+
+	runOpts := *d.opts
+	runOpts.PProfOptions.Merge = true
+	runOpts.OutputOpts.Raw = true
+	ctx, cancel := newContext(d.opts.Timeout)
+	defer cancel()
+	result, err := generateResult(ctx, &runOpts, files)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result.Profile, result.SampleIndex, nil
+}
+
+// newTrendReport compares the fraction of samples that include each function
+// in the current profile against the base profile.
+func newTrendReport(target string, from, to time.Time, base, current *stack.Profile, sampleIdx int) trendReport {
+	report := trendReport{Target: target, From: from, To: to}
+	for _, d := range stack.Drift(base, current, sampleIdx, 0) {
+		f := trendFunc{Func: d.Func, Before: d.Base * 100, After: d.Current * 100}
+		switch {
+		case d.Change() > 0 && len(report.Risers) < trendTopFuncs:
+			report.Risers = append(report.Risers, f)
+		case d.Change() < 0 && len(report.Fallers) < trendTopFuncs:
+			report.Fallers = append(report.Fallers, f)
+		}
+	}
+
+	var text bytes.Buffer
+	fmt.Fprintf(&text, "Trend of %v from %v to %v, against the previous period:\n",
+		target, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	writeTrendFuncs(&text, "Top risers", report.Risers)
+	writeTrendFuncs(&text, "Top fallers", report.Fallers)
+	report.Text = text.String()
+	return report
+}
+
+func writeTrendFuncs(w *bytes.Buffer, heading string, funcs []trendFunc) {
+	if len(funcs) == 0 {
+		fmt.Fprintf(w, "%v: none\n", heading)
+		return
+	}
+	fmt.Fprintf(w, "%v:\n", heading)
+	for _, f := range funcs {
+		fmt.Fprintf(w, "  %+6.1f%%  %v (%.1f%% -> %.1f%%)\n", f.After-f.Before, f.Func, f.Before, f.After)
+	}
+}
+
+// postWebhook posts the report to the webhook as JSON.
+func postWebhook(webhook string, report *trendReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("webhook returned %v: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber/go-torch/stack"
+)
+
+func TestTrendPeriodStart(t *testing.T) {
+	tests := []struct {
+		trend string
+		t     time.Time
+		want  time.Time
+	}{
+		{"daily", time.Date(2017, 10, 11, 15, 4, 5, 0, time.UTC), time.Date(2017, 10, 11, 0, 0, 0, 0, time.UTC)},
+		{"weekly", time.Date(2017, 10, 11, 15, 4, 5, 0, time.UTC), time.Date(2017, 10, 9, 0, 0, 0, 0, time.UTC)},
+		{"weekly", time.Date(2017, 10, 15, 23, 0, 0, 0, time.UTC), time.Date(2017, 10, 9, 0, 0, 0, 0, time.UTC)},
+		{"weekly", time.Date(2017, 10, 9, 0, 0, 0, 0, time.UTC), time.Date(2017, 10, 9, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := trendPeriodStart(tt.trend, tt.t); !got.Equal(tt.want) {
+			t.Errorf("trendPeriodStart(%v, %v) = %v, want %v", tt.trend, tt.t, got, tt.want)
+		}
+	}
+}
+
+func newTrendProfile(t *testing.T, counts map[string]int64) *stack.Profile {
+	p, err := stack.NewProfile([]string{"samples"})
+	if err != nil {
+		t.Fatalf("NewProfile failed: %v", err)
+	}
+	for f, count := range counts {
+		p.Samples = append(p.Samples, stack.NewSample([]string{"main.main", f}, []int64{count}))
+	}
+	return p
+}
+
+func TestNewTrendReport(t *testing.T) {
+	base := newTrendProfile(t, map[string]int64{"main.parse": 50, "main.encode": 50})
+	current := newTrendProfile(t, map[string]int64{"main.parse": 20, "main.encode": 50, "main.compress": 30})
+	from := time.Date(2017, 10, 9, 0, 0, 0, 0, time.UTC)
+
+	report := newTrendReport("api", from, from.AddDate(0, 0, 1), base, current, 0)
+	wantRisers := []trendFunc{{"main.compress", 0, 30}}
+	wantFallers := []trendFunc{{"main.parse", 50, 20}}
+	if len(report.Risers) != 1 || report.Risers[0] != wantRisers[0] {
+		t.Errorf("Risers = %v, want %v", report.Risers, wantRisers)
+	}
+	if len(report.Fallers) != 1 || report.Fallers[0] != wantFallers[0] {
+		t.Errorf("Fallers = %v, want %v", report.Fallers, wantFallers)
+	}
+	for _, want := range []string{
+		"Trend of api from 2017-10-09 to 2017-10-10",
+		"Top risers:\n   +30.0%  main.compress (0.0% -> 30.0%)",
+		"Top fallers:\n   -30.0%  main.parse (50.0% -> 20.0%)",
+	} {
+		if !strings.Contains(report.Text, want) {
+			t.Errorf("report is missing %q:\n%s", want, report.Text)
+		}
+	}
+}
+
+func TestDaemonReportTrends(t *testing.T) {
+	var reports []trendReport
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report trendReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Errorf("webhook got invalid JSON: %v", err)
+		}
+		reports = append(reports, report)
+	}))
+	defer webhook.Close()
+
+	now := time.Date(2017, 10, 10, 0, 5, 0, 0, time.Local)
+	targets := []daemonTarget{{Name: "api", URL: "http://api:8080"}, {Name: "worker", URL: "http://worker:8080"}}
+	d, dir := newTestDaemon(t, targets, now)
+	defer os.RemoveAll(dir)
+	d.opts.daemon.Trend = "daily"
+	d.opts.daemon.Webhook = webhook.URL
+	d.trendStart = time.Date(2017, 10, 9, 0, 0, 0, 0, time.Local)
+
+	profile, err := ioutil.ReadFile(testPProfInputFile)
+	if err != nil {
+		t.Fatalf("Failed to read test profile: %v", err)
+	}
+	for _, f := range []string{"20171008-120000.000.profile", "20171009-120000.000.profile", "20171009-130000.000.profile"} {
+		if err := d.store.Put("api", f, profile); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	withScriptsInPath(t, func() {
+		d.reportTrends()
+		// The report for a period is only sent once.
+		d.reportTrends()
+	})
+	if len(reports) != 1 {
+		t.Fatalf("webhook got %v reports, want 1 for api: %+v", len(reports), reports)
+	}
+	report := reports[0]
+	if report.Target != "api" || !report.From.Equal(d.trendStart.AddDate(0, 0, -1)) || !report.To.Equal(d.trendStart) {
+		t.Errorf("report is for %v from %v to %v, want api from 2017-10-09 to 2017-10-10", report.Target, report.From, report.To)
+	}
+	// Both periods have the same profile.
+	if len(report.Risers) != 0 || len(report.Fallers) != 0 {
+		t.Errorf("report has changes between the same profiles: %+v", report)
+	}
+}
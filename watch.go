@@ -0,0 +1,72 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/uber/go-torch/torchlog"
+)
+
+// watchTimestampFormat is added to output file names with --watch-timestamp.
+const watchTimestampFormat = "20060102-150405.000"
+
+// runWatch generates a flame graph every opts.Watch until a value is received
+// on stop. Errors are logged rather than returned, so a single failed profile
+// does not stop the watch.
+func runWatch(opts *options, remaining []string, stop <-chan os.Signal) {
+	torchlog.Printf("Watching, generating a flame graph every %v until interrupted", opts.Watch)
+
+	ticker := time.NewTicker(opts.Watch)
+	defer ticker.Stop()
+
+	for {
+		runOpts := *opts
+		if opts.WatchStamp {
+			now := time.Now()
+			runOpts.OutputOpts.File = addTimestamp(opts.OutputOpts.File, now)
+			runOpts.OutputOpts.RawFile = addTimestamp(opts.OutputOpts.RawFile, now)
+		}
+		if err := runWithOptions(&runOpts, remaining); err != nil {
+			torchlog.Printf("Failed: %v", err)
+		}
+
+		select {
+		case <-stop:
+			torchlog.Print("Stopped watching")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// addTimestamp adds the time to a file name before its extension,
+// e.g. torch.svg becomes torch-20171010-101010.000.svg.
+func addTimestamp(file string, t time.Time) string {
+	if file == "" {
+		return ""
+	}
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "-" + t.Format(watchTimestampFormat) + ext
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddTimestamp(t *testing.T) {
+	ts := time.Date(2017, 10, 10, 8, 30, 15, 123000000, time.UTC)
+	tests := []struct {
+		file     string
+		expected string
+	}{
+		{"", ""},
+		{"torch.svg", "torch-20171010-083015.123.svg"},
+		{"/tmp/out/stacks.folded", "/tmp/out/stacks-20171010-083015.123.folded"},
+		{"noext", "noext-20171010-083015.123"},
+	}
+
+	for _, tt := range tests {
+		if got := addTimestamp(tt.file, ts); got != tt.expected {
+			t.Errorf("addTimestamp(%v) got %v, want %v", tt.file, got, tt.expected)
+		}
+	}
+}
+
+func TestRunWatch(t *testing.T) {
+	dir := filepath.Dir(getTempFilename(t, ""))
+	opts := getDefaultOptions()
+	opts.Watch = 10 * time.Millisecond
+	opts.WatchStamp = true
+	opts.OutputOpts.RawFile = filepath.Join(dir, "go-torch-watch-test.folded")
+
+	stop := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		runWatch(opts, nil, stop)
+		close(done)
+	}()
+
+	// Wait for at least two runs before stopping.
+	pattern := filepath.Join(dir, "go-torch-watch-test-*.folded")
+	deadline := time.Now().Add(30 * time.Second)
+	var files []string
+	for len(files) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		files, _ = filepath.Glob(pattern)
+	}
+	stop <- os.Interrupt
+	<-done
+
+	files, _ = filepath.Glob(pattern)
+	for _, f := range files {
+		os.Remove(f)
+	}
+	if len(files) < 2 {
+		t.Errorf("Expected at least 2 timestamped files, got %v", files)
+	}
+}